@@ -0,0 +1,379 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+// Package admin serves a small web UI to browse, edit and remove the entries
+// of a provider. It has no authentication of its own: mount it behind the
+// middleware that protects the rest of your internal endpoints.
+package admin
+
+import (
+	"cmp"
+	_ "embed"
+	"encoding/json"
+	baseErrors "errors"
+	"fmt"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/errors"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type Config struct {
+	Title    string `yaml:"title,omitempty"`
+	ReadOnly bool   `yaml:"read_only,omitempty"`
+	PageSize int    `yaml:"page_size,omitempty"`
+	// MaxValueSize limits the body of an edit. The default is 1 MiB.
+	MaxValueSize int64 `yaml:"max_value_size,omitempty"`
+	// Values are shown after storage.Redact unless Unredacted is set.
+	Unredacted bool `yaml:"unredacted,omitempty"`
+}
+
+const (
+	defaultTitle        = "storage"
+	defaultPageSize     = 50
+	maxPageSize         = 1000
+	defaultMaxValueSize = 1 << 20
+)
+
+//go:embed index.html
+var index string
+
+var page = template.Must(template.New("index").Parse(index))
+
+type Entry[K ~string | ~uint64, V any] struct {
+	Key   K `json:"key"`
+	Value V `json:"value"`
+}
+
+type Page[K ~string | ~uint64, V any] struct {
+	Entries []Entry[K, V] `json:"entries"`
+	Next    string        `json:"next,omitempty"`
+}
+
+type Detail[K ~string | ~uint64, V any] struct {
+	Key        K   `json:"key"`
+	Value      V   `json:"value"`
+	References []K `json:"references"`
+	// Redacted is set when Value was redacted. Saving it would overwrite the
+	// redacted fields, so the UI does not allow editing it.
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+type Reference[K ~string | ~uint64] struct {
+	Reference K `json:"reference"`
+	Key       K `json:"key"`
+}
+
+type handler[K ~string | ~uint64, V any] struct {
+	provider storage.KeyValueProvider[K, V]
+	cfg      Config
+	mux      *http.ServeMux
+}
+
+// Handler returns the UI and its JSON API for provider. Mount it under a
+// path that ends with a slash, e.g.
+//
+//	mux.Handle("/admin/", http.StripPrefix("/admin", admin.Handler(db, admin.Config{})))
+//
+// Values are shown and edited as JSON, after storage.Redact unless
+// Config.Unredacted is set; redacted values can not be edited.
+func Handler[K ~string | ~uint64, V any](provider storage.KeyValueProvider[K, V], cfg Config) http.Handler {
+	if cfg.Title == "" {
+		cfg.Title = defaultTitle
+	}
+	if cfg.PageSize <= 0 {
+		cfg.PageSize = defaultPageSize
+	}
+	if cfg.MaxValueSize <= 0 {
+		cfg.MaxValueSize = defaultMaxValueSize
+	}
+
+	h := &handler[K, V]{provider: provider, cfg: cfg, mux: http.NewServeMux()}
+	h.mux.HandleFunc("GET /{$}", h.index)
+	h.mux.HandleFunc("GET /api/entries", h.entries)
+	h.mux.HandleFunc("GET /api/entry", h.entry)
+	h.mux.HandleFunc("PUT /api/entry", h.store)
+	h.mux.HandleFunc("DELETE /api/entry", h.remove)
+	h.mux.HandleFunc("GET /api/references", h.references)
+
+	return h
+}
+
+func (h *handler[K, V]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *handler[K, V]) index(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	_ = page.Execute(w, h.cfg)
+}
+
+// entries returns a page of entries whose key starts with the prefix query
+// parameter, in key order, after the key in the cursor parameter.
+func (h *handler[K, V]) entries(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := h.cfg.PageSize
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, baseErrors.New("limit must be a positive number"))
+			return
+		}
+		limit = min(n, maxPageSize)
+	}
+
+	keys, err := h.keys(query.Get("prefix"))
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		after, err := parseKey[K](cursor)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		i, found := slices.BinarySearch(keys, after)
+		if found {
+			i++
+		}
+		keys = keys[i:]
+	}
+
+	result := Page[K, V]{Entries: []Entry[K, V]{}}
+	if len(keys) > limit {
+		keys = keys[:limit]
+		result.Next = keyString(keys[limit-1])
+	}
+	for _, key := range keys {
+		value, err := h.provider.Get(key)
+		if errors.Is(err, errors.NotFound) {
+			continue
+		}
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		shown, _ := h.redact(value)
+		result.Entries = append(result.Entries, Entry[K, V]{Key: key, Value: shown})
+	}
+
+	writeJSON(w, result)
+}
+
+func (h *handler[K, V]) keys(prefix string) ([]K, error) {
+	var keys []K
+	collect := func(key K) bool {
+		if strings.HasPrefix(keyString(key), prefix) {
+			keys = append(keys, key)
+		}
+		return true
+	}
+
+	// uint64 keys are filtered by their decimal form, which providers with
+	// binary keys can not seek to.
+	var err error
+	if prefix != "" && reflect.TypeFor[K]().Kind() == reflect.String {
+		pr, _ := parseKey[K](prefix)
+		err = h.provider.ForEachPrefix(pr, func(key K, _ V) bool { return collect(key) })
+	} else {
+		err = h.provider.ForEachKey(collect)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(keys, cmp.Compare[K])
+	return slices.Compact(keys), nil
+}
+
+func (h *handler[K, V]) entry(w http.ResponseWriter, r *http.Request) {
+	key, ok := h.key(w, r)
+	if !ok {
+		return
+	}
+
+	value, err := h.provider.Get(key)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	detail := Detail[K, V]{Key: key, References: []K{}}
+	detail.Value, detail.Redacted = h.redact(value)
+	err = h.provider.ForEachReference(func(reference K, target K) bool {
+		if target == key {
+			detail.References = append(detail.References, reference)
+		}
+		return true
+	})
+	if err != nil && !errors.Is(err, errors.Unsupported) {
+		writeStorageError(w, err)
+		return
+	}
+	slices.SortFunc(detail.References, cmp.Compare[K])
+
+	writeJSON(w, detail)
+}
+
+func (h *handler[K, V]) store(w http.ResponseWriter, r *http.Request) {
+	if !h.writable(w) {
+		return
+	}
+	key, ok := h.key(w, r)
+	if !ok {
+		return
+	}
+	// Browsers only send a JSON body cross-origin after a preflight, so this
+	// also keeps other sites from editing entries.
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, baseErrors.New("content type must be application/json"))
+		return
+	}
+
+	var value V
+	decoder := json.NewDecoder(io.LimitReader(r.Body, h.cfg.MaxValueSize))
+	if err := decoder.Decode(&value); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid value: %w", err))
+		return
+	}
+	if err := h.provider.Store(key, value); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler[K, V]) remove(w http.ResponseWriter, r *http.Request) {
+	if !h.writable(w) {
+		return
+	}
+	key, ok := h.key(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.provider.Remove(key); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// references returns the references whose name starts with the prefix query
+// parameter, sorted by name.
+func (h *handler[K, V]) references(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	references := []Reference[K]{}
+	err := h.provider.ForEachReference(func(reference K, key K) bool {
+		if strings.HasPrefix(keyString(reference), prefix) {
+			references = append(references, Reference[K]{Reference: reference, Key: key})
+		}
+		return len(references) < maxPageSize
+	})
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	slices.SortFunc(references, func(a, b Reference[K]) int {
+		return cmp.Or(cmp.Compare(a.Reference, b.Reference), cmp.Compare(a.Key, b.Key))
+	})
+	writeJSON(w, references)
+}
+
+// redact returns value as it is shown and whether redaction changed it.
+func (h *handler[K, V]) redact(value V) (V, bool) {
+	if h.cfg.Unredacted {
+		return value, false
+	}
+
+	shown := storage.Redact(value)
+	return shown, !reflect.DeepEqual(shown, value)
+}
+
+func (h *handler[K, V]) key(w http.ResponseWriter, r *http.Request) (K, bool) {
+	s := r.URL.Query().Get("key")
+	key, err := parseKey[K](s)
+	if err != nil || s == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid key %q", s))
+		return key, false
+	}
+
+	return key, true
+}
+
+func (h *handler[K, V]) writable(w http.ResponseWriter) bool {
+	if h.cfg.ReadOnly {
+		writeError(w, http.StatusForbidden, errors.ReadOnly)
+		return false
+	}
+
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+func writeStorageError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errors.NotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, errors.ReadOnly), errors.Is(err, errors.Immutable):
+		status = http.StatusForbidden
+	case errors.Is(err, errors.Unsupported):
+		status = http.StatusNotImplemented
+	case errors.Is(err, errors.Unavailable), errors.Is(err, errors.Closed):
+		status = http.StatusServiceUnavailable
+	}
+
+	writeError(w, status, err)
+}
+
+func keyString[K ~string | ~uint64](key K) string {
+	v := reflect.ValueOf(key)
+	if v.Kind() == reflect.Uint64 {
+		return strconv.FormatUint(v.Uint(), 10)
+	}
+
+	return v.String()
+}
+
+func parseKey[K ~string | ~uint64](s string) (K, error) {
+	var key K
+	v := reflect.ValueOf(&key).Elem()
+	if v.Kind() != reflect.Uint64 {
+		v.SetString(s)
+		return key, nil
+	}
+
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return key, fmt.Errorf("invalid key %q: expected a number", s)
+	}
+	v.SetUint(n)
+	return key, nil
+}
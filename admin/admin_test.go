@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type user struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func newProvider[K ~string | ~uint64, V any](t *testing.T) storage.KeyValueProvider[K, V] {
+	p, err := storage.GetKeyValueProviderFromConfig[K, V](storage.KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	t.Cleanup(func() {
+		require.NoError(t, p.Shutdown())
+	})
+
+	return p
+}
+
+func do(t *testing.T, h http.Handler, method, target string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func decode[T any](t *testing.T, rec *httptest.ResponseRecorder) T {
+	var v T
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&v), rec.Body.String())
+	return v
+}
+
+func TestHandler(t *testing.T) {
+	p := newProvider[string, user](t)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, p.Store(fmt.Sprintf("user:%d", i), user{Name: fmt.Sprint("user", i), Age: 20 + i}))
+	}
+	require.NoError(t, p.Store("order:1", user{}))
+	require.NoError(t, p.StoreReference("email:alice", "user:1"))
+
+	mux := http.NewServeMux()
+	mux.Handle("/admin/", http.StripPrefix("/admin", Handler(p, Config{Title: "staging", PageSize: 3})))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/admin/")
+	require.NoError(t, err)
+	html, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(html), "<title>staging</title>")
+
+	rec := do(t, mux, "GET", "/admin/api/entries?prefix=user:", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	page := decode[Page[string, user]](t, rec)
+	require.Len(t, page.Entries, 3)
+	assert.Equal(t, "user:0", page.Entries[0].Key)
+	assert.Equal(t, "user:2", page.Next)
+
+	page = decode[Page[string, user]](t, do(t, mux, "GET", "/admin/api/entries?prefix=user:&cursor="+page.Next, ""))
+	require.Len(t, page.Entries, 2)
+	assert.Equal(t, user{Name: "user4", Age: 24}, page.Entries[1].Value)
+	assert.Empty(t, page.Next)
+
+	detail := decode[Detail[string, user]](t, do(t, mux, "GET", "/admin/api/entry?key=user:1", ""))
+	assert.Equal(t, "user1", detail.Value.Name)
+	assert.Equal(t, []string{"email:alice"}, detail.References)
+
+	references := decode[[]Reference[string]](t, do(t, mux, "GET", "/admin/api/references?prefix=email:", ""))
+	assert.Equal(t, []Reference[string]{{Reference: "email:alice", Key: "user:1"}}, references)
+
+	assert.Equal(t, http.StatusNoContent, do(t, mux, "PUT", "/admin/api/entry?key=user:1", `{"name":"alice","age":30}`).Code)
+	u, err := p.Get("user:1")
+	require.NoError(t, err)
+	assert.Equal(t, user{Name: "alice", Age: 30}, u)
+
+	assert.Equal(t, http.StatusBadRequest, do(t, mux, "PUT", "/admin/api/entry?key=user:1", `{"name":`).Code)
+	req := httptest.NewRequest("PUT", "/admin/api/entry?key=user:1", strings.NewReader(`{}`))
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+
+	assert.Equal(t, http.StatusNoContent, do(t, mux, "DELETE", "/admin/api/entry?key=user:1", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(t, mux, "GET", "/admin/api/entry?key=user:1", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(t, mux, "GET", "/admin/api/entry", "").Code)
+}
+
+func TestHandler_ReadOnlyNumericKeys(t *testing.T) {
+	p := newProvider[uint64, int](t)
+	for _, id := range []uint64{2, 10, 1, 100} {
+		require.NoError(t, p.Store(id, int(id)))
+	}
+	h := Handler(p, Config{ReadOnly: true})
+
+	page := decode[Page[uint64, int]](t, do(t, h, "GET", "/api/entries?prefix=1", ""))
+	var keys []uint64
+	for _, e := range page.Entries {
+		keys = append(keys, e.Key)
+	}
+	assert.Equal(t, []uint64{1, 10, 100}, keys)
+
+	assert.Equal(t, http.StatusBadRequest, do(t, h, "GET", "/api/entry?key=abc", "").Code)
+	assert.Equal(t, http.StatusForbidden, do(t, h, "PUT", "/api/entry?key=1", "5").Code)
+	assert.Equal(t, http.StatusForbidden, do(t, h, "DELETE", "/api/entry?key=1", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(t, h, "GET", "/missing", "").Code)
+}
+
+type customer struct {
+	Name  string `json:"name"`
+	Email string `json:"email" storage:"redact"`
+}
+
+func TestHandler_Redacted(t *testing.T) {
+	p := newProvider[string, customer](t)
+	require.NoError(t, p.Store("customer:1", customer{Name: "ann", Email: "ann@example.com"}))
+
+	h := Handler(p, Config{})
+	page := decode[Page[string, customer]](t, do(t, h, "GET", "/api/entries", ""))
+	require.Len(t, page.Entries, 1)
+	assert.Equal(t, customer{Name: "ann", Email: storage.RedactedText}, page.Entries[0].Value)
+
+	detail := decode[Detail[string, customer]](t, do(t, h, "GET", "/api/entry?key=customer:1", ""))
+	assert.Equal(t, storage.RedactedText, detail.Value.Email)
+	assert.True(t, detail.Redacted)
+
+	detail = decode[Detail[string, customer]](t, do(t, Handler(p, Config{Unredacted: true}), "GET", "/api/entry?key=customer:1", ""))
+	assert.Equal(t, "ann@example.com", detail.Value.Email)
+	assert.False(t, detail.Redacted)
+}
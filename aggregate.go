@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"errors"
+	"runtime"
+)
+
+// Reduce folds every entry into an accumulator without collecting the
+// entries. Entries are folded in parallel into up to workers accumulators
+// created by init, which are then combined with merge.
+func Reduce[K ~string | ~uint64, V any, A any](provider KeyValueProvider[K, V], workers int, init func() A, fold func(acc A, key K, value V) A, merge func(a, b A) A) (A, error) {
+	var zero A
+	if workers <= 0 {
+		return zero, errors.New("workers must be positive")
+	}
+
+	accumulators := make(chan A, workers)
+	for i := 0; i < workers; i++ {
+		accumulators <- init()
+	}
+
+	err := ForEachParallel(provider, workers, func(key K, value V) error {
+		acc := <-accumulators
+		accumulators <- fold(acc, key, value)
+		return nil
+	})
+	if err != nil {
+		return zero, err
+	}
+
+	close(accumulators)
+	result := <-accumulators
+	for acc := range accumulators {
+		result = merge(result, acc)
+	}
+
+	return result, nil
+}
+
+// Count returns how many entries match pred.
+func Count[K ~string | ~uint64, V any](provider KeyValueProvider[K, V], pred func(key K, value V) bool) (int, error) {
+	return Reduce(provider, runtime.GOMAXPROCS(0),
+		func() int {
+			return 0
+		},
+		func(n int, key K, value V) int {
+			if pred(key, value) {
+				n++
+			}
+			return n
+		},
+		func(a, b int) int {
+			return a + b
+		},
+	)
+}
+
+// GroupBy counts the entries per group that extract returns for them.
+func GroupBy[K ~string | ~uint64, V any, G comparable](provider KeyValueProvider[K, V], extract func(key K, value V) G) (map[G]int, error) {
+	return Reduce(provider, runtime.GOMAXPROCS(0),
+		func() map[G]int {
+			return map[G]int{}
+		},
+		func(groups map[G]int, key K, value V) map[G]int {
+			groups[extract(key, value)]++
+			return groups
+		},
+		func(a, b map[G]int) map[G]int {
+			for group, n := range b {
+				a[group] += n
+			}
+			return a
+		},
+	)
+}
@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package azureblob
+
+import (
+	"errors"
+	"fmt"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage/kv"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	ConnectionString        string `yaml:"connection_string,omitempty"`
+	AccountURL              string `yaml:"account_url,omitempty"`
+	ManagedIdentityClientID string `yaml:"managed_identity_client_id,omitempty"`
+
+	Container       string                  `yaml:"container"`
+	Prefix          string                  `yaml:"prefix,omitempty"`
+	CreateContainer nullable.Nullable[bool] `yaml:"create_container"`
+	ReadOnly        bool                    `yaml:"read_only,omitempty"`
+
+	TLS nullable.Nullable[kv.TLSConfig] `yaml:"tls"`
+
+	ConflictRetries    int           `yaml:"conflict_retries,omitempty"`
+	MaxReferenceDepth  int           `yaml:"max_reference_depth,omitempty"`
+	TransactionTimeout time.Duration `yaml:"transaction_timeout,omitempty"`
+}
+
+func (c Config) Validate() error {
+	var errs []error
+	switch {
+	case c.ConnectionString == "" && c.AccountURL == "":
+		errs = append(errs, errors.New("connection_string or account_url is required"))
+	case c.ConnectionString != "" && c.AccountURL != "":
+		errs = append(errs, errors.New("connection_string and account_url are mutually exclusive"))
+	case c.AccountURL != "":
+		if u, err := url.Parse(c.AccountURL); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid account_url %q", c.AccountURL))
+		}
+	}
+	if c.ManagedIdentityClientID != "" && c.AccountURL == "" {
+		errs = append(errs, errors.New("managed_identity_client_id requires account_url"))
+	}
+	if c.Container == "" {
+		errs = append(errs, errors.New("container is required"))
+	}
+	if c.Prefix != "" && !strings.HasSuffix(c.Prefix, "/") {
+		errs = append(errs, errors.New("prefix must end with /"))
+	}
+	if c.TLS.HasValue() {
+		if err := c.TLS.GetValue().Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.ConflictRetries < 0 {
+		errs = append(errs, errors.New("conflict_retries must not be negative"))
+	}
+	if c.MaxReferenceDepth < 0 {
+		errs = append(errs, errors.New("max_reference_depth must not be negative"))
+	}
+	if c.TransactionTimeout < 0 {
+		errs = append(errs, errors.New("transaction_timeout must not be negative"))
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package azureblob
+
+import (
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage/kv"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	connection := "UseDevelopmentStorage=true"
+	account := "https://account.blob.core.windows.net"
+	valid := map[string]Config{
+		"connection string": {ConnectionString: connection, Container: "kv"},
+		"account":           {AccountURL: account, Container: "kv"},
+		"full": {
+			AccountURL:              account,
+			ManagedIdentityClientID: "00000000-0000-0000-0000-000000000000",
+			Container:               "kv",
+			Prefix:                  "app/",
+			ConflictRetries:         3,
+			MaxReferenceDepth:       4,
+			TransactionTimeout:      time.Second,
+		},
+	}
+	for name, cfg := range valid {
+		assert.NoError(t, cfg.Validate(), name)
+	}
+
+	invalid := map[string]Config{
+		"no credentials":       {Container: "kv"},
+		"both credentials":     {ConnectionString: connection, AccountURL: account, Container: "kv"},
+		"account url":          {AccountURL: "account.blob.core.windows.net", Container: "kv"},
+		"managed identity":     {ConnectionString: connection, ManagedIdentityClientID: "id", Container: "kv"},
+		"no container":         {ConnectionString: connection},
+		"prefix":               {ConnectionString: connection, Container: "kv", Prefix: "app"},
+		"tls":                  {ConnectionString: connection, Container: "kv", TLS: nullable.FromValue(kv.TLSConfig{KeyFile: "key.pem"})},
+		"negative retries":     {ConnectionString: connection, Container: "kv", ConflictRetries: -1},
+		"negative depth":       {ConnectionString: connection, Container: "kv", MaxReferenceDepth: -1},
+		"negative transaction": {ConnectionString: connection, Container: "kv", TransactionTimeout: -time.Second},
+	}
+	for name, cfg := range invalid {
+		assert.Error(t, cfg.Validate(), name)
+	}
+}
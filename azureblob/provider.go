@@ -0,0 +1,866 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !(js && wasm) && !noazureblob
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"github.com/rlshukhov/storage/kv"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	dataPrefix      = "data/"
+	referencePrefix = "references/"
+	maxBlobName     = 1024
+
+	defaultConflictRetries   = 10
+	defaultMaxReferenceDepth = 8
+)
+
+type provider[K ~string | ~uint64, V any] struct {
+	cfg       Config
+	lastWrite atomic.Int64
+
+	mu     sync.RWMutex
+	client *container.Client
+}
+
+func New[K ~string | ~uint64, V any](cfg Config) (*provider[K, V], error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.ConflictRetries == 0 {
+		cfg.ConflictRetries = defaultConflictRetries
+	}
+	if cfg.MaxReferenceDepth == 0 {
+		cfg.MaxReferenceDepth = defaultMaxReferenceDepth
+	}
+
+	return &provider[K, V]{cfg: cfg}, nil
+}
+
+func (p *provider[K, V]) Setup() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.client != nil {
+		return nil
+	}
+
+	client, err := p.newClient()
+	if err != nil {
+		return err
+	}
+
+	if p.cfg.CreateContainer.OrElse(true) && !p.cfg.ReadOnly {
+		ctx, cancel := p.context()
+		defer cancel()
+
+		if _, err := client.Create(ctx, nil); err != nil && !bloberror.HasCode(err, bloberror.ContainerAlreadyExists) {
+			return mapError(err)
+		}
+	}
+
+	p.client = client
+	return nil
+}
+
+func (p *provider[K, V]) newClient() (*container.Client, error) {
+	var opts *container.ClientOptions
+	if p.cfg.TLS.HasValue() {
+		config, err := p.cfg.TLS.GetValue().Client()
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = config
+		opts = &container.ClientOptions{ClientOptions: azcore.ClientOptions{Transport: &http.Client{Transport: transport}}}
+	}
+
+	if p.cfg.ConnectionString != "" {
+		ctx, cancel := p.context()
+		defer cancel()
+
+		connectionString, err := kv.ResolveSecrets(ctx, p.cfg.ConnectionString)
+		if err != nil {
+			return nil, err
+		}
+		return container.NewClientFromConnectionString(connectionString, p.cfg.Container, opts)
+	}
+
+	var credential azcore.TokenCredential
+	var err error
+	if p.cfg.ManagedIdentityClientID != "" {
+		credential, err = azidentity.NewManagedIdentityCredential(&azidentity.ManagedIdentityCredentialOptions{
+			ID: azidentity.ClientID(p.cfg.ManagedIdentityClientID),
+		})
+	} else {
+		credential, err = azidentity.NewDefaultAzureCredential(nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	containerURL, err := url.JoinPath(p.cfg.AccountURL, p.cfg.Container)
+	if err != nil {
+		return nil, err
+	}
+
+	return container.NewClient(containerURL, credential, opts)
+}
+
+func (p *provider[K, V]) Shutdown() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.client = nil
+	return nil
+}
+
+func (p *provider[K, V]) Close() error {
+	return p.Shutdown()
+}
+
+func (p *provider[K, V]) Ping(ctx context.Context) error {
+	client, err := p.conn()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.GetProperties(ctx, nil)
+	return mapError(err)
+}
+
+func (p *provider[K, V]) Stats() (kv.ProviderStats, error) {
+	stats := kv.ProviderStats{Provider: "azureblob"}
+
+	err := p.list(p.cfg.Prefix, func(item *container.BlobItem) (bool, error) {
+		if strings.HasPrefix(*item.Name, p.cfg.Prefix+referencePrefix) {
+			stats.References++
+		} else if strings.HasPrefix(*item.Name, p.cfg.Prefix+dataPrefix) {
+			stats.Entries++
+		}
+		if item.Properties != nil && item.Properties.ContentLength != nil {
+			stats.DiskUsage += *item.Properties.ContentLength
+		}
+		return true, nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	if lastWrite := p.lastWrite.Load(); lastWrite > 0 {
+		stats.LastFlush = time.Unix(0, lastWrite)
+	}
+	stats.Backend = map[string]any{
+		"container": p.cfg.Container,
+		"prefix":    p.cfg.Prefix,
+		"read_only": p.cfg.ReadOnly,
+	}
+
+	return stats, nil
+}
+
+func (p *provider[K, V]) ApproximateSize() (uint64, error) {
+	var size uint64
+	err := p.list(p.cfg.Prefix, func(item *container.BlobItem) (bool, error) {
+		if item.Properties != nil && item.Properties.ContentLength != nil {
+			size += uint64(*item.Properties.ContentLength)
+		}
+		return true, nil
+	})
+
+	return size, err
+}
+
+func (p *provider[K, V]) Store(key K, value V) error {
+	data, err := encode(value)
+	if err != nil {
+		return err
+	}
+
+	return p.upload(p.dataBlob(key), data, nil)
+}
+
+func (p *provider[K, V]) Update(key K, fn func(value V, exists bool) (V, error)) error {
+	return p.compareAndSwap(p.dataBlob(key), func(raw []byte, exists bool) ([]byte, error) {
+		var value V
+		if exists {
+			var err error
+			if value, err = decode[V](raw); err != nil {
+				return nil, err
+			}
+		}
+
+		value, err := fn(value, exists)
+		if err != nil {
+			return nil, err
+		}
+
+		return encode(value)
+	})
+}
+
+func (p *provider[K, V]) Get(key K) (V, error) {
+	raw, _, err := p.download(p.dataBlob(key))
+	if err != nil {
+		var v V
+		return v, err
+	}
+
+	return decode[V](raw)
+}
+
+func (p *provider[K, V]) GetMultiple(keys []K) ([]V, error) {
+	var values []V
+	for _, key := range keys {
+		v, err := p.Get(key)
+		if err != nil {
+			return []V{}, err
+		}
+
+		values = append(values, v)
+	}
+
+	return values, nil
+}
+
+func (p *provider[K, V]) Remove(key K) error {
+	return p.delete(p.dataBlob(key))
+}
+
+func (p *provider[K, V]) RemovePrefix(prefix K) (int, error) {
+	return p.removeWhere(p.cfg.Prefix+dataPrefix+escape(keyString(prefix)), func(string, []byte) (bool, error) {
+		return true, nil
+	})
+}
+
+func (p *provider[K, V]) RemoveWhere(pred func(key K, value V) bool) (int, error) {
+	return p.removeWhere(p.cfg.Prefix+dataPrefix, func(name string, raw []byte) (bool, error) {
+		key, err := p.blobKey(name, dataPrefix)
+		if err != nil {
+			return false, err
+		}
+		value, err := decode[V](raw)
+		if err != nil {
+			return false, err
+		}
+
+		return pred(key, value), nil
+	})
+}
+
+func (p *provider[K, V]) Clear() error {
+	_, err := p.removeWhere(p.cfg.Prefix, nil)
+	return err
+}
+
+func (p *provider[K, V]) removeWhere(prefix string, fn func(name string, raw []byte) (bool, error)) (int, error) {
+	if p.cfg.ReadOnly {
+		return 0, storageErrors.ReadOnly
+	}
+
+	var names []string
+	err := p.list(prefix, func(item *container.BlobItem) (bool, error) {
+		if fn != nil {
+			raw, _, err := p.download(*item.Name)
+			if storageErrors.Is(err, storageErrors.NotFound) {
+				return true, nil
+			}
+			if err != nil {
+				return false, err
+			}
+
+			remove, err := fn(*item.Name, raw)
+			if err != nil || !remove {
+				return err == nil, err
+			}
+		}
+
+		names = append(names, *item.Name)
+		return true, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, name := range names {
+		err := p.delete(name)
+		if storageErrors.Is(err, storageErrors.NotFound) {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+func (p *provider[K, V]) ForEach(fn func(key K, value V) bool) error {
+	return p.iterate(p.cfg.Prefix+dataPrefix, fn)
+}
+
+func (p *provider[K, V]) ForEachPrefix(prefix K, fn func(key K, value V) bool) error {
+	return p.iterate(p.cfg.Prefix+dataPrefix+escape(keyString(prefix)), fn)
+}
+
+func (p *provider[K, V]) iterate(prefix string, fn func(key K, value V) bool) error {
+	return p.list(prefix, func(item *container.BlobItem) (bool, error) {
+		key, err := p.blobKey(*item.Name, dataPrefix)
+		if err != nil {
+			return false, err
+		}
+
+		raw, _, err := p.download(*item.Name)
+		if storageErrors.Is(err, storageErrors.NotFound) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		value, err := decode[V](raw)
+		if err != nil {
+			return false, err
+		}
+
+		return fn(key, value), nil
+	})
+}
+
+func (p *provider[K, V]) ForEachKey(fn func(key K) bool) error {
+	return p.list(p.cfg.Prefix+dataPrefix, func(item *container.BlobItem) (bool, error) {
+		key, err := p.blobKey(*item.Name, dataPrefix)
+		if err != nil {
+			return false, err
+		}
+
+		return fn(key), nil
+	})
+}
+
+func (p *provider[K, V]) ForEachReference(fn func(reference K, key K) bool) error {
+	return p.list(p.cfg.Prefix+referencePrefix, func(item *container.BlobItem) (bool, error) {
+		reference, err := p.blobKey(*item.Name, referencePrefix)
+		if err != nil {
+			return false, err
+		}
+
+		targets, err := p.targets(reference)
+		if storageErrors.Is(err, storageErrors.NotFound) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		for _, key := range targets {
+			if !fn(reference, key) {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+}
+
+func (p *provider[K, V]) StoreReference(reference K, key K) error {
+	data, err := json.Marshal([]K{key})
+	if err != nil {
+		return err
+	}
+
+	return p.upload(p.referenceBlob(reference), data, nil)
+}
+
+func (p *provider[K, V]) AddReference(reference K, key K) error {
+	return p.compareAndSwap(p.referenceBlob(reference), func(raw []byte, exists bool) ([]byte, error) {
+		var targets []K
+		if exists {
+			if err := json.Unmarshal(raw, &targets); err != nil {
+				return nil, storageErrors.NewCorrupted(err)
+			}
+		}
+		for _, target := range targets {
+			if target == key {
+				return raw, nil
+			}
+		}
+
+		return json.Marshal(append(targets, key))
+	})
+}
+
+func (p *provider[K, V]) RemoveReference(reference K) error {
+	return p.delete(p.referenceBlob(reference))
+}
+
+func (p *provider[K, V]) RemoveReferenceTarget(reference K, key K) error {
+	name := p.referenceBlob(reference)
+
+	empty := false
+	err := p.compareAndSwap(name, func(raw []byte, exists bool) ([]byte, error) {
+		if !exists {
+			return nil, storageErrors.NewNotFound(fmt.Errorf("reference %v", reference))
+		}
+
+		var targets []K
+		if err := json.Unmarshal(raw, &targets); err != nil {
+			return nil, storageErrors.NewCorrupted(err)
+		}
+
+		remaining := make([]K, 0, len(targets))
+		for _, target := range targets {
+			if target != key {
+				remaining = append(remaining, target)
+			}
+		}
+		if len(remaining) == len(targets) {
+			return nil, storageErrors.NewNotFound(fmt.Errorf("reference %v does not point to %v", reference, key))
+		}
+
+		empty = len(remaining) == 0
+		return json.Marshal(remaining)
+	})
+	if err != nil || !empty {
+		return err
+	}
+
+	err = p.delete(name)
+	if storageErrors.Is(err, storageErrors.NotFound) {
+		return nil
+	}
+
+	return err
+}
+
+func (p *provider[K, V]) GetByReference(reference K) (V, error) {
+	keys, err := p.resolve(reference, 0, map[K]bool{})
+	if err != nil {
+		var v V
+		return v, err
+	}
+
+	return p.Get(keys[0])
+}
+
+func (p *provider[K, V]) GetAllByReference(reference K) ([]V, error) {
+	keys, err := p.resolve(reference, 0, map[K]bool{})
+	if err != nil {
+		return nil, err
+	}
+
+	var values []V
+	for _, key := range keys {
+		v, err := p.Get(key)
+		if storageErrors.Is(err, storageErrors.NotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+
+	return values, nil
+}
+
+func (p *provider[K, V]) resolve(reference K, depth int, path map[K]bool) ([]K, error) {
+	if path[reference] {
+		return nil, storageErrors.NewReferenceCycle(fmt.Errorf("reference %v", reference))
+	}
+	if depth >= p.cfg.MaxReferenceDepth {
+		return nil, storageErrors.NewReferenceTooDeep(fmt.Errorf("reference %v exceeds depth %d", reference, p.cfg.MaxReferenceDepth))
+	}
+
+	targets, err := p.targets(reference)
+	if err != nil {
+		return nil, err
+	}
+
+	path[reference] = true
+	defer delete(path, reference)
+
+	var keys []K
+	for _, target := range targets {
+		if _, err := p.targets(target); storageErrors.Is(err, storageErrors.NotFound) {
+			keys = append(keys, target)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		resolved, err := p.resolve(target, depth+1, path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, resolved...)
+	}
+
+	return keys, nil
+}
+
+func (p *provider[K, V]) targets(reference K) ([]K, error) {
+	raw, _, err := p.download(p.referenceBlob(reference))
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []K
+	if err := json.Unmarshal(raw, &targets); err != nil {
+		return nil, storageErrors.NewCorrupted(err)
+	}
+	if len(targets) == 0 {
+		return nil, storageErrors.NewNotFound(fmt.Errorf("reference %v", reference))
+	}
+
+	return targets, nil
+}
+
+func (p *provider[K, V]) Verify() error {
+	var errs []error
+	err := p.list(p.cfg.Prefix+dataPrefix, func(item *container.BlobItem) (bool, error) {
+		if _, err := p.blobKey(*item.Name, dataPrefix); err != nil {
+			errs = append(errs, fmt.Errorf("blob %q: %w", *item.Name, err))
+			return true, nil
+		}
+
+		raw, _, err := p.download(*item.Name)
+		if storageErrors.Is(err, storageErrors.NotFound) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if _, err := decode[V](raw); err != nil {
+			errs = append(errs, fmt.Errorf("blob %q: %w", *item.Name, err))
+		}
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return errors.Join(errs...)
+}
+
+type backupRecord[K ~string | ~uint64, V any] struct {
+	Key       K   `json:"key"`
+	Value     *V  `json:"value,omitempty"`
+	Reference *K  `json:"reference,omitempty"`
+	Targets   []K `json:"targets,omitempty"`
+}
+
+func (p *provider[K, V]) Backup(w io.Writer) error {
+	enc := json.NewEncoder(w)
+
+	var encErr error
+	err := p.iterate(p.cfg.Prefix+dataPrefix, func(key K, value V) bool {
+		encErr = enc.Encode(backupRecord[K, V]{Key: key, Value: &value})
+		return encErr == nil
+	})
+	if err = errors.Join(err, encErr); err != nil {
+		return err
+	}
+
+	return p.list(p.cfg.Prefix+referencePrefix, func(item *container.BlobItem) (bool, error) {
+		reference, err := p.blobKey(*item.Name, referencePrefix)
+		if err != nil {
+			return false, err
+		}
+
+		targets, err := p.targets(reference)
+		if storageErrors.Is(err, storageErrors.NotFound) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		return true, enc.Encode(backupRecord[K, V]{Reference: &reference, Targets: targets})
+	})
+}
+
+func (p *provider[K, V]) compareAndSwap(name string, fn func(raw []byte, exists bool) ([]byte, error)) error {
+	for attempt := 0; attempt <= p.cfg.ConflictRetries; attempt++ {
+		raw, etag, err := p.download(name)
+		exists := err == nil
+		if err != nil && !storageErrors.Is(err, storageErrors.NotFound) {
+			return err
+		}
+
+		data, err := fn(raw, exists)
+		if err != nil {
+			return err
+		}
+
+		conditions := &blob.ModifiedAccessConditions{}
+		if exists {
+			conditions.IfMatch = &etag
+		} else {
+			none := azcore.ETagAny
+			conditions.IfNoneMatch = &none
+		}
+
+		err = p.upload(name, data, conditions)
+		if storageErrors.Is(err, storageErrors.Conflict) {
+			continue
+		}
+
+		return err
+	}
+
+	return storageErrors.NewConflict(fmt.Errorf("blob %q changed concurrently %d times", name, p.cfg.ConflictRetries+1))
+}
+
+func (p *provider[K, V]) upload(name string, data []byte, conditions *blob.ModifiedAccessConditions) error {
+	if p.cfg.ReadOnly {
+		return storageErrors.ReadOnly
+	}
+
+	client, err := p.conn()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := p.context()
+	defer cancel()
+
+	opts := &blockblob.UploadBufferOptions{}
+	if conditions != nil {
+		opts.AccessConditions = &blob.AccessConditions{ModifiedAccessConditions: conditions}
+	}
+
+	if _, err := client.NewBlockBlobClient(name).UploadBuffer(ctx, data, opts); err != nil {
+		return mapError(err)
+	}
+
+	p.lastWrite.Store(time.Now().UnixNano())
+	return nil
+}
+
+func (p *provider[K, V]) download(name string) ([]byte, azcore.ETag, error) {
+	client, err := p.conn()
+	if err != nil {
+		return nil, "", err
+	}
+
+	ctx, cancel := p.context()
+	defer cancel()
+
+	resp, err := client.NewBlobClient(name).DownloadStream(ctx, nil)
+	if err != nil {
+		return nil, "", mapError(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", mapError(err)
+	}
+
+	var etag azcore.ETag
+	if resp.ETag != nil {
+		etag = *resp.ETag
+	}
+
+	return data, etag, nil
+}
+
+func (p *provider[K, V]) delete(name string) error {
+	if p.cfg.ReadOnly {
+		return storageErrors.ReadOnly
+	}
+
+	client, err := p.conn()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := p.context()
+	defer cancel()
+
+	if _, err := client.NewBlobClient(name).Delete(ctx, nil); err != nil {
+		return mapError(err)
+	}
+
+	p.lastWrite.Store(time.Now().UnixNano())
+	return nil
+}
+
+func (p *provider[K, V]) list(prefix string, fn func(item *container.BlobItem) (bool, error)) error {
+	client, err := p.conn()
+	if err != nil {
+		return err
+	}
+
+	pager := client.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{Prefix: &prefix})
+	for pager.More() {
+		ctx, cancel := p.context()
+		page, err := pager.NextPage(ctx)
+		cancel()
+		if err != nil {
+			return mapError(err)
+		}
+		if page.Segment == nil {
+			continue
+		}
+
+		for _, item := range page.Segment.BlobItems {
+			if item.Name == nil {
+				continue
+			}
+
+			next, err := fn(item)
+			if err != nil || !next {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *provider[K, V]) conn() (*container.Client, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.client == nil {
+		return nil, storageErrors.NewClosed(errors.New("azure blob client is not open"))
+	}
+
+	return p.client, nil
+}
+
+func (p *provider[K, V]) context() (context.Context, context.CancelFunc) {
+	if p.cfg.TransactionTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+
+	return context.WithTimeout(context.Background(), p.cfg.TransactionTimeout)
+}
+
+func (p *provider[K, V]) dataBlob(key K) string {
+	return p.cfg.Prefix + dataPrefix + escape(keyString(key))
+}
+
+func (p *provider[K, V]) referenceBlob(reference K) string {
+	return p.cfg.Prefix + referencePrefix + escape(keyString(reference))
+}
+
+func (p *provider[K, V]) blobKey(name, namespace string) (K, error) {
+	escaped := strings.TrimPrefix(name, p.cfg.Prefix+namespace)
+
+	s, err := url.PathUnescape(escaped)
+	if err != nil {
+		var key K
+		return key, storageErrors.NewCorrupted(fmt.Errorf("blob name %q: %w", name, err))
+	}
+
+	return parseKey[K](s)
+}
+
+func mapError(err error) error {
+	var respErr *azcore.ResponseError
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, context.DeadlineExceeded):
+		return storageErrors.NewTimeout(err)
+	case bloberror.HasCode(err, bloberror.BlobNotFound, bloberror.ContainerNotFound):
+		return storageErrors.NewNotFound(err)
+	case bloberror.HasCode(err, bloberror.ConditionNotMet, bloberror.BlobAlreadyExists):
+		return storageErrors.NewConflict(err)
+	case errors.As(err, &respErr):
+		switch respErr.StatusCode {
+		case http.StatusNotFound:
+			return storageErrors.NewNotFound(err)
+		case http.StatusConflict, http.StatusPreconditionFailed:
+			return storageErrors.NewConflict(err)
+		case http.StatusTooManyRequests:
+			return storageErrors.NewRateLimited(err)
+		case http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusGatewayTimeout:
+			return storageErrors.NewUnavailable(err)
+		}
+	}
+
+	return err
+}
+
+func escape(key string) string {
+	return strings.ReplaceAll(url.PathEscape(key), "%2F", "/")
+}
+
+func encode[T any](value T) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decode[T any](data []byte) (T, error) {
+	var value T
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value); err != nil {
+		return value, storageErrors.NewCorrupted(err)
+	}
+
+	return value, nil
+}
+
+func keyString[K ~string | ~uint64](key K) string {
+	v := reflect.ValueOf(key)
+	if v.Kind() == reflect.Uint64 {
+		return strconv.FormatUint(v.Uint(), 10)
+	}
+
+	return v.String()
+}
+
+func parseKey[K ~string | ~uint64](s string) (K, error) {
+	var key K
+	v := reflect.ValueOf(&key).Elem()
+	if v.Kind() != reflect.Uint64 {
+		v.SetString(s)
+		return key, nil
+	}
+
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return key, storageErrors.NewCorrupted(fmt.Errorf("key %q is not a uint64", s))
+	}
+	v.SetUint(n)
+
+	return key, nil
+}
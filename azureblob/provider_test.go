@@ -0,0 +1,226 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !(js && wasm) && !noazureblob
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package azureblob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProvider_Blobs(t *testing.T) {
+	p, err := New[string, int](Config{ConnectionString: "UseDevelopmentStorage=true", Container: "kv", Prefix: "app/"})
+	require.NoError(t, err)
+
+	names := map[string]string{
+		"user":      "app/data/user",
+		"users/1":   "app/data/users/1",
+		"a b":       "app/data/a%20b",
+		"100%":      "app/data/100%25",
+		"a?b#c":     "app/data/a%3Fb%23c",
+		"ключ":      "app/data/%D0%BA%D0%BB%D1%8E%D1%87",
+		"":          "app/data/",
+		"a\\b\x00c": "app/data/a%5Cb%00c",
+	}
+	for key, name := range names {
+		assert.Equal(t, name, p.dataBlob(key), "%q", key)
+
+		decoded, err := p.blobKey(name, dataPrefix)
+		require.NoError(t, err)
+		assert.Equal(t, key, decoded, "%q", key)
+	}
+	assert.Equal(t, "app/references/a%20b", p.referenceBlob("a b"))
+
+	// Escaping keeps prefixes, so prefix listings find every key.
+	for _, key := range []string{"a b/c", "100%/x", "ключ/1"} {
+		prefix := key[:strings.Index(key, "/")+1]
+		assert.True(t, strings.HasPrefix(p.dataBlob(key), p.cfg.Prefix+dataPrefix+escape(prefix)), "%q", key)
+	}
+
+	_, err = p.blobKey("app/data/%zz", dataPrefix)
+	assert.True(t, storageErrors.Is(err, storageErrors.Corrupted))
+
+	numeric, err := New[uint64, int](Config{ConnectionString: "UseDevelopmentStorage=true", Container: "kv"})
+	require.NoError(t, err)
+	assert.Equal(t, "data/42", numeric.dataBlob(42))
+	number, err := numeric.blobKey("data/42", dataPrefix)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(42), number)
+	_, err = numeric.blobKey("data/user", dataPrefix)
+	assert.True(t, storageErrors.Is(err, storageErrors.Corrupted))
+}
+
+func TestMapError(t *testing.T) {
+	assert.NoError(t, mapError(nil))
+
+	mapped := map[error]error{
+		&azcore.ResponseError{ErrorCode: string(bloberror.BlobNotFound)}:      storageErrors.NotFound,
+		&azcore.ResponseError{ErrorCode: string(bloberror.ContainerNotFound)}: storageErrors.NotFound,
+		&azcore.ResponseError{ErrorCode: string(bloberror.ConditionNotMet)}:   storageErrors.Conflict,
+		&azcore.ResponseError{ErrorCode: string(bloberror.BlobAlreadyExists)}: storageErrors.Conflict,
+		&azcore.ResponseError{StatusCode: http.StatusNotFound}:                storageErrors.NotFound,
+		&azcore.ResponseError{StatusCode: http.StatusConflict}:                storageErrors.Conflict,
+		&azcore.ResponseError{StatusCode: http.StatusPreconditionFailed}:      storageErrors.Conflict,
+		&azcore.ResponseError{StatusCode: http.StatusTooManyRequests}:         storageErrors.RateLimited,
+		&azcore.ResponseError{StatusCode: http.StatusServiceUnavailable}:      storageErrors.Unavailable,
+		&azcore.ResponseError{StatusCode: http.StatusBadGateway}:              storageErrors.Unavailable,
+		&azcore.ResponseError{StatusCode: http.StatusGatewayTimeout}:          storageErrors.Unavailable,
+		context.DeadlineExceeded:                             storageErrors.Timeout,
+		fmt.Errorf("download: %w", context.DeadlineExceeded): storageErrors.Timeout,
+	}
+	for err, kind := range mapped {
+		assert.True(t, storageErrors.Is(mapError(err), kind), "%v", err)
+	}
+
+	forbidden := &azcore.ResponseError{StatusCode: http.StatusForbidden}
+	assert.Equal(t, forbidden, mapError(forbidden))
+}
+
+func TestCodec(t *testing.T) {
+	data, err := encode(map[string]int{"a": 1})
+	require.NoError(t, err)
+	value, err := decode[map[string]int](data)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"a": 1}, value)
+
+	_, err = decode[int]([]byte("garbage"))
+	assert.True(t, storageErrors.Is(err, storageErrors.Corrupted))
+}
+
+func TestProvider_Closed(t *testing.T) {
+	p, err := New[string, int](Config{ConnectionString: "UseDevelopmentStorage=true", Container: "kv"})
+	require.NoError(t, err)
+
+	assert.True(t, storageErrors.Is(p.Store("key", 1), storageErrors.Closed))
+	_, err = p.Get("key")
+	assert.True(t, storageErrors.Is(err, storageErrors.Closed))
+
+	p, err = New[string, int](Config{ConnectionString: "UseDevelopmentStorage=true", Container: "kv", ReadOnly: true})
+	require.NoError(t, err)
+	assert.ErrorIs(t, p.Store("key", 1), storageErrors.ReadOnly)
+}
+
+// TestProvider_Integration runs against the account in
+// AZURE_STORAGE_CONNECTION_STRING, e.g. Azurite. It creates and deletes its
+// own container.
+func TestProvider_Integration(t *testing.T) {
+	connectionString := os.Getenv("AZURE_STORAGE_CONNECTION_STRING")
+	if connectionString == "" {
+		t.Skip("AZURE_STORAGE_CONNECTION_STRING is not set")
+	}
+
+	p, err := New[string, int](Config{
+		ConnectionString: connectionString,
+		Container:        fmt.Sprintf("storage-test-%d", time.Now().UnixNano()),
+		Prefix:           "app/",
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	defer func() {
+		client, err := p.conn()
+		require.NoError(t, err)
+		_, err = client.Delete(context.Background(), nil)
+		assert.NoError(t, err)
+		require.NoError(t, p.Shutdown())
+	}()
+
+	_, err = p.Get("a")
+	assert.True(t, storageErrors.Is(err, storageErrors.NotFound))
+
+	require.NoError(t, p.Store("a", 1))
+	require.NoError(t, p.Store("b/1", 2))
+	require.NoError(t, p.Store("b/2 x", 3))
+	require.NoError(t, p.Store("b%", 4))
+	val, err := p.Get("b/2 x")
+	require.NoError(t, err)
+	assert.Equal(t, 3, val)
+	values, err := p.GetMultiple([]string{"b/1", "a"})
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 1}, values)
+	_, err = p.GetMultiple([]string{"a", "missing"})
+	assert.True(t, storageErrors.Is(err, storageErrors.NotFound))
+
+	require.NoError(t, p.Update("a", func(value int, exists bool) (int, error) {
+		assert.True(t, exists)
+		return value + 10, nil
+	}))
+	require.NoError(t, p.Update("c", func(value int, exists bool) (int, error) {
+		assert.False(t, exists)
+		return 5, nil
+	}))
+	failed := errors.New("failed")
+	assert.ErrorIs(t, p.Update("a", func(value int, exists bool) (int, error) {
+		return 0, failed
+	}), failed)
+	val, err = p.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, 11, val)
+
+	var keys []string
+	require.NoError(t, p.ForEachPrefix("b/", func(key string, value int) bool {
+		keys = append(keys, key)
+		return true
+	}))
+	assert.Equal(t, []string{"b/1", "b/2 x"}, keys)
+
+	require.NoError(t, p.StoreReference("ref", "a"))
+	require.NoError(t, p.AddReference("ref", "b/1"))
+	require.NoError(t, p.AddReference("ref", "b/1"))
+	val, err = p.GetByReference("ref")
+	require.NoError(t, err)
+	assert.Equal(t, 11, val)
+	values, err = p.GetAllByReference("ref")
+	require.NoError(t, err)
+	assert.Equal(t, []int{11, 2}, values)
+	require.NoError(t, p.StoreReference("outer", "ref"))
+	require.NoError(t, p.StoreReference("ref", "outer"))
+	_, err = p.GetByReference("outer")
+	assert.True(t, storageErrors.Is(err, storageErrors.ReferenceCycle))
+	assert.True(t, storageErrors.Is(p.RemoveReferenceTarget("ref", "a"), storageErrors.NotFound))
+	require.NoError(t, p.RemoveReferenceTarget("ref", "outer"))
+	_, err = p.GetByReference("ref")
+	assert.True(t, storageErrors.Is(err, storageErrors.NotFound))
+	require.NoError(t, p.RemoveReference("outer"))
+
+	removed, err := p.RemovePrefix("b/")
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	removed, err = p.RemoveWhere(func(key string, value int) bool { return value == 11 })
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.True(t, storageErrors.Is(p.Remove("missing"), storageErrors.NotFound))
+
+	require.NoError(t, p.Verify())
+	var backup bytes.Buffer
+	require.NoError(t, p.Backup(&backup))
+	assert.Contains(t, backup.String(), `"b%"`)
+	stats, err := p.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), stats.Entries)
+	require.NoError(t, p.Ping(context.Background()))
+
+	require.NoError(t, p.Clear())
+	require.NoError(t, p.ForEachKey(func(key string) bool {
+		t.Errorf("key %q is left after Clear", key)
+		return true
+	}))
+}
@@ -0,0 +1,17 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !(js && wasm) && !noazureblob
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import "github.com/rlshukhov/storage/azureblob"
+
+func newAzureBlobProvider[K ~string | ~uint64, V any](cfg azureblob.Config) (KeyValueProvider[K, V], error) {
+	return azureblob.New[K, V](cfg)
+}
@@ -0,0 +1,365 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/rlshukhov/storage/kv"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type BackupSchedule struct {
+	Interval  time.Duration `yaml:"interval"`
+	Directory string        `yaml:"directory,omitempty"`
+	Retain    int           `yaml:"retain,omitempty"`
+
+	// Destination stores the backups instead of Directory. Its values are
+	// byte slices, so every backup is held in memory while it is stored.
+	Destination KeyValueProvider[string, []byte] `yaml:"-"`
+	OnSuccess   func(name string, size int)      `yaml:"-"`
+	OnFailure   func(err error)                  `yaml:"-"`
+}
+
+const (
+	backupPrefix     = "backup-"
+	backupSuffix     = ".bak"
+	backupTimeFormat = "20060102T150405.000000000Z"
+)
+
+type scheduledProvider[K ~string | ~uint64, V any] struct {
+	KeyValueProvider[K, V]
+	schedule BackupSchedule
+
+	mu      sync.Mutex
+	stop    chan struct{}
+	done    chan struct{}
+	running bool
+}
+
+func (s BackupSchedule) Validate() error {
+	var errs []error
+	if s.Interval <= 0 {
+		errs = append(errs, errors.New("interval must be positive"))
+	}
+	if (s.Directory == "") == (s.Destination == nil) {
+		errs = append(errs, errors.New("exactly one of directory or destination is required"))
+	}
+	if s.Retain < 0 {
+		errs = append(errs, errors.New("retain must not be negative"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func newScheduledProvider[K ~string | ~uint64, V any](inner KeyValueProvider[K, V], schedule BackupSchedule) (KeyValueProvider[K, V], error) {
+	if err := schedule.Validate(); err != nil {
+		return nil, err
+	}
+
+	p := &scheduledProvider[K, V]{
+		KeyValueProvider: inner,
+		schedule:         schedule,
+	}
+
+	// Like the lazy provider, Watcher and Expirer are only implemented if
+	// inner implements them.
+	_, watches := inner.(Watcher[K, V])
+	_, expires := inner.(Expirer[K, V])
+	switch {
+	case watches && expires:
+		return &scheduledWatcherExpirer[K, V]{p}, nil
+	case watches:
+		return &scheduledWatcher[K, V]{p}, nil
+	case expires:
+		return &scheduledExpirer[K, V]{p}, nil
+	default:
+		return p, nil
+	}
+}
+
+type scheduledWatcher[K ~string | ~uint64, V any] struct {
+	*scheduledProvider[K, V]
+}
+
+func (p *scheduledWatcher[K, V]) Watch(ctx context.Context, prefix K, fn func(key K, value V, removed bool)) error {
+	return p.KeyValueProvider.(Watcher[K, V]).Watch(ctx, prefix, fn)
+}
+
+type scheduledExpirer[K ~string | ~uint64, V any] struct {
+	*scheduledProvider[K, V]
+}
+
+func (p *scheduledExpirer[K, V]) StoreWithTTL(key K, value V, ttl time.Duration) error {
+	return p.KeyValueProvider.(Expirer[K, V]).StoreWithTTL(key, value, ttl)
+}
+
+type scheduledWatcherExpirer[K ~string | ~uint64, V any] struct {
+	*scheduledProvider[K, V]
+}
+
+func (p *scheduledWatcherExpirer[K, V]) Watch(ctx context.Context, prefix K, fn func(key K, value V, removed bool)) error {
+	return p.KeyValueProvider.(Watcher[K, V]).Watch(ctx, prefix, fn)
+}
+
+func (p *scheduledWatcherExpirer[K, V]) StoreWithTTL(key K, value V, ttl time.Duration) error {
+	return p.KeyValueProvider.(Expirer[K, V]).StoreWithTTL(key, value, ttl)
+}
+
+func (p *scheduledProvider[K, V]) Setup() error {
+	if err := p.KeyValueProvider.Setup(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.running {
+		return nil
+	}
+
+	p.running = true
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+	go p.run(p.stop, p.done)
+
+	return nil
+}
+
+func (p *scheduledProvider[K, V]) Shutdown() error {
+	p.mu.Lock()
+	if p.running {
+		p.running = false
+		close(p.stop)
+		<-p.done
+	}
+	p.mu.Unlock()
+
+	return p.KeyValueProvider.Shutdown()
+}
+
+func (p *scheduledProvider[K, V]) Close() error {
+	return p.Shutdown()
+}
+
+func (p *scheduledProvider[K, V]) ReencodeAll() (int, error) {
+	return ReencodeAll(p.KeyValueProvider)
+}
+
+func (p *scheduledProvider[K, V]) MigrateKeys() (int, error) {
+	return MigrateKeys(p.KeyValueProvider)
+}
+
+func (p *scheduledProvider[K, V]) List(limit int, cursor Cursor) ([]kv.Entry[K, V], Cursor, error) {
+	return List(p.KeyValueProvider, limit, cursor)
+}
+
+func (p *scheduledProvider[K, V]) ForEachParallel(workers int, fn func(key K, value V) error) error {
+	return ForEachParallel(p.KeyValueProvider, workers, fn)
+}
+
+func (p *scheduledProvider[K, V]) Restore(r io.Reader) error {
+	return Restore(p.KeyValueProvider, r)
+}
+
+func (p *scheduledProvider[K, V]) StoreWithReferences(key K, value V, references ...K) error {
+	return StoreWithReferences(p.KeyValueProvider, key, value, references...)
+}
+
+func (p *scheduledProvider[K, V]) RemoveWithReferences(keys []K, references []K) error {
+	return RemoveWithReferences(p.KeyValueProvider, keys, references)
+}
+
+func (p *scheduledProvider[K, V]) GetInto(key K, dst *V) error {
+	return GetInto(p.KeyValueProvider, key, dst)
+}
+
+func (p *scheduledProvider[K, V]) StoreIfAbsent(key K, value V) error {
+	return StoreIfAbsent(p.KeyValueProvider, key, value)
+}
+
+func (p *scheduledProvider[K, V]) StoreIfPresent(key K, value V) error {
+	return StoreIfPresent(p.KeyValueProvider, key, value)
+}
+
+func (p *scheduledProvider[K, V]) Flush() error {
+	return Flush(p.KeyValueProvider)
+}
+
+func (p *scheduledProvider[K, V]) Maintain(ctx context.Context) error {
+	return Maintain(ctx, p.KeyValueProvider)
+}
+
+func (p *scheduledProvider[K, V]) OnExpire(fn func(key K, value V)) error {
+	return OnExpire(p.KeyValueProvider, fn)
+}
+
+func (p *scheduledProvider[K, V]) SweepExpired() (int, error) {
+	return SweepExpired(p.KeyValueProvider)
+}
+
+func (p *scheduledProvider[K, V]) OpenSnapshot() (kv.Snapshot[K, V], error) {
+	return Snapshot(p.KeyValueProvider)
+}
+
+func (p *scheduledProvider[K, V]) Preload(keys []K) (int, error) {
+	return Preload(p.KeyValueProvider, keys)
+}
+
+func (p *scheduledProvider[K, V]) PreloadPrefix(prefix K) (int, error) {
+	return PreloadPrefix(p.KeyValueProvider, prefix)
+}
+
+func (p *scheduledProvider[K, V]) run(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(p.schedule.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			name, size, err := p.backup()
+			if err != nil {
+				if p.schedule.OnFailure != nil {
+					p.schedule.OnFailure(err)
+				}
+				continue
+			}
+			if p.schedule.OnSuccess != nil {
+				p.schedule.OnSuccess(name, size)
+			}
+		}
+	}
+}
+
+func (p *scheduledProvider[K, V]) backup() (string, int, error) {
+	name := backupPrefix + time.Now().UTC().Format(backupTimeFormat) + backupSuffix
+	if p.schedule.Destination != nil {
+		var buf bytes.Buffer
+		if err := p.KeyValueProvider.Backup(&buf); err != nil {
+			return name, 0, err
+		}
+		if err := p.schedule.Destination.Store(name, buf.Bytes()); err != nil {
+			return name, 0, err
+		}
+		return name, buf.Len(), p.pruneDestination()
+	}
+
+	size, err := p.backupToFile(filepath.Join(p.schedule.Directory, name))
+	if err != nil {
+		return name, 0, err
+	}
+
+	return name, size, p.pruneDirectory()
+}
+
+// backupToFile streams the backup to a temporary file next to path and
+// renames it into place once it is completely written.
+func (p *scheduledProvider[K, V]) backupToFile(path string) (int, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+
+	if err := p.KeyValueProvider.Backup(f); err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+
+	return int(info.Size()), os.Rename(f.Name(), path)
+}
+
+func (p *scheduledProvider[K, V]) pruneDirectory() error {
+	if p.schedule.Retain <= 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(p.schedule.Directory)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if isBackupName(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+
+	var errs []error
+	for _, name := range expiredBackups(names, p.schedule.Retain) {
+		errs = append(errs, os.Remove(filepath.Join(p.schedule.Directory, name)))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (p *scheduledProvider[K, V]) pruneDestination() error {
+	if p.schedule.Retain <= 0 {
+		return nil
+	}
+
+	var names []string
+	err := p.schedule.Destination.ForEachKey(func(key string) bool {
+		if isBackupName(key) {
+			names = append(names, key)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, name := range expiredBackups(names, p.schedule.Retain) {
+		errs = append(errs, p.schedule.Destination.Remove(name))
+	}
+
+	return errors.Join(errs...)
+}
+
+func isBackupName(name string) bool {
+	return strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupSuffix)
+}
+
+func expiredBackups(names []string, retain int) []string {
+	if len(names) <= retain {
+		return nil
+	}
+
+	slices.Sort(names)
+	return names[:len(names)-retain]
+}
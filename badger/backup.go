@@ -0,0 +1,20 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package badger
+
+import (
+	"github.com/rlshukhov/storage/internal/chunked"
+	"io"
+)
+
+// VerifyBackup checks the chunk checksums and the overall SHA-256 of a backup
+// without restoring it.
+func VerifyBackup(r io.Reader) error {
+	return chunked.Verify(r)
+}
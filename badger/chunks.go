@@ -0,0 +1,382 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !nobadger
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger/v4"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"hash/crc32"
+	"sync"
+	"time"
+)
+
+// Values above the chunk size are stored as chunks under keys of their own
+// and a manifest under the key of the value:
+//
+//	manifest:  id [16]byte | chunks uint32 | size uint64 | crc32 of the value
+//	chunk key: "\xffchunk/" | id | index uint32
+//
+// 0xFF never starts a valid UTF-8 string, decimal or UUID key and chunk keys
+// are longer than binary keys, so chunk keys do not collide with keys.
+const (
+	chunkedMeta byte = 3
+	chunkMeta   byte = 4
+
+	defaultChunkSize = 1 << 20
+	chunkIDSize      = 16
+	manifestSize     = chunkIDSize + 4 + 8 + 4
+)
+
+var chunkKeyPrefix = []byte("\xffchunk/")
+
+// isValue reports whether an item holds a value, either directly or as a
+// chunk manifest.
+func isValue(meta byte) bool {
+	return meta == 0 || meta == chunkedMeta
+}
+
+func isChunkKey(k []byte) bool {
+	return len(k) == len(chunkKeyPrefix)+chunkIDSize+4 && bytes.HasPrefix(k, chunkKeyPrefix)
+}
+
+func chunkKey(id []byte, index uint32) []byte {
+	k := append(bytes.Clone(chunkKeyPrefix), id...)
+	return binary.BigEndian.AppendUint32(k, index)
+}
+
+type manifest struct {
+	id     []byte
+	chunks uint32
+	size   uint64
+	crc    uint32
+}
+
+func (m manifest) encode() []byte {
+	b := append(make([]byte, 0, manifestSize), m.id...)
+	b = binary.BigEndian.AppendUint32(b, m.chunks)
+	b = binary.BigEndian.AppendUint64(b, m.size)
+	return binary.BigEndian.AppendUint32(b, m.crc)
+}
+
+func decodeManifest(b []byte) (manifest, error) {
+	if len(b) != manifestSize {
+		return manifest{}, storageErrors.NewCorrupted(errors.New("chunk manifest is truncated"))
+	}
+
+	return manifest{
+		id:     bytes.Clone(b[:chunkIDSize]),
+		chunks: binary.BigEndian.Uint32(b[chunkIDSize:]),
+		size:   binary.BigEndian.Uint64(b[chunkIDSize+4:]),
+		crc:    binary.BigEndian.Uint32(b[chunkIDSize+12:]),
+	}, nil
+}
+
+func (m manifest) keys() [][]byte {
+	keys := make([][]byte, 0, m.chunks)
+	for i := range m.chunks {
+		keys = append(keys, chunkKey(m.id, i))
+	}
+
+	return keys
+}
+
+// readValue calls fn with the value of item, reassembling a chunked value
+// from txn.
+func readValue(txn *badger.Txn, item *badger.Item, fn func(val []byte) error) error {
+	if item.UserMeta() != chunkedMeta {
+		return item.Value(fn)
+	}
+
+	raw, err := item.ValueCopy(nil)
+	if err != nil {
+		return err
+	}
+	val, err := readChunks(raw, txn.Get)
+	if err != nil {
+		return fmt.Errorf("key %q: %w", item.Key(), err)
+	}
+
+	return fn(val)
+}
+
+// readChunks reassembles the value of the manifest raw from the chunks get
+// returns.
+func readChunks(raw []byte, get func(k []byte) (*badger.Item, error)) ([]byte, error) {
+	m, err := decodeManifest(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	val := make([]byte, 0, m.size)
+	for _, k := range m.keys() {
+		item, err := get(k)
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil, storageErrors.NewCorrupted(fmt.Errorf("chunk %x is missing", k[len(chunkKeyPrefix):]))
+		}
+		if err != nil {
+			return nil, err
+		}
+		err = item.Value(func(chunk []byte) error {
+			val = append(val, chunk...)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if uint64(len(val)) != m.size || crc32.ChecksumIEEE(val) != m.crc {
+		return nil, storageErrors.NewCorrupted(errors.New("chunked value checksum mismatch"))
+	}
+
+	return val, nil
+}
+
+// setEntry stores entry, deleting the chunks of the value it replaces.
+func setEntry(txn *badger.Txn, entry *badger.Entry) error {
+	if err := dropChunks(txn, entry.Key); err != nil {
+		return err
+	}
+
+	return txn.SetEntry(entry)
+}
+
+// deleteEntry deletes k together with the chunks of its value.
+func deleteEntry(txn *badger.Txn, k []byte) error {
+	if err := dropChunks(txn, k); err != nil {
+		return err
+	}
+
+	return txn.Delete(k)
+}
+
+// dropChunks deletes the chunks of the value stored under k, if it is
+// chunked.
+func dropChunks(txn *badger.Txn, k []byte) error {
+	item, err := txn.Get(k)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil
+	}
+	if err != nil || item.UserMeta() != chunkedMeta {
+		return err
+	}
+
+	raw, err := item.ValueCopy(nil)
+	if err != nil {
+		return err
+	}
+	m, err := decodeManifest(raw)
+	if err != nil {
+		return err
+	}
+	for _, k := range m.keys() {
+		if err := txn.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// chunkWrites writes the chunks of the entries of one write transaction.
+// Chunks are written in batches of their own before the transaction, so
+// large values do not hit Badger's transaction size limit, and become
+// visible with the manifest when the transaction commits.
+type chunkWrites struct {
+	db   *badger.DB
+	size int
+	// mu is held for reading from the first chunk written until finish, so
+	// CollectChunks does not remove chunks whose manifest is not committed
+	// yet.
+	mu *sync.RWMutex
+
+	locked  bool
+	written []manifest
+}
+
+func (p *provider[K, V]) chunkWrites() *chunkWrites {
+	return &chunkWrites{db: p.db, size: p.cfg.ChunkSize.OrElse(defaultChunkSize), mu: &p.chunkMu}
+}
+
+// entry returns the entry that stores v under k. expiresAt is a Unix time,
+// or zero.
+func (w *chunkWrites) entry(k []byte, v []byte, expiresAt uint64) (*badger.Entry, error) {
+	if w.size <= 0 || len(v) <= w.size {
+		entry := badger.NewEntry(k, v)
+		entry.ExpiresAt = expiresAt
+		return entry, nil
+	}
+
+	id := make([]byte, chunkIDSize)
+	rand.Read(id)
+	m := manifest{
+		id:     id,
+		chunks: uint32((len(v) + w.size - 1) / w.size),
+		size:   uint64(len(v)),
+		crc:    crc32.ChecksumIEEE(v),
+	}
+	if !w.locked {
+		w.mu.RLock()
+		w.locked = true
+	}
+	w.written = append(w.written, m)
+
+	wb := w.db.NewWriteBatch()
+	defer wb.Cancel()
+	for i, k := range m.keys() {
+		chunk := v[i*w.size : min((i+1)*w.size, len(v))]
+		entry := badger.NewEntry(k, chunk).WithMeta(chunkMeta)
+		entry.ExpiresAt = expiresAt
+		if err := wb.SetEntry(entry); err != nil {
+			return nil, err
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return nil, err
+	}
+
+	entry := badger.NewEntry(k, m.encode()).WithMeta(chunkedMeta)
+	entry.ExpiresAt = expiresAt
+	return entry, nil
+}
+
+// finish discards the chunks unless the transaction committed.
+func (w *chunkWrites) finish(err error) {
+	if err != nil {
+		w.discard()
+	}
+	w.written = nil
+	if w.locked {
+		w.mu.RUnlock()
+		w.locked = false
+	}
+}
+
+// discard deletes the chunks written so far, e.g. by an attempt of the
+// transaction that conflicted.
+func (w *chunkWrites) discard() {
+	if len(w.written) > 0 {
+		wb := w.db.NewWriteBatch()
+		defer wb.Cancel()
+		for _, m := range w.written {
+			for _, k := range m.keys() {
+				if wb.Delete(k) != nil {
+					break
+				}
+			}
+		}
+		// Chunks left behind are removed by CollectChunks.
+		_ = wb.Flush()
+	}
+	w.written = nil
+}
+
+// chunkKeys returns the chunk keys of the chunked values stored under keys.
+func (p *provider[K, V]) chunkKeys(keys [][]byte) ([][]byte, error) {
+	var chunks [][]byte
+	err := p.db.View(func(txn *badger.Txn) error {
+		for _, k := range keys {
+			item, err := txn.Get(k)
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if item.UserMeta() != chunkedMeta {
+				continue
+			}
+
+			raw, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			m, err := decodeManifest(raw)
+			if err != nil {
+				return err
+			}
+			chunks = append(chunks, m.keys()...)
+		}
+		return nil
+	})
+
+	return chunks, mapError(err)
+}
+
+// CollectChunks removes chunks that no manifest refers to, e.g. chunks of
+// writes that failed or of values removed with their whole prefix, and
+// returns how many it removed. Maintain runs it first.
+func (p *provider[K, V]) CollectChunks() (int, error) {
+	if p.cfg.ReadOnly {
+		return 0, storageErrors.ReadOnly
+	}
+
+	p.chunkMu.Lock()
+	defer p.chunkMu.Unlock()
+
+	used := map[string]bool{}
+	var orphans [][]byte
+	err := p.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			if item.UserMeta() != chunkedMeta {
+				continue
+			}
+			raw, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if m, err := decodeManifest(raw); err == nil {
+				used[string(m.id)] = true
+			}
+		}
+
+		opts.Prefix = chunkKeyPrefix
+		chunks := txn.NewIterator(opts)
+		defer chunks.Close()
+		for chunks.Rewind(); chunks.Valid(); chunks.Next() {
+			item := chunks.Item()
+			if item.UserMeta() != chunkMeta || !isChunkKey(item.Key()) {
+				continue
+			}
+			if id := item.Key()[len(chunkKeyPrefix) : len(chunkKeyPrefix)+chunkIDSize]; !used[string(id)] {
+				orphans = append(orphans, item.KeyCopy(nil))
+			}
+		}
+		return nil
+	})
+	if err != nil || len(orphans) == 0 {
+		return 0, mapError(err)
+	}
+
+	wb := p.db.NewWriteBatch()
+	defer wb.Cancel()
+	for _, k := range orphans {
+		if err := wb.Delete(k); err != nil {
+			return 0, mapError(err)
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return 0, mapError(err)
+	}
+	p.lastWrite.Store(time.Now().UnixNano())
+
+	return len(orphans), nil
+}
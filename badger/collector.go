@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !nobadger && !noprometheus
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package badger
+
+import (
+	"github.com/dgraph-io/ristretto/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"strconv"
+	"time"
+)
+
+const metricsNamespace = "storage_badger"
+
+// Collector returns a Prometheus collector for the internals of the database:
+// LSM levels, pending compactions, value log size, cache hit rates, value
+// log GC runs and expiration sweeps.
+func (p *provider[K, V]) Collector() prometheus.Collector {
+	return &collector[K, V]{provider: p}
+}
+
+var (
+	levelLabels = []string{"level"}
+	cacheLabels = []string{"cache"}
+
+	lsmSizeDesc            = newDesc("lsm_size_bytes", "Size of the LSM tree files.", nil)
+	vlogSizeDesc           = newDesc("vlog_size_bytes", "Size of the value log files.", nil)
+	levelTablesDesc        = newDesc("level_tables", "Number of tables in the LSM level.", levelLabels)
+	levelSizeDesc          = newDesc("level_size_bytes", "Size of the LSM level.", levelLabels)
+	levelTargetSizeDesc    = newDesc("level_target_size_bytes", "Target size of the LSM level.", levelLabels)
+	levelScoreDesc         = newDesc("level_compaction_score", "Compaction score of the LSM level, the level is compacted at 1 or more.", levelLabels)
+	levelStaleDesc         = newDesc("level_stale_data_bytes", "Stale data in the LSM level that compaction would drop.", levelLabels)
+	pendingCompactionsDesc = newDesc("pending_compactions", "Number of LSM levels with a compaction score of 1 or more.", nil)
+	cacheHitsDesc          = newDesc("cache_hits_total", "Cache hits.", cacheLabels)
+	cacheMissesDesc        = newDesc("cache_misses_total", "Cache misses.", cacheLabels)
+	cacheHitRatioDesc      = newDesc("cache_hit_ratio", "Ratio of cache hits to lookups.", cacheLabels)
+	cacheEvictionsDesc     = newDesc("cache_evictions_total", "Keys evicted from the cache.", cacheLabels)
+	gcRunsDesc             = newDesc("vlog_gc_runs_total", "Value log GC runs.", nil)
+	gcRewritesDesc         = newDesc("vlog_gc_rewrites_total", "Value log GC runs that rewrote a file.", nil)
+	gcFailuresDesc         = newDesc("vlog_gc_failures_total", "Value log GC runs that failed.", nil)
+	gcDurationDesc         = newDesc("vlog_gc_duration_seconds_total", "Time spent in value log GC.", nil)
+	gcLastRunDesc          = newDesc("vlog_gc_last_run_timestamp_seconds", "Start of the last value log GC run.", nil)
+	sweepsDesc             = newDesc("expiration_sweeps_total", "Expiration sweeps.", nil)
+	expiredDesc            = newDesc("expired_total", "Expired entries removed by sweeps.", nil)
+	lastSweepExpiredDesc   = newDesc("expiration_last_sweep_expired", "Expired entries removed by the last sweep.", nil)
+	sweepDurationDesc      = newDesc("expiration_sweep_duration_seconds_total", "Time spent in expiration sweeps.", nil)
+	sweepLastRunDesc       = newDesc("expiration_last_sweep_timestamp_seconds", "Start of the last expiration sweep.", nil)
+)
+
+func newDesc(name string, help string, labels []string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", name), help, labels, nil)
+}
+
+type collector[K any, V any] struct {
+	provider *provider[K, V]
+}
+
+func (c *collector[K, V]) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		lsmSizeDesc, vlogSizeDesc,
+		levelTablesDesc, levelSizeDesc, levelTargetSizeDesc, levelScoreDesc, levelStaleDesc, pendingCompactionsDesc,
+		cacheHitsDesc, cacheMissesDesc, cacheHitRatioDesc, cacheEvictionsDesc,
+		gcRunsDesc, gcRewritesDesc, gcFailuresDesc, gcDurationDesc, gcLastRunDesc,
+		sweepsDesc, expiredDesc, lastSweepExpiredDesc, sweepDurationDesc, sweepLastRunDesc,
+	} {
+		ch <- desc
+	}
+}
+
+func (c *collector[K, V]) Collect(ch chan<- prometheus.Metric) {
+	db := c.provider.db
+	if db == nil || db.IsClosed() {
+		return
+	}
+
+	lsm, vlog := db.Size()
+	ch <- prometheus.MustNewConstMetric(lsmSizeDesc, prometheus.GaugeValue, float64(lsm))
+	ch <- prometheus.MustNewConstMetric(vlogSizeDesc, prometheus.GaugeValue, float64(vlog))
+
+	pending := 0
+	for _, level := range db.Levels() {
+		l := strconv.Itoa(level.Level)
+		ch <- prometheus.MustNewConstMetric(levelTablesDesc, prometheus.GaugeValue, float64(level.NumTables), l)
+		ch <- prometheus.MustNewConstMetric(levelSizeDesc, prometheus.GaugeValue, float64(level.Size), l)
+		ch <- prometheus.MustNewConstMetric(levelTargetSizeDesc, prometheus.GaugeValue, float64(level.TargetSize), l)
+		ch <- prometheus.MustNewConstMetric(levelScoreDesc, prometheus.GaugeValue, level.Score, l)
+		ch <- prometheus.MustNewConstMetric(levelStaleDesc, prometheus.GaugeValue, float64(level.StaleDatSize), l)
+		if level.Score >= 1 {
+			pending++
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(pendingCompactionsDesc, prometheus.GaugeValue, float64(pending))
+
+	collectCache(ch, "block", db.BlockCacheMetrics())
+	collectCache(ch, "index", db.IndexCacheMetrics())
+
+	gc := &c.provider.gc
+	ch <- prometheus.MustNewConstMetric(gcRunsDesc, prometheus.CounterValue, float64(gc.runs.Load()))
+	ch <- prometheus.MustNewConstMetric(gcRewritesDesc, prometheus.CounterValue, float64(gc.rewrites.Load()))
+	ch <- prometheus.MustNewConstMetric(gcFailuresDesc, prometheus.CounterValue, float64(gc.failures.Load()))
+	ch <- prometheus.MustNewConstMetric(gcDurationDesc, prometheus.CounterValue, time.Duration(gc.duration.Load()).Seconds())
+	if lastRun := gc.lastRun.Load(); lastRun > 0 {
+		ch <- prometheus.MustNewConstMetric(gcLastRunDesc, prometheus.GaugeValue, float64(lastRun)/float64(time.Second))
+	}
+
+	expiration := &c.provider.expiration
+	ch <- prometheus.MustNewConstMetric(sweepsDesc, prometheus.CounterValue, float64(expiration.sweeps.Load()))
+	ch <- prometheus.MustNewConstMetric(expiredDesc, prometheus.CounterValue, float64(expiration.expired.Load()))
+	ch <- prometheus.MustNewConstMetric(lastSweepExpiredDesc, prometheus.GaugeValue, float64(expiration.lastExpired.Load()))
+	ch <- prometheus.MustNewConstMetric(sweepDurationDesc, prometheus.CounterValue, time.Duration(expiration.duration.Load()).Seconds())
+	if lastRun := expiration.lastRun.Load(); lastRun > 0 {
+		ch <- prometheus.MustNewConstMetric(sweepLastRunDesc, prometheus.GaugeValue, float64(lastRun)/float64(time.Second))
+	}
+}
+
+func collectCache(ch chan<- prometheus.Metric, cache string, metrics *ristretto.Metrics) {
+	if metrics == nil {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(cacheHitsDesc, prometheus.CounterValue, float64(metrics.Hits()), cache)
+	ch <- prometheus.MustNewConstMetric(cacheMissesDesc, prometheus.CounterValue, float64(metrics.Misses()), cache)
+	ch <- prometheus.MustNewConstMetric(cacheHitRatioDesc, prometheus.GaugeValue, metrics.Ratio(), cache)
+	ch <- prometheus.MustNewConstMetric(cacheEvictionsDesc, prometheus.CounterValue, float64(metrics.KeysEvicted()), cache)
+}
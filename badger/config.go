@@ -0,0 +1,274 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package badger
+
+import (
+	"errors"
+	"fmt"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage/codec"
+	"github.com/rlshukhov/storage/integrity"
+	"github.com/rlshukhov/storage/kv"
+	"log/slog"
+	"time"
+)
+
+type Config struct {
+	DirectoryPath nullable.Nullable[string] `yaml:"db_path"`
+	InMemory      bool                      `yaml:"in_memory,omitempty"`
+	ReadOnly      bool                      `yaml:"read_only,omitempty"`
+
+	MaxReferenceDepth  int           `yaml:"max_reference_depth,omitempty"`
+	TransactionTimeout time.Duration `yaml:"transaction_timeout,omitempty"`
+
+	ConflictRetries nullable.Nullable[int] `yaml:"conflict_retries"`
+	StrictSchema    bool                   `yaml:"strict_schema,omitempty"`
+	Codec           string                 `yaml:"codec,omitempty"`
+	KeyEncoding     string                 `yaml:"key_encoding,omitempty"`
+	// ChunkSize splits encoded values above it into chunks, so values are not
+	// limited by the value log file size. It defaults to 1 MiB; zero disables
+	// chunking.
+	ChunkSize nullable.Nullable[int] `yaml:"chunk_size"`
+	// ValueLogFileSize is the size of Badger's value log files, which limits
+	// the size of a single value or chunk. It defaults to 1 GiB.
+	ValueLogFileSize int64 `yaml:"value_log_file_size,omitempty"`
+
+	Integrity   nullable.Nullable[integrity.Config]  `yaml:"integrity"`
+	Maintenance nullable.Nullable[MaintenanceConfig] `yaml:"maintenance"`
+	Snapshot    nullable.Nullable[SnapshotConfig]    `yaml:"snapshot"`
+	Expiration  nullable.Nullable[ExpirationConfig]  `yaml:"expiration"`
+	WriteQueue  nullable.Nullable[WriteQueueConfig]  `yaml:"write_queue"`
+	// Consistency decides whether reads commit the write queue first. It
+	// defaults to kv.Strict.
+	Consistency kv.Consistency `yaml:"consistency,omitempty"`
+
+	Logger *slog.Logger `yaml:"-"`
+}
+
+const (
+	DecimalKeys = "decimal"
+	BinaryKeys  = "binary"
+	// UUIDKeys stores string keys that are canonical UUIDs as 16 bytes.
+	UUIDKeys = "uuid"
+)
+
+const (
+	minValueLogFileSize     = 1 << 20
+	maxValueLogFileSize     = 2<<30 - 1
+	defaultValueLogFileSize = 1<<30 - 1
+)
+
+func (c Config) Validate() error {
+	var errs []error
+	if !c.InMemory && c.DirectoryPath.IsNull() {
+		errs = append(errs, errors.New("db_path is required unless in_memory is set"))
+	}
+	if c.InMemory && c.DirectoryPath.HasValue() {
+		errs = append(errs, errors.New("db_path and in_memory are mutually exclusive"))
+	}
+	if c.InMemory && c.ReadOnly {
+		errs = append(errs, errors.New("in-memory database cannot be read-only"))
+	}
+	if c.MaxReferenceDepth < 0 {
+		errs = append(errs, errors.New("max_reference_depth must not be negative"))
+	}
+	if c.TransactionTimeout < 0 {
+		errs = append(errs, errors.New("transaction_timeout must not be negative"))
+	}
+	if c.ConflictRetries.OrElse(0) < 0 {
+		errs = append(errs, errors.New("conflict_retries must not be negative"))
+	}
+	if c.ValueLogFileSize != 0 && (c.ValueLogFileSize < minValueLogFileSize || c.ValueLogFileSize > maxValueLogFileSize) {
+		errs = append(errs, fmt.Errorf("value_log_file_size must be between %d and %d", minValueLogFileSize, maxValueLogFileSize))
+	}
+	vlogSize := c.ValueLogFileSize
+	if vlogSize == 0 {
+		vlogSize = defaultValueLogFileSize
+	}
+	if size := c.ChunkSize.OrElse(0); size < 0 || int64(size) > vlogSize {
+		errs = append(errs, fmt.Errorf("chunk_size must be between 0 and the value log file size %d", vlogSize))
+	}
+	if c.Codec != "" {
+		if _, err := codec.ByName(c.Codec); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.KeyEncoding != "" && c.KeyEncoding != DecimalKeys && c.KeyEncoding != BinaryKeys && c.KeyEncoding != UUIDKeys {
+		errs = append(errs, fmt.Errorf("key_encoding must be %q, %q or %q", DecimalKeys, BinaryKeys, UUIDKeys))
+	}
+	if c.Integrity.HasValue() {
+		if err := c.Integrity.GetValue().Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.Maintenance.HasValue() {
+		if c.ReadOnly {
+			errs = append(errs, errors.New("maintenance requires a writable database"))
+		}
+		if err := c.Maintenance.GetValue().Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.Snapshot.HasValue() {
+		if !c.InMemory {
+			errs = append(errs, errors.New("snapshot requires in_memory"))
+		}
+		if err := c.Snapshot.GetValue().Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.Expiration.HasValue() {
+		if c.ReadOnly {
+			errs = append(errs, errors.New("expiration requires a writable database"))
+		}
+		if err := c.Expiration.GetValue().Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := c.Consistency.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.WriteQueue.HasValue() {
+		if c.ReadOnly {
+			errs = append(errs, errors.New("write_queue requires a writable database"))
+		}
+		if err := c.WriteQueue.GetValue().Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// MaintenanceConfig schedules Maintain. With At set it runs at that local
+// time of day and then every Interval (24h by default); without At it runs
+// every Interval after Setup.
+type MaintenanceConfig struct {
+	At             string        `yaml:"at,omitempty"`
+	Interval       time.Duration `yaml:"interval,omitempty"`
+	FlattenWorkers int           `yaml:"flatten_workers,omitempty"`
+	GCDiscardRatio float64       `yaml:"gc_discard_ratio,omitempty"`
+}
+
+const (
+	defaultMaintenanceInterval = 24 * time.Hour
+	defaultFlattenWorkers      = 1
+	defaultGCDiscardRatio      = 0.5
+)
+
+func (c MaintenanceConfig) Validate() error {
+	var errs []error
+	if c.At != "" {
+		if _, err := time.Parse("15:04", c.At); err != nil {
+			errs = append(errs, errors.New("maintenance.at must be a time of day like 03:00"))
+		}
+	}
+	if c.Interval < 0 {
+		errs = append(errs, errors.New("maintenance.interval must not be negative"))
+	}
+	if c.At == "" && c.Interval == 0 {
+		errs = append(errs, errors.New("maintenance requires at or interval"))
+	}
+	if c.FlattenWorkers < 0 {
+		errs = append(errs, errors.New("maintenance.flatten_workers must not be negative"))
+	}
+	if c.GCDiscardRatio < 0 || c.GCDiscardRatio >= 1 {
+		errs = append(errs, errors.New("maintenance.gc_discard_ratio must be in [0, 1)"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// next returns the first run after now.
+func (c MaintenanceConfig) next(now time.Time) time.Time {
+	interval := c.Interval
+	if interval == 0 {
+		interval = defaultMaintenanceInterval
+	}
+	if c.At == "" {
+		return now.Add(interval)
+	}
+
+	at, _ := time.Parse("15:04", c.At)
+	next := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+	for !next.After(now) {
+		next = next.Add(interval)
+	}
+
+	return next
+}
+
+// SnapshotConfig persists an in-memory database to Path, every Interval and
+// on Shutdown, and loads it back in Setup. Writes after the last snapshot are
+// lost on a crash. Without Interval the snapshot is only written on Shutdown.
+type SnapshotConfig struct {
+	Path     string        `yaml:"path"`
+	Interval time.Duration `yaml:"interval,omitempty"`
+}
+
+func (c SnapshotConfig) Validate() error {
+	var errs []error
+	if c.Path == "" {
+		errs = append(errs, errors.New("snapshot.path is required"))
+	}
+	if c.Interval < 0 {
+		errs = append(errs, errors.New("snapshot.interval must not be negative"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// ExpirationConfig schedules SweepExpired every Interval. With IdleAfter set
+// a sweep only starts once nothing was written for IdleAfter, and stops
+// between batches as soon as something is.
+type ExpirationConfig struct {
+	Interval  time.Duration `yaml:"interval"`
+	BatchSize int           `yaml:"batch_size,omitempty"`
+	IdleAfter time.Duration `yaml:"idle_after,omitempty"`
+}
+
+func (c ExpirationConfig) Validate() error {
+	var errs []error
+	if c.Interval <= 0 {
+		errs = append(errs, errors.New("expiration.interval must be positive"))
+	}
+	if c.BatchSize < 0 {
+		errs = append(errs, errors.New("expiration.batch_size must not be negative"))
+	}
+	if c.IdleAfter < 0 {
+		errs = append(errs, errors.New("expiration.idle_after must not be negative"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// WriteQueueConfig makes Store and StoreWithTTL return once the value is
+// queued. Queued values are committed together every Interval (5ms by
+// default), or as soon as MaxBatch (1000 by default) are queued. With
+// kv.Strict consistency reads commit the queue first; with kv.Eventual they
+// see queued values once committed. Other writes commit the queue first, so
+// writes apply in order. Commit errors are passed to OnError and returned by the
+// next Flush or Shutdown. Queued values are lost on a crash.
+type WriteQueueConfig struct {
+	Interval time.Duration   `yaml:"interval,omitempty"`
+	MaxBatch int             `yaml:"max_batch,omitempty"`
+	OnError  func(err error) `yaml:"-"`
+}
+
+func (c WriteQueueConfig) Validate() error {
+	var errs []error
+	if c.Interval < 0 {
+		errs = append(errs, errors.New("write_queue.interval must not be negative"))
+	}
+	if c.MaxBatch < 0 {
+		errs = append(errs, errors.New("write_queue.max_batch must not be negative"))
+	}
+
+	return errors.Join(errs...)
+}
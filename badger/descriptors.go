@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !nobadger
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger/v4"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"sync"
+)
+
+// descriptorMeta marks the gob type descriptors of the gob-shared codec,
+// stored under "\xffgob/" | id uint64. Like chunk keys, descriptor keys do
+// not collide with keys.
+const descriptorMeta byte = 5
+
+var descriptorKeyPrefix = []byte("\xffgob/")
+
+func descriptorKey(id uint64) []byte {
+	return binary.BigEndian.AppendUint64(bytes.Clone(descriptorKeyPrefix), id)
+}
+
+// descriptors implements codec.Descriptors on the database of a provider.
+type descriptors struct {
+	db func() *badger.DB
+	// stored holds the descriptors stored by this process, so Clear can
+	// store them again for the encoders that already sent them.
+	stored sync.Map
+}
+
+func (d *descriptors) LoadDescriptor(id uint64) ([]byte, error) {
+	var descriptor []byte
+	err := d.db().View(func(txn *badger.Txn) error {
+		item, err := txn.Get(descriptorKey(id))
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return storageErrors.NewCorrupted(fmt.Errorf("gob descriptor %016x is missing", id))
+		}
+		if err != nil {
+			return err
+		}
+		descriptor, err = item.ValueCopy(nil)
+		return err
+	})
+
+	return descriptor, err
+}
+
+func (d *descriptors) StoreDescriptor(id uint64, descriptor []byte) error {
+	d.stored.Store(id, descriptor)
+
+	return d.store(id, descriptor)
+}
+
+func (d *descriptors) store(id uint64, descriptor []byte) error {
+	return d.db().Update(func(txn *badger.Txn) error {
+		k := descriptorKey(id)
+		if _, err := txn.Get(k); !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+		return txn.SetEntry(badger.NewEntry(k, descriptor).WithMeta(descriptorMeta))
+	})
+}
+
+// restore stores the descriptors again after the database was dropped.
+func (d *descriptors) restore() error {
+	var err error
+	d.stored.Range(func(id, descriptor any) bool {
+		err = d.store(id.(uint64), descriptor.([]byte))
+		return err == nil
+	})
+
+	return err
+}
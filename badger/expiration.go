@@ -0,0 +1,281 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !nobadger
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/dgraph-io/badger/v4"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"sync/atomic"
+	"time"
+)
+
+const defaultExpirationBatchSize = 1000
+
+type expirationStats struct {
+	sweeps      atomic.Uint64
+	expired     atomic.Uint64
+	lastExpired atomic.Uint64
+	duration    atomic.Int64
+	lastRun     atomic.Int64
+}
+
+// OnExpire registers fn to be called for every entry SweepExpired removes.
+// Badger hides expired entries on its own, so fn only sees the entries a
+// sweep finds, not the moment they expire.
+func (p *provider[K, V]) OnExpire(fn func(key K, value V)) error {
+	p.expireMu.Lock()
+	defer p.expireMu.Unlock()
+
+	p.onExpire = append(p.onExpire, fn)
+	return nil
+}
+
+// SweepExpired removes the expired entries in batches, calls the OnExpire
+// callbacks for them and returns how many were removed.
+func (p *provider[K, V]) SweepExpired() (int, error) {
+	return p.sweep(context.Background(), false)
+}
+
+// sweep runs one sweep. With idleOnly it stops between batches once
+// something else was written.
+func (p *provider[K, V]) sweep(ctx context.Context, idleOnly bool) (int, error) {
+	if p.db == nil || p.db.IsClosed() {
+		return 0, errors.New("database is not open")
+	}
+	if p.cfg.ReadOnly {
+		return 0, storageErrors.ReadOnly
+	}
+
+	p.sweepMu.Lock()
+	defer p.sweepMu.Unlock()
+
+	batchSize := p.cfg.Expiration.OrElse(ExpirationConfig{}).BatchSize
+	if batchSize == 0 {
+		batchSize = defaultExpirationBatchSize
+	}
+
+	start := time.Now()
+	expired := 0
+	var after []byte
+	var err error
+	for {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		if idleOnly && p.lastWrite.Load() != p.lastSweepWrite.Load() {
+			break
+		}
+
+		var batch []expiredItem
+		var done bool
+		batch, done, err = p.expiredBatch(after, batchSize)
+		if err != nil {
+			break
+		}
+		if len(batch) > 0 {
+			after = batch[len(batch)-1].key
+		}
+
+		var n int
+		n, err = p.removeExpired(batch)
+		expired += n
+		p.lastSweepWrite.Store(p.lastWrite.Load())
+		if err != nil || done {
+			break
+		}
+	}
+
+	p.expiration.sweeps.Add(1)
+	p.expiration.expired.Add(uint64(expired))
+	p.expiration.lastExpired.Store(uint64(expired))
+	p.expiration.duration.Add(int64(time.Since(start)))
+	p.expiration.lastRun.Store(start.UnixNano())
+	return expired, err
+}
+
+type expiredItem struct {
+	key    []byte
+	raw    []byte
+	chunks [][]byte
+}
+
+// expiredBatch returns up to limit entries after after whose latest version
+// expired, and whether the scan reached the end.
+func (p *provider[K, V]) expiredBatch(after []byte, limit int) ([]expiredItem, bool, error) {
+	var items []expiredItem
+	done := true
+	now := uint64(time.Now().Unix())
+	err := p.view(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.AllVersions = true
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		var last []byte
+		for it.Seek(after); it.Valid(); it.Next() {
+			item := it.Item()
+			if bytes.Equal(item.Key(), last) || (after != nil && bytes.Equal(item.Key(), after)) {
+				continue
+			}
+			last = item.KeyCopy(last[:0])
+
+			if !isValue(item.UserMeta()) || item.ExpiresAt() == 0 || item.ExpiresAt() > now {
+				continue
+			}
+			if len(items) == limit {
+				done = false
+				return nil
+			}
+			raw, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			expired := expiredItem{key: item.KeyCopy(nil), raw: raw}
+			if item.UserMeta() == chunkedMeta {
+				expired = expiredChunks(txn, expired)
+			}
+			items = append(items, expired)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, mapError(err)
+	}
+
+	return items, done, nil
+}
+
+// expiredChunks reads the chunks of an expired value, which txn.Get no
+// longer returns. The value is left empty when they can not be read.
+func expiredChunks(txn *badger.Txn, item expiredItem) expiredItem {
+	m, err := decodeManifest(item.raw)
+	if err != nil {
+		item.raw = nil
+		return item
+	}
+	item.chunks = m.keys()
+
+	opts := badger.DefaultIteratorOptions
+	opts.AllVersions = true
+	opts.Prefix = chunkKeyPrefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	item.raw, _ = readChunks(item.raw, func(k []byte) (*badger.Item, error) {
+		if it.Seek(k); !it.Valid() || !bytes.Equal(it.Item().Key(), k) {
+			return nil, badger.ErrKeyNotFound
+		}
+		return it.Item(), nil
+	})
+	return item
+}
+
+// removeExpired deletes the entries that are still expired and calls the
+// OnExpire callbacks for them.
+func (p *provider[K, V]) removeExpired(items []expiredItem) (int, error) {
+	if len(items) == 0 {
+		return 0, nil
+	}
+
+	var removed []expiredItem
+	err := p.update(func(txn *badger.Txn) error {
+		removed = removed[:0]
+		for _, item := range items {
+			if _, err := txn.Get(item.key); !errors.Is(err, badger.ErrKeyNotFound) {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err := txn.Delete(item.key); err != nil {
+				return err
+			}
+			for _, k := range item.chunks {
+				if err := txn.Delete(k); err != nil {
+					return err
+				}
+			}
+			removed = append(removed, item)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	p.expireMu.Lock()
+	callbacks := p.onExpire
+	p.expireMu.Unlock()
+	if len(callbacks) == 0 {
+		return len(removed), nil
+	}
+
+	for _, item := range removed {
+		key, err := p.byteToKey(item.key)
+		if err == nil {
+			var value V
+			if value, err = p.decodeFromBytes(item.key, item.raw); err == nil {
+				for _, fn := range callbacks {
+					fn(key, value)
+				}
+				continue
+			}
+		}
+		if p.cfg.Logger != nil {
+			p.cfg.Logger.Warn("badger could not decode expired entry", "key", item.key, "error", err)
+		}
+	}
+
+	return len(removed), nil
+}
+
+func (p *provider[K, V]) startExpiration() {
+	if p.cfg.Expiration.IsNull() {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	p.stopExpiration = func() {
+		cancel()
+		<-done
+	}
+
+	go func() {
+		defer close(done)
+
+		cfg := p.cfg.Expiration.GetValue()
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			lastWrite := p.lastWrite.Load()
+			idle := cfg.IdleAfter == 0 || lastWrite == p.lastSweepWrite.Load() || time.Since(time.Unix(0, lastWrite)) >= cfg.IdleAfter
+			if !idle {
+				continue
+			}
+			p.lastSweepWrite.Store(lastWrite)
+			if _, err := p.sweep(ctx, cfg.IdleAfter > 0); err != nil && ctx.Err() == nil && p.cfg.Logger != nil {
+				p.cfg.Logger.Error("badger expiration sweep failed", "error", err)
+			}
+		}
+	}()
+}
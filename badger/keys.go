@@ -0,0 +1,262 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !nobadger
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/google/uuid"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"slices"
+	"strconv"
+)
+
+const (
+	// binaryKeyMarker starts binary uint64 keys. Decimal keys start with a
+	// digit, so both encodings can be told apart and read at any time.
+	binaryKeyMarker byte = 0x00
+	binaryKeySize        = 1 + 8
+	// Binary UUID keys start with the same marker, followed by 16 bytes.
+	uuidKeySize = 1 + 16
+)
+
+func encodeUint64Key(n uint64, binaryKeys bool) []byte {
+	if !binaryKeys {
+		return strconv.AppendUint(nil, n, 10)
+	}
+
+	b := make([]byte, binaryKeySize)
+	b[0] = binaryKeyMarker
+	binary.BigEndian.PutUint64(b[1:], n)
+	return b
+}
+
+func decodeUint64Key(b []byte) (uint64, error) {
+	if isBinaryKey(b) {
+		return binary.BigEndian.Uint64(b[1:]), nil
+	}
+
+	n, err := strconv.ParseUint(string(b), 10, 64)
+	if err != nil {
+		return 0, errors.New("failed to convert bytes to uint64")
+	}
+	return n, nil
+}
+
+func isBinaryKey(b []byte) bool {
+	return len(b) == binaryKeySize && b[0] == binaryKeyMarker
+}
+
+// encodeStringKey returns the 16 bytes of s behind the marker when s is a
+// canonical UUID and uuidKeys is set, and s otherwise.
+func encodeStringKey(s string, uuidKeys bool) []byte {
+	if id, ok := parseCanonicalUUID(s); ok && uuidKeys {
+		return append([]byte{binaryKeyMarker}, id[:]...)
+	}
+
+	return []byte(s)
+}
+
+func decodeStringKey(b []byte, uuidKeys bool) string {
+	if uuidKeys && isUUIDKey(b) {
+		return uuid.UUID(b[1:]).String()
+	}
+
+	return string(b)
+}
+
+func isUUIDKey(b []byte) bool {
+	return len(b) == uuidKeySize && b[0] == binaryKeyMarker
+}
+
+// parseCanonicalUUID accepts only the lower case hyphenated form, so decoded
+// keys are equal to the stored ones.
+func parseCanonicalUUID(s string) (uuid.UUID, bool) {
+	if len(s) != 36 {
+		return uuid.UUID{}, false
+	}
+
+	id, err := uuid.Parse(s)
+	return id, err == nil && id.String() == s
+}
+
+// MigrateKeys rewrites uint64 keys, and the keys references point to, that
+// were stored in the other key encoding than the configured one; with uuid
+// key encoding, it rewrites UUID keys stored as strings. Keys written in the
+// configured encoding win over legacy copies of the same key. Run it right
+// after changing key_encoding: until then legacy keys are only seen by
+// iteration.
+func (p *provider[K, V]) MigrateKeys() (int, error) {
+	if p.cfg.ReadOnly {
+		return 0, storageErrors.ReadOnly
+	}
+	if !p.uint64Keys && !p.uuidKeys {
+		return 0, nil
+	}
+
+	var keys [][]byte
+	err := p.view(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			stale, err := p.staleKeys(item)
+			if err != nil {
+				return err
+			}
+			if stale {
+				keys = append(keys, item.KeyCopy(nil))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, mapError(err)
+	}
+
+	migrated := 0
+	for batch := range slices.Chunk(keys, reencodeBatchSize) {
+		n := 0
+		w := p.chunkWrites()
+		err := p.update(func(txn *badger.Txn) error {
+			w.discard()
+			n = 0
+			for _, k := range batch {
+				item, err := txn.Get(k)
+				if errors.Is(err, badger.ErrKeyNotFound) {
+					continue
+				} else if err != nil {
+					return err
+				}
+				if err := p.migrateKey(txn, item, w); err != nil {
+					return fmt.Errorf("key %q: %w", k, err)
+				}
+				n++
+			}
+			return nil
+		})
+		w.finish(err)
+		if err != nil {
+			return migrated, err
+		}
+		migrated += n
+	}
+
+	return migrated, nil
+}
+
+func (p *provider[K, V]) staleKeys(item *badger.Item) (bool, error) {
+	if item.UserMeta() == chunkMeta || item.UserMeta() == descriptorMeta || item.UserMeta() == watchMeta {
+		return false, nil
+	}
+	if p.staleKey(item.Key()) {
+		return true, nil
+	}
+	if !isReference(item.UserMeta()) {
+		return false, nil
+	}
+
+	targets, err := decodeReference(item)
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(targets, p.staleKey), nil
+}
+
+func (p *provider[K, V]) staleKey(b []byte) bool {
+	if p.uuidKeys {
+		_, ok := parseCanonicalUUID(string(b))
+		return ok
+	}
+
+	return isBinaryKey(b) != p.binaryKeys
+}
+
+// recodeKey returns b in the configured key encoding.
+func (p *provider[K, V]) recodeKey(b []byte) ([]byte, error) {
+	if p.uuidKeys {
+		return encodeStringKey(string(b), true), nil
+	}
+
+	n, err := decodeUint64Key(b)
+	if err != nil {
+		return nil, err
+	}
+	return encodeUint64Key(n, p.binaryKeys), nil
+}
+
+func (p *provider[K, V]) migrateKey(txn *badger.Txn, item *badger.Item, w *chunkWrites) error {
+	old := item.KeyCopy(nil)
+	k, err := p.recodeKey(old)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(k, old) {
+		if _, err := txn.Get(k); err == nil {
+			return deleteEntry(txn, old)
+		} else if !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+	}
+
+	var entry *badger.Entry
+	if isReference(item.UserMeta()) {
+		targets, err := decodeReference(item)
+		if err != nil {
+			return err
+		}
+		for i, t := range targets {
+			if targets[i], err = p.recodeKey(t); err != nil {
+				return err
+			}
+		}
+
+		if len(targets) == 1 {
+			entry = badger.NewEntry(k, targets[0]).WithMeta(referenceMeta)
+		} else {
+			var buf bytes.Buffer
+			if err := gob.NewEncoder(&buf).Encode(targets); err != nil {
+				return err
+			}
+			entry = badger.NewEntry(k, buf.Bytes()).WithMeta(referenceSetMeta)
+		}
+		entry.ExpiresAt = item.ExpiresAt()
+	} else {
+		var value V
+		err := readValue(txn, item, func(raw []byte) error {
+			value, err = p.decodeFromBytes(old, raw)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		v, err := p.encodeToBytes(k, value)
+		if err != nil {
+			return err
+		}
+		if entry, err = w.entry(k, v, item.ExpiresAt()); err != nil {
+			return err
+		}
+	}
+
+	if !bytes.Equal(k, old) {
+		if err := deleteEntry(txn, old); err != nil {
+			return err
+		}
+	}
+	return setEntry(txn, entry)
+}
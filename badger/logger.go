@@ -0,0 +1,41 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !nobadger
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package badger
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+func (l slogLogger) Errorf(format string, args ...any) {
+	l.logger.Error(message(format, args))
+}
+
+func (l slogLogger) Warningf(format string, args ...any) {
+	l.logger.Warn(message(format, args))
+}
+
+func (l slogLogger) Infof(format string, args ...any) {
+	l.logger.Info(message(format, args))
+}
+
+func (l slogLogger) Debugf(format string, args ...any) {
+	l.logger.Debug(message(format, args))
+}
+
+func message(format string, args []any) string {
+	return strings.TrimSpace(fmt.Sprintf(format, args...))
+}
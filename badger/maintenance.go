@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !nobadger
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package badger
+
+import (
+	"context"
+	"errors"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"time"
+)
+
+// Maintain removes orphaned chunks with CollectChunks, flattens the LSM tree
+// into one level, which drops deleted and overwritten versions, and then runs
+// value log GC until no file has enough garbage left. Writes should be light
+// while it runs.
+func (p *provider[K, V]) Maintain(ctx context.Context) error {
+	if p.db == nil || p.db.IsClosed() {
+		return errors.New("database is not open")
+	}
+	if p.cfg.ReadOnly {
+		return storageErrors.ReadOnly
+	}
+
+	p.maintainMu.Lock()
+	defer p.maintainMu.Unlock()
+
+	if _, err := p.CollectChunks(); err != nil {
+		return err
+	}
+
+	cfg := p.cfg.Maintenance.OrElse(MaintenanceConfig{})
+	workers := cfg.FlattenWorkers
+	if workers == 0 {
+		workers = defaultFlattenWorkers
+	}
+	if err := p.db.Flatten(workers); err != nil {
+		return mapError(err)
+	}
+	if p.cfg.InMemory {
+		return nil
+	}
+
+	ratio := cfg.GCDiscardRatio
+	if ratio == 0 {
+		ratio = defaultGCDiscardRatio
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rewritten, err := p.RunValueLogGC(ratio)
+		if err != nil {
+			return mapError(err)
+		}
+		if !rewritten {
+			return nil
+		}
+	}
+}
+
+func (p *provider[K, V]) startMaintenance() {
+	if p.cfg.Maintenance.IsNull() {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	p.stopMaintenance = func() {
+		cancel()
+		<-done
+	}
+
+	go func() {
+		defer close(done)
+
+		cfg := p.cfg.Maintenance.GetValue()
+		for {
+			timer := time.NewTimer(time.Until(cfg.next(time.Now())))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+
+			if err := p.Maintain(ctx); err != nil && ctx.Err() == nil && p.cfg.Logger != nil {
+				p.cfg.Logger.Error("badger maintenance failed", "error", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !nobadger
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package badger
+
+import (
+	"errors"
+	"github.com/dgraph-io/badger/v4"
+	"sync/atomic"
+	"time"
+)
+
+type gcStats struct {
+	runs     atomic.Uint64
+	rewrites atomic.Uint64
+	failures atomic.Uint64
+	duration atomic.Int64
+	lastRun  atomic.Int64
+}
+
+// RunValueLogGC runs one round of value log garbage collection and records it
+// in the metrics. rewritten is false if no file had enough garbage to be
+// rewritten.
+func (p *provider[K, V]) RunValueLogGC(discardRatio float64) (rewritten bool, err error) {
+	if p.db == nil || p.db.IsClosed() {
+		return false, errors.New("database is not open")
+	}
+
+	start := time.Now()
+	err = p.db.RunValueLogGC(discardRatio)
+	p.gc.runs.Add(1)
+	p.gc.duration.Add(int64(time.Since(start)))
+	p.gc.lastRun.Store(start.UnixNano())
+
+	switch {
+	case err == nil:
+		p.gc.rewrites.Add(1)
+		return true, nil
+	case errors.Is(err, badger.ErrNoRewrite):
+		return false, nil
+	default:
+		p.gc.failures.Add(1)
+		return false, err
+	}
+}
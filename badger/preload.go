@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !nobadger
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package badger
+
+import (
+	"errors"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// Preload reads the values of keys so the blocks and value log pages they
+// live in are in Badger's block cache and the OS page cache before the first
+// request needs them. Missing keys are skipped. It returns how many values
+// were read.
+func (p *provider[K, V]) Preload(keys []K) (int, error) {
+	loaded := 0
+	err := p.view(func(txn *badger.Txn) error {
+		for _, key := range keys {
+			k, err := p.keyToByte(key)
+			if err != nil {
+				return err
+			}
+
+			item, err := txn.Get(k)
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			if err := readValue(txn, item, func([]byte) error { return nil }); err != nil {
+				return err
+			}
+			loaded++
+		}
+		return nil
+	})
+
+	return loaded, mapError(err)
+}
+
+// PreloadPrefix reads the values of every key starting with prefix, like
+// Preload.
+func (p *provider[K, V]) PreloadPrefix(prefix K) (int, error) {
+	pr, err := p.keyToByte(prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	loaded := 0
+	err = p.view(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = pr
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			if !isValue(item.UserMeta()) {
+				continue
+			}
+			if err := readValue(txn, item, func([]byte) error { return nil }); err != nil {
+				return err
+			}
+			loaded++
+		}
+		return nil
+	})
+
+	return loaded, mapError(err)
+}
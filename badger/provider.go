@@ -1,5 +1,7 @@
 // SPDX-License-Identifier: MPL-2.0
 
+//go:build !nobadger
+
 /*
  * This Source Code Form is subject to the terms of the Mozilla Public
  * License, v. 2.0. If a copy of the MPL was not distributed with this
@@ -10,26 +12,124 @@ package badger
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"encoding/gob"
 	"errors"
+	"fmt"
 	"github.com/dgraph-io/badger/v4"
-	"github.com/rlshukhov/nullable"
+	"github.com/dgraph-io/badger/v4/pb"
+	"github.com/dgraph-io/ristretto/v2/z"
+	"github.com/rlshukhov/storage/codec"
 	storageErrors "github.com/rlshukhov/storage/errors"
-	"strconv"
+	"github.com/rlshukhov/storage/integrity"
+	"github.com/rlshukhov/storage/internal/bufpool"
+	"github.com/rlshukhov/storage/internal/chunked"
+	"github.com/rlshukhov/storage/internal/schema"
+	"github.com/rlshukhov/storage/internal/timeout"
+	"github.com/rlshukhov/storage/kv"
+	"hash/crc32"
+	"io"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-type Config struct {
-	DirectoryPath nullable.Nullable[string] `yaml:"db_path"`
-	InMemory      bool                      `yaml:"in_memory,omitempty"`
-}
+const (
+	referenceMeta    byte = 1
+	referenceSetMeta byte = 2
+	valueMagic       byte = 0xB5
+	schemaMagic      byte = 0xB6
+	protoMagic       byte = 0xB7
+	taggedMagic      byte = 0xB8
+	valueHeaderSize       = 1 + 8 + 4
+
+	defaultMaxReferenceDepth = 8
+	defaultConflictRetries   = 3
+	reencodeBatchSize        = 256
+	restoreMaxPendingWrites  = 256
+)
 
 type provider[K any, V any] struct {
-	cfg Config
-	db  *badger.DB
+	cfg         Config
+	db          *badger.DB
+	fingerprint uint64
+	schema      []byte
+	codec       codec.Codec
+	sharedGob   codec.Codec
+	descriptors *descriptors
+	signer      *integrity.Signer
+	uint64Keys  bool
+	binaryKeys  bool
+	uuidKeys    bool
+	lastWrite   atomic.Int64
+	gc          gcStats
+	mu          sync.Mutex
+	chunkMu     sync.RWMutex
+
+	maintainMu      sync.Mutex
+	stopMaintenance func()
+
+	snapshotMu    sync.Mutex
+	lastSnapshot  int64
+	stopSnapshots func()
+
+	expireMu       sync.Mutex
+	onExpire       []func(key K, value V)
+	sweepMu        sync.Mutex
+	lastSweepWrite atomic.Int64
+	expiration     expirationStats
+	stopExpiration func()
+	queue          *writeQueue
+	stopWriteQueue func() error
 }
 
 func New[K ~string | ~uint64, V any](cfg Config) (*provider[K, V], error) {
-	p := &provider[K, V]{cfg: cfg}
+	c := codec.For[V]()
+	if cfg.Codec != "" {
+		var err error
+		if c, err = codec.ByName(cfg.Codec); err != nil {
+			return nil, err
+		}
+		if c == codec.Proto && !codec.IsProto[V]() {
+			return nil, fmt.Errorf("proto codec requires a proto.Message value type, got %v", reflect.TypeFor[V]())
+		}
+	}
+
+	var zero K
+	uint64Keys := reflect.ValueOf(zero).Kind() == reflect.Uint64
+	switch cfg.KeyEncoding {
+	case "", DecimalKeys:
+	case BinaryKeys:
+		if !uint64Keys {
+			return nil, errors.New("binary key encoding requires uint64 keys")
+		}
+	case UUIDKeys:
+		if uint64Keys {
+			return nil, errors.New("uuid key encoding requires string keys")
+		}
+	default:
+		return nil, fmt.Errorf("unknown key encoding %q", cfg.KeyEncoding)
+	}
+
+	p := &provider[K, V]{
+		cfg:         cfg,
+		fingerprint: typeFingerprint[V](),
+		codec:       c,
+		uint64Keys:  uint64Keys,
+		binaryKeys:  cfg.KeyEncoding == BinaryKeys,
+		uuidKeys:    cfg.KeyEncoding == UUIDKeys,
+	}
+	p.descriptors = &descriptors{db: func() *badger.DB { return p.db }}
+	p.sharedGob = codec.NewSharedGob(p.descriptors)
+	if c == codec.SharedGob {
+		p.codec = p.sharedGob
+	}
+	if cfg.StrictSchema && p.codec != codec.Proto {
+		p.schema = schema.Encode(schema.Describe(reflect.TypeFor[V]()))
+	}
 	return p, nil
 }
 
@@ -37,12 +137,59 @@ func (p *provider[K, V]) Setup() error {
 	if !p.cfg.InMemory && p.cfg.DirectoryPath.IsNull() {
 		return errors.New("directory path is null")
 	}
+	if p.cfg.InMemory && p.cfg.ReadOnly {
+		return errors.New("in-memory database cannot be read-only")
+	}
+	if p.cfg.Integrity.HasValue() {
+		signer, err := p.cfg.Integrity.GetValue().Signer()
+		if err != nil {
+			return err
+		}
+		p.signer = signer
+	}
+	if p.cfg.Maintenance.HasValue() {
+		if err := p.cfg.Maintenance.GetValue().Validate(); err != nil {
+			return err
+		}
+	}
+	if p.cfg.Snapshot.HasValue() {
+		if !p.cfg.InMemory {
+			return errors.New("snapshot requires in_memory")
+		}
+		if err := p.cfg.Snapshot.GetValue().Validate(); err != nil {
+			return err
+		}
+	}
+	if p.cfg.Expiration.HasValue() {
+		if p.cfg.ReadOnly {
+			return errors.New("expiration requires a writable database")
+		}
+		if err := p.cfg.Expiration.GetValue().Validate(); err != nil {
+			return err
+		}
+	}
+	if p.cfg.WriteQueue.HasValue() {
+		if p.cfg.ReadOnly {
+			return errors.New("write queue requires a writable database")
+		}
+		if err := p.cfg.WriteQueue.GetValue().Validate(); err != nil {
+			return err
+		}
+	}
+
+	var logger badger.Logger
+	if p.cfg.Logger != nil {
+		logger = slogLogger{logger: p.cfg.Logger.With("provider", "badger")}
+	}
 
 	var options badger.Options
 	if p.cfg.InMemory {
-		options = badger.DefaultOptions("").WithInMemory(true).WithLogger(nil)
+		options = badger.DefaultOptions("").WithInMemory(true).WithLogger(logger)
 	} else {
-		options = badger.DefaultOptions(p.cfg.DirectoryPath.GetValue()).WithLogger(nil)
+		options = badger.DefaultOptions(p.cfg.DirectoryPath.GetValue()).WithLogger(logger).WithReadOnly(p.cfg.ReadOnly)
+	}
+	if p.cfg.ValueLogFileSize > 0 {
+		options = options.WithValueLogFileSize(p.cfg.ValueLogFileSize)
 	}
 
 	db, err := badger.Open(options)
@@ -51,11 +198,121 @@ func (p *provider[K, V]) Setup() error {
 	}
 
 	p.db = db
+	if !p.cfg.ReadOnly && !p.cfg.InMemory {
+		if err := p.migrateReferences(); err != nil {
+			return errors.Join(fmt.Errorf("failed to migrate references: %w", err), db.Close())
+		}
+	}
+	if p.cfg.Snapshot.HasValue() {
+		if err := p.loadSnapshot(); err != nil {
+			return errors.Join(fmt.Errorf("failed to load snapshot: %w", err), db.Close())
+		}
+		p.startSnapshots()
+	}
+	p.startWriteQueue()
+	p.startMaintenance()
+	p.startExpiration()
 	return nil
 }
 
 func (p *provider[K, V]) Shutdown() error {
-	return p.db.Close()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var queueErr error
+	if p.stopWriteQueue != nil {
+		queueErr = p.stopWriteQueue()
+		p.stopWriteQueue = nil
+	}
+	if p.stopMaintenance != nil {
+		p.stopMaintenance()
+		p.stopMaintenance = nil
+	}
+	if p.stopSnapshots != nil {
+		p.stopSnapshots()
+		p.stopSnapshots = nil
+	}
+	if p.stopExpiration != nil {
+		p.stopExpiration()
+		p.stopExpiration = nil
+	}
+	if p.db == nil || p.db.IsClosed() {
+		return queueErr
+	}
+
+	var snapshotErr error
+	if p.cfg.Snapshot.HasValue() {
+		snapshotErr = p.Snapshot()
+	}
+
+	return errors.Join(queueErr, snapshotErr, p.db.Close())
+}
+
+func (p *provider[K, V]) Close() error {
+	return p.Shutdown()
+}
+
+func (p *provider[K, V]) Ping(ctx context.Context) error {
+	if p.db == nil || p.db.IsClosed() {
+		return errors.New("database is not open")
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return mapError(p.db.View(func(txn *badger.Txn) error {
+		return nil
+	}))
+}
+
+func (p *provider[K, V]) Stats() (kv.ProviderStats, error) {
+	p.readBarrier()
+	stats := kv.ProviderStats{Provider: "badger"}
+
+	err := p.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			if meta := it.Item().UserMeta(); isReference(meta) {
+				stats.References++
+			} else if isValue(meta) {
+				stats.Entries++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, mapError(err)
+	}
+
+	lsm, vlog := p.db.Size()
+	stats.DiskUsage = lsm + vlog
+	if lastWrite := p.lastWrite.Load(); lastWrite > 0 {
+		stats.LastFlush = time.Unix(0, lastWrite)
+	}
+	stats.Backend = map[string]any{
+		"lsm_size":    lsm,
+		"vlog_size":   vlog,
+		"tables":      len(p.db.Tables()),
+		"in_memory":   p.cfg.InMemory,
+		"read_only":   p.cfg.ReadOnly,
+		"max_version": p.db.MaxVersion(),
+	}
+
+	return stats, nil
+}
+
+func (p *provider[K, V]) ApproximateSize() (uint64, error) {
+	if p.db == nil || p.db.IsClosed() {
+		return 0, storageErrors.Closed
+	}
+
+	lsm, vlog := p.db.Size()
+	return uint64(lsm + vlog), nil
 }
 
 func (p *provider[K, V]) Store(key K, value V) error {
@@ -64,14 +321,98 @@ func (p *provider[K, V]) Store(key K, value V) error {
 		return err
 	}
 
-	v, err := p.encodeToBytes(value)
+	v, err := p.encodeToBytes(k, value)
 	if err != nil {
 		return err
 	}
 
-	return mapError(p.db.Update(func(txn *badger.Txn) error {
-		return txn.Set(k, v)
-	}))
+	return p.store(k, v, 0)
+}
+
+// store writes the encoded value v under k, or queues it with the write
+// queue. expiresAt is a Unix time, or zero.
+func (p *provider[K, V]) store(k []byte, v []byte, expiresAt uint64) error {
+	if p.queue != nil {
+		return p.enqueue(k, v, expiresAt)
+	}
+
+	w := p.chunkWrites()
+	entry, err := w.entry(k, v, expiresAt)
+	if err == nil {
+		err = p.commit(func(txn *badger.Txn) error {
+			return setEntry(txn, entry)
+		})
+	}
+	w.finish(err)
+
+	return mapError(err)
+}
+
+func (p *provider[K, V]) StoreWithTTL(key K, value V, ttl time.Duration) error {
+	if ttl <= 0 {
+		return errors.New("ttl must be positive")
+	}
+
+	k, err := p.keyToByte(key)
+	if err != nil {
+		return err
+	}
+
+	v, err := p.encodeToBytes(k, value)
+	if err != nil {
+		return err
+	}
+
+	return p.store(k, v, uint64(time.Now().Add(ttl).Unix()))
+}
+
+func (p *provider[K, V]) Update(key K, fn func(value V, exists bool) (V, error)) error {
+	k, err := p.keyToByte(key)
+	if err != nil {
+		return err
+	}
+
+	w := p.chunkWrites()
+	err = p.update(func(txn *badger.Txn) error {
+		w.discard()
+
+		var value V
+		exists := true
+
+		item, err := txn.Get(k)
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			exists = false
+		} else if err != nil {
+			return err
+		} else {
+			err = readValue(txn, item, func(val []byte) error {
+				value, err = p.decodeFromBytes(item.Key(), val)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		value, err = fn(value, exists)
+		if err != nil {
+			return err
+		}
+
+		v, err := p.encodeToBytes(k, value)
+		if err != nil {
+			return err
+		}
+		entry, err := w.entry(k, v, 0)
+		if err != nil {
+			return err
+		}
+
+		return setEntry(txn, entry)
+	})
+	w.finish(err)
+
+	return err
 }
 
 func (p *provider[K, V]) GetMultiple(keys []K) ([]V, error) {
@@ -89,25 +430,31 @@ func (p *provider[K, V]) GetMultiple(keys []K) ([]V, error) {
 }
 
 func (p *provider[K, V]) Get(key K) (V, error) {
+	var value V
+	err := p.GetInto(key, &value)
+
+	return value, err
+}
+
+// GetInto decodes the value of key into dst, which is reset first, without
+// copying the decoded value. dst is left unchanged when key does not exist.
+func (p *provider[K, V]) GetInto(key K, dst *V) error {
 	k, err := p.keyToByte(key)
 	if err != nil {
-		var v V
-		return v, err
+		return err
 	}
 
-	var value V
-	err = p.db.View(func(txn *badger.Txn) error {
+	err = p.view(func(txn *badger.Txn) error {
 		item, err := txn.Get(k)
 		if err != nil {
 			return err
 		}
-		return item.Value(func(val []byte) error {
-			value, err = p.decodeFromBytes(val)
-			return err
+		return readValue(txn, item, func(val []byte) error {
+			return p.decodeInto(item.Key(), val, dst)
 		})
 	})
 
-	return value, mapError(err)
+	return mapError(err)
 }
 
 func (p *provider[K, V]) Remove(key K) error {
@@ -116,155 +463,1155 @@ func (p *provider[K, V]) Remove(key K) error {
 		return err
 	}
 
-	return mapError(p.db.Update(func(txn *badger.Txn) error {
-		return txn.Delete(k)
-	}))
+	return p.update(func(txn *badger.Txn) error {
+		return deleteEntry(txn, k)
+	})
 }
 
-func (p *provider[K, V]) ForEach(fn func(key K, value V) bool) error {
-	return mapError(p.db.View(func(txn *badger.Txn) error {
+func (p *provider[K, V]) RemovePrefix(prefix K) (int, error) {
+	if p.cfg.ReadOnly {
+		return 0, storageErrors.ReadOnly
+	}
+
+	pr, err := p.keyToByte(prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	p.drainQueue()
+	var keys [][]byte
+	// DropPrefix would drop references and leave chunks stored elsewhere.
+	dropKeys := false
+	err = p.db.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
-		opts.PrefetchSize = 10
+		opts.PrefetchValues = false
+		opts.Prefix = pr
 		it := txn.NewIterator(opts)
 		defer it.Close()
 
 		for it.Rewind(); it.Valid(); it.Next() {
-			stopIterationErr := errors.New("stop iteration")
-
-			item := it.Item()
-			key, err := p.byteToKey(item.Key())
-			if err != nil {
-				return err
-			}
-
-			err = item.Value(func(val []byte) error {
-				v, err := p.decodeFromBytes(val)
-				if err != nil {
-					return err
-				}
-
-				if fn(key, v) {
-					return nil
-				} else {
-					return stopIterationErr
-				}
-			})
-
-			if err != nil {
-				if errors.Is(err, stopIterationErr) {
-					return nil
-				}
-				return err
+			meta := it.Item().UserMeta()
+			if !isValue(meta) {
+				dropKeys = true
+				continue
 			}
+			dropKeys = dropKeys || meta == chunkedMeta
+			keys = append(keys, it.Item().KeyCopy(nil))
 		}
 		return nil
-	}))
-}
+	})
+	if err != nil || len(keys) == 0 {
+		return 0, mapError(err)
+	}
 
-func mapError(err error) error {
-	if storageErrors.Is(err, badger.ErrKeyNotFound) {
-		return storageErrors.NewNotFound(err)
+	if dropKeys {
+		err = p.deleteKeys(keys)
+	} else {
+		err = mapError(p.db.DropPrefix(pr))
+		if err == nil {
+			p.lastWrite.Store(time.Now().UnixNano())
+		}
+	}
+	if err != nil {
+		return 0, err
 	}
 
-	return err
+	return len(keys), nil
 }
 
-func (p *provider[K, V]) keyToByte(k any) ([]byte, error) {
-	switch k.(type) {
-	case string:
-		return []byte(k.(string)), nil
-	case uint64:
-		return []byte(strconv.FormatUint(k.(uint64), 10)), nil
-	default:
-		return nil, errors.New("unknown key type (string, uint64 supported)")
+func (p *provider[K, V]) RemoveWhere(pred func(key K, value V) bool) (int, error) {
+	if p.cfg.ReadOnly {
+		return 0, storageErrors.ReadOnly
 	}
-}
 
-func (p *provider[K, V]) byteToKey(b []byte) (K, error) {
-	var k K
-	switch any(k).(type) {
-	case string:
-		return any(string(b)).(K), nil
-	case uint64:
-		intValue, err := strconv.ParseUint(string(b), 10, 64)
+	p.drainQueue()
+	var keys [][]byte
+	var keyErr error
+	err := p.iterate(nil, func(key K, value V) bool {
+		if !pred(key, value) {
+			return true
+		}
+
+		k, err := p.keyToByte(key)
 		if err != nil {
-			var zero K
-			return zero, errors.New("failed to convert bytes to uint64")
+			keyErr = err
+			return false
 		}
-		return any(intValue).(K), nil
-	default:
-		var zero K
-		return zero, errors.New("unknown key type (string, uint64 supported)")
+		keys = append(keys, k)
+		return true
+	})
+	if err = errors.Join(err, keyErr); err != nil || len(keys) == 0 {
+		return 0, err
 	}
-}
-
-func (p *provider[K, V]) encodeToBytes(data V) ([]byte, error) {
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
 
-	if err := enc.Encode(data); err != nil {
-		return nil, err
+	if err := p.deleteKeys(keys); err != nil {
+		return 0, err
 	}
 
-	defer buf.Reset()
-	return buf.Bytes(), nil
+	return len(keys), nil
 }
 
-func (p *provider[K, V]) decodeFromBytes(data []byte) (V, error) {
-	var value V
-	buf := bytes.NewBuffer(data)
-	dec := gob.NewDecoder(buf)
+func (p *provider[K, V]) Clear() error {
+	if p.cfg.ReadOnly {
+		return storageErrors.ReadOnly
+	}
 
-	if err := dec.Decode(&value); err != nil {
-		return value, err
+	p.drainQueue()
+	if err := p.db.DropAll(); err != nil {
+		return mapError(err)
+	}
+	if err := p.descriptors.restore(); err != nil {
+		return mapError(err)
 	}
 
-	return value, nil
+	p.lastWrite.Store(time.Now().UnixNano())
+	return nil
 }
 
-func (p *provider[K, V]) StoreReference(reference K, key K) error {
-	r, err := p.keyToByte(reference)
+func (p *provider[K, V]) deleteKeys(keys [][]byte) error {
+	chunks, err := p.chunkKeys(keys)
 	if err != nil {
 		return err
 	}
-	k, err := p.keyToByte(key)
-	if err != nil {
-		return err
+
+	wb := p.db.NewWriteBatch()
+	defer wb.Cancel()
+
+	for _, k := range append(keys, chunks...) {
+		if err := wb.Delete(k); err != nil {
+			return mapError(err)
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return mapError(err)
 	}
 
-	return mapError(p.db.Update(func(txn *badger.Txn) error {
-		return txn.Set(r, k)
-	}))
+	p.lastWrite.Store(time.Now().UnixNano())
+	return nil
 }
 
-func (p *provider[K, V]) RemoveReference(reference K) error {
-	r, err := p.keyToByte(reference)
+func (p *provider[K, V]) ForEach(fn func(key K, value V) bool) error {
+	return p.iterate(nil, fn)
+}
+
+func (p *provider[K, V]) ForEachPrefix(prefix K, fn func(key K, value V) bool) error {
+	pr, err := p.keyToByte(prefix)
 	if err != nil {
 		return err
 	}
 
-	return mapError(p.db.Update(func(txn *badger.Txn) error {
-		return txn.Delete(r)
+	return p.iterate(pr, fn)
+}
+
+func (p *provider[K, V]) iterate(prefix []byte, fn func(key K, value V) bool) error {
+	p.readBarrier()
+	return mapError(p.db.View(func(txn *badger.Txn) error {
+		return p.scan(txn, prefix, fn)
 	}))
 }
 
-func (p *provider[K, V]) GetByReference(reference K) (V, error) {
-	r, err := p.keyToByte(reference)
-	if err != nil {
-		var v V
-		return v, err
-	}
+func (p *provider[K, V]) scan(txn *badger.Txn, prefix []byte, fn func(key K, value V) bool) error {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchSize = 10
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
 
-	var key K
-	err = p.db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(r)
+	for it.Rewind(); it.Valid(); it.Next() {
+		stopIterationErr := errors.New("stop iteration")
+
+		item := it.Item()
+		if !isValue(item.UserMeta()) {
+			continue
+		}
+
+		key, err := p.byteToKey(item.Key())
 		if err != nil {
 			return err
 		}
-		return item.Value(func(val []byte) error {
-			key, err = p.byteToKey(val)
-			return err
+
+		err = readValue(txn, item, func(val []byte) error {
+			v, err := p.decodeFromBytes(item.Key(), val)
+			if err != nil {
+				return err
+			}
+
+			if fn(key, v) {
+				return nil
+			} else {
+				return stopIterationErr
+			}
 		})
+
+		if err != nil {
+			if errors.Is(err, stopIterationErr) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns up to limit entries in key order, starting after cursor.
+func (p *provider[K, V]) List(limit int, cursor kv.Cursor) ([]kv.Entry[K, V], kv.Cursor, error) {
+	if limit <= 0 {
+		return nil, "", errors.New("limit must be positive")
+	}
+	after, err := cursor.Position()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var entries []kv.Entry[K, V]
+	var next kv.Cursor
+	err = p.view(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchSize = min(limit, 100)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		var last []byte
+		for it.Seek(after); it.Valid(); it.Next() {
+			item := it.Item()
+			if !isValue(item.UserMeta()) || (cursor != "" && bytes.Equal(item.Key(), after)) {
+				continue
+			}
+			if len(entries) == limit {
+				next = kv.NewCursor(last)
+				return nil
+			}
+
+			key, err := p.byteToKey(item.Key())
+			if err != nil {
+				return err
+			}
+			var value V
+			err = readValue(txn, item, func(val []byte) error {
+				value, err = p.decodeFromBytes(item.Key(), val)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+
+			entries = append(entries, kv.Entry[K, V]{Key: key, Value: value})
+			last = item.KeyCopy(last[:0])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", mapError(err)
+	}
+
+	return entries, next, nil
+}
+
+// ForEachParallel calls fn for every entry from workers goroutines, using
+// Badger's stream framework to split the key space. After the first error
+// the remaining keys are skipped and that error is returned.
+func (p *provider[K, V]) ForEachParallel(workers int, fn func(key K, value V) error) error {
+	if workers <= 0 {
+		return errors.New("workers must be positive")
+	}
+
+	var (
+		failed   atomic.Bool
+		once     sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			failed.Store(true)
+		})
+	}
+
+	p.readBarrier()
+	stream := p.db.NewStream()
+	stream.NumGo = workers
+	stream.LogPrefix = "badger.ForEachParallel"
+	stream.ChooseKey = func(item *badger.Item) bool {
+		return !failed.Load() && isValue(item.UserMeta()) && !item.IsDeletedOrExpired()
+	}
+	// Errors returned from KeyToList are only logged by the stream.
+	stream.KeyToList = func(k []byte, itr *badger.Iterator) (*pb.KVList, error) {
+		key, err := p.byteToKey(k)
+		if err != nil {
+			fail(err)
+			return nil, nil
+		}
+
+		var value V
+		read := func(txn *badger.Txn) error {
+			return readValue(txn, itr.Item(), func(val []byte) error {
+				value, err = p.decodeFromBytes(k, val)
+				return err
+			})
+		}
+		// The stream has no transaction to read chunks from.
+		if itr.Item().UserMeta() == chunkedMeta {
+			err = p.db.View(read)
+		} else {
+			err = read(nil)
+		}
+		if err == nil {
+			err = fn(key, value)
+		}
+		if err != nil {
+			fail(err)
+		}
+		return nil, nil
+	}
+	stream.Send = func(*z.Buffer) error {
+		return nil
+	}
+
+	if err := stream.Orchestrate(context.Background()); err != nil {
+		return mapError(err)
+	}
+
+	return firstErr
+}
+
+func (p *provider[K, V]) ForEachKey(fn func(key K) bool) error {
+	return p.scanKeys(false, func(key K, _ *badger.Item) (bool, error) {
+		return fn(key), nil
+	})
+}
+
+func (p *provider[K, V]) ForEachReference(fn func(reference K, key K) bool) error {
+	return p.scanKeys(true, func(reference K, item *badger.Item) (bool, error) {
+		keys, err := p.referenceTargets(item)
+		if err != nil {
+			return false, err
+		}
+
+		for _, key := range keys {
+			if !fn(reference, key) {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+}
+
+func (p *provider[K, V]) scanKeys(references bool, fn func(key K, item *badger.Item) (bool, error)) error {
+	p.readBarrier()
+	return mapError(p.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			if meta := item.UserMeta(); references && !isReference(meta) || !references && !isValue(meta) {
+				continue
+			}
+
+			key, err := p.byteToKey(item.Key())
+			if err != nil {
+				return err
+			}
+
+			next, err := fn(key, item)
+			if err != nil {
+				return err
+			}
+			if !next {
+				return nil
+			}
+		}
+		return nil
+	}))
+}
+
+func (p *provider[K, V]) view(fn func(txn *badger.Txn) error) error {
+	p.readBarrier()
+
+	deadline := timeout.Start(p.cfg.TransactionTimeout, "badger read transaction")
+	return p.db.View(func(txn *badger.Txn) error {
+		if err := fn(txn); err != nil {
+			return err
+		}
+		return deadline.Err()
+	})
+}
+
+// update commits the write queue first, so fn applies after the queued
+// writes. Callers that write chunks before the transaction drain the queue
+// themselves and use commit, as chunk writes must not wait for other chunk
+// writes.
+func (p *provider[K, V]) update(fn func(txn *badger.Txn) error) error {
+	p.drainQueue()
+
+	return p.commit(fn)
+}
+
+// commit runs fn in a write transaction, retrying it on conflicts. The
+// transaction is discarded instead of committed once TransactionTimeout has
+// passed.
+func (p *provider[K, V]) commit(fn func(txn *badger.Txn) error) error {
+	retries := p.cfg.ConflictRetries.OrElse(defaultConflictRetries)
+	deadline := timeout.Start(p.cfg.TransactionTimeout, "badger write transaction")
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = p.db.Update(func(txn *badger.Txn) error {
+			if err := fn(txn); err != nil {
+				return err
+			}
+			return deadline.Err()
+		})
+		if !errors.Is(err, badger.ErrConflict) || attempt >= retries {
+			break
+		}
+		time.Sleep(time.Duration(attempt+1) * time.Millisecond)
+	}
+	if err == nil {
+		p.lastWrite.Store(time.Now().UnixNano())
+	}
+
+	return mapError(err)
+}
+
+func mapError(err error) error {
+	if storageErrors.Is(err, badger.ErrKeyNotFound) {
+		return storageErrors.NewNotFound(err)
+	}
+	if storageErrors.Is(err, badger.ErrReadOnlyTxn) {
+		return storageErrors.NewReadOnly(err)
+	}
+	if storageErrors.Is(err, badger.ErrConflict) {
+		return storageErrors.NewConflict(err)
+	}
+	if storageErrors.Is(err, badger.ErrDBClosed) {
+		return storageErrors.NewClosed(err)
+	}
+
+	return err
+}
+
+func (p *provider[K, V]) keyToByte(k any) ([]byte, error) {
+	v := reflect.ValueOf(k)
+	switch v.Kind() {
+	case reflect.String:
+		return encodeStringKey(v.String(), p.uuidKeys), nil
+	case reflect.Uint64:
+		return encodeUint64Key(v.Uint(), p.binaryKeys), nil
+	default:
+		return nil, errors.New("unknown key type (string, uint64 supported)")
+	}
+}
+
+func (p *provider[K, V]) byteToKey(b []byte) (K, error) {
+	var k K
+	v := reflect.ValueOf(&k).Elem()
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(decodeStringKey(b, p.uuidKeys))
+	case reflect.Uint64:
+		intValue, err := decodeUint64Key(b)
+		if err != nil {
+			var zero K
+			return zero, err
+		}
+		v.SetUint(intValue)
+	default:
+		var zero K
+		return zero, errors.New("unknown key type (string, uint64 supported)")
+	}
+
+	return k, nil
+}
+
+func (p *provider[K, V]) encodeToBytes(key []byte, data V) ([]byte, error) {
+	buf := bufpool.Get()
+	defer bufpool.Put(buf)
+
+	var header [valueHeaderSize + 1 + 4]byte
+	header[valueHeaderSize] = p.codec.Tag()
+	binary.BigEndian.PutUint32(header[valueHeaderSize+1:], uint32(len(p.schema)))
+	buf.Write(header[:])
+	buf.Write(p.schema)
+	if err := codec.MarshalTo(p.codec, buf, data); err != nil {
+		return nil, err
+	}
+
+	b := buf.Bytes()
+	b[0] = taggedMagic
+	binary.BigEndian.PutUint64(b[1:9], p.fingerprint)
+	binary.BigEndian.PutUint32(b[9:valueHeaderSize], crc32.ChecksumIEEE(b[valueHeaderSize:]))
+	if p.signer != nil {
+		buf.Write(p.signer.Sign(key, b))
+	}
+
+	// The pooled buffer is reused, so the transaction gets a copy.
+	return bytes.Clone(buf.Bytes()), nil
+}
+
+func (p *provider[K, V]) decodeFromBytes(key []byte, data []byte) (V, error) {
+	var value V
+	err := p.decodeInto(key, data, &value)
+
+	return value, err
+}
+
+// decodeInto decodes data into value, which is reset first.
+func (p *provider[K, V]) decodeInto(key []byte, data []byte, value *V) error {
+	var zero V
+	*value = zero
+	if p.signer != nil {
+		if len(data) < integrity.Size {
+			return storageErrors.NewTampered(fmt.Errorf("key %q: integrity signature is missing", key))
+		}
+		n := len(data) - integrity.Size
+		if !p.signer.Valid(key, data[:n], data[n:]) {
+			return storageErrors.NewTampered(fmt.Errorf("key %q: integrity check failed", key))
+		}
+		data = data[:n]
+	}
+	dec := codec.Gob
+	if len(data) > 0 && (data[0] == valueMagic || data[0] == schemaMagic || data[0] == protoMagic || data[0] == taggedMagic) {
+		if len(data) < valueHeaderSize {
+			return storageErrors.NewCorrupted(errors.New("value header is truncated"))
+		}
+		// With StrictSchema, values that carry a schema are checked field by
+		// field below, which allows compatible changes such as added fields.
+		fingerprinted := binary.BigEndian.Uint64(data[1:9]) == p.fingerprint
+		if binary.BigEndian.Uint32(data[9:valueHeaderSize]) != crc32.ChecksumIEEE(data[valueHeaderSize:]) {
+			return storageErrors.NewCorrupted(errors.New("value checksum mismatch"))
+		}
+		magic := data[0]
+		data = data[valueHeaderSize:]
+
+		switch magic {
+		case protoMagic:
+			dec = codec.Proto
+		case taggedMagic:
+			if len(data) == 0 {
+				return storageErrors.NewCorrupted(errors.New("value codec tag is missing"))
+			}
+			c, err := codec.ByTag(data[0])
+			if err != nil {
+				return storageErrors.NewCorrupted(err)
+			}
+			if c == codec.SharedGob {
+				c = p.sharedGob
+			}
+			dec = c
+			data = data[1:]
+			fallthrough
+		case schemaMagic:
+			if len(data) < 4 || uint64(len(data)-4) < uint64(binary.BigEndian.Uint32(data)) {
+				return storageErrors.NewCorrupted(errors.New("value schema is truncated"))
+			}
+			n := binary.BigEndian.Uint32(data)
+			if p.schema != nil && n > 0 {
+				if err := schema.Check(data[4:4+n], p.schema); err != nil {
+					return err
+				}
+				fingerprinted = true
+			}
+			data = data[4+n:]
+		}
+		if !fingerprinted {
+			return storageErrors.NewCorrupted(errors.New("value type fingerprint mismatch"))
+		}
+	}
+
+	if err := dec.Unmarshal(data, value); err != nil {
+		return storageErrors.NewCorrupted(err)
+	}
+
+	return nil
+}
+
+// ReencodeAll rewrites every value that was not written with the configured
+// codec and returns how many values were rewritten.
+func (p *provider[K, V]) ReencodeAll() (int, error) {
+	if p.cfg.ReadOnly {
+		return 0, storageErrors.ReadOnly
+	}
+
+	var keys [][]byte
+	err := p.view(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			if !isValue(item.UserMeta()) {
+				continue
+			}
+
+			err := readValue(txn, item, func(val []byte) error {
+				if p.stale(val) {
+					keys = append(keys, item.KeyCopy(nil))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, mapError(err)
+	}
+
+	reencoded := 0
+	for batch := range slices.Chunk(keys, reencodeBatchSize) {
+		n := 0
+		w := p.chunkWrites()
+		err := p.update(func(txn *badger.Txn) error {
+			w.discard()
+			n = 0
+			for _, k := range batch {
+				item, err := txn.Get(k)
+				if errors.Is(err, badger.ErrKeyNotFound) {
+					continue
+				} else if err != nil {
+					return err
+				}
+				if !isValue(item.UserMeta()) {
+					continue
+				}
+
+				var v []byte
+				err = readValue(txn, item, func(raw []byte) error {
+					if !p.stale(raw) {
+						return nil
+					}
+					value, err := p.decodeFromBytes(k, raw)
+					if err != nil {
+						return fmt.Errorf("key %q: %w", k, err)
+					}
+					v, err = p.encodeToBytes(k, value)
+					return err
+				})
+				if err != nil {
+					return err
+				}
+				if v == nil {
+					continue
+				}
+
+				entry, err := w.entry(k, v, item.ExpiresAt())
+				if err != nil {
+					return err
+				}
+				if err := setEntry(txn, entry); err != nil {
+					return err
+				}
+				n++
+			}
+			return nil
+		})
+		w.finish(err)
+		if err != nil {
+			return reencoded, err
+		}
+		reencoded += n
+	}
+
+	return reencoded, nil
+}
+
+func (p *provider[K, V]) stale(val []byte) bool {
+	return len(val) <= valueHeaderSize || val[0] != taggedMagic || val[valueHeaderSize] != p.codec.Tag()
+}
+
+// Backup writes a full backup in checksummed chunks, see VerifyBackup.
+func (p *provider[K, V]) Backup(w io.Writer) error {
+	p.readBarrier()
+	cw := chunked.NewWriter(w)
+	if _, err := p.db.Backup(cw, 0); err != nil {
+		return mapError(err)
+	}
+
+	return cw.Close()
+}
+
+// Restore loads a backup written by Backup. Each chunk is checked before it
+// is applied, so a damaged backup may leave the chunks before the damage
+// applied; run VerifyBackup first to avoid partial restores.
+func (p *provider[K, V]) Restore(r io.Reader) error {
+	if p.cfg.ReadOnly {
+		return storageErrors.ReadOnly
+	}
+
+	p.drainQueue()
+	err := p.db.Load(chunked.NewReader(r), restoreMaxPendingWrites)
+	p.lastWrite.Store(time.Now().UnixNano())
+
+	return mapError(err)
+}
+
+func (p *provider[K, V]) Verify() error {
+	p.readBarrier()
+	var errs []error
+	err := p.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			if !isValue(item.UserMeta()) {
+				continue
+			}
+
+			err := readValue(txn, item, func(val []byte) error {
+				_, err := p.decodeFromBytes(item.Key(), val)
+				return err
+			})
+			if err != nil {
+				errs = append(errs, fmt.Errorf("key %q: %w", item.KeyCopy(nil), err))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return mapError(err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// typeFingerprint identifies the shape of V, so values stored with an
+// incompatible type fail to decode while renamed types still read them.
+func typeFingerprint[V any]() uint64 {
+	return schema.Fingerprint(reflect.TypeFor[V]())
+}
+
+func (p *provider[K, V]) StoreReference(reference K, key K) error {
+	r, err := p.keyToByte(reference)
+	if err != nil {
+		return err
+	}
+	k, err := p.keyToByte(key)
+	if err != nil {
+		return err
+	}
+
+	return p.update(func(txn *badger.Txn) error {
+		return txn.SetEntry(badger.NewEntry(r, k).WithMeta(referenceMeta))
+	})
+}
+
+// StoreWithReferences stores the value and points every reference to key in
+// one transaction.
+func (p *provider[K, V]) StoreWithReferences(key K, value V, references ...K) error {
+	k, err := p.keyToByte(key)
+	if err != nil {
+		return err
+	}
+	v, err := p.encodeToBytes(k, value)
+	if err != nil {
+		return err
+	}
+	refs := make([][]byte, 0, len(references))
+	for _, reference := range references {
+		r, err := p.keyToByte(reference)
+		if err != nil {
+			return err
+		}
+		refs = append(refs, r)
+	}
+
+	p.drainQueue()
+	w := p.chunkWrites()
+	entry, err := w.entry(k, v, 0)
+	if err == nil {
+		err = p.commit(func(txn *badger.Txn) error {
+			if err := setEntry(txn, entry); err != nil {
+				return err
+			}
+			for _, r := range refs {
+				if err := txn.SetEntry(badger.NewEntry(r, k).WithMeta(referenceMeta)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	w.finish(err)
+
+	return mapError(err)
+}
+
+func (p *provider[K, V]) RemoveWithReferences(keys []K, references []K) error {
+	ks := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		k, err := p.keyToByte(key)
+		if err != nil {
+			return err
+		}
+		ks = append(ks, k)
+	}
+	refs := make([][]byte, 0, len(references))
+	for _, reference := range references {
+		r, err := p.keyToByte(reference)
+		if err != nil {
+			return err
+		}
+		refs = append(refs, r)
+	}
+
+	return p.update(func(txn *badger.Txn) error {
+		for _, k := range ks {
+			if err := deleteEntry(txn, k); err != nil {
+				return err
+			}
+		}
+		for _, r := range refs {
+			targets, err := p.loadReference(txn, r)
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			remaining := slices.DeleteFunc(slices.Clone(targets), func(t []byte) bool {
+				return slices.ContainsFunc(ks, func(k []byte) bool { return bytes.Equal(t, k) })
+			})
+			if len(remaining) == len(targets) {
+				continue
+			}
+			if err := p.saveReference(txn, r, remaining); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *provider[K, V]) RemoveReference(reference K) error {
+	r, err := p.keyToByte(reference)
+	if err != nil {
+		return err
+	}
+
+	return p.update(func(txn *badger.Txn) error {
+		return txn.Delete(r)
+	})
+}
+
+func (p *provider[K, V]) AddReference(reference K, key K) error {
+	r, err := p.keyToByte(reference)
+	if err != nil {
+		return err
+	}
+	k, err := p.keyToByte(key)
+	if err != nil {
+		return err
+	}
+
+	return p.update(func(txn *badger.Txn) error {
+		targets, err := p.loadReference(txn, r)
+		if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+		if slices.ContainsFunc(targets, func(t []byte) bool { return bytes.Equal(t, k) }) {
+			return nil
+		}
+
+		return p.saveReference(txn, r, append(targets, k))
+	})
+}
+
+func (p *provider[K, V]) RemoveReferenceTarget(reference K, key K) error {
+	r, err := p.keyToByte(reference)
+	if err != nil {
+		return err
+	}
+	k, err := p.keyToByte(key)
+	if err != nil {
+		return err
+	}
+
+	return p.update(func(txn *badger.Txn) error {
+		targets, err := p.loadReference(txn, r)
+		if err != nil {
+			return err
+		}
+
+		remaining := slices.DeleteFunc(slices.Clone(targets), func(t []byte) bool { return bytes.Equal(t, k) })
+		if len(remaining) == len(targets) {
+			return badger.ErrKeyNotFound
+		}
+
+		return p.saveReference(txn, r, remaining)
+	})
+}
+
+func (p *provider[K, V]) GetAllByReference(reference K) ([]V, error) {
+	r, err := p.keyToByte(reference)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []V
+	err = p.view(func(txn *badger.Txn) error {
+		targets, err := p.resolveReference(txn, r, 0, map[string]bool{})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range targets {
+			item, err := txn.Get(k)
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			err = readValue(txn, item, func(val []byte) error {
+				v, err := p.decodeFromBytes(item.Key(), val)
+				values = append(values, v)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return values, mapError(err)
+}
+
+func (p *provider[K, V]) loadReference(txn *badger.Txn, r []byte) ([][]byte, error) {
+	item, err := txn.Get(r)
+	if err != nil {
+		return nil, err
+	}
+	if !isReference(item.UserMeta()) {
+		return nil, badger.ErrKeyNotFound
+	}
+
+	return decodeReference(item)
+}
+
+func (p *provider[K, V]) resolveReference(txn *badger.Txn, r []byte, depth int, path map[string]bool) ([][]byte, error) {
+	maxDepth := p.cfg.MaxReferenceDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultMaxReferenceDepth
+	}
+	if path[string(r)] {
+		return nil, storageErrors.NewReferenceCycle(fmt.Errorf("reference %q", r))
+	}
+	if depth >= maxDepth {
+		return nil, storageErrors.NewReferenceTooDeep(fmt.Errorf("reference %q exceeds depth %d", r, maxDepth))
+	}
+
+	targets, err := p.loadReference(txn, r)
+	if err != nil {
+		return nil, err
+	}
+
+	path[string(r)] = true
+	defer delete(path, string(r))
+
+	var keys [][]byte
+	for _, t := range targets {
+		item, err := txn.Get(t)
+		if err != nil || !isReference(item.UserMeta()) {
+			keys = append(keys, t)
+			continue
+		}
+
+		resolved, err := p.resolveReference(txn, t, depth+1, path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, resolved...)
+	}
+
+	return keys, nil
+}
+
+func decodeReference(item *badger.Item) ([][]byte, error) {
+	val, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, err
+	}
+	if item.UserMeta() == referenceMeta {
+		return [][]byte{val}, nil
+	}
+
+	var targets [][]byte
+	if err := gob.NewDecoder(bytes.NewReader(val)).Decode(&targets); err != nil {
+		return nil, storageErrors.NewCorrupted(err)
+	}
+	if len(targets) == 0 {
+		return nil, storageErrors.NewCorrupted(errors.New("empty reference set"))
+	}
+
+	return targets, nil
+}
+
+func (p *provider[K, V]) saveReference(txn *badger.Txn, r []byte, targets [][]byte) error {
+	switch len(targets) {
+	case 0:
+		return txn.Delete(r)
+	case 1:
+		return txn.SetEntry(badger.NewEntry(r, targets[0]).WithMeta(referenceMeta))
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(targets); err != nil {
+		return err
+	}
+
+	return txn.SetEntry(badger.NewEntry(r, buf.Bytes()).WithMeta(referenceSetMeta))
+}
+
+func (p *provider[K, V]) referenceTargets(item *badger.Item) ([]K, error) {
+	raw, err := decodeReference(item)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]K, 0, len(raw))
+	for _, b := range raw {
+		key, err := p.byteToKey(b)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func isReference(meta byte) bool {
+	return meta == referenceMeta || meta == referenceSetMeta
+}
+
+// migrateReferences marks references written before references had their
+// own user meta. Those were stored as plain entries holding the target key,
+// so they are the plain, headerless entries that do not decode as a value
+// and name an existing key. Setup runs it on writable databases.
+func (p *provider[K, V]) migrateReferences() error {
+	var legacy [][]byte
+	err := p.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			if item.UserMeta() != 0 {
+				continue
+			}
+
+			var target []byte
+			err := item.Value(func(val []byte) error {
+				if len(val) == 0 || val[0] == valueMagic || val[0] == schemaMagic || val[0] == protoMagic || val[0] == taggedMagic {
+					return nil
+				}
+				if _, err := p.decodeFromBytes(item.Key(), val); storageErrors.Is(err, storageErrors.Corrupted) {
+					target = bytes.Clone(val)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if target == nil || bytes.Equal(target, item.Key()) {
+				continue
+			}
+			if _, err := txn.Get(target); err == nil {
+				legacy = append(legacy, item.KeyCopy(nil))
+			} else if !errors.Is(err, badger.ErrKeyNotFound) {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for batch := range slices.Chunk(legacy, reencodeBatchSize) {
+		err := p.db.Update(func(txn *badger.Txn) error {
+			for _, r := range batch {
+				item, err := txn.Get(r)
+				if errors.Is(err, badger.ErrKeyNotFound) {
+					continue
+				} else if err != nil {
+					return err
+				}
+				if item.UserMeta() != 0 {
+					continue
+				}
+
+				target, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				entry := badger.NewEntry(r, target).WithMeta(referenceMeta)
+				entry.ExpiresAt = item.ExpiresAt()
+				if err := txn.SetEntry(entry); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *provider[K, V]) ResolveReference(reference K) ([]K, error) {
+	r, err := p.keyToByte(reference)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []K
+	err = p.view(func(txn *badger.Txn) error {
+		targets, err := p.resolveReference(txn, r, 0, map[string]bool{})
+		if err != nil {
+			return err
+		}
+
+		for _, t := range targets {
+			key, err := p.byteToKey(t)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, key)
+		}
+		return nil
+	})
+
+	return keys, mapError(err)
+}
+
+func (p *provider[K, V]) GetByReference(reference K) (V, error) {
+	r, err := p.keyToByte(reference)
+	if err != nil {
+		var v V
+		return v, err
+	}
+
+	var key K
+	err = p.view(func(txn *badger.Txn) error {
+		targets, err := p.resolveReference(txn, r, 0, map[string]bool{})
+		if err != nil {
+			return err
+		}
+
+		key, err = p.byteToKey(targets[0])
+		return err
 	})
 	if err != nil {
 		var v V
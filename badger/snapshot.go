@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !nobadger
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package badger
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Snapshot writes the database to the configured snapshot path. The previous
+// snapshot is replaced only once the new one is complete. It does nothing if
+// nothing was written since the last snapshot.
+func (p *provider[K, V]) Snapshot() error {
+	if p.cfg.Snapshot.IsNull() {
+		return errors.New("snapshot is not configured")
+	}
+	if p.db == nil || p.db.IsClosed() {
+		return errors.New("database is not open")
+	}
+
+	p.readBarrier()
+	p.snapshotMu.Lock()
+	defer p.snapshotMu.Unlock()
+
+	lastWrite := p.lastWrite.Load()
+	if lastWrite == p.lastSnapshot {
+		return nil
+	}
+
+	path := p.cfg.Snapshot.GetValue().Path
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := p.Backup(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+
+	p.lastSnapshot = lastWrite
+	return nil
+}
+
+func (p *provider[K, V]) loadSnapshot() error {
+	f, err := os.Open(p.cfg.Snapshot.GetValue().Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := p.Restore(f); err != nil {
+		return err
+	}
+
+	p.lastSnapshot = p.lastWrite.Load()
+	return nil
+}
+
+func (p *provider[K, V]) startSnapshots() {
+	interval := p.cfg.Snapshot.GetValue().Interval
+	if interval == 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	p.stopSnapshots = func() {
+		close(stop)
+		<-done
+	}
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			if err := p.Snapshot(); err != nil && p.cfg.Logger != nil {
+				p.cfg.Logger.Error("badger snapshot failed", "error", err)
+			}
+		}
+	}()
+}
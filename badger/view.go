@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !nobadger
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package badger
+
+import (
+	"github.com/dgraph-io/badger/v4"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"github.com/rlshukhov/storage/kv"
+	"sync"
+)
+
+// snapshot reads from a read-only transaction, which sees the database as
+// it was when the transaction started. It keeps Badger from discarding the
+// versions it can see until it is closed.
+type snapshot[K any, V any] struct {
+	p *provider[K, V]
+
+	mu  sync.RWMutex
+	txn *badger.Txn
+}
+
+// OpenSnapshot returns a point-in-time view of the database.
+func (p *provider[K, V]) OpenSnapshot() (kv.Snapshot[K, V], error) {
+	if p.db == nil || p.db.IsClosed() {
+		return nil, storageErrors.Closed
+	}
+
+	p.readBarrier()
+	return &snapshot[K, V]{p: p, txn: p.db.NewTransaction(false)}, nil
+}
+
+func (s *snapshot[K, V]) Get(key K) (V, error) {
+	var value V
+	k, err := s.p.keyToByte(key)
+	if err != nil {
+		return value, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.txn == nil {
+		return value, storageErrors.Closed
+	}
+	item, err := s.txn.Get(k)
+	if err != nil {
+		return value, mapError(err)
+	}
+	if !isValue(item.UserMeta()) {
+		return value, storageErrors.NotFound
+	}
+	err = readValue(s.txn, item, func(val []byte) error {
+		value, err = s.p.decodeFromBytes(item.Key(), val)
+		return err
+	})
+
+	return value, mapError(err)
+}
+
+func (s *snapshot[K, V]) ForEach(fn func(key K, value V) bool) error {
+	return s.scan(nil, fn)
+}
+
+func (s *snapshot[K, V]) ForEachPrefix(prefix K, fn func(key K, value V) bool) error {
+	pr, err := s.p.keyToByte(prefix)
+	if err != nil {
+		return err
+	}
+
+	return s.scan(pr, fn)
+}
+
+func (s *snapshot[K, V]) scan(prefix []byte, fn func(key K, value V) bool) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.txn == nil {
+		return storageErrors.Closed
+	}
+
+	return mapError(s.p.scan(s.txn, prefix, fn))
+}
+
+func (s *snapshot[K, V]) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.txn != nil {
+		s.txn.Discard()
+		s.txn = nil
+	}
+
+	return nil
+}
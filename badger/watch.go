@@ -0,0 +1,155 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !nobadger
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package badger
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/dgraph-io/badger/v4/pb"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"github.com/rlshukhov/storage/kv"
+	"sync"
+	"time"
+)
+
+// watchMeta marks the markers Watch stores under "\xffwatch/" | id [16]byte
+// to learn when its subscription is registered, as Badger does not report
+// it. Like chunk keys, marker keys do not collide with keys. Markers expire
+// in case the process stops before it removes them.
+const (
+	watchMeta byte = 6
+
+	watchMarkerInterval = 10 * time.Millisecond
+	watchMarkerTTL      = time.Minute
+)
+
+var watchKeyPrefix = []byte("\xffwatch/")
+
+// Watch calls fn for every change of a key with prefix until ctx is done. If
+// ctx carries a kv.WithWatchReady callback, it is called once the
+// subscription is registered.
+func (p *provider[K, V]) Watch(ctx context.Context, prefix K, fn func(key K, value V, removed bool)) error {
+	pr, err := p.keyToByte(prefix)
+	if err != nil {
+		return err
+	}
+
+	matches := []pb.Match{{Prefix: pr}}
+	var marker []byte
+	var seen chan struct{}
+	var once sync.Once
+	failed := make(chan error, 1)
+	if ready := kv.WatchReady(ctx); ready != nil && p.cfg.ReadOnly {
+		// Nothing is written to a read-only database, so there is no change
+		// to miss.
+		ready()
+	} else if ready != nil {
+		id := make([]byte, 16)
+		rand.Read(id)
+		marker = append(bytes.Clone(watchKeyPrefix), id...)
+		matches = append(matches, pb.Match{Prefix: marker})
+		seen = make(chan struct{})
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			if err := p.markWatch(ctx, marker, seen, ready); err != nil {
+				failed <- err
+				cancel()
+			}
+		}()
+	}
+
+	err = p.db.Subscribe(ctx, func(list *badger.KVList) error {
+		for _, kv := range list.GetKv() {
+			if marker != nil && bytes.Equal(kv.GetKey(), marker) {
+				once.Do(func() { close(seen) })
+				continue
+			}
+			if len(kv.GetMeta()) > 0 && !isValue(kv.GetMeta()[0]) || isChunkKey(kv.GetKey()) {
+				continue
+			}
+
+			key, err := p.byteToKey(kv.GetKey())
+			if err != nil {
+				return err
+			}
+
+			if len(kv.GetValue()) == 0 {
+				var v V
+				fn(key, v, true)
+				continue
+			}
+
+			val := kv.GetValue()
+			if len(kv.GetMeta()) > 0 && kv.GetMeta()[0] == chunkedMeta {
+				err := p.db.View(func(txn *badger.Txn) error {
+					val, err = readChunks(kv.GetValue(), txn.Get)
+					return err
+				})
+				// The chunks are gone when the value was replaced since;
+				// the replacement is reported next.
+				if storageErrors.Is(err, storageErrors.Corrupted) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+			}
+
+			v, err := p.decodeFromBytes(kv.GetKey(), val)
+			if err != nil {
+				return err
+			}
+			fn(key, v, false)
+		}
+		return nil
+	}, matches)
+
+	select {
+	case err := <-failed:
+		return err
+	default:
+		return err
+	}
+}
+
+// markWatch stores marker until the subscription reports it, then removes
+// it and calls ready.
+func (p *provider[K, V]) markWatch(ctx context.Context, marker []byte, seen <-chan struct{}, ready func()) error {
+	ticker := time.NewTicker(watchMarkerInterval)
+	defer ticker.Stop()
+
+	for {
+		err := p.db.Update(func(txn *badger.Txn) error {
+			return txn.SetEntry(badger.NewEntry(marker, nil).WithMeta(watchMeta).WithTTL(watchMarkerTTL))
+		})
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-seen:
+			// A marker that is not removed expires.
+			_ = p.db.Update(func(txn *badger.Txn) error {
+				return txn.Delete(marker)
+			})
+			ready()
+			return nil
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,226 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !nobadger
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package badger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dgraph-io/badger/v4"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"github.com/rlshukhov/storage/kv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultWriteQueueInterval = 5 * time.Millisecond
+	defaultWriteQueueBatch    = 1000
+	// Store commits the queue itself once this many batches are queued, so
+	// the queue does not grow faster than it is committed.
+	maxQueuedBatches = 4
+)
+
+type queuedWrite struct {
+	k         []byte
+	v         []byte
+	expiresAt uint64
+}
+
+type writeQueue struct {
+	cfg  WriteQueueConfig
+	kick chan struct{}
+
+	mu      sync.Mutex
+	pending []queuedWrite
+	closed  bool
+	// err is the first error of the commits since the last Flush.
+	err error
+	// uncommitted counts the queued writes and the writes being committed.
+	uncommitted atomic.Int64
+
+	// commitMu serializes commits, so batches apply in the order they were
+	// queued.
+	commitMu sync.Mutex
+}
+
+func (p *provider[K, V]) startWriteQueue() {
+	if p.cfg.WriteQueue.IsNull() {
+		return
+	}
+
+	cfg := p.cfg.WriteQueue.GetValue()
+	if cfg.Interval == 0 {
+		cfg.Interval = defaultWriteQueueInterval
+	}
+	if cfg.MaxBatch == 0 {
+		cfg.MaxBatch = defaultWriteQueueBatch
+	}
+	q := &writeQueue{cfg: cfg, kick: make(chan struct{}, 1)}
+	p.queue = q
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	p.stopWriteQueue = func() error {
+		cancel()
+		<-done
+
+		q.mu.Lock()
+		q.closed = true
+		q.mu.Unlock()
+		p.drainQueue()
+
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return q.err
+	}
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-q.kick:
+			}
+			p.drainQueue()
+		}
+	}()
+}
+
+// enqueue queues the encoded value v for k. expiresAt is a Unix time, or
+// zero.
+func (p *provider[K, V]) enqueue(k []byte, v []byte, expiresAt uint64) error {
+	q := p.queue
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return storageErrors.Closed
+	}
+	q.pending = append(q.pending, queuedWrite{k: k, v: v, expiresAt: expiresAt})
+	q.uncommitted.Add(1)
+	n := len(q.pending)
+	q.mu.Unlock()
+
+	switch {
+	case n >= q.cfg.MaxBatch*maxQueuedBatches:
+		p.drainQueue()
+	case n >= q.cfg.MaxBatch:
+		select {
+		case q.kick <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// drainQueue commits the queued writes, so writes that follow apply after
+// them. Failures are kept for Flush and reported to OnError.
+func (p *provider[K, V]) drainQueue() {
+	q := p.queue
+	if q == nil || q.uncommitted.Load() == 0 {
+		return
+	}
+
+	q.commitMu.Lock()
+	q.mu.Lock()
+	batch := q.pending
+	q.pending = nil
+	q.mu.Unlock()
+
+	var err error
+	for len(batch) > 0 {
+		n := min(len(batch), q.cfg.MaxBatch)
+		err = errors.Join(err, p.commitWrites(batch[:n]))
+		q.uncommitted.Add(-int64(n))
+		batch = batch[n:]
+	}
+	q.commitMu.Unlock()
+	if err == nil {
+		return
+	}
+
+	q.mu.Lock()
+	if q.err == nil {
+		q.err = err
+	}
+	q.mu.Unlock()
+	if q.cfg.OnError != nil {
+		q.cfg.OnError(err)
+	} else if p.cfg.Logger != nil {
+		p.cfg.Logger.Error("badger queued writes failed", "error", err)
+	}
+}
+
+// readBarrier commits the write queue before a read in strict consistency,
+// so the read observes the writes that returned before it.
+func (p *provider[K, V]) readBarrier() {
+	if p.cfg.Consistency != kv.Eventual {
+		p.drainQueue()
+	}
+}
+
+// commitWrites commits batch in one transaction. When that fails, e.g. as
+// the batch does not fit into one transaction or one value is invalid, it
+// commits the halves of batch, so one bad write does not fail the others.
+func (p *provider[K, V]) commitWrites(batch []queuedWrite) error {
+	w := p.chunkWrites()
+	err := p.commit(func(txn *badger.Txn) error {
+		w.discard()
+		for _, write := range batch {
+			entry, err := w.entry(write.k, write.v, write.expiresAt)
+			if err != nil {
+				return err
+			}
+			if err := setEntry(txn, entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	w.finish(err)
+	switch {
+	case err == nil || errors.Is(err, badger.ErrDBClosed):
+		return err
+	case len(batch) > 1:
+		n := len(batch) / 2
+		return errors.Join(p.commitWrites(batch[:n]), p.commitWrites(batch[n:]))
+	default:
+		return fmt.Errorf("key %q: %w", batch[0].k, err)
+	}
+}
+
+// Flush commits the queued writes, syncs the database to disk and returns
+// the first error of the queued writes committed since the last Flush.
+func (p *provider[K, V]) Flush() error {
+	if p.db == nil || p.db.IsClosed() {
+		return storageErrors.Closed
+	}
+
+	var err error
+	if q := p.queue; q != nil {
+		p.drainQueue()
+		q.mu.Lock()
+		err, q.err = q.err, nil
+		q.mu.Unlock()
+	}
+	if !p.cfg.InMemory && !p.cfg.ReadOnly {
+		err = errors.Join(err, mapError(p.db.Sync()))
+	}
+
+	return err
+}
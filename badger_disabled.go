@@ -0,0 +1,21 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build nobadger
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"errors"
+	"github.com/rlshukhov/storage/badger"
+	storageErrors "github.com/rlshukhov/storage/errors"
+)
+
+func newBadgerProvider[K ~string | ~uint64, V any](badger.Config) (KeyValueProvider[K, V], error) {
+	return nil, storageErrors.NewUnsupported(errors.New("badger provider is not available in builds with -tags nobadger"))
+}
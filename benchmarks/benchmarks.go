@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package benchmarks
+
+import (
+	"fmt"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/rlshukhov/storage/file"
+	"math/rand"
+	"path/filepath"
+)
+
+type Case struct {
+	Name     string
+	Config   storage.KeyValueConfig
+	Rewrites bool
+}
+
+type Value struct {
+	ID      uint64 `json:"id" yaml:"id"`
+	Payload []byte `json:"payload" yaml:"payload"`
+}
+
+var (
+	ValueSizes    = []int{128, 4 << 10}
+	Cardinalities = []int{100, 10_000}
+
+	MaxRewriteBytes = 4 << 20
+)
+
+func Providers(dir string) []Case {
+	cases := []Case{
+		{Name: "badger-memory", Config: storage.KeyValueConfig{Badger: nullable.FromValue(badger.Config{InMemory: true})}},
+		{Name: "badger-disk", Config: storage.KeyValueConfig{Badger: nullable.FromValue(badger.Config{DirectoryPath: nullable.FromValue(filepath.Join(dir, "badger"))})}},
+		{Name: "badger-queue", Config: storage.KeyValueConfig{Badger: nullable.FromValue(badger.Config{
+			DirectoryPath: nullable.FromValue(filepath.Join(dir, "badger-queue")),
+			WriteQueue:    nullable.FromValue(badger.WriteQueueConfig{}),
+		})}},
+	}
+	for _, ext := range []string{"json", "yaml", "csv", "db"} {
+		cases = append(cases, Case{
+			Name:     "file-" + ext,
+			Config:   storage.KeyValueConfig{File: nullable.FromValue(file.Config{Path: filepath.Join(dir, "data."+ext)})},
+			Rewrites: ext != "db",
+		})
+	}
+
+	return cases
+}
+
+func Key(i int) string {
+	return fmt.Sprintf("key-%08d", i)
+}
+
+func NewValue(r *rand.Rand, id uint64, size int) Value {
+	payload := make([]byte, size)
+	r.Read(payload)
+
+	return Value{ID: id, Payload: payload}
+}
+
+// Open sets up the provider for c and stores entries values of size bytes.
+// File providers that rewrite the whole file on every write are filled
+// through a journal, so that filling them takes linear time.
+func Open(c Case, entries int, size int) (storage.KeyValueProvider[string, Value], error) {
+	if c.Rewrites {
+		cfg := c.Config.File.GetValue()
+		cfg.Journal = nullable.FromValue(file.JournalConfig{CheckpointEvery: entries + 1})
+		if err := fill(storage.KeyValueConfig{File: nullable.FromValue(cfg)}, entries, size); err != nil {
+			return nil, err
+		}
+	} else if !c.Config.Badger.HasValue() || !c.Config.Badger.GetValue().InMemory {
+		if err := fill(c.Config, entries, size); err != nil {
+			return nil, err
+		}
+	}
+
+	p, err := storage.GetKeyValueProviderFromConfig[string, Value](c.Config)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Setup(); err != nil {
+		return nil, err
+	}
+
+	if c.Config.Badger.HasValue() && c.Config.Badger.GetValue().InMemory {
+		if err := store(p, entries, size); err != nil {
+			p.Shutdown()
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+func fill(cfg storage.KeyValueConfig, entries int, size int) error {
+	p, err := storage.GetKeyValueProviderFromConfig[string, Value](cfg)
+	if err != nil {
+		return err
+	}
+	if err := p.Setup(); err != nil {
+		return err
+	}
+	if err := store(p, entries, size); err != nil {
+		p.Shutdown()
+		return err
+	}
+
+	return p.Shutdown()
+}
+
+func store(p storage.KeyValueProvider[string, Value], entries int, size int) error {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < entries; i++ {
+		if err := p.Store(Key(i), NewValue(r, uint64(i), size)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
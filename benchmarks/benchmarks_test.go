@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package benchmarks
+
+import (
+	"fmt"
+	"github.com/rlshukhov/storage"
+	"math/rand"
+	"testing"
+)
+
+func run(b *testing.B, fn func(b *testing.B, p storage.KeyValueProvider[string, Value], entries int, size int)) {
+	for _, size := range ValueSizes {
+		for _, entries := range Cardinalities {
+			for _, c := range Providers(b.TempDir()) {
+				b.Run(fmt.Sprintf("provider=%s/size=%d/entries=%d", c.Name, size, entries), func(b *testing.B) {
+					if c.Rewrites && entries*size > MaxRewriteBytes {
+						b.Skipf("%s rewrites the whole file, skipping data sets over %d bytes", c.Name, MaxRewriteBytes)
+					}
+
+					p, err := Open(c, entries, size)
+					if err != nil {
+						b.Fatal(err)
+					}
+
+					b.ReportAllocs()
+					b.ResetTimer()
+					fn(b, p, entries, size)
+					b.StopTimer()
+
+					if err := p.Shutdown(); err != nil {
+						b.Fatal(err)
+					}
+				})
+			}
+		}
+	}
+}
+
+func BenchmarkStore(b *testing.B) {
+	run(b, func(b *testing.B, p storage.KeyValueProvider[string, Value], entries int, size int) {
+		value := NewValue(rand.New(rand.NewSource(2)), 0, size)
+		b.SetBytes(int64(size))
+
+		for i := 0; i < b.N; i++ {
+			if err := p.Store(Key(i%entries), value); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkGet(b *testing.B) {
+	run(b, func(b *testing.B, p storage.KeyValueProvider[string, Value], entries int, size int) {
+		b.SetBytes(int64(size))
+
+		for i := 0; i < b.N; i++ {
+			if _, err := p.Get(Key(i % entries)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkGetInto(b *testing.B) {
+	run(b, func(b *testing.B, p storage.KeyValueProvider[string, Value], entries int, size int) {
+		b.SetBytes(int64(size))
+
+		var value Value
+		for i := 0; i < b.N; i++ {
+			if err := storage.GetInto(p, Key(i%entries), &value); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkGetMultiple(b *testing.B) {
+	run(b, func(b *testing.B, p storage.KeyValueProvider[string, Value], entries int, size int) {
+		keys := make([]string, min(entries, 100))
+		for i := range keys {
+			keys[i] = Key(i * (entries / len(keys)))
+		}
+		b.SetBytes(int64(size * len(keys)))
+
+		for i := 0; i < b.N; i++ {
+			if _, err := p.GetMultiple(keys); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkForEach(b *testing.B) {
+	run(b, func(b *testing.B, p storage.KeyValueProvider[string, Value], entries int, size int) {
+		b.SetBytes(int64(size * entries))
+
+		for i := 0; i < b.N; i++ {
+			n := 0
+			err := p.ForEach(func(string, Value) bool {
+				n++
+				return true
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			if n != entries {
+				b.Fatalf("visited %d entries, expected %d", n, entries)
+			}
+		}
+	})
+}
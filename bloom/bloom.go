@@ -0,0 +1,267 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package bloom
+
+import (
+	baseErrors "errors"
+	"fmt"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/errors"
+	"hash/maphash"
+	"math"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Config struct {
+	// ExpectedKeys and FalsePositiveRate size the filter. They default to one
+	// million keys and 1%, which takes about 1.2 MB.
+	ExpectedKeys      uint64  `yaml:"expected_keys,omitempty"`
+	FalsePositiveRate float64 `yaml:"false_positive_rate,omitempty"`
+}
+
+type Stats struct {
+	// ShortCircuited counts the Gets answered with NotFound without asking
+	// the inner provider.
+	ShortCircuited uint64
+	// PassedThrough counts the Gets the filter could not answer.
+	PassedThrough uint64
+	LastRebuild   time.Time
+}
+
+type provider[K ~string | ~uint64, V any] struct {
+	storage.KeyValueProvider[K, V]
+	bits   uint64
+	hashes uint64
+	seeds  [2]maphash.Seed
+
+	rebuildMu sync.Mutex
+	mu        sync.RWMutex
+	filter    *filter
+	building  *filter
+	rebuilt   time.Time
+
+	shortCircuited atomic.Uint64
+	passedThrough  atomic.Uint64
+}
+
+type filter struct {
+	words []atomic.Uint64
+}
+
+const (
+	defaultExpectedKeys      = 1_000_000
+	defaultFalsePositiveRate = 0.01
+)
+
+// New keeps a bloom filter of the keys of inner and answers Get for keys that
+// are definitely missing with errors.NotFound without calling inner. The
+// filter is built on Setup from ForEachKey; until then every Get passes
+// through. Removed keys stay in the filter until the next Rebuild. Keys
+// written to inner by anything else than this provider are not seen, so
+// either route every write through it or call Rebuild after such writes.
+func New[K ~string | ~uint64, V any](inner storage.KeyValueProvider[K, V], cfg Config) (*provider[K, V], error) {
+	if inner == nil {
+		return nil, baseErrors.New("inner provider is nil")
+	}
+
+	n := cfg.ExpectedKeys
+	if n == 0 {
+		n = defaultExpectedKeys
+	}
+	rate := cfg.FalsePositiveRate
+	if rate == 0 {
+		rate = defaultFalsePositiveRate
+	}
+	if !(rate > 0 && rate < 1) {
+		return nil, fmt.Errorf("false_positive_rate %v must be between 0 and 1", rate)
+	}
+
+	bits := uint64(math.Ceil(-float64(n) * math.Log(rate) / (math.Ln2 * math.Ln2)))
+	bits = (bits + 63) / 64 * 64
+	hashes := max(1, uint64(math.Round(float64(bits)/float64(n)*math.Ln2)))
+
+	return &provider[K, V]{
+		KeyValueProvider: inner,
+		bits:             bits,
+		hashes:           hashes,
+		seeds:            [2]maphash.Seed{maphash.MakeSeed(), maphash.MakeSeed()},
+	}, nil
+}
+
+func (p *provider[K, V]) Setup() error {
+	if err := p.KeyValueProvider.Setup(); err != nil {
+		return err
+	}
+
+	return p.Rebuild()
+}
+
+// Rebuild replaces the filter with one built from the keys currently in the
+// inner provider, dropping removed keys. Keys stored while it runs are added
+// to both filters.
+func (p *provider[K, V]) Rebuild() error {
+	p.rebuildMu.Lock()
+	defer p.rebuildMu.Unlock()
+
+	p.mu.Lock()
+	building := p.newFilter()
+	p.building = building
+	p.mu.Unlock()
+
+	err := p.KeyValueProvider.ForEachKey(func(key K) bool {
+		p.addTo(building, key)
+		return true
+	})
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.building = nil
+	if err != nil {
+		return err
+	}
+	p.filter = building
+	p.rebuilt = time.Now()
+	return nil
+}
+
+func (p *provider[K, V]) FilterStats() Stats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return Stats{
+		ShortCircuited: p.shortCircuited.Load(),
+		PassedThrough:  p.passedThrough.Load(),
+		LastRebuild:    p.rebuilt,
+	}
+}
+
+func (p *provider[K, V]) Get(key K) (V, error) {
+	if !p.mayContain(key) {
+		p.shortCircuited.Add(1)
+		var v V
+		return v, errors.NewNotFound(fmt.Errorf("key %v not found", key))
+	}
+
+	p.passedThrough.Add(1)
+	return p.KeyValueProvider.Get(key)
+}
+
+func (p *provider[K, V]) GetMultiple(keys []K) ([]V, error) {
+	for _, key := range keys {
+		if !p.mayContain(key) {
+			p.shortCircuited.Add(1)
+			return []V{}, errors.NewNotFound(fmt.Errorf("key %v not found", key))
+		}
+	}
+
+	p.passedThrough.Add(1)
+	return p.KeyValueProvider.GetMultiple(keys)
+}
+
+func (p *provider[K, V]) Flush() error {
+	return storage.Flush(p.KeyValueProvider)
+}
+
+func (p *provider[K, V]) Store(key K, value V) error {
+	p.add(key)
+	defer p.add(key)
+	return p.KeyValueProvider.Store(key, value)
+}
+
+func (p *provider[K, V]) Update(key K, fn func(value V, exists bool) (V, error)) error {
+	p.add(key)
+	defer p.add(key)
+	return p.KeyValueProvider.Update(key, fn)
+}
+
+func (p *provider[K, V]) StoreWithTTL(key K, value V, ttl time.Duration) error {
+	e, ok := p.KeyValueProvider.(storage.Expirer[K, V])
+	if !ok {
+		return errors.NewUnsupported(fmt.Errorf("%T does not support TTLs", p.KeyValueProvider))
+	}
+
+	p.add(key)
+	defer p.add(key)
+	return e.StoreWithTTL(key, value, ttl)
+}
+
+// Clear empties the filter first, so keys stored concurrently end up in the
+// new filter.
+func (p *provider[K, V]) Clear() error {
+	p.mu.Lock()
+	if p.filter != nil {
+		p.filter = p.newFilter()
+	}
+	p.mu.Unlock()
+
+	return p.KeyValueProvider.Clear()
+}
+
+// add is called before the inner write, so a concurrent Get never misses a
+// key that is already stored, and again after it, so a Rebuild that started
+// in between does not miss it either.
+func (p *provider[K, V]) add(key K) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.filter != nil {
+		p.addTo(p.filter, key)
+	}
+	if p.building != nil {
+		p.addTo(p.building, key)
+	}
+}
+
+func (p *provider[K, V]) addTo(f *filter, key K) {
+	h1, h2 := p.hash(key)
+	for i := uint64(0); i < p.hashes; i++ {
+		bit := (h1 + i*h2) % p.bits
+		f.words[bit/64].Or(1 << (bit % 64))
+	}
+}
+
+func (p *provider[K, V]) mayContain(key K) bool {
+	p.mu.RLock()
+	f := p.filter
+	p.mu.RUnlock()
+	if f == nil {
+		return true
+	}
+
+	h1, h2 := p.hash(key)
+	for i := uint64(0); i < p.hashes; i++ {
+		bit := (h1 + i*h2) % p.bits
+		if f.words[bit/64].Load()&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *provider[K, V]) hash(key K) (uint64, uint64) {
+	s := keyString(key)
+	return maphash.String(p.seeds[0], s), maphash.String(p.seeds[1], s) | 1
+}
+
+func (p *provider[K, V]) newFilter() *filter {
+	return &filter{words: make([]atomic.Uint64, p.bits/64)}
+}
+
+func keyString[K ~string | ~uint64](key K) string {
+	v := reflect.ValueOf(key)
+	if v.Kind() == reflect.Uint64 {
+		return strconv.FormatUint(v.Uint(), 10)
+	}
+
+	return v.String()
+}
@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package bloom
+
+import (
+	"fmt"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/rlshukhov/storage/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestProvider(t *testing.T) {
+	inner, err := storage.GetKeyValueProviderFromConfig[string, int](storage.KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, inner.Setup())
+	for i := 0; i < 500; i++ {
+		require.NoError(t, inner.Store(fmt.Sprintf("before-%d", i), i))
+	}
+
+	_, err = New(inner, Config{FalsePositiveRate: 1})
+	assert.Error(t, err)
+	p, err := New(inner, Config{ExpectedKeys: 2000})
+	require.NoError(t, err)
+	require.NoError(t, p.Rebuild())
+	defer p.Shutdown()
+
+	for i := 0; i < 500; i++ {
+		require.NoError(t, p.Store(fmt.Sprintf("after-%d", i), i))
+	}
+	for i := 0; i < 500; i++ {
+		value, err := p.Get(fmt.Sprintf("before-%d", i))
+		require.NoError(t, err)
+		assert.Equal(t, i, value)
+		value, err = p.Get(fmt.Sprintf("after-%d", i))
+		require.NoError(t, err)
+		assert.Equal(t, i, value)
+	}
+	values, err := p.GetMultiple([]string{"before-1", "after-2"})
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, values)
+
+	passed := p.FilterStats().PassedThrough
+	for i := 0; i < 1000; i++ {
+		_, err := p.Get(fmt.Sprintf("missing-%d", i))
+		assert.True(t, errors.Is(err, errors.NotFound))
+	}
+	_, err = p.GetMultiple([]string{"before-1", "missing-1"})
+	assert.True(t, errors.Is(err, errors.NotFound))
+	stats := p.FilterStats()
+	assert.Greater(t, stats.ShortCircuited, uint64(950))
+	assert.Less(t, stats.PassedThrough-passed, uint64(50))
+
+	require.NoError(t, p.Update("updated", func(value int, exists bool) (int, error) {
+		return 7, nil
+	}))
+	value, err := p.Get("updated")
+	require.NoError(t, err)
+	assert.Equal(t, 7, value)
+	require.NoError(t, storage.StoreIfAbsent[string, int](p, "conditional", 8))
+	value, err = p.Get("conditional")
+	require.NoError(t, err)
+	assert.Equal(t, 8, value)
+	require.NoError(t, p.StoreWithTTL("ttl", 9, time.Hour))
+	value, err = p.Get("ttl")
+	require.NoError(t, err)
+	assert.Equal(t, 9, value)
+
+	require.NoError(t, p.Clear())
+	_, err = p.Get("before-1")
+	assert.True(t, errors.Is(err, errors.NotFound))
+	require.NoError(t, p.Store("fresh", 1))
+	value, err = p.Get("fresh")
+	require.NoError(t, err)
+	assert.Equal(t, 1, value)
+}
@@ -0,0 +1,297 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package cdc
+
+import (
+	"context"
+	baseErrors "errors"
+	"fmt"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/errors"
+	"sync"
+	"time"
+)
+
+type Op string
+
+const (
+	OpStore                 Op = "store"
+	OpRemove                Op = "remove"
+	OpRemovePrefix          Op = "remove_prefix"
+	OpClear                 Op = "clear"
+	OpStoreReference        Op = "store_reference"
+	OpAddReference          Op = "add_reference"
+	OpRemoveReference       Op = "remove_reference"
+	OpRemoveReferenceTarget Op = "remove_reference_target"
+)
+
+// Change is one mutation. Key is the key, prefix or reference the operation
+// was called with, Value is set for stores and Target for reference
+// operations.
+type Change[K ~string | ~uint64, V any] struct {
+	Sequence uint64    `yaml:"sequence" json:"sequence"`
+	Op       Op        `yaml:"op" json:"op"`
+	Key      K         `yaml:"key" json:"key"`
+	Value    V         `yaml:"value,omitempty" json:"value,omitempty"`
+	Target   K         `yaml:"target,omitempty" json:"target,omitempty"`
+	Time     time.Time `yaml:"time" json:"time"`
+}
+
+type provider[K ~string | ~uint64, V any] struct {
+	storage.KeyValueProvider[K, V]
+	log storage.KeyValueProvider[uint64, Change[K, V]]
+
+	mu      sync.Mutex
+	first   uint64
+	last    uint64
+	changed chan struct{}
+}
+
+// New records every mutation of inner in log, keyed by a sequence number
+// that starts at 1 and has no gaps. Both providers must be set up. Mutations
+// are serialized so that sequence order is the order they were applied in. A
+// change is recorded after inner applied it, so a crash in between loses the
+// change.
+func New[K ~string | ~uint64, V any](inner storage.KeyValueProvider[K, V], log storage.KeyValueProvider[uint64, Change[K, V]]) (*provider[K, V], error) {
+	if inner == nil {
+		return nil, baseErrors.New("inner provider is nil")
+	}
+	if log == nil {
+		return nil, baseErrors.New("log provider is nil")
+	}
+
+	p := &provider[K, V]{
+		KeyValueProvider: inner,
+		log:              log,
+		changed:          make(chan struct{}),
+	}
+	err := log.ForEachKey(func(sequence uint64) bool {
+		if p.first == 0 || sequence < p.first {
+			p.first = sequence
+		}
+		p.last = max(p.last, sequence)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read change log: %w", err)
+	}
+	if p.first == 0 {
+		p.first = p.last + 1
+	}
+
+	return p, nil
+}
+
+// Sequence returns the sequence number of the last recorded change.
+func (p *provider[K, V]) Sequence() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.last
+}
+
+// Changes returns up to limit changes starting at sequence from.
+// errors.NotFound is returned if from was already truncated.
+func (p *provider[K, V]) Changes(from uint64, limit int) ([]Change[K, V], error) {
+	if limit <= 0 {
+		return nil, baseErrors.New("limit must be positive")
+	}
+
+	p.mu.Lock()
+	first, last := p.first, p.last
+	p.mu.Unlock()
+
+	from = max(from, 1)
+	if from < first {
+		return nil, errors.NewNotFound(fmt.Errorf("changes before %d were truncated", first))
+	}
+
+	var changes []Change[K, V]
+	for sequence := from; sequence <= last && len(changes) < limit; sequence++ {
+		change, err := p.log.Get(sequence)
+		if err != nil {
+			return changes, err
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// Subscribe calls fn for every change starting at sequence from, and then for
+// new changes as they are recorded, until ctx is done or fn returns an error.
+// Consumers that publish to Kafka or NATS should keep the last sequence they
+// published as their offset and resume from the next one.
+func (p *provider[K, V]) Subscribe(ctx context.Context, from uint64, fn func(change Change[K, V]) error) error {
+	const batch = 256
+
+	from = max(from, 1)
+	for {
+		p.mu.Lock()
+		changed := p.changed
+		p.mu.Unlock()
+
+		changes, err := p.Changes(from, batch)
+		if err != nil {
+			return err
+		}
+		for _, change := range changes {
+			if err := fn(change); err != nil {
+				return err
+			}
+			from = change.Sequence + 1
+		}
+		if len(changes) == batch {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Truncate removes the changes before sequence before and returns how many
+// were removed.
+func (p *provider[K, V]) Truncate(before uint64) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	removed := 0
+	for ; p.first < before && p.first <= p.last; p.first++ {
+		if err := p.log.Remove(p.first); err != nil && !errors.Is(err, errors.NotFound) {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+func (p *provider[K, V]) Flush() error {
+	return storage.Flush(p.KeyValueProvider)
+}
+
+func (p *provider[K, V]) Store(key K, value V) error {
+	return p.mutate(func() (Change[K, V], bool, error) {
+		return Change[K, V]{Op: OpStore, Key: key, Value: value}, true, p.KeyValueProvider.Store(key, value)
+	})
+}
+
+func (p *provider[K, V]) Update(key K, fn func(value V, exists bool) (V, error)) error {
+	return p.mutate(func() (Change[K, V], bool, error) {
+		var stored V
+		err := p.KeyValueProvider.Update(key, func(value V, exists bool) (V, error) {
+			var err error
+			stored, err = fn(value, exists)
+			return stored, err
+		})
+		return Change[K, V]{Op: OpStore, Key: key, Value: stored}, true, err
+	})
+}
+
+func (p *provider[K, V]) Remove(key K) error {
+	return p.mutate(func() (Change[K, V], bool, error) {
+		return Change[K, V]{Op: OpRemove, Key: key}, true, p.KeyValueProvider.Remove(key)
+	})
+}
+
+func (p *provider[K, V]) RemovePrefix(prefix K) (int, error) {
+	var removed int
+	err := p.mutate(func() (Change[K, V], bool, error) {
+		var err error
+		removed, err = p.KeyValueProvider.RemovePrefix(prefix)
+		return Change[K, V]{Op: OpRemovePrefix, Key: prefix}, removed > 0, err
+	})
+
+	return removed, err
+}
+
+// RemoveWhere records a remove for every key pred matched.
+func (p *provider[K, V]) RemoveWhere(pred func(key K, value V) bool) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var matched []K
+	removed, err := p.KeyValueProvider.RemoveWhere(func(key K, value V) bool {
+		if pred(key, value) {
+			matched = append(matched, key)
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		return removed, err
+	}
+
+	for _, key := range matched {
+		if err := p.record(Change[K, V]{Op: OpRemove, Key: key}); err != nil {
+			return removed, err
+		}
+	}
+
+	return removed, nil
+}
+
+func (p *provider[K, V]) Clear() error {
+	return p.mutate(func() (Change[K, V], bool, error) {
+		return Change[K, V]{Op: OpClear}, true, p.KeyValueProvider.Clear()
+	})
+}
+
+func (p *provider[K, V]) StoreReference(reference K, key K) error {
+	return p.mutate(func() (Change[K, V], bool, error) {
+		return Change[K, V]{Op: OpStoreReference, Key: reference, Target: key}, true, p.KeyValueProvider.StoreReference(reference, key)
+	})
+}
+
+func (p *provider[K, V]) AddReference(reference K, key K) error {
+	return p.mutate(func() (Change[K, V], bool, error) {
+		return Change[K, V]{Op: OpAddReference, Key: reference, Target: key}, true, p.KeyValueProvider.AddReference(reference, key)
+	})
+}
+
+func (p *provider[K, V]) RemoveReference(reference K) error {
+	return p.mutate(func() (Change[K, V], bool, error) {
+		return Change[K, V]{Op: OpRemoveReference, Key: reference}, true, p.KeyValueProvider.RemoveReference(reference)
+	})
+}
+
+func (p *provider[K, V]) RemoveReferenceTarget(reference K, key K) error {
+	return p.mutate(func() (Change[K, V], bool, error) {
+		return Change[K, V]{Op: OpRemoveReferenceTarget, Key: reference, Target: key}, true, p.KeyValueProvider.RemoveReferenceTarget(reference, key)
+	})
+}
+
+func (p *provider[K, V]) mutate(apply func() (Change[K, V], bool, error)) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	change, changed, err := apply()
+	if err != nil || !changed {
+		return err
+	}
+
+	return p.record(change)
+}
+
+func (p *provider[K, V]) record(change Change[K, V]) error {
+	change.Sequence = p.last + 1
+	change.Time = time.Now()
+	if err := p.log.Store(change.Sequence, change); err != nil {
+		return fmt.Errorf("failed to record change: %w", err)
+	}
+
+	p.last = change.Sequence
+	close(p.changed)
+	p.changed = make(chan struct{})
+	return nil
+}
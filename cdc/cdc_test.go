@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package cdc
+
+import (
+	"context"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/rlshukhov/storage/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestProvider(t *testing.T) {
+	inner, err := storage.GetKeyValueProviderFromConfig[string, string](storage.KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, inner.Setup())
+	defer inner.Shutdown()
+
+	log, err := storage.GetKeyValueProviderFromConfig[uint64, Change[string, string]](storage.KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true, KeyEncoding: badger.BinaryKeys}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, log.Setup())
+	defer log.Shutdown()
+
+	p, err := New(inner, log)
+	require.NoError(t, err)
+
+	require.NoError(t, p.Store("a", "1"))
+	require.NoError(t, p.Update("a", func(value string, exists bool) (string, error) {
+		return value + "2", nil
+	}))
+	require.NoError(t, p.StoreReference("ref", "a"))
+	require.NoError(t, p.Store("b", "3"))
+	_, err = p.RemovePrefix("nothing")
+	require.NoError(t, err)
+	require.NoError(t, p.Remove("b"))
+
+	changes, err := p.Changes(0, 10)
+	require.NoError(t, err)
+	var summary []string
+	for i, change := range changes {
+		assert.Equal(t, uint64(i+1), change.Sequence)
+		summary = append(summary, string(change.Op)+":"+change.Key+"="+change.Value+change.Target)
+	}
+	assert.Equal(t, []string{"store:a=1", "store:a=12", "store_reference:ref=a", "store:b=3", "remove:b="}, summary)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	received := make(chan Change[string, string], 10)
+	done := make(chan error)
+	go func() {
+		done <- p.Subscribe(ctx, 5, func(change Change[string, string]) error {
+			received <- change
+			return nil
+		})
+	}()
+	assert.Equal(t, OpRemove, (<-received).Op)
+	require.NoError(t, p.Store("c", "4"))
+	select {
+	case change := <-received:
+		assert.Equal(t, uint64(6), change.Sequence)
+	case <-time.After(time.Second):
+		t.Fatal("subscriber did not receive the new change")
+	}
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+
+	removed, err := p.Truncate(4)
+	require.NoError(t, err)
+	assert.Equal(t, 3, removed)
+	_, err = p.Changes(2, 10)
+	assert.True(t, errors.Is(err, errors.NotFound))
+
+	reopened, err := New(inner, log)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(6), reopened.Sequence())
+	require.NoError(t, reopened.Clear())
+	changes, err = reopened.Changes(4, 10)
+	require.NoError(t, err)
+	require.Len(t, changes, 4)
+	assert.Equal(t, OpClear, changes[3].Op)
+	assert.Equal(t, uint64(7), changes[3].Sequence)
+	assert.Equal(t, "b", changes[0].Key)
+}
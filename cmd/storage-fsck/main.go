@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/badger"
+	"os"
+)
+
+func main() {
+	fs := flag.NewFlagSet("storage-fsck", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to a YAML storage config (overrides -storage.* flags)")
+	keyType := fs.String("keys", "string", "key type: string or uint64")
+	repair := fs.Bool("repair", false, "remove the targets of dangling references instead of only reporting them")
+	backup := fs.String("verify-backup", "", "path to a badger backup to check instead of a storage")
+	loadFlags := storage.KeyValueConfigFlags(fs)
+	_ = fs.Parse(os.Args[1:])
+
+	if *backup != "" {
+		if err := verifyBackup(*backup); err != nil {
+			fail(err)
+		}
+		fmt.Println("backup ok")
+		return
+	}
+
+	cfg, err := loadConfig(*configPath, loadFlags)
+	if err != nil {
+		fail(err)
+	}
+
+	mode := storage.RepairNone
+	if *repair {
+		mode = storage.RepairDangling
+	}
+
+	var consistent bool
+	switch *keyType {
+	case "string":
+		consistent, err = run[string](cfg, mode)
+	case "uint64":
+		consistent, err = run[uint64](cfg, mode)
+	default:
+		err = fmt.Errorf("unknown key type %q", *keyType)
+	}
+	if err != nil {
+		fail(err)
+	}
+
+	if !consistent && mode == storage.RepairNone {
+		os.Exit(1)
+	}
+}
+
+func loadConfig(path string, loadFlags func() (storage.KeyValueConfig, error)) (storage.KeyValueConfig, error) {
+	if path == "" {
+		return loadFlags()
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return storage.KeyValueConfig{}, err
+	}
+
+	return storage.ParseKeyValueConfig(data)
+}
+
+func run[K ~string | ~uint64](cfg storage.KeyValueConfig, mode storage.RepairMode) (bool, error) {
+	provider, err := storage.GetKeyValueProviderFromConfig[K, any](cfg)
+	if err != nil {
+		return false, err
+	}
+	if err := provider.Setup(); err != nil {
+		return false, err
+	}
+	defer provider.Shutdown()
+
+	report, err := storage.VerifyReferences(provider, mode)
+
+	fmt.Printf("keys: %d, references: %d, unreferenced keys: %d\n", report.Keys, report.References, len(report.Unreferenced))
+	for reference, keys := range report.Dangling {
+		for _, key := range keys {
+			fmt.Printf("dangling reference %v -> %v\n", reference, key)
+		}
+	}
+	if mode != storage.RepairNone && !report.Consistent() {
+		fmt.Println("repaired")
+	}
+
+	return report.Consistent(), err
+}
+
+func verifyBackup(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return badger.VerifyBackup(f)
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "storage-fsck:", err)
+	os.Exit(2)
+}
@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package main
+
+import (
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+)
+
+type user struct {
+	Name string `yaml:"name" json:"name"`
+}
+
+func TestRun_TypedFile(t *testing.T) {
+	for _, name := range []string{"data.json", "data.yaml"} {
+		t.Run(name, func(t *testing.T) {
+			cfg := storage.KeyValueConfig{
+				File: nullable.FromValue(file.Config{Path: filepath.Join(t.TempDir(), name)}),
+			}
+			open := func() storage.KeyValueProvider[string, user] {
+				p, err := storage.GetKeyValueProviderFromConfig[string, user](cfg)
+				require.NoError(t, err)
+				require.NoError(t, p.Setup())
+				return p
+			}
+
+			p := open()
+			require.NoError(t, p.Store("alice", user{Name: "Alice"}))
+			require.NoError(t, p.Store("bob", user{Name: "Bob"}))
+			require.NoError(t, p.StoreReference("admin", "alice"))
+			require.NoError(t, p.AddReference("admin", "bob"))
+			require.NoError(t, p.Remove("bob"))
+			require.NoError(t, p.Shutdown())
+
+			consistent, err := run[string](cfg, storage.RepairNone)
+			require.NoError(t, err)
+			assert.False(t, consistent)
+
+			_, err = run[string](cfg, storage.RepairDangling)
+			require.NoError(t, err)
+			consistent, err = run[string](cfg, storage.RepairNone)
+			require.NoError(t, err)
+			assert.True(t, consistent)
+
+			p = open()
+			defer p.Shutdown()
+			val, err := p.GetByReference("admin")
+			require.NoError(t, err)
+			assert.Equal(t, user{Name: "Alice"}, val, "the repaired file still opens with the typed provider")
+			values, err := p.GetAllByReference("admin")
+			require.NoError(t, err)
+			assert.Equal(t, []user{{Name: "Alice"}}, values)
+		})
+	}
+}
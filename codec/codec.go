@@ -0,0 +1,196 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package codec
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"reflect"
+)
+
+// Codec encodes values. Tag identifies the codec in stored values, so it must
+// never change once data has been written with it.
+type Codec interface {
+	Name() string
+	Tag() byte
+	Marshal(value any) ([]byte, error)
+	Unmarshal(data []byte, target any) error
+}
+
+// BufferMarshaler is implemented by codecs that encode into a buffer, so
+// callers can reuse buffers across values.
+type BufferMarshaler interface {
+	MarshalTo(buf *bytes.Buffer, value any) error
+}
+
+// MarshalTo appends the encoding of value to buf.
+func MarshalTo(c Codec, buf *bytes.Buffer, value any) error {
+	if m, ok := c.(BufferMarshaler); ok {
+		return m.MarshalTo(buf, value)
+	}
+
+	encoded, err := c.Marshal(value)
+	if err != nil {
+		return err
+	}
+	buf.Write(encoded)
+
+	return nil
+}
+
+var (
+	Gob     Codec = gobCodec{}
+	Proto   Codec = protoCodec{}
+	MsgPack Codec = msgPackCodec{}
+)
+
+var all = []Codec{Gob, Proto, MsgPack, SharedGob}
+
+var messageType = reflect.TypeFor[proto.Message]()
+
+// For returns Proto when V is a protobuf message and Gob otherwise.
+func For[V any]() Codec {
+	if IsProto[V]() {
+		return Proto
+	}
+
+	return Gob
+}
+
+func IsProto[V any]() bool {
+	return reflect.TypeFor[V]().Implements(messageType)
+}
+
+func ByName(name string) (Codec, error) {
+	for _, c := range all {
+		if c.Name() == name {
+			return c, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown codec %q (gob, gob-shared, proto or msgpack supported)", name)
+}
+
+func ByTag(tag byte) (Codec, error) {
+	for _, c := range all {
+		if c.Tag() == tag {
+			return c, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown codec tag %d", tag)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string {
+	return "gob"
+}
+
+func (gobCodec) Tag() byte {
+	return 1
+}
+
+func (c gobCodec) Marshal(value any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := c.MarshalTo(&buf, value); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// MarshalTo uses a new encoder for every value, so each encoding carries its
+// type information and can be decoded on its own.
+func (gobCodec) MarshalTo(buf *bytes.Buffer, value any) error {
+	return gob.NewEncoder(buf).Encode(value)
+}
+
+func (gobCodec) Unmarshal(data []byte, target any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(target)
+}
+
+type protoCodec struct{}
+
+func (protoCodec) Name() string {
+	return "proto"
+}
+
+func (protoCodec) Tag() byte {
+	return 2
+}
+
+func (protoCodec) Marshal(value any) ([]byte, error) {
+	msg, ok := value.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a proto.Message", value)
+	}
+
+	return proto.Marshal(msg)
+}
+
+func (protoCodec) MarshalTo(buf *bytes.Buffer, value any) error {
+	msg, ok := value.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%T is not a proto.Message", value)
+	}
+
+	encoded, err := proto.MarshalOptions{}.MarshalAppend(buf.AvailableBuffer(), msg)
+	if err != nil {
+		return err
+	}
+	buf.Write(encoded)
+
+	return nil
+}
+
+// Unmarshal decodes into target, a pointer to a proto.Message pointer. A nil
+// message is allocated first.
+func (protoCodec) Unmarshal(data []byte, target any) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Pointer || v.IsNil() || !v.Elem().Type().Implements(messageType) {
+		return fmt.Errorf("%T is not a pointer to a proto.Message", target)
+	}
+
+	elem := v.Elem()
+	if elem.Kind() == reflect.Pointer && elem.IsNil() {
+		elem.Set(reflect.New(elem.Type().Elem()))
+	}
+
+	return proto.Unmarshal(data, elem.Interface().(proto.Message))
+}
+
+type msgPackCodec struct{}
+
+func (msgPackCodec) Name() string {
+	return "msgpack"
+}
+
+func (msgPackCodec) Tag() byte {
+	return 3
+}
+
+func (msgPackCodec) Marshal(value any) ([]byte, error) {
+	return msgpack.Marshal(value)
+}
+
+func (msgPackCodec) MarshalTo(buf *bytes.Buffer, value any) error {
+	enc := msgpack.GetEncoder()
+	defer msgpack.PutEncoder(enc)
+	enc.Reset(buf)
+
+	return enc.Encode(value)
+}
+
+func (msgPackCodec) Unmarshal(data []byte, target any) error {
+	return msgpack.Unmarshal(data, target)
+}
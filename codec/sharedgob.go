@@ -0,0 +1,279 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"reflect"
+	"slices"
+	"sync"
+)
+
+// Descriptors stores the gob type descriptors values encoded with a shared
+// gob codec refer to. A descriptor must be kept as long as values refer to
+// it; descriptors never change, so storing one twice is harmless.
+type Descriptors interface {
+	LoadDescriptor(id uint64) ([]byte, error)
+	StoreDescriptor(id uint64, descriptor []byte) error
+}
+
+// SharedGob identifies the shared gob codec in ByName and ByTag. It encodes
+// nothing itself; providers bind it to their storage with NewSharedGob.
+var SharedGob Codec = &sharedGobCodec{}
+
+// NewSharedGob returns a gob codec that stores the type descriptor of a value
+// type once in descriptors instead of in every value, and reuses primed
+// encoders and decoders. Values are an 8-byte descriptor ID followed by a gob
+// value message. Types with interface fields are not supported, as gob sends
+// the types of interface values along with the values.
+func NewSharedGob(descriptors Descriptors) Codec {
+	return &sharedGobCodec{descriptors: descriptors}
+}
+
+type sharedGobCodec struct {
+	descriptors Descriptors
+	// encoders maps reflect.Type to *gobEncoders.
+	encoders sync.Map
+	// decoders maps descriptor IDs to *gobDecoders.
+	decoders sync.Map
+}
+
+func (*sharedGobCodec) Name() string {
+	return "gob-shared"
+}
+
+func (*sharedGobCodec) Tag() byte {
+	return 4
+}
+
+func (c *sharedGobCodec) Marshal(value any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := c.MarshalTo(&buf, value); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (c *sharedGobCodec) MarshalTo(buf *bytes.Buffer, value any) error {
+	if c.descriptors == nil {
+		return errors.New("gob-shared codec is not bound to a provider")
+	}
+
+	encoders, err := c.encodersFor(value)
+	if err != nil {
+		return err
+	}
+	enc, err := encoders.get(value)
+	if err != nil {
+		return err
+	}
+
+	buf.Write(binary.BigEndian.AppendUint64(buf.AvailableBuffer(), encoders.id))
+	enc.out.buf = buf
+	err = enc.Encode(value)
+	enc.out.buf = nil
+	if err != nil {
+		// The encoder may have sent part of a message, drop it.
+		return err
+	}
+	encoders.pool.Put(enc)
+
+	return nil
+}
+
+func (c *sharedGobCodec) Unmarshal(data []byte, target any) error {
+	if c.descriptors == nil {
+		return errors.New("gob-shared codec is not bound to a provider")
+	}
+	if len(data) < 8 {
+		return errors.New("gob descriptor ID is missing")
+	}
+
+	decoders, err := c.decodersFor(binary.BigEndian.Uint64(data))
+	if err != nil {
+		return err
+	}
+	dec, err := decoders.get()
+	if err != nil {
+		return err
+	}
+
+	dec.in.Reset(data[8:])
+	if err := dec.Decode(target); err != nil {
+		return err
+	}
+	dec.in.Reset(nil)
+	decoders.pool.Put(dec)
+
+	return nil
+}
+
+type sharedEncoder struct {
+	*gob.Encoder
+	out *bufferWriter
+}
+
+type bufferWriter struct {
+	buf *bytes.Buffer
+}
+
+func (w *bufferWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+type gobEncoders struct {
+	id         uint64
+	descriptor []byte
+	pool       sync.Pool
+}
+
+// encodersFor returns the encoders of the type of value. The descriptor of a
+// new type is stored before any value refers to it.
+func (c *sharedGobCodec) encodersFor(value any) (*gobEncoders, error) {
+	t := reflect.TypeOf(value)
+	if t == nil {
+		return nil, errors.New("gob-shared codec cannot encode nil")
+	}
+	if e, ok := c.encoders.Load(t); ok {
+		return e.(*gobEncoders), nil
+	}
+	if holdsInterfaces(t, nil) {
+		return nil, fmt.Errorf("gob-shared codec does not support interface fields, %v has some", t)
+	}
+
+	// The first value an encoder sends carries the descriptor, the second
+	// one does not.
+	out := &bufferWriter{buf: &bytes.Buffer{}}
+	enc := &sharedEncoder{Encoder: gob.NewEncoder(out), out: out}
+	if err := enc.Encode(value); err != nil {
+		return nil, err
+	}
+	first := bytes.Clone(out.buf.Bytes())
+	out.buf.Reset()
+	if err := enc.Encode(value); err != nil {
+		return nil, err
+	}
+	descriptor := first[:len(first)-out.buf.Len()]
+
+	h := fnv.New64a()
+	h.Write(descriptor)
+	encoders := &gobEncoders{id: h.Sum64(), descriptor: descriptor}
+	if err := c.descriptors.StoreDescriptor(encoders.id, descriptor); err != nil {
+		return nil, err
+	}
+	out.buf = nil
+	encoders.pool.Put(enc)
+
+	e, _ := c.encoders.LoadOrStore(t, encoders)
+	return e.(*gobEncoders), nil
+}
+
+// get returns an encoder that sent the descriptor already, priming a new one
+// with value.
+func (e *gobEncoders) get(value any) (*sharedEncoder, error) {
+	if enc, ok := e.pool.Get().(*sharedEncoder); ok {
+		return enc, nil
+	}
+
+	out := &bufferWriter{buf: &bytes.Buffer{}}
+	enc := &sharedEncoder{Encoder: gob.NewEncoder(out), out: out}
+	if err := enc.Encode(value); err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(out.buf.Bytes(), e.descriptor) {
+		return nil, fmt.Errorf("gob descriptor of %T changed", value)
+	}
+	out.buf = nil
+
+	return enc, nil
+}
+
+type sharedDecoder struct {
+	*gob.Decoder
+	// in implements io.ByteReader, so the decoder reads no further than the
+	// current message.
+	in *bytes.Reader
+}
+
+type gobDecoders struct {
+	descriptor []byte
+	pool       sync.Pool
+}
+
+func (c *sharedGobCodec) decodersFor(id uint64) (*gobDecoders, error) {
+	if d, ok := c.decoders.Load(id); ok {
+		return d.(*gobDecoders), nil
+	}
+
+	descriptor, err := c.descriptors.LoadDescriptor(id)
+	if err != nil {
+		return nil, err
+	}
+	h := fnv.New64a()
+	h.Write(descriptor)
+	if h.Sum64() != id {
+		return nil, fmt.Errorf("gob descriptor %016x does not match its ID", id)
+	}
+
+	d, _ := c.decoders.LoadOrStore(id, &gobDecoders{descriptor: descriptor})
+	return d.(*gobDecoders), nil
+}
+
+// get returns a decoder that read the descriptor already.
+func (d *gobDecoders) get() (*sharedDecoder, error) {
+	if dec, ok := d.pool.Get().(*sharedDecoder); ok {
+		return dec, nil
+	}
+
+	in := bytes.NewReader(d.descriptor)
+	dec := &sharedDecoder{Decoder: gob.NewDecoder(in), in: in}
+	// The descriptor holds no value, so decoding stops at its end once the
+	// types are read.
+	if err := dec.DecodeValue(reflect.Value{}); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+
+	return dec, nil
+}
+
+// holdsInterfaces reports whether values of t can hold interface values gob
+// would encode.
+func holdsInterfaces(t reflect.Type, visiting []reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Pointer, reflect.Slice, reflect.Array:
+		return holdsInterfaces(t.Elem(), visiting)
+	case reflect.Map:
+		return holdsInterfaces(t.Key(), visiting) || holdsInterfaces(t.Elem(), visiting)
+	case reflect.Struct:
+	default:
+		return false
+	}
+	if slices.Contains(visiting, t) {
+		return false
+	}
+	visiting = append(visiting, t)
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if field.IsExported() && holdsInterfaces(field.Type, visiting) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,344 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage/azureblob"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/rlshukhov/storage/file"
+	"github.com/rlshukhov/storage/firestore"
+	"github.com/rlshukhov/storage/keyring"
+	"github.com/rlshukhov/storage/localstorage"
+	"github.com/rlshukhov/storage/memcached"
+	"github.com/rlshukhov/storage/mysql"
+	"github.com/rlshukhov/storage/rocksdb"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func ParseKeyValueConfig(data []byte) (KeyValueConfig, error) {
+	var cfg KeyValueConfig
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return cfg, fmt.Errorf("invalid storage config: %w", err)
+	}
+
+	return cfg, cfg.Validate()
+}
+
+func (c KeyValueConfig) Validate() error {
+	var errs []error
+
+	configured := 0
+	if c.AzureBlob.HasValue() {
+		configured++
+		errs = append(errs, prefixErrors("azure_blob", c.AzureBlob.GetValue().Validate())...)
+	}
+	if c.Badger.HasValue() {
+		configured++
+		errs = append(errs, prefixErrors("badger", c.Badger.GetValue().Validate())...)
+	}
+	if c.File.HasValue() {
+		configured++
+		errs = append(errs, prefixErrors("file", c.File.GetValue().Validate())...)
+	}
+	if c.Firestore.HasValue() {
+		configured++
+		errs = append(errs, prefixErrors("firestore", c.Firestore.GetValue().Validate())...)
+	}
+	if c.Keyring.HasValue() {
+		configured++
+		errs = append(errs, prefixErrors("keyring", c.Keyring.GetValue().Validate())...)
+	}
+	if c.LocalStorage.HasValue() {
+		configured++
+		errs = append(errs, prefixErrors("local_storage", c.LocalStorage.GetValue().Validate())...)
+	}
+	if c.Memcached.HasValue() {
+		configured++
+		errs = append(errs, prefixErrors("memcached", c.Memcached.GetValue().Validate())...)
+	}
+	if c.MySQL.HasValue() {
+		configured++
+		errs = append(errs, prefixErrors("mysql", c.MySQL.GetValue().Validate())...)
+	}
+	if c.RocksDB.HasValue() {
+		configured++
+		errs = append(errs, prefixErrors("rocksdb", c.RocksDB.GetValue().Validate())...)
+	}
+
+	switch {
+	case configured == 0:
+		errs = append(errs, fmt.Errorf("storage provider is not configured: set one of %s", providerNames))
+	case configured > 1:
+		errs = append(errs, fmt.Errorf("multiple storage providers are configured: set only one of %s", providerNames))
+	}
+
+	if c.Lazy.HasValue() {
+		errs = append(errs, prefixErrors("lazy", c.Lazy.GetValue().Validate())...)
+	}
+	if c.Backup.HasValue() {
+		errs = append(errs, prefixErrors("backup", c.Backup.GetValue().Validate())...)
+	}
+
+	return errors.Join(errs...)
+}
+
+const providerNames = "azure_blob, badger, file, firestore, keyring, local_storage, memcached, mysql or rocksdb"
+
+func prefixErrors(prefix string, err error) []error {
+	if err == nil {
+		return nil
+	}
+
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{fmt.Errorf("%s: %w", prefix, err)}
+	}
+
+	var errs []error
+	for _, e := range joined.Unwrap() {
+		errs = append(errs, prefixErrors(prefix, e)...)
+	}
+
+	return errs
+}
+
+const envPrefix = "STORAGE_"
+
+type configSource struct {
+	provider string
+
+	azureBlobConnectionString string
+	azureBlobAccountURL       string
+	azureBlobContainer        string
+
+	badgerPath     string
+	badgerInMemory bool
+	badgerReadOnly bool
+
+	filePath     string
+	fileContent  string
+	fileReadOnly bool
+
+	firestoreProjectID  string
+	firestoreCollection string
+
+	keyringService string
+
+	localStoragePrefix string
+
+	memcachedServers   string
+	memcachedKeyPrefix string
+	memcachedTTL       time.Duration
+
+	mysqlDSN   string
+	mysqlTable string
+
+	rocksdbPath     string
+	rocksdbReadOnly bool
+
+	lazy               bool
+	lazyInitialBackoff time.Duration
+	lazyMaxBackoff     time.Duration
+}
+
+func LoadKeyValueConfigFromEnv() (KeyValueConfig, error) {
+	return loadKeyValueConfigFromEnv(os.LookupEnv)
+}
+
+func loadKeyValueConfigFromEnv(lookup func(key string) (string, bool)) (KeyValueConfig, error) {
+	var src configSource
+	var errs []error
+
+	str := func(name string, target *string) {
+		if v, ok := lookup(envPrefix + name); ok {
+			*target = v
+		}
+	}
+	boolean := func(name string, target *bool) {
+		if v, ok := lookup(envPrefix + name); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%s: %w", envPrefix, name, err))
+			}
+			*target = b
+		}
+	}
+	duration := func(name string, target *time.Duration) {
+		if v, ok := lookup(envPrefix + name); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%s: %w", envPrefix, name, err))
+			}
+			*target = d
+		}
+	}
+
+	str("PROVIDER", &src.provider)
+	str("AZURE_BLOB_CONNECTION_STRING", &src.azureBlobConnectionString)
+	str("AZURE_BLOB_ACCOUNT_URL", &src.azureBlobAccountURL)
+	str("AZURE_BLOB_CONTAINER", &src.azureBlobContainer)
+	str("BADGER_PATH", &src.badgerPath)
+	boolean("BADGER_IN_MEMORY", &src.badgerInMemory)
+	boolean("BADGER_READ_ONLY", &src.badgerReadOnly)
+	str("FILE_PATH", &src.filePath)
+	str("FILE_CONTENT", &src.fileContent)
+	boolean("FILE_READ_ONLY", &src.fileReadOnly)
+	str("FIRESTORE_PROJECT_ID", &src.firestoreProjectID)
+	str("FIRESTORE_COLLECTION", &src.firestoreCollection)
+	str("KEYRING_SERVICE", &src.keyringService)
+	str("LOCAL_STORAGE_PREFIX", &src.localStoragePrefix)
+	str("MEMCACHED_SERVERS", &src.memcachedServers)
+	str("MEMCACHED_KEY_PREFIX", &src.memcachedKeyPrefix)
+	duration("MEMCACHED_TTL", &src.memcachedTTL)
+	str("MYSQL_DSN", &src.mysqlDSN)
+	str("MYSQL_TABLE", &src.mysqlTable)
+	str("ROCKSDB_PATH", &src.rocksdbPath)
+	boolean("ROCKSDB_READ_ONLY", &src.rocksdbReadOnly)
+	boolean("LAZY", &src.lazy)
+	duration("LAZY_INITIAL_BACKOFF", &src.lazyInitialBackoff)
+	duration("LAZY_MAX_BACKOFF", &src.lazyMaxBackoff)
+
+	if err := errors.Join(errs...); err != nil {
+		return KeyValueConfig{}, err
+	}
+
+	return src.build()
+}
+
+func KeyValueConfigFlags(fs *flag.FlagSet) func() (KeyValueConfig, error) {
+	var src configSource
+
+	fs.StringVar(&src.provider, "storage.provider", "", "storage provider: "+providerNames)
+	fs.StringVar(&src.azureBlobConnectionString, "storage.azure-blob.connection-string", "", "azure storage connection string")
+	fs.StringVar(&src.azureBlobAccountURL, "storage.azure-blob.account-url", "", "azure storage account url, authenticated with managed identity")
+	fs.StringVar(&src.azureBlobContainer, "storage.azure-blob.container", "", "azure blob container name")
+	fs.StringVar(&src.badgerPath, "storage.badger.path", "", "badger database directory")
+	fs.BoolVar(&src.badgerInMemory, "storage.badger.in-memory", false, "run badger in memory")
+	fs.BoolVar(&src.badgerReadOnly, "storage.badger.read-only", false, "open badger read-only")
+	fs.StringVar(&src.filePath, "storage.file.path", "", "path of the .json, .yaml, .yml, .csv or .db data file")
+	fs.StringVar(&src.fileContent, "storage.file.content", "", "inline JSON or YAML content")
+	fs.BoolVar(&src.fileReadOnly, "storage.file.read-only", false, "open the data file read-only")
+	fs.StringVar(&src.firestoreProjectID, "storage.firestore.project-id", "", "google cloud project id")
+	fs.StringVar(&src.firestoreCollection, "storage.firestore.collection", "", "firestore collection path")
+	fs.StringVar(&src.keyringService, "storage.keyring.service", "", "operating system keychain service name")
+	fs.StringVar(&src.localStoragePrefix, "storage.local-storage.prefix", "", "prefix for all browser localStorage items")
+	fs.StringVar(&src.memcachedServers, "storage.memcached.servers", "", "comma-separated memcached server addresses")
+	fs.StringVar(&src.memcachedKeyPrefix, "storage.memcached.key-prefix", "", "prefix for all memcached keys")
+	fs.DurationVar(&src.memcachedTTL, "storage.memcached.ttl", 0, "memcached entry expiration")
+	fs.StringVar(&src.mysqlDSN, "storage.mysql.dsn", "", "mysql data source name")
+	fs.StringVar(&src.mysqlTable, "storage.mysql.table", "", "mysql table name")
+	fs.StringVar(&src.rocksdbPath, "storage.rocksdb.path", "", "rocksdb database directory")
+	fs.BoolVar(&src.rocksdbReadOnly, "storage.rocksdb.read-only", false, "open rocksdb read-only")
+	fs.BoolVar(&src.lazy, "storage.lazy", false, "connect lazily and reconnect with backoff")
+	fs.DurationVar(&src.lazyInitialBackoff, "storage.lazy.initial-backoff", 0, "initial reconnect backoff")
+	fs.DurationVar(&src.lazyMaxBackoff, "storage.lazy.max-backoff", 0, "maximum reconnect backoff")
+
+	return func() (KeyValueConfig, error) {
+		return src.build()
+	}
+}
+
+func (s configSource) build() (KeyValueConfig, error) {
+	var cfg KeyValueConfig
+
+	switch strings.ToLower(s.provider) {
+	case "azure_blob":
+		cfg.AzureBlob = nullable.FromValue(azureblob.Config{
+			ConnectionString: s.azureBlobConnectionString,
+			AccountURL:       s.azureBlobAccountURL,
+			Container:        s.azureBlobContainer,
+		})
+
+	case "badger":
+		badgerCfg := badger.Config{
+			InMemory: s.badgerInMemory,
+			ReadOnly: s.badgerReadOnly,
+		}
+		if s.badgerPath != "" {
+			badgerCfg.DirectoryPath = nullable.FromValue(s.badgerPath)
+		}
+		cfg.Badger = nullable.FromValue(badgerCfg)
+
+	case "file":
+		cfg.File = nullable.FromValue(file.Config{
+			Path:     s.filePath,
+			Content:  s.fileContent,
+			ReadOnly: s.fileReadOnly,
+		})
+
+	case "firestore":
+		cfg.Firestore = nullable.FromValue(firestore.Config{
+			ProjectID:  s.firestoreProjectID,
+			Collection: s.firestoreCollection,
+		})
+
+	case "keyring":
+		cfg.Keyring = nullable.FromValue(keyring.Config{
+			Service: s.keyringService,
+		})
+
+	case "local_storage":
+		cfg.LocalStorage = nullable.FromValue(localstorage.Config{
+			Prefix: s.localStoragePrefix,
+		})
+
+	case "memcached":
+		var servers []string
+		for _, server := range strings.Split(s.memcachedServers, ",") {
+			if server = strings.TrimSpace(server); server != "" {
+				servers = append(servers, server)
+			}
+		}
+		cfg.Memcached = nullable.FromValue(memcached.Config{
+			Servers:   servers,
+			KeyPrefix: s.memcachedKeyPrefix,
+			TTL:       s.memcachedTTL,
+		})
+
+	case "mysql":
+		cfg.MySQL = nullable.FromValue(mysql.Config{
+			DSN:   s.mysqlDSN,
+			Table: s.mysqlTable,
+		})
+
+	case "rocksdb":
+		cfg.RocksDB = nullable.FromValue(rocksdb.Config{
+			Path:     s.rocksdbPath,
+			ReadOnly: s.rocksdbReadOnly,
+		})
+
+	case "":
+		return cfg, errors.New("storage provider is not set")
+
+	default:
+		return cfg, fmt.Errorf("unknown storage provider %q: expected %s", s.provider, providerNames)
+	}
+
+	if s.lazy || s.lazyInitialBackoff != 0 || s.lazyMaxBackoff != 0 {
+		cfg.Lazy = nullable.FromValue(LazyConfig{
+			InitialBackoff: s.lazyInitialBackoff,
+			MaxBackoff:     s.lazyMaxBackoff,
+		})
+	}
+
+	return cfg, cfg.Validate()
+}
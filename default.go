@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var (
+	defaultMu       sync.RWMutex
+	defaultProvider any
+)
+
+// SetDefault makes provider the one used by the package-level Get, Store and
+// Remove. The provider must already be set up; wrap it with the lazy config
+// to connect on first use instead.
+func SetDefault[K ~string | ~uint64, V any](provider KeyValueProvider[K, V]) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	if provider == nil {
+		defaultProvider = nil
+		return
+	}
+	defaultProvider = provider
+}
+
+func Default[K ~string | ~uint64, V any]() (KeyValueProvider[K, V], error) {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+
+	if defaultProvider == nil {
+		return nil, errors.New("no default storage provider is set")
+	}
+
+	provider, ok := defaultProvider.(KeyValueProvider[K, V])
+	if !ok {
+		return nil, fmt.Errorf("default storage provider %T is not a KeyValueProvider[%v, %v]", defaultProvider, reflect.TypeFor[K](), reflect.TypeFor[V]())
+	}
+
+	return provider, nil
+}
+
+func Get[K ~string | ~uint64, V any](key K) (V, error) {
+	provider, err := Default[K, V]()
+	if err != nil {
+		var v V
+		return v, err
+	}
+
+	return provider.Get(key)
+}
+
+func Store[K ~string | ~uint64, V any](key K, value V) error {
+	provider, err := Default[K, V]()
+	if err != nil {
+		return err
+	}
+
+	return provider.Store(key, value)
+}
+
+func Remove[K ~string | ~uint64, V any](key K) error {
+	provider, err := Default[K, V]()
+	if err != nil {
+		return err
+	}
+
+	return provider.Remove(key)
+}
@@ -0,0 +1,284 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package election
+
+import (
+	"context"
+	baseErrors "errors"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/errors"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	TTL             time.Duration `yaml:"ttl"`
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"`
+	PollInterval    time.Duration `yaml:"poll_interval,omitempty"`
+}
+
+type Record struct {
+	Leader    string    `yaml:"leader" json:"leader"`
+	Term      uint64    `yaml:"term" json:"term"`
+	ExpiresAt time.Time `yaml:"expires_at" json:"expires_at"`
+}
+
+func (r Record) alive(now time.Time) bool {
+	return r.Leader != "" && now.Before(r.ExpiresAt)
+}
+
+type Election struct {
+	provider storage.KeyValueProvider[string, Record]
+	key      string
+	cfg      Config
+
+	mu          sync.Mutex
+	candidate   string
+	campaigning bool
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+var errLeaderExists = baseErrors.New("leader exists")
+
+func New(provider storage.KeyValueProvider[string, Record], name string, cfg Config) (*Election, error) {
+	if name == "" {
+		return nil, baseErrors.New("election name is empty")
+	}
+	if cfg.TTL <= 0 {
+		return nil, baseErrors.New("election ttl must be positive")
+	}
+	if cfg.RefreshInterval <= 0 {
+		cfg.RefreshInterval = cfg.TTL / 3
+	}
+	if cfg.RefreshInterval >= cfg.TTL {
+		return nil, baseErrors.New("election refresh interval must be less than ttl")
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = cfg.RefreshInterval
+	}
+
+	return &Election{
+		provider: provider,
+		key:      "election/" + name,
+		cfg:      cfg,
+	}, nil
+}
+
+func (e *Election) Campaign(ctx context.Context, candidate string) error {
+	if candidate == "" {
+		return baseErrors.New("candidate is empty")
+	}
+
+	e.mu.Lock()
+	if e.candidate != "" {
+		select {
+		case <-e.done:
+			e.candidate = ""
+		default:
+			defer e.mu.Unlock()
+			if e.candidate == candidate {
+				return nil
+			}
+			return baseErrors.New("election is already campaigning as " + e.candidate)
+		}
+	}
+	if e.campaigning {
+		e.mu.Unlock()
+		return baseErrors.New("election is already campaigning")
+	}
+	e.campaigning = true
+	e.mu.Unlock()
+
+	// The lock is not held while waiting for the leader to go away, so
+	// IsLeader and Resign do not block on a campaign.
+	err := e.campaign(ctx, candidate)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.campaigning = false
+	if err != nil {
+		return err
+	}
+
+	e.candidate = candidate
+	e.stop = make(chan struct{})
+	e.done = make(chan struct{})
+	go e.refresh(candidate, e.stop, e.done)
+
+	return nil
+}
+
+func (e *Election) campaign(ctx context.Context, candidate string) error {
+	ticker := time.NewTicker(e.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		err := e.acquire(candidate)
+		if !baseErrors.Is(err, errLeaderExists) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Resign stops refreshing the lease and releases it. If ctx expires first,
+// the election stays the leader until the lease expires after TTL, and Resign
+// can be retried to release it.
+func (e *Election) Resign(ctx context.Context) error {
+	e.mu.Lock()
+	candidate, stop, done := e.candidate, e.stop, e.done
+	e.stop = nil
+	e.mu.Unlock()
+
+	if candidate == "" {
+		return errors.NotLeader
+	}
+
+	if stop != nil {
+		close(stop)
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	err := e.provider.Update(e.key, func(record Record, exists bool) (Record, error) {
+		if !exists || record.Leader != candidate {
+			return record, errors.NotLeader
+		}
+
+		record.ExpiresAt = time.Time{}
+		return record, nil
+	})
+	if err != nil && !errors.Is(err, errors.NotLeader) {
+		return err
+	}
+
+	e.mu.Lock()
+	if e.done == done {
+		e.candidate = ""
+	}
+	e.mu.Unlock()
+
+	return err
+}
+
+func (e *Election) IsLeader() bool {
+	e.mu.Lock()
+	candidate := e.candidate
+	e.mu.Unlock()
+
+	if candidate == "" {
+		return false
+	}
+
+	record, err := e.Leader()
+	return err == nil && record.Leader == candidate
+}
+
+func (e *Election) Leader() (Record, error) {
+	record, err := e.provider.Get(e.key)
+	if err != nil {
+		return Record{}, err
+	}
+
+	if !record.alive(time.Now()) {
+		return Record{}, errors.NotFound
+	}
+
+	return record, nil
+}
+
+func (e *Election) Observe(ctx context.Context) <-chan Record {
+	ch := make(chan Record, 1)
+
+	go func() {
+		defer close(ch)
+
+		ticker := time.NewTicker(e.cfg.PollInterval)
+		defer ticker.Stop()
+
+		var last Record
+		first := true
+		for {
+			record, err := e.Leader()
+			if err == nil || errors.Is(err, errors.NotFound) {
+				if first || record.Leader != last.Leader || record.Term != last.Term {
+					select {
+					case ch <- record:
+					case <-ctx.Done():
+						return
+					}
+					last = record
+					first = false
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return ch
+}
+
+func (e *Election) acquire(candidate string) error {
+	return e.provider.Update(e.key, func(record Record, exists bool) (Record, error) {
+		now := time.Now()
+		if exists && record.alive(now) && record.Leader != candidate {
+			return record, errLeaderExists
+		}
+
+		if !exists || record.Leader != candidate || !record.alive(now) {
+			record.Term++
+		}
+		record.Leader = candidate
+		record.ExpiresAt = now.Add(e.cfg.TTL)
+
+		return record, nil
+	})
+}
+
+func (e *Election) refresh(candidate string, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(e.cfg.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		err := e.provider.Update(e.key, func(record Record, exists bool) (Record, error) {
+			if !exists || record.Leader != candidate {
+				return record, errors.NotLeader
+			}
+
+			record.ExpiresAt = time.Now().Add(e.cfg.TTL)
+			return record, nil
+		})
+		if errors.Is(err, errors.NotLeader) {
+			return
+		}
+	}
+}
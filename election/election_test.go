@@ -0,0 +1,127 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package election
+
+import (
+	"context"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/rlshukhov/storage/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func newProvider(t *testing.T) storage.KeyValueProvider[string, Record] {
+	p, err := storage.GetKeyValueProviderFromConfig[string, Record](storage.KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{
+			InMemory: true,
+		}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	t.Cleanup(func() {
+		require.NoError(t, p.Shutdown())
+	})
+
+	return p
+}
+
+func TestElection_CampaignAndResign(t *testing.T) {
+	p := newProvider(t)
+	cfg := Config{TTL: 300 * time.Millisecond, PollInterval: 20 * time.Millisecond}
+
+	first, err := New(p, "leader", cfg)
+	require.NoError(t, err)
+	second, err := New(p, "leader", cfg)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	require.NoError(t, first.Campaign(ctx, "first"))
+	assert.True(t, first.IsLeader())
+
+	short, shortCancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer shortCancel()
+	assert.ErrorIs(t, second.Campaign(short, "second"), context.DeadlineExceeded)
+
+	record, err := second.Leader()
+	require.NoError(t, err)
+	assert.Equal(t, "first", record.Leader)
+
+	require.NoError(t, first.Resign(ctx))
+	assert.False(t, first.IsLeader())
+
+	require.NoError(t, second.Campaign(ctx, "second"))
+	record, err = first.Leader()
+	require.NoError(t, err)
+	assert.Equal(t, "second", record.Leader)
+	assert.Equal(t, uint64(2), record.Term)
+
+	assert.True(t, errors.Is(first.Resign(ctx), errors.NotLeader))
+	require.NoError(t, second.Resign(ctx))
+}
+
+func TestElection_Observe(t *testing.T) {
+	p := newProvider(t)
+	e, err := New(p, "observed", Config{TTL: 300 * time.Millisecond, PollInterval: 10 * time.Millisecond})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	changes := e.Observe(ctx)
+	assert.Equal(t, "", (<-changes).Leader)
+
+	require.NoError(t, e.Campaign(ctx, "node"))
+	assert.Equal(t, "node", (<-changes).Leader)
+
+	require.NoError(t, e.Resign(ctx))
+	assert.Equal(t, "", (<-changes).Leader)
+}
+
+func TestElection_ConcurrentCampaignAndResign(t *testing.T) {
+	p := newProvider(t)
+	cfg := Config{TTL: 300 * time.Millisecond, PollInterval: 10 * time.Millisecond}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	leader, err := New(p, "busy", cfg)
+	require.NoError(t, err)
+	require.NoError(t, leader.Campaign(ctx, "leader"))
+
+	waiting, err := New(p, "busy", cfg)
+	require.NoError(t, err)
+	campaignCtx, stopCampaign := context.WithCancel(ctx)
+	campaigned := make(chan error)
+	go func() { campaigned <- waiting.Campaign(campaignCtx, "waiting") }()
+
+	assert.Eventually(t, func() bool {
+		waiting.mu.Lock()
+		defer waiting.mu.Unlock()
+		return waiting.campaigning
+	}, time.Second, time.Millisecond)
+	assert.False(t, waiting.IsLeader())
+	assert.Error(t, waiting.Campaign(ctx, "other"))
+	stopCampaign()
+	assert.ErrorIs(t, <-campaigned, context.Canceled)
+
+	expired, expire := context.WithCancel(ctx)
+	expire()
+	_ = leader.Resign(expired)
+	require.NoError(t, leader.Resign(ctx))
+	assert.False(t, leader.IsLeader())
+	_, err = leader.Leader()
+	assert.True(t, errors.Is(err, errors.NotFound), "the retry releases the lease")
+	assert.True(t, errors.Is(leader.Resign(ctx), errors.NotLeader))
+}
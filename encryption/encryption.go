@@ -0,0 +1,287 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/codec"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"github.com/rlshukhov/storage/internal/transform"
+	"github.com/rlshukhov/storage/kv"
+	"reflect"
+	"sync/atomic"
+)
+
+// Key is an AES key. Secret must be 16, 24 or 32 bytes long.
+type Key struct {
+	ID     uint32
+	Secret []byte
+}
+
+// KeyFromSecret returns the key whose secret is the base64 value of ref, a
+// secret reference such as file:/run/secrets/key or ${env:STORAGE_KEY}. See
+// kv.ResolveSecrets.
+func KeyFromSecret(id uint32, ref string) (Key, error) {
+	resolved, err := kv.ResolveSecrets(context.Background(), ref)
+	if err != nil {
+		return Key{}, fmt.Errorf("encryption key %d: %w", id, err)
+	}
+	secret, err := base64.StdEncoding.DecodeString(resolved)
+	if err != nil {
+		return Key{}, fmt.Errorf("encryption key %d: secret is not base64: %w", id, err)
+	}
+
+	return Key{ID: id, Secret: secret}, nil
+}
+
+type Progress struct {
+	Total   int
+	Rotated int
+}
+
+const (
+	// formatVersion values are bound to their key. Values of
+	// legacyFormatVersion are not and open under any key until they are
+	// rotated.
+	formatVersion       byte = 2
+	legacyFormatVersion byte = 1
+	headerSize               = 1 + 4
+)
+
+type provider[K ~string | ~uint64, V any] struct {
+	*transform.Provider[K, V, []byte]
+	codec  codec.Codec
+	keys   map[uint32]cipher.AEAD
+	active uint32
+}
+
+// New encrypts values with AES-GCM before storing them in inner. The last key
+// encrypts new values, the others are only used to decrypt existing ones.
+func New[K ~string | ~uint64, V any](inner storage.KeyValueProvider[K, []byte], keys ...Key) (*provider[K, V], error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one encryption key is required")
+	}
+
+	ciphers, err := newCiphers(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &provider[K, V]{
+		codec:  codec.For[V](),
+		keys:   ciphers,
+		active: keys[len(keys)-1].ID,
+	}
+	p.Provider = &transform.Provider[K, V, []byte]{
+		Inner:  inner,
+		Encode: p.encrypt,
+		Decode: p.decrypt,
+	}
+
+	return p, nil
+}
+
+type Rotation struct {
+	done    chan struct{}
+	err     error
+	total   atomic.Int64
+	rotated atomic.Int64
+}
+
+func (r *Rotation) Progress() Progress {
+	return Progress{Total: int(r.total.Load()), Rotated: int(r.rotated.Load())}
+}
+
+func (r *Rotation) Done() <-chan struct{} {
+	return r.done
+}
+
+func (r *Rotation) Wait() error {
+	<-r.done
+	return r.err
+}
+
+// RotateKeys re-encrypts every value that is not encrypted with the newest key
+// in the background. onProgress, if not nil, is called after each value.
+func (p *provider[K, V]) RotateKeys(ctx context.Context, onProgress func(Progress)) *Rotation {
+	r := &Rotation{done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		r.err = p.rotate(ctx, r, onProgress)
+	}()
+
+	return r
+}
+
+func (p *provider[K, V]) rotate(ctx context.Context, r *Rotation, onProgress func(Progress)) error {
+	var outdated []K
+	err := p.Inner.ForEach(func(key K, stored []byte) bool {
+		if !p.current(stored) {
+			outdated = append(outdated, key)
+		}
+		return ctx.Err() == nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.total.Store(int64(len(outdated)))
+
+	for _, key := range outdated {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := p.Inner.Update(key, func(stored []byte, exists bool) ([]byte, error) {
+			if !exists {
+				return stored, storageErrors.NotFound
+			}
+			if p.current(stored) {
+				return stored, nil
+			}
+
+			value, err := p.decrypt(key, stored)
+			if err != nil {
+				return stored, err
+			}
+
+			return p.encrypt(key, value)
+		})
+		if err != nil && !storageErrors.Is(err, storageErrors.NotFound) {
+			return fmt.Errorf("key %v: %w", key, err)
+		}
+
+		r.rotated.Add(1)
+		if onProgress != nil {
+			onProgress(r.Progress())
+		}
+	}
+
+	return nil
+}
+
+// current reports whether stored is encrypted with the newest key and bound
+// to its key.
+func (p *provider[K, V]) current(stored []byte) bool {
+	id, err := keyID(stored)
+	return err == nil && id == p.active && stored[0] == formatVersion
+}
+
+// encrypt binds the ciphertext to key, so a value copied to another key does
+// not decrypt.
+func (p *provider[K, V]) encrypt(key K, value V) ([]byte, error) {
+	plain, err := p.codec.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return seal(p.keys, p.active, plain, keyToBytes(key))
+}
+
+func (p *provider[K, V]) decrypt(key K, stored []byte) (V, error) {
+	var value V
+	data := keyToBytes(key)
+	if len(stored) > 0 && stored[0] == legacyFormatVersion {
+		data = nil
+	}
+	plain, err := open(p.keys, stored, data)
+	if err != nil {
+		return value, err
+	}
+
+	if err := p.codec.Unmarshal(plain, &value); err != nil {
+		return value, storageErrors.NewCorrupted(err)
+	}
+
+	return value, nil
+}
+
+func keyToBytes[K ~string | ~uint64](key K) []byte {
+	v := reflect.ValueOf(key)
+	if v.Kind() == reflect.Uint64 {
+		return binary.BigEndian.AppendUint64(nil, v.Uint())
+	}
+
+	return []byte(v.String())
+}
+
+func newCiphers(keys []Key) (map[uint32]cipher.AEAD, error) {
+	ciphers := make(map[uint32]cipher.AEAD, len(keys))
+	for _, key := range keys {
+		if _, ok := ciphers[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate encryption key id %d", key.ID)
+		}
+
+		block, err := aes.NewCipher(key.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %d: %w", key.ID, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key %d: %w", key.ID, err)
+		}
+		ciphers[key.ID] = aead
+	}
+
+	return ciphers, nil
+}
+
+// seal encrypts plain with the key id. The header and data are
+// authenticated, so a ciphertext only opens with the same data.
+func seal(ciphers map[uint32]cipher.AEAD, id uint32, plain []byte, data []byte) ([]byte, error) {
+	aead := ciphers[id]
+	out := make([]byte, headerSize+aead.NonceSize(), headerSize+aead.NonceSize()+len(plain)+aead.Overhead())
+	out[0] = formatVersion
+	binary.BigEndian.PutUint32(out[1:headerSize], id)
+	if _, err := rand.Read(out[headerSize:]); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(out, out[headerSize:], plain, append(out[:headerSize:headerSize], data...)), nil
+}
+
+func open(ciphers map[uint32]cipher.AEAD, stored []byte, data []byte) ([]byte, error) {
+	id, err := keyID(stored)
+	if err != nil {
+		return nil, err
+	}
+	aead, ok := ciphers[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown encryption key id %d", id)
+	}
+	if len(stored) < headerSize+aead.NonceSize() {
+		return nil, storageErrors.NewCorrupted(errors.New("ciphertext is truncated"))
+	}
+
+	nonce := stored[headerSize : headerSize+aead.NonceSize()]
+	plain, err := aead.Open(nil, nonce, stored[headerSize+aead.NonceSize():], append(stored[:headerSize:headerSize], data...))
+	if err != nil {
+		return nil, storageErrors.NewCorrupted(err)
+	}
+
+	return plain, nil
+}
+
+func keyID(stored []byte) (uint32, error) {
+	if len(stored) < headerSize || stored[0] != formatVersion && stored[0] != legacyFormatVersion {
+		return 0, storageErrors.NewCorrupted(errors.New("ciphertext header is missing"))
+	}
+
+	return binary.BigEndian.Uint32(stored[1:headerSize]), nil
+}
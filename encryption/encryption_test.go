@@ -0,0 +1,215 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/badger"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type user struct {
+	Name string
+	Age  int
+}
+
+func TestRotateKeys(t *testing.T) {
+	inner, err := storage.GetKeyValueProviderFromConfig[string, []byte](storage.KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{
+			InMemory: true,
+		}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, inner.Setup())
+	defer func() {
+		require.NoError(t, inner.Shutdown())
+	}()
+
+	oldKey := Key{ID: 1, Secret: bytes.Repeat([]byte{1}, 32)}
+	newKey := Key{ID: 2, Secret: bytes.Repeat([]byte{2}, 16)}
+
+	v1, err := New[string, user](inner, oldKey)
+	require.NoError(t, err)
+	require.NoError(t, v1.Store("a", user{Name: "Ann", Age: 30}))
+	require.NoError(t, v1.Store("b", user{Name: "Bob", Age: 40}))
+
+	raw, err := inner.Get("a")
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "Ann")
+
+	v2, err := New[string, user](inner, oldKey, newKey)
+	require.NoError(t, err)
+	require.NoError(t, v2.Store("c", user{Name: "Cid", Age: 50}))
+
+	val, err := v2.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, user{Name: "Ann", Age: 30}, val)
+
+	var reported []Progress
+	rotation := v2.RotateKeys(context.Background(), func(progress Progress) {
+		reported = append(reported, progress)
+	})
+	require.NoError(t, rotation.Wait())
+	assert.Equal(t, Progress{Total: 2, Rotated: 2}, rotation.Progress())
+	assert.Equal(t, []Progress{{Total: 2, Rotated: 1}, {Total: 2, Rotated: 2}}, reported)
+
+	v3, err := New[string, user](inner, newKey)
+	require.NoError(t, err)
+	values, err := v3.GetMultiple([]string{"a", "b", "c"})
+	require.NoError(t, err)
+	assert.Equal(t, []user{{Name: "Ann", Age: 30}, {Name: "Bob", Age: 40}, {Name: "Cid", Age: 50}}, values)
+	require.NoError(t, v3.Verify())
+
+	_, err = v1.Get("a")
+	assert.ErrorContains(t, err, "unknown encryption key id 2")
+
+	_, err = New[string, user](inner, oldKey, Key{ID: 1, Secret: newKey.Secret})
+	assert.ErrorContains(t, err, "duplicate encryption key id 1")
+	_, err = New[string, user](inner, Key{ID: 3, Secret: []byte("short")})
+	assert.Error(t, err)
+}
+
+func TestBoundToKey(t *testing.T) {
+	inner, err := storage.GetKeyValueProviderFromConfig[string, []byte](storage.KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{
+			InMemory: true,
+		}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, inner.Setup())
+	defer func() {
+		require.NoError(t, inner.Shutdown())
+	}()
+
+	key := Key{ID: 1, Secret: bytes.Repeat([]byte{1}, 32)}
+	p, err := New[string, user](inner, key)
+	require.NoError(t, err)
+	require.NoError(t, p.Store("admin", user{Name: "Ann", Age: 30}))
+	require.NoError(t, p.Store("guest", user{Name: "Bob", Age: 40}))
+
+	admin, err := inner.Get("admin")
+	require.NoError(t, err)
+	require.NoError(t, inner.Store("guest", admin))
+	_, err = p.Get("guest")
+	assert.True(t, storageErrors.Is(err, storageErrors.Corrupted))
+	assert.True(t, storageErrors.Is(p.Verify(), storageErrors.Corrupted))
+
+	require.NoError(t, p.Store("guest", user{Name: "Bob", Age: 40}))
+	require.NoError(t, p.StoreReference("current", "guest"))
+	val, err := p.GetByReference("current")
+	require.NoError(t, err)
+	assert.Equal(t, user{Name: "Bob", Age: 40}, val)
+	values, err := p.GetAllByReference("current")
+	require.NoError(t, err)
+	assert.Equal(t, []user{{Name: "Bob", Age: 40}}, values)
+
+	// Values written before ciphertexts were bound to their key still open
+	// and are bound when they are rotated.
+	plain, err := p.codec.Marshal(user{Name: "Cid", Age: 50})
+	require.NoError(t, err)
+	aead := p.keys[key.ID]
+	legacy := make([]byte, headerSize+aead.NonceSize())
+	legacy[0] = legacyFormatVersion
+	binary.BigEndian.PutUint32(legacy[1:headerSize], key.ID)
+	legacy = aead.Seal(legacy, legacy[headerSize:], plain, legacy[:headerSize])
+	require.NoError(t, inner.Store("legacy", legacy))
+
+	val, err = p.Get("legacy")
+	require.NoError(t, err)
+	assert.Equal(t, user{Name: "Cid", Age: 50}, val)
+	rotation := p.RotateKeys(context.Background(), nil)
+	require.NoError(t, rotation.Wait())
+	assert.Equal(t, Progress{Total: 1, Rotated: 1}, rotation.Progress())
+	stored, err := inner.Get("legacy")
+	require.NoError(t, err)
+	assert.Equal(t, formatVersion, stored[0])
+	val, err = p.Get("legacy")
+	require.NoError(t, err)
+	assert.Equal(t, user{Name: "Cid", Age: 50}, val)
+}
+
+func TestKeyFromSecret(t *testing.T) {
+	t.Setenv("STORAGE_TEST_KEY", "AQEBAQEBAQEBAQEBAQEBAQ==")
+
+	key, err := KeyFromSecret(3, "${env:STORAGE_TEST_KEY}")
+	require.NoError(t, err)
+	assert.Equal(t, Key{ID: 3, Secret: bytes.Repeat([]byte{1}, 16)}, key)
+
+	_, err = KeyFromSecret(3, "not base64")
+	assert.ErrorContains(t, err, "encryption key 3")
+}
+
+type address struct {
+	City   string
+	Street string `storage:"encrypt"`
+}
+
+type patient struct {
+	Name    string
+	SSN     string `storage:"encrypt"`
+	Notes   []byte `storage:"encrypt"`
+	Address *address
+}
+
+func TestNewFields(t *testing.T) {
+	inner, err := storage.GetKeyValueProviderFromConfig[string, patient](storage.KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{
+			InMemory: true,
+		}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, inner.Setup())
+	defer func() {
+		require.NoError(t, inner.Shutdown())
+	}()
+
+	key := Key{ID: 1, Secret: bytes.Repeat([]byte{1}, 32)}
+	p, err := NewFields[string, patient](inner, key)
+	require.NoError(t, err)
+
+	ann := patient{Name: "Ann", SSN: "123-45-6789", Notes: []byte("allergic"), Address: &address{City: "Oslo", Street: "Main St 1"}}
+	require.NoError(t, p.Store("ann", ann))
+	require.NoError(t, p.Store("bob", patient{Name: "Bob"}))
+	assert.Equal(t, "Main St 1", ann.Address.Street)
+
+	raw, err := inner.Get("ann")
+	require.NoError(t, err)
+	assert.Equal(t, "Ann", raw.Name)
+	assert.Equal(t, "Oslo", raw.Address.City)
+	assert.NotContains(t, raw.SSN, "123")
+	assert.NotContains(t, string(raw.Notes), "allergic")
+	assert.NotContains(t, raw.Address.Street, "Main")
+
+	val, err := p.Get("ann")
+	require.NoError(t, err)
+	assert.Equal(t, ann, val)
+	val, err = p.Get("bob")
+	require.NoError(t, err)
+	assert.Equal(t, patient{Name: "Bob"}, val)
+
+	// A ciphertext only opens in the field it was written to.
+	raw.SSN = raw.Address.Street
+	require.NoError(t, inner.Store("ann", raw))
+	_, err = p.Get("ann")
+	assert.True(t, storageErrors.Is(err, storageErrors.Corrupted))
+
+	_, err = NewFields[string, user](nil, key)
+	assert.ErrorContains(t, err, "no fields tagged")
+	_, err = NewFields[string, struct {
+		Age int `storage:"encrypt"`
+	}](nil, key)
+	assert.ErrorContains(t, err, "only strings and byte slices")
+}
@@ -0,0 +1,242 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package encryption
+
+import (
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/rlshukhov/storage"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"github.com/rlshukhov/storage/internal/transform"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+const fieldTag = "encrypt"
+
+type fieldProvider[K ~string | ~uint64, V any] struct {
+	*transform.Provider[K, V, V]
+	keys   map[uint32]cipher.AEAD
+	active uint32
+	// encrypted caches which types hold an encrypted field.
+	encrypted map[reflect.Type]bool
+}
+
+// NewFields encrypts the struct fields tagged `storage:"encrypt"` with
+// AES-GCM before storing values in inner and leaves the other fields as they
+// are, so they can still be read, searched and indexed through inner.
+// Tagged fields must be strings or byte slices; strings hold the base64
+// ciphertext. Fields of nested structs and struct pointers are encrypted
+// too. Empty fields are stored empty. The last key encrypts new values, the
+// others are only used to decrypt existing ones.
+func NewFields[K ~string | ~uint64, V any](inner storage.KeyValueProvider[K, V], keys ...Key) (*fieldProvider[K, V], error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one encryption key is required")
+	}
+
+	ciphers, err := newCiphers(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &fieldProvider[K, V]{
+		keys:      ciphers,
+		active:    keys[len(keys)-1].ID,
+		encrypted: map[reflect.Type]bool{},
+	}
+	found, err := p.inspect(reflect.TypeFor[V](), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("%v has no fields tagged `storage:\"%s\"`", reflect.TypeFor[V](), fieldTag)
+	}
+	p.Provider = &transform.Provider[K, V, V]{
+		Inner:  inner,
+		Encode: p.encrypt,
+		Decode: p.decrypt,
+	}
+
+	return p, nil
+}
+
+// inspect reports whether t holds an encrypted field and checks that every
+// tagged field can hold ciphertext.
+func (p *fieldProvider[K, V]) inspect(t reflect.Type, path string, visiting []reflect.Type) (bool, error) {
+	if found, ok := p.encrypted[t]; ok {
+		return found, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return p.inspect(t.Elem(), path, visiting)
+	case reflect.Struct:
+	default:
+		return false, nil
+	}
+	if slices.Contains(visiting, t) {
+		return false, nil
+	}
+	visiting = append(visiting, t)
+
+	found := false
+	var errs []error
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := fieldPath(path, field.Name)
+
+		if tagged(field) {
+			if !canHoldCiphertext(field.Type) {
+				errs = append(errs, fmt.Errorf("field %s is %v: only strings and byte slices can be encrypted", name, field.Type))
+			}
+			found = true
+			continue
+		}
+
+		nested, err := p.inspect(field.Type, name, visiting)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		found = found || nested
+	}
+	if err := errors.Join(errs...); err != nil {
+		return false, err
+	}
+
+	p.encrypted[t] = found
+	return found, nil
+}
+
+func (p *fieldProvider[K, V]) encrypt(_ K, value V) (V, error) {
+	err := p.walk(reflect.ValueOf(&value).Elem(), "", func(field reflect.Value, path string) error {
+		plain := fieldBytes(field)
+		if len(plain) == 0 {
+			return nil
+		}
+
+		sealed, err := seal(p.keys, p.active, plain, []byte(path))
+		if err != nil {
+			return err
+		}
+		if field.Kind() == reflect.String {
+			field.SetString(base64.StdEncoding.EncodeToString(sealed))
+		} else {
+			field.SetBytes(sealed)
+		}
+		return nil
+	})
+
+	return value, err
+}
+
+func (p *fieldProvider[K, V]) decrypt(_ K, stored V) (V, error) {
+	err := p.walk(reflect.ValueOf(&stored).Elem(), "", func(field reflect.Value, path string) error {
+		sealed := fieldBytes(field)
+		if len(sealed) == 0 {
+			return nil
+		}
+		if field.Kind() == reflect.String {
+			var err error
+			if sealed, err = base64.StdEncoding.DecodeString(field.String()); err != nil {
+				return storageErrors.NewCorrupted(fmt.Errorf("field %s is not encrypted", path))
+			}
+		}
+
+		plain, err := open(p.keys, sealed, []byte(path))
+		if err != nil {
+			return fmt.Errorf("field %s: %w", path, err)
+		}
+		if field.Kind() == reflect.String {
+			field.SetString(string(plain))
+		} else {
+			field.SetBytes(plain)
+		}
+		return nil
+	})
+
+	return stored, err
+}
+
+// walk calls fn for every encrypted field in v. Pointers on the way are
+// replaced with copies, so the caller's value is never modified.
+func (p *fieldProvider[K, V]) walk(v reflect.Value, path string, fn func(field reflect.Value, path string) error) error {
+	if !p.encrypted[indirect(v.Type())] {
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		copied := reflect.New(v.Type().Elem())
+		copied.Elem().Set(v.Elem())
+		v.Set(copied)
+		return p.walk(copied.Elem(), path, fn)
+
+	case reflect.Struct:
+		for i := range v.NumField() {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name := fieldPath(path, field.Name)
+
+			var err error
+			if tagged(field) {
+				err = fn(v.Field(i), name)
+			} else {
+				err = p.walk(v.Field(i), name, fn)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func tagged(field reflect.StructField) bool {
+	return slices.Contains(strings.Split(field.Tag.Get("storage"), ","), fieldTag)
+}
+
+func canHoldCiphertext(t reflect.Type) bool {
+	return t.Kind() == reflect.String || (t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8)
+}
+
+func fieldBytes(field reflect.Value) []byte {
+	if field.Kind() == reflect.String {
+		return []byte(field.String())
+	}
+
+	return field.Bytes()
+}
+
+func fieldPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+
+	return path + "." + name
+}
+
+func indirect(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	return t
+}
@@ -0,0 +1,169 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"cmp"
+	baseErrors "errors"
+	"fmt"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+)
+
+// IndexFunc returns the subjects a value belongs to in an index, e.g. the IDs
+// of its owners.
+type IndexFunc[K ~string | ~uint64, V any] func(key K, value V) []string
+
+// Indexes finds the entries of a provider that belong to a subject, to
+// answer and erase them for data subject requests.
+type Indexes[K ~string | ~uint64, V any] struct {
+	provider KeyValueProvider[K, V]
+
+	mu    sync.RWMutex
+	funcs map[string]IndexFunc[K, V]
+}
+
+// ErasureReport lists what EraseByIndex removed.
+type ErasureReport[K ~string | ~uint64] struct {
+	Index   string `json:"index"`
+	Subject string `json:"subject"`
+	Keys    []K    `json:"keys"`
+	// References pointed at a removed key. They were removed, or only lost
+	// the removed keys from their targets.
+	References []K       `json:"references"`
+	ErasedAt   time.Time `json:"erased_at"`
+}
+
+func NewIndexes[K ~string | ~uint64, V any](provider KeyValueProvider[K, V]) *Indexes[K, V] {
+	return &Indexes[K, V]{provider: provider, funcs: map[string]IndexFunc[K, V]{}}
+}
+
+// Register adds an index that is evaluated by reading every value.
+func (i *Indexes[K, V]) Register(name string, fn IndexFunc[K, V]) error {
+	if name == "" || fn == nil {
+		return baseErrors.New("index name and function are required")
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if _, ok := i.funcs[name]; ok {
+		return storageErrors.NewAlreadyExists(fmt.Errorf("index %q is already registered", name))
+	}
+	i.funcs[name] = fn
+
+	return nil
+}
+
+// Lookup returns the sorted keys that belong to subject in the index name:
+// the keys the registered function of name returns subject for and, with
+// string keys, the targets of the reference "name:subject", as in
+// StoreWithReferences(db, "order:1", order, "owner:42").
+func (i *Indexes[K, V]) Lookup(name string, subject string) ([]K, error) {
+	i.mu.RLock()
+	fn, registered := i.funcs[name]
+	i.mu.RUnlock()
+
+	reference, byReference := subjectReference[K](name, subject)
+	if !registered && !byReference {
+		return nil, storageErrors.NewNotFound(fmt.Errorf("index %q is not registered", name))
+	}
+
+	found := map[K]bool{}
+	if registered {
+		err := i.provider.ForEach(func(key K, value V) bool {
+			if slices.Contains(fn(key, value), subject) {
+				found[key] = true
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if byReference {
+		err := i.provider.ForEachReference(func(r K, key K) bool {
+			if r == reference {
+				found[key] = true
+			}
+			return true
+		})
+		if err != nil && !storageErrors.Is(err, storageErrors.Unsupported) {
+			return nil, err
+		}
+	}
+
+	keys := make([]K, 0, len(found))
+	for key := range found {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, cmp.Compare[K])
+
+	return keys, nil
+}
+
+// EraseByIndex removes the entries Lookup finds for subject, together with
+// every reference that points at them, with RemoveWithReferences. Badger
+// removes them in one write. References added while the erasure runs may
+// be left behind, so stop writes for subject first.
+func (i *Indexes[K, V]) EraseByIndex(name string, subject string) (ErasureReport[K], error) {
+	report := ErasureReport[K]{Index: name, Subject: subject, Keys: []K{}, References: []K{}}
+
+	keys, err := i.Lookup(name, subject)
+	if err != nil {
+		return report, err
+	}
+
+	erased := map[K]bool{}
+	for _, key := range keys {
+		erased[key] = true
+	}
+	pointing := map[K]bool{}
+	err = i.provider.ForEachReference(func(reference K, key K) bool {
+		if erased[key] {
+			pointing[reference] = true
+		}
+		return true
+	})
+	if err != nil && !storageErrors.Is(err, storageErrors.Unsupported) {
+		return report, err
+	}
+	references := make([]K, 0, len(pointing))
+	for reference := range pointing {
+		references = append(references, reference)
+	}
+	slices.SortFunc(references, cmp.Compare[K])
+
+	if len(keys) == 0 {
+		report.ErasedAt = time.Now()
+		return report, nil
+	}
+	if err := RemoveWithReferences(i.provider, keys, references); err != nil {
+		return report, err
+	}
+
+	report.Keys = keys
+	report.References = references
+	report.ErasedAt = time.Now()
+
+	return report, nil
+}
+
+func subjectReference[K ~string | ~uint64](name string, subject string) (K, bool) {
+	var reference K
+	v := reflect.ValueOf(&reference).Elem()
+	if v.Kind() != reflect.String {
+		return reference, false
+	}
+
+	v.SetString(name + ":" + subject)
+	return reference, true
+}
@@ -11,7 +11,26 @@ package errors
 import "errors"
 
 var (
-	NotFound error = errors.New("not found")
+	NotFound      error = errors.New("not found")
+	AlreadyExists error = errors.New("already exists")
+	NotLeader     error = errors.New("not leader")
+	Corrupted     error = errors.New("corrupted")
+	Closed        error = errors.New("closed")
+	Unavailable   error = errors.New("unavailable")
+	ReadOnly      error = errors.New("read-only")
+	Timeout       error = errors.New("timeout")
+	RateLimited   error = errors.New("rate limited")
+	Conflict      error = errors.New("conflict")
+	Unsupported   error = errors.New("unsupported")
+
+	QuotaExceeded error = errors.New("quota exceeded")
+	Immutable     error = errors.New("immutable")
+
+	SchemaMismatch error = errors.New("schema mismatch")
+	Tampered       error = errors.New("tampered")
+
+	ReferenceCycle   error = errors.New("reference cycle")
+	ReferenceTooDeep error = errors.New("reference chain too deep")
 )
 
 func Is(err, target error) bool {
@@ -21,3 +40,63 @@ func Is(err, target error) bool {
 func NewNotFound(parentError error) error {
 	return errors.Join(NotFound, parentError)
 }
+
+func NewAlreadyExists(parentError error) error {
+	return errors.Join(AlreadyExists, parentError)
+}
+
+func NewCorrupted(parentError error) error {
+	return errors.Join(Corrupted, parentError)
+}
+
+func NewClosed(parentError error) error {
+	return errors.Join(Closed, parentError)
+}
+
+func NewUnavailable(parentError error) error {
+	return errors.Join(Unavailable, parentError)
+}
+
+func NewReadOnly(parentError error) error {
+	return errors.Join(ReadOnly, parentError)
+}
+
+func NewTimeout(parentError error) error {
+	return errors.Join(Timeout, parentError)
+}
+
+func NewRateLimited(parentError error) error {
+	return errors.Join(RateLimited, parentError)
+}
+
+func NewConflict(parentError error) error {
+	return errors.Join(Conflict, parentError)
+}
+
+func NewUnsupported(parentError error) error {
+	return errors.Join(Unsupported, parentError)
+}
+
+func NewQuotaExceeded(parentError error) error {
+	return errors.Join(QuotaExceeded, parentError)
+}
+
+func NewImmutable(parentError error) error {
+	return errors.Join(Immutable, parentError)
+}
+
+func NewSchemaMismatch(parentError error) error {
+	return errors.Join(SchemaMismatch, parentError)
+}
+
+func NewTampered(parentError error) error {
+	return errors.Join(Tampered, parentError)
+}
+
+func NewReferenceCycle(parentError error) error {
+	return errors.Join(ReferenceCycle, parentError)
+}
+
+func NewReferenceTooDeep(parentError error) error {
+	return errors.Join(ReferenceTooDeep, parentError)
+}
@@ -0,0 +1,246 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package eventstore
+
+import (
+	"context"
+	baseErrors "errors"
+	"fmt"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/errors"
+	"sync"
+	"time"
+)
+
+type Event[E any] struct {
+	Aggregate string    `yaml:"aggregate" json:"aggregate"`
+	Version   uint64    `yaml:"version" json:"version"`
+	Data      E         `yaml:"data" json:"data"`
+	Time      time.Time `yaml:"time" json:"time"`
+}
+
+type Snapshot[S any] struct {
+	Aggregate string    `yaml:"aggregate" json:"aggregate"`
+	Version   uint64    `yaml:"version" json:"version"`
+	State     S         `yaml:"state" json:"state"`
+	Time      time.Time `yaml:"time" json:"time"`
+}
+
+// ConflictError is returned by Append when the stream is not at the expected
+// version. It matches errors.Conflict.
+type ConflictError struct {
+	Aggregate string
+	Expected  uint64
+	Actual    uint64
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("aggregate %q is at version %d, expected %d", e.Aggregate, e.Actual, e.Expected)
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == errors.Conflict
+}
+
+// Store keeps one append-only event stream per aggregate. Event versions
+// start at 1; the event with version n is stored under the composite key
+// ("event", aggregate, n), so streams list in version order.
+type Store[E any, S any] struct {
+	events    storage.KeyValueProvider[string, Event[E]]
+	snapshots storage.KeyValueProvider[string, Snapshot[S]]
+	watcher   storage.Watcher[string, Event[E]]
+
+	mu          sync.RWMutex
+	subscribers map[int]func(Event[E])
+	nextID      int
+}
+
+// New creates a store. snapshots may be nil if snapshots are not used.
+func New[E any, S any](events storage.KeyValueProvider[string, Event[E]], snapshots storage.KeyValueProvider[string, Snapshot[S]]) (*Store[E, S], error) {
+	if events == nil {
+		return nil, baseErrors.New("events provider is nil")
+	}
+
+	s := &Store[E, S]{
+		events:      events,
+		snapshots:   snapshots,
+		subscribers: map[int]func(Event[E]){},
+	}
+	if watcher, ok := events.(storage.Watcher[string, Event[E]]); ok {
+		s.watcher = watcher
+	}
+
+	return s, nil
+}
+
+// Append adds events to the stream of aggregate, which must be at version
+// expected (0 for a new stream), and returns the new version. Each event is
+// stored with StoreIfAbsent, so concurrent appends at the same version
+// conflict instead of overwriting each other.
+func (s *Store[E, S]) Append(aggregate string, expected uint64, events ...E) (uint64, error) {
+	if aggregate == "" {
+		return 0, baseErrors.New("aggregate is empty")
+	}
+
+	if expected > 0 {
+		if _, err := s.events.Get(eventKey(aggregate, expected)); errors.Is(err, errors.NotFound) {
+			actual, verr := s.Version(aggregate)
+			if verr != nil {
+				return expected, verr
+			}
+			return expected, &ConflictError{Aggregate: aggregate, Expected: expected, Actual: actual}
+		} else if err != nil {
+			return expected, err
+		}
+	}
+
+	now := time.Now()
+	version := expected
+	var appended []Event[E]
+	for _, data := range events {
+		event := Event[E]{Aggregate: aggregate, Version: version + 1, Data: data, Time: now}
+		err := storage.StoreIfAbsent(s.events, eventKey(aggregate, event.Version), event)
+		if errors.Is(err, errors.AlreadyExists) {
+			actual, verr := s.Version(aggregate)
+			if verr != nil {
+				return version, verr
+			}
+			return version, &ConflictError{Aggregate: aggregate, Expected: version, Actual: actual}
+		} else if err != nil {
+			return version, err
+		}
+
+		version = event.Version
+		appended = append(appended, event)
+	}
+	if version == expected {
+		if actual, err := s.Version(aggregate); err != nil {
+			return version, err
+		} else if actual != expected {
+			return version, &ConflictError{Aggregate: aggregate, Expected: expected, Actual: actual}
+		}
+	}
+
+	s.notify(appended)
+	return version, nil
+}
+
+// Load returns the events of aggregate after version after, in order.
+func (s *Store[E, S]) Load(aggregate string, after uint64) ([]Event[E], error) {
+	var events []Event[E]
+	for version := after + 1; ; version++ {
+		event, err := s.events.Get(eventKey(aggregate, version))
+		if errors.Is(err, errors.NotFound) {
+			return events, nil
+		} else if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+}
+
+// Version returns the version of the last event of aggregate, 0 if the
+// stream is empty.
+func (s *Store[E, S]) Version(aggregate string) (uint64, error) {
+	var version uint64
+	if s.snapshots != nil {
+		snapshot, err := s.snapshots.Get(snapshotKey(aggregate))
+		if err == nil {
+			version = snapshot.Version
+		} else if !errors.Is(err, errors.NotFound) {
+			return 0, err
+		}
+	}
+
+	for {
+		_, err := s.events.Get(eventKey(aggregate, version+1))
+		if errors.Is(err, errors.NotFound) {
+			return version, nil
+		} else if err != nil {
+			return version, err
+		}
+		version++
+	}
+}
+
+// SaveSnapshot stores the state of aggregate as of version. Loading can then
+// start from the snapshot instead of the first event.
+func (s *Store[E, S]) SaveSnapshot(aggregate string, version uint64, state S) error {
+	if s.snapshots == nil {
+		return errors.NewUnsupported(baseErrors.New("no snapshot provider configured"))
+	}
+
+	return s.snapshots.Store(snapshotKey(aggregate), Snapshot[S]{Aggregate: aggregate, Version: version, State: state, Time: time.Now()})
+}
+
+// LoadWithSnapshot returns the latest snapshot of aggregate, if any, and the
+// events after it. found is false if there is no snapshot.
+func (s *Store[E, S]) LoadWithSnapshot(aggregate string) (snapshot Snapshot[S], found bool, events []Event[E], err error) {
+	if s.snapshots != nil {
+		snapshot, err = s.snapshots.Get(snapshotKey(aggregate))
+		if err == nil {
+			found = true
+		} else if !errors.Is(err, errors.NotFound) {
+			return snapshot, false, nil, err
+		}
+	}
+
+	events, err = s.Load(aggregate, snapshot.Version)
+	return snapshot, found, events, err
+}
+
+// Subscribe calls fn for every event appended from now on until ctx is done.
+// Providers that implement storage.Watcher deliver events appended by every
+// process; otherwise only events appended through this Store are delivered.
+func (s *Store[E, S]) Subscribe(ctx context.Context, fn func(event Event[E])) error {
+	if s.watcher != nil {
+		return s.watcher.Watch(ctx, storage.NewKey("event").Prefix(), func(key string, event Event[E], removed bool) {
+			if !removed {
+				fn(event)
+			}
+		})
+	}
+
+	s.mu.Lock()
+	id := s.nextID
+	s.nextID++
+	s.subscribers[id] = fn
+	s.mu.Unlock()
+
+	<-ctx.Done()
+
+	s.mu.Lock()
+	delete(s.subscribers, id)
+	s.mu.Unlock()
+
+	return ctx.Err()
+}
+
+func (s *Store[E, S]) notify(events []Event[E]) {
+	if s.watcher != nil {
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, event := range events {
+		for _, fn := range s.subscribers {
+			fn(event)
+		}
+	}
+}
+
+func eventKey(aggregate string, version uint64) string {
+	return storage.NewKey("event", aggregate).Uint64(version).Key()
+}
+
+func snapshotKey(aggregate string) string {
+	return storage.NewKey("snapshot", aggregate).Key()
+}
@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package eventstore
+
+import (
+	"context"
+	baseErrors "errors"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/rlshukhov/storage/errors"
+	"github.com/rlshukhov/storage/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type deposited struct {
+	Amount int
+}
+
+func TestStore(t *testing.T) {
+	configs := map[string]storage.KeyValueConfig{
+		"badger": {Badger: nullable.FromValue(badger.Config{InMemory: true})},
+		"file":   {File: nullable.FromValue(file.Config{Path: filepath.Join(t.TempDir(), "events.json")})},
+	}
+
+	for name, cfg := range configs {
+		t.Run(name, func(t *testing.T) {
+			events, err := storage.GetKeyValueProviderFromConfig[string, Event[deposited]](cfg)
+			require.NoError(t, err)
+			require.NoError(t, events.Setup())
+			defer func() {
+				require.NoError(t, events.Shutdown())
+			}()
+			snapshots, err := storage.GetKeyValueProviderFromConfig[string, Snapshot[int]](storage.KeyValueConfig{
+				Badger: nullable.FromValue(badger.Config{InMemory: true}),
+			})
+			require.NoError(t, err)
+			require.NoError(t, snapshots.Setup())
+			defer func() {
+				require.NoError(t, snapshots.Shutdown())
+			}()
+
+			store, err := New(events, snapshots)
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			received := make(chan Event[deposited], 16)
+			go store.Subscribe(ctx, func(event Event[deposited]) {
+				received <- event
+			})
+			time.Sleep(20 * time.Millisecond)
+
+			version, err := store.Append("account-1", 0, deposited{10}, deposited{20})
+			require.NoError(t, err)
+			assert.Equal(t, uint64(2), version)
+
+			_, err = store.Append("account-1", 1, deposited{99})
+			assert.True(t, errors.Is(err, errors.Conflict))
+			var conflict *ConflictError
+			require.True(t, baseErrors.As(err, &conflict))
+			assert.Equal(t, ConflictError{Aggregate: "account-1", Expected: 1, Actual: 2}, *conflict)
+			_, err = store.Append("account-1", 5, deposited{99})
+			assert.True(t, errors.Is(err, errors.Conflict))
+
+			for i := 0; i < 12; i++ {
+				version, err = store.Append("account-1", version, deposited{1})
+				require.NoError(t, err)
+			}
+			_, err = store.Append("account-10", 0, deposited{1000})
+			require.NoError(t, err)
+
+			loaded, err := store.Load("account-1", 0)
+			require.NoError(t, err)
+			require.Len(t, loaded, 14)
+			for i, event := range loaded {
+				assert.Equal(t, uint64(i+1), event.Version)
+			}
+
+			require.NoError(t, store.SaveSnapshot("account-1", 13, 41))
+			snapshot, found, rest, err := store.LoadWithSnapshot("account-1")
+			require.NoError(t, err)
+			assert.True(t, found)
+			assert.Equal(t, 41, snapshot.State)
+			require.Len(t, rest, 1)
+			assert.Equal(t, uint64(14), rest[0].Version)
+			version, err = store.Version("account-1")
+			require.NoError(t, err)
+			assert.Equal(t, uint64(14), version)
+
+			var versions []uint64
+			require.Eventually(t, func() bool {
+				for {
+					select {
+					case event := <-received:
+						if event.Aggregate == "account-1" {
+							versions = append(versions, event.Version)
+						}
+					default:
+						return len(versions) == 14
+					}
+				}
+			}, time.Second, 10*time.Millisecond)
+		})
+	}
+}
@@ -0,0 +1,201 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+)
+
+type ExportFormat string
+
+const (
+	JSONL ExportFormat = "jsonl"
+	CSV   ExportFormat = "csv"
+)
+
+type exportRecord[K ~string | ~uint64, V any] struct {
+	Key   K `json:"key"`
+	Value V `json:"value"`
+}
+
+func Export[K ~string | ~uint64, V any](provider KeyValueProvider[K, V], w io.Writer, format ExportFormat) error {
+	return export(provider, w, format, func(value V) V { return value })
+}
+
+// ExportRedacted is Export with every value passed through Redact first, for
+// exports that leave the system. Redacted exports can not be imported back
+// without losing the redacted fields.
+func ExportRedacted[K ~string | ~uint64, V any](provider KeyValueProvider[K, V], w io.Writer, format ExportFormat) error {
+	return export(provider, w, format, Redact[V])
+}
+
+func export[K ~string | ~uint64, V any](provider KeyValueProvider[K, V], w io.Writer, format ExportFormat, prepare func(value V) V) error {
+	var write func(key K, value V) error
+	var flush func() error
+
+	switch format {
+	case JSONL:
+		bw := bufio.NewWriter(w)
+		encoder := json.NewEncoder(bw)
+		write = func(key K, value V) error {
+			return encoder.Encode(exportRecord[K, V]{Key: key, Value: value})
+		}
+		flush = bw.Flush
+
+	case CSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"key", "value"}); err != nil {
+			return err
+		}
+		write = func(key K, value V) error {
+			field, err := formatCSVValue(value)
+			if err != nil {
+				return err
+			}
+			return cw.Write([]string{formatCSVKey(key), field})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+
+	default:
+		return fmt.Errorf("unsupported export format %q", format)
+	}
+
+	var writeErr error
+	err := provider.ForEach(func(key K, value V) bool {
+		writeErr = write(key, prepare(value))
+		return writeErr == nil
+	})
+	if err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+
+	return flush()
+}
+
+func Import[K ~string | ~uint64, V any](provider KeyValueProvider[K, V], r io.Reader, format ExportFormat) error {
+	switch format {
+	case JSONL:
+		decoder := json.NewDecoder(r)
+		for line := 1; ; line++ {
+			var record exportRecord[K, V]
+			err := decoder.Decode(&record)
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("record %d: %w", line, err)
+			}
+
+			if err := provider.Store(record.Key, record.Value); err != nil {
+				return fmt.Errorf("record %d: %w", line, err)
+			}
+		}
+
+	case CSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = 2
+
+		header, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header[0] != "key" || header[1] != "value" {
+			return errors.New(`csv header must be "key,value"`)
+		}
+
+		for line := 2; ; line++ {
+			fields, err := cr.Read()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			key, err := parseCSVKey[K](fields[0])
+			if err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			value, err := parseCSVValue[V](fields[1])
+			if err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+
+			if err := provider.Store(key, value); err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+
+	default:
+		return fmt.Errorf("unsupported import format %q", format)
+	}
+}
+
+func formatCSVKey[K ~string | ~uint64](key K) string {
+	v := reflect.ValueOf(key)
+	if v.Kind() == reflect.Uint64 {
+		return strconv.FormatUint(v.Uint(), 10)
+	}
+
+	return v.String()
+}
+
+func parseCSVKey[K ~string | ~uint64](field string) (K, error) {
+	var key K
+	v := reflect.ValueOf(&key).Elem()
+	if v.Kind() == reflect.Uint64 {
+		n, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return key, err
+		}
+		v.SetUint(n)
+		return key, nil
+	}
+
+	v.SetString(field)
+	return key, nil
+}
+
+func formatCSVValue[V any](value V) (string, error) {
+	v := reflect.ValueOf(&value).Elem()
+	if v.Kind() == reflect.String {
+		return v.String(), nil
+	}
+
+	raw, err := json.Marshal(value)
+	return string(raw), err
+}
+
+func parseCSVValue[V any](field string) (V, error) {
+	var value V
+	v := reflect.ValueOf(&value).Elem()
+	if v.Kind() == reflect.String {
+		v.SetString(field)
+		return value, nil
+	}
+
+	err := json.Unmarshal([]byte(field), &value)
+	return value, err
+}
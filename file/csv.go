@@ -0,0 +1,186 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/rlshukhov/storage/errors"
+	"io"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	csvDataHeader      = []string{"key", "value"}
+	csvReferenceHeader = []string{"reference", "key"}
+)
+
+func referencesPath(cfg Config) string {
+	if cfg.ReferencesPath != "" {
+		return cfg.ReferencesPath
+	}
+
+	return strings.TrimSuffix(cfg.Path, filepath.Ext(cfg.Path)) + ".references.csv"
+}
+
+func marshalCSV[K comparable, V any](d data[K, V]) ([]byte, []byte, error) {
+	var dataBuf bytes.Buffer
+	w := csv.NewWriter(&dataBuf)
+	if err := w.Write(csvDataHeader); err != nil {
+		return nil, nil, err
+	}
+
+	for _, key := range sortedKeys(d.DataMap) {
+		value, err := json.Marshal(d.DataMap[key])
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := w.Write([]string{keyString(key), string(value)}); err != nil {
+			return nil, nil, err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, nil, err
+	}
+
+	var refBuf bytes.Buffer
+	w = csv.NewWriter(&refBuf)
+	if err := w.Write(csvReferenceHeader); err != nil {
+		return nil, nil, err
+	}
+
+	targets := map[K][]K{}
+	for reference, key := range d.References {
+		targets[reference] = []K{key}
+	}
+	for reference, keys := range d.ReferenceSets {
+		targets[reference] = keys
+	}
+	for _, reference := range sortedKeys(targets) {
+		for _, key := range targets[reference] {
+			if err := w.Write([]string{keyString(reference), keyString(key)}); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	w.Flush()
+
+	return dataBuf.Bytes(), refBuf.Bytes(), w.Error()
+}
+
+func unmarshalCSV[K comparable, V any](raw []byte, d *data[K, V]) error {
+	r := csv.NewReader(bytes.NewReader(raw))
+	r.FieldsPerRecord = 2
+
+	references := false
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case line == 1 && slices.Equal(record, csvDataHeader):
+			continue
+		case !references && slices.Equal(record, csvReferenceHeader):
+			references = true
+			continue
+		}
+
+		key, err := parseKey[K](record[0])
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if references {
+			target, err := parseKey[K](record[1])
+			if err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			addTarget(d, key, target)
+			continue
+		}
+
+		var value V
+		if err := json.Unmarshal([]byte(record[1]), &value); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		if d.DataMap == nil {
+			d.DataMap = map[K]V{}
+		}
+		d.DataMap[key] = value
+	}
+}
+
+func addTarget[K comparable, V any](d *data[K, V], reference K, key K) {
+	if d.References == nil {
+		d.References = map[K]K{}
+	}
+	if d.ReferenceSets == nil {
+		d.ReferenceSets = map[K][]K{}
+	}
+
+	if keys, ok := d.ReferenceSets[reference]; ok {
+		if !slices.Contains(keys, key) {
+			d.ReferenceSets[reference] = append(keys, key)
+		}
+		return
+	}
+
+	existing, ok := d.References[reference]
+	switch {
+	case !ok:
+		d.References[reference] = key
+	case existing != key:
+		delete(d.References, reference)
+		d.ReferenceSets[reference] = []K{existing, key}
+	}
+}
+
+func sortedKeys[K comparable, T any](m map[K]T) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	slices.SortFunc(keys, func(a, b K) int {
+		return strings.Compare(keyString(a), keyString(b))
+	})
+
+	return keys
+}
+
+func parseKey[K comparable](s string) (K, error) {
+	var key K
+	v := reflect.ValueOf(&key).Elem()
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return key, errors.NewCorrupted(fmt.Errorf("key %q is not a uint64", s))
+		}
+		v.SetUint(n)
+	default:
+		return key, fmt.Errorf("unsupported key type %T", key)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,309 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import (
+	"encoding/json"
+	baseErrors "errors"
+	"fmt"
+	"github.com/rlshukhov/storage/errors"
+	"github.com/rlshukhov/storage/internal/timeout"
+	"gopkg.in/yaml.v3"
+	"hash/fnv"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DirectoryConfig turns Path into a directory. Without Shards every key is
+// stored in its own file named after the key; with Shards the keys are
+// hashed into that many files. References are kept in a separate file.
+// A write rewrites only the files of the keys it changed.
+type DirectoryConfig struct {
+	Format Type `yaml:"format,omitempty"`
+	Shards int  `yaml:"shards,omitempty"`
+}
+
+// The names of the shard and reference files start with a character
+// keyFileName always escapes, so they never collide with a key.
+const (
+	shardPrefix    = "@shard-"
+	referencesName = "@references"
+)
+
+func (c DirectoryConfig) Validate() error {
+	var errs []error
+	switch c.Format {
+	case "", jsn, yml:
+	default:
+		errs = append(errs, fmt.Errorf("directory.format %q is not supported: only json and yaml are", c.Format))
+	}
+	if c.Shards < 0 {
+		errs = append(errs, baseErrors.New("directory.shards must not be negative"))
+	}
+
+	return baseErrors.Join(errs...)
+}
+
+func (c DirectoryConfig) format() Type {
+	if c.Format == "" {
+		return jsn
+	}
+
+	return c.Format
+}
+
+func (p *provider[K, V]) isDirectory() bool {
+	return p.cfg.Directory.HasValue()
+}
+
+func (p *provider[K, V]) ext() string {
+	return "." + string(p.fileType)
+}
+
+// fileOf returns the name of the file key is stored in.
+func (p *provider[K, V]) fileOf(key K) string {
+	shards := p.cfg.Directory.GetValue().Shards
+	if shards == 0 {
+		return keyFileName(keyString(key)) + p.ext()
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(keyString(key)))
+	return shardFileName(int(h.Sum32()%uint32(shards))) + p.ext()
+}
+
+func shardFileName(shard int) string {
+	return shardPrefix + fmt.Sprintf("%04d", shard)
+}
+
+// keyFileName escapes everything but letters, digits, '-', '_' and non-leading
+// dots, so any key makes a valid file name that sorts like the key.
+func keyFileName(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '_':
+			b.WriteByte(c)
+		case c == '.' && i > 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+func (p *provider[K, V]) markDirty(entry journalEntry[K, V]) {
+	switch entry.Op {
+	case opStore, opRemove:
+		p.dirty[p.fileOf(entry.Key)] = true
+	default:
+		p.dirty[referencesName+p.ext()] = true
+	}
+}
+
+func (p *provider[K, V]) loadDirectory() error {
+	p.dirty = map[string]bool{}
+
+	if err := p.readDirectory(&p.data, p.dirty); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if p.cfg.ReadOnly {
+			return nil
+		}
+		return os.MkdirAll(p.cfg.Path, 0755)
+	}
+
+	if p.cfg.ReadOnly {
+		clear(p.dirty)
+	}
+	return nil
+}
+
+// readDirectory reads the directory into d. Files holding keys that belong
+// elsewhere, e.g. after Shards changed, are added to misplaced together with
+// the files those keys belong to.
+func (p *provider[K, V]) readDirectory(d *data[K, V], misplaced map[string]bool) error {
+	entries, err := os.ReadDir(p.cfg.Path)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		base, ok := strings.CutSuffix(name, p.ext())
+		if entry.IsDir() || !ok {
+			continue
+		}
+
+		raw, err := os.ReadFile(filepath.Join(p.cfg.Path, name))
+		if err != nil {
+			return err
+		}
+
+		entries := map[K]V{}
+		switch {
+		case base == referencesName:
+			if err := p.unmarshal(raw, d); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		case strings.HasPrefix(base, shardPrefix):
+			if err := unmarshalAs(p.fileType, raw, &entries); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		default:
+			unescaped, err := url.PathUnescape(base)
+			if err != nil {
+				return errors.NewCorrupted(fmt.Errorf("%s: %w", name, err))
+			}
+			key, err := parseKey[K](unescaped)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			var value V
+			if err := unmarshalAs(p.fileType, raw, &value); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			entries[key] = value
+		}
+
+		for key, value := range entries {
+			d.DataMap[key] = value
+			if file := p.fileOf(key); file != name {
+				misplaced[name] = true
+				misplaced[file] = true
+			}
+		}
+	}
+
+	return nil
+}
+
+func unmarshalAs(t Type, raw []byte, v any) error {
+	var err error
+	if t == yml {
+		err = yaml.Unmarshal(raw, v)
+	} else {
+		err = json.Unmarshal(raw, v)
+	}
+	if err != nil {
+		return errors.NewCorrupted(err)
+	}
+
+	return nil
+}
+
+func marshalAs(t Type, v any) ([]byte, error) {
+	if t == yml {
+		return yaml.Marshal(v)
+	}
+
+	return json.MarshalIndent(v, "", "  ")
+}
+
+// saveDirectory rewrites the dirty files and removes the ones left empty.
+func (p *provider[K, V]) saveDirectory() error {
+	if len(p.dirty) == 0 {
+		return nil
+	}
+
+	contents := map[string]any{}
+	for key, value := range p.data.DataMap {
+		name := p.fileOf(key)
+		if !p.dirty[name] {
+			continue
+		}
+		if p.cfg.Directory.GetValue().Shards == 0 {
+			contents[name] = value
+			continue
+		}
+		shard, ok := contents[name].(map[K]V)
+		if !ok {
+			shard = map[K]V{}
+			contents[name] = shard
+		}
+		shard[key] = value
+	}
+	references := referencesName + p.ext()
+	if p.dirty[references] && len(p.data.References)+len(p.data.ReferenceSets) > 0 {
+		contents[references] = data[K, V]{References: p.data.References, ReferenceSets: p.data.ReferenceSets}
+	}
+
+	// Dirty files without contents are removed.
+	raws := make(map[string][]byte, len(p.dirty))
+	for name := range p.dirty {
+		content, ok := contents[name]
+		if !ok {
+			raws[name] = nil
+			continue
+		}
+		raw, err := marshalAs(p.fileType, content)
+		if err != nil {
+			return err
+		}
+		raws[name] = raw
+	}
+
+	// Files are written one by one. After a Timeout the dirty set is kept, so
+	// the next save rewrites the files that were already written.
+	deadline := timeout.Start(p.cfg.WriteTimeout, "file write")
+	err := func() error {
+		for name, raw := range raws {
+			path := filepath.Join(p.cfg.Path, name)
+			if raw == nil {
+				if err := deadline.Err(); err != nil {
+					return err
+				}
+				if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return err
+				}
+			} else if err := writeAtomic(path, raw, deadline); err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		p.log(slog.LevelError, "failed to save directory", "error", err)
+		return err
+	}
+
+	clear(p.dirty)
+	p.lastFlush = time.Now()
+	return nil
+}
+
+// directorySize sums the sizes of the files of the directory.
+func (p *provider[K, V]) directorySize() (int64, error) {
+	entries, err := os.ReadDir(p.cfg.Path)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), p.ext()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return 0, err
+		}
+		size += info.Size()
+	}
+
+	return size, nil
+}
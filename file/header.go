@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import (
+	"encoding/json"
+	baseErrors "errors"
+	"fmt"
+	"github.com/rlshukhov/storage/errors"
+	"gopkg.in/yaml.v3"
+	"reflect"
+)
+
+// formatVersion is the version of the json and yaml file layout. It is
+// raised when a file written by this version can not be read by older ones.
+const formatVersion = 1
+
+// header describes what a json or yaml file holds, so a file opened with the
+// wrong type parameters fails with a clear error instead of an unmarshal
+// error somewhere in the data.
+type header struct {
+	FormatVersion int    `yaml:"format_version" json:"format_version"`
+	KeyType       string `yaml:"key_type" json:"key_type"`
+	ValueType     string `yaml:"value_type" json:"value_type"`
+	Codec         Type   `yaml:"codec" json:"codec"`
+}
+
+func newHeader[K comparable, V any](codec Type) *header {
+	return &header{
+		FormatVersion: formatVersion,
+		KeyType:       reflect.TypeFor[K]().Kind().String(),
+		ValueType:     reflect.TypeFor[V]().String(),
+		Codec:         codec,
+	}
+}
+
+// untyped reports whether V is any. Tools like storage-fsck open files of
+// every value type that way, so the value type is neither checked nor
+// rewritten for them.
+func untyped[V any]() bool {
+	return reflect.TypeFor[V]() == reflect.TypeFor[any]()
+}
+
+// header returns the header to write. Providers with any values keep the
+// value type of the file they read.
+func (p *provider[K, V]) header(codec Type) *header {
+	h := newHeader[K, V](codec)
+	if untyped[V]() {
+		h.ValueType = p.valueType
+	}
+
+	return h
+}
+
+// checkHeader reads only the header of raw and compares it with the type
+// parameters and the codec the file is read with. Files without a header,
+// and fields missing from it, are accepted.
+func (p *provider[K, V]) checkHeader(raw []byte, codec Type, path string) error {
+	h, err := checkHeader[K, V](raw, codec, path)
+	if err != nil {
+		return err
+	}
+	if h != nil && h.ValueType != "" {
+		p.valueType = h.ValueType
+	}
+
+	return nil
+}
+
+func checkHeader[K comparable, V any](raw []byte, codec Type, path string) (*header, error) {
+	var stored struct {
+		Header *header `yaml:"header" json:"header"`
+	}
+
+	var err error
+	switch codec {
+	case yml:
+		err = yaml.Unmarshal(raw, &stored)
+	case jsn:
+		err = json.Unmarshal(raw, &stored)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.NewCorrupted(fmt.Errorf("%s is not a %s storage file: %w", path, codec, err))
+	}
+	if stored.Header == nil {
+		return nil, nil
+	}
+
+	h, expected := stored.Header, newHeader[K, V](codec)
+	var errs []error
+	if h.FormatVersion > formatVersion {
+		errs = append(errs, fmt.Errorf("format version %d is newer than the supported version %d", h.FormatVersion, formatVersion))
+	}
+	if h.KeyType != "" && h.KeyType != expected.KeyType {
+		errs = append(errs, fmt.Errorf("keys are %s, but the provider uses %s keys", h.KeyType, expected.KeyType))
+	}
+	if h.ValueType != "" && h.ValueType != expected.ValueType && !untyped[V]() {
+		errs = append(errs, fmt.Errorf("values are %s, but the provider uses %s values", h.ValueType, expected.ValueType))
+	}
+	if h.Codec != "" && h.Codec != expected.Codec {
+		errs = append(errs, fmt.Errorf("the file was written as %s, but is read as %s", h.Codec, expected.Codec))
+	}
+	if len(errs) > 0 {
+		return nil, errors.NewSchemaMismatch(fmt.Errorf("%s: %w", path, baseErrors.Join(errs...)))
+	}
+
+	return h, nil
+}
@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	baseErrors "errors"
+	"fmt"
+	"github.com/rlshukhov/storage/errors"
+)
+
+// sign returns a copy of d with an HMAC for every entry. Key and value are
+// signed in their JSON form, so the signatures are the same for JSON and YAML.
+func (p *provider[K, V]) sign(d data[K, V]) (data[K, V], error) {
+	d.Signatures = make(map[K]string, len(d.DataMap))
+	for key, value := range d.DataMap {
+		sum, err := p.signature(key, value)
+		if err != nil {
+			return d, err
+		}
+		d.Signatures[key] = hex.EncodeToString(sum)
+	}
+
+	return d, nil
+}
+
+func (p *provider[K, V]) checkSignatures(d *data[K, V]) error {
+	signatures := d.Signatures
+	d.Signatures = nil
+	if p.signer == nil {
+		return nil
+	}
+
+	var errs []error
+	for key, value := range d.DataMap {
+		sum, err := hex.DecodeString(signatures[key])
+		if err != nil || len(sum) == 0 {
+			errs = append(errs, errors.NewTampered(fmt.Errorf("key %v: integrity signature is missing", key)))
+			continue
+		}
+
+		k, v, err := signedForm(key, value)
+		if err != nil {
+			return err
+		}
+		if !p.signer.Valid(k, v, sum) {
+			errs = append(errs, errors.NewTampered(fmt.Errorf("key %v: integrity check failed", key)))
+		}
+	}
+	for key := range signatures {
+		if _, ok := d.DataMap[key]; !ok {
+			errs = append(errs, errors.NewTampered(fmt.Errorf("key %v: entry was removed", key)))
+		}
+	}
+
+	return baseErrors.Join(errs...)
+}
+
+func (p *provider[K, V]) signature(key K, value V) ([]byte, error) {
+	k, v, err := signedForm(key, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.signer.Sign(k, v), nil
+}
+
+func signedForm[K comparable, V any](key K, value V) ([]byte, []byte, error) {
+	k, err := json.Marshal(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	v, err := json.Marshal(value)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return k, v, nil
+}
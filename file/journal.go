@@ -0,0 +1,344 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	baseErrors "errors"
+	"fmt"
+	"github.com/rlshukhov/storage/errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type JournalConfig struct {
+	Path            string `yaml:"path,omitempty"`
+	CheckpointEvery int    `yaml:"checkpoint_every,omitempty"`
+	Retain          int    `yaml:"retain,omitempty"`
+}
+
+type journalOp string
+
+const (
+	opStore           journalOp = "store"
+	opRemove          journalOp = "remove"
+	opStoreReference  journalOp = "store_reference"
+	opRemoveReference journalOp = "remove_reference"
+	opAddReference    journalOp = "add_reference"
+	opRemoveTarget    journalOp = "remove_reference_target"
+)
+
+const defaultCheckpointEvery = 1000
+
+type journalEntry[K comparable, V any] struct {
+	Time     time.Time `json:"time"`
+	Op       journalOp `json:"op"`
+	Key      K         `json:"key"`
+	Value    *V        `json:"value,omitempty"`
+	Previous *V        `json:"previous,omitempty"`
+	Target   *K        `json:"target,omitempty"`
+	Origin   *K        `json:"origin,omitempty"`
+
+	OriginSet []K `json:"origin_set,omitempty"`
+}
+
+func (p *provider[K, V]) journalPath() string {
+	cfg := p.cfg.Journal.GetValue()
+	if cfg.Path != "" {
+		return cfg.Path
+	}
+
+	return p.cfg.Path + ".journal"
+}
+
+func (p *provider[K, V]) openJournal() error {
+	entries, err := readJournal[K, V](p.journalPath())
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		p.redo(entry)
+	}
+	p.pending = len(entries)
+	if len(entries) > 0 {
+		p.log(slog.LevelInfo, "replayed journal", "entries", len(entries), "journal", p.journalPath())
+	}
+
+	if p.cfg.ReadOnly {
+		return nil
+	}
+
+	f, err := os.OpenFile(p.journalPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	p.journalFile = f
+	return nil
+}
+
+func (p *provider[K, V]) closeJournal() error {
+	if p.journalFile == nil {
+		return nil
+	}
+
+	err := p.journalFile.Close()
+	p.journalFile = nil
+	return err
+}
+
+func (p *provider[K, V]) record(entry journalEntry[K, V]) error {
+	if p.dirty != nil {
+		p.markDirty(entry)
+	}
+	if p.db != nil {
+		p.batch = append(p.batch, entry)
+		return nil
+	}
+	if p.journalFile == nil {
+		return nil
+	}
+
+	entry.Time = time.Now()
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if _, err := p.journalFile.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	return p.journalFile.Sync()
+}
+
+func (p *provider[K, V]) persist() error {
+	if p.db != nil {
+		return p.applyBatch()
+	}
+	if p.journalFile == nil {
+		return p.saveToFile()
+	}
+
+	p.pending++
+	checkpointEvery := p.cfg.Journal.GetValue().CheckpointEvery
+	if checkpointEvery <= 0 {
+		checkpointEvery = defaultCheckpointEvery
+	}
+	if p.pending < checkpointEvery {
+		return nil
+	}
+
+	return p.checkpoint()
+}
+
+func (p *provider[K, V]) checkpoint() error {
+	if err := p.saveToFile(); err != nil {
+		return err
+	}
+
+	if p.journalFile == nil {
+		return nil
+	}
+
+	if err := p.journalFile.Close(); err != nil {
+		return err
+	}
+	p.journalFile = nil
+
+	p.log(slog.LevelDebug, "journal checkpoint", "entries", p.pending)
+
+	retain := p.cfg.Journal.GetValue().Retain
+	path := p.journalPath()
+	if retain > 0 && p.pending > 0 {
+		archived := path + "." + strconv.FormatInt(time.Now().UnixNano(), 10)
+		if err := os.Rename(path, archived); err != nil {
+			return err
+		}
+		if err := pruneArchives(path, retain); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	p.journalFile = f
+	p.pending = 0
+	return nil
+}
+
+func (p *provider[K, V]) RestoreToTime(t time.Time) error {
+	if p.cfg.ReadOnly {
+		return errors.ReadOnly
+	}
+	if !p.cfg.Journal.HasValue() {
+		return baseErrors.New("journal is not configured")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return errors.Closed
+	}
+
+	p.detach()
+
+	path := p.journalPath()
+	archives, err := listArchives(path)
+	if err != nil {
+		return err
+	}
+
+	var history []journalEntry[K, V]
+	for _, segment := range append(archives, path) {
+		entries, err := readJournal[K, V](segment)
+		if err != nil {
+			return err
+		}
+		history = append(history, entries...)
+	}
+
+	if len(history) > 0 && history[0].Time.After(t) {
+		return fmt.Errorf("journal history does not reach back to %s", t.Format(time.RFC3339Nano))
+	}
+
+	for i := len(history) - 1; i >= 0 && history[i].Time.After(t); i-- {
+		p.undo(history[i])
+	}
+
+	for _, archive := range archives {
+		if err := os.Remove(archive); err != nil {
+			return err
+		}
+	}
+
+	p.pending = 0
+	return p.checkpoint()
+}
+
+func (p *provider[K, V]) redo(entry journalEntry[K, V]) {
+	switch entry.Op {
+	case opStore:
+		if entry.Value != nil {
+			p.data.DataMap[entry.Key] = *entry.Value
+		}
+	case opRemove:
+		delete(p.data.DataMap, entry.Key)
+	case opStoreReference:
+		if entry.Target != nil {
+			p.setTargets(entry.Key, []K{*entry.Target})
+		}
+	case opRemoveReference:
+		p.setTargets(entry.Key, nil)
+	case opAddReference:
+		if entry.Target != nil {
+			p.addTarget(entry.Key, *entry.Target)
+		}
+	case opRemoveTarget:
+		if entry.Target != nil {
+			p.removeTarget(entry.Key, *entry.Target)
+		}
+	}
+}
+
+func (p *provider[K, V]) undo(entry journalEntry[K, V]) {
+	switch entry.Op {
+	case opStore, opRemove:
+		if entry.Previous != nil {
+			p.data.DataMap[entry.Key] = *entry.Previous
+		} else {
+			delete(p.data.DataMap, entry.Key)
+		}
+	case opStoreReference, opRemoveReference, opAddReference, opRemoveTarget:
+		if entry.Origin != nil {
+			p.setTargets(entry.Key, []K{*entry.Origin})
+		} else {
+			p.setTargets(entry.Key, entry.OriginSet)
+		}
+	}
+}
+
+func readJournal[K comparable, V any](path string) ([]journalEntry[K, V], error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(raw), []byte("\n"))
+
+	var entries []journalEntry[K, V]
+	for i, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+
+		var entry journalEntry[K, V]
+		if err := json.Unmarshal(line, &entry); err != nil {
+			if i == len(lines)-1 {
+				break
+			}
+			return nil, errors.NewCorrupted(err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func listArchives(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	var archives []string
+	for _, match := range matches {
+		suffix := strings.TrimPrefix(match, path+".")
+		if _, err := strconv.ParseInt(suffix, 10, 64); err == nil {
+			archives = append(archives, match)
+		}
+	}
+
+	slices.SortFunc(archives, func(a, b string) int {
+		x, _ := strconv.ParseInt(strings.TrimPrefix(a, path+"."), 10, 64)
+		y, _ := strconv.ParseInt(strings.TrimPrefix(b, path+"."), 10, 64)
+		return cmp.Compare(x, y)
+	})
+
+	return archives, nil
+}
+
+func pruneArchives(path string, retain int) error {
+	archives, err := listArchives(path)
+	if err != nil {
+		return err
+	}
+
+	for len(archives) > retain {
+		if err := os.Remove(archives[0]); err != nil {
+			return err
+		}
+		archives = archives[1:]
+	}
+
+	return nil
+}
@@ -0,0 +1,207 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import (
+	baseErrors "errors"
+	"fmt"
+	"github.com/rlshukhov/storage/errors"
+	"github.com/rlshukhov/storage/internal/timeout"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+// overlay holds the changes made on top of inline content: the entries and
+// references that were added or changed, and the ones that were removed.
+type overlay[K comparable, V any] struct {
+	data[K, V] `yaml:",inline"`
+
+	Removed           []K `yaml:"removed,omitempty" json:"removed,omitempty"`
+	RemovedReferences []K `yaml:"removed_references,omitempty" json:"removed_references,omitempty"`
+}
+
+// errContentReadOnly is returned for writes to inline content without an
+// overlay file.
+var errContentReadOnly = errors.NewReadOnly(baseErrors.New("inline content is read-only: set overlay_path to keep writes in a file"))
+
+func overlayType(path string) (Type, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return yml, nil
+	case ".json":
+		return jsn, nil
+	default:
+		return "", fmt.Errorf("unsupported overlay extension %q: only .json, .yaml and .yml are supported", filepath.Ext(path))
+	}
+}
+
+// writable returns the error writes fail with, if any.
+func (p *provider[K, V]) writable() error {
+	if p.cfg.ReadOnly {
+		return errors.ReadOnly
+	}
+	if p.cfg.Content != "" && p.cfg.OverlayPath == "" {
+		return errContentReadOnly
+	}
+
+	return nil
+}
+
+// readOverlay applies the overlay file, if there is one, on top of d.
+func (p *provider[K, V]) readOverlay(d *data[K, V]) error {
+	if p.cfg.OverlayPath == "" {
+		return nil
+	}
+
+	raw, err := os.ReadFile(p.cfg.OverlayPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	t, err := overlayType(p.cfg.OverlayPath)
+	if err != nil {
+		return err
+	}
+	if len(strings.TrimSpace(string(raw))) == 0 {
+		return nil
+	}
+	if err := p.checkHeader(raw, t, p.cfg.OverlayPath); err != nil {
+		return err
+	}
+	var o overlay[K, V]
+	if err := unmarshalAs(t, raw, &o); err != nil {
+		return fmt.Errorf("%s: %w", p.cfg.OverlayPath, err)
+	}
+
+	if d.DataMap == nil {
+		d.DataMap = map[K]V{}
+	}
+	maps.Copy(d.DataMap, o.DataMap)
+	for _, key := range o.Removed {
+		delete(d.DataMap, key)
+	}
+	for _, reference := range o.RemovedReferences {
+		setTargets(d, reference, nil)
+	}
+	for reference, key := range o.References {
+		setTargets(d, reference, []K{key})
+	}
+	for reference, keys := range o.ReferenceSets {
+		setTargets(d, reference, keys)
+	}
+
+	return nil
+}
+
+// saveOverlay writes the difference between the data and the inline content
+// to the overlay file.
+func (p *provider[K, V]) saveOverlay() error {
+	t, err := overlayType(p.cfg.OverlayPath)
+	if err != nil {
+		return err
+	}
+
+	o := overlay[K, V]{
+		data: data[K, V]{
+			Header:        p.header(t),
+			DataMap:       map[K]V{},
+			References:    map[K]K{},
+			ReferenceSets: map[K][]K{},
+		},
+	}
+	for key, value := range p.data.DataMap {
+		if base, ok := p.base.DataMap[key]; !ok || !reflect.DeepEqual(base, value) {
+			o.DataMap[key] = value
+		}
+	}
+	for _, key := range sortedKeys(p.base.DataMap) {
+		if _, ok := p.data.DataMap[key]; !ok {
+			o.Removed = append(o.Removed, key)
+		}
+	}
+
+	current, base := allTargets(p.data), allTargets(p.base)
+	for reference, keys := range current {
+		if slices.Equal(keys, base[reference]) {
+			continue
+		}
+		setTargets(&o.data, reference, keys)
+	}
+	for _, reference := range sortedKeys(base) {
+		if _, ok := current[reference]; !ok {
+			o.RemovedReferences = append(o.RemovedReferences, reference)
+		}
+	}
+
+	raw, err := marshalAs(t, o)
+	if err != nil {
+		return err
+	}
+
+	err = writeAtomic(p.cfg.OverlayPath, raw, timeout.Start(p.cfg.WriteTimeout, "file write"))
+	if err != nil {
+		p.log(slog.LevelError, "failed to save overlay", "error", err)
+		return err
+	}
+
+	p.lastFlush = time.Now()
+	return nil
+}
+
+func allTargets[K comparable, V any](d data[K, V]) map[K][]K {
+	targets := make(map[K][]K, len(d.References)+len(d.ReferenceSets))
+	for reference, key := range d.References {
+		targets[reference] = []K{key}
+	}
+	for reference, keys := range d.ReferenceSets {
+		targets[reference] = keys
+	}
+
+	return targets
+}
+
+func setTargets[K comparable, V any](d *data[K, V], reference K, targets []K) {
+	if d.References == nil {
+		d.References = map[K]K{}
+	}
+	if d.ReferenceSets == nil {
+		d.ReferenceSets = map[K][]K{}
+	}
+
+	delete(d.References, reference)
+	delete(d.ReferenceSets, reference)
+	switch len(targets) {
+	case 0:
+	case 1:
+		d.References[reference] = targets[0]
+	default:
+		d.ReferenceSets[reference] = slices.Clone(targets)
+	}
+}
+
+func cloneData[K comparable, V any](d data[K, V]) data[K, V] {
+	sets := make(map[K][]K, len(d.ReferenceSets))
+	for reference, keys := range d.ReferenceSets {
+		sets[reference] = slices.Clone(keys)
+	}
+
+	return data[K, V]{
+		DataMap:       maps.Clone(d.DataMap),
+		References:    maps.Clone(d.References),
+		ReferenceSets: sets,
+	}
+}
@@ -9,51 +9,214 @@
 package file
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
 	"encoding/json"
 	baseErrors "errors"
+	"fmt"
+	"github.com/rlshukhov/nullable"
 	"github.com/rlshukhov/storage/errors"
+	"github.com/rlshukhov/storage/integrity"
+	"github.com/rlshukhov/storage/internal/timeout"
+	"github.com/rlshukhov/storage/kv"
 	"gopkg.in/yaml.v3"
+	"io"
+	"log/slog"
 	"maps"
 	"os"
 	"path/filepath"
+	"reflect"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 type Config struct {
-	Path    string `yaml:"path"`
-	Content string `yaml:"content"`
+	Path     string                           `yaml:"path"`
+	Content  string                           `yaml:"content"`
+	ReadOnly bool                             `yaml:"read_only,omitempty"`
+	Journal  nullable.Nullable[JournalConfig] `yaml:"journal"`
+
+	// OverlayPath is a .json or .yaml file that keeps the writes made on top
+	// of Content. Without it, inline content is read-only.
+	OverlayPath string `yaml:"overlay_path,omitempty"`
+
+	Directory nullable.Nullable[DirectoryConfig] `yaml:"directory"`
+
+	// PreserveComments keeps the comments, anchors and scalar styles of a
+	// YAML file when it is rewritten.
+	PreserveComments bool `yaml:"preserve_comments,omitempty"`
+
+	ReferencesPath string `yaml:"references_path,omitempty"`
+
+	Integrity nullable.Nullable[integrity.Config] `yaml:"integrity"`
+
+	MaxReferenceDepth int           `yaml:"max_reference_depth,omitempty"`
+	WriteTimeout      time.Duration `yaml:"write_timeout,omitempty"`
+
+	Logger *slog.Logger `yaml:"-"`
 }
 
+const defaultMaxReferenceDepth = 8
+
 type Type string
 
 const (
-	jsn Type = "json"
-	yml Type = "yaml"
+	jsn        Type = "json"
+	yml        Type = "yaml"
+	csvType    Type = "csv"
+	sqliteType Type = "sqlite"
 )
 
 type data[K comparable, V any] struct {
-	DataMap    map[K]V `yaml:"data,omitempty" json:"data,omitempty"`
-	References map[K]K `yaml:"references,omitempty" json:"references,omitempty"`
+	Header *header `yaml:"header,omitempty" json:"header,omitempty"`
+
+	DataMap       map[K]V   `yaml:"data,omitempty" json:"data,omitempty"`
+	References    map[K]K   `yaml:"references,omitempty" json:"references,omitempty"`
+	ReferenceSets map[K][]K `yaml:"reference_sets,omitempty" json:"reference_sets,omitempty"`
+
+	Signatures map[K]string `yaml:"signatures,omitempty" json:"signatures,omitempty"`
 }
 
 type provider[K comparable, V any] struct {
-	cfg      Config
-	data     data[K, V]
-	fileType Type
-	mu       sync.RWMutex
+	cfg       Config
+	data      data[K, V]
+	fileType  Type
+	mu        sync.RWMutex
+	lastFlush time.Time
+	closed    bool
+	// shared is set while read views hold DataMap; the next write copies it.
+	shared bool
+
+	journalFile *os.File
+	pending     int
+
+	db    *sql.DB
+	batch []journalEntry[K, V]
+
+	signer *integrity.Signer
+
+	// dirty holds the names of the files to rewrite in directory mode.
+	dirty map[string]bool
+	// yamlDoc is the last YAML document read or written with
+	// PreserveComments.
+	yamlDoc *yaml.Node
+	// base is the inline content the overlay is written against.
+	base data[K, V]
+	// valueType is the value type in the header of the file read, kept for
+	// providers with any values.
+	valueType string
+}
+
+func (c Config) Validate() error {
+	var errs []error
+	switch {
+	case c.Path == "" && c.Content == "":
+		errs = append(errs, baseErrors.New("either path or content is required"))
+	case c.Path != "" && c.Content != "":
+		errs = append(errs, baseErrors.New("path and content are mutually exclusive"))
+	case c.Path != "" && c.Directory.HasValue():
+		if err := c.Directory.GetValue().Validate(); err != nil {
+			errs = append(errs, err)
+		}
+		if c.Journal.HasValue() {
+			errs = append(errs, baseErrors.New("journal is not supported with a directory: each write rewrites only the files it changes"))
+		}
+		if c.Integrity.HasValue() {
+			errs = append(errs, baseErrors.New("integrity is not supported with a directory"))
+		}
+	case c.Path != "":
+		switch strings.ToLower(filepath.Ext(c.Path)) {
+		case ".yaml", ".yml", ".json", ".csv":
+		case ".db", ".sqlite", ".sqlite3":
+			if c.Journal.HasValue() {
+				errs = append(errs, baseErrors.New("journal is not supported with sqlite files: sqlite keeps its own write-ahead log"))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("unsupported file extension %q: only .json, .yaml, .yml, .csv, .db, .sqlite, and .sqlite3 are supported", filepath.Ext(c.Path)))
+		}
+	}
+	if c.OverlayPath != "" {
+		if c.Content == "" {
+			errs = append(errs, baseErrors.New("overlay_path is only supported with inline content"))
+		}
+		if _, err := overlayType(c.OverlayPath); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.Content != "" && c.Directory.HasValue() {
+		errs = append(errs, baseErrors.New("directory is not supported with inline content"))
+	}
+	if c.ReferencesPath != "" && (c.Directory.HasValue() || strings.ToLower(filepath.Ext(c.Path)) != ".csv") {
+		errs = append(errs, baseErrors.New("references_path is only supported with .csv files"))
+	}
+	if c.Integrity.HasValue() {
+		if err := c.Integrity.GetValue().Validate(); err != nil {
+			errs = append(errs, err)
+		}
+		switch {
+		case c.Content != "":
+			errs = append(errs, baseErrors.New("integrity is not supported with inline content"))
+		case c.Journal.HasValue():
+			errs = append(errs, baseErrors.New("integrity is not supported with a journal"))
+		case c.Directory.HasValue():
+		default:
+			switch strings.ToLower(filepath.Ext(c.Path)) {
+			case ".yaml", ".yml", ".json":
+			default:
+				errs = append(errs, baseErrors.New("integrity is only supported with .json and .yaml files"))
+			}
+		}
+	}
+
+	if c.PreserveComments {
+		switch ext := strings.ToLower(filepath.Ext(c.Path)); {
+		case c.Path == "" || c.Directory.HasValue():
+			errs = append(errs, baseErrors.New("preserve_comments is only supported with a .yaml or .yml path"))
+		case ext != ".yaml" && ext != ".yml":
+			errs = append(errs, baseErrors.New("preserve_comments is only supported with .yaml and .yml files"))
+		}
+	}
+	if c.MaxReferenceDepth < 0 {
+		errs = append(errs, baseErrors.New("max_reference_depth must not be negative"))
+	}
+	if c.WriteTimeout < 0 {
+		errs = append(errs, baseErrors.New("write_timeout must not be negative"))
+	}
+
+	if c.Journal.HasValue() {
+		journal := c.Journal.GetValue()
+		if c.Content != "" {
+			errs = append(errs, baseErrors.New("journal is not supported with inline content"))
+		}
+		if journal.CheckpointEvery < 0 {
+			errs = append(errs, baseErrors.New("journal checkpoint_every must not be negative"))
+		}
+		if journal.Retain < 0 {
+			errs = append(errs, baseErrors.New("journal retain must not be negative"))
+		}
+	}
+
+	return baseErrors.Join(errs...)
 }
 
 func New[K ~string | ~uint64, V any](cfg Config) (*provider[K, V], error) {
 	p := &provider[K, V]{
 		cfg: cfg,
 		data: data[K, V]{
-			DataMap:    map[K]V{},
-			References: map[K]K{},
+			DataMap:       map[K]V{},
+			References:    map[K]K{},
+			ReferenceSets: map[K][]K{},
 		},
 	}
 
+	if cfg.Journal.HasValue() && cfg.Content != "" {
+		return nil, baseErrors.New("journal is not supported with inline content")
+	}
+
 	if cfg.Content != "" {
 		err := json.Unmarshal([]byte(cfg.Content), &p.data)
 		if err != nil {
@@ -66,14 +229,32 @@ func New[K ~string | ~uint64, V any](cfg Config) (*provider[K, V], error) {
 		} else {
 			p.fileType = jsn
 		}
+		if cfg.OverlayPath != "" {
+			if _, err := overlayType(cfg.OverlayPath); err != nil {
+				return nil, err
+			}
+		}
+		p.base = cloneData(p.data)
+	} else if cfg.Directory.HasValue() {
+		if cfg.Journal.HasValue() || cfg.Integrity.HasValue() {
+			return nil, baseErrors.New("journal and integrity are not supported with a directory")
+		}
+		p.fileType = cfg.Directory.GetValue().format()
 	} else {
 		switch strings.ToLower(filepath.Ext(cfg.Path)) {
 		case ".yaml", ".yml":
 			p.fileType = yml
 		case ".json":
 			p.fileType = jsn
+		case ".csv":
+			p.fileType = csvType
+		case ".db", ".sqlite", ".sqlite3":
+			if cfg.Journal.HasValue() {
+				return nil, baseErrors.New("journal is not supported with sqlite files")
+			}
+			p.fileType = sqliteType
 		default:
-			return nil, baseErrors.New("unsupported file format: only .json, .yaml, and .yml are supported")
+			return nil, baseErrors.New("unsupported file format: only .json, .yaml, .yml, .csv, .db, .sqlite, and .sqlite3 are supported")
 		}
 	}
 
@@ -81,58 +262,326 @@ func New[K ~string | ~uint64, V any](cfg Config) (*provider[K, V], error) {
 }
 
 func (p *provider[K, V]) Setup() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.detach()
+	p.closed = false
+
 	if p.cfg.Content != "" {
-		return nil
+		return p.readOverlay(&p.data)
 	}
 
-	p.mu.Lock()
-	defer p.mu.Unlock()
+	if p.fileType == sqliteType {
+		return p.openSQLite()
+	}
+	if p.isDirectory() {
+		return p.loadDirectory()
+	}
+
+	if p.cfg.Integrity.HasValue() {
+		signer, err := p.cfg.Integrity.GetValue().Signer()
+		if err != nil {
+			return err
+		}
+		p.signer = signer
+	}
 
 	if _, err := os.Stat(p.cfg.Path); errors.Is(err, os.ErrNotExist) {
-		return os.WriteFile(p.cfg.Path, []byte(""), 0644)
+		if !p.cfg.ReadOnly {
+			if err := os.WriteFile(p.cfg.Path, []byte(""), 0644); err != nil {
+				return err
+			}
+		}
 	} else if err != nil {
 		return err
+	} else {
+		data, err := os.ReadFile(p.cfg.Path)
+		if err != nil {
+			return err
+		}
+
+		if err := p.unmarshal(data, &p.data); err != nil {
+			return err
+		}
+		if err := p.checkSignatures(&p.data); err != nil {
+			return err
+		}
+		if p.cfg.PreserveComments && p.fileType == yml {
+			if p.yamlDoc, err = parseYAMLDocument(data); err != nil {
+				return err
+			}
+		}
 	}
 
-	data, err := os.ReadFile(p.cfg.Path)
-	if err != nil {
+	if err := p.readReferences(&p.data); err != nil {
 		return err
 	}
 
+	if p.cfg.Journal.HasValue() {
+		return p.openJournal()
+	}
+
+	return nil
+}
+
+func (p *provider[K, V]) unmarshal(raw []byte, d *data[K, V]) error {
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return nil
+	}
+
+	if err := p.checkHeader(raw, p.fileType, p.cfg.Path); err != nil {
+		return err
+	}
+
+	var err error
 	switch p.fileType {
 	case yml:
-		if err := yaml.Unmarshal(data, &p.data); err != nil {
-			return err
-		}
+		err = yaml.Unmarshal(raw, d)
 	case jsn:
-		if err := json.Unmarshal(data, &p.data); err != nil {
-			return err
-		}
+		err = json.Unmarshal(raw, d)
+	case csvType:
+		err = unmarshalCSV(raw, d)
 	default:
 		return baseErrors.New("unsupported file format")
 	}
 
+	if err != nil {
+		return errors.NewCorrupted(err)
+	}
+
 	return nil
 }
 
+func (p *provider[K, V]) readReferences(d *data[K, V]) error {
+	if p.fileType != csvType || p.cfg.Content != "" {
+		return nil
+	}
+
+	raw, err := os.ReadFile(referencesPath(p.cfg))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return p.unmarshal(raw, d)
+}
+
+func (p *provider[K, V]) Verify() error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return errors.Closed
+	}
+
+	if p.fileType == sqliteType {
+		return p.verifySQLite()
+	}
+
+	if p.isDirectory() {
+		d := data[K, V]{DataMap: map[K]V{}}
+		err := p.readDirectory(&d, map[string]bool{})
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	raw := []byte(p.cfg.Content)
+	if p.cfg.Content == "" {
+		var err error
+		raw, err = os.ReadFile(p.cfg.Path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	d := data[K, V]{}
+	if err := p.unmarshal(raw, &d); err != nil {
+		return err
+	}
+	if err := p.checkSignatures(&d); err != nil {
+		return err
+	}
+	if err := p.readOverlay(&d); err != nil {
+		return err
+	}
+
+	return p.readReferences(&d)
+}
+
 func (p *provider[K, V]) Shutdown() error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	return p.saveToFile()
+	if p.closed {
+		return nil
+	}
+
+	if p.writable() == nil {
+		if err := p.checkpoint(); err != nil {
+			return err
+		}
+	}
+	if err := p.closeJournal(); err != nil {
+		return err
+	}
+	if err := p.closeSQLite(); err != nil {
+		return err
+	}
+
+	p.closed = true
+	return nil
+}
+
+func (p *provider[K, V]) Close() error {
+	return p.Shutdown()
+}
+
+func (p *provider[K, V]) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if p.cfg.Content != "" {
+		return nil
+	}
+
+	_, err := os.Stat(p.cfg.Path)
+	return err
+}
+
+func (p *provider[K, V]) Stats() (kv.ProviderStats, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return kv.ProviderStats{}, errors.Closed
+	}
+
+	stats := kv.ProviderStats{
+		Provider:   "file",
+		Entries:    uint64(len(p.data.DataMap)),
+		References: uint64(len(p.data.References) + len(p.data.ReferenceSets)),
+		LastFlush:  p.lastFlush,
+		Backend: map[string]any{
+			"format":    string(p.fileType),
+			"read_only": p.cfg.ReadOnly,
+		},
+	}
+
+	if p.isDirectory() {
+		size, err := p.directorySize()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return stats, err
+		}
+		stats.DiskUsage = size
+		stats.Backend["path"] = p.cfg.Path
+		stats.Backend["shards"] = p.cfg.Directory.GetValue().Shards
+	} else if p.cfg.Content == "" {
+		info, err := os.Stat(p.cfg.Path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return stats, err
+		}
+		if err == nil {
+			stats.DiskUsage = info.Size()
+		}
+		stats.Backend["path"] = p.cfg.Path
+	}
+
+	return stats, nil
+}
+
+func (p *provider[K, V]) ApproximateSize() (uint64, error) {
+	if p.isDirectory() {
+		size, err := p.directorySize()
+		if err == nil {
+			return uint64(size), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return 0, err
+		}
+	} else if p.cfg.Content == "" {
+		info, err := os.Stat(p.cfg.Path)
+		if err == nil {
+			return uint64(info.Size()), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return 0, err
+		}
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return 0, errors.Closed
+	}
+
+	data, err := p.marshal()
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(len(data)), nil
 }
 
 func (p *provider[K, V]) Store(key K, value V) error {
+	if err := p.writable(); err != nil {
+		return err
+	}
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	if p.closed {
+		return errors.Closed
+	}
+
+	p.detach()
+
+	previous, existed := p.data.DataMap[key]
+	if err := p.record(journalEntry[K, V]{Op: opStore, Key: key, Value: &value, Previous: pointerIf(previous, existed)}); err != nil {
+		return err
+	}
 
 	p.data.DataMap[key] = value
-	return p.saveToFile()
+	return p.persist()
+}
+
+func (p *provider[K, V]) Update(key K, fn func(value V, exists bool) (V, error)) error {
+	if err := p.writable(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return errors.Closed
+	}
+
+	p.detach()
+
+	previous, exists := p.data.DataMap[key]
+	value, err := fn(previous, exists)
+	if err != nil {
+		return err
+	}
+
+	if err := p.record(journalEntry[K, V]{Op: opStore, Key: key, Value: &value, Previous: pointerIf(previous, exists)}); err != nil {
+		return err
+	}
+
+	p.data.DataMap[key] = value
+	return p.persist()
 }
 
 func (p *provider[K, V]) Get(key K) (V, error) {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
+	if p.closed {
+		var zero V
+		return zero, errors.Closed
+	}
 
 	value, exists := p.data.DataMap[key]
 	if !exists {
@@ -158,21 +607,115 @@ func (p *provider[K, V]) GetMultiple(keys []K) ([]V, error) {
 }
 
 func (p *provider[K, V]) Remove(key K) error {
+	if err := p.writable(); err != nil {
+		return err
+	}
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	if p.closed {
+		return errors.Closed
+	}
+
+	p.detach()
 
-	_, exists := p.data.DataMap[key]
+	previous, exists := p.data.DataMap[key]
 	if !exists {
 		return errors.NotFound
 	}
 
+	if err := p.record(journalEntry[K, V]{Op: opRemove, Key: key, Previous: &previous}); err != nil {
+		return err
+	}
+
 	delete(p.data.DataMap, key)
-	return p.saveToFile()
+	return p.persist()
+}
+
+func (p *provider[K, V]) RemovePrefix(prefix K) (int, error) {
+	pr := keyString(prefix)
+	return p.removeWhere(func(key K, _ V) bool {
+		return strings.HasPrefix(keyString(key), pr)
+	})
+}
+
+func (p *provider[K, V]) RemoveWhere(pred func(key K, value V) bool) (int, error) {
+	return p.removeWhere(pred)
+}
+
+func (p *provider[K, V]) removeWhere(pred func(key K, value V) bool) (int, error) {
+	if err := p.writable(); err != nil {
+		return 0, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return 0, errors.Closed
+	}
+
+	p.detach()
+
+	removed := 0
+	for key, value := range p.data.DataMap {
+		if !pred(key, value) {
+			continue
+		}
+
+		if err := p.record(journalEntry[K, V]{Op: opRemove, Key: key, Previous: &value}); err != nil {
+			return removed, err
+		}
+
+		delete(p.data.DataMap, key)
+		removed++
+	}
+
+	if removed == 0 {
+		return 0, nil
+	}
+
+	return removed, p.persist()
+}
+
+func (p *provider[K, V]) Clear() error {
+	if err := p.writable(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return errors.Closed
+	}
+
+	p.detach()
+
+	for key, value := range p.data.DataMap {
+		if err := p.record(journalEntry[K, V]{Op: opRemove, Key: key, Previous: &value}); err != nil {
+			return err
+		}
+	}
+	references := slices.Collect(maps.Keys(p.data.References))
+	references = append(references, slices.Collect(maps.Keys(p.data.ReferenceSets))...)
+	for _, reference := range references {
+		origin, originSet := p.referenceOrigin(reference)
+		if err := p.record(journalEntry[K, V]{Op: opRemoveReference, Key: reference, Origin: origin, OriginSet: originSet}); err != nil {
+			return err
+		}
+	}
+
+	clear(p.data.DataMap)
+	clear(p.data.References)
+	clear(p.data.ReferenceSets)
+	return p.persist()
 }
 
 func (p *provider[K, V]) ForEach(fn func(key K, value V) bool) error {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
+	if p.closed {
+		return errors.Closed
+	}
 
 	for k, v := range p.data.DataMap {
 		if !fn(k, v) {
@@ -183,16 +726,230 @@ func (p *provider[K, V]) ForEach(fn func(key K, value V) bool) error {
 	return nil
 }
 
+func (p *provider[K, V]) ForEachKey(fn func(key K) bool) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return errors.Closed
+	}
+
+	for k := range p.data.DataMap {
+		if !fn(k) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (p *provider[K, V]) ForEachReference(fn func(reference K, key K) bool) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return errors.Closed
+	}
+
+	for r, k := range p.data.References {
+		if !fn(r, k) {
+			return nil
+		}
+	}
+	for r, keys := range p.data.ReferenceSets {
+		for _, k := range keys {
+			if !fn(r, k) {
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *provider[K, V]) ForEachPrefix(prefix K, fn func(key K, value V) bool) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return errors.Closed
+	}
+
+	pr := keyString(prefix)
+	var keys []K
+	for k := range p.data.DataMap {
+		if strings.HasPrefix(keyString(k), pr) {
+			keys = append(keys, k)
+		}
+	}
+
+	slices.SortFunc(keys, func(a, b K) int {
+		return strings.Compare(keyString(a), keyString(b))
+	})
+
+	for _, k := range keys {
+		if !fn(k, p.data.DataMap[k]) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func keyString[K comparable](k K) string {
+	v := reflect.ValueOf(k)
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	default:
+		return fmt.Sprint(k)
+	}
+}
+
+func (p *provider[K, V]) Backup(w io.Writer) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return errors.Closed
+	}
+
+	if p.fileType == sqliteType {
+		return p.backupSQLite(w)
+	}
+
+	data, err := p.marshal()
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
 func (p *provider[K, V]) saveToFile() error {
-	var data []byte
+	if p.fileType == sqliteType {
+		return p.checkpointSQLite()
+	}
+	if p.cfg.Content != "" {
+		return p.saveOverlay()
+	}
+	if p.isDirectory() {
+		return p.saveDirectory()
+	}
+
+	var data, references []byte
+	var yamlDoc *yaml.Node
 	var err error
+	switch {
+	case p.fileType == csvType:
+		data, references, err = marshalCSV(p.data)
+	case p.cfg.PreserveComments && p.fileType == yml:
+		data, yamlDoc, err = p.marshalYAML()
+	default:
+		data, err = p.marshal()
+	}
+	if err != nil {
+		return err
+	}
 
+	deadline := timeout.Start(p.cfg.WriteTimeout, "file write")
+	if references != nil {
+		err = writeAtomic(referencesPath(p.cfg), references, deadline)
+	}
+	if err == nil {
+		err = writeAtomic(p.cfg.Path, data, deadline)
+	}
+	if err != nil {
+		p.log(slog.LevelError, "failed to save file", "error", err)
+		return err
+	}
+
+	if yamlDoc != nil {
+		p.yamlDoc = yamlDoc
+	}
+	p.lastFlush = time.Now()
+	return nil
+}
+
+// writeAtomic writes data to a temporary file and renames it over path. The
+// temporary file is removed instead once deadline has passed, so a Timeout
+// leaves path unchanged.
+func writeAtomic(path string, data []byte, deadline timeout.Deadline) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := deadline.Err(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// document returns what is written to json and yaml files.
+func (p *provider[K, V]) document() (any, error) {
 	var k K
-	var d any
 	if _, ok := any(k).(int); ok {
-		d = slices.Collect(maps.Values(p.data.DataMap))
-	} else {
-		d = p.data
+		return slices.Collect(maps.Values(p.data.DataMap)), nil
+	}
+	d := p.data
+	if p.signer != nil {
+		signed, err := p.sign(p.data)
+		if err != nil {
+			return nil, err
+		}
+		d = signed
+	}
+
+	codec := jsn
+	if p.fileType == yml {
+		codec = yml
+	}
+	d.Header = p.header(codec)
+	return d, nil
+}
+
+// marshalYAML encodes the data with the comments and anchors of the last
+// YAML document, and returns the document so the next write can keep them.
+func (p *provider[K, V]) marshalYAML() ([]byte, *yaml.Node, error) {
+	d, err := p.document()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	doc, err := encodeYAML(d, p.yamlDoc)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return data, doc, nil
+}
+
+func (p *provider[K, V]) marshal() ([]byte, error) {
+	if p.cfg.PreserveComments && p.fileType == yml {
+		data, _, err := p.marshalYAML()
+		return data, err
+	}
+
+	var data []byte
+	d, err := p.document()
+	if err != nil {
+		return nil, err
 	}
 
 	switch p.fileType {
@@ -200,49 +957,181 @@ func (p *provider[K, V]) saveToFile() error {
 		data, err = yaml.Marshal(d)
 	case jsn:
 		data, err = json.MarshalIndent(d, "", "  ")
+	case csvType:
+		var references []byte
+		data, references, err = marshalCSV(p.data)
+		data = append(data, references...)
+	case sqliteType:
+		data, err = json.Marshal(d)
 	default:
-		return baseErrors.New("unsupported file format")
+		return nil, baseErrors.New("unsupported file format")
 	}
 
-	if err != nil {
-		return err
+	return data, err
+}
+
+func (p *provider[K, V]) log(level slog.Level, msg string, args ...any) {
+	if p.cfg.Logger != nil {
+		p.cfg.Logger.Log(context.Background(), level, msg, append([]any{"provider", "file", "path", p.cfg.Path}, args...)...)
+	}
+}
+
+func pointerIf[T any](value T, ok bool) *T {
+	if !ok {
+		return nil
 	}
 
-	return os.WriteFile(p.cfg.Path, data, 0644)
+	return &value
 }
 
 func (p *provider[K, V]) StoreReference(reference K, key K) error {
+	if err := p.writable(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return errors.Closed
+	}
+
+	p.detach()
+
+	origin, originSet := p.referenceOrigin(reference)
+	if err := p.record(journalEntry[K, V]{Op: opStoreReference, Key: reference, Target: &key, Origin: origin, OriginSet: originSet}); err != nil {
+		return err
+	}
+
+	p.setTargets(reference, []K{key})
+	return p.persist()
+}
+
+// StoreWithReferences stores the value and points every reference to key
+// with a single write of the file.
+func (p *provider[K, V]) StoreWithReferences(key K, value V, references ...K) error {
+	if err := p.writable(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return errors.Closed
+	}
+
+	p.detach()
+
+	previous, existed := p.data.DataMap[key]
+	if err := p.record(journalEntry[K, V]{Op: opStore, Key: key, Value: &value, Previous: pointerIf(previous, existed)}); err != nil {
+		return err
+	}
+	for _, reference := range references {
+		origin, originSet := p.referenceOrigin(reference)
+		if err := p.record(journalEntry[K, V]{Op: opStoreReference, Key: reference, Target: &key, Origin: origin, OriginSet: originSet}); err != nil {
+			return err
+		}
+	}
+
+	p.data.DataMap[key] = value
+	for _, reference := range references {
+		p.setTargets(reference, []K{key})
+	}
+	return p.persist()
+}
+
+func (p *provider[K, V]) AddReference(reference K, key K) error {
+	if err := p.writable(); err != nil {
+		return err
+	}
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	if p.closed {
+		return errors.Closed
+	}
+
+	p.detach()
 
-	p.data.References[reference] = key
-	return p.saveToFile()
+	if slices.Contains(p.targets(reference), key) {
+		return nil
+	}
+
+	origin, originSet := p.referenceOrigin(reference)
+	if err := p.record(journalEntry[K, V]{Op: opAddReference, Key: reference, Target: &key, Origin: origin, OriginSet: originSet}); err != nil {
+		return err
+	}
+
+	p.addTarget(reference, key)
+	return p.persist()
 }
 
 func (p *provider[K, V]) RemoveReference(reference K) error {
+	if err := p.writable(); err != nil {
+		return err
+	}
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	if p.closed {
+		return errors.Closed
+	}
 
-	_, exists := p.data.References[reference]
-	if !exists {
+	p.detach()
+
+	origin, originSet := p.referenceOrigin(reference)
+	if origin == nil && originSet == nil {
 		return errors.NotFound
 	}
 
-	delete(p.data.References, reference)
-	return p.saveToFile()
+	if err := p.record(journalEntry[K, V]{Op: opRemoveReference, Key: reference, Origin: origin, OriginSet: originSet}); err != nil {
+		return err
+	}
+
+	p.setTargets(reference, nil)
+	return p.persist()
+}
+
+func (p *provider[K, V]) RemoveReferenceTarget(reference K, key K) error {
+	if err := p.writable(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return errors.Closed
+	}
+
+	p.detach()
+
+	if !slices.Contains(p.targets(reference), key) {
+		return errors.NotFound
+	}
+
+	origin, originSet := p.referenceOrigin(reference)
+	if err := p.record(journalEntry[K, V]{Op: opRemoveTarget, Key: reference, Target: &key, Origin: origin, OriginSet: originSet}); err != nil {
+		return err
+	}
+
+	p.removeTarget(reference, key)
+	return p.persist()
 }
 
 func (p *provider[K, V]) GetByReference(reference K) (V, error) {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
+	if p.closed {
+		var zero V
+		return zero, errors.Closed
+	}
 
-	key, exists := p.data.References[reference]
-	if !exists {
+	targets, err := p.resolve(reference, 0, map[K]bool{})
+	if err != nil {
 		var v V
-		return v, errors.NotFound
+		return v, err
 	}
 
-	value, exists := p.data.DataMap[key]
+	value, exists := p.data.DataMap[targets[0]]
 	if !exists {
 		var v V
 		return v, errors.NotFound
@@ -250,3 +1139,92 @@ func (p *provider[K, V]) GetByReference(reference K) (V, error) {
 
 	return value, nil
 }
+
+func (p *provider[K, V]) GetAllByReference(reference K) ([]V, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return nil, errors.Closed
+	}
+
+	targets, err := p.resolve(reference, 0, map[K]bool{})
+	if err != nil {
+		return nil, err
+	}
+
+	var values []V
+	for _, key := range targets {
+		if value, exists := p.data.DataMap[key]; exists {
+			values = append(values, value)
+		}
+	}
+
+	return values, nil
+}
+
+func (p *provider[K, V]) resolve(reference K, depth int, path map[K]bool) ([]K, error) {
+	maxDepth := p.cfg.MaxReferenceDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultMaxReferenceDepth
+	}
+	if path[reference] {
+		return nil, errors.NewReferenceCycle(fmt.Errorf("reference %v", reference))
+	}
+	if depth >= maxDepth {
+		return nil, errors.NewReferenceTooDeep(fmt.Errorf("reference %v exceeds depth %d", reference, maxDepth))
+	}
+
+	targets := p.targets(reference)
+	if len(targets) == 0 {
+		return nil, errors.NotFound
+	}
+
+	path[reference] = true
+	defer delete(path, reference)
+
+	var keys []K
+	for _, target := range targets {
+		if _, exists := p.data.DataMap[target]; exists || len(p.targets(target)) == 0 {
+			keys = append(keys, target)
+			continue
+		}
+
+		resolved, err := p.resolve(target, depth+1, path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, resolved...)
+	}
+
+	return keys, nil
+}
+
+func (p *provider[K, V]) targets(reference K) []K {
+	if key, exists := p.data.References[reference]; exists {
+		return []K{key}
+	}
+
+	return slices.Clone(p.data.ReferenceSets[reference])
+}
+
+func (p *provider[K, V]) setTargets(reference K, targets []K) {
+	setTargets(&p.data, reference, targets)
+}
+
+func (p *provider[K, V]) addTarget(reference K, key K) {
+	targets := p.targets(reference)
+	if !slices.Contains(targets, key) {
+		p.setTargets(reference, append(targets, key))
+	}
+}
+
+func (p *provider[K, V]) removeTarget(reference K, key K) {
+	p.setTargets(reference, slices.DeleteFunc(p.targets(reference), func(k K) bool {
+		return k == key
+	}))
+}
+
+func (p *provider[K, V]) referenceOrigin(reference K) (*K, []K) {
+	origin, exists := p.data.References[reference]
+	return pointerIf(origin, exists), slices.Clone(p.data.ReferenceSets[reference])
+}
@@ -0,0 +1,261 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/rlshukhov/storage/errors"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS data (
+	key   TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+) WITHOUT ROWID;
+CREATE TABLE IF NOT EXISTS refs (
+	id        INTEGER PRIMARY KEY,
+	reference TEXT NOT NULL,
+	key       TEXT NOT NULL,
+	UNIQUE (reference, key)
+);
+`
+
+func openSQLite(path string, readOnly bool) (*sql.DB, error) {
+	query := url.Values{}
+	query.Add("_pragma", "busy_timeout(5000)")
+	if readOnly {
+		query.Set("mode", "ro")
+	} else {
+		query.Add("_pragma", "journal_mode(WAL)")
+		query.Add("_pragma", "synchronous(NORMAL)")
+	}
+
+	escaped := strings.NewReplacer("%", "%25", "?", "%3F", "#", "%23").Replace(filepath.ToSlash(path))
+	db, err := sql.Open("sqlite", "file:"+escaped+"?"+query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	if !readOnly {
+		if _, err := db.Exec(sqliteSchema); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return db, nil
+}
+
+func (p *provider[K, V]) openSQLite() error {
+	if _, err := os.Stat(p.cfg.Path); errors.Is(err, os.ErrNotExist) && p.cfg.ReadOnly {
+		return nil
+	}
+
+	db, err := openSQLite(p.cfg.Path, p.cfg.ReadOnly)
+	if err != nil {
+		return err
+	}
+
+	if err := loadSQLite(db, &p.data); err != nil {
+		db.Close()
+		return err
+	}
+
+	p.db = db
+	return nil
+}
+
+func loadSQLite[K comparable, V any](db *sql.DB, d *data[K, V]) error {
+	rows, err := db.Query("SELECT key, value FROM data")
+	if err != nil {
+		return errors.NewCorrupted(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rawKey, rawValue string
+		if err := rows.Scan(&rawKey, &rawValue); err != nil {
+			return err
+		}
+
+		key, err := parseKey[K](rawKey)
+		if err != nil {
+			return err
+		}
+		var value V
+		if err := json.Unmarshal([]byte(rawValue), &value); err != nil {
+			return errors.NewCorrupted(fmt.Errorf("key %q: %w", rawKey, err))
+		}
+		if d.DataMap == nil {
+			d.DataMap = map[K]V{}
+		}
+		d.DataMap[key] = value
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	refs, err := db.Query("SELECT reference, key FROM refs ORDER BY id")
+	if err != nil {
+		return errors.NewCorrupted(err)
+	}
+	defer refs.Close()
+
+	for refs.Next() {
+		var rawReference, rawKey string
+		if err := refs.Scan(&rawReference, &rawKey); err != nil {
+			return err
+		}
+
+		reference, err := parseKey[K](rawReference)
+		if err != nil {
+			return err
+		}
+		key, err := parseKey[K](rawKey)
+		if err != nil {
+			return err
+		}
+		addTarget(d, reference, key)
+	}
+
+	return refs.Err()
+}
+
+func (p *provider[K, V]) applyBatch() error {
+	if len(p.batch) == 0 {
+		return nil
+	}
+
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, entry := range p.batch {
+		if err := applyEntry(tx, entry); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	p.batch = p.batch[:0]
+	return nil
+}
+
+func applyEntry[K comparable, V any](tx *sql.Tx, entry journalEntry[K, V]) error {
+	key := keyString(entry.Key)
+
+	var err error
+	switch entry.Op {
+	case opStore:
+		var value []byte
+		if value, err = json.Marshal(entry.Value); err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO data (key, value) VALUES (?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value", key, string(value))
+	case opRemove:
+		_, err = tx.Exec("DELETE FROM data WHERE key = ?", key)
+	case opStoreReference:
+		if _, err = tx.Exec("DELETE FROM refs WHERE reference = ?", key); err == nil {
+			_, err = tx.Exec("INSERT INTO refs (reference, key) VALUES (?, ?)", key, keyString(*entry.Target))
+		}
+	case opAddReference:
+		_, err = tx.Exec("INSERT OR IGNORE INTO refs (reference, key) VALUES (?, ?)", key, keyString(*entry.Target))
+	case opRemoveReference:
+		_, err = tx.Exec("DELETE FROM refs WHERE reference = ?", key)
+	case opRemoveTarget:
+		_, err = tx.Exec("DELETE FROM refs WHERE reference = ? AND key = ?", key, keyString(*entry.Target))
+	}
+
+	return err
+}
+
+func (p *provider[K, V]) checkpointSQLite() error {
+	if p.db == nil {
+		return nil
+	}
+	if err := p.applyBatch(); err != nil {
+		return err
+	}
+
+	_, err := p.db.Exec("PRAGMA wal_checkpoint(TRUNCATE)")
+	return err
+}
+
+func (p *provider[K, V]) closeSQLite() error {
+	if p.db == nil {
+		return nil
+	}
+
+	err := p.db.Close()
+	p.db = nil
+	return err
+}
+
+func (p *provider[K, V]) verifySQLite() error {
+	db := p.db
+	if db == nil {
+		if _, err := os.Stat(p.cfg.Path); errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		var err error
+		if db, err = openSQLite(p.cfg.Path, true); err != nil {
+			return err
+		}
+		defer db.Close()
+	}
+
+	var result string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return errors.NewCorrupted(err)
+	}
+	if result != "ok" {
+		return errors.NewCorrupted(fmt.Errorf("integrity check: %s", result))
+	}
+
+	return loadSQLite(db, &data[K, V]{})
+}
+
+func (p *provider[K, V]) backupSQLite(w io.Writer) error {
+	if p.db == nil {
+		return errors.NewClosed(fmt.Errorf("sqlite database %q is not open", p.cfg.Path))
+	}
+
+	dir, err := os.MkdirTemp("", "storage-sqlite-backup-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "backup.db")
+	if _, err := p.db.Exec("VACUUM INTO ?", path); err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,16 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !(js && wasm) && !nosqlite
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+// The sqlite driver does not build for js/wasm and is left out of builds with
+// -tags nosqlite, where sqlite files fail to open with an unknown driver
+// error.
+import _ "modernc.org/sqlite"
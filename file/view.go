@@ -0,0 +1,149 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import (
+	"github.com/rlshukhov/storage/errors"
+	"github.com/rlshukhov/storage/kv"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// readView reads the map the provider held when it was opened. The
+// provider copies its map before the next write instead, so opening a view
+// is cheap and reads through it never see a write made after it.
+type readView[K comparable, V any] struct {
+	mu     sync.RWMutex
+	data   map[K]V
+	closed bool
+}
+
+// BeginRead returns a view of the data as it is now, so several reads are
+// not interleaved with concurrent writes. Close releases it.
+func (p *provider[K, V]) BeginRead() (*readView[K, V], error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, errors.Closed
+	}
+
+	p.shared = true
+	return &readView[K, V]{data: p.data.DataMap}, nil
+}
+
+func (p *provider[K, V]) OpenSnapshot() (kv.Snapshot[K, V], error) {
+	return p.BeginRead()
+}
+
+// detach gives the provider its own copy of the map that open views share.
+// It is called with the write lock held before anything is changed.
+func (p *provider[K, V]) detach() {
+	if !p.shared {
+		return
+	}
+
+	p.data.DataMap = maps.Clone(p.data.DataMap)
+	p.shared = false
+}
+
+func (v *readView[K, V]) Get(key K) (V, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	var value V
+	if v.closed {
+		return value, errors.Closed
+	}
+	value, exists := v.data[key]
+	if !exists {
+		return value, errors.NotFound
+	}
+
+	return value, nil
+}
+
+func (v *readView[K, V]) GetMultiple(keys []K) ([]V, error) {
+	var values []V
+	for _, key := range keys {
+		value, err := v.Get(key)
+		if err != nil {
+			return []V{}, err
+		}
+
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+func (v *readView[K, V]) ForEach(fn func(key K, value V) bool) error {
+	data, err := v.snapshot()
+	if err != nil {
+		return err
+	}
+
+	for k, value := range data {
+		if !fn(k, value) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (v *readView[K, V]) ForEachPrefix(prefix K, fn func(key K, value V) bool) error {
+	data, err := v.snapshot()
+	if err != nil {
+		return err
+	}
+
+	pr := keyString(prefix)
+	var keys []K
+	for k := range data {
+		if strings.HasPrefix(keyString(k), pr) {
+			keys = append(keys, k)
+		}
+	}
+
+	slices.SortFunc(keys, func(a, b K) int {
+		return strings.Compare(keyString(a), keyString(b))
+	})
+
+	for _, k := range keys {
+		if !fn(k, data[k]) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// Close releases the view. The maps it held are freed once the provider has
+// copied them.
+func (v *readView[K, V]) Close() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.data, v.closed = nil, true
+	return nil
+}
+
+func (v *readView[K, V]) snapshot() (map[K]V, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.closed {
+		return nil, errors.Closed
+	}
+
+	return v.data, nil
+}
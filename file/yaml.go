@@ -0,0 +1,163 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package file
+
+import (
+	"bytes"
+	"github.com/rlshukhov/storage/errors"
+	"gopkg.in/yaml.v3"
+	"slices"
+)
+
+func parseYAMLDocument(raw []byte) (*yaml.Node, error) {
+	if len(bytes.TrimSpace(raw)) == 0 {
+		return nil, nil
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return nil, errors.NewCorrupted(err)
+	}
+
+	return &doc, nil
+}
+
+// encodeYAML encodes v and carries the comments, anchors and styles of old
+// over to the parts that match it. Subtrees that did not change are taken
+// from old as they are. old is never modified.
+func encodeYAML(v any, old *yaml.Node) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := doc.Encode(v); err != nil {
+		return nil, err
+	}
+
+	result := &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{&doc}}
+	if old != nil && old.Kind == yaml.DocumentNode && len(old.Content) == 1 {
+		result.HeadComment = old.HeadComment
+		result.LineComment = old.LineComment
+		result.FootComment = old.FootComment
+		result.Content[0] = mergeYAML(old.Content[0], &doc)
+	}
+
+	return resolveDanglingAliases(result, map[*yaml.Node]bool{}), nil
+}
+
+func mergeYAML(old, node *yaml.Node) *yaml.Node {
+	if equalYAML(old, node) {
+		return old
+	}
+
+	merged := *node
+	merged.HeadComment = old.HeadComment
+	merged.LineComment = old.LineComment
+	merged.FootComment = old.FootComment
+	old = resolveAlias(old)
+	if old.Kind != node.Kind {
+		return &merged
+	}
+	if old.Kind == yaml.ScalarNode && old.Tag == node.Tag {
+		merged.Style = old.Style
+	}
+
+	merged.Content = make([]*yaml.Node, len(node.Content))
+	switch node.Kind {
+	case yaml.MappingNode:
+		keys := map[string]int{}
+		for i := 0; i+1 < len(old.Content); i += 2 {
+			keys[old.Content[i].Value] = i
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			j, ok := keys[node.Content[i].Value]
+			if !ok {
+				merged.Content[i], merged.Content[i+1] = node.Content[i], node.Content[i+1]
+				continue
+			}
+			merged.Content[i] = mergeYAML(old.Content[j], node.Content[i])
+			merged.Content[i+1] = mergeYAML(old.Content[j+1], node.Content[i+1])
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			if i < len(old.Content) {
+				item = mergeYAML(old.Content[i], item)
+			}
+			merged.Content[i] = item
+		}
+	default:
+		copy(merged.Content, node.Content)
+	}
+
+	return &merged
+}
+
+// equalYAML reports whether a and b hold the same data, following aliases.
+func equalYAML(a, b *yaml.Node) bool {
+	a, b = resolveAlias(a), resolveAlias(b)
+	if a.Kind != b.Kind || a.ShortTag() != b.ShortTag() || a.Value != b.Value || len(a.Content) != len(b.Content) {
+		return false
+	}
+	for i := range a.Content {
+		if !equalYAML(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func resolveAlias(n *yaml.Node) *yaml.Node {
+	for n.Kind == yaml.AliasNode && n.Alias != nil {
+		n = n.Alias
+	}
+
+	return n
+}
+
+// resolveDanglingAliases returns n with the aliases whose anchor was dropped,
+// or does not come before them any more, replaced by a copy of the aliased
+// node. Changed nodes are copied, so n is not modified.
+func resolveDanglingAliases(n *yaml.Node, anchors map[*yaml.Node]bool) *yaml.Node {
+	if n.Kind == yaml.AliasNode && !anchors[n.Alias] {
+		inlined := *resolveAlias(n)
+		inlined.Anchor = ""
+		inlined.HeadComment = n.HeadComment
+		inlined.LineComment = n.LineComment
+		inlined.FootComment = n.FootComment
+		n = &inlined
+	}
+	if n.Anchor != "" {
+		anchors[n] = true
+	}
+
+	var content []*yaml.Node
+	for i, child := range n.Content {
+		resolved := resolveDanglingAliases(child, anchors)
+		if resolved == child {
+			continue
+		}
+		if content == nil {
+			content = slices.Clone(n.Content)
+		}
+		content[i] = resolved
+
+		// A block collection takes its line comment from the key, as the
+		// parser puts it there.
+		if n.Kind == yaml.MappingNode && i%2 == 1 && resolved.Kind != yaml.ScalarNode && resolved.LineComment != "" && content[i-1].LineComment == "" {
+			key, value := *content[i-1], *resolved
+			key.LineComment, value.LineComment = value.LineComment, ""
+			content[i-1], content[i] = &key, &value
+		}
+	}
+	if content == nil {
+		return n
+	}
+
+	resolved := *n
+	resolved.Content = content
+	return &resolved
+}
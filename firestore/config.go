@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package firestore
+
+import (
+	"errors"
+	"fmt"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage/kv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	ProjectID       string `yaml:"project_id"`
+	DatabaseID      string `yaml:"database_id,omitempty"`
+	CredentialsFile string `yaml:"credentials_file,omitempty"`
+
+	// TLS replaces the TLS settings of the gRPC connection, e.g. to trust
+	// the CA of a proxy in front of Firestore.
+	TLS nullable.Nullable[kv.TLSConfig] `yaml:"tls"`
+
+	Collection           string `yaml:"collection,omitempty"`
+	ReferencesCollection string `yaml:"references_collection,omitempty"`
+	KeyField             string `yaml:"key_field,omitempty"`
+	ValueField           string `yaml:"value_field,omitempty"`
+	ValueFormat          string `yaml:"value_format,omitempty"`
+	PageSize             int    `yaml:"page_size,omitempty"`
+	ReadOnly             bool   `yaml:"read_only,omitempty"`
+
+	MaxReferenceDepth  int           `yaml:"max_reference_depth,omitempty"`
+	TransactionTimeout time.Duration `yaml:"transaction_timeout,omitempty"`
+}
+
+const (
+	FormatBytes  = "bytes"
+	FormatNative = "native"
+
+	defaultCollection        = "storage"
+	defaultKeyField          = "key"
+	defaultValueField        = "value"
+	defaultPageSize          = 300
+	defaultMaxReferenceDepth = 8
+
+	referenceField = "reference"
+	targetsField   = "targets"
+	countAggregate = "count"
+	hashedIDPrefix = "~"
+	maxDocumentID  = 1500
+	maxPageSize    = 10000
+)
+
+func (c Config) Validate() error {
+	var errs []error
+	if c.ProjectID == "" {
+		errs = append(errs, errors.New("project_id is required"))
+	}
+	if c.Collection != "" && !validCollection(c.Collection) {
+		errs = append(errs, errors.New("collection must be a slash-separated path with an odd number of segments"))
+	}
+	if c.ReferencesCollection != "" && !validCollection(c.ReferencesCollection) {
+		errs = append(errs, errors.New("references_collection must be a slash-separated path with an odd number of segments"))
+	}
+	if c.ReferencesCollection != "" && c.ReferencesCollection == c.Collection {
+		errs = append(errs, errors.New("references_collection must differ from collection"))
+	}
+	if c.KeyField != "" && c.KeyField == c.ValueField {
+		errs = append(errs, errors.New("key_field and value_field must differ"))
+	}
+	if c.ValueFormat != "" && c.ValueFormat != FormatBytes && c.ValueFormat != FormatNative {
+		errs = append(errs, fmt.Errorf("value_format must be %q or %q", FormatBytes, FormatNative))
+	}
+	if c.PageSize < 0 || c.PageSize > maxPageSize {
+		errs = append(errs, fmt.Errorf("page_size must be between 0 and %d", maxPageSize))
+	}
+	if c.TLS.HasValue() {
+		if err := c.TLS.GetValue().Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.MaxReferenceDepth < 0 {
+		errs = append(errs, errors.New("max_reference_depth must not be negative"))
+	}
+	if c.TransactionTimeout < 0 {
+		errs = append(errs, errors.New("transaction_timeout must not be negative"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func validCollection(path string) bool {
+	segments := strings.Split(path, "/")
+	if len(segments)%2 == 0 {
+		return false
+	}
+	for _, segment := range segments {
+		if segment == "" {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,56 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package firestore
+
+import (
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage/kv"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	valid := map[string]Config{
+		"project": {ProjectID: "app"},
+		"full": {
+			ProjectID:            "app",
+			DatabaseID:           "kv",
+			Collection:           "tenants/acme/kv",
+			ReferencesCollection: "tenants/acme/refs",
+			KeyField:             "k",
+			ValueField:           "v",
+			ValueFormat:          FormatNative,
+			PageSize:             maxPageSize,
+			MaxReferenceDepth:    4,
+			TransactionTimeout:   time.Second,
+		},
+	}
+	for name, cfg := range valid {
+		assert.NoError(t, cfg.Validate(), name)
+	}
+
+	invalid := map[string]Config{
+		"no project":           {},
+		"collection":           {ProjectID: "app", Collection: "tenants/acme"},
+		"empty segment":        {ProjectID: "app", Collection: "tenants//kv"},
+		"references":           {ProjectID: "app", ReferencesCollection: "/refs"},
+		"same collections":     {ProjectID: "app", Collection: "kv", ReferencesCollection: "kv"},
+		"same fields":          {ProjectID: "app", KeyField: "data", ValueField: "data"},
+		"value format":         {ProjectID: "app", ValueFormat: "xml"},
+		"negative page size":   {ProjectID: "app", PageSize: -1},
+		"page size":            {ProjectID: "app", PageSize: maxPageSize + 1},
+		"tls":                  {ProjectID: "app", TLS: nullable.FromValue(kv.TLSConfig{CertFile: "cert.pem"})},
+		"negative depth":       {ProjectID: "app", MaxReferenceDepth: -1},
+		"negative transaction": {ProjectID: "app", TransactionTimeout: -time.Second},
+	}
+	for name, cfg := range invalid {
+		assert.Error(t, cfg.Validate(), name)
+	}
+}
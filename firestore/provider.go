@@ -0,0 +1,963 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !nofirestore
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package firestore
+
+import (
+	"bytes"
+	gcp "cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"github.com/rlshukhov/storage/kv"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+type provider[K ~string | ~uint64, V any] struct {
+	cfg       Config
+	lastWrite atomic.Int64
+
+	mu     sync.RWMutex
+	client *gcp.Client
+}
+
+func New[K ~string | ~uint64, V any](cfg Config) (*provider[K, V], error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.DatabaseID == "" {
+		cfg.DatabaseID = gcp.DefaultDatabaseID
+	}
+	if cfg.Collection == "" {
+		cfg.Collection = defaultCollection
+	}
+	if cfg.ReferencesCollection == "" {
+		cfg.ReferencesCollection = cfg.Collection + "_references"
+	}
+	if cfg.KeyField == "" {
+		cfg.KeyField = defaultKeyField
+	}
+	if cfg.ValueField == "" {
+		cfg.ValueField = defaultValueField
+	}
+	if cfg.ValueFormat == "" {
+		cfg.ValueFormat = FormatBytes
+	}
+	if cfg.PageSize == 0 {
+		cfg.PageSize = defaultPageSize
+	}
+	if cfg.MaxReferenceDepth == 0 {
+		cfg.MaxReferenceDepth = defaultMaxReferenceDepth
+	}
+
+	return &provider[K, V]{cfg: cfg}, nil
+}
+
+func (p *provider[K, V]) Setup() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.client != nil {
+		return nil
+	}
+
+	var opts []option.ClientOption
+	if p.cfg.CredentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(p.cfg.CredentialsFile))
+	}
+	if p.cfg.TLS.HasValue() {
+		config, err := p.cfg.TLS.GetValue().Client()
+		if err != nil {
+			return err
+		}
+		opts = append(opts, option.WithGRPCDialOption(grpc.WithTransportCredentials(credentials.NewTLS(config))))
+	}
+
+	ctx, cancel := p.context()
+	defer cancel()
+
+	client, err := gcp.NewClientWithDatabase(ctx, p.cfg.ProjectID, p.cfg.DatabaseID, opts...)
+	if err != nil {
+		return mapError(err)
+	}
+
+	p.client = client
+	return nil
+}
+
+func (p *provider[K, V]) Shutdown() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.client == nil {
+		return nil
+	}
+
+	err := p.client.Close()
+	p.client = nil
+	return err
+}
+
+func (p *provider[K, V]) Close() error {
+	return p.Shutdown()
+}
+
+func (p *provider[K, V]) Ping(ctx context.Context) error {
+	client, err := p.conn()
+	if err != nil {
+		return err
+	}
+
+	it := client.Collection(p.cfg.Collection).Limit(1).Documents(ctx)
+	defer it.Stop()
+
+	if _, err := it.Next(); err != nil && !errors.Is(err, iterator.Done) {
+		return mapError(err)
+	}
+
+	return nil
+}
+
+func (p *provider[K, V]) Stats() (kv.ProviderStats, error) {
+	stats := kv.ProviderStats{Provider: "firestore"}
+
+	client, err := p.conn()
+	if err != nil {
+		return stats, err
+	}
+
+	if stats.Entries, err = p.count(client.Collection(p.cfg.Collection)); err != nil {
+		return stats, err
+	}
+	if stats.References, err = p.count(client.Collection(p.cfg.ReferencesCollection)); err != nil {
+		return stats, err
+	}
+
+	if lastWrite := p.lastWrite.Load(); lastWrite > 0 {
+		stats.LastFlush = time.Unix(0, lastWrite)
+	}
+	stats.Backend = map[string]any{
+		"project_id":            p.cfg.ProjectID,
+		"database_id":           p.cfg.DatabaseID,
+		"collection":            p.cfg.Collection,
+		"references_collection": p.cfg.ReferencesCollection,
+		"value_format":          p.cfg.ValueFormat,
+	}
+
+	return stats, nil
+}
+
+func (p *provider[K, V]) count(collection *gcp.CollectionRef) (uint64, error) {
+	ctx, cancel := p.context()
+	defer cancel()
+
+	result, err := collection.NewAggregationQuery().WithCount(countAggregate).Get(ctx)
+	if err != nil {
+		return 0, mapError(err)
+	}
+
+	value, ok := result[countAggregate].(*firestorepb.Value)
+	if !ok {
+		return 0, fmt.Errorf("unexpected count aggregation result %T", result[countAggregate])
+	}
+
+	return uint64(value.GetIntegerValue()), nil
+}
+
+func (p *provider[K, V]) ApproximateSize() (uint64, error) {
+	return 0, storageErrors.NewUnsupported(errors.New("firestore does not report storage size"))
+}
+
+func (p *provider[K, V]) Store(key K, value V) error {
+	data, err := p.document(key, value)
+	if err != nil {
+		return err
+	}
+
+	return p.write(func(ctx context.Context, client *gcp.Client) error {
+		_, err := p.dataDoc(client, key).Set(ctx, data)
+		return err
+	})
+}
+
+func (p *provider[K, V]) Update(key K, fn func(value V, exists bool) (V, error)) error {
+	return p.transaction(func(ctx context.Context, client *gcp.Client, tx *gcp.Transaction) error {
+		doc := p.dataDoc(client, key)
+
+		var value V
+		snap, err := tx.Get(doc)
+		exists := err == nil
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if exists {
+			if value, err = p.decode(snap); err != nil {
+				return err
+			}
+		}
+
+		value, err = fn(value, exists)
+		if err != nil {
+			return err
+		}
+
+		data, err := p.document(key, value)
+		if err != nil {
+			return err
+		}
+
+		return tx.Set(doc, data)
+	})
+}
+
+func (p *provider[K, V]) Get(key K) (V, error) {
+	var value V
+
+	client, err := p.conn()
+	if err != nil {
+		return value, err
+	}
+
+	ctx, cancel := p.context()
+	defer cancel()
+
+	snap, err := p.dataDoc(client, key).Get(ctx)
+	if err != nil {
+		return value, mapError(err)
+	}
+
+	return p.decode(snap)
+}
+
+func (p *provider[K, V]) GetMultiple(keys []K) ([]V, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	client, err := p.conn()
+	if err != nil {
+		return []V{}, err
+	}
+
+	docs := make([]*gcp.DocumentRef, len(keys))
+	for i, key := range keys {
+		docs[i] = p.dataDoc(client, key)
+	}
+
+	ctx, cancel := p.context()
+	defer cancel()
+
+	snaps, err := client.GetAll(ctx, docs)
+	if err != nil {
+		return []V{}, mapError(err)
+	}
+
+	values := make([]V, 0, len(keys))
+	for i, snap := range snaps {
+		if !snap.Exists() {
+			return []V{}, storageErrors.NewNotFound(fmt.Errorf("key %v", keys[i]))
+		}
+
+		v, err := p.decode(snap)
+		if err != nil {
+			return []V{}, err
+		}
+		values = append(values, v)
+	}
+
+	return values, nil
+}
+
+func (p *provider[K, V]) Remove(key K) error {
+	return p.write(func(ctx context.Context, client *gcp.Client) error {
+		_, err := p.dataDoc(client, key).Delete(ctx)
+		return err
+	})
+}
+
+func (p *provider[K, V]) RemovePrefix(prefix K) (int, error) {
+	return p.removeWhere(keyString(prefix), func(*gcp.DocumentSnapshot) (bool, error) {
+		return true, nil
+	})
+}
+
+func (p *provider[K, V]) RemoveWhere(pred func(key K, value V) bool) (int, error) {
+	return p.removeWhere("", func(snap *gcp.DocumentSnapshot) (bool, error) {
+		key, value, err := p.entry(snap)
+		if err != nil {
+			return false, err
+		}
+
+		return pred(key, value), nil
+	})
+}
+
+func (p *provider[K, V]) Clear() error {
+	all := func(*gcp.DocumentSnapshot) (bool, error) {
+		return true, nil
+	}
+
+	if _, err := p.removeWhere("", all); err != nil {
+		return err
+	}
+
+	return p.deleteWhere(p.cfg.ReferencesCollection, referenceField, "", all)
+}
+
+func (p *provider[K, V]) removeWhere(prefix string, fn func(snap *gcp.DocumentSnapshot) (bool, error)) (int, error) {
+	removed := 0
+	err := p.deleteWhere(p.cfg.Collection, p.cfg.KeyField, prefix, func(snap *gcp.DocumentSnapshot) (bool, error) {
+		remove, err := fn(snap)
+		if remove {
+			removed++
+		}
+		return remove, err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+func (p *provider[K, V]) deleteWhere(collection, field, prefix string, fn func(snap *gcp.DocumentSnapshot) (bool, error)) error {
+	if p.cfg.ReadOnly {
+		return storageErrors.ReadOnly
+	}
+
+	var docs []*gcp.DocumentRef
+	err := p.scan(collection, field, prefix, func(snap *gcp.DocumentSnapshot) (bool, error) {
+		remove, err := fn(snap)
+		if remove {
+			docs = append(docs, snap.Ref)
+		}
+		return err == nil, err
+	})
+	if err != nil || len(docs) == 0 {
+		return err
+	}
+
+	return p.write(func(ctx context.Context, client *gcp.Client) error {
+		writer := client.BulkWriter(ctx)
+
+		jobs := make([]*gcp.BulkWriterJob, 0, len(docs))
+		for _, doc := range docs {
+			job, err := writer.Delete(doc)
+			if err != nil {
+				writer.End()
+				return err
+			}
+			jobs = append(jobs, job)
+		}
+		writer.End()
+
+		var errs []error
+		for _, job := range jobs {
+			if _, err := job.Results(); err != nil && status.Code(err) != codes.NotFound {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	})
+}
+
+func (p *provider[K, V]) ForEach(fn func(key K, value V) bool) error {
+	return p.iterate("", fn)
+}
+
+func (p *provider[K, V]) ForEachPrefix(prefix K, fn func(key K, value V) bool) error {
+	return p.iterate(keyString(prefix), fn)
+}
+
+func (p *provider[K, V]) iterate(prefix string, fn func(key K, value V) bool) error {
+	return p.scan(p.cfg.Collection, p.cfg.KeyField, prefix, func(snap *gcp.DocumentSnapshot) (bool, error) {
+		key, value, err := p.entry(snap)
+		if err != nil {
+			return false, err
+		}
+
+		return fn(key, value), nil
+	})
+}
+
+func (p *provider[K, V]) ForEachKey(fn func(key K) bool) error {
+	return p.scan(p.cfg.Collection, p.cfg.KeyField, "", func(snap *gcp.DocumentSnapshot) (bool, error) {
+		key, err := p.field(snap, p.cfg.KeyField)
+		if err != nil {
+			return false, err
+		}
+
+		return fn(key), nil
+	})
+}
+
+func (p *provider[K, V]) ForEachReference(fn func(reference K, key K) bool) error {
+	return p.scan(p.cfg.ReferencesCollection, referenceField, "", func(snap *gcp.DocumentSnapshot) (bool, error) {
+		reference, err := p.field(snap, referenceField)
+		if err != nil {
+			return false, err
+		}
+		targets, err := p.targetsOf(snap)
+		if err != nil {
+			return false, err
+		}
+
+		for _, key := range targets {
+			if !fn(reference, key) {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+}
+
+func (p *provider[K, V]) scan(collection, field, prefix string, fn func(snap *gcp.DocumentSnapshot) (bool, error)) error {
+	client, err := p.conn()
+	if err != nil {
+		return err
+	}
+
+	query := client.Collection(collection).OrderBy(field, gcp.Asc).Limit(p.cfg.PageSize)
+	if prefix != "" {
+		query = query.Where(field, ">=", prefix)
+		if limit, ok := prefixLimit(prefix); ok {
+			query = query.Where(field, "<", limit)
+		}
+	}
+
+	var last any
+	for {
+		page := query
+		if last != nil {
+			page = page.StartAfter(last)
+		}
+
+		next, n, err := p.page(page, field, &last, fn)
+		if err != nil || !next || n < p.cfg.PageSize {
+			return err
+		}
+	}
+}
+
+func (p *provider[K, V]) page(query gcp.Query, field string, last *any, fn func(snap *gcp.DocumentSnapshot) (bool, error)) (bool, int, error) {
+	ctx, cancel := p.context()
+	defer cancel()
+
+	it := query.Documents(ctx)
+	defer it.Stop()
+
+	n := 0
+	for {
+		snap, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return true, n, nil
+		}
+		if err != nil {
+			return false, n, mapError(err)
+		}
+
+		n++
+		if *last, err = snap.DataAt(field); err != nil {
+			return false, n, storageErrors.NewCorrupted(err)
+		}
+
+		next, err := fn(snap)
+		if err != nil || !next {
+			return false, n, err
+		}
+	}
+}
+
+func (p *provider[K, V]) StoreReference(reference K, key K) error {
+	return p.write(func(ctx context.Context, client *gcp.Client) error {
+		_, err := p.referenceDoc(client, reference).Set(ctx, map[string]any{
+			referenceField: keyString(reference),
+			targetsField:   []string{keyString(key)},
+		})
+		return err
+	})
+}
+
+func (p *provider[K, V]) AddReference(reference K, key K) error {
+	return p.write(func(ctx context.Context, client *gcp.Client) error {
+		_, err := p.referenceDoc(client, reference).Set(ctx, map[string]any{
+			referenceField: keyString(reference),
+			targetsField:   gcp.ArrayUnion(keyString(key)),
+		}, gcp.MergeAll)
+		return err
+	})
+}
+
+func (p *provider[K, V]) RemoveReference(reference K) error {
+	return p.write(func(ctx context.Context, client *gcp.Client) error {
+		_, err := p.referenceDoc(client, reference).Delete(ctx)
+		return err
+	})
+}
+
+func (p *provider[K, V]) RemoveReferenceTarget(reference K, key K) error {
+	return p.transaction(func(ctx context.Context, client *gcp.Client, tx *gcp.Transaction) error {
+		doc := p.referenceDoc(client, reference)
+
+		snap, err := tx.Get(doc)
+		if err != nil {
+			return err
+		}
+		targets, err := p.targetsOf(snap)
+		if err != nil {
+			return err
+		}
+
+		remaining := make([]string, 0, len(targets))
+		for _, target := range targets {
+			if target != key {
+				remaining = append(remaining, keyString(target))
+			}
+		}
+		if len(remaining) == len(targets) {
+			return storageErrors.NewNotFound(fmt.Errorf("reference %v does not point to %v", reference, key))
+		}
+		if len(remaining) == 0 {
+			return tx.Delete(doc)
+		}
+
+		return tx.Set(doc, map[string]any{
+			referenceField: keyString(reference),
+			targetsField:   remaining,
+		})
+	})
+}
+
+func (p *provider[K, V]) GetByReference(reference K) (V, error) {
+	keys, err := p.resolve(reference, 0, map[K]bool{})
+	if err != nil {
+		var v V
+		return v, err
+	}
+
+	return p.Get(keys[0])
+}
+
+func (p *provider[K, V]) GetAllByReference(reference K) ([]V, error) {
+	keys, err := p.resolve(reference, 0, map[K]bool{})
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := p.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make([]*gcp.DocumentRef, len(keys))
+	for i, key := range keys {
+		docs[i] = p.dataDoc(client, key)
+	}
+
+	ctx, cancel := p.context()
+	defer cancel()
+
+	snaps, err := client.GetAll(ctx, docs)
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	var values []V
+	for _, snap := range snaps {
+		if !snap.Exists() {
+			continue
+		}
+
+		v, err := p.decode(snap)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+
+	return values, nil
+}
+
+func (p *provider[K, V]) resolve(reference K, depth int, path map[K]bool) ([]K, error) {
+	if path[reference] {
+		return nil, storageErrors.NewReferenceCycle(fmt.Errorf("reference %v", reference))
+	}
+	if depth >= p.cfg.MaxReferenceDepth {
+		return nil, storageErrors.NewReferenceTooDeep(fmt.Errorf("reference %v exceeds depth %d", reference, p.cfg.MaxReferenceDepth))
+	}
+
+	targets, err := p.targets(reference)
+	if err != nil {
+		return nil, err
+	}
+
+	path[reference] = true
+	defer delete(path, reference)
+
+	var keys []K
+	for _, target := range targets {
+		if _, err := p.targets(target); storageErrors.Is(err, storageErrors.NotFound) {
+			keys = append(keys, target)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		resolved, err := p.resolve(target, depth+1, path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, resolved...)
+	}
+
+	return keys, nil
+}
+
+func (p *provider[K, V]) targets(reference K) ([]K, error) {
+	client, err := p.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := p.context()
+	defer cancel()
+
+	snap, err := p.referenceDoc(client, reference).Get(ctx)
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	return p.targetsOf(snap)
+}
+
+func (p *provider[K, V]) targetsOf(snap *gcp.DocumentSnapshot) ([]K, error) {
+	raw, err := snap.DataAt(targetsField)
+	if err != nil {
+		return nil, storageErrors.NewCorrupted(err)
+	}
+
+	values, ok := raw.([]any)
+	if !ok || len(values) == 0 {
+		return nil, storageErrors.NewCorrupted(fmt.Errorf("reference %s has no targets", snap.Ref.ID))
+	}
+
+	targets := make([]K, 0, len(values))
+	for _, value := range values {
+		s, ok := value.(string)
+		if !ok {
+			return nil, storageErrors.NewCorrupted(fmt.Errorf("reference %s has a non-string target", snap.Ref.ID))
+		}
+
+		key, err := parseKey[K](s)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, key)
+	}
+
+	return targets, nil
+}
+
+func (p *provider[K, V]) Verify() error {
+	var errs []error
+	err := p.scan(p.cfg.Collection, p.cfg.KeyField, "", func(snap *gcp.DocumentSnapshot) (bool, error) {
+		if _, _, err := p.entry(snap); err != nil {
+			errs = append(errs, fmt.Errorf("document %q: %w", snap.Ref.ID, err))
+		}
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = p.scan(p.cfg.ReferencesCollection, referenceField, "", func(snap *gcp.DocumentSnapshot) (bool, error) {
+		if _, err := p.targetsOf(snap); err != nil {
+			errs = append(errs, fmt.Errorf("reference document %q: %w", snap.Ref.ID, err))
+		}
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return errors.Join(errs...)
+}
+
+type backupRecord[K ~string | ~uint64, V any] struct {
+	Key       K   `json:"key"`
+	Value     *V  `json:"value,omitempty"`
+	Reference *K  `json:"reference,omitempty"`
+	Targets   []K `json:"targets,omitempty"`
+}
+
+func (p *provider[K, V]) Backup(w io.Writer) error {
+	enc := json.NewEncoder(w)
+
+	err := p.iterate("", func(key K, value V) bool {
+		return enc.Encode(backupRecord[K, V]{Key: key, Value: &value}) == nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return p.scan(p.cfg.ReferencesCollection, referenceField, "", func(snap *gcp.DocumentSnapshot) (bool, error) {
+		reference, err := p.field(snap, referenceField)
+		if err != nil {
+			return false, err
+		}
+		targets, err := p.targetsOf(snap)
+		if err != nil {
+			return false, err
+		}
+
+		return true, enc.Encode(backupRecord[K, V]{Reference: &reference, Targets: targets})
+	})
+}
+
+func (p *provider[K, V]) entry(snap *gcp.DocumentSnapshot) (K, V, error) {
+	var value V
+
+	key, err := p.field(snap, p.cfg.KeyField)
+	if err != nil {
+		return key, value, err
+	}
+
+	value, err = p.decode(snap)
+	return key, value, err
+}
+
+func (p *provider[K, V]) field(snap *gcp.DocumentSnapshot, field string) (K, error) {
+	var key K
+
+	raw, err := snap.DataAt(field)
+	if err != nil {
+		return key, storageErrors.NewCorrupted(err)
+	}
+
+	s, ok := raw.(string)
+	if !ok {
+		return key, storageErrors.NewCorrupted(fmt.Errorf("document %s: %s is not a string", snap.Ref.ID, field))
+	}
+
+	return parseKey[K](s)
+}
+
+func (p *provider[K, V]) document(key K, value V) (map[string]any, error) {
+	var encoded any
+	if p.cfg.ValueFormat == FormatNative {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &encoded); err != nil {
+			return nil, err
+		}
+	} else {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+			return nil, err
+		}
+		encoded = buf.Bytes()
+	}
+
+	return map[string]any{
+		p.cfg.KeyField:   keyString(key),
+		p.cfg.ValueField: encoded,
+	}, nil
+}
+
+func (p *provider[K, V]) decode(snap *gcp.DocumentSnapshot) (V, error) {
+	var value V
+
+	raw, err := snap.DataAt(p.cfg.ValueField)
+	if err != nil {
+		return value, storageErrors.NewCorrupted(err)
+	}
+
+	if p.cfg.ValueFormat == FormatNative {
+		data, err := json.Marshal(raw)
+		if err != nil {
+			return value, storageErrors.NewCorrupted(err)
+		}
+		if err := json.Unmarshal(data, &value); err != nil {
+			return value, storageErrors.NewCorrupted(err)
+		}
+		return value, nil
+	}
+
+	data, ok := raw.([]byte)
+	if !ok {
+		return value, storageErrors.NewCorrupted(fmt.Errorf("document %s: %s is not bytes", snap.Ref.ID, p.cfg.ValueField))
+	}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value); err != nil {
+		return value, storageErrors.NewCorrupted(err)
+	}
+
+	return value, nil
+}
+
+func (p *provider[K, V]) dataDoc(client *gcp.Client, key K) *gcp.DocumentRef {
+	return client.Collection(p.cfg.Collection).Doc(documentID(keyString(key)))
+}
+
+func (p *provider[K, V]) referenceDoc(client *gcp.Client, reference K) *gcp.DocumentRef {
+	return client.Collection(p.cfg.ReferencesCollection).Doc(documentID(keyString(reference)))
+}
+
+func (p *provider[K, V]) conn() (*gcp.Client, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.client == nil {
+		return nil, storageErrors.NewClosed(errors.New("firestore client is not open"))
+	}
+
+	return p.client, nil
+}
+
+func (p *provider[K, V]) context() (context.Context, context.CancelFunc) {
+	if p.cfg.TransactionTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+
+	return context.WithTimeout(context.Background(), p.cfg.TransactionTimeout)
+}
+
+func (p *provider[K, V]) write(fn func(ctx context.Context, client *gcp.Client) error) error {
+	if p.cfg.ReadOnly {
+		return storageErrors.ReadOnly
+	}
+
+	client, err := p.conn()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := p.context()
+	defer cancel()
+
+	if err := fn(ctx, client); err != nil {
+		return mapError(err)
+	}
+
+	p.lastWrite.Store(time.Now().UnixNano())
+	return nil
+}
+
+func (p *provider[K, V]) transaction(fn func(ctx context.Context, client *gcp.Client, tx *gcp.Transaction) error) error {
+	return p.write(func(ctx context.Context, client *gcp.Client) error {
+		return client.RunTransaction(ctx, func(ctx context.Context, tx *gcp.Transaction) error {
+			return fn(ctx, client, tx)
+		})
+	})
+}
+
+func mapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return storageErrors.NewTimeout(err)
+	}
+
+	switch status.Code(err) {
+	case codes.NotFound:
+		return storageErrors.NewNotFound(err)
+	case codes.Unavailable:
+		return storageErrors.NewUnavailable(err)
+	case codes.DeadlineExceeded:
+		return storageErrors.NewTimeout(err)
+	case codes.Aborted:
+		return storageErrors.NewConflict(err)
+	case codes.ResourceExhausted:
+		return storageErrors.NewRateLimited(err)
+	default:
+		return err
+	}
+}
+
+func documentID(key string) string {
+	if key != "" && len(key) <= maxDocumentID && utf8.ValidString(key) &&
+		!strings.Contains(key, "/") && key != "." && key != ".." &&
+		!strings.HasPrefix(key, hashedIDPrefix) &&
+		!(strings.HasPrefix(key, "__") && strings.HasSuffix(key, "__")) {
+		return key
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return hashedIDPrefix + hex.EncodeToString(sum[:])
+}
+
+// prefixLimit returns the smallest string above every string with the prefix,
+// the prefix with its last rune incremented. Firestore orders strings by their
+// UTF-8 bytes, which is the order of their runes.
+func prefixLimit(prefix string) (string, bool) {
+	runes := []rune(prefix)
+	for i := len(runes) - 1; i >= 0; i-- {
+		switch runes[i] {
+		case utf8.MaxRune:
+			continue
+		case 0xd7ff:
+			// Skip the surrogates, which are not valid in UTF-8.
+			runes[i] = 0xe000
+		default:
+			runes[i]++
+		}
+
+		return string(runes[:i+1]), true
+	}
+
+	return "", false
+}
+
+func keyString[K ~string | ~uint64](key K) string {
+	v := reflect.ValueOf(key)
+	if v.Kind() == reflect.Uint64 {
+		return strconv.FormatUint(v.Uint(), 10)
+	}
+
+	return v.String()
+}
+
+func parseKey[K ~string | ~uint64](s string) (K, error) {
+	var key K
+	v := reflect.ValueOf(&key).Elem()
+	if v.Kind() != reflect.Uint64 {
+		v.SetString(s)
+		return key, nil
+	}
+
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return key, storageErrors.NewCorrupted(fmt.Errorf("key %q is not a uint64", s))
+	}
+	v.SetUint(n)
+
+	return key, nil
+}
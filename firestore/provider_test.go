@@ -0,0 +1,222 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !nofirestore
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package firestore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDocumentID(t *testing.T) {
+	for _, key := range []string{"user", "user.1", "1", "ключ"} {
+		assert.Equal(t, key, documentID(key))
+	}
+
+	hashed := map[string]string{
+		"empty":    "",
+		"slash":    "users/1",
+		"dot":      ".",
+		"dots":     "..",
+		"reserved": "__name__",
+		"tilde":    "~user",
+		"invalid":  "\xff",
+		"long":     strings.Repeat("a", maxDocumentID+1),
+	}
+	ids := map[string]bool{}
+	for name, key := range hashed {
+		id := documentID(key)
+		assert.True(t, strings.HasPrefix(id, hashedIDPrefix), name)
+		assert.Len(t, id, len(hashedIDPrefix)+64, name)
+		ids[id] = true
+	}
+	assert.Len(t, ids, len(hashed), "hashed IDs are distinct")
+	assert.NotEqual(t, documentID("~user"), documentID(documentID("~user")), "hashed and plain IDs do not collide")
+	assert.Equal(t, strings.Repeat("a", maxDocumentID), documentID(strings.Repeat("a", maxDocumentID)))
+}
+
+func TestKeys(t *testing.T) {
+	assert.Equal(t, "user", keyString("user"))
+	assert.Equal(t, "42", keyString(uint64(42)))
+
+	key, err := parseKey[string]("user")
+	require.NoError(t, err)
+	assert.Equal(t, "user", key)
+	number, err := parseKey[uint64]("42")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(42), number)
+	_, err = parseKey[uint64]("user")
+	assert.True(t, storageErrors.Is(err, storageErrors.Corrupted))
+}
+
+func TestPrefixLimit(t *testing.T) {
+	limits := map[string]string{
+		"user/":                "user0",
+		"a\U0010ffff":          "b",
+		"a\ud7ff":              "a\ue000",
+		"a\uf8ff":              "a\uf900",
+		"\U0010ffff":           "",
+		"\U0010ffff\U0010ffff": "",
+	}
+	for prefix, expected := range limits {
+		limit, ok := prefixLimit(prefix)
+		assert.Equal(t, expected != "", ok, "%q", prefix)
+		assert.Equal(t, expected, limit, "%q", prefix)
+	}
+
+	// Every key with the prefix sorts between the bounds, others do not.
+	limit, _ := prefixLimit("a/")
+	for key, matches := range map[string]bool{"a/": true, "a/b": true, "a/\uf8ff\uf8ff": true, "a/😀": true, "a/\U0010ffff": true, "a.": false, "a0": false, "b": false} {
+		within := key >= "a/" && key < limit
+		assert.Equal(t, matches, within, "%q", key)
+	}
+}
+
+func TestMapError(t *testing.T) {
+	assert.NoError(t, mapError(nil))
+
+	mapped := map[error]error{
+		status.Error(codes.NotFound, "missing"):         storageErrors.NotFound,
+		status.Error(codes.Unavailable, "down"):         storageErrors.Unavailable,
+		status.Error(codes.DeadlineExceeded, "slow"):    storageErrors.Timeout,
+		status.Error(codes.Aborted, "contention"):       storageErrors.Conflict,
+		status.Error(codes.ResourceExhausted, "quota"):  storageErrors.RateLimited,
+		context.DeadlineExceeded:                        storageErrors.Timeout,
+		fmt.Errorf("get: %w", context.DeadlineExceeded): storageErrors.Timeout,
+	}
+	for err, kind := range mapped {
+		assert.True(t, storageErrors.Is(mapError(err), kind), "%v", err)
+	}
+
+	denied := status.Error(codes.PermissionDenied, "denied")
+	assert.Equal(t, denied, mapError(denied))
+}
+
+func TestProvider_Closed(t *testing.T) {
+	p, err := New[string, int](Config{ProjectID: "app"})
+	require.NoError(t, err)
+
+	assert.True(t, storageErrors.Is(p.Store("key", 1), storageErrors.Closed))
+	_, err = p.Get("key")
+	assert.True(t, storageErrors.Is(err, storageErrors.Closed))
+
+	p, err = New[string, int](Config{ProjectID: "app", ReadOnly: true})
+	require.NoError(t, err)
+	assert.ErrorIs(t, p.Store("key", 1), storageErrors.ReadOnly)
+}
+
+// TestProvider_Integration runs against the emulator in
+// FIRESTORE_EMULATOR_HOST, e.g. "localhost:8080", started with
+// "gcloud emulators firestore start".
+func TestProvider_Integration(t *testing.T) {
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST is not set")
+	}
+
+	for _, format := range []string{FormatBytes, FormatNative} {
+		t.Run(format, func(t *testing.T) {
+			p, err := New[string, int](Config{
+				ProjectID:   "storage-test",
+				Collection:  fmt.Sprintf("storage_test_%d", time.Now().UnixNano()),
+				ValueFormat: format,
+				PageSize:    2,
+			})
+			require.NoError(t, err)
+			require.NoError(t, p.Setup())
+			defer func() {
+				require.NoError(t, p.Clear())
+				require.NoError(t, p.Shutdown())
+			}()
+
+			_, err = p.Get("a")
+			assert.True(t, storageErrors.Is(err, storageErrors.NotFound))
+
+			require.NoError(t, p.Store("a", 1))
+			require.NoError(t, p.Store("b/1", 2))
+			require.NoError(t, p.Store("b/2", 3))
+			require.NoError(t, p.Store("b/😀", 4))
+			require.NoError(t, p.Store("users/~1", 5))
+			val, err := p.Get("users/~1")
+			require.NoError(t, err)
+			assert.Equal(t, 5, val)
+			values, err := p.GetMultiple([]string{"b/2", "a"})
+			require.NoError(t, err)
+			assert.Equal(t, []int{3, 1}, values)
+			_, err = p.GetMultiple([]string{"a", "missing"})
+			assert.True(t, storageErrors.Is(err, storageErrors.NotFound))
+
+			require.NoError(t, p.Update("a", func(value int, exists bool) (int, error) {
+				assert.True(t, exists)
+				return value + 10, nil
+			}))
+			failed := errors.New("failed")
+			assert.ErrorIs(t, p.Update("a", func(value int, exists bool) (int, error) {
+				return 0, failed
+			}), failed)
+			val, err = p.Get("a")
+			require.NoError(t, err)
+			assert.Equal(t, 11, val)
+
+			var keys []string
+			require.NoError(t, p.ForEachPrefix("b/", func(key string, value int) bool {
+				keys = append(keys, key)
+				return true
+			}))
+			assert.Equal(t, []string{"b/1", "b/2", "b/😀"}, keys, "prefix scans page and include every rune")
+
+			require.NoError(t, p.StoreReference("ref", "a"))
+			require.NoError(t, p.AddReference("ref", "b/1"))
+			require.NoError(t, p.AddReference("ref", "b/1"))
+			val, err = p.GetByReference("ref")
+			require.NoError(t, err)
+			assert.Equal(t, 11, val)
+			values, err = p.GetAllByReference("ref")
+			require.NoError(t, err)
+			assert.Equal(t, []int{11, 2}, values)
+			require.NoError(t, p.StoreReference("outer", "ref"))
+			require.NoError(t, p.StoreReference("ref", "outer"))
+			_, err = p.GetByReference("outer")
+			assert.True(t, storageErrors.Is(err, storageErrors.ReferenceCycle))
+			assert.True(t, storageErrors.Is(p.RemoveReferenceTarget("ref", "a"), storageErrors.NotFound))
+			require.NoError(t, p.RemoveReferenceTarget("ref", "outer"))
+			_, err = p.GetByReference("ref")
+			assert.True(t, storageErrors.Is(err, storageErrors.NotFound))
+			require.NoError(t, p.RemoveReference("outer"))
+
+			removed, err := p.RemovePrefix("b/")
+			require.NoError(t, err)
+			assert.Equal(t, 3, removed)
+			removed, err = p.RemoveWhere(func(key string, value int) bool { return value == 11 })
+			require.NoError(t, err)
+			assert.Equal(t, 1, removed)
+			require.NoError(t, p.Remove("missing"))
+
+			require.NoError(t, p.Verify())
+			var backup bytes.Buffer
+			require.NoError(t, p.Backup(&backup))
+			assert.Contains(t, backup.String(), "users/~1")
+			stats, err := p.Stats()
+			require.NoError(t, err)
+			assert.Equal(t, uint64(1), stats.Entries)
+			require.NoError(t, p.Ping(context.Background()))
+		})
+	}
+}
@@ -0,0 +1,268 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package geo
+
+import (
+	"cmp"
+	baseErrors "errors"
+	"fmt"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/errors"
+	"math"
+	"slices"
+	"strings"
+)
+
+type Location struct {
+	Lat float64 `yaml:"lat" json:"lat"`
+	Lon float64 `yaml:"lon" json:"lon"`
+}
+
+type Result[V any] struct {
+	Key      string   `yaml:"key" json:"key"`
+	Value    V        `yaml:"value" json:"value"`
+	Location Location `yaml:"location" json:"location"`
+	// Distance from the query point in meters.
+	Distance float64 `yaml:"distance" json:"distance"`
+}
+
+// Index stores values in one provider and their locations in another. Every
+// location is kept twice: under ("geo", geohash, key) for prefix scans and
+// under ("location", key) to find the old geohash when an entry moves.
+type Index[V any] struct {
+	values    storage.KeyValueProvider[string, V]
+	locations storage.KeyValueProvider[string, Location]
+}
+
+const (
+	earthRadius     = 6371008.8
+	metersPerDegree = earthRadius * math.Pi / 180
+	hashLength      = 12
+	base32          = "0123456789bcdefghjkmnpqrstuvwxyz"
+)
+
+func New[V any](values storage.KeyValueProvider[string, V], locations storage.KeyValueProvider[string, Location]) (*Index[V], error) {
+	if values == nil {
+		return nil, baseErrors.New("values provider is nil")
+	}
+	if locations == nil {
+		return nil, baseErrors.New("locations provider is nil")
+	}
+
+	return &Index[V]{
+		values:    values,
+		locations: locations,
+	}, nil
+}
+
+func (i *Index[V]) StoreWithLocation(key string, value V, lat, lon float64) error {
+	location := Location{Lat: lat, Lon: lon}
+	if err := location.validate(); err != nil {
+		return err
+	}
+
+	if err := i.unindex(key, &location); err != nil {
+		return err
+	}
+	if err := i.values.Store(key, value); err != nil {
+		return err
+	}
+	if err := i.locations.Store(geoKey(encode(location, hashLength), key), location); err != nil {
+		return err
+	}
+
+	return i.locations.Store(locationKey(key), location)
+}
+
+func (i *Index[V]) Location(key string) (Location, error) {
+	return i.locations.Get(locationKey(key))
+}
+
+func (i *Index[V]) Remove(key string) error {
+	if err := i.unindex(key, nil); err != nil {
+		return err
+	}
+	if err := i.locations.Remove(locationKey(key)); err != nil && !errors.Is(err, errors.NotFound) {
+		return err
+	}
+
+	return i.values.Remove(key)
+}
+
+// QueryRadius returns the entries within radius meters of (lat, lon), nearest
+// first. It scans the geohash cell of the query point and its eight
+// neighbours, with cells chosen at least radius wide, so the cost grows with
+// the number of entries in that area rather than in the index.
+func (i *Index[V]) QueryRadius(lat, lon, radius float64) ([]Result[V], error) {
+	center := Location{Lat: lat, Lon: lon}
+	if err := center.validate(); err != nil {
+		return nil, err
+	}
+	if radius < 0 || math.IsNaN(radius) {
+		return nil, fmt.Errorf("invalid radius %v", radius)
+	}
+
+	var results []Result[V]
+	for _, cell := range cells(center, radius) {
+		var err error
+		scanErr := i.locations.ForEachPrefix(storage.NewKey("geo", cell).Key(), func(indexKey string, location Location) bool {
+			distance := center.distance(location)
+			if distance > radius {
+				return true
+			}
+
+			var parts []string
+			if parts, err = storage.SplitKey(indexKey); err != nil {
+				return false
+			}
+			key := parts[len(parts)-1]
+			value, getErr := i.values.Get(key)
+			if errors.Is(getErr, errors.NotFound) {
+				return true
+			} else if getErr != nil {
+				err = getErr
+				return false
+			}
+			results = append(results, Result[V]{Key: key, Value: value, Location: location, Distance: distance})
+			return true
+		})
+		if scanErr != nil {
+			return nil, scanErr
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	slices.SortFunc(results, func(a, b Result[V]) int {
+		return cmp.Or(cmp.Compare(a.Distance, b.Distance), strings.Compare(a.Key, b.Key))
+	})
+	return results, nil
+}
+
+// unindex removes the geohash entry of key unless it is already at location.
+// A nil location always removes it.
+func (i *Index[V]) unindex(key string, location *Location) error {
+	old, err := i.locations.Get(locationKey(key))
+	if errors.Is(err, errors.NotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if location != nil && old == *location {
+		return nil
+	}
+
+	err = i.locations.Remove(geoKey(encode(old, hashLength), key))
+	if err != nil && !errors.Is(err, errors.NotFound) {
+		return err
+	}
+	return nil
+}
+
+func (l Location) validate() error {
+	if !(l.Lat >= -90 && l.Lat <= 90) || !(l.Lon >= -180 && l.Lon <= 180) {
+		return fmt.Errorf("invalid location %v, %v", l.Lat, l.Lon)
+	}
+
+	return nil
+}
+
+// distance returns the great-circle distance to other in meters.
+func (l Location) distance(other Location) float64 {
+	lat1, lat2 := l.Lat*math.Pi/180, other.Lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (other.Lon - l.Lon) * math.Pi / 180
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * earthRadius * math.Asin(math.Sqrt(min(a, 1)))
+}
+
+// cells returns the geohash prefixes covering radius meters around center:
+// the longest prefix whose cells are at least radius tall and wide, for the
+// cell of center and its neighbours. Near the poles the whole index is
+// scanned.
+func cells(center Location, radius float64) []string {
+	reach := radius / metersPerDegree
+	if math.Abs(center.Lat)+reach >= 90 {
+		return []string{""}
+	}
+	cos := math.Cos((math.Abs(center.Lat) + reach) * math.Pi / 180)
+
+	length := 0
+	for length < hashLength {
+		height, width := cellSize(length + 1)
+		if height*metersPerDegree < radius || width*metersPerDegree*cos < radius {
+			break
+		}
+		length++
+	}
+	if length == 0 {
+		return []string{""}
+	}
+
+	height, width := cellSize(length)
+	var prefixes []string
+	for _, dLat := range []float64{-height, 0, height} {
+		for _, dLon := range []float64{-width, 0, width} {
+			lat := max(-90, min(90, center.Lat+dLat))
+			lon := math.Mod(center.Lon+dLon+540, 360) - 180
+			prefix := encode(Location{Lat: lat, Lon: lon}, length)
+			if !slices.Contains(prefixes, prefix) {
+				prefixes = append(prefixes, prefix)
+			}
+		}
+	}
+
+	return prefixes
+}
+
+// cellSize returns the height and width in degrees of geohash cells of the
+// given length.
+func cellSize(length int) (float64, float64) {
+	bits := 5 * length
+	return 180 / math.Exp2(float64(bits/2)), 360 / math.Exp2(float64(bits-bits/2))
+}
+
+func encode(l Location, length int) string {
+	latRange, lonRange := [2]float64{-90, 90}, [2]float64{-180, 180}
+	hash := make([]byte, 0, length)
+	even := true
+	var ch, bit int
+	for len(hash) < length {
+		r, v := &latRange, l.Lat
+		if even {
+			r, v = &lonRange, l.Lon
+		}
+		mid := (r[0] + r[1]) / 2
+		ch <<= 1
+		if v >= mid {
+			ch |= 1
+			r[0] = mid
+		} else {
+			r[1] = mid
+		}
+		even = !even
+
+		if bit++; bit == 5 {
+			hash = append(hash, base32[ch])
+			ch, bit = 0, 0
+		}
+	}
+
+	return string(hash)
+}
+
+func geoKey(hash string, key string) string {
+	return storage.NewKey("geo", hash).String(key).Key()
+}
+
+func locationKey(key string) string {
+	return storage.NewKey("location").String(key).Key()
+}
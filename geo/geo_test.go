@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package geo
+
+import (
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/rlshukhov/storage/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	values, err := storage.GetKeyValueProviderFromConfig[string, string](storage.KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, values.Setup())
+	defer values.Shutdown()
+	locations, err := storage.GetKeyValueProviderFromConfig[string, Location](storage.KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, locations.Setup())
+	defer locations.Shutdown()
+
+	index, err := New(values, locations)
+	require.NoError(t, err)
+
+	assert.Equal(t, "u4pruydqqvj", encode(Location{Lat: 57.64911, Lon: 10.40744}, 11))
+
+	places := map[string]Location{
+		"louvre":      {Lat: 48.8606, Lon: 2.3376},
+		"notre-dame":  {Lat: 48.8530, Lon: 2.3499},
+		"eiffel":      {Lat: 48.8584, Lon: 2.2945},
+		"versailles":  {Lat: 48.8049, Lon: 2.1204},
+		"london-eye":  {Lat: 51.5033, Lon: -0.1196},
+		"fiji-east":   {Lat: -17.7134, Lon: 179.9},
+		"fiji-west":   {Lat: -17.7134, Lon: -179.95},
+		"north-pole":  {Lat: 89.99, Lon: 0},
+		"north-pole2": {Lat: 89.99, Lon: 180},
+	}
+	for key, location := range places {
+		require.NoError(t, index.StoreWithLocation(key, key, location.Lat, location.Lon))
+	}
+	assert.Error(t, index.StoreWithLocation("nowhere", "", 91, 0))
+
+	keys := func(results []Result[string]) []string {
+		var keys []string
+		for _, result := range results {
+			keys = append(keys, result.Key)
+			assert.Equal(t, result.Key, result.Value)
+		}
+		return keys
+	}
+
+	results, err := index.QueryRadius(48.8600, 2.3400, 2000)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"louvre", "notre-dame"}, keys(results))
+	assert.Less(t, results[0].Distance, results[1].Distance)
+
+	results, err = index.QueryRadius(48.8600, 2.3400, 5000)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"louvre", "notre-dame", "eiffel"}, keys(results))
+
+	results, err = index.QueryRadius(48.8600, 2.3400, 400000)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"louvre", "notre-dame", "eiffel", "versailles", "london-eye"}, keys(results))
+
+	results, err = index.QueryRadius(-17.7134, 180, 20000)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"fiji-east", "fiji-west"}, keys(results))
+
+	results, err = index.QueryRadius(90, 0, 5000)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"north-pole", "north-pole2"}, keys(results))
+
+	require.NoError(t, index.StoreWithLocation("louvre", "louvre", 51.5007, -0.1246))
+	results, err = index.QueryRadius(48.8600, 2.3400, 2000)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"notre-dame"}, keys(results))
+	results, err = index.QueryRadius(51.5033, -0.1196, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"london-eye", "louvre"}, keys(results))
+
+	require.NoError(t, index.Remove("louvre"))
+	_, err = index.Location("louvre")
+	assert.True(t, errors.Is(err, errors.NotFound))
+	results, err = index.QueryRadius(51.5033, -0.1196, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"london-eye"}, keys(results))
+}
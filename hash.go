@@ -0,0 +1,131 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"errors"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const hashFieldSeparator = "\x00"
+
+type HashStore[K ~string, F ~string | ~uint64, V any] struct {
+	provider KeyValueProvider[string, V]
+}
+
+func NewHashStore[K ~string, F ~string | ~uint64, V any](provider KeyValueProvider[string, V]) *HashStore[K, F, V] {
+	return &HashStore[K, F, V]{provider: provider}
+}
+
+func (h *HashStore[K, F, V]) HSet(key K, field F, value V) error {
+	k, err := h.fieldKey(key, field)
+	if err != nil {
+		return err
+	}
+
+	return h.provider.Store(k, value)
+}
+
+func (h *HashStore[K, F, V]) HGet(key K, field F) (V, error) {
+	k, err := h.fieldKey(key, field)
+	if err != nil {
+		var v V
+		return v, err
+	}
+
+	return h.provider.Get(k)
+}
+
+func (h *HashStore[K, F, V]) HDel(key K, fields ...F) error {
+	for _, field := range fields {
+		k, err := h.fieldKey(key, field)
+		if err != nil {
+			return err
+		}
+
+		err = h.provider.Remove(k)
+		if err != nil && !storageErrors.Is(err, storageErrors.NotFound) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *HashStore[K, F, V]) HGetAll(key K) (map[F]V, error) {
+	prefix, err := h.prefix(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var parseErr error
+	values := map[F]V{}
+	err = h.provider.ForEachPrefix(prefix, func(k string, value V) bool {
+		field, err := parseHashField[F](strings.TrimPrefix(k, prefix))
+		if err != nil {
+			parseErr = err
+			return false
+		}
+
+		values[field] = value
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return values, parseErr
+}
+
+func (h *HashStore[K, F, V]) prefix(key K) (string, error) {
+	if strings.Contains(string(key), hashFieldSeparator) {
+		return "", errors.New("hash key must not contain NUL characters")
+	}
+
+	return string(key) + hashFieldSeparator, nil
+}
+
+func (h *HashStore[K, F, V]) fieldKey(key K, field F) (string, error) {
+	prefix, err := h.prefix(key)
+	if err != nil {
+		return "", err
+	}
+
+	v := reflect.ValueOf(field)
+	switch v.Kind() {
+	case reflect.String:
+		return prefix + v.String(), nil
+	case reflect.Uint64:
+		return prefix + strconv.FormatUint(v.Uint(), 10), nil
+	default:
+		return "", errors.New("unknown field type (string, uint64 supported)")
+	}
+}
+
+func parseHashField[F ~string | ~uint64](s string) (F, error) {
+	var field F
+	v := reflect.ValueOf(&field).Elem()
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return field, errors.New("failed to convert hash field to uint64")
+		}
+		v.SetUint(n)
+	default:
+		return field, errors.New("unknown field type (string, uint64 supported)")
+	}
+
+	return field, nil
+}
@@ -0,0 +1,242 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package hotkeys
+
+import (
+	"cmp"
+	"container/heap"
+	baseErrors "errors"
+	"github.com/rlshukhov/storage"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	// Capacity is the number of keys tracked, 1024 by default. Every key that
+	// takes more than 1/Capacity of the accesses is guaranteed to be tracked.
+	Capacity int `yaml:"capacity,omitempty"`
+	// Separator, if set, counts accesses per prefix: the key up to and
+	// including the first Separator, e.g. "user:" for "user:42".
+	Separator string `yaml:"separator,omitempty"`
+}
+
+// HotKey is a tracked key or prefix. The true number of accesses is between
+// Count-Error and Count.
+type HotKey struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+	Error uint64 `json:"error"`
+}
+
+type provider[K ~string | ~uint64, V any] struct {
+	storage.KeyValueProvider[K, V]
+	separator string
+
+	mu       sync.Mutex
+	sketch   *sketch
+	total    uint64
+	since    time.Time
+	capacity int
+}
+
+const defaultCapacity = 1024
+
+// New tracks the access frequency of the keys of inner with the Space-Saving
+// sketch, which keeps Capacity counters no matter how many keys there are.
+func New[K ~string | ~uint64, V any](inner storage.KeyValueProvider[K, V], cfg Config) (*provider[K, V], error) {
+	if inner == nil {
+		return nil, baseErrors.New("inner provider is nil")
+	}
+	if cfg.Capacity < 0 {
+		return nil, baseErrors.New("capacity must not be negative")
+	}
+
+	capacity := cfg.Capacity
+	if capacity == 0 {
+		capacity = defaultCapacity
+	}
+
+	return &provider[K, V]{
+		KeyValueProvider: inner,
+		separator:        cfg.Separator,
+		sketch:           newSketch(capacity),
+		since:            time.Now(),
+		capacity:         capacity,
+	}, nil
+}
+
+// HotKeys returns up to n of the most accessed keys, most accessed first.
+func (p *provider[K, V]) HotKeys(n int) []HotKey {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	keys := make([]HotKey, 0, len(p.sketch.counters))
+	for _, c := range p.sketch.counters {
+		keys = append(keys, HotKey{Key: c.key, Count: c.count, Error: c.error})
+	}
+	slices.SortFunc(keys, func(a, b HotKey) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), strings.Compare(a.Key, b.Key))
+	})
+
+	return keys[:max(0, min(n, len(keys)))]
+}
+
+// Accesses returns the number of accesses counted and when counting
+// started, at New or the last Reset.
+func (p *provider[K, V]) Accesses() (uint64, time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.total, p.since
+}
+
+func (p *provider[K, V]) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sketch = newSketch(p.capacity)
+	p.total = 0
+	p.since = time.Now()
+}
+
+func (p *provider[K, V]) Get(key K) (V, error) {
+	p.record(key)
+	return p.KeyValueProvider.Get(key)
+}
+
+func (p *provider[K, V]) GetMultiple(keys []K) ([]V, error) {
+	p.record(keys...)
+	return p.KeyValueProvider.GetMultiple(keys)
+}
+
+func (p *provider[K, V]) GetByReference(reference K) (V, error) {
+	p.record(reference)
+	return p.KeyValueProvider.GetByReference(reference)
+}
+
+func (p *provider[K, V]) GetAllByReference(reference K) ([]V, error) {
+	p.record(reference)
+	return p.KeyValueProvider.GetAllByReference(reference)
+}
+
+func (p *provider[K, V]) Flush() error {
+	return storage.Flush(p.KeyValueProvider)
+}
+
+func (p *provider[K, V]) Store(key K, value V) error {
+	p.record(key)
+	return p.KeyValueProvider.Store(key, value)
+}
+
+func (p *provider[K, V]) Update(key K, fn func(value V, exists bool) (V, error)) error {
+	p.record(key)
+	return p.KeyValueProvider.Update(key, fn)
+}
+
+func (p *provider[K, V]) Remove(key K) error {
+	p.record(key)
+	return p.KeyValueProvider.Remove(key)
+}
+
+func (p *provider[K, V]) record(keys ...K) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, key := range keys {
+		s := keyString(key)
+		if p.separator != "" {
+			if i := strings.Index(s, p.separator); i >= 0 {
+				s = s[:i+len(p.separator)]
+			}
+		}
+		p.sketch.add(s)
+		p.total++
+	}
+}
+
+// sketch is a Space-Saving sketch: a full sketch replaces its least counted
+// key with a new one, which inherits that count as its error.
+type sketch struct {
+	capacity int
+	counters counterHeap
+	index    map[string]*counter
+}
+
+type counter struct {
+	key   string
+	count uint64
+	error uint64
+	pos   int
+}
+
+func newSketch(capacity int) *sketch {
+	return &sketch{
+		capacity: capacity,
+		index:    make(map[string]*counter, capacity),
+	}
+}
+
+func (s *sketch) add(key string) {
+	if c, ok := s.index[key]; ok {
+		c.count++
+		heap.Fix(&s.counters, c.pos)
+		return
+	}
+
+	if len(s.counters) < s.capacity {
+		c := &counter{key: key, count: 1}
+		s.index[key] = c
+		heap.Push(&s.counters, c)
+		return
+	}
+
+	c := s.counters[0]
+	delete(s.index, c.key)
+	c.key, c.error = key, c.count
+	c.count++
+	s.index[key] = c
+	heap.Fix(&s.counters, 0)
+}
+
+type counterHeap []*counter
+
+func (h counterHeap) Len() int           { return len(h) }
+func (h counterHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+
+func (h counterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].pos = i
+	h[j].pos = j
+}
+
+func (h *counterHeap) Push(x any) {
+	c := x.(*counter)
+	c.pos = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *counterHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+func keyString[K ~string | ~uint64](key K) string {
+	v := reflect.ValueOf(key)
+	if v.Kind() == reflect.Uint64 {
+		return strconv.FormatUint(v.Uint(), 10)
+	}
+
+	return v.String()
+}
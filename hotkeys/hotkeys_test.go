@@ -0,0 +1,64 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package hotkeys
+
+import (
+	"fmt"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestProvider(t *testing.T) {
+	inner, err := storage.GetKeyValueProviderFromConfig[string, int](storage.KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, inner.Setup())
+	defer inner.Shutdown()
+
+	p, err := New(inner, Config{Capacity: 16})
+	require.NoError(t, err)
+
+	for i := 0; i < 2000; i++ {
+		require.NoError(t, p.Store(fmt.Sprintf("cold:%d", i), i))
+		if i%4 == 0 {
+			_, err := p.Get("hot:a")
+			assert.Error(t, err)
+		}
+		if i%10 == 0 {
+			_, _ = p.GetMultiple([]string{"hot:b"})
+		}
+	}
+
+	hot := p.HotKeys(2)
+	require.Len(t, hot, 2)
+	assert.Equal(t, "hot:a", hot[0].Key)
+	assert.GreaterOrEqual(t, hot[0].Count, uint64(500))
+	assert.LessOrEqual(t, hot[0].Count-hot[0].Error, uint64(500))
+	assert.Equal(t, "hot:b", hot[1].Key)
+	assert.Len(t, p.HotKeys(100), 16)
+	total, _ := p.Accesses()
+	assert.Equal(t, uint64(2700), total)
+
+	p.Reset()
+	assert.Empty(t, p.HotKeys(10))
+
+	prefixes, err := New(inner, Config{Separator: ":"})
+	require.NoError(t, err)
+	for i := 0; i < 30; i++ {
+		_, _ = prefixes.Get(fmt.Sprintf("cold:%d", i))
+	}
+	_, _ = prefixes.Get("hot:a")
+	_, _ = prefixes.Get("plain")
+	assert.Equal(t, []HotKey{{Key: "cold:", Count: 30}, {Key: "hot:", Count: 1}, {Key: "plain", Count: 1}}, prefixes.HotKeys(5))
+}
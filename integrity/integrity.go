@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package integrity
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Config selects where the HMAC secret comes from. Exactly one source must be
+// set.
+type Config struct {
+	Secret     string `yaml:"secret,omitempty"`
+	SecretEnv  string `yaml:"secret_env,omitempty"`
+	SecretFile string `yaml:"secret_file,omitempty"`
+}
+
+const Size = sha256.Size
+
+type Signer struct {
+	secret []byte
+}
+
+func (c Config) Validate() error {
+	sources := 0
+	for _, s := range []string{c.Secret, c.SecretEnv, c.SecretFile} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return errors.New("integrity requires exactly one of secret, secret_env or secret_file")
+	}
+
+	return nil
+}
+
+func (c Config) Signer() (*Signer, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	var secret []byte
+	switch {
+	case c.Secret != "":
+		secret = []byte(c.Secret)
+	case c.SecretEnv != "":
+		secret = []byte(os.Getenv(c.SecretEnv))
+	default:
+		raw, err := os.ReadFile(c.SecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("integrity secret: %w", err)
+		}
+		secret = bytes.TrimRight(raw, "\r\n")
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("integrity secret is empty")
+	}
+
+	return &Signer{secret: secret}, nil
+}
+
+// Sign returns the HMAC-SHA256 of key and value.
+func (s *Signer) Sign(key []byte, value []byte) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(binary.BigEndian.AppendUint32(nil, uint32(len(key))))
+	mac.Write(key)
+	mac.Write(value)
+
+	return mac.Sum(nil)
+}
+
+func (s *Signer) Valid(key []byte, value []byte, sum []byte) bool {
+	return hmac.Equal(s.Sign(key, value), sum)
+}
@@ -0,0 +1,37 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+// Package bufpool pools the buffers values are encoded into.
+package bufpool
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxSize drops buffers that grew beyond it, so one large value does not
+// keep its memory alive in the pool.
+const maxSize = 64 << 10
+
+var pool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// Get returns an empty buffer.
+func Get() *bytes.Buffer {
+	return pool.Get().(*bytes.Buffer)
+}
+
+// Put returns buf to the pool. buf must not be used afterwards, including
+// slices of its contents.
+func Put(buf *bytes.Buffer) {
+	if buf.Cap() > maxSize {
+		return
+	}
+
+	buf.Reset()
+	pool.Put(buf)
+}
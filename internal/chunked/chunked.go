@@ -0,0 +1,196 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+// Package chunked frames a stream into checksummed chunks:
+//
+//	magic "SBK1"
+//	chunk:   length uint32 | crc32c uint32 | data
+//	trailer: 0 uint32 | total length uint64 | sha256 of all data
+package chunked
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+const ChunkSize = 1 << 20
+
+var (
+	magic = []byte("SBK1")
+	table = crc32.MakeTable(crc32.Castagnoli)
+)
+
+type Writer struct {
+	w     io.Writer
+	buf   []byte
+	sum   hash.Hash
+	total uint64
+	err   error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	cw := &Writer{w: w, buf: make([]byte, 0, ChunkSize), sum: sha256.New()}
+	_, cw.err = w.Write(magic)
+
+	return cw
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 && w.err == nil {
+		c := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+c]
+		p = p[c:]
+		n += c
+		if len(w.buf) == cap(w.buf) {
+			w.flush()
+		}
+	}
+
+	return n, w.err
+}
+
+// Close writes the trailer. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	w.flush()
+	if w.err != nil {
+		return w.err
+	}
+
+	trailer := binary.BigEndian.AppendUint32(nil, 0)
+	trailer = binary.BigEndian.AppendUint64(trailer, w.total)
+	trailer = w.sum.Sum(trailer)
+	_, w.err = w.w.Write(trailer)
+
+	return w.err
+}
+
+func (w *Writer) flush() {
+	if w.err != nil || len(w.buf) == 0 {
+		return
+	}
+
+	header := binary.BigEndian.AppendUint32(nil, uint32(len(w.buf)))
+	header = binary.BigEndian.AppendUint32(header, crc32.Checksum(w.buf, table))
+	if _, w.err = w.w.Write(header); w.err != nil {
+		return
+	}
+	if _, w.err = w.w.Write(w.buf); w.err != nil {
+		return
+	}
+
+	w.sum.Write(w.buf)
+	w.total += uint64(len(w.buf))
+	w.buf = w.buf[:0]
+}
+
+// Reader returns the data of a framed stream. Every chunk is checked before
+// its data is returned; errors are reported as errors.Corrupted.
+type Reader struct {
+	r      io.Reader
+	chunk  []byte
+	sum    hash.Hash
+	total  uint64
+	chunks int
+	err    error
+	opened bool
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r, sum: sha256.New()}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 && r.err == nil {
+		r.err = r.next()
+	}
+	if len(r.chunk) == 0 {
+		return 0, r.err
+	}
+
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+
+	return n, nil
+}
+
+func (r *Reader) next() error {
+	if !r.opened {
+		r.opened = true
+		head := make([]byte, len(magic))
+		if _, err := io.ReadFull(r.r, head); err != nil || !bytes.Equal(head, magic) {
+			return storageErrors.NewCorrupted(errors.New("backup header is missing"))
+		}
+	}
+
+	var header [8]byte
+	if _, err := io.ReadFull(r.r, header[:4]); err != nil {
+		return r.truncated(err)
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	if length == 0 {
+		return r.trailer()
+	}
+	if length > ChunkSize {
+		return storageErrors.NewCorrupted(fmt.Errorf("chunk %d: length %d exceeds %d", r.chunks, length, ChunkSize))
+	}
+
+	if _, err := io.ReadFull(r.r, header[4:]); err != nil {
+		return r.truncated(err)
+	}
+	chunk := make([]byte, length)
+	if _, err := io.ReadFull(r.r, chunk); err != nil {
+		return r.truncated(err)
+	}
+	if crc32.Checksum(chunk, table) != binary.BigEndian.Uint32(header[4:]) {
+		return storageErrors.NewCorrupted(fmt.Errorf("chunk %d: checksum mismatch", r.chunks))
+	}
+
+	r.sum.Write(chunk)
+	r.total += uint64(length)
+	r.chunks++
+	r.chunk = chunk
+
+	return nil
+}
+
+func (r *Reader) trailer() error {
+	trailer := make([]byte, 8+sha256.Size)
+	if _, err := io.ReadFull(r.r, trailer); err != nil {
+		return r.truncated(err)
+	}
+	if binary.BigEndian.Uint64(trailer[:8]) != r.total {
+		return storageErrors.NewCorrupted(fmt.Errorf("backup length is %d, expected %d", r.total, binary.BigEndian.Uint64(trailer[:8])))
+	}
+	if !bytes.Equal(r.sum.Sum(nil), trailer[8:]) {
+		return storageErrors.NewCorrupted(errors.New("backup checksum mismatch"))
+	}
+
+	return io.EOF
+}
+
+func (r *Reader) truncated(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return storageErrors.NewCorrupted(fmt.Errorf("backup is truncated after %d chunks", r.chunks))
+	}
+
+	return err
+}
+
+// Verify reads the whole stream and checks every chunk and the trailer.
+func Verify(r io.Reader) error {
+	_, err := io.Copy(io.Discard, NewReader(r))
+	return err
+}
@@ -0,0 +1,136 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package schema
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/gob"
+	"fmt"
+	"github.com/rlshukhov/storage/errors"
+	"hash/fnv"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+var (
+	gobEncoder    = reflect.TypeFor[gob.GobEncoder]()
+	binaryEncoder = reflect.TypeFor[encoding.BinaryMarshaler]()
+)
+
+// Describe lists the fields gob encodes for t as sorted "path type" lines.
+func Describe(t reflect.Type) []string {
+	return list(t, reflect.Type.String)
+}
+
+// Fingerprint hashes the shape of t: the paths and kinds of the fields gob
+// encodes, but not the names of the types. Renaming or moving a type keeps
+// its fingerprint; adding, removing or retyping a field changes it.
+func Fingerprint(t reflect.Type) uint64 {
+	h := fnv.New64a()
+	h.Write(Encode(list(t, func(t reflect.Type) string {
+		return t.Kind().String()
+	})))
+
+	return h.Sum64()
+}
+
+func list(t reflect.Type, name func(reflect.Type) string) []string {
+	var fields []string
+	describe(t, "", name, map[reflect.Type]bool{}, &fields)
+	slices.Sort(fields)
+
+	return slices.Compact(fields)
+}
+
+func describe(t reflect.Type, path string, name func(reflect.Type) string, seen map[reflect.Type]bool, fields *[]string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	leaf := func() {
+		if path == "" {
+			path = "."
+		}
+		*fields = append(*fields, path+" "+name(t))
+	}
+
+	if t.Implements(gobEncoder) || reflect.PointerTo(t).Implements(gobEncoder) ||
+		t.Implements(binaryEncoder) || reflect.PointerTo(t).Implements(binaryEncoder) {
+		leaf()
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if seen[t] {
+			leaf()
+			return
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() || f.Type.Kind() == reflect.Chan || f.Type.Kind() == reflect.Func {
+				continue
+			}
+
+			field := f.Name
+			if path != "" {
+				field = path + "." + f.Name
+			}
+			describe(f.Type, field, name, seen, fields)
+		}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			leaf()
+			return
+		}
+		describe(t.Elem(), path+"[]", name, seen, fields)
+	case reflect.Map:
+		describe(t.Elem(), path+"{"+name(t.Key())+"}", name, seen, fields)
+	default:
+		leaf()
+	}
+}
+
+func Encode(fields []string) []byte {
+	return []byte(strings.Join(fields, "\n"))
+}
+
+func Decode(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+
+	return strings.Split(string(data), "\n")
+}
+
+// Check reports the stored fields that current no longer has with the same
+// type. Fields that only exist in current are allowed.
+func Check(stored []byte, current []byte) error {
+	if bytes.Equal(stored, current) {
+		return nil
+	}
+
+	have := Decode(current)
+	var lost []string
+	for _, field := range Decode(stored) {
+		if _, found := slices.BinarySearch(have, field); !found {
+			lost = append(lost, field)
+		}
+	}
+	if len(lost) == 0 {
+		return nil
+	}
+
+	return errors.NewSchemaMismatch(fmt.Errorf("stored fields are missing or changed in the current type: %s", strings.Join(lost, ", ")))
+}
@@ -0,0 +1,39 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package timeout
+
+import (
+	"fmt"
+	"github.com/rlshukhov/storage/errors"
+	"time"
+)
+
+// Deadline bounds an operation that cannot be interrupted once it writes.
+// The operation runs synchronously and checks Err at the last point where
+// it can still back out, e.g. before a commit or rename, so a Timeout
+// always means nothing was written.
+type Deadline struct {
+	op    string
+	d     time.Duration
+	start time.Time
+}
+
+// Start starts the deadline of op. A d of 0 or less never expires.
+func Start(d time.Duration, op string) Deadline {
+	return Deadline{op: op, d: d, start: time.Now()}
+}
+
+// Err returns a Timeout error once the deadline has passed.
+func (d Deadline) Err() error {
+	if d.d <= 0 || time.Since(d.start) < d.d {
+		return nil
+	}
+
+	return errors.NewTimeout(fmt.Errorf("%s did not complete within %s", d.op, d.d))
+}
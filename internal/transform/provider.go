@@ -0,0 +1,260 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package transform
+
+import (
+	"context"
+	baseErrors "errors"
+	"fmt"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/errors"
+	"io"
+)
+
+// Provider stores the values of V in Inner as R. Encode and Decode get the
+// key of the value; values read through references get the key the
+// reference resolves to.
+type Provider[K ~string | ~uint64, V any, R any] struct {
+	Inner  storage.KeyValueProvider[K, R]
+	Encode func(key K, value V) (R, error)
+	Decode func(key K, stored R) (V, error)
+}
+
+func (p *Provider[K, V, R]) Setup() error {
+	return p.Inner.Setup()
+}
+
+func (p *Provider[K, V, R]) Shutdown() error {
+	return p.Inner.Shutdown()
+}
+
+func (p *Provider[K, V, R]) Close() error {
+	return p.Shutdown()
+}
+
+func (p *Provider[K, V, R]) Ping(ctx context.Context) error {
+	return p.Inner.Ping(ctx)
+}
+
+func (p *Provider[K, V, R]) Stats() (storage.ProviderStats, error) {
+	return p.Inner.Stats()
+}
+
+func (p *Provider[K, V, R]) ApproximateSize() (uint64, error) {
+	return p.Inner.ApproximateSize()
+}
+
+func (p *Provider[K, V, R]) Flush() error {
+	return storage.Flush(p.Inner)
+}
+
+func (p *Provider[K, V, R]) Store(key K, value V) error {
+	r, err := p.Encode(key, value)
+	if err != nil {
+		return err
+	}
+
+	return p.Inner.Store(key, r)
+}
+
+func (p *Provider[K, V, R]) Update(key K, fn func(value V, exists bool) (V, error)) error {
+	return p.Inner.Update(key, func(stored R, exists bool) (R, error) {
+		var value V
+		if exists {
+			v, err := p.Decode(key, stored)
+			if err != nil {
+				return stored, err
+			}
+			value = v
+		}
+
+		value, err := fn(value, exists)
+		if err != nil {
+			return stored, err
+		}
+
+		return p.Encode(key, value)
+	})
+}
+
+func (p *Provider[K, V, R]) Get(key K) (V, error) {
+	stored, err := p.Inner.Get(key)
+	if err != nil {
+		var v V
+		return v, err
+	}
+
+	return p.Decode(key, stored)
+}
+
+func (p *Provider[K, V, R]) GetMultiple(keys []K) ([]V, error) {
+	var values []V
+	for _, key := range keys {
+		v, err := p.Get(key)
+		if err != nil {
+			return []V{}, err
+		}
+
+		values = append(values, v)
+	}
+
+	return values, nil
+}
+
+func (p *Provider[K, V, R]) Remove(key K) error {
+	return p.Inner.Remove(key)
+}
+
+func (p *Provider[K, V, R]) RemovePrefix(prefix K) (int, error) {
+	return p.Inner.RemovePrefix(prefix)
+}
+
+func (p *Provider[K, V, R]) RemoveWhere(pred func(key K, value V) bool) (int, error) {
+	var decodeErr error
+	removed, err := p.Inner.RemoveWhere(func(key K, stored R) bool {
+		if decodeErr != nil {
+			return false
+		}
+
+		value, err := p.Decode(key, stored)
+		if err != nil {
+			decodeErr = err
+			return false
+		}
+
+		return pred(key, value)
+	})
+	if err != nil {
+		return removed, err
+	}
+
+	return removed, decodeErr
+}
+
+func (p *Provider[K, V, R]) Clear() error {
+	return p.Inner.Clear()
+}
+
+func (p *Provider[K, V, R]) ForEach(fn func(key K, value V) bool) error {
+	var decodeErr error
+	err := p.Inner.ForEach(p.decodeEach(fn, &decodeErr))
+	if err != nil {
+		return err
+	}
+
+	return decodeErr
+}
+
+func (p *Provider[K, V, R]) ForEachPrefix(prefix K, fn func(key K, value V) bool) error {
+	var decodeErr error
+	err := p.Inner.ForEachPrefix(prefix, p.decodeEach(fn, &decodeErr))
+	if err != nil {
+		return err
+	}
+
+	return decodeErr
+}
+
+func (p *Provider[K, V, R]) ForEachKey(fn func(key K) bool) error {
+	return p.Inner.ForEachKey(fn)
+}
+
+func (p *Provider[K, V, R]) StoreReference(reference K, key K) error {
+	return p.Inner.StoreReference(reference, key)
+}
+
+func (p *Provider[K, V, R]) AddReference(reference K, key K) error {
+	return p.Inner.AddReference(reference, key)
+}
+
+func (p *Provider[K, V, R]) RemoveReference(reference K) error {
+	return p.Inner.RemoveReference(reference)
+}
+
+func (p *Provider[K, V, R]) RemoveReferenceTarget(reference K, key K) error {
+	return p.Inner.RemoveReferenceTarget(reference, key)
+}
+
+func (p *Provider[K, V, R]) ResolveReference(reference K) ([]K, error) {
+	return storage.ResolveReference(p.Inner, reference)
+}
+
+func (p *Provider[K, V, R]) GetByReference(reference K) (V, error) {
+	keys, err := p.ResolveReference(reference)
+	if err != nil {
+		var v V
+		return v, err
+	}
+	if len(keys) == 0 {
+		var v V
+		return v, errors.NotFound
+	}
+
+	return p.Get(keys[0])
+}
+
+func (p *Provider[K, V, R]) GetAllByReference(reference K) ([]V, error) {
+	keys, err := p.ResolveReference(reference)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]V, 0, len(keys))
+	for _, key := range keys {
+		v, err := p.Get(key)
+		if errors.Is(err, errors.NotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+
+	return values, nil
+}
+
+func (p *Provider[K, V, R]) ForEachReference(fn func(reference K, key K) bool) error {
+	return p.Inner.ForEachReference(fn)
+}
+
+func (p *Provider[K, V, R]) Backup(w io.Writer) error {
+	return p.Inner.Backup(w)
+}
+
+func (p *Provider[K, V, R]) Verify() error {
+	if err := p.Inner.Verify(); err != nil {
+		return err
+	}
+
+	var errs []error
+	err := p.Inner.ForEach(func(key K, stored R) bool {
+		if _, err := p.Decode(key, stored); err != nil {
+			errs = append(errs, fmt.Errorf("key %v: %w", key, errors.NewCorrupted(err)))
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	return baseErrors.Join(errs...)
+}
+
+func (p *Provider[K, V, R]) decodeEach(fn func(key K, value V) bool, decodeErr *error) func(key K, stored R) bool {
+	return func(key K, stored R) bool {
+		v, err := p.Decode(key, stored)
+		if err != nil {
+			*decodeErr = err
+			return false
+		}
+
+		return fn(key, v)
+	}
+}
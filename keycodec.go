@@ -0,0 +1,393 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"reflect"
+)
+
+// KeyCodec converts keys of any comparable type to string keys that sort
+// like the keys, so they can be stored by every provider.
+type KeyCodec[K comparable] interface {
+	EncodeKey(key K) (string, error)
+	DecodeKey(key string) (K, error)
+}
+
+// NewKeyCodec returns a KeyCodec that writes K as a KeyBuilder key. K is a
+// struct whose exported fields are the parts of the key in field order, or a
+// single part. Parts may be strings, booleans, integers, uuid.UUID and byte
+// arrays. Integers keep numeric order, UUIDs are written in their canonical
+// form and other byte arrays as hexadecimal, so keys stay valid UTF-8
+// whenever their strings are. A uuid.UUID key is its canonical form, which
+// Badger stores as 16 bytes with key_encoding: uuid.
+func NewKeyCodec[K comparable]() (KeyCodec[K], error) {
+	t := reflect.TypeFor[K]()
+	if t.Kind() != reflect.Struct {
+		if !isKeyPart(t) {
+			return nil, fmt.Errorf("%v can not be a key part", t)
+		}
+		return partsKeyCodec[K]{}, nil
+	}
+
+	var fields []int
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			return nil, fmt.Errorf("%v: unexported field %s can not be encoded", t, field.Name)
+		}
+		if !isKeyPart(field.Type) {
+			return nil, fmt.Errorf("%v: field %s of type %v can not be a key part", t, field.Name, field.Type)
+		}
+		fields = append(fields, i)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("%v has no fields", t)
+	}
+
+	return partsKeyCodec[K]{fields: fields}, nil
+}
+
+type partsKeyCodec[K comparable] struct {
+	// fields are the struct fields of K, or nil when K is a single part.
+	fields []int
+}
+
+func (c partsKeyCodec[K]) EncodeKey(key K) (string, error) {
+	v := reflect.ValueOf(key)
+	if c.fields == nil {
+		return encodeKeyPart(KeyBuilder{}, v).Key(), nil
+	}
+
+	var b KeyBuilder
+	for _, i := range c.fields {
+		b = encodeKeyPart(b, v.Field(i))
+	}
+
+	return b.Key(), nil
+}
+
+func (c partsKeyCodec[K]) DecodeKey(s string) (K, error) {
+	var key K
+	parts, err := SplitKey(s)
+	if err != nil {
+		return key, err
+	}
+
+	v := reflect.ValueOf(&key).Elem()
+	if c.fields == nil {
+		if len(parts) != 1 {
+			return key, fmt.Errorf("key %q has %d parts, expected 1", s, len(parts))
+		}
+		return key, decodeKeyPart(parts[0], v)
+	}
+
+	if len(parts) != len(c.fields) {
+		return key, fmt.Errorf("key %q has %d parts, expected %d", s, len(parts), len(c.fields))
+	}
+	for i, field := range c.fields {
+		if err := decodeKeyPart(parts[i], v.Field(field)); err != nil {
+			return key, fmt.Errorf("key %q: %s: %w", s, v.Type().Field(field).Name, err)
+		}
+	}
+
+	return key, nil
+}
+
+func isKeyPart(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+
+	return isKeyArray(t)
+}
+
+func isKeyArray(t reflect.Type) bool {
+	return t.Kind() == reflect.Array && t.Elem().Kind() == reflect.Uint8
+}
+
+var uuidType = reflect.TypeFor[uuid.UUID]()
+
+func encodeKeyPart(b KeyBuilder, v reflect.Value) KeyBuilder {
+	if v.Type() == uuidType {
+		return b.String(v.Interface().(uuid.UUID).String())
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return b.String(v.String())
+	case reflect.Bool:
+		if v.Bool() {
+			return b.Uint64(1)
+		}
+		return b.Uint64(0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		// Flipping the sign bit sorts negative numbers first.
+		return b.Uint64(uint64(v.Int()) ^ (1 << 63))
+	case reflect.Array:
+		raw := make([]byte, v.Len())
+		reflect.Copy(reflect.ValueOf(raw), v)
+		return b.String(hex.EncodeToString(raw))
+	default:
+		return b.Uint64(v.Uint())
+	}
+}
+
+func decodeKeyPart(part string, v reflect.Value) error {
+	if v.Type() == uuidType {
+		id, err := uuid.Parse(part)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(id))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(part)
+		return nil
+
+	case reflect.Array:
+		raw, err := hex.DecodeString(part)
+		if err != nil {
+			return err
+		}
+		if len(raw) != v.Len() {
+			return fmt.Errorf("expected %d bytes, got %d", v.Len(), len(raw))
+		}
+		reflect.Copy(v, reflect.ValueOf(raw))
+		return nil
+	}
+
+	n, err := ParseUint64Part(part)
+	if err != nil {
+		return err
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if n > 1 {
+			return errors.New("invalid boolean part")
+		}
+		v.SetBool(n == 1)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := int64(n ^ (1 << 63))
+		if v.OverflowInt(i) {
+			return fmt.Errorf("%d overflows %v", i, v.Type())
+		}
+		v.SetInt(i)
+	default:
+		if v.OverflowUint(n) {
+			return fmt.Errorf("%d overflows %v", n, v.Type())
+		}
+		v.SetUint(n)
+	}
+
+	return nil
+}
+
+// Keyed stores values under keys of any comparable type in a string keyed
+// provider, converting them with a KeyCodec.
+type Keyed[K comparable, V any] struct {
+	provider KeyValueProvider[string, V]
+	codec    KeyCodec[K]
+}
+
+// NewKeyed returns provider with keys of type K. A nil codec uses
+// NewKeyCodec.
+func NewKeyed[K comparable, V any](provider KeyValueProvider[string, V], codec KeyCodec[K]) (*Keyed[K, V], error) {
+	if provider == nil {
+		return nil, errors.New("provider is nil")
+	}
+	if codec == nil {
+		var err error
+		if codec, err = NewKeyCodec[K](); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Keyed[K, V]{provider: provider, codec: codec}, nil
+}
+
+// Provider returns the string keyed provider, e.g. for Setup and Shutdown.
+func (k *Keyed[K, V]) Provider() KeyValueProvider[string, V] {
+	return k.provider
+}
+
+func (k *Keyed[K, V]) Store(key K, value V) error {
+	s, err := k.codec.EncodeKey(key)
+	if err != nil {
+		return err
+	}
+
+	return k.provider.Store(s, value)
+}
+
+func (k *Keyed[K, V]) Update(key K, fn func(value V, exists bool) (V, error)) error {
+	s, err := k.codec.EncodeKey(key)
+	if err != nil {
+		return err
+	}
+
+	return k.provider.Update(s, fn)
+}
+
+func (k *Keyed[K, V]) Get(key K) (V, error) {
+	s, err := k.codec.EncodeKey(key)
+	if err != nil {
+		var v V
+		return v, err
+	}
+
+	return k.provider.Get(s)
+}
+
+func (k *Keyed[K, V]) GetMultiple(keys []K) ([]V, error) {
+	encoded := make([]string, 0, len(keys))
+	for _, key := range keys {
+		s, err := k.codec.EncodeKey(key)
+		if err != nil {
+			return []V{}, err
+		}
+		encoded = append(encoded, s)
+	}
+
+	return k.provider.GetMultiple(encoded)
+}
+
+func (k *Keyed[K, V]) Remove(key K) error {
+	s, err := k.codec.EncodeKey(key)
+	if err != nil {
+		return err
+	}
+
+	return k.provider.Remove(s)
+}
+
+func (k *Keyed[K, V]) RemoveWhere(pred func(key K, value V) bool) (int, error) {
+	return k.provider.RemoveWhere(func(s string, value V) bool {
+		key, err := k.codec.DecodeKey(s)
+		return err == nil && pred(key, value)
+	})
+}
+
+// StoreWithReferences stores value and points every reference to key, see
+// the package function. References are plain names, e.g. "email:ann@x.org".
+func (k *Keyed[K, V]) StoreWithReferences(key K, value V, references ...string) error {
+	s, err := k.codec.EncodeKey(key)
+	if err != nil {
+		return err
+	}
+
+	return StoreWithReferences(k.provider, s, value, references...)
+}
+
+func (k *Keyed[K, V]) StoreReference(reference string, key K) error {
+	s, err := k.codec.EncodeKey(key)
+	if err != nil {
+		return err
+	}
+
+	return k.provider.StoreReference(reference, s)
+}
+
+func (k *Keyed[K, V]) AddReference(reference string, key K) error {
+	s, err := k.codec.EncodeKey(key)
+	if err != nil {
+		return err
+	}
+
+	return k.provider.AddReference(reference, s)
+}
+
+func (k *Keyed[K, V]) RemoveReference(reference string) error {
+	return k.provider.RemoveReference(reference)
+}
+
+func (k *Keyed[K, V]) RemoveReferenceTarget(reference string, key K) error {
+	s, err := k.codec.EncodeKey(key)
+	if err != nil {
+		return err
+	}
+
+	return k.provider.RemoveReferenceTarget(reference, s)
+}
+
+func (k *Keyed[K, V]) GetByReference(reference string) (V, error) {
+	return k.provider.GetByReference(reference)
+}
+
+func (k *Keyed[K, V]) GetAllByReference(reference string) ([]V, error) {
+	return k.provider.GetAllByReference(reference)
+}
+
+func (k *Keyed[K, V]) ForEachReference(fn func(reference string, key K) bool) error {
+	var decodeErr error
+	err := k.provider.ForEachReference(func(reference string, s string) bool {
+		key, err := k.codec.DecodeKey(s)
+		if err != nil {
+			decodeErr = err
+			return false
+		}
+		return fn(reference, key)
+	})
+
+	return errors.Join(err, decodeErr)
+}
+
+// ForEach calls fn for every entry. Keys that the codec can not decode, e.g.
+// entries stored under plain string keys, fail the iteration.
+func (k *Keyed[K, V]) ForEach(fn func(key K, value V) bool) error {
+	return k.forEach(func(fn func(string, V) bool) error {
+		return k.provider.ForEach(fn)
+	}, fn)
+}
+
+// ForEachPrefix calls fn for the entries whose encoded key starts with
+// prefix. For keys from NewKeyCodec, NewKey(...).Prefix() selects the keys
+// whose leading parts are the given ones.
+func (k *Keyed[K, V]) ForEachPrefix(prefix string, fn func(key K, value V) bool) error {
+	return k.forEach(func(fn func(string, V) bool) error {
+		return k.provider.ForEachPrefix(prefix, fn)
+	}, fn)
+}
+
+func (k *Keyed[K, V]) ForEachKey(fn func(key K) bool) error {
+	var decodeErr error
+	err := k.provider.ForEachKey(func(s string) bool {
+		key, err := k.codec.DecodeKey(s)
+		if err != nil {
+			decodeErr = err
+			return false
+		}
+		return fn(key)
+	})
+
+	return errors.Join(err, decodeErr)
+}
+
+func (k *Keyed[K, V]) forEach(iterate func(fn func(string, V) bool) error, fn func(key K, value V) bool) error {
+	var decodeErr error
+	err := iterate(func(s string, value V) bool {
+		key, err := k.codec.DecodeKey(s)
+		if err != nil {
+			decodeErr = err
+			return false
+		}
+		return fn(key, value)
+	})
+
+	return errors.Join(err, decodeErr)
+}
@@ -0,0 +1,48 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package keyring
+
+import (
+	baseErrors "errors"
+	"strings"
+)
+
+// Config stores entries in the keychain of the operating system: the macOS
+// Keychain, the Windows Credential Manager or a Secret Service such as
+// GNOME Keyring through libsecret. Every entry is a secret of Service. It is
+// meant for a handful of small secrets per user, e.g. the tokens of a CLI:
+// Windows limits a secret to 2560 bytes, and every operation is a call to
+// the keychain.
+type Config struct {
+	Service  string `yaml:"service"`
+	ReadOnly bool   `yaml:"read_only,omitempty"`
+
+	MaxReferenceDepth int `yaml:"max_reference_depth,omitempty"`
+}
+
+const (
+	// indexUser holds the keys and the references, as keychains can not
+	// list the secrets of a service.
+	indexUser  = "@index"
+	dataPrefix = "k:"
+
+	defaultMaxReferenceDepth = 8
+)
+
+func (c Config) Validate() error {
+	var errs []error
+	if strings.TrimSpace(c.Service) == "" {
+		errs = append(errs, baseErrors.New("service is required"))
+	}
+	if c.MaxReferenceDepth < 0 {
+		errs = append(errs, baseErrors.New("max_reference_depth must not be negative"))
+	}
+
+	return baseErrors.Join(errs...)
+}
@@ -0,0 +1,778 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !nokeyring
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package keyring
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	baseErrors "errors"
+	"fmt"
+	"github.com/rlshukhov/storage/errors"
+	"github.com/rlshukhov/storage/kv"
+	"github.com/zalando/go-keyring"
+	"io"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type index[K comparable] struct {
+	Keys       []K
+	References map[K][]K
+}
+
+type provider[K ~string | ~uint64, V any] struct {
+	cfg       Config
+	lastWrite time.Time
+
+	mu   sync.Mutex
+	open bool
+}
+
+func New[K ~string | ~uint64, V any](cfg Config) (*provider[K, V], error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.MaxReferenceDepth == 0 {
+		cfg.MaxReferenceDepth = defaultMaxReferenceDepth
+	}
+
+	return &provider[K, V]{cfg: cfg}, nil
+}
+
+func (p *provider[K, V]) Setup() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.readIndex(); err != nil {
+		return err
+	}
+
+	p.open = true
+	return nil
+}
+
+func (p *provider[K, V]) Shutdown() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.open = false
+	return nil
+}
+
+func (p *provider[K, V]) Close() error {
+	return p.Shutdown()
+}
+
+func (p *provider[K, V]) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.open {
+		return errors.Closed
+	}
+	_, err := p.readIndex()
+	return err
+}
+
+func (p *provider[K, V]) Stats() (kv.ProviderStats, error) {
+	stats := kv.ProviderStats{
+		Provider: "keyring",
+		Backend:  map[string]any{"service": p.cfg.Service},
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	idx, err := p.index()
+	if err != nil {
+		return stats, err
+	}
+	stats.Entries = uint64(len(idx.Keys))
+	stats.References = uint64(len(idx.References))
+	stats.LastFlush = p.lastWrite
+
+	return stats, nil
+}
+
+// ApproximateSize returns the size of the encoded secrets.
+func (p *provider[K, V]) ApproximateSize() (uint64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	idx, err := p.index()
+	if err != nil {
+		return 0, err
+	}
+
+	raw, err := get(p.cfg.Service, indexUser)
+	if err != nil && !errors.Is(err, errors.NotFound) {
+		return 0, err
+	}
+	size := uint64(len(raw))
+	for _, key := range idx.Keys {
+		raw, err := get(p.cfg.Service, dataUser(key))
+		if err != nil {
+			return 0, err
+		}
+		size += uint64(len(raw))
+	}
+
+	return size, nil
+}
+
+func (p *provider[K, V]) Store(key K, value V) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.writable(); err != nil {
+		return err
+	}
+
+	return p.store(key, value)
+}
+
+func (p *provider[K, V]) StoreIfAbsent(key K, value V) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.writable(); err != nil {
+		return err
+	}
+	if _, err := p.get(key); err == nil {
+		return errors.NewAlreadyExists(fmt.Errorf("key %v", key))
+	} else if !errors.Is(err, errors.NotFound) {
+		return err
+	}
+
+	return p.store(key, value)
+}
+
+func (p *provider[K, V]) StoreIfPresent(key K, value V) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.writable(); err != nil {
+		return err
+	}
+	if _, err := p.get(key); err != nil {
+		return err
+	}
+
+	return p.store(key, value)
+}
+
+func (p *provider[K, V]) Update(key K, fn func(value V, exists bool) (V, error)) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.writable(); err != nil {
+		return err
+	}
+
+	value, err := p.get(key)
+	exists := err == nil
+	if err != nil && !errors.Is(err, errors.NotFound) {
+		return err
+	}
+
+	value, err = fn(value, exists)
+	if err != nil {
+		return err
+	}
+
+	return p.store(key, value)
+}
+
+func (p *provider[K, V]) Get(key K) (V, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.open {
+		var v V
+		return v, errors.Closed
+	}
+
+	return p.get(key)
+}
+
+func (p *provider[K, V]) GetMultiple(keys []K) ([]V, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.open {
+		return []V{}, errors.Closed
+	}
+
+	values := make([]V, 0, len(keys))
+	for _, key := range keys {
+		value, err := p.get(key)
+		if err != nil {
+			return []V{}, err
+		}
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+func (p *provider[K, V]) Remove(key K) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.writable(); err != nil {
+		return err
+	}
+
+	idx, err := p.index()
+	if err != nil {
+		return err
+	}
+	if err := remove(p.cfg.Service, dataUser(key)); err != nil && !errors.Is(err, errors.NotFound) {
+		return err
+	}
+	if i, found := slices.BinarySearch(idx.Keys, key); found {
+		idx.Keys = slices.Delete(idx.Keys, i, i+1)
+		if err := p.writeIndex(idx); err != nil {
+			return err
+		}
+	}
+
+	p.lastWrite = time.Now()
+	return nil
+}
+
+func (p *provider[K, V]) RemovePrefix(prefix K) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.writable(); err != nil {
+		return 0, err
+	}
+
+	return p.removeWhere(func(key K, _ V) bool { return hasPrefix(key, prefix) })
+}
+
+func (p *provider[K, V]) RemoveWhere(pred func(key K, value V) bool) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.writable(); err != nil {
+		return 0, err
+	}
+
+	return p.removeWhere(pred)
+}
+
+func (p *provider[K, V]) Clear() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.writable(); err != nil {
+		return err
+	}
+
+	idx, err := p.index()
+	if err != nil {
+		return err
+	}
+	for _, key := range idx.Keys {
+		if err := remove(p.cfg.Service, dataUser(key)); err != nil && !errors.Is(err, errors.NotFound) {
+			return err
+		}
+	}
+	if err := remove(p.cfg.Service, indexUser); err != nil && !errors.Is(err, errors.NotFound) {
+		return err
+	}
+
+	p.lastWrite = time.Now()
+	return nil
+}
+
+func (p *provider[K, V]) ForEach(fn func(key K, value V) bool) error {
+	return p.forEach(func(K) bool { return true }, fn)
+}
+
+func (p *provider[K, V]) ForEachPrefix(prefix K, fn func(key K, value V) bool) error {
+	return p.forEach(func(key K) bool { return hasPrefix(key, prefix) }, fn)
+}
+
+func (p *provider[K, V]) ForEachKey(fn func(key K) bool) error {
+	idx, err := p.snapshot()
+	if err != nil {
+		return err
+	}
+	for _, key := range idx.Keys {
+		if !fn(key) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (p *provider[K, V]) StoreReference(reference K, key K) error {
+	return p.updateReferences(func(references map[K][]K) error {
+		references[reference] = []K{key}
+		return nil
+	})
+}
+
+func (p *provider[K, V]) AddReference(reference K, key K) error {
+	return p.updateReferences(func(references map[K][]K) error {
+		if !slices.Contains(references[reference], key) {
+			references[reference] = append(references[reference], key)
+		}
+		return nil
+	})
+}
+
+func (p *provider[K, V]) RemoveReference(reference K) error {
+	return p.updateReferences(func(references map[K][]K) error {
+		if _, ok := references[reference]; !ok {
+			return errors.NewNotFound(fmt.Errorf("reference %v", reference))
+		}
+		delete(references, reference)
+		return nil
+	})
+}
+
+func (p *provider[K, V]) RemoveReferenceTarget(reference K, key K) error {
+	return p.updateReferences(func(references map[K][]K) error {
+		targets := references[reference]
+		i := slices.Index(targets, key)
+		if i < 0 {
+			return errors.NotFound
+		}
+
+		if targets = slices.Delete(slices.Clone(targets), i, i+1); len(targets) == 0 {
+			delete(references, reference)
+		} else {
+			references[reference] = targets
+		}
+		return nil
+	})
+}
+
+func (p *provider[K, V]) GetByReference(reference K) (V, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	keys, err := p.resolve(reference)
+	if err != nil {
+		var v V
+		return v, err
+	}
+
+	return p.get(keys[0])
+}
+
+func (p *provider[K, V]) GetAllByReference(reference K) ([]V, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	keys, err := p.resolve(reference)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []V
+	for _, key := range keys {
+		value, err := p.get(key)
+		if errors.Is(err, errors.NotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+func (p *provider[K, V]) ForEachReference(fn func(reference K, key K) bool) error {
+	idx, err := p.snapshot()
+	if err != nil {
+		return err
+	}
+	for _, reference := range sortedKeys(idx.References) {
+		for _, key := range idx.References[reference] {
+			if !fn(reference, key) {
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *provider[K, V]) Verify() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	idx, err := p.index()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, key := range idx.Keys {
+		if _, err := p.get(key); err != nil {
+			errs = append(errs, fmt.Errorf("key %v: %w", key, err))
+		}
+	}
+
+	return baseErrors.Join(errs...)
+}
+
+type backupRecord[K any, V any] struct {
+	Key       K  `json:"key"`
+	Value     *V `json:"value,omitempty"`
+	Reference *K `json:"reference,omitempty"`
+}
+
+func (p *provider[K, V]) Backup(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	idx, err := p.index()
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	for _, key := range idx.Keys {
+		value, err := p.get(key)
+		if errors.Is(err, errors.NotFound) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := enc.Encode(backupRecord[K, V]{Key: key, Value: &value}); err != nil {
+			return err
+		}
+	}
+	for _, reference := range sortedKeys(idx.References) {
+		for _, key := range idx.References[reference] {
+			if err := enc.Encode(backupRecord[K, V]{Key: key, Reference: &reference}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// forEach reads the matching values one at a time and calls fn without
+// holding the lock, so fn may use the provider.
+func (p *provider[K, V]) forEach(match func(key K) bool, fn func(key K, value V) bool) error {
+	idx, err := p.snapshot()
+	if err != nil {
+		return err
+	}
+	for _, key := range idx.Keys {
+		if !match(key) {
+			continue
+		}
+
+		value, err := p.Get(key)
+		if errors.Is(err, errors.NotFound) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if !fn(key, value) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (p *provider[K, V]) snapshot() (index[K], error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.index()
+}
+
+func (p *provider[K, V]) writable() error {
+	if !p.open {
+		return errors.Closed
+	}
+	if p.cfg.ReadOnly {
+		return errors.ReadOnly
+	}
+
+	return nil
+}
+
+func (p *provider[K, V]) get(key K) (V, error) {
+	raw, err := get(p.cfg.Service, dataUser(key))
+	if err != nil {
+		var v V
+		return v, err
+	}
+
+	return decode[V](raw)
+}
+
+func (p *provider[K, V]) store(key K, value V) error {
+	raw, err := encode(value)
+	if err != nil {
+		return err
+	}
+	if err := set(p.cfg.Service, dataUser(key), raw); err != nil {
+		return err
+	}
+
+	idx, err := p.index()
+	if err != nil {
+		return err
+	}
+	i, found := slices.BinarySearch(idx.Keys, key)
+	if !found {
+		idx.Keys = slices.Insert(idx.Keys, i, key)
+		if err := p.writeIndex(idx); err != nil {
+			return err
+		}
+	}
+
+	p.lastWrite = time.Now()
+	return nil
+}
+
+func (p *provider[K, V]) removeWhere(pred func(key K, value V) bool) (int, error) {
+	idx, err := p.index()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	kept := idx.Keys[:0]
+	for _, key := range idx.Keys {
+		value, err := p.get(key)
+		if err != nil && !errors.Is(err, errors.NotFound) {
+			return removed, err
+		}
+		if err == nil && !pred(key, value) {
+			kept = append(kept, key)
+			continue
+		}
+		if err := remove(p.cfg.Service, dataUser(key)); err != nil && !errors.Is(err, errors.NotFound) {
+			return removed, err
+		}
+		removed++
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+
+	idx.Keys = kept
+	if err := p.writeIndex(idx); err != nil {
+		return removed, err
+	}
+
+	p.lastWrite = time.Now()
+	return removed, nil
+}
+
+func (p *provider[K, V]) updateReferences(fn func(references map[K][]K) error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.writable(); err != nil {
+		return err
+	}
+
+	idx, err := p.index()
+	if err != nil {
+		return err
+	}
+	if idx.References == nil {
+		idx.References = map[K][]K{}
+	}
+	if err := fn(idx.References); err != nil {
+		return err
+	}
+	if err := p.writeIndex(idx); err != nil {
+		return err
+	}
+
+	p.lastWrite = time.Now()
+	return nil
+}
+
+func (p *provider[K, V]) resolve(reference K) ([]K, error) {
+	if !p.open {
+		return nil, errors.Closed
+	}
+
+	idx, err := p.index()
+	if err != nil {
+		return nil, err
+	}
+
+	return resolve(idx.References, reference, p.cfg.MaxReferenceDepth, 0, map[K]bool{})
+}
+
+func resolve[K comparable](references map[K][]K, reference K, maxDepth, depth int, path map[K]bool) ([]K, error) {
+	if path[reference] {
+		return nil, errors.NewReferenceCycle(fmt.Errorf("reference %v", reference))
+	}
+	if depth >= maxDepth {
+		return nil, errors.NewReferenceTooDeep(fmt.Errorf("reference %v exceeds depth %d", reference, maxDepth))
+	}
+
+	targets := references[reference]
+	if len(targets) == 0 {
+		return nil, errors.NewNotFound(fmt.Errorf("reference %v", reference))
+	}
+
+	path[reference] = true
+	defer delete(path, reference)
+
+	var keys []K
+	for _, target := range targets {
+		if len(references[target]) == 0 {
+			keys = append(keys, target)
+			continue
+		}
+
+		resolved, err := resolve(references, target, maxDepth, depth+1, path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, resolved...)
+	}
+
+	return keys, nil
+}
+
+func (p *provider[K, V]) index() (index[K], error) {
+	if !p.open {
+		return index[K]{}, errors.Closed
+	}
+
+	return p.readIndex()
+}
+
+func (p *provider[K, V]) readIndex() (index[K], error) {
+	raw, err := get(p.cfg.Service, indexUser)
+	if errors.Is(err, errors.NotFound) {
+		return index[K]{}, nil
+	} else if err != nil {
+		return index[K]{}, err
+	}
+
+	return decode[index[K]](raw)
+}
+
+func (p *provider[K, V]) writeIndex(idx index[K]) error {
+	if len(idx.Keys) == 0 && len(idx.References) == 0 {
+		if err := remove(p.cfg.Service, indexUser); err != nil && !errors.Is(err, errors.NotFound) {
+			return err
+		}
+		return nil
+	}
+
+	raw, err := encode(idx)
+	if err != nil {
+		return err
+	}
+
+	return set(p.cfg.Service, indexUser, raw)
+}
+
+func dataUser[K ~string | ~uint64](key K) string {
+	return dataPrefix + keyString(key)
+}
+
+func hasPrefix[K ~string | ~uint64](key, prefix K) bool {
+	return strings.HasPrefix(keyString(key), keyString(prefix))
+}
+
+func sortedKeys[K ~string | ~uint64, T any](m map[K]T) []K {
+	keys := make([]K, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	return keys
+}
+
+func keyString[K ~string | ~uint64](key K) string {
+	v := reflect.ValueOf(key)
+	if v.Kind() == reflect.Uint64 {
+		return strconv.FormatUint(v.Uint(), 10)
+	}
+
+	return v.String()
+}
+
+func get(service, user string) (string, error) {
+	raw, err := keyring.Get(service, user)
+	return raw, mapError(err)
+}
+
+func set(service, user, raw string) error {
+	return mapError(keyring.Set(service, user, raw))
+}
+
+func remove(service, user string) error {
+	return mapError(keyring.Delete(service, user))
+}
+
+func encode[T any](value T) (string, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func decode[T any](raw string) (T, error) {
+	var value T
+	data, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return value, errors.NewCorrupted(err)
+	}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value); err != nil {
+		return value, errors.NewCorrupted(err)
+	}
+
+	return value, nil
+}
+
+func mapError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case baseErrors.Is(err, keyring.ErrNotFound):
+		return errors.NewNotFound(err)
+	case baseErrors.Is(err, keyring.ErrSetDataTooBig):
+		return fmt.Errorf("value is too large for the keychain: %w", err)
+	default:
+		return errors.NewUnavailable(err)
+	}
+}
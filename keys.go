@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	keySeparator byte = 0x00
+	keyEscape    byte = 0x01
+	uint64Digits      = 16
+)
+
+// KeyBuilder builds composite keys that sort like their parts: strings byte
+// by byte, uint64 numerically, and shorter keys before the keys they prefix.
+// Parts are separated by a zero byte, so keys stay valid UTF-8 whenever the
+// parts are.
+type KeyBuilder struct {
+	buf   []byte
+	parts int
+}
+
+func NewKey(parts ...string) KeyBuilder {
+	var b KeyBuilder
+	for _, part := range parts {
+		b = b.String(part)
+	}
+
+	return b
+}
+
+func (b KeyBuilder) String(part string) KeyBuilder {
+	buf := b.separate()
+	for i := 0; i < len(part); i++ {
+		switch part[i] {
+		case keySeparator:
+			buf = append(buf, keyEscape, 0x01)
+		case keyEscape:
+			buf = append(buf, keyEscape, 0x02)
+		default:
+			buf = append(buf, part[i])
+		}
+	}
+
+	return KeyBuilder{buf: buf, parts: b.parts + 1}
+}
+
+// Uint64 appends n as fixed-width hexadecimal, which keeps numeric order.
+func (b KeyBuilder) Uint64(n uint64) KeyBuilder {
+	buf := b.separate()
+	digits := strconv.FormatUint(n, 16)
+	for i := len(digits); i < uint64Digits; i++ {
+		buf = append(buf, '0')
+	}
+
+	return KeyBuilder{buf: append(buf, digits...), parts: b.parts + 1}
+}
+
+func (b KeyBuilder) Key() string {
+	return string(b.buf)
+}
+
+// Prefix returns the prefix shared by every key built by appending more
+// parts, for ForEachPrefix and RemovePrefix.
+func (b KeyBuilder) Prefix() string {
+	return string(b.buf) + string(keySeparator)
+}
+
+func (b KeyBuilder) separate() []byte {
+	buf := slices.Clip(b.buf)
+	if b.parts > 0 {
+		buf = append(buf, keySeparator)
+	}
+
+	return buf
+}
+
+// SplitKey returns the parts of a key built by KeyBuilder. Uint64 parts are
+// returned in their encoded form, see ParseUint64Part.
+func SplitKey(key string) ([]string, error) {
+	var parts []string
+	var part strings.Builder
+	for i := 0; i < len(key); i++ {
+		switch key[i] {
+		case keySeparator:
+			parts = append(parts, part.String())
+			part.Reset()
+		case keyEscape:
+			if i+1 >= len(key) || (key[i+1] != 0x01 && key[i+1] != 0x02) {
+				return nil, fmt.Errorf("invalid escape at byte %d of key %q", i, key)
+			}
+			part.WriteByte(key[i+1] - 1)
+			i++
+		default:
+			part.WriteByte(key[i])
+		}
+	}
+
+	return append(parts, part.String()), nil
+}
+
+func ParseUint64Part(part string) (uint64, error) {
+	if len(part) != uint64Digits {
+		return 0, errors.New("uint64 key part must have 16 hexadecimal digits")
+	}
+
+	return strconv.ParseUint(part, 16, 64)
+}
@@ -0,0 +1,33 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package kv
+
+import "fmt"
+
+// Consistency is what reads of a provider that holds writes back, e.g. in a
+// write queue, guarantee about the writes that returned before them. The
+// empty value is Strict.
+type Consistency string
+
+const (
+	// Strict reads observe every write that returned before them; a Get
+	// after a Store sees the stored value. Reads commit held back writes
+	// first.
+	Strict Consistency = "strict"
+	// Eventual reads observe held back writes only once they are committed.
+	Eventual Consistency = "eventual"
+)
+
+func (c Consistency) Validate() error {
+	if c != "" && c != Strict && c != Eventual {
+		return fmt.Errorf("consistency must be %q or %q, got %q", Strict, Eventual, c)
+	}
+
+	return nil
+}
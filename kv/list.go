@@ -0,0 +1,36 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package kv
+
+import (
+	"encoding/base64"
+	"errors"
+)
+
+type Entry[K any, V any] struct {
+	Key   K `yaml:"key" json:"key"`
+	Value V `yaml:"value" json:"value"`
+}
+
+// Cursor is an opaque position in a listing. The empty Cursor starts at the
+// first key and is returned after the last page.
+type Cursor string
+
+func NewCursor(position []byte) Cursor {
+	return Cursor(base64.RawURLEncoding.EncodeToString(position))
+}
+
+func (c Cursor) Position() ([]byte, error) {
+	position, err := base64.RawURLEncoding.DecodeString(string(c))
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	return position, nil
+}
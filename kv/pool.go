@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package kv
+
+import (
+	"errors"
+	"time"
+)
+
+// PoolConfig configures the connection pool of a remote provider. Zero
+// values keep the defaults of the backend.
+type PoolConfig struct {
+	MaxOpen     int           `yaml:"max_open,omitempty"`
+	MaxIdle     int           `yaml:"max_idle,omitempty"`
+	IdleTimeout time.Duration `yaml:"idle_timeout,omitempty"`
+	MaxLifetime time.Duration `yaml:"max_lifetime,omitempty"`
+	// WaitTimeout bounds how long an operation waits for a free connection
+	// once MaxOpen are in use. It then fails with errors.Unavailable. Zero
+	// waits for as long as the operation may take.
+	WaitTimeout time.Duration `yaml:"wait_timeout,omitempty"`
+}
+
+func (c PoolConfig) Validate() error {
+	var errs []error
+	if c.MaxOpen < 0 {
+		errs = append(errs, errors.New("pool.max_open must not be negative"))
+	}
+	if c.MaxIdle < 0 {
+		errs = append(errs, errors.New("pool.max_idle must not be negative"))
+	}
+	if c.MaxOpen > 0 && c.MaxIdle > c.MaxOpen {
+		errs = append(errs, errors.New("pool.max_idle must not exceed pool.max_open"))
+	}
+	if c.IdleTimeout < 0 {
+		errs = append(errs, errors.New("pool.idle_timeout must not be negative"))
+	}
+	if c.MaxLifetime < 0 {
+		errs = append(errs, errors.New("pool.max_lifetime must not be negative"))
+	}
+	if c.WaitTimeout < 0 {
+		errs = append(errs, errors.New("pool.wait_timeout must not be negative"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// PoolStats is the usage of a connection pool. MaxOpen is 0 for an
+// unlimited pool.
+type PoolStats struct {
+	MaxOpen      int           `yaml:"max_open" json:"max_open"`
+	Open         int           `yaml:"open" json:"open"`
+	InUse        int           `yaml:"in_use" json:"in_use"`
+	Idle         int           `yaml:"idle" json:"idle"`
+	WaitCount    int64         `yaml:"wait_count" json:"wait_count"`
+	WaitDuration time.Duration `yaml:"wait_duration" json:"wait_duration"`
+	// WaitTimeouts counts the operations that failed because no connection
+	// became free within WaitTimeout.
+	WaitTimeouts uint64 `yaml:"wait_timeouts" json:"wait_timeouts"`
+}
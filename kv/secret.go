@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	secretFile  = "file:"
+	secretVault = "vault:"
+	secretEnv   = "env:"
+)
+
+// HasSecrets reports whether s holds a secret reference that ResolveSecrets
+// would replace.
+func HasSecrets(s string) bool {
+	return strings.HasPrefix(s, secretFile+"/") || strings.HasPrefix(s, secretVault) || strings.Contains(s, "${")
+}
+
+// ResolveSecrets returns s with its secret references replaced, so
+// credentials can be kept out of config files:
+//
+//   - file:/run/secrets/x as the whole value is the content of the file,
+//     without a trailing newline
+//   - vault:secret/data/app#password as the whole value is a field of a
+//     Vault secret, read with VAULT_ADDR and VAULT_TOKEN
+//   - ${env:VAR}, ${file:/path} and ${vault:path#field} are replaced anywhere
+//     in s, e.g. in the password of a DSN; $${ is a literal ${
+func ResolveSecrets(ctx context.Context, s string) (string, error) {
+	if strings.HasPrefix(s, secretFile+"/") || strings.HasPrefix(s, secretVault) {
+		return resolveSecret(ctx, s)
+	}
+
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i-1])
+			b.WriteString("${")
+			s = s[i+2:]
+			continue
+		}
+
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated secret reference %q", s[i:])
+		}
+		value, err := resolveSecret(ctx, s[i+2:i+end])
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(s[:i])
+		b.WriteString(value)
+		s = s[i+end+1:]
+	}
+}
+
+func resolveSecret(ctx context.Context, ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, secretEnv):
+		name := strings.TrimPrefix(ref, secretEnv)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("secret %s: environment variable %s is not set", ref, name)
+		}
+		return value, nil
+
+	case strings.HasPrefix(ref, secretFile):
+		raw, err := os.ReadFile(strings.TrimPrefix(ref, secretFile))
+		if err != nil {
+			return "", fmt.Errorf("secret %s: %w", ref, err)
+		}
+		return strings.TrimRight(string(raw), "\r\n"), nil
+
+	case strings.HasPrefix(ref, secretVault):
+		value, err := readVault(ctx, strings.TrimPrefix(ref, secretVault))
+		if err != nil {
+			return "", fmt.Errorf("secret %s: %w", ref, err)
+		}
+		return value, nil
+
+	default:
+		return "", fmt.Errorf("unsupported secret reference %q: expected env:, file: or vault:", ref)
+	}
+}
+
+// readVault reads a field of a secret from the Vault HTTP API. Both KV
+// version 1 and version 2 secrets are supported.
+func readVault(ctx context.Context, ref string) (string, error) {
+	path, field, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || field == "" {
+		return "", errors.New("expected vault:<path>#<field>")
+	}
+
+	addr, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		return "", errors.New("VAULT_ADDR and VAULT_TOKEN must be set")
+	}
+	endpoint, err := url.JoinPath(addr, "v1", strings.TrimPrefix(path, "/"))
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", storageErrors.NewUnavailable(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("vault returned %s", resp.Status)
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return "", storageErrors.NewUnavailable(err)
+		}
+		return "", err
+	}
+
+	var body struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	data := body.Data
+	if inner, ok := data["data"].(map[string]any); ok {
+		if _, v2 := data["metadata"]; v2 {
+			data = inner
+		}
+	}
+
+	switch value := data[field].(type) {
+	case string:
+		return value, nil
+	case nil:
+		return "", fmt.Errorf("field %q not found", field)
+	default:
+		return "", fmt.Errorf("field %q is not a string", field)
+	}
+}
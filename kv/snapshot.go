@@ -0,0 +1,19 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package kv
+
+// Snapshot is a read-only view of a provider at one point in time. Writes to
+// the provider after it was taken are not visible through it. Close releases
+// what the snapshot holds on to, e.g. old versions kept by the backend.
+type Snapshot[K any, V any] interface {
+	Get(key K) (V, error)
+	ForEach(fn func(key K, value V) bool) error
+	ForEachPrefix(prefix K, fn func(key K, value V) bool) error
+	Close() error
+}
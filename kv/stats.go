@@ -0,0 +1,23 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package kv
+
+import "time"
+
+type ProviderStats struct {
+	Provider   string         `yaml:"provider" json:"provider"`
+	Entries    uint64         `yaml:"entries" json:"entries"`
+	References uint64         `yaml:"references" json:"references"`
+	DiskUsage  int64          `yaml:"disk_usage" json:"disk_usage"`
+	LastFlush  time.Time      `yaml:"last_flush,omitempty" json:"last_flush,omitempty"`
+	Backend    map[string]any `yaml:"backend,omitempty" json:"backend,omitempty"`
+
+	// Pool is set by providers with a connection pool.
+	Pool *PoolStats `yaml:"pool,omitempty" json:"pool,omitempty"`
+}
@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package kv
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TLSConfig configures TLS for a network connection. An empty block enables
+// TLS with the system roots. CertFile and KeyFile add a client certificate
+// for mutual TLS, or the certificate of a server.
+type TLSConfig struct {
+	CAFile             string `yaml:"ca_file,omitempty"`
+	CertFile           string `yaml:"cert_file,omitempty"`
+	KeyFile            string `yaml:"key_file,omitempty"`
+	ServerName         string `yaml:"server_name,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"`
+}
+
+func (c TLSConfig) Validate() error {
+	var errs []error
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		errs = append(errs, errors.New("tls.cert_file and tls.key_file must be set together"))
+	}
+	if c.InsecureSkipVerify && c.CAFile != "" {
+		errs = append(errs, errors.New("tls.ca_file has no effect with tls.insecure_skip_verify"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Client returns the tls.Config to dial servers with. Files are read on each
+// call, so renewed certificates are picked up on the next Setup.
+func (c TLSConfig) Client() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	var err error
+	if cfg.RootCAs, err = c.pool(); err != nil {
+		return nil, err
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// Server returns the tls.Config to serve with. CertFile and KeyFile are
+// required. With CAFile set, clients must present a certificate signed by it.
+func (c TLSConfig) Server() (*tls.Config, error) {
+	if c.CertFile == "" {
+		return nil, errors.New("tls: cert_file and key_file are required to serve")
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if cfg.ClientCAs, err = c.pool(); err != nil {
+		return nil, err
+	}
+	if cfg.ClientCAs != nil {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
+
+func (c TLSConfig) pool() (*x509.CertPool, error) {
+	if c.CAFile == "" {
+		return nil, nil
+	}
+
+	raw, err := os.ReadFile(c.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(raw) {
+		return nil, fmt.Errorf("tls: no certificates found in %s", c.CAFile)
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,27 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package kv
+
+import "context"
+
+type watchReadyKey struct{}
+
+// WithWatchReady returns a context for Watch that calls ready once the watch
+// is registered, so every change made after ready is reported. Watchers call
+// it before they report changes or, when they cannot miss changes, as soon as
+// Watch starts.
+func WithWatchReady(ctx context.Context, ready func()) context.Context {
+	return context.WithValue(ctx, watchReadyKey{}, ready)
+}
+
+// WatchReady returns the ready callback of ctx, or nil.
+func WatchReady(ctx context.Context) func() {
+	ready, _ := ctx.Value(watchReadyKey{}).(func())
+	return ready
+}
@@ -0,0 +1,494 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"context"
+	"errors"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"github.com/rlshukhov/storage/kv"
+	"io"
+	"sync"
+	"time"
+)
+
+type LazyConfig struct {
+	InitialBackoff time.Duration `yaml:"initial_backoff,omitempty"`
+	MaxBackoff     time.Duration `yaml:"max_backoff,omitempty"`
+}
+
+func (c LazyConfig) Validate() error {
+	var errs []error
+	if c.InitialBackoff < 0 {
+		errs = append(errs, errors.New("initial_backoff must not be negative"))
+	}
+	if c.MaxBackoff < 0 {
+		errs = append(errs, errors.New("max_backoff must not be negative"))
+	}
+	if c.InitialBackoff > 0 && c.MaxBackoff > 0 && c.MaxBackoff < c.InitialBackoff {
+		errs = append(errs, errors.New("max_backoff must not be less than initial_backoff"))
+	}
+
+	return errors.Join(errs...)
+}
+
+type ConnectionState string
+
+const (
+	Disconnected ConnectionState = "disconnected"
+	Connected    ConnectionState = "connected"
+	ShutDown     ConnectionState = "shutdown"
+)
+
+const (
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 30 * time.Second
+)
+
+type lazyProvider[K ~string | ~uint64, V any] struct {
+	inner KeyValueProvider[K, V]
+	cfg   LazyConfig
+
+	mu          sync.Mutex
+	state       ConnectionState
+	lastErr     error
+	backoff     time.Duration
+	nextAttempt time.Time
+}
+
+func newLazyProvider[K ~string | ~uint64, V any](inner KeyValueProvider[K, V], cfg LazyConfig) KeyValueProvider[K, V] {
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = defaultInitialBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = defaultMaxBackoff
+	}
+	if cfg.MaxBackoff < cfg.InitialBackoff {
+		cfg.MaxBackoff = cfg.InitialBackoff
+	}
+
+	p := &lazyProvider[K, V]{
+		inner:   inner,
+		cfg:     cfg,
+		state:   Disconnected,
+		backoff: cfg.InitialBackoff,
+	}
+
+	// Callers check for Watcher and Expirer to pick a fallback, so they are
+	// only implemented if inner implements them.
+	_, watches := inner.(Watcher[K, V])
+	_, expires := inner.(Expirer[K, V])
+	switch {
+	case watches && expires:
+		return &lazyWatcherExpirer[K, V]{p}
+	case watches:
+		return &lazyWatcher[K, V]{p}
+	case expires:
+		return &lazyExpirer[K, V]{p}
+	default:
+		return p
+	}
+}
+
+type lazyWatcher[K ~string | ~uint64, V any] struct {
+	*lazyProvider[K, V]
+}
+
+func (p *lazyWatcher[K, V]) Watch(ctx context.Context, prefix K, fn func(key K, value V, removed bool)) error {
+	return p.watch(ctx, prefix, fn)
+}
+
+type lazyExpirer[K ~string | ~uint64, V any] struct {
+	*lazyProvider[K, V]
+}
+
+func (p *lazyExpirer[K, V]) StoreWithTTL(key K, value V, ttl time.Duration) error {
+	return p.storeWithTTL(key, value, ttl)
+}
+
+type lazyWatcherExpirer[K ~string | ~uint64, V any] struct {
+	*lazyProvider[K, V]
+}
+
+func (p *lazyWatcherExpirer[K, V]) Watch(ctx context.Context, prefix K, fn func(key K, value V, removed bool)) error {
+	return p.watch(ctx, prefix, fn)
+}
+
+func (p *lazyWatcherExpirer[K, V]) StoreWithTTL(key K, value V, ttl time.Duration) error {
+	return p.storeWithTTL(key, value, ttl)
+}
+
+func (p *lazyProvider[K, V]) State() ConnectionState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.state
+}
+
+func (p *lazyProvider[K, V]) Setup() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state == ShutDown {
+		p.state = Disconnected
+		p.backoff = p.cfg.InitialBackoff
+		p.nextAttempt = time.Time{}
+	}
+
+	_ = p.connect()
+	return nil
+}
+
+func (p *lazyProvider[K, V]) Shutdown() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state := p.state
+	p.state = ShutDown
+	if state != Connected {
+		return nil
+	}
+
+	return p.inner.Shutdown()
+}
+
+func (p *lazyProvider[K, V]) Close() error {
+	return p.Shutdown()
+}
+
+func (p *lazyProvider[K, V]) Ping(ctx context.Context) error {
+	return p.call(func() error {
+		return p.inner.Ping(ctx)
+	})
+}
+
+func (p *lazyProvider[K, V]) Stats() (ProviderStats, error) {
+	p.mu.Lock()
+	state, lastErr := p.state, p.lastErr
+	p.mu.Unlock()
+
+	if state != Connected {
+		stats := ProviderStats{
+			Backend: map[string]any{
+				"connection_state": string(state),
+			},
+		}
+		if lastErr != nil {
+			stats.Backend["last_error"] = lastErr.Error()
+		}
+		return stats, nil
+	}
+
+	stats, err := lazyCall(p, p.inner.Stats)
+	if stats.Backend == nil {
+		stats.Backend = map[string]any{}
+	}
+	stats.Backend["connection_state"] = string(Connected)
+
+	return stats, err
+}
+
+func (p *lazyProvider[K, V]) ApproximateSize() (uint64, error) {
+	return lazyCall(p, p.inner.ApproximateSize)
+}
+
+func (p *lazyProvider[K, V]) Store(key K, value V) error {
+	return p.call(func() error {
+		return p.inner.Store(key, value)
+	})
+}
+
+func (p *lazyProvider[K, V]) Update(key K, fn func(value V, exists bool) (V, error)) error {
+	return p.call(func() error {
+		return p.inner.Update(key, fn)
+	})
+}
+
+func (p *lazyProvider[K, V]) Get(key K) (V, error) {
+	return lazyCall(p, func() (V, error) {
+		return p.inner.Get(key)
+	})
+}
+
+func (p *lazyProvider[K, V]) GetInto(key K, dst *V) error {
+	return p.call(func() error {
+		return GetInto(p.inner, key, dst)
+	})
+}
+
+func (p *lazyProvider[K, V]) Remove(key K) error {
+	return p.call(func() error {
+		return p.inner.Remove(key)
+	})
+}
+
+func (p *lazyProvider[K, V]) RemovePrefix(prefix K) (int, error) {
+	return lazyCall(p, func() (int, error) {
+		return p.inner.RemovePrefix(prefix)
+	})
+}
+
+func (p *lazyProvider[K, V]) RemoveWhere(pred func(key K, value V) bool) (int, error) {
+	return lazyCall(p, func() (int, error) {
+		return p.inner.RemoveWhere(pred)
+	})
+}
+
+func (p *lazyProvider[K, V]) ReencodeAll() (int, error) {
+	return lazyCall(p, func() (int, error) {
+		return ReencodeAll(p.inner)
+	})
+}
+
+func (p *lazyProvider[K, V]) MigrateKeys() (int, error) {
+	return lazyCall(p, func() (int, error) {
+		return MigrateKeys(p.inner)
+	})
+}
+
+func (p *lazyProvider[K, V]) List(limit int, cursor Cursor) ([]kv.Entry[K, V], Cursor, error) {
+	var next Cursor
+	entries, err := lazyCall(p, func() ([]kv.Entry[K, V], error) {
+		entries, cursor, err := List(p.inner, limit, cursor)
+		next = cursor
+		return entries, err
+	})
+
+	return entries, next, err
+}
+
+func (p *lazyProvider[K, V]) ForEachParallel(workers int, fn func(key K, value V) error) error {
+	return p.call(func() error {
+		return ForEachParallel(p.inner, workers, fn)
+	})
+}
+
+func (p *lazyProvider[K, V]) Clear() error {
+	return p.call(p.inner.Clear)
+}
+
+func (p *lazyProvider[K, V]) ForEach(fn func(key K, value V) bool) error {
+	return p.call(func() error {
+		return p.inner.ForEach(fn)
+	})
+}
+
+func (p *lazyProvider[K, V]) ForEachPrefix(prefix K, fn func(key K, value V) bool) error {
+	return p.call(func() error {
+		return p.inner.ForEachPrefix(prefix, fn)
+	})
+}
+
+func (p *lazyProvider[K, V]) ForEachKey(fn func(key K) bool) error {
+	return p.call(func() error {
+		return p.inner.ForEachKey(fn)
+	})
+}
+
+func (p *lazyProvider[K, V]) GetMultiple(keys []K) ([]V, error) {
+	return lazyCall(p, func() ([]V, error) {
+		return p.inner.GetMultiple(keys)
+	})
+}
+
+func (p *lazyProvider[K, V]) StoreReference(reference K, key K) error {
+	return p.call(func() error {
+		return p.inner.StoreReference(reference, key)
+	})
+}
+
+func (p *lazyProvider[K, V]) AddReference(reference K, key K) error {
+	return p.call(func() error {
+		return p.inner.AddReference(reference, key)
+	})
+}
+
+func (p *lazyProvider[K, V]) RemoveReference(reference K) error {
+	return p.call(func() error {
+		return p.inner.RemoveReference(reference)
+	})
+}
+
+func (p *lazyProvider[K, V]) RemoveReferenceTarget(reference K, key K) error {
+	return p.call(func() error {
+		return p.inner.RemoveReferenceTarget(reference, key)
+	})
+}
+
+func (p *lazyProvider[K, V]) GetByReference(reference K) (V, error) {
+	return lazyCall(p, func() (V, error) {
+		return p.inner.GetByReference(reference)
+	})
+}
+
+func (p *lazyProvider[K, V]) GetAllByReference(reference K) ([]V, error) {
+	return lazyCall(p, func() ([]V, error) {
+		return p.inner.GetAllByReference(reference)
+	})
+}
+
+func (p *lazyProvider[K, V]) ForEachReference(fn func(reference K, key K) bool) error {
+	return p.call(func() error {
+		return p.inner.ForEachReference(fn)
+	})
+}
+
+func (p *lazyProvider[K, V]) Verify() error {
+	return p.call(p.inner.Verify)
+}
+
+func (p *lazyProvider[K, V]) Backup(w io.Writer) error {
+	return p.call(func() error {
+		return p.inner.Backup(w)
+	})
+}
+
+func (p *lazyProvider[K, V]) Restore(r io.Reader) error {
+	return p.call(func() error {
+		return Restore(p.inner, r)
+	})
+}
+
+func (p *lazyProvider[K, V]) StoreWithReferences(key K, value V, references ...K) error {
+	return p.call(func() error {
+		return StoreWithReferences(p.inner, key, value, references...)
+	})
+}
+
+func (p *lazyProvider[K, V]) RemoveWithReferences(keys []K, references []K) error {
+	return p.call(func() error {
+		return RemoveWithReferences(p.inner, keys, references)
+	})
+}
+
+func (p *lazyProvider[K, V]) StoreIfAbsent(key K, value V) error {
+	return p.call(func() error {
+		return StoreIfAbsent(p.inner, key, value)
+	})
+}
+
+func (p *lazyProvider[K, V]) StoreIfPresent(key K, value V) error {
+	return p.call(func() error {
+		return StoreIfPresent(p.inner, key, value)
+	})
+}
+
+func (p *lazyProvider[K, V]) Flush() error {
+	return p.call(func() error {
+		return Flush(p.inner)
+	})
+}
+
+func (p *lazyProvider[K, V]) Maintain(ctx context.Context) error {
+	return p.call(func() error {
+		return Maintain(ctx, p.inner)
+	})
+}
+
+// OnExpire registers fn without setting the provider up.
+func (p *lazyProvider[K, V]) OnExpire(fn func(key K, value V)) error {
+	return OnExpire(p.inner, fn)
+}
+
+func (p *lazyProvider[K, V]) SweepExpired() (int, error) {
+	return lazyCall(p, func() (int, error) {
+		return SweepExpired(p.inner)
+	})
+}
+
+func (p *lazyProvider[K, V]) OpenSnapshot() (kv.Snapshot[K, V], error) {
+	return lazyCall(p, func() (kv.Snapshot[K, V], error) {
+		return Snapshot(p.inner)
+	})
+}
+
+func (p *lazyProvider[K, V]) Preload(keys []K) (int, error) {
+	return lazyCall(p, func() (int, error) {
+		return Preload(p.inner, keys)
+	})
+}
+
+func (p *lazyProvider[K, V]) PreloadPrefix(prefix K) (int, error) {
+	return lazyCall(p, func() (int, error) {
+		return PreloadPrefix(p.inner, prefix)
+	})
+}
+
+func (p *lazyProvider[K, V]) watch(ctx context.Context, prefix K, fn func(key K, value V, removed bool)) error {
+	return p.call(func() error {
+		return p.inner.(Watcher[K, V]).Watch(ctx, prefix, fn)
+	})
+}
+
+func (p *lazyProvider[K, V]) storeWithTTL(key K, value V, ttl time.Duration) error {
+	return p.call(func() error {
+		return p.inner.(Expirer[K, V]).StoreWithTTL(key, value, ttl)
+	})
+}
+
+func (p *lazyProvider[K, V]) call(fn func() error) error {
+	_, err := lazyCall(p, func() (struct{}, error) {
+		return struct{}{}, fn()
+	})
+
+	return err
+}
+
+func lazyCall[K ~string | ~uint64, V any, T any](p *lazyProvider[K, V], fn func() (T, error)) (T, error) {
+	p.mu.Lock()
+	err := p.connect()
+	p.mu.Unlock()
+
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	result, err := fn()
+	if storageErrors.Is(err, storageErrors.Closed) || storageErrors.Is(err, storageErrors.Unavailable) {
+		p.mu.Lock()
+		if p.state == Connected {
+			p.state = Disconnected
+			p.lastErr = err
+			p.backoff = p.cfg.InitialBackoff
+			p.nextAttempt = time.Time{}
+		}
+		p.mu.Unlock()
+	}
+
+	return result, err
+}
+
+func (p *lazyProvider[K, V]) connect() error {
+	switch p.state {
+	case Connected:
+		return nil
+	case ShutDown:
+		return storageErrors.Closed
+	}
+
+	now := time.Now()
+	if now.Before(p.nextAttempt) {
+		return storageErrors.NewUnavailable(p.lastErr)
+	}
+
+	if err := p.inner.Setup(); err != nil {
+		p.lastErr = err
+		p.nextAttempt = now.Add(p.backoff)
+		p.backoff = min(p.backoff*2, p.cfg.MaxBackoff)
+		return storageErrors.NewUnavailable(err)
+	}
+
+	p.state = Connected
+	p.lastErr = nil
+	p.backoff = p.cfg.InitialBackoff
+	p.nextAttempt = time.Time{}
+
+	return nil
+}
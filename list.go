@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"cmp"
+	"errors"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"github.com/rlshukhov/storage/kv"
+	"reflect"
+	"slices"
+	"strconv"
+)
+
+type Cursor = kv.Cursor
+
+type Lister[K ~string | ~uint64, V any] interface {
+	List(limit int, cursor Cursor) ([]kv.Entry[K, V], Cursor, error)
+}
+
+// List returns up to limit entries in key order, starting after cursor, and
+// the cursor of the next page, which is empty after the last page. Cursors
+// hold the last key of their page, so they stay valid while entries are
+// added and removed. Badger seeks to the cursor; other providers sort all
+// keys on every call.
+func List[K ~string | ~uint64, V any](provider KeyValueProvider[K, V], limit int, cursor Cursor) ([]kv.Entry[K, V], Cursor, error) {
+	if l, ok := provider.(Lister[K, V]); ok {
+		return l.List(limit, cursor)
+	}
+
+	if limit <= 0 {
+		return nil, "", errors.New("limit must be positive")
+	}
+	var after K
+	if cursor != "" {
+		position, err := cursor.Position()
+		if err != nil {
+			return nil, "", err
+		}
+		if after, err = parseCursorKey[K](position); err != nil {
+			return nil, "", err
+		}
+	}
+
+	var keys []K
+	err := provider.ForEachKey(func(key K) bool {
+		if cursor == "" || key > after {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	slices.SortFunc(keys, cmp.Compare[K])
+
+	var entries []kv.Entry[K, V]
+	for _, key := range keys {
+		if len(entries) == limit {
+			return entries, kv.NewCursor(cursorKey(entries[len(entries)-1].Key)), nil
+		}
+
+		value, err := provider.Get(key)
+		if storageErrors.Is(err, storageErrors.NotFound) {
+			continue
+		} else if err != nil {
+			return nil, "", err
+		}
+		entries = append(entries, kv.Entry[K, V]{Key: key, Value: value})
+	}
+
+	return entries, "", nil
+}
+
+func cursorKey[K ~string | ~uint64](key K) []byte {
+	v := reflect.ValueOf(key)
+	if v.Kind() == reflect.Uint64 {
+		return strconv.AppendUint(nil, v.Uint(), 10)
+	}
+
+	return []byte(v.String())
+}
+
+func parseCursorKey[K ~string | ~uint64](position []byte) (K, error) {
+	var key K
+	v := reflect.ValueOf(&key).Elem()
+	if v.Kind() != reflect.Uint64 {
+		v.SetString(string(position))
+		return key, nil
+	}
+
+	n, err := strconv.ParseUint(string(position), 10, 64)
+	if err != nil {
+		return key, errors.New("invalid cursor")
+	}
+	v.SetUint(n)
+	return key, nil
+}
@@ -0,0 +1,42 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package localstorage
+
+import "errors"
+
+// Config stores entries in the Web Storage of the browser a js/wasm build
+// runs in: localStorage, or sessionStorage with Session set. Entries are
+// kept under Prefix, so several providers can share the storage of an
+// origin.
+type Config struct {
+	Prefix   string `yaml:"prefix,omitempty"`
+	Session  bool   `yaml:"session,omitempty"`
+	ReadOnly bool   `yaml:"read_only,omitempty"`
+
+	MaxReferenceDepth int `yaml:"max_reference_depth,omitempty"`
+}
+
+const defaultMaxReferenceDepth = 8
+
+func (c Config) Validate() error {
+	var errs []error
+	if c.MaxReferenceDepth < 0 {
+		errs = append(errs, errors.New("max_reference_depth must not be negative"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c Config) area() string {
+	if c.Session {
+		return "sessionStorage"
+	}
+
+	return "localStorage"
+}
@@ -0,0 +1,848 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build js && wasm
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package localstorage
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	baseErrors "errors"
+	"fmt"
+	"github.com/rlshukhov/storage/errors"
+	"github.com/rlshukhov/storage/kv"
+	"io"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall/js"
+	"time"
+)
+
+const (
+	dataNamespace      = "k:"
+	referenceNamespace = "r:"
+)
+
+type provider[K ~string | ~uint64, V any] struct {
+	cfg       Config
+	lastWrite time.Time
+
+	mu      sync.Mutex
+	storage js.Value
+}
+
+func New[K ~string | ~uint64, V any](cfg Config) (*provider[K, V], error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.MaxReferenceDepth == 0 {
+		cfg.MaxReferenceDepth = defaultMaxReferenceDepth
+	}
+
+	return &provider[K, V]{cfg: cfg}, nil
+}
+
+func (p *provider[K, V]) Setup() (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Reading localStorage throws when storage is disabled for the origin.
+	defer catch(&err)
+	storage := js.Global().Get(p.cfg.area())
+	if storage.IsUndefined() || storage.IsNull() {
+		return errors.NewUnavailable(fmt.Errorf("%s is not available", p.cfg.area()))
+	}
+
+	p.storage = storage
+	return nil
+}
+
+func (p *provider[K, V]) Shutdown() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.storage = js.Undefined()
+	return nil
+}
+
+func (p *provider[K, V]) Close() error {
+	return p.Shutdown()
+}
+
+func (p *provider[K, V]) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.check()
+}
+
+func (p *provider[K, V]) Stats() (kv.ProviderStats, error) {
+	stats := kv.ProviderStats{
+		Provider: "localstorage",
+		Backend: map[string]any{
+			"area":   p.cfg.area(),
+			"prefix": p.cfg.Prefix,
+		},
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	keys, err := p.names(dataNamespace)
+	if err != nil {
+		return stats, err
+	}
+	references, err := p.names(referenceNamespace)
+	if err != nil {
+		return stats, err
+	}
+	size, err := p.size()
+	if err != nil {
+		return stats, err
+	}
+
+	stats.Entries = uint64(len(keys))
+	stats.References = uint64(len(references))
+	stats.DiskUsage = int64(size)
+	stats.LastFlush = p.lastWrite
+	return stats, nil
+}
+
+// ApproximateSize returns the length of the names and values of the entries,
+// which is what counts against the quota of the origin.
+func (p *provider[K, V]) ApproximateSize() (uint64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.size()
+}
+
+func (p *provider[K, V]) Store(key K, value V) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.writable(); err != nil {
+		return err
+	}
+
+	return p.store(key, value)
+}
+
+func (p *provider[K, V]) StoreIfAbsent(key K, value V) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.writable(); err != nil {
+		return err
+	}
+	if _, ok, err := p.getItem(p.dataName(key)); err != nil {
+		return err
+	} else if ok {
+		return errors.NewAlreadyExists(fmt.Errorf("key %v", key))
+	}
+
+	return p.store(key, value)
+}
+
+func (p *provider[K, V]) StoreIfPresent(key K, value V) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.writable(); err != nil {
+		return err
+	}
+	if _, ok, err := p.getItem(p.dataName(key)); err != nil {
+		return err
+	} else if !ok {
+		return errors.NewNotFound(fmt.Errorf("key %v", key))
+	}
+
+	return p.store(key, value)
+}
+
+func (p *provider[K, V]) Update(key K, fn func(value V, exists bool) (V, error)) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.writable(); err != nil {
+		return err
+	}
+
+	value, err := p.get(key)
+	exists := err == nil
+	if err != nil && !errors.Is(err, errors.NotFound) {
+		return err
+	}
+
+	value, err = fn(value, exists)
+	if err != nil {
+		return err
+	}
+
+	return p.store(key, value)
+}
+
+func (p *provider[K, V]) Get(key K) (V, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.check(); err != nil {
+		var v V
+		return v, err
+	}
+
+	return p.get(key)
+}
+
+func (p *provider[K, V]) GetMultiple(keys []K) ([]V, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.check(); err != nil {
+		return []V{}, err
+	}
+
+	values := make([]V, 0, len(keys))
+	for _, key := range keys {
+		value, err := p.get(key)
+		if err != nil {
+			return []V{}, err
+		}
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+func (p *provider[K, V]) Remove(key K) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.writable(); err != nil {
+		return err
+	}
+	if err := p.removeItem(p.dataName(key)); err != nil {
+		return err
+	}
+
+	p.lastWrite = time.Now()
+	return nil
+}
+
+func (p *provider[K, V]) RemovePrefix(prefix K) (int, error) {
+	return p.RemoveWhere(func(key K, _ V) bool { return hasPrefix(key, prefix) })
+}
+
+func (p *provider[K, V]) RemoveWhere(pred func(key K, value V) bool) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.writable(); err != nil {
+		return 0, err
+	}
+
+	keys, err := p.keys()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, key := range keys {
+		value, err := p.get(key)
+		if err != nil {
+			return removed, err
+		}
+		if !pred(key, value) {
+			continue
+		}
+		if err := p.removeItem(p.dataName(key)); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	if removed > 0 {
+		p.lastWrite = time.Now()
+	}
+
+	return removed, nil
+}
+
+// Clear removes the entries and references under Prefix. Other items of the
+// origin are kept.
+func (p *provider[K, V]) Clear() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.writable(); err != nil {
+		return err
+	}
+
+	for _, namespace := range []string{dataNamespace, referenceNamespace} {
+		names, err := p.names(namespace)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err := p.removeItem(name); err != nil {
+				return err
+			}
+		}
+	}
+
+	p.lastWrite = time.Now()
+	return nil
+}
+
+func (p *provider[K, V]) ForEach(fn func(key K, value V) bool) error {
+	return p.forEach(func(K) bool { return true }, fn)
+}
+
+func (p *provider[K, V]) ForEachPrefix(prefix K, fn func(key K, value V) bool) error {
+	return p.forEach(func(key K) bool { return hasPrefix(key, prefix) }, fn)
+}
+
+func (p *provider[K, V]) ForEachKey(fn func(key K) bool) error {
+	p.mu.Lock()
+	keys, err := p.keys()
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if !fn(key) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (p *provider[K, V]) StoreReference(reference K, key K) error {
+	return p.updateTargets(reference, func([]K) ([]K, error) {
+		return []K{key}, nil
+	})
+}
+
+func (p *provider[K, V]) AddReference(reference K, key K) error {
+	return p.updateTargets(reference, func(targets []K) ([]K, error) {
+		if slices.Contains(targets, key) {
+			return targets, nil
+		}
+		return append(targets, key), nil
+	})
+}
+
+func (p *provider[K, V]) RemoveReference(reference K) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.writable(); err != nil {
+		return err
+	}
+	if err := p.removeItem(p.referenceName(reference)); err != nil {
+		return err
+	}
+
+	p.lastWrite = time.Now()
+	return nil
+}
+
+func (p *provider[K, V]) RemoveReferenceTarget(reference K, key K) error {
+	return p.updateTargets(reference, func(targets []K) ([]K, error) {
+		i := slices.Index(targets, key)
+		if i < 0 {
+			return nil, errors.NotFound
+		}
+		return slices.Delete(targets, i, i+1), nil
+	})
+}
+
+func (p *provider[K, V]) GetByReference(reference K) (V, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	keys, err := p.resolve(reference, 0, map[K]bool{})
+	if err != nil {
+		var v V
+		return v, err
+	}
+
+	return p.get(keys[0])
+}
+
+func (p *provider[K, V]) GetAllByReference(reference K) ([]V, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	keys, err := p.resolve(reference, 0, map[K]bool{})
+	if err != nil {
+		return nil, err
+	}
+
+	var values []V
+	for _, key := range keys {
+		value, err := p.get(key)
+		if errors.Is(err, errors.NotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+func (p *provider[K, V]) ForEachReference(fn func(reference K, key K) bool) error {
+	p.mu.Lock()
+	references, err := p.references()
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, reference := range references {
+		for _, key := range reference.targets {
+			if !fn(reference.reference, key) {
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *provider[K, V]) Verify() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	keys, err := p.keys()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, key := range keys {
+		if _, err := p.get(key); err != nil {
+			errs = append(errs, fmt.Errorf("key %v: %w", key, err))
+		}
+	}
+	if _, err := p.references(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return baseErrors.Join(errs...)
+}
+
+type backupRecord[K any, V any] struct {
+	Key       K  `json:"key"`
+	Value     *V `json:"value,omitempty"`
+	Reference *K `json:"reference,omitempty"`
+}
+
+func (p *provider[K, V]) Backup(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	keys, err := p.keys()
+	if err != nil {
+		return err
+	}
+	references, err := p.references()
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	for _, key := range keys {
+		value, err := p.get(key)
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(backupRecord[K, V]{Key: key, Value: &value}); err != nil {
+			return err
+		}
+	}
+	for _, reference := range references {
+		for _, key := range reference.targets {
+			if err := enc.Encode(backupRecord[K, V]{Key: key, Reference: &reference.reference}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// forEach reads the matching values one at a time and calls fn without
+// holding the lock, so fn may use the provider.
+func (p *provider[K, V]) forEach(match func(key K) bool, fn func(key K, value V) bool) error {
+	p.mu.Lock()
+	keys, err := p.keys()
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if !match(key) {
+			continue
+		}
+
+		value, err := p.Get(key)
+		if errors.Is(err, errors.NotFound) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if !fn(key, value) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (p *provider[K, V]) check() error {
+	if p.storage.IsUndefined() {
+		return errors.Closed
+	}
+
+	return nil
+}
+
+func (p *provider[K, V]) writable() error {
+	if err := p.check(); err != nil {
+		return err
+	}
+	if p.cfg.ReadOnly {
+		return errors.ReadOnly
+	}
+
+	return nil
+}
+
+func (p *provider[K, V]) get(key K) (V, error) {
+	var v V
+	raw, ok, err := p.getItem(p.dataName(key))
+	if err != nil {
+		return v, err
+	}
+	if !ok {
+		return v, errors.NewNotFound(fmt.Errorf("key %v", key))
+	}
+
+	return decode[V](raw)
+}
+
+func (p *provider[K, V]) store(key K, value V) error {
+	raw, err := encode(value)
+	if err != nil {
+		return err
+	}
+	if err := p.setItem(p.dataName(key), raw); err != nil {
+		return err
+	}
+
+	p.lastWrite = time.Now()
+	return nil
+}
+
+func (p *provider[K, V]) updateTargets(reference K, fn func(targets []K) ([]K, error)) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.writable(); err != nil {
+		return err
+	}
+
+	name := p.referenceName(reference)
+	var targets []K
+	raw, ok, err := p.getItem(name)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if targets, err = decode[[]K](raw); err != nil {
+			return err
+		}
+	}
+
+	if targets, err = fn(targets); err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		err = p.removeItem(name)
+	} else if raw, err = encode(targets); err == nil {
+		err = p.setItem(name, raw)
+	}
+	if err != nil {
+		return err
+	}
+
+	p.lastWrite = time.Now()
+	return nil
+}
+
+func (p *provider[K, V]) resolve(reference K, depth int, path map[K]bool) ([]K, error) {
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+	if path[reference] {
+		return nil, errors.NewReferenceCycle(fmt.Errorf("reference %v", reference))
+	}
+	if depth >= p.cfg.MaxReferenceDepth {
+		return nil, errors.NewReferenceTooDeep(fmt.Errorf("reference %v exceeds depth %d", reference, p.cfg.MaxReferenceDepth))
+	}
+
+	targets, err := p.targets(reference)
+	if err != nil {
+		return nil, err
+	}
+
+	path[reference] = true
+	defer delete(path, reference)
+
+	var keys []K
+	for _, target := range targets {
+		if _, err := p.targets(target); err != nil {
+			keys = append(keys, target)
+			continue
+		}
+
+		resolved, err := p.resolve(target, depth+1, path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, resolved...)
+	}
+
+	return keys, nil
+}
+
+func (p *provider[K, V]) targets(reference K) ([]K, error) {
+	raw, ok, err := p.getItem(p.referenceName(reference))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.NewNotFound(fmt.Errorf("reference %v", reference))
+	}
+
+	targets, err := decode[[]K](raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, errors.NotFound
+	}
+
+	return targets, nil
+}
+
+func (p *provider[K, V]) keys() ([]K, error) {
+	names, err := p.names(dataNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]K, 0, len(names))
+	for _, name := range names {
+		key, err := parseKey[K](strings.TrimPrefix(name, p.cfg.Prefix+dataNamespace))
+		if err != nil {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	return keys, nil
+}
+
+type referenceTargets[K any] struct {
+	reference K
+	targets   []K
+}
+
+func (p *provider[K, V]) references() ([]referenceTargets[K], error) {
+	names, err := p.names(referenceNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var references []referenceTargets[K]
+	for _, name := range names {
+		reference, err := parseKey[K](strings.TrimPrefix(name, p.cfg.Prefix+referenceNamespace))
+		if err != nil {
+			continue
+		}
+		targets, err := p.targets(reference)
+		if errors.Is(err, errors.NotFound) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("reference %v: %w", reference, err)
+		}
+		references = append(references, referenceTargets[K]{reference: reference, targets: targets})
+	}
+	slices.SortFunc(references, func(a, b referenceTargets[K]) int {
+		return strings.Compare(keyString(a.reference), keyString(b.reference))
+	})
+
+	return references, nil
+}
+
+// names returns the sorted names of the items in namespace.
+func (p *provider[K, V]) names(namespace string) (names []string, err error) {
+	if err := p.check(); err != nil {
+		return nil, err
+	}
+
+	defer catch(&err)
+	prefix := p.cfg.Prefix + namespace
+	for i, n := 0, p.storage.Length(); i < n; i++ {
+		name := p.storage.Call("key", i)
+		if name.Type() == js.TypeString && strings.HasPrefix(name.String(), prefix) {
+			names = append(names, name.String())
+		}
+	}
+	slices.Sort(names)
+
+	return names, nil
+}
+
+func (p *provider[K, V]) size() (uint64, error) {
+	var size uint64
+	for _, namespace := range []string{dataNamespace, referenceNamespace} {
+		names, err := p.names(namespace)
+		if err != nil {
+			return 0, err
+		}
+		for _, name := range names {
+			raw, _, err := p.getItem(name)
+			if err != nil {
+				return 0, err
+			}
+			size += uint64(len(name) + len(raw))
+		}
+	}
+
+	return size, nil
+}
+
+func (p *provider[K, V]) getItem(name string) (raw string, ok bool, err error) {
+	defer catch(&err)
+	v := p.storage.Call("getItem", name)
+	if v.IsNull() {
+		return "", false, nil
+	}
+
+	return v.String(), true, nil
+}
+
+func (p *provider[K, V]) setItem(name, raw string) (err error) {
+	defer catch(&err)
+	p.storage.Call("setItem", name, raw)
+	return nil
+}
+
+func (p *provider[K, V]) removeItem(name string) (err error) {
+	defer catch(&err)
+	p.storage.Call("removeItem", name)
+	return nil
+}
+
+func (p *provider[K, V]) dataName(key K) string {
+	return p.cfg.Prefix + dataNamespace + keyString(key)
+}
+
+func (p *provider[K, V]) referenceName(reference K) string {
+	return p.cfg.Prefix + referenceNamespace + keyString(reference)
+}
+
+// catch turns an exception thrown by Web Storage into err. Exceeding the
+// quota of the origin is errors.QuotaExceeded, anything else, such as
+// storage disabled by the user, is errors.Unavailable.
+func catch(err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	jsErr, ok := r.(js.Error)
+	if !ok {
+		panic(r)
+	}
+	if jsErr.Get("name").String() == "QuotaExceededError" {
+		*err = errors.NewQuotaExceeded(jsErr)
+	} else {
+		*err = errors.NewUnavailable(jsErr)
+	}
+}
+
+func hasPrefix[K ~string | ~uint64](key, prefix K) bool {
+	return strings.HasPrefix(keyString(key), keyString(prefix))
+}
+
+func keyString[K ~string | ~uint64](key K) string {
+	v := reflect.ValueOf(key)
+	if v.Kind() == reflect.Uint64 {
+		return strconv.FormatUint(v.Uint(), 10)
+	}
+
+	return v.String()
+}
+
+func parseKey[K ~string | ~uint64](s string) (K, error) {
+	var key K
+	v := reflect.ValueOf(&key).Elem()
+	if v.Kind() == reflect.Uint64 {
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return key, err
+		}
+		v.SetUint(n)
+		return key, nil
+	}
+
+	v.SetString(s)
+	return key, nil
+}
+
+func encode[T any](value T) (string, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func decode[T any](raw string) (T, error) {
+	var value T
+	data, err := base64.StdEncoding.DecodeString(raw)
+	if err != nil {
+		return value, errors.NewCorrupted(err)
+	}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value); err != nil {
+		return value, errors.NewCorrupted(err)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,17 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build js && wasm
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import "github.com/rlshukhov/storage/localstorage"
+
+func newLocalStorageProvider[K ~string | ~uint64, V any](cfg localstorage.Config) (KeyValueProvider[K, V], error) {
+	return localstorage.New[K, V](cfg)
+}
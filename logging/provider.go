@@ -0,0 +1,323 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package logging
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	baseErrors "errors"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/errors"
+	"io"
+	"log/slog"
+	"path"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+type Config struct {
+	Level      nullable.Nullable[slog.Level] `yaml:"level"`
+	ErrorLevel nullable.Nullable[slog.Level] `yaml:"error_level"`
+
+	// Operations taking SlowThreshold or longer are logged at SlowLevel with
+	// the provider name and the calling file and line.
+	SlowThreshold time.Duration                 `yaml:"slow_threshold,omitempty"`
+	SlowLevel     nullable.Nullable[slog.Level] `yaml:"slow_level"`
+	Provider      string                        `yaml:"provider,omitempty"`
+
+	// RedactKeys logs a short SHA-256 hash instead of keys, prefixes and
+	// references, for keys that hold personal data such as email addresses.
+	// Equal keys still log equal hashes.
+	RedactKeys bool `yaml:"redact_keys,omitempty"`
+
+	Logger *slog.Logger `yaml:"-"`
+}
+
+type provider[K ~string | ~uint64, V any] struct {
+	storage.KeyValueProvider[K, V]
+	logger        *slog.Logger
+	level         slog.Level
+	errorLevel    slog.Level
+	slowThreshold time.Duration
+	slowLevel     slog.Level
+	name          string
+	redactKeys    bool
+}
+
+func New[K ~string | ~uint64, V any](inner storage.KeyValueProvider[K, V], cfg Config) (*provider[K, V], error) {
+	if inner == nil {
+		return nil, baseErrors.New("inner provider is nil")
+	}
+	if cfg.SlowThreshold < 0 {
+		return nil, baseErrors.New("slow_threshold must not be negative")
+	}
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	name := cfg.Provider
+	if name == "" {
+		name = providerName(inner)
+	}
+
+	return &provider[K, V]{
+		KeyValueProvider: inner,
+		logger:           logger,
+		level:            cfg.Level.OrElse(slog.LevelDebug),
+		errorLevel:       cfg.ErrorLevel.OrElse(slog.LevelError),
+		slowThreshold:    cfg.SlowThreshold,
+		slowLevel:        cfg.SlowLevel.OrElse(slog.LevelWarn),
+		name:             name,
+		redactKeys:       cfg.RedactKeys,
+	}, nil
+}
+
+func (p *provider[K, V]) Setup() error {
+	start := time.Now()
+	err := p.KeyValueProvider.Setup()
+	p.log("setup", start, err)
+	return err
+}
+
+func (p *provider[K, V]) Shutdown() error {
+	start := time.Now()
+	err := p.KeyValueProvider.Shutdown()
+	p.log("shutdown", start, err)
+	return err
+}
+
+func (p *provider[K, V]) Close() error {
+	start := time.Now()
+	err := p.KeyValueProvider.Close()
+	p.log("close", start, err)
+	return err
+}
+
+func (p *provider[K, V]) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := p.KeyValueProvider.Ping(ctx)
+	p.log("ping", start, err)
+	return err
+}
+
+func (p *provider[K, V]) Flush() error {
+	start := time.Now()
+	err := storage.Flush(p.KeyValueProvider)
+	p.log("flush", start, err)
+	return err
+}
+
+func (p *provider[K, V]) Store(key K, value V) error {
+	start := time.Now()
+	err := p.KeyValueProvider.Store(key, value)
+	p.log("store", start, err, slog.Any("key", key))
+	return err
+}
+
+func (p *provider[K, V]) Update(key K, fn func(value V, exists bool) (V, error)) error {
+	start := time.Now()
+	err := p.KeyValueProvider.Update(key, fn)
+	p.log("update", start, err, slog.Any("key", key))
+	return err
+}
+
+func (p *provider[K, V]) Get(key K) (V, error) {
+	start := time.Now()
+	value, err := p.KeyValueProvider.Get(key)
+	p.log("get", start, err, slog.Any("key", key))
+	return value, err
+}
+
+func (p *provider[K, V]) GetMultiple(keys []K) ([]V, error) {
+	start := time.Now()
+	values, err := p.KeyValueProvider.GetMultiple(keys)
+	p.log("get_multiple", start, err, slog.Int("keys", len(keys)))
+	return values, err
+}
+
+func (p *provider[K, V]) Remove(key K) error {
+	start := time.Now()
+	err := p.KeyValueProvider.Remove(key)
+	p.log("remove", start, err, slog.Any("key", key))
+	return err
+}
+
+func (p *provider[K, V]) RemovePrefix(prefix K) (int, error) {
+	start := time.Now()
+	removed, err := p.KeyValueProvider.RemovePrefix(prefix)
+	p.log("remove_prefix", start, err, slog.Any("prefix", prefix), slog.Int("removed", removed))
+	return removed, err
+}
+
+func (p *provider[K, V]) RemoveWhere(pred func(key K, value V) bool) (int, error) {
+	start := time.Now()
+	removed, err := p.KeyValueProvider.RemoveWhere(pred)
+	p.log("remove_where", start, err, slog.Int("removed", removed))
+	return removed, err
+}
+
+func (p *provider[K, V]) Clear() error {
+	start := time.Now()
+	err := p.KeyValueProvider.Clear()
+	p.log("clear", start, err)
+	return err
+}
+
+func (p *provider[K, V]) ForEach(fn func(key K, value V) bool) error {
+	start := time.Now()
+	err := p.KeyValueProvider.ForEach(fn)
+	p.log("for_each", start, err)
+	return err
+}
+
+func (p *provider[K, V]) ForEachPrefix(prefix K, fn func(key K, value V) bool) error {
+	start := time.Now()
+	err := p.KeyValueProvider.ForEachPrefix(prefix, fn)
+	p.log("for_each_prefix", start, err, slog.Any("prefix", prefix))
+	return err
+}
+
+func (p *provider[K, V]) ForEachKey(fn func(key K) bool) error {
+	start := time.Now()
+	err := p.KeyValueProvider.ForEachKey(fn)
+	p.log("for_each_key", start, err)
+	return err
+}
+
+func (p *provider[K, V]) StoreReference(reference K, key K) error {
+	start := time.Now()
+	err := p.KeyValueProvider.StoreReference(reference, key)
+	p.log("store_reference", start, err, slog.Any("reference", reference), slog.Any("key", key))
+	return err
+}
+
+func (p *provider[K, V]) AddReference(reference K, key K) error {
+	start := time.Now()
+	err := p.KeyValueProvider.AddReference(reference, key)
+	p.log("add_reference", start, err, slog.Any("reference", reference), slog.Any("key", key))
+	return err
+}
+
+func (p *provider[K, V]) RemoveReference(reference K) error {
+	start := time.Now()
+	err := p.KeyValueProvider.RemoveReference(reference)
+	p.log("remove_reference", start, err, slog.Any("reference", reference))
+	return err
+}
+
+func (p *provider[K, V]) RemoveReferenceTarget(reference K, key K) error {
+	start := time.Now()
+	err := p.KeyValueProvider.RemoveReferenceTarget(reference, key)
+	p.log("remove_reference_target", start, err, slog.Any("reference", reference), slog.Any("key", key))
+	return err
+}
+
+func (p *provider[K, V]) GetByReference(reference K) (V, error) {
+	start := time.Now()
+	value, err := p.KeyValueProvider.GetByReference(reference)
+	p.log("get_by_reference", start, err, slog.Any("reference", reference))
+	return value, err
+}
+
+func (p *provider[K, V]) GetAllByReference(reference K) ([]V, error) {
+	start := time.Now()
+	values, err := p.KeyValueProvider.GetAllByReference(reference)
+	p.log("get_all_by_reference", start, err, slog.Any("reference", reference), slog.Int("values", len(values)))
+	return values, err
+}
+
+func (p *provider[K, V]) ForEachReference(fn func(reference K, key K) bool) error {
+	start := time.Now()
+	err := p.KeyValueProvider.ForEachReference(fn)
+	p.log("for_each_reference", start, err)
+	return err
+}
+
+func (p *provider[K, V]) Verify() error {
+	start := time.Now()
+	err := p.KeyValueProvider.Verify()
+	p.log("verify", start, err)
+	return err
+}
+
+func (p *provider[K, V]) Backup(w io.Writer) error {
+	start := time.Now()
+	err := p.KeyValueProvider.Backup(w)
+	p.log("backup", start, err)
+	return err
+}
+
+func (p *provider[K, V]) log(op string, start time.Time, err error, attrs ...slog.Attr) {
+	duration := time.Since(start)
+	slow := p.slowThreshold > 0 && duration >= p.slowThreshold
+
+	level, msg := p.level, "storage operation"
+	if slow {
+		level, msg = p.slowLevel, "slow storage operation"
+	}
+	if err != nil && !errors.Is(err, errors.NotFound) {
+		level = max(level, p.errorLevel)
+	}
+
+	ctx := context.Background()
+	if !p.logger.Enabled(ctx, level) {
+		return
+	}
+
+	if p.redactKeys {
+		for i, attr := range attrs {
+			switch attr.Key {
+			case "key", "prefix", "reference":
+				attrs[i] = slog.String(attr.Key, hashKey(attr.Value.String()))
+			}
+		}
+	}
+
+	attrs = append([]slog.Attr{slog.String("op", op), slog.Duration("duration", duration)}, attrs...)
+	if slow {
+		attrs = append(attrs, slog.String("provider", p.name), slog.String("caller", caller()))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+
+	p.logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+// caller returns the file and line that called the provider method, every
+// method calls log directly.
+func caller() string {
+	_, file, line, ok := runtime.Caller(3)
+	if !ok {
+		return "unknown"
+	}
+
+	return filepath.Base(filepath.Dir(file)) + "/" + filepath.Base(file) + ":" + strconv.Itoa(line)
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:6])
+}
+
+func providerName(inner any) string {
+	t := reflect.TypeOf(inner)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	return path.Base(t.PkgPath())
+}
@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package logging
+
+import (
+	"bytes"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestProvider_SlowOperations(t *testing.T) {
+	inner, err := storage.GetKeyValueProviderFromConfig[string, string](storage.KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, inner.Setup())
+	defer func() {
+		require.NoError(t, inner.Shutdown())
+	}()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+	fast, err := New(inner, Config{Logger: logger, SlowThreshold: time.Hour})
+	require.NoError(t, err)
+	require.NoError(t, fast.Store("user:123", "ann"))
+	assert.Empty(t, buf.String())
+
+	slow, err := New(inner, Config{Logger: logger, SlowThreshold: time.Nanosecond})
+	require.NoError(t, err)
+	_, err = slow.Get("user:123")
+	require.NoError(t, err)
+	line := buf.String()
+	assert.Contains(t, line, "level=WARN")
+	assert.Contains(t, line, `msg="slow storage operation" op=get`)
+	assert.Contains(t, line, "key=user:123")
+	assert.Contains(t, line, "provider=badger")
+	assert.Contains(t, line, "caller=logging/provider_test.go:")
+
+	_, err = New(inner, Config{SlowThreshold: -time.Second})
+	assert.Error(t, err)
+}
+
+func TestProvider_RedactKeys(t *testing.T) {
+	inner, err := storage.GetKeyValueProviderFromConfig[string, string](storage.KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, inner.Setup())
+	defer func() {
+		require.NoError(t, inner.Shutdown())
+	}()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	p, err := New(inner, Config{Logger: logger, RedactKeys: true})
+	require.NoError(t, err)
+	require.NoError(t, p.Store("ann@example.com", "ann"))
+	_, err = p.Get("ann@example.com")
+	require.NoError(t, err)
+	_, err = p.RemovePrefix("ann@")
+	require.NoError(t, err)
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.Len(t, lines, 3)
+	assert.NotContains(t, buf.String(), "ann@")
+	hash := hashKey("ann@example.com")
+	assert.Contains(t, string(lines[0]), "key="+hash)
+	assert.Contains(t, string(lines[1]), "key="+hash)
+	assert.Contains(t, string(lines[2]), "prefix="+hashKey("ann@"))
+}
+
+type flusher struct {
+	storage.KeyValueProvider[string, string]
+	flushed int
+}
+
+func (f *flusher) Flush() error {
+	f.flushed++
+	return nil
+}
+
+func TestProvider_Flush(t *testing.T) {
+	inner, err := storage.GetKeyValueProviderFromConfig[string, string](storage.KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true}),
+	})
+	require.NoError(t, err)
+	f := &flusher{KeyValueProvider: inner}
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	p, err := New[string, string](f, Config{Logger: logger, SlowThreshold: time.Nanosecond})
+	require.NoError(t, err)
+
+	require.NoError(t, storage.Flush(p))
+	assert.Equal(t, 1, f.flushed)
+	assert.Contains(t, buf.String(), "op=flush")
+}
@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"errors"
+	storageErrors "github.com/rlshukhov/storage/errors"
+)
+
+// Map has the methods of sync.Map, typed and backed by a provider. Every
+// method also returns the error of the provider.
+type Map[K ~string | ~uint64, V any] struct {
+	provider KeyValueProvider[K, V]
+}
+
+var errUnchanged = errors.New("value unchanged")
+
+func NewMap[K ~string | ~uint64, V any](provider KeyValueProvider[K, V]) *Map[K, V] {
+	return &Map[K, V]{provider: provider}
+}
+
+func (m *Map[K, V]) Load(key K) (value V, ok bool, err error) {
+	value, err = m.provider.Get(key)
+	if storageErrors.Is(err, storageErrors.NotFound) {
+		return value, false, nil
+	} else if err != nil {
+		return value, false, err
+	}
+
+	return value, true, nil
+}
+
+func (m *Map[K, V]) Store(key K, value V) error {
+	return m.provider.Store(key, value)
+}
+
+func (m *Map[K, V]) Delete(key K) error {
+	err := m.provider.Remove(key)
+	if storageErrors.Is(err, storageErrors.NotFound) {
+		return nil
+	}
+
+	return err
+}
+
+// LoadOrStore returns the existing value for the key if present. Otherwise,
+// it stores and returns the given value. The loaded result is true if the
+// value was loaded, false if stored.
+func (m *Map[K, V]) LoadOrStore(key K, value V) (actual V, loaded bool, err error) {
+	err = m.provider.Update(key, func(current V, exists bool) (V, error) {
+		if exists {
+			actual, loaded = current, true
+			return current, errUnchanged
+		}
+
+		actual, loaded = value, false
+		return value, nil
+	})
+	if errors.Is(err, errUnchanged) {
+		err = nil
+	}
+
+	return actual, loaded, err
+}
+
+// LoadAndDelete deletes the value for a key, returning the previous value if
+// any. Unlike sync.Map, the load and the delete are not atomic.
+func (m *Map[K, V]) LoadAndDelete(key K) (value V, loaded bool, err error) {
+	value, loaded, err = m.Load(key)
+	if err != nil || !loaded {
+		return value, loaded, err
+	}
+
+	return value, true, m.Delete(key)
+}
+
+// Swap stores value and returns the previous value if any.
+func (m *Map[K, V]) Swap(key K, value V) (previous V, loaded bool, err error) {
+	err = m.provider.Update(key, func(current V, exists bool) (V, error) {
+		previous, loaded = current, exists
+		return value, nil
+	})
+
+	return previous, loaded, err
+}
+
+func (m *Map[K, V]) Range(f func(key K, value V) bool) error {
+	return m.provider.ForEach(f)
+}
+
+func (m *Map[K, V]) Clear() error {
+	return m.provider.Clear()
+}
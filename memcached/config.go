@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package memcached
+
+import (
+	baseErrors "errors"
+	"fmt"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage/kv"
+	"time"
+)
+
+type Config struct {
+	Servers           []string      `yaml:"servers"`
+	KeyPrefix         string        `yaml:"key_prefix,omitempty"`
+	TTL               time.Duration `yaml:"ttl,omitempty"`
+	Timeout           time.Duration `yaml:"timeout,omitempty"`
+	CASRetries        int           `yaml:"cas_retries,omitempty"`
+	MaxReferenceDepth int           `yaml:"max_reference_depth,omitempty"`
+
+	// Pool only supports MaxIdle: the memcache client opens connections
+	// as needed and keeps up to MaxIdle of them per server.
+	Pool kv.PoolConfig `yaml:"pool,omitempty"`
+
+	// Deprecated: use Pool.MaxIdle, which takes precedence.
+	MaxIdleConns int `yaml:"max_idle_conns,omitempty"`
+
+	// TLS connects to servers started with --enable-ssl.
+	TLS nullable.Nullable[kv.TLSConfig] `yaml:"tls"`
+}
+
+const (
+	maxKeyLength             = 250
+	relativeExpirationLimit  = 30 * 24 * time.Hour
+	defaultCASRetries        = 10
+	defaultMaxReferenceDepth = 8
+)
+
+func (c Config) Validate() error {
+	var errs []error
+	if len(c.Servers) == 0 {
+		errs = append(errs, baseErrors.New("at least one server is required"))
+	}
+	if len(c.KeyPrefix) > maxKeyLength/2 {
+		errs = append(errs, fmt.Errorf("key_prefix must not exceed %d bytes", maxKeyLength/2))
+	}
+	if !validKey(c.KeyPrefix) {
+		errs = append(errs, baseErrors.New("key_prefix must not contain whitespace or control characters"))
+	}
+	if c.TTL < 0 {
+		errs = append(errs, baseErrors.New("ttl must not be negative"))
+	}
+	if c.Timeout < 0 {
+		errs = append(errs, baseErrors.New("timeout must not be negative"))
+	}
+	if c.MaxIdleConns < 0 {
+		errs = append(errs, baseErrors.New("max_idle_conns must not be negative"))
+	}
+	if err := c.Pool.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Pool.MaxOpen != 0 || c.Pool.IdleTimeout != 0 || c.Pool.MaxLifetime != 0 || c.Pool.WaitTimeout != 0 {
+		errs = append(errs, baseErrors.New("memcached only supports pool.max_idle"))
+	}
+	if c.TLS.HasValue() {
+		if err := c.TLS.GetValue().Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.CASRetries < 0 {
+		errs = append(errs, baseErrors.New("cas_retries must not be negative"))
+	}
+	if c.MaxReferenceDepth < 0 {
+		errs = append(errs, baseErrors.New("max_reference_depth must not be negative"))
+	}
+
+	return baseErrors.Join(errs...)
+}
+
+func validKey(key string) bool {
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+
+	return true
+}
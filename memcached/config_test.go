@@ -0,0 +1,56 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package memcached
+
+import (
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage/kv"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	servers := []string{"localhost:11211"}
+	valid := map[string]Config{
+		"servers": {Servers: servers},
+		"full": {
+			Servers:           servers,
+			KeyPrefix:         "app:",
+			TTL:               time.Hour,
+			Timeout:           time.Second,
+			CASRetries:        3,
+			MaxReferenceDepth: 4,
+			Pool:              kv.PoolConfig{MaxIdle: 4},
+			MaxIdleConns:      2,
+		},
+	}
+	for name, cfg := range valid {
+		assert.NoError(t, cfg.Validate(), name)
+	}
+
+	invalid := map[string]Config{
+		"no servers":       {},
+		"long prefix":      {Servers: servers, KeyPrefix: strings.Repeat("a", maxKeyLength/2+1)},
+		"prefix space":     {Servers: servers, KeyPrefix: "app "},
+		"prefix control":   {Servers: servers, KeyPrefix: "app\x7f"},
+		"negative ttl":     {Servers: servers, TTL: -time.Second},
+		"negative timeout": {Servers: servers, Timeout: -time.Second},
+		"negative idle":    {Servers: servers, MaxIdleConns: -1},
+		"pool max_open":    {Servers: servers, Pool: kv.PoolConfig{MaxOpen: 4}},
+		"negative pool":    {Servers: servers, Pool: kv.PoolConfig{MaxIdle: -1}},
+		"tls":              {Servers: servers, TLS: nullable.FromValue(kv.TLSConfig{CertFile: "cert.pem"})},
+		"negative retries": {Servers: servers, CASRetries: -1},
+		"negative depth":   {Servers: servers, MaxReferenceDepth: -1},
+	}
+	for name, cfg := range invalid {
+		assert.Error(t, cfg.Validate(), name)
+	}
+}
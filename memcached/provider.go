@@ -0,0 +1,635 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !nomemcached
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package memcached
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/gob"
+	"encoding/hex"
+	baseErrors "errors"
+	"fmt"
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/rlshukhov/storage/errors"
+	"github.com/rlshukhov/storage/kv"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type provider[K ~string | ~uint64, V any] struct {
+	cfg    Config
+	client *memcache.Client
+	mu     sync.RWMutex
+	closed bool
+}
+
+func New[K ~string | ~uint64, V any](cfg Config) (*provider[K, V], error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.CASRetries == 0 {
+		cfg.CASRetries = defaultCASRetries
+	}
+	if cfg.MaxReferenceDepth == 0 {
+		cfg.MaxReferenceDepth = defaultMaxReferenceDepth
+	}
+
+	return &provider[K, V]{cfg: cfg}, nil
+}
+
+func (p *provider[K, V]) Setup() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	client := memcache.New(p.cfg.Servers...)
+	if p.cfg.Timeout > 0 {
+		client.Timeout = p.cfg.Timeout
+	}
+	if maxIdle := cmp.Or(p.cfg.Pool.MaxIdle, p.cfg.MaxIdleConns); maxIdle > 0 {
+		client.MaxIdleConns = maxIdle
+	}
+	if p.cfg.TLS.HasValue() {
+		config, err := p.cfg.TLS.GetValue().Client()
+		if err != nil {
+			return err
+		}
+		dialer := &tls.Dialer{
+			NetDialer: &net.Dialer{Timeout: cmp.Or(p.cfg.Timeout, memcache.DefaultTimeout)},
+			Config:    config,
+		}
+		client.DialContext = dialer.DialContext
+	}
+
+	p.client = client
+	p.closed = false
+	return nil
+}
+
+func (p *provider[K, V]) Shutdown() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.client == nil || p.closed {
+		return nil
+	}
+
+	p.closed = true
+	return p.client.Close()
+}
+
+func (p *provider[K, V]) Close() error {
+	return p.Shutdown()
+}
+
+func (p *provider[K, V]) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	client, err := p.conn()
+	if err != nil {
+		return err
+	}
+
+	return mapError(client.Ping())
+}
+
+func (p *provider[K, V]) Stats() (kv.ProviderStats, error) {
+	return kv.ProviderStats{
+		Provider: "memcached",
+		Backend: map[string]any{
+			"servers":    p.cfg.Servers,
+			"key_prefix": p.cfg.KeyPrefix,
+			"ttl":        p.cfg.TTL.String(),
+		},
+	}, nil
+}
+
+func (p *provider[K, V]) ApproximateSize() (uint64, error) {
+	return 0, errors.NewUnsupported(baseErrors.New("memcached does not report per-prefix size"))
+}
+
+func (p *provider[K, V]) Store(key K, value V) error {
+	client, err := p.conn()
+	if err != nil {
+		return err
+	}
+
+	data, err := encode(value)
+	if err != nil {
+		return err
+	}
+
+	return mapError(client.Set(&memcache.Item{Key: p.dataKey(key), Value: data, Expiration: p.expiration()}))
+}
+
+func (p *provider[K, V]) StoreWithTTL(key K, value V, ttl time.Duration) error {
+	if ttl <= 0 {
+		return baseErrors.New("ttl must be positive")
+	}
+
+	client, err := p.conn()
+	if err != nil {
+		return err
+	}
+
+	data, err := encode(value)
+	if err != nil {
+		return err
+	}
+
+	return mapError(client.Set(&memcache.Item{Key: p.dataKey(key), Value: data, Expiration: expiration(ttl, time.Now())}))
+}
+
+func (p *provider[K, V]) StoreIfAbsent(key K, value V) error {
+	client, err := p.conn()
+	if err != nil {
+		return err
+	}
+
+	data, err := encode(value)
+	if err != nil {
+		return err
+	}
+
+	err = client.Add(&memcache.Item{Key: p.dataKey(key), Value: data, Expiration: p.expiration()})
+	if baseErrors.Is(err, memcache.ErrNotStored) {
+		return errors.NewAlreadyExists(fmt.Errorf("key %v", key))
+	}
+
+	return mapError(err)
+}
+
+func (p *provider[K, V]) StoreIfPresent(key K, value V) error {
+	client, err := p.conn()
+	if err != nil {
+		return err
+	}
+
+	data, err := encode(value)
+	if err != nil {
+		return err
+	}
+
+	err = client.Replace(&memcache.Item{Key: p.dataKey(key), Value: data, Expiration: p.expiration()})
+	if baseErrors.Is(err, memcache.ErrNotStored) {
+		return errors.NewNotFound(fmt.Errorf("key %v", key))
+	}
+
+	return mapError(err)
+}
+
+func (p *provider[K, V]) Update(key K, fn func(value V, exists bool) (V, error)) error {
+	client, err := p.conn()
+	if err != nil {
+		return err
+	}
+
+	return p.compareAndSwap(client, p.dataKey(key), func(raw []byte, exists bool) ([]byte, error) {
+		var value V
+		if exists {
+			if value, err = decode[V](raw); err != nil {
+				return nil, err
+			}
+		}
+
+		value, err := fn(value, exists)
+		if err != nil {
+			return nil, err
+		}
+
+		return encode(value)
+	})
+}
+
+func (p *provider[K, V]) Get(key K) (V, error) {
+	client, err := p.conn()
+	if err != nil {
+		var v V
+		return v, err
+	}
+
+	item, err := client.Get(p.dataKey(key))
+	if err != nil {
+		var v V
+		return v, mapError(err)
+	}
+
+	return decode[V](item.Value)
+}
+
+func (p *provider[K, V]) GetMultiple(keys []K) ([]V, error) {
+	client, err := p.conn()
+	if err != nil {
+		return []V{}, err
+	}
+
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		names[i] = p.dataKey(key)
+	}
+
+	items, err := client.GetMulti(names)
+	if err != nil {
+		return []V{}, mapError(err)
+	}
+
+	var values []V
+	for _, name := range names {
+		item, ok := items[name]
+		if !ok {
+			return []V{}, errors.NotFound
+		}
+
+		v, err := decode[V](item.Value)
+		if err != nil {
+			return []V{}, err
+		}
+		values = append(values, v)
+	}
+
+	return values, nil
+}
+
+func (p *provider[K, V]) Remove(key K) error {
+	client, err := p.conn()
+	if err != nil {
+		return err
+	}
+
+	return mapError(client.Delete(p.dataKey(key)))
+}
+
+func (p *provider[K, V]) RemovePrefix(prefix K) (int, error) {
+	return 0, unsupported("RemovePrefix")
+}
+
+func (p *provider[K, V]) RemoveWhere(pred func(key K, value V) bool) (int, error) {
+	return 0, unsupported("RemoveWhere")
+}
+
+func (p *provider[K, V]) Clear() error {
+	if p.cfg.KeyPrefix != "" {
+		return errors.NewUnsupported(baseErrors.New("Clear would flush keys outside key_prefix"))
+	}
+
+	client, err := p.conn()
+	if err != nil {
+		return err
+	}
+
+	return mapError(client.DeleteAll())
+}
+
+func (p *provider[K, V]) ForEach(fn func(key K, value V) bool) error {
+	return unsupported("ForEach")
+}
+
+func (p *provider[K, V]) ForEachPrefix(prefix K, fn func(key K, value V) bool) error {
+	return unsupported("ForEachPrefix")
+}
+
+func (p *provider[K, V]) ForEachKey(fn func(key K) bool) error {
+	return unsupported("ForEachKey")
+}
+
+func (p *provider[K, V]) StoreReference(reference K, key K) error {
+	client, err := p.conn()
+	if err != nil {
+		return err
+	}
+
+	data, err := encode([]K{key})
+	if err != nil {
+		return err
+	}
+
+	return mapError(client.Set(&memcache.Item{Key: p.referenceKey(reference), Value: data, Expiration: p.expiration()}))
+}
+
+func (p *provider[K, V]) AddReference(reference K, key K) error {
+	client, err := p.conn()
+	if err != nil {
+		return err
+	}
+
+	return p.compareAndSwap(client, p.referenceKey(reference), func(raw []byte, exists bool) ([]byte, error) {
+		var targets []K
+		if exists {
+			if targets, err = decode[[]K](raw); err != nil {
+				return nil, err
+			}
+		}
+		for _, target := range targets {
+			if target == key {
+				return raw, nil
+			}
+		}
+
+		return encode(append(targets, key))
+	})
+}
+
+func (p *provider[K, V]) RemoveReference(reference K) error {
+	client, err := p.conn()
+	if err != nil {
+		return err
+	}
+
+	return mapError(client.Delete(p.referenceKey(reference)))
+}
+
+func (p *provider[K, V]) RemoveReferenceTarget(reference K, key K) error {
+	client, err := p.conn()
+	if err != nil {
+		return err
+	}
+
+	name := p.referenceKey(reference)
+	empty := false
+	err = p.compareAndSwap(client, name, func(raw []byte, exists bool) ([]byte, error) {
+		if !exists {
+			return nil, errors.NotFound
+		}
+
+		targets, err := decode[[]K](raw)
+		if err != nil {
+			return nil, err
+		}
+
+		remaining := make([]K, 0, len(targets))
+		for _, target := range targets {
+			if target != key {
+				remaining = append(remaining, target)
+			}
+		}
+		if len(remaining) == len(targets) {
+			return nil, errors.NotFound
+		}
+
+		empty = len(remaining) == 0
+		return encode(remaining)
+	})
+	if err != nil || !empty {
+		return err
+	}
+
+	err = mapError(client.Delete(name))
+	if errors.Is(err, errors.NotFound) {
+		return nil
+	}
+
+	return err
+}
+
+func (p *provider[K, V]) GetByReference(reference K) (V, error) {
+	keys, err := p.resolve(reference, 0, map[K]bool{})
+	if err != nil {
+		var v V
+		return v, err
+	}
+
+	return p.Get(keys[0])
+}
+
+func (p *provider[K, V]) GetAllByReference(reference K) ([]V, error) {
+	keys, err := p.resolve(reference, 0, map[K]bool{})
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := p.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		names[i] = p.dataKey(key)
+	}
+
+	items, err := client.GetMulti(names)
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	var values []V
+	for _, name := range names {
+		if item, ok := items[name]; ok {
+			v, err := decode[V](item.Value)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+	}
+
+	return values, nil
+}
+
+func (p *provider[K, V]) ForEachReference(fn func(reference K, key K) bool) error {
+	return unsupported("ForEachReference")
+}
+
+func (p *provider[K, V]) Verify() error {
+	return unsupported("Verify")
+}
+
+func (p *provider[K, V]) Backup(w io.Writer) error {
+	return unsupported("Backup")
+}
+
+func (p *provider[K, V]) resolve(reference K, depth int, path map[K]bool) ([]K, error) {
+	if path[reference] {
+		return nil, errors.NewReferenceCycle(fmt.Errorf("reference %v", reference))
+	}
+	if depth >= p.cfg.MaxReferenceDepth {
+		return nil, errors.NewReferenceTooDeep(fmt.Errorf("reference %v exceeds depth %d", reference, p.cfg.MaxReferenceDepth))
+	}
+
+	targets, err := p.targets(reference)
+	if err != nil {
+		return nil, err
+	}
+
+	path[reference] = true
+	defer delete(path, reference)
+
+	var keys []K
+	for _, target := range targets {
+		if _, err := p.targets(target); err != nil {
+			keys = append(keys, target)
+			continue
+		}
+
+		resolved, err := p.resolve(target, depth+1, path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, resolved...)
+	}
+
+	return keys, nil
+}
+
+func (p *provider[K, V]) targets(reference K) ([]K, error) {
+	client, err := p.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := client.Get(p.referenceKey(reference))
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	targets, err := decode[[]K](item.Value)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, errors.NotFound
+	}
+
+	return targets, nil
+}
+
+func (p *provider[K, V]) compareAndSwap(client *memcache.Client, name string, fn func(raw []byte, exists bool) ([]byte, error)) error {
+	for attempt := 0; attempt <= p.cfg.CASRetries; attempt++ {
+		item, err := client.Get(name)
+		exists := err == nil
+		if err != nil && !baseErrors.Is(err, memcache.ErrCacheMiss) {
+			return mapError(err)
+		}
+
+		var raw []byte
+		if exists {
+			raw = item.Value
+		}
+
+		data, err := fn(raw, exists)
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			item.Value = data
+			item.Expiration = p.expiration()
+			err = client.CompareAndSwap(item)
+		} else {
+			err = client.Add(&memcache.Item{Key: name, Value: data, Expiration: p.expiration()})
+		}
+
+		if baseErrors.Is(err, memcache.ErrCASConflict) || baseErrors.Is(err, memcache.ErrNotStored) || baseErrors.Is(err, memcache.ErrCacheMiss) {
+			continue
+		}
+
+		return mapError(err)
+	}
+
+	return errors.NewConflict(fmt.Errorf("%q changed concurrently %d times", name, p.cfg.CASRetries+1))
+}
+
+func (p *provider[K, V]) conn() (*memcache.Client, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.client == nil || p.closed {
+		return nil, errors.Closed
+	}
+
+	return p.client, nil
+}
+
+func (p *provider[K, V]) dataKey(key K) string {
+	return p.name("k:", keyString(key))
+}
+
+func (p *provider[K, V]) referenceKey(reference K) string {
+	return p.name("r:", keyString(reference))
+}
+
+func (p *provider[K, V]) name(namespace string, key string) string {
+	name := p.cfg.KeyPrefix + namespace + key
+	if len(name) <= maxKeyLength && validKey(key) && (len(key) == 0 || key[0] != '~') {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return p.cfg.KeyPrefix + namespace + "~" + hex.EncodeToString(sum[:])
+}
+
+func (p *provider[K, V]) expiration() int32 {
+	return expiration(p.cfg.TTL, time.Now())
+}
+
+func expiration(ttl time.Duration, now time.Time) int32 {
+	switch {
+	case ttl <= 0:
+		return 0
+	case ttl > relativeExpirationLimit:
+		return int32(now.Add(ttl).Unix())
+	default:
+		return int32(max(1, (ttl+time.Second-1)/time.Second))
+	}
+}
+
+func keyString[K ~string | ~uint64](key K) string {
+	v := reflect.ValueOf(key)
+	if v.Kind() == reflect.Uint64 {
+		return strconv.FormatUint(v.Uint(), 10)
+	}
+
+	return v.String()
+}
+
+func encode[T any](value T) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decode[T any](data []byte) (T, error) {
+	var value T
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&value); err != nil {
+		return value, errors.NewCorrupted(err)
+	}
+
+	return value, nil
+}
+
+func mapError(err error) error {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return nil
+	case baseErrors.Is(err, memcache.ErrCacheMiss):
+		return errors.NewNotFound(err)
+	case baseErrors.Is(err, memcache.ErrNoServers), baseErrors.As(err, &netErr):
+		return errors.NewUnavailable(err)
+	default:
+		return err
+	}
+}
+
+func unsupported(op string) error {
+	return errors.NewUnsupported(fmt.Errorf("%s is not supported by the memcached provider", op))
+}
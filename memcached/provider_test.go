@@ -0,0 +1,180 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !nomemcached
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package memcached
+
+import (
+	"context"
+	baseErrors "errors"
+	"fmt"
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/rlshukhov/storage/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProvider_Keys(t *testing.T) {
+	p, err := New[string, int](Config{Servers: []string{"localhost:11211"}, KeyPrefix: "app:"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "app:k:user", p.dataKey("user"))
+	assert.Equal(t, "app:r:user", p.referenceKey("user"))
+
+	hashed := map[string]string{
+		"space":   "a b",
+		"control": "a\nb",
+		"long":    strings.Repeat("a", maxKeyLength),
+		"tilde":   "~user",
+	}
+	names := map[string]bool{}
+	for name, key := range hashed {
+		encoded := p.dataKey(key)
+		assert.True(t, strings.HasPrefix(encoded, "app:k:~"), name)
+		assert.LessOrEqual(t, len(encoded), maxKeyLength, name)
+		assert.True(t, validKey(encoded), name)
+		names[encoded] = true
+	}
+	assert.Len(t, names, len(hashed), "hashed keys are distinct")
+	assert.NotEqual(t, p.dataKey("~user"), p.dataKey(p.dataKey("~user")[len("app:k:"):]), "hashed and plain keys do not collide")
+
+	numeric, err := New[uint64, int](Config{Servers: []string{"localhost:11211"}})
+	require.NoError(t, err)
+	assert.Equal(t, "k:42", numeric.dataKey(42))
+}
+
+func TestExpiration(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+
+	assert.Equal(t, int32(0), expiration(0, now))
+	assert.Equal(t, int32(1), expiration(time.Millisecond, now))
+	assert.Equal(t, int32(2), expiration(1500*time.Millisecond, now))
+	assert.Equal(t, int32(30*24*60*60), expiration(relativeExpirationLimit, now))
+	assert.Equal(t, int32(now.Unix())+31*24*60*60, expiration(31*24*time.Hour, now))
+}
+
+func TestMapError(t *testing.T) {
+	assert.NoError(t, mapError(nil))
+	assert.True(t, errors.Is(mapError(memcache.ErrCacheMiss), errors.NotFound))
+	assert.True(t, errors.Is(mapError(memcache.ErrNoServers), errors.Unavailable))
+	assert.True(t, errors.Is(mapError(&net.OpError{Op: "dial", Err: baseErrors.New("refused")}), errors.Unavailable))
+
+	other := baseErrors.New("other")
+	assert.Equal(t, other, mapError(other))
+}
+
+func TestCodec(t *testing.T) {
+	data, err := encode(map[string]int{"a": 1})
+	require.NoError(t, err)
+	value, err := decode[map[string]int](data)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"a": 1}, value)
+
+	_, err = decode[int]([]byte("garbage"))
+	assert.True(t, errors.Is(err, errors.Corrupted))
+}
+
+func TestProvider_Closed(t *testing.T) {
+	p, err := New[string, int](Config{Servers: []string{"localhost:11211"}})
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, p.Store("key", 1), errors.Closed)
+	_, err = p.Get("key")
+	assert.ErrorIs(t, err, errors.Closed)
+	assert.True(t, errors.Is(p.Verify(), errors.Unsupported))
+}
+
+// TestProvider_Integration runs against the servers in MEMCACHED_SERVERS,
+// e.g. "localhost:11211".
+func TestProvider_Integration(t *testing.T) {
+	servers := os.Getenv("MEMCACHED_SERVERS")
+	if servers == "" {
+		t.Skip("MEMCACHED_SERVERS is not set")
+	}
+
+	p, err := New[string, int](Config{
+		Servers:   strings.Split(servers, ","),
+		KeyPrefix: fmt.Sprintf("storage-test-%d:", time.Now().UnixNano()),
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	defer func() {
+		require.NoError(t, p.Shutdown())
+	}()
+
+	_, err = p.Get("a")
+	assert.True(t, errors.Is(err, errors.NotFound))
+
+	require.NoError(t, p.Store("a", 1))
+	require.NoError(t, p.Store("b", 2))
+	val, err := p.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, 1, val)
+	values, err := p.GetMultiple([]string{"a", "b"})
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, values)
+	_, err = p.GetMultiple([]string{"a", "missing"})
+	assert.True(t, errors.Is(err, errors.NotFound))
+
+	require.NoError(t, p.Update("a", func(value int, exists bool) (int, error) {
+		assert.True(t, exists)
+		return value + 10, nil
+	}))
+	require.NoError(t, p.Update("c", func(value int, exists bool) (int, error) {
+		assert.False(t, exists)
+		return 3, nil
+	}))
+	val, err = p.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, 11, val)
+
+	assert.True(t, errors.Is(p.StoreIfAbsent("a", 0), errors.AlreadyExists))
+	require.NoError(t, p.StoreIfAbsent("d", 4))
+	assert.True(t, errors.Is(p.StoreIfPresent("missing", 0), errors.NotFound))
+	require.NoError(t, p.StoreIfPresent("d", 5))
+	require.NoError(t, p.StoreWithTTL("e", 6, time.Minute))
+	val, err = p.Get("e")
+	require.NoError(t, err)
+	assert.Equal(t, 6, val)
+
+	require.NoError(t, p.StoreReference("ref", "a"))
+	val, err = p.GetByReference("ref")
+	require.NoError(t, err)
+	assert.Equal(t, 11, val)
+	require.NoError(t, p.AddReference("ref", "b"))
+	values, err = p.GetAllByReference("ref")
+	require.NoError(t, err)
+	assert.Equal(t, []int{11, 2}, values)
+	require.NoError(t, p.StoreReference("outer", "ref"))
+	val, err = p.GetByReference("outer")
+	require.NoError(t, err)
+	assert.Equal(t, 11, val)
+	require.NoError(t, p.StoreReference("ref", "outer"))
+	_, err = p.GetByReference("outer")
+	assert.True(t, errors.Is(err, errors.ReferenceCycle))
+	require.NoError(t, p.StoreReference("ref", "a"))
+
+	require.NoError(t, p.RemoveReferenceTarget("ref", "a"))
+	assert.True(t, errors.Is(p.RemoveReferenceTarget("ref", "a"), errors.NotFound))
+	require.NoError(t, p.RemoveReference("outer"))
+	_, err = p.GetByReference("outer")
+	assert.True(t, errors.Is(err, errors.NotFound))
+
+	require.NoError(t, p.Remove("a"))
+	_, err = p.Get("a")
+	assert.True(t, errors.Is(err, errors.NotFound))
+	assert.True(t, errors.Is(p.Remove("a"), errors.NotFound))
+	assert.True(t, errors.Is(p.Clear(), errors.Unsupported), "Clear with key_prefix")
+	require.NoError(t, p.Ping(context.Background()))
+}
@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !noprometheus
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	storageErrors "github.com/rlshukhov/storage/errors"
+)
+
+// MetricsCollector is implemented by providers that export backend metrics.
+// Wrappers return nil if the provider they wrap does not.
+type MetricsCollector interface {
+	Collector() prometheus.Collector
+}
+
+// Collector returns a Prometheus collector for the backend internals of the
+// provider, register it with a prometheus.Registerer to export them.
+func Collector[K ~string | ~uint64, V any](provider KeyValueProvider[K, V]) (prometheus.Collector, error) {
+	if c, ok := provider.(MetricsCollector); ok {
+		if collector := c.Collector(); collector != nil {
+			return collector, nil
+		}
+	}
+
+	return nil, storageErrors.NewUnsupported(fmt.Errorf("%T does not export backend metrics", provider))
+}
+
+func innerCollector(inner any) prometheus.Collector {
+	if c, ok := inner.(MetricsCollector); ok {
+		return c.Collector()
+	}
+
+	return nil
+}
+
+func (p *lazyProvider[K, V]) Collector() prometheus.Collector {
+	return innerCollector(p.inner)
+}
+
+func (p *scheduledProvider[K, V]) Collector() prometheus.Collector {
+	return innerCollector(p.KeyValueProvider)
+}
@@ -0,0 +1,208 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !nobadger && !noprometheus
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/rlshukhov/storage/errors"
+	"github.com/rlshukhov/storage/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBadgerProvider_Metrics(t *testing.T) {
+	p, err := GetKeyValueProviderFromConfig[string, string](KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{DirectoryPath: nullable.FromValue(t.TempDir())}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	defer p.Shutdown()
+	require.NoError(t, p.Store("key", "value"))
+	_, err = p.Get("key")
+	require.NoError(t, err)
+
+	collector, err := Collector(p)
+	require.NoError(t, err)
+	gc, ok := p.(interface {
+		RunValueLogGC(discardRatio float64) (bool, error)
+	})
+	require.True(t, ok)
+	rewritten, err := gc.RunValueLogGC(0.5)
+	require.NoError(t, err)
+	assert.False(t, rewritten)
+
+	registry := prometheus.NewPedanticRegistry()
+	require.NoError(t, registry.Register(collector))
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	names := map[string]*dto.MetricFamily{}
+	for _, family := range families {
+		names[family.GetName()] = family
+	}
+	for _, name := range []string{
+		"storage_badger_lsm_size_bytes",
+		"storage_badger_vlog_size_bytes",
+		"storage_badger_level_tables",
+		"storage_badger_pending_compactions",
+		"storage_badger_cache_hits_total",
+		"storage_badger_vlog_gc_runs_total",
+	} {
+		assert.Contains(t, names, name)
+	}
+	assert.Equal(t, 1.0, names["storage_badger_vlog_gc_runs_total"].GetMetric()[0].GetCounter().GetValue())
+
+	fileProvider, err := GetKeyValueProviderFromConfig[string, string](KeyValueConfig{
+		File: nullable.FromValue(file.Config{Path: filepath.Join(t.TempDir(), "data.json")}),
+	})
+	require.NoError(t, err)
+	_, err = Collector(fileProvider)
+	assert.True(t, errors.Is(err, errors.Unsupported))
+}
+
+func TestBadgerProvider_Maintenance(t *testing.T) {
+	p, err := GetKeyValueProviderFromConfig[string, string](KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{
+			DirectoryPath: nullable.FromValue(t.TempDir()),
+			Maintenance:   nullable.FromValue(badger.MaintenanceConfig{Interval: 10 * time.Millisecond}),
+		}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	defer p.Shutdown()
+
+	for i := 0; i < 200; i++ {
+		require.NoError(t, p.Store(fmt.Sprintf("key-%03d", i), strings.Repeat("v", 100)))
+	}
+	_, err = p.RemovePrefix("key-1")
+	require.NoError(t, err)
+	require.NoError(t, Maintain(context.Background(), p))
+
+	val, err := p.Get("key-042")
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("v", 100), val)
+	_, err = p.Get("key-142")
+	assert.True(t, errors.Is(err, errors.NotFound))
+
+	collector, err := Collector(p)
+	require.NoError(t, err)
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(collector))
+	assert.Eventually(t, func() bool {
+		families, err := registry.Gather()
+		require.NoError(t, err)
+		for _, family := range families {
+			if family.GetName() == "storage_badger_vlog_gc_runs_total" {
+				return family.GetMetric()[0].GetCounter().GetValue() > 1
+			}
+		}
+		return false
+	}, time.Second, 10*time.Millisecond)
+
+	cfg := badger.Config{InMemory: true, Maintenance: nullable.FromValue(badger.MaintenanceConfig{At: "25:00"})}
+	assert.Error(t, cfg.Validate())
+}
+
+func TestBadgerProvider_Expiration(t *testing.T) {
+	p, err := GetKeyValueProviderFromConfig[string, string](KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{
+			InMemory:   true,
+			Expiration: nullable.FromValue(badger.ExpirationConfig{Interval: 50 * time.Millisecond, BatchSize: 2}),
+		}),
+	})
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	expired := map[string]string{}
+	require.NoError(t, OnExpire(p, func(key string, value string) {
+		mu.Lock()
+		defer mu.Unlock()
+		expired[key] = value
+	}))
+	require.NoError(t, p.Setup())
+	defer p.Shutdown()
+
+	expirer := p.(Expirer[string, string])
+	for i := 0; i < 5; i++ {
+		require.NoError(t, expirer.StoreWithTTL(fmt.Sprintf("session-%d", i), fmt.Sprintf("value-%d", i), time.Second))
+	}
+	require.NoError(t, expirer.StoreWithTTL("renewed", "old", time.Second))
+	require.NoError(t, p.Store("permanent", "value"))
+	require.NoError(t, p.Store("renewed", "new"))
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(expired) == 5
+	}, 5*time.Second, 50*time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, "value-3", expired["session-3"])
+	mu.Unlock()
+
+	val, err := p.Get("renewed")
+	require.NoError(t, err)
+	assert.Equal(t, "new", val)
+	_, err = p.Get("permanent")
+	require.NoError(t, err)
+	removed, err := SweepExpired(p)
+	require.NoError(t, err)
+	assert.Equal(t, 0, removed)
+
+	collector, err := Collector(p)
+	require.NoError(t, err)
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(collector))
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	var total float64
+	for _, family := range families {
+		if family.GetName() == "storage_badger_expired_total" {
+			total = family.GetMetric()[0].GetCounter().GetValue()
+		}
+	}
+	assert.Equal(t, float64(5), total)
+
+	cfg := badger.Config{InMemory: true, Expiration: nullable.FromValue(badger.ExpirationConfig{})}
+	assert.Error(t, cfg.Validate())
+}
+
+func TestWrappers_Collector(t *testing.T) {
+	p, err := GetKeyValueProviderFromConfig[string, string](KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true}),
+		Lazy:   nullable.FromValue(LazyConfig{}),
+		Backup: nullable.FromValue(BackupSchedule{Interval: time.Hour, Directory: t.TempDir()}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	defer p.Shutdown()
+
+	collector, err := Collector(p)
+	require.NoError(t, err)
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(collector))
+
+	f, err := GetKeyValueProviderFromConfig[string, string](KeyValueConfig{
+		File: nullable.FromValue(file.Config{Path: filepath.Join(t.TempDir(), "data.json")}),
+		Lazy: nullable.FromValue(LazyConfig{}),
+	})
+	require.NoError(t, err)
+	_, err = Collector(f)
+	assert.True(t, errors.Is(err, errors.Unsupported))
+}
@@ -0,0 +1,189 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package migration
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rlshukhov/storage"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"github.com/rlshukhov/storage/internal/transform"
+	"slices"
+)
+
+type Record struct {
+	Version int    `yaml:"version" json:"version"`
+	Data    string `yaml:"data" json:"data"`
+}
+
+type Migration struct {
+	Version int
+	Up      func(doc map[string]any) (map[string]any, error)
+}
+
+type provider[K ~string | ~uint64, V any] struct {
+	*transform.Provider[K, V, Record]
+	migrations []Migration
+	version    int
+}
+
+func New[K ~string | ~uint64, V any](inner storage.KeyValueProvider[K, Record], migrations ...Migration) (*provider[K, V], error) {
+	migrations = slices.Clone(migrations)
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return a.Version - b.Version
+	})
+
+	version := 0
+	for _, m := range migrations {
+		if m.Version <= 0 {
+			return nil, errors.New("migration version must be positive")
+		}
+		if m.Version == version {
+			return nil, fmt.Errorf("duplicate migration version %d", m.Version)
+		}
+		if m.Up == nil {
+			return nil, fmt.Errorf("migration %d has no transform function", m.Version)
+		}
+		version = m.Version
+	}
+
+	p := &provider[K, V]{
+		migrations: migrations,
+		version:    version,
+	}
+	p.Provider = &transform.Provider[K, V, Record]{
+		Inner:  inner,
+		Encode: p.encode,
+		Decode: p.decode,
+	}
+
+	return p, nil
+}
+
+func (p *provider[K, V]) Version() int {
+	return p.version
+}
+
+func (p *provider[K, V]) Get(key K) (V, error) {
+	record, err := p.Inner.Get(key)
+	if err != nil {
+		var v V
+		return v, err
+	}
+
+	if record.Version == p.version {
+		return p.decode(key, record)
+	}
+
+	value, err := p.decode(key, record)
+	if err != nil {
+		return value, err
+	}
+
+	err = p.upgrade(key)
+	if err != nil && !storageErrors.Is(err, storageErrors.NotFound) {
+		return value, err
+	}
+
+	return value, nil
+}
+
+func (p *provider[K, V]) MigrateAll() (int, error) {
+	var outdated []K
+	err := p.Inner.ForEach(func(key K, record Record) bool {
+		if record.Version != p.version {
+			outdated = append(outdated, key)
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, key := range outdated {
+		err := p.upgrade(key)
+		if storageErrors.Is(err, storageErrors.NotFound) {
+			continue
+		}
+		if err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+
+	return migrated, nil
+}
+
+func (p *provider[K, V]) upgrade(key K) error {
+	return p.Inner.Update(key, func(record Record, exists bool) (Record, error) {
+		if !exists {
+			return record, storageErrors.NotFound
+		}
+		if record.Version == p.version {
+			return record, nil
+		}
+
+		value, err := p.decode(key, record)
+		if err != nil {
+			return record, err
+		}
+
+		return p.encode(key, value)
+	})
+}
+
+func (p *provider[K, V]) encode(_ K, value V) (Record, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return Record{}, err
+	}
+
+	return Record{Version: p.version, Data: string(data)}, nil
+}
+
+func (p *provider[K, V]) decode(_ K, record Record) (V, error) {
+	var value V
+	if record.Version > p.version {
+		return value, fmt.Errorf("stored schema version %d is newer than %d", record.Version, p.version)
+	}
+
+	data := []byte(record.Data)
+	if record.Version < p.version {
+		var doc map[string]any
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return value, err
+		}
+
+		for _, m := range p.migrations {
+			if m.Version <= record.Version {
+				continue
+			}
+
+			var err error
+			doc, err = m.Up(doc)
+			if err != nil {
+				return value, fmt.Errorf("migration %d: %w", m.Version, err)
+			}
+		}
+
+		var err error
+		data, err = json.Marshal(doc)
+		if err != nil {
+			return value, err
+		}
+	}
+
+	if err := json.Unmarshal(data, &value); err != nil {
+		return value, err
+	}
+
+	return value, nil
+}
@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package migration
+
+import (
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+type userV1 struct {
+	Name string `json:"name"`
+}
+
+type userV2 struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+func TestMigration(t *testing.T) {
+	inner, err := storage.GetKeyValueProviderFromConfig[string, Record](storage.KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{
+			InMemory: true,
+		}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, inner.Setup())
+	defer func() {
+		require.NoError(t, inner.Shutdown())
+	}()
+
+	v1, err := New[string, userV1](inner)
+	require.NoError(t, err)
+	require.NoError(t, v1.Store("a", userV1{Name: "John Doe"}))
+	require.NoError(t, v1.Store("b", userV1{Name: "Jane Roe"}))
+
+	v2, err := New[string, userV2](inner, Migration{
+		Version: 1,
+		Up: func(doc map[string]any) (map[string]any, error) {
+			first, last, _ := strings.Cut(doc["name"].(string), " ")
+			return map[string]any{"first_name": first, "last_name": last}, nil
+		},
+	})
+	require.NoError(t, err)
+
+	user, err := v2.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, userV2{FirstName: "John", LastName: "Doe"}, user)
+
+	record, err := inner.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, 1, record.Version)
+
+	migrated, err := v2.MigrateAll()
+	require.NoError(t, err)
+	assert.Equal(t, 1, migrated)
+
+	record, err = inner.Get("b")
+	require.NoError(t, err)
+	assert.Equal(t, 1, record.Version)
+
+	_, err = v1.Get("b")
+	assert.Error(t, err)
+}
@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package mysql
+
+import (
+	"errors"
+	"fmt"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage/kv"
+	"regexp"
+	"time"
+)
+
+type Config struct {
+	DSN             string `yaml:"dsn"`
+	Table           string `yaml:"table,omitempty"`
+	ReferencesTable string `yaml:"references_table,omitempty"`
+	ValueFormat     string `yaml:"value_format,omitempty"`
+	ReadOnly        bool   `yaml:"read_only,omitempty"`
+
+	CreateSchema nullable.Nullable[bool] `yaml:"create_schema"`
+
+	Pool kv.PoolConfig                   `yaml:"pool,omitempty"`
+	TLS  nullable.Nullable[kv.TLSConfig] `yaml:"tls"`
+
+	// Deprecated: use Pool, which takes precedence.
+	MaxOpenConns    int           `yaml:"max_open_conns,omitempty"`
+	MaxIdleConns    int           `yaml:"max_idle_conns,omitempty"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime,omitempty"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time,omitempty"`
+
+	MaxReferenceDepth  int           `yaml:"max_reference_depth,omitempty"`
+	TransactionTimeout time.Duration `yaml:"transaction_timeout,omitempty"`
+}
+
+const (
+	FormatBlob = "blob"
+	FormatJSON = "json"
+
+	defaultTable             = "storage_kv"
+	maxKeyLength             = 767
+	defaultMaxReferenceDepth = 8
+)
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
+// pool returns Pool with the deprecated fields filling in what it leaves
+// unset.
+func (c Config) pool() kv.PoolConfig {
+	pool := c.Pool
+	if pool.MaxOpen == 0 {
+		pool.MaxOpen = c.MaxOpenConns
+	}
+	if pool.MaxIdle == 0 {
+		pool.MaxIdle = c.MaxIdleConns
+	}
+	if pool.MaxLifetime == 0 {
+		pool.MaxLifetime = c.ConnMaxLifetime
+	}
+	if pool.IdleTimeout == 0 {
+		pool.IdleTimeout = c.ConnMaxIdleTime
+	}
+
+	return pool
+}
+
+func (c Config) Validate() error {
+	var errs []error
+	if c.DSN == "" {
+		errs = append(errs, errors.New("dsn is required"))
+	} else if kv.HasSecrets(c.DSN) {
+		// Checked once the secrets are resolved in Setup.
+	} else if err := c.validateDSN(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Table != "" && !identifier.MatchString(c.Table) {
+		errs = append(errs, fmt.Errorf("invalid table name %q", c.Table))
+	}
+	if c.ReferencesTable != "" && !identifier.MatchString(c.ReferencesTable) {
+		errs = append(errs, fmt.Errorf("invalid references_table name %q", c.ReferencesTable))
+	}
+	if c.ValueFormat != "" && c.ValueFormat != FormatBlob && c.ValueFormat != FormatJSON {
+		errs = append(errs, fmt.Errorf("value_format must be %q or %q", FormatBlob, FormatJSON))
+	}
+	if c.MaxOpenConns < 0 {
+		errs = append(errs, errors.New("max_open_conns must not be negative"))
+	}
+	if c.MaxIdleConns < 0 {
+		errs = append(errs, errors.New("max_idle_conns must not be negative"))
+	}
+	if c.ConnMaxLifetime < 0 {
+		errs = append(errs, errors.New("conn_max_lifetime must not be negative"))
+	}
+	if c.ConnMaxIdleTime < 0 {
+		errs = append(errs, errors.New("conn_max_idle_time must not be negative"))
+	}
+	if err := c.Pool.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.TLS.HasValue() {
+		if err := c.TLS.GetValue().Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.MaxReferenceDepth < 0 {
+		errs = append(errs, errors.New("max_reference_depth must not be negative"))
+	}
+	if c.TransactionTimeout < 0 {
+		errs = append(errs, errors.New("transaction_timeout must not be negative"))
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package mysql
+
+import (
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage/kv"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	dsn := "user:password@tcp(localhost:3306)/app"
+	valid := map[string]Config{
+		"dsn":    {DSN: dsn},
+		"secret": {DSN: "${env:MYSQL_DSN}"},
+		"full": {
+			DSN:                dsn,
+			Table:              "kv",
+			ReferencesTable:    "kv_refs",
+			ValueFormat:        FormatJSON,
+			Pool:               kv.PoolConfig{MaxOpen: 4, MaxIdle: 2},
+			MaxReferenceDepth:  4,
+			TransactionTimeout: time.Second,
+		},
+	}
+	for name, cfg := range valid {
+		assert.NoError(t, cfg.Validate(), name)
+	}
+
+	invalid := map[string]Config{
+		"no dsn":               {},
+		"table":                {DSN: dsn, Table: "kv; DROP TABLE users"},
+		"references table":     {DSN: dsn, ReferencesTable: "1refs"},
+		"value format":         {DSN: dsn, ValueFormat: "xml"},
+		"negative open":        {DSN: dsn, MaxOpenConns: -1},
+		"negative idle":        {DSN: dsn, MaxIdleConns: -1},
+		"negative lifetime":    {DSN: dsn, ConnMaxLifetime: -time.Second},
+		"negative idle time":   {DSN: dsn, ConnMaxIdleTime: -time.Second},
+		"pool":                 {DSN: dsn, Pool: kv.PoolConfig{MaxOpen: 1, MaxIdle: 2}},
+		"tls":                  {DSN: dsn, TLS: nullable.FromValue(kv.TLSConfig{KeyFile: "key.pem"})},
+		"negative depth":       {DSN: dsn, MaxReferenceDepth: -1},
+		"negative transaction": {DSN: dsn, TransactionTimeout: -time.Second},
+	}
+	for name, cfg := range invalid {
+		assert.Error(t, cfg.Validate(), name)
+	}
+}
+
+func TestConfig_Pool(t *testing.T) {
+	cfg := Config{
+		Pool:            kv.PoolConfig{MaxOpen: 8},
+		MaxOpenConns:    4,
+		MaxIdleConns:    2,
+		ConnMaxLifetime: time.Minute,
+		ConnMaxIdleTime: time.Second,
+	}
+
+	assert.Equal(t, kv.PoolConfig{MaxOpen: 8, MaxIdle: 2, MaxLifetime: time.Minute, IdleTimeout: time.Second}, cfg.pool())
+}
@@ -0,0 +1,974 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !nomysql
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	sqlDriver "database/sql/driver"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	driver "github.com/go-sql-driver/mysql"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"github.com/rlshukhov/storage/kv"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	errLockWaitTimeout = 1205
+	errDeadlock        = 1213
+	errReadOnly        = 1290
+	errReadOnlyTx      = 1792
+	errSuperReadOnly   = 1836
+)
+
+func (c Config) validateDSN() error {
+	dsn, err := driver.ParseDSN(c.DSN)
+	if err != nil {
+		return fmt.Errorf("invalid dsn: %w", err)
+	}
+	if dsn.TLSConfig != "" && c.TLS.HasValue() {
+		return errors.New("tls and the tls parameter of dsn are mutually exclusive")
+	}
+
+	return nil
+}
+
+type provider[K ~string | ~uint64, V any] struct {
+	cfg          Config
+	pool         kv.PoolConfig
+	table        string
+	references   string
+	lastWrite    atomic.Int64
+	waitTimeouts atomic.Uint64
+
+	mu sync.RWMutex
+	db *sql.DB
+}
+
+func New[K ~string | ~uint64, V any](cfg Config) (*provider[K, V], error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Table == "" {
+		cfg.Table = defaultTable
+	}
+	if cfg.ReferencesTable == "" {
+		cfg.ReferencesTable = cfg.Table + "_references"
+	}
+	if cfg.ValueFormat == "" {
+		cfg.ValueFormat = FormatBlob
+	}
+	if cfg.MaxReferenceDepth == 0 {
+		cfg.MaxReferenceDepth = defaultMaxReferenceDepth
+	}
+
+	return &provider[K, V]{
+		cfg:        cfg,
+		pool:       cfg.pool(),
+		table:      "`" + cfg.Table + "`",
+		references: "`" + cfg.ReferencesTable + "`",
+	}, nil
+}
+
+func (p *provider[K, V]) Setup() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.db != nil {
+		return nil
+	}
+
+	db, err := p.open()
+	if err != nil {
+		return err
+	}
+	db.SetMaxOpenConns(p.pool.MaxOpen)
+	if p.pool.MaxIdle > 0 {
+		db.SetMaxIdleConns(p.pool.MaxIdle)
+	}
+	db.SetConnMaxLifetime(p.pool.MaxLifetime)
+	db.SetConnMaxIdleTime(p.pool.IdleTimeout)
+
+	ctx, cancel := p.context()
+	defer cancel()
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return mapError(err)
+	}
+	if p.cfg.CreateSchema.OrElse(true) && !p.cfg.ReadOnly {
+		if err := p.createSchema(ctx, db); err != nil {
+			db.Close()
+			return mapError(err)
+		}
+	}
+
+	p.db = db
+	return nil
+}
+
+func (p *provider[K, V]) createSchema(ctx context.Context, db *sql.DB) error {
+	valueType := "LONGBLOB"
+	if p.cfg.ValueFormat == FormatJSON {
+		valueType = "JSON"
+	}
+
+	statements := []string{
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s ("+
+			"`k` VARBINARY(%d) NOT NULL PRIMARY KEY, "+
+			"`v` %s NOT NULL, "+
+			"`updated_at` TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) ON UPDATE CURRENT_TIMESTAMP(6)"+
+			") ENGINE=InnoDB", p.table, maxKeyLength, valueType),
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s ("+
+			"`id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY, "+
+			"`ref` VARBINARY(%d) NOT NULL, "+
+			"`k` VARBINARY(%d) NOT NULL, "+
+			"UNIQUE KEY `ref_k` (`ref`, `k`)"+
+			") ENGINE=InnoDB", p.references, maxKeyLength, maxKeyLength),
+	}
+	for _, statement := range statements {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *provider[K, V]) open() (*sql.DB, error) {
+	ctx, cancel := p.context()
+	defer cancel()
+
+	resolved, err := kv.ResolveSecrets(ctx, p.cfg.DSN)
+	if err != nil {
+		return nil, err
+	}
+	dsn, err := driver.ParseDSN(resolved)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dsn: %w", err)
+	}
+	if !p.cfg.TLS.HasValue() {
+		return sql.Open("mysql", resolved)
+	}
+	if dsn.TLSConfig != "" {
+		return nil, errors.New("tls and the tls parameter of dsn are mutually exclusive")
+	}
+	if dsn.TLS, err = p.cfg.TLS.GetValue().Client(); err != nil {
+		return nil, err
+	}
+	connector, err := driver.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return sql.OpenDB(connector), nil
+}
+
+func (p *provider[K, V]) Shutdown() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.db == nil {
+		return nil
+	}
+
+	err := p.db.Close()
+	p.db = nil
+	return err
+}
+
+func (p *provider[K, V]) Close() error {
+	return p.Shutdown()
+}
+
+func (p *provider[K, V]) Ping(ctx context.Context) error {
+	db, release, err := p.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return mapError(db.PingContext(ctx))
+}
+
+func (p *provider[K, V]) Stats() (kv.ProviderStats, error) {
+	stats := kv.ProviderStats{Provider: "mysql"}
+
+	ctx, cancel := p.context()
+	defer cancel()
+
+	db, release, err := p.conn(ctx)
+	if err != nil {
+		return stats, err
+	}
+	defer release()
+
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+p.table).Scan(&stats.Entries); err != nil {
+		return stats, mapError(err)
+	}
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(DISTINCT `ref`) FROM "+p.references).Scan(&stats.References); err != nil {
+		return stats, mapError(err)
+	}
+
+	size, err := p.tableSize(ctx, db)
+	if err != nil {
+		return stats, err
+	}
+	stats.DiskUsage = int64(size)
+
+	if lastWrite := p.lastWrite.Load(); lastWrite > 0 {
+		stats.LastFlush = time.Unix(0, lastWrite)
+	}
+
+	stats.Backend = map[string]any{
+		"table":            p.cfg.Table,
+		"references_table": p.cfg.ReferencesTable,
+		"value_format":     p.cfg.ValueFormat,
+	}
+	stats.Pool = p.poolStats()
+
+	return stats, nil
+}
+
+func (p *provider[K, V]) ApproximateSize() (uint64, error) {
+	ctx, cancel := p.context()
+	defer cancel()
+
+	db, release, err := p.conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	return p.tableSize(ctx, db)
+}
+
+func (p *provider[K, V]) tableSize(ctx context.Context, db sqlConn) (uint64, error) {
+	var size uint64
+	err := db.QueryRowContext(ctx,
+		"SELECT CAST(COALESCE(SUM(`data_length` + `index_length`), 0) AS UNSIGNED) FROM `information_schema`.`tables` "+
+			"WHERE `table_schema` = DATABASE() AND `table_name` IN (?, ?)",
+		p.cfg.Table, p.cfg.ReferencesTable,
+	).Scan(&size)
+	if err != nil {
+		return 0, mapError(err)
+	}
+
+	return size, nil
+}
+
+func (p *provider[K, V]) Store(key K, value V) error {
+	v, err := p.encode(value)
+	if err != nil {
+		return err
+	}
+
+	return p.exec("INSERT INTO "+p.table+" (`k`, `v`) VALUES (?, ?) ON DUPLICATE KEY UPDATE `v` = VALUES(`v`)", keyToBytes(key), v)
+}
+
+func (p *provider[K, V]) Update(key K, fn func(value V, exists bool) (V, error)) error {
+	return p.transaction(func(ctx context.Context, tx *sql.Tx) error {
+		k := keyToBytes(key)
+
+		var raw []byte
+		err := tx.QueryRowContext(ctx, "SELECT `v` FROM "+p.table+" WHERE `k` = ? FOR UPDATE", k).Scan(&raw)
+		exists := err == nil
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		var value V
+		if exists {
+			if value, err = p.decode(raw); err != nil {
+				return err
+			}
+		}
+
+		value, err = fn(value, exists)
+		if err != nil {
+			return err
+		}
+
+		v, err := p.encode(value)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "INSERT INTO "+p.table+" (`k`, `v`) VALUES (?, ?) ON DUPLICATE KEY UPDATE `v` = VALUES(`v`)", k, v)
+		return err
+	})
+}
+
+func (p *provider[K, V]) Get(key K) (V, error) {
+	var value V
+
+	ctx, cancel := p.context()
+	defer cancel()
+
+	db, release, err := p.conn(ctx)
+	if err != nil {
+		return value, err
+	}
+	defer release()
+
+	var raw []byte
+	if err := db.QueryRowContext(ctx, "SELECT `v` FROM "+p.table+" WHERE `k` = ?", keyToBytes(key)).Scan(&raw); err != nil {
+		return value, mapError(err)
+	}
+
+	return p.decode(raw)
+}
+
+func (p *provider[K, V]) GetMultiple(keys []K) ([]V, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	found := map[string]V{}
+	args := make([]any, len(keys))
+	for i, key := range keys {
+		args[i] = keyToBytes(key)
+	}
+
+	err := p.query("SELECT `k`, `v` FROM "+p.table+" WHERE `k` IN ("+placeholders(len(keys))+")", args, func(rows *sql.Rows) (bool, error) {
+		var k, raw []byte
+		if err := rows.Scan(&k, &raw); err != nil {
+			return false, err
+		}
+
+		v, err := p.decode(raw)
+		if err != nil {
+			return false, err
+		}
+		found[string(k)] = v
+		return true, nil
+	})
+	if err != nil {
+		return []V{}, err
+	}
+
+	values := make([]V, 0, len(keys))
+	for _, key := range keys {
+		v, ok := found[string(keyToBytes(key))]
+		if !ok {
+			return []V{}, storageErrors.NewNotFound(fmt.Errorf("key %v", key))
+		}
+		values = append(values, v)
+	}
+
+	return values, nil
+}
+
+func (p *provider[K, V]) Remove(key K) error {
+	return p.exec("DELETE FROM "+p.table+" WHERE `k` = ?", keyToBytes(key))
+}
+
+func (p *provider[K, V]) RemovePrefix(prefix K) (int, error) {
+	condition, args := prefixCondition(keyToBytes(prefix))
+
+	var removed int64
+	err := p.transaction(func(ctx context.Context, tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, "DELETE FROM "+p.table+" WHERE "+condition, args...)
+		if err != nil {
+			return err
+		}
+
+		removed, err = result.RowsAffected()
+		return err
+	})
+
+	return int(removed), err
+}
+
+func (p *provider[K, V]) RemoveWhere(pred func(key K, value V) bool) (int, error) {
+	var keys []any
+	err := p.iterate(nil, func(key K, value V) bool {
+		if pred(key, value) {
+			keys = append(keys, keyToBytes(key))
+		}
+		return true
+	})
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+
+	err = p.transaction(func(ctx context.Context, tx *sql.Tx) error {
+		for _, k := range keys {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM "+p.table+" WHERE `k` = ?", k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(keys), nil
+}
+
+func (p *provider[K, V]) Clear() error {
+	return p.transaction(func(ctx context.Context, tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+p.table); err != nil {
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx, "DELETE FROM "+p.references)
+		return err
+	})
+}
+
+func (p *provider[K, V]) ForEach(fn func(key K, value V) bool) error {
+	return p.iterate(nil, fn)
+}
+
+func (p *provider[K, V]) ForEachPrefix(prefix K, fn func(key K, value V) bool) error {
+	return p.iterate(keyToBytes(prefix), fn)
+}
+
+func (p *provider[K, V]) iterate(prefix []byte, fn func(key K, value V) bool) error {
+	condition, args := prefixCondition(prefix)
+
+	return p.query("SELECT `k`, `v` FROM "+p.table+" WHERE "+condition+" ORDER BY `k`", args, func(rows *sql.Rows) (bool, error) {
+		var k, raw []byte
+		if err := rows.Scan(&k, &raw); err != nil {
+			return false, err
+		}
+
+		key, err := bytesToKey[K](k)
+		if err != nil {
+			return false, err
+		}
+		value, err := p.decode(raw)
+		if err != nil {
+			return false, err
+		}
+
+		return fn(key, value), nil
+	})
+}
+
+func (p *provider[K, V]) ForEachKey(fn func(key K) bool) error {
+	return p.query("SELECT `k` FROM "+p.table+" ORDER BY `k`", nil, func(rows *sql.Rows) (bool, error) {
+		var k []byte
+		if err := rows.Scan(&k); err != nil {
+			return false, err
+		}
+
+		key, err := bytesToKey[K](k)
+		if err != nil {
+			return false, err
+		}
+
+		return fn(key), nil
+	})
+}
+
+func (p *provider[K, V]) ForEachReference(fn func(reference K, key K) bool) error {
+	return p.query("SELECT `ref`, `k` FROM "+p.references+" ORDER BY `ref`, `id`", nil, func(rows *sql.Rows) (bool, error) {
+		var r, k []byte
+		if err := rows.Scan(&r, &k); err != nil {
+			return false, err
+		}
+
+		reference, err := bytesToKey[K](r)
+		if err != nil {
+			return false, err
+		}
+		key, err := bytesToKey[K](k)
+		if err != nil {
+			return false, err
+		}
+
+		return fn(reference, key), nil
+	})
+}
+
+func (p *provider[K, V]) StoreReference(reference K, key K) error {
+	return p.transaction(func(ctx context.Context, tx *sql.Tx) error {
+		r := keyToBytes(reference)
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+p.references+" WHERE `ref` = ?", r); err != nil {
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx, "INSERT INTO "+p.references+" (`ref`, `k`) VALUES (?, ?)", r, keyToBytes(key))
+		return err
+	})
+}
+
+// StoreWithReferences stores the value and points every reference to key in
+// one transaction.
+func (p *provider[K, V]) StoreWithReferences(key K, value V, references ...K) error {
+	v, err := p.encode(value)
+	if err != nil {
+		return err
+	}
+
+	return p.transaction(func(ctx context.Context, tx *sql.Tx) error {
+		k := keyToBytes(key)
+		if _, err := tx.ExecContext(ctx, "INSERT INTO "+p.table+" (`k`, `v`) VALUES (?, ?) ON DUPLICATE KEY UPDATE `v` = VALUES(`v`)", k, v); err != nil {
+			return err
+		}
+
+		for _, reference := range references {
+			r := keyToBytes(reference)
+			if _, err := tx.ExecContext(ctx, "DELETE FROM "+p.references+" WHERE `ref` = ?", r); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, "INSERT INTO "+p.references+" (`ref`, `k`) VALUES (?, ?)", r, k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *provider[K, V]) AddReference(reference K, key K) error {
+	return p.exec("INSERT IGNORE INTO "+p.references+" (`ref`, `k`) VALUES (?, ?)", keyToBytes(reference), keyToBytes(key))
+}
+
+func (p *provider[K, V]) RemoveReference(reference K) error {
+	return p.exec("DELETE FROM "+p.references+" WHERE `ref` = ?", keyToBytes(reference))
+}
+
+func (p *provider[K, V]) RemoveReferenceTarget(reference K, key K) error {
+	return p.transaction(func(ctx context.Context, tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, "DELETE FROM "+p.references+" WHERE `ref` = ? AND `k` = ?", keyToBytes(reference), keyToBytes(key))
+		if err != nil {
+			return err
+		}
+
+		removed, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if removed == 0 {
+			return storageErrors.NewNotFound(fmt.Errorf("reference %v does not point to %v", reference, key))
+		}
+		return nil
+	})
+}
+
+func (p *provider[K, V]) GetByReference(reference K) (V, error) {
+	keys, err := p.resolve(keyToBytes(reference), 0, map[string]bool{})
+	if err != nil {
+		var v V
+		return v, err
+	}
+
+	key, err := bytesToKey[K](keys[0])
+	if err != nil {
+		var v V
+		return v, err
+	}
+
+	return p.Get(key)
+}
+
+func (p *provider[K, V]) GetAllByReference(reference K) ([]V, error) {
+	keys, err := p.resolve(keyToBytes(reference), 0, map[string]bool{})
+	if err != nil {
+		return nil, err
+	}
+
+	var values []V
+	for _, k := range keys {
+		key, err := bytesToKey[K](k)
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := p.Get(key)
+		if storageErrors.Is(err, storageErrors.NotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+
+	return values, nil
+}
+
+func (p *provider[K, V]) resolve(r []byte, depth int, path map[string]bool) ([][]byte, error) {
+	if path[string(r)] {
+		return nil, storageErrors.NewReferenceCycle(fmt.Errorf("reference %q", r))
+	}
+	if depth >= p.cfg.MaxReferenceDepth {
+		return nil, storageErrors.NewReferenceTooDeep(fmt.Errorf("reference %q exceeds depth %d", r, p.cfg.MaxReferenceDepth))
+	}
+
+	targets, err := p.targets(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, storageErrors.NewNotFound(fmt.Errorf("reference %q", r))
+	}
+
+	path[string(r)] = true
+	defer delete(path, string(r))
+
+	var keys [][]byte
+	for _, t := range targets {
+		chained, err := p.targets(t)
+		if err != nil {
+			return nil, err
+		}
+		if len(chained) == 0 {
+			keys = append(keys, t)
+			continue
+		}
+
+		resolved, err := p.resolve(t, depth+1, path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, resolved...)
+	}
+
+	return keys, nil
+}
+
+func (p *provider[K, V]) targets(r []byte) ([][]byte, error) {
+	var targets [][]byte
+	err := p.query("SELECT `k` FROM "+p.references+" WHERE `ref` = ? ORDER BY `id`", []any{r}, func(rows *sql.Rows) (bool, error) {
+		var k []byte
+		if err := rows.Scan(&k); err != nil {
+			return false, err
+		}
+		targets = append(targets, k)
+		return true, nil
+	})
+
+	return targets, err
+}
+
+func (p *provider[K, V]) Verify() error {
+	var errs []error
+	err := p.query("SELECT `k`, `v` FROM "+p.table+" ORDER BY `k`", nil, func(rows *sql.Rows) (bool, error) {
+		var k, raw []byte
+		if err := rows.Scan(&k, &raw); err != nil {
+			return false, err
+		}
+
+		if _, err := bytesToKey[K](k); err != nil {
+			errs = append(errs, fmt.Errorf("key %q: %w", k, err))
+		}
+		if _, err := p.decode(raw); err != nil {
+			errs = append(errs, fmt.Errorf("key %q: %w", k, err))
+		}
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return errors.Join(errs...)
+}
+
+type backupRecord struct {
+	Key       []byte `json:"key"`
+	Value     []byte `json:"value,omitempty"`
+	Reference []byte `json:"reference,omitempty"`
+}
+
+func (p *provider[K, V]) Backup(w io.Writer) error {
+	enc := json.NewEncoder(w)
+
+	err := p.query("SELECT `k`, `v` FROM "+p.table+" ORDER BY `k`", nil, func(rows *sql.Rows) (bool, error) {
+		var record backupRecord
+		if err := rows.Scan(&record.Key, &record.Value); err != nil {
+			return false, err
+		}
+
+		return true, enc.Encode(record)
+	})
+	if err != nil {
+		return err
+	}
+
+	return p.query("SELECT `ref`, `k` FROM "+p.references+" ORDER BY `ref`, `id`", nil, func(rows *sql.Rows) (bool, error) {
+		var record backupRecord
+		if err := rows.Scan(&record.Reference, &record.Key); err != nil {
+			return false, err
+		}
+
+		return true, enc.Encode(record)
+	})
+}
+
+// sqlConn is what operations run on: the pool itself, or a connection taken
+// from it when pool.wait_timeout is set.
+type sqlConn interface {
+	PingContext(ctx context.Context) error
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// conn returns the connection to run an operation on and a function to
+// release it. With pool.wait_timeout a connection is taken from the pool up
+// front, so waiting for it can time out separately from the operation.
+func (p *provider[K, V]) conn(ctx context.Context) (sqlConn, func(), error) {
+	p.mu.RLock()
+	db := p.db
+	p.mu.RUnlock()
+
+	if db == nil {
+		return nil, nil, storageErrors.NewClosed(errors.New("database is not open"))
+	}
+	if p.pool.WaitTimeout <= 0 {
+		return db, func() {}, nil
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, p.pool.WaitTimeout)
+	defer cancel()
+
+	c, err := db.Conn(waitCtx)
+	if err != nil {
+		if ctx.Err() == nil && errors.Is(waitCtx.Err(), context.DeadlineExceeded) {
+			p.waitTimeouts.Add(1)
+			return nil, nil, storageErrors.NewUnavailable(fmt.Errorf("no connection became free within %s", p.pool.WaitTimeout))
+		}
+		return nil, nil, mapError(err)
+	}
+
+	return c, func() { c.Close() }, nil
+}
+
+func (p *provider[K, V]) poolStats() *kv.PoolStats {
+	p.mu.RLock()
+	db := p.db
+	p.mu.RUnlock()
+
+	stats := &kv.PoolStats{MaxOpen: p.pool.MaxOpen, WaitTimeouts: p.waitTimeouts.Load()}
+	if db != nil {
+		dbStats := db.Stats()
+		stats.Open = dbStats.OpenConnections
+		stats.InUse = dbStats.InUse
+		stats.Idle = dbStats.Idle
+		stats.WaitCount = dbStats.WaitCount
+		stats.WaitDuration = dbStats.WaitDuration
+	}
+
+	return stats
+}
+
+func (p *provider[K, V]) context() (context.Context, context.CancelFunc) {
+	if p.cfg.TransactionTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+
+	return context.WithTimeout(context.Background(), p.cfg.TransactionTimeout)
+}
+
+func (p *provider[K, V]) exec(query string, args ...any) error {
+	if p.cfg.ReadOnly {
+		return storageErrors.ReadOnly
+	}
+
+	ctx, cancel := p.context()
+	defer cancel()
+
+	db, release, err := p.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if _, err := db.ExecContext(ctx, query, args...); err != nil {
+		return mapError(err)
+	}
+
+	p.lastWrite.Store(time.Now().UnixNano())
+	return nil
+}
+
+func (p *provider[K, V]) transaction(fn func(ctx context.Context, tx *sql.Tx) error) error {
+	if p.cfg.ReadOnly {
+		return storageErrors.ReadOnly
+	}
+
+	ctx, cancel := p.context()
+	defer cancel()
+
+	db, release, err := p.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return mapError(err)
+	}
+
+	if err := fn(ctx, tx); err != nil {
+		return errors.Join(mapError(err), ignoreDone(tx.Rollback()))
+	}
+	if err := tx.Commit(); err != nil {
+		return mapError(err)
+	}
+
+	p.lastWrite.Store(time.Now().UnixNano())
+	return nil
+}
+
+func (p *provider[K, V]) query(query string, args []any, fn func(rows *sql.Rows) (bool, error)) error {
+	ctx, cancel := p.context()
+	defer cancel()
+
+	db, release, err := p.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return mapError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		next, err := fn(rows)
+		if err != nil {
+			return mapError(err)
+		}
+		if !next {
+			return nil
+		}
+	}
+
+	return mapError(rows.Err())
+}
+
+func (p *provider[K, V]) encode(value V) ([]byte, error) {
+	if p.cfg.ValueFormat == FormatJSON {
+		return json.Marshal(value)
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (p *provider[K, V]) decode(data []byte) (V, error) {
+	var value V
+
+	var err error
+	if p.cfg.ValueFormat == FormatJSON {
+		err = json.Unmarshal(data, &value)
+	} else {
+		err = gob.NewDecoder(bytes.NewReader(data)).Decode(&value)
+	}
+	if err != nil {
+		return value, storageErrors.NewCorrupted(err)
+	}
+
+	return value, nil
+}
+
+func mapError(err error) error {
+	var mysqlErr *driver.MySQLError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, sql.ErrNoRows):
+		return storageErrors.NewNotFound(err)
+	case errors.Is(err, context.DeadlineExceeded):
+		// Checked before net.Error, which context.DeadlineExceeded implements.
+		return storageErrors.NewTimeout(err)
+	case errors.Is(err, sql.ErrConnDone), errors.Is(err, sqlDriver.ErrBadConn), errors.Is(err, driver.ErrInvalidConn), errors.As(err, &netErr):
+		return storageErrors.NewUnavailable(err)
+	case errors.As(err, &mysqlErr):
+		switch mysqlErr.Number {
+		case errDeadlock, errLockWaitTimeout:
+			return storageErrors.NewConflict(err)
+		case errReadOnly, errReadOnlyTx, errSuperReadOnly:
+			return storageErrors.NewReadOnly(err)
+		}
+	}
+
+	return err
+}
+
+func ignoreDone(err error) error {
+	if errors.Is(err, sql.ErrTxDone) {
+		return nil
+	}
+
+	return err
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func prefixCondition(prefix []byte) (string, []any) {
+	if len(prefix) == 0 {
+		return "1 = 1", nil
+	}
+
+	limit := bytes.Clone(prefix)
+	for i := len(limit) - 1; i >= 0; i-- {
+		if limit[i] < 0xff {
+			limit[i]++
+			return "`k` >= ? AND `k` < ?", []any{prefix, limit[:i+1]}
+		}
+	}
+
+	return "`k` >= ?", []any{prefix}
+}
+
+func keyToBytes[K ~string | ~uint64](key K) []byte {
+	v := reflect.ValueOf(key)
+	if v.Kind() == reflect.Uint64 {
+		return strconv.AppendUint(nil, v.Uint(), 10)
+	}
+
+	return []byte(v.String())
+}
+
+func bytesToKey[K ~string | ~uint64](b []byte) (K, error) {
+	var key K
+	v := reflect.ValueOf(&key).Elem()
+	if v.Kind() != reflect.Uint64 {
+		v.SetString(string(b))
+		return key, nil
+	}
+
+	n, err := strconv.ParseUint(string(b), 10, 64)
+	if err != nil {
+		return key, storageErrors.NewCorrupted(fmt.Errorf("key %q is not a uint64", b))
+	}
+	v.SetUint(n)
+
+	return key, nil
+}
@@ -0,0 +1,17 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build nomysql
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package mysql
+
+// validateDSN can not parse the DSN without the driver, which builds with
+// -tags nomysql leave out.
+func (c Config) validateDSN() error {
+	return nil
+}
@@ -0,0 +1,243 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !nomysql
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	sqlDriver "database/sql/driver"
+	"errors"
+	"fmt"
+	driver "github.com/go-sql-driver/mysql"
+	"github.com/rlshukhov/nullable"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"github.com/rlshukhov/storage/kv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestConfig_ValidateDSN(t *testing.T) {
+	assert.Error(t, Config{DSN: "not a dsn"}.Validate())
+	assert.Error(t, Config{
+		DSN: "user:password@tcp(localhost:3306)/app?tls=true",
+		TLS: nullable.FromValue(kv.TLSConfig{}),
+	}.Validate())
+	assert.NoError(t, Config{DSN: "user:password@tcp(localhost:3306)/app?tls=true"}.Validate())
+}
+
+func TestKeys(t *testing.T) {
+	assert.Equal(t, []byte("user"), keyToBytes("user"))
+	assert.Equal(t, []byte("42"), keyToBytes(uint64(42)))
+
+	key, err := bytesToKey[string]([]byte("user"))
+	require.NoError(t, err)
+	assert.Equal(t, "user", key)
+	number, err := bytesToKey[uint64]([]byte("42"))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(42), number)
+	_, err = bytesToKey[uint64]([]byte("user"))
+	assert.True(t, storageErrors.Is(err, storageErrors.Corrupted))
+
+	assert.Equal(t, "?, ?, ?", placeholders(3))
+	assert.Equal(t, "?", placeholders(1))
+}
+
+func TestPrefixCondition(t *testing.T) {
+	condition, args := prefixCondition(nil)
+	assert.Equal(t, "1 = 1", condition)
+	assert.Empty(t, args)
+
+	condition, args = prefixCondition([]byte("user/"))
+	assert.Equal(t, "`k` >= ? AND `k` < ?", condition)
+	assert.Equal(t, []any{[]byte("user/"), []byte("user0")}, args)
+
+	_, args = prefixCondition([]byte{'a', 0xff, 0xff})
+	assert.Equal(t, []any{[]byte{'a', 0xff, 0xff}, []byte{'b'}}, args)
+
+	condition, args = prefixCondition([]byte{0xff, 0xff})
+	assert.Equal(t, "`k` >= ?", condition)
+	assert.Equal(t, []any{[]byte{0xff, 0xff}}, args)
+
+	prefix := []byte("user/")
+	prefixCondition(prefix)
+	assert.Equal(t, []byte("user/"), prefix, "the prefix is not modified")
+
+	// Every key with the prefix sorts between the bounds, others do not.
+	_, args = prefixCondition([]byte("a\xff"))
+	lower, upper := args[0].([]byte), args[1].([]byte)
+	for key, matches := range map[string]bool{"a\xff": true, "a\xff\xff\x00": true, "a\xfe": false, "b": false} {
+		within := bytes.Compare([]byte(key), lower) >= 0 && bytes.Compare([]byte(key), upper) < 0
+		assert.Equal(t, matches, within, "%q", key)
+	}
+}
+
+func TestMapError(t *testing.T) {
+	assert.NoError(t, mapError(nil))
+
+	mapped := map[error]error{
+		sql.ErrNoRows:                                  storageErrors.NotFound,
+		sql.ErrConnDone:                                storageErrors.Unavailable,
+		sqlDriver.ErrBadConn:                           storageErrors.Unavailable,
+		driver.ErrInvalidConn:                          storageErrors.Unavailable,
+		&net.OpError{Op: "dial"}:                       storageErrors.Unavailable,
+		context.DeadlineExceeded:                       storageErrors.Timeout,
+		&driver.MySQLError{Number: errDeadlock}:        storageErrors.Conflict,
+		&driver.MySQLError{Number: errLockWaitTimeout}: storageErrors.Conflict,
+		&driver.MySQLError{Number: errReadOnly}:        storageErrors.ReadOnly,
+		&driver.MySQLError{Number: errReadOnlyTx}:      storageErrors.ReadOnly,
+		&driver.MySQLError{Number: errSuperReadOnly}:   storageErrors.ReadOnly,
+		fmt.Errorf("query: %w", sql.ErrNoRows):         storageErrors.NotFound,
+	}
+	for err, kind := range mapped {
+		assert.True(t, storageErrors.Is(mapError(err), kind), "%v", err)
+	}
+
+	duplicate := &driver.MySQLError{Number: 1062}
+	assert.Equal(t, duplicate, mapError(duplicate))
+}
+
+func TestProvider_Codec(t *testing.T) {
+	for _, format := range []string{FormatBlob, FormatJSON} {
+		p, err := New[string, map[string]int](Config{DSN: "user@tcp(localhost:3306)/app", ValueFormat: format})
+		require.NoError(t, err)
+
+		data, err := p.encode(map[string]int{"a": 1})
+		require.NoError(t, err)
+		value, err := p.decode(data)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int{"a": 1}, value, format)
+
+		_, err = p.decode([]byte("garbage"))
+		assert.True(t, storageErrors.Is(err, storageErrors.Corrupted), format)
+	}
+}
+
+func TestProvider_Closed(t *testing.T) {
+	p, err := New[string, int](Config{DSN: "user@tcp(localhost:3306)/app"})
+	require.NoError(t, err)
+
+	assert.True(t, storageErrors.Is(p.Store("key", 1), storageErrors.Closed))
+	_, err = p.Get("key")
+	assert.True(t, storageErrors.Is(err, storageErrors.Closed))
+
+	p, err = New[string, int](Config{DSN: "user@tcp(localhost:3306)/app", ReadOnly: true})
+	require.NoError(t, err)
+	assert.ErrorIs(t, p.Store("key", 1), storageErrors.ReadOnly)
+}
+
+// TestProvider_Integration runs against the database in MYSQL_DSN, e.g.
+// "root:password@tcp(localhost:3306)/test". It creates and drops its own
+// tables.
+func TestProvider_Integration(t *testing.T) {
+	dsn := os.Getenv("MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("MYSQL_DSN is not set")
+	}
+
+	for _, format := range []string{FormatBlob, FormatJSON} {
+		t.Run(format, func(t *testing.T) {
+			p, err := New[string, int](Config{
+				DSN:         dsn,
+				Table:       fmt.Sprintf("storage_test_%d", time.Now().UnixNano()),
+				ValueFormat: format,
+			})
+			require.NoError(t, err)
+			require.NoError(t, p.Setup())
+			defer func() {
+				for _, table := range []string{p.references, p.table} {
+					_, err := p.db.Exec("DROP TABLE " + table)
+					assert.NoError(t, err)
+				}
+				require.NoError(t, p.Shutdown())
+			}()
+
+			_, err = p.Get("a")
+			assert.True(t, storageErrors.Is(err, storageErrors.NotFound))
+
+			require.NoError(t, p.Store("a", 1))
+			require.NoError(t, p.Store("b/1", 2))
+			require.NoError(t, p.Store("b/2", 3))
+			require.NoError(t, p.Store("a", 4))
+			val, err := p.Get("a")
+			require.NoError(t, err)
+			assert.Equal(t, 4, val)
+			values, err := p.GetMultiple([]string{"b/2", "a"})
+			require.NoError(t, err)
+			assert.Equal(t, []int{3, 4}, values)
+			_, err = p.GetMultiple([]string{"a", "missing"})
+			assert.True(t, storageErrors.Is(err, storageErrors.NotFound))
+
+			require.NoError(t, p.Update("a", func(value int, exists bool) (int, error) {
+				assert.True(t, exists)
+				return value + 10, nil
+			}))
+			failed := errors.New("failed")
+			assert.ErrorIs(t, p.Update("a", func(value int, exists bool) (int, error) {
+				return 0, failed
+			}), failed)
+			val, err = p.Get("a")
+			require.NoError(t, err)
+			assert.Equal(t, 14, val)
+
+			var keys []string
+			require.NoError(t, p.ForEachPrefix("b/", func(key string, value int) bool {
+				keys = append(keys, key)
+				return true
+			}))
+			assert.Equal(t, []string{"b/1", "b/2"}, keys)
+
+			require.NoError(t, p.StoreReference("ref", "a"))
+			require.NoError(t, p.AddReference("ref", "b/1"))
+			require.NoError(t, p.AddReference("ref", "b/1"))
+			val, err = p.GetByReference("ref")
+			require.NoError(t, err)
+			assert.Equal(t, 14, val)
+			values, err = p.GetAllByReference("ref")
+			require.NoError(t, err)
+			assert.Equal(t, []int{14, 2}, values)
+			require.NoError(t, p.StoreReference("outer", "ref"))
+			require.NoError(t, p.StoreReference("ref", "outer"))
+			_, err = p.GetByReference("outer")
+			assert.True(t, storageErrors.Is(err, storageErrors.ReferenceCycle))
+			assert.True(t, storageErrors.Is(p.RemoveReferenceTarget("ref", "a"), storageErrors.NotFound))
+			require.NoError(t, p.RemoveReferenceTarget("ref", "outer"))
+			require.NoError(t, p.RemoveReference("outer"))
+
+			removed, err := p.RemovePrefix("b/")
+			require.NoError(t, err)
+			assert.Equal(t, 2, removed)
+			removed, err = p.RemoveWhere(func(key string, value int) bool { return value == 14 })
+			require.NoError(t, err)
+			assert.Equal(t, 1, removed)
+
+			require.NoError(t, p.Store("c", 5))
+			require.NoError(t, p.Verify())
+			var backup bytes.Buffer
+			require.NoError(t, p.Backup(&backup))
+			assert.NotEmpty(t, backup.Bytes())
+			stats, err := p.Stats()
+			require.NoError(t, err)
+			assert.Equal(t, uint64(1), stats.Entries)
+
+			require.NoError(t, p.Clear())
+			require.NoError(t, p.ForEachKey(func(key string) bool {
+				t.Errorf("key %q is left after Clear", key)
+				return true
+			}))
+			require.NoError(t, p.Ping(context.Background()))
+		})
+	}
+}
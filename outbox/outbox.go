@@ -0,0 +1,366 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+// Package outbox stores a value together with the events that announce it and
+// relays the events to a publisher, so an event is never lost when the
+// process stops between the write and the publish.
+package outbox
+
+import (
+	"context"
+	baseErrors "errors"
+	"fmt"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/errors"
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Config struct {
+	// Interval is how often the relay retries events that are due.
+	Interval time.Duration `yaml:"interval,omitempty"`
+	// ScanInterval is how often the relay reads every record to find events
+	// written by other processes.
+	ScanInterval time.Duration `yaml:"scan_interval,omitempty"`
+	// Backoff is the delay before the first retry of a failed publish. It
+	// doubles with every attempt up to MaxBackoff.
+	Backoff    time.Duration `yaml:"backoff,omitempty"`
+	MaxBackoff time.Duration `yaml:"max_backoff,omitempty"`
+
+	// Markers records the IDs of published events, so an event is not
+	// published again when it could not be removed from its record.
+	Markers         storage.KeyValueProvider[string, time.Time] `yaml:"-"`
+	MarkerRetention time.Duration                               `yaml:"marker_retention,omitempty"`
+
+	OnError func(err error) `yaml:"-"`
+}
+
+const (
+	defaultInterval        = time.Second
+	defaultScanInterval    = time.Minute
+	defaultBackoff         = time.Second
+	defaultMaxBackoff      = 5 * time.Minute
+	defaultMarkerRetention = 24 * time.Hour
+)
+
+// Record is what the outbox stores under a key: the value and the events
+// written with it that are not published yet. Both are one value, so every
+// provider writes them atomically.
+type Record[V any, E any] struct {
+	Value  V          `yaml:"value" json:"value"`
+	Events []Event[E] `yaml:"events,omitempty" json:"events,omitempty"`
+}
+
+// Event is an event waiting to be published. ID is unique and stays the same
+// across retries, so consumers can drop duplicates.
+type Event[E any] struct {
+	ID          string    `yaml:"id" json:"id"`
+	Payload     E         `yaml:"payload" json:"payload"`
+	CreatedAt   time.Time `yaml:"created_at" json:"created_at"`
+	Attempts    int       `yaml:"attempts,omitempty" json:"attempts,omitempty"`
+	NextAttempt time.Time `yaml:"next_attempt,omitempty" json:"next_attempt,omitempty"`
+	LastError   string    `yaml:"last_error,omitempty" json:"last_error,omitempty"`
+}
+
+// Publisher delivers an event, e.g. to a message broker. It is retried until
+// it returns nil.
+type Publisher[E any] func(ctx context.Context, event Event[E]) error
+
+type Outbox[K ~string | ~uint64, V any, E any] struct {
+	provider  storage.KeyValueProvider[K, Record[V, E]]
+	publisher Publisher[E]
+	cfg       Config
+	sequence  atomic.Uint64
+	wake      chan struct{}
+
+	mu sync.Mutex
+	// pending counts the writes of events per key, so a key is only dropped
+	// when no events were stored while it was delivered.
+	pending  map[K]uint64
+	lastScan time.Time
+}
+
+var errDelivered = baseErrors.New("event already removed")
+
+func New[K ~string | ~uint64, V any, E any](provider storage.KeyValueProvider[K, Record[V, E]], publisher Publisher[E], cfg Config) (*Outbox[K, V, E], error) {
+	if provider == nil {
+		return nil, baseErrors.New("outbox provider is nil")
+	}
+	if publisher == nil {
+		return nil, baseErrors.New("outbox publisher is nil")
+	}
+	if cfg.Interval < 0 || cfg.ScanInterval < 0 || cfg.Backoff < 0 || cfg.MaxBackoff < 0 || cfg.MarkerRetention < 0 {
+		return nil, baseErrors.New("outbox intervals must not be negative")
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = defaultInterval
+	}
+	if cfg.ScanInterval == 0 {
+		cfg.ScanInterval = defaultScanInterval
+	}
+	if cfg.Backoff == 0 {
+		cfg.Backoff = defaultBackoff
+	}
+	if cfg.MaxBackoff == 0 {
+		cfg.MaxBackoff = defaultMaxBackoff
+	}
+	if cfg.MarkerRetention == 0 {
+		cfg.MarkerRetention = defaultMarkerRetention
+	}
+
+	return &Outbox[K, V, E]{
+		provider:  provider,
+		publisher: publisher,
+		cfg:       cfg,
+		wake:      make(chan struct{}, 1),
+		pending:   map[K]uint64{},
+	}, nil
+}
+
+// StoreWithOutboxEvent stores value under key and queues the events for the
+// relay in the same write. Events still queued for key are kept.
+func (o *Outbox[K, V, E]) StoreWithOutboxEvent(key K, value V, events ...E) error {
+	now := time.Now()
+	err := o.provider.Update(key, func(record Record[V, E], exists bool) (Record[V, E], error) {
+		record.Value = value
+		for _, payload := range events {
+			record.Events = append(record.Events, Event[E]{
+				ID:          fmt.Sprintf("%020d%010d", now.UnixNano(), o.sequence.Add(1)%10000000000),
+				Payload:     payload,
+				CreatedAt:   now,
+				NextAttempt: now,
+			})
+		}
+		return record, nil
+	})
+	if err != nil || len(events) == 0 {
+		return err
+	}
+
+	o.mu.Lock()
+	o.pending[key]++
+	o.mu.Unlock()
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+func (o *Outbox[K, V, E]) Get(key K) (V, error) {
+	record, err := o.provider.Get(key)
+	return record.Value, err
+}
+
+// Relay publishes queued events until ctx is done. Events of one key are
+// published in the order they were stored; a failed event holds back the
+// ones after it until it is published.
+func (o *Outbox[K, V, E]) Relay(ctx context.Context) error {
+	ticker := time.NewTicker(o.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := o.Deliver(ctx); err != nil && ctx.Err() == nil && o.cfg.OnError != nil {
+			o.cfg.OnError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
+
+// Deliver publishes the events that are due once and returns how many were
+// published. Failed publishes are scheduled for a retry and are not
+// returned as errors.
+func (o *Outbox[K, V, E]) Deliver(ctx context.Context) (int, error) {
+	keys, err := o.due()
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	var errs []error
+	for key, writes := range keys {
+		if ctx.Err() != nil {
+			break
+		}
+
+		n, done, err := o.deliverKey(ctx, key)
+		delivered += n
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if done {
+			o.mu.Lock()
+			if o.pending[key] == writes {
+				delete(o.pending, key)
+			}
+			o.mu.Unlock()
+		}
+	}
+
+	return delivered, baseErrors.Join(errs...)
+}
+
+// due returns the keys that may have queued events. Every record is read on
+// the first call and after ScanInterval; otherwise only the keys this outbox
+// stored events for.
+func (o *Outbox[K, V, E]) due() (map[K]uint64, error) {
+	o.mu.Lock()
+	scan := time.Since(o.lastScan) >= o.cfg.ScanInterval
+	o.mu.Unlock()
+
+	if scan {
+		var found []K
+		err := o.provider.ForEach(func(key K, record Record[V, E]) bool {
+			if len(record.Events) > 0 {
+				found = append(found, key)
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := o.pruneMarkers(); err != nil {
+			return nil, err
+		}
+
+		o.mu.Lock()
+		for _, key := range found {
+			o.pending[key]++
+		}
+		o.lastScan = time.Now()
+		o.mu.Unlock()
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return maps.Clone(o.pending), nil
+}
+
+// deliverKey publishes the due events of key in order. done reports that
+// key has no events left.
+func (o *Outbox[K, V, E]) deliverKey(ctx context.Context, key K) (int, bool, error) {
+	record, err := o.provider.Get(key)
+	if errors.Is(err, errors.NotFound) {
+		return 0, true, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	delivered := 0
+	for _, event := range record.Events {
+		if time.Now().Before(event.NextAttempt) {
+			return delivered, false, nil
+		}
+
+		published, err := o.published(event.ID)
+		if err != nil {
+			return delivered, false, err
+		}
+		if !published {
+			if err := o.publisher(ctx, event); err != nil {
+				return delivered, false, o.retry(key, event.ID, err)
+			}
+			if o.cfg.Markers != nil {
+				if err := o.cfg.Markers.Store(event.ID, time.Now()); err != nil {
+					return delivered, false, err
+				}
+			}
+			delivered++
+		}
+
+		if err := o.remove(key, event.ID); err != nil {
+			return delivered, false, err
+		}
+	}
+
+	return delivered, true, nil
+}
+
+func (o *Outbox[K, V, E]) published(id string) (bool, error) {
+	if o.cfg.Markers == nil {
+		return false, nil
+	}
+
+	_, err := o.cfg.Markers.Get(id)
+	if errors.Is(err, errors.NotFound) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (o *Outbox[K, V, E]) remove(key K, id string) error {
+	err := o.provider.Update(key, func(record Record[V, E], exists bool) (Record[V, E], error) {
+		for i, event := range record.Events {
+			if event.ID == id {
+				record.Events = append(record.Events[:i:i], record.Events[i+1:]...)
+				return record, nil
+			}
+		}
+		return record, errDelivered
+	})
+	if baseErrors.Is(err, errDelivered) {
+		return nil
+	}
+
+	return err
+}
+
+func (o *Outbox[K, V, E]) retry(key K, id string, cause error) error {
+	err := o.provider.Update(key, func(record Record[V, E], exists bool) (Record[V, E], error) {
+		for i, event := range record.Events {
+			if event.ID == id {
+				event.Attempts++
+				event.NextAttempt = time.Now().Add(o.backoff(event.Attempts))
+				event.LastError = cause.Error()
+				record.Events[i] = event
+				return record, nil
+			}
+		}
+		return record, errDelivered
+	})
+	if baseErrors.Is(err, errDelivered) {
+		return nil
+	}
+
+	return err
+}
+
+func (o *Outbox[K, V, E]) backoff(attempts int) time.Duration {
+	delay := o.cfg.Backoff
+	for i := 1; i < attempts && delay < o.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	return min(delay, o.cfg.MaxBackoff)
+}
+
+func (o *Outbox[K, V, E]) pruneMarkers() error {
+	if o.cfg.Markers == nil {
+		return nil
+	}
+
+	cutoff := time.Now().Add(-o.cfg.MarkerRetention)
+	_, err := o.cfg.Markers.RemoveWhere(func(id string, published time.Time) bool {
+		return published.Before(cutoff)
+	})
+
+	return err
+}
@@ -0,0 +1,142 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package outbox
+
+import (
+	"context"
+	baseErrors "errors"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+type publisher struct {
+	mu     sync.Mutex
+	fail   int
+	events []string
+}
+
+func (p *publisher) publish(ctx context.Context, event Event[string]) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.fail > 0 {
+		p.fail--
+		return baseErrors.New("broker is down")
+	}
+	p.events = append(p.events, event.Payload)
+	return nil
+}
+
+func (p *publisher) published() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]string(nil), p.events...)
+}
+
+func newProvider[K ~string | ~uint64, V any](t *testing.T) storage.KeyValueProvider[K, V] {
+	p, err := storage.GetKeyValueProviderFromConfig[K, V](storage.KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	t.Cleanup(func() {
+		require.NoError(t, p.Shutdown())
+	})
+
+	return p
+}
+
+func TestOutbox_Deliver(t *testing.T) {
+	records := newProvider[string, Record[int, string]](t)
+	pub := &publisher{fail: 1}
+	o, err := New(records, pub.publish, Config{Backoff: 20 * time.Millisecond})
+	require.NoError(t, err)
+
+	require.NoError(t, o.StoreWithOutboxEvent("order:1", 100, "order created", "payment requested"))
+	require.NoError(t, o.StoreWithOutboxEvent("order:2", 5))
+	value, err := o.Get("order:1")
+	require.NoError(t, err)
+	assert.Equal(t, 100, value)
+
+	n, err := o.Deliver(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	record, err := records.Get("order:1")
+	require.NoError(t, err)
+	require.Len(t, record.Events, 2)
+	assert.Equal(t, 1, record.Events[0].Attempts)
+	assert.Equal(t, "broker is down", record.Events[0].LastError)
+
+	n, err = o.Deliver(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	time.Sleep(30 * time.Millisecond)
+	n, err = o.Deliver(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{"order created", "payment requested"}, pub.published())
+
+	record, err = records.Get("order:1")
+	require.NoError(t, err)
+	assert.Empty(t, record.Events)
+	assert.Equal(t, 100, record.Value)
+}
+
+func TestOutbox_Markers(t *testing.T) {
+	records := newProvider[uint64, Record[string, string]](t)
+	markers := newProvider[string, time.Time](t)
+	pub := &publisher{}
+
+	writer, err := New(records, pub.publish, Config{})
+	require.NoError(t, err)
+	require.NoError(t, writer.StoreWithOutboxEvent(1, "alice", "user created"))
+	record, err := records.Get(1)
+	require.NoError(t, err)
+	require.NoError(t, markers.Store(record.Events[0].ID, time.Now()))
+	require.NoError(t, writer.StoreWithOutboxEvent(1, "alice", "user renamed"))
+
+	relay, err := New(records, pub.publish, Config{Markers: markers})
+	require.NoError(t, err)
+	n, err := relay.Deliver(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"user renamed"}, pub.published())
+
+	record, err = records.Get(1)
+	require.NoError(t, err)
+	assert.Empty(t, record.Events)
+}
+
+func TestOutbox_Relay(t *testing.T) {
+	pub := &publisher{}
+	o, err := New(newProvider[string, Record[int, string]](t), pub.publish, Config{Interval: time.Hour})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- o.Relay(ctx)
+	}()
+
+	require.NoError(t, o.StoreWithOutboxEvent("a", 1, "stored"))
+	assert.Eventually(t, func() bool {
+		return len(pub.published()) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"errors"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"sync"
+)
+
+type ParallelIterator[K ~string | ~uint64, V any] interface {
+	ForEachParallel(workers int, fn func(key K, value V) error) error
+}
+
+// ForEachParallel calls fn for every entry from workers goroutines, in no
+// particular order. It returns the first error fn returns, after which no new
+// entries are handed out. Badger decodes entries in parallel too; other
+// providers collect the keys first and load each value in a worker.
+func ForEachParallel[K ~string | ~uint64, V any](provider KeyValueProvider[K, V], workers int, fn func(key K, value V) error) error {
+	if p, ok := provider.(ParallelIterator[K, V]); ok {
+		return p.ForEachParallel(workers, fn)
+	}
+
+	if workers <= 0 {
+		return errors.New("workers must be positive")
+	}
+
+	var keys []K
+	err := provider.ForEachKey(func(key K) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	queue := make(chan K)
+	stop := make(chan struct{})
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range queue {
+				value, err := provider.Get(key)
+				if storageErrors.Is(err, storageErrors.NotFound) {
+					continue
+				}
+				if err == nil {
+					err = fn(key, value)
+				}
+				if err != nil {
+					once.Do(func() {
+						firstErr = err
+						close(stop)
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, key := range keys {
+		select {
+		case queue <- key:
+		case <-stop:
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	return firstErr
+}
@@ -0,0 +1,333 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type patchOperation struct {
+	Op    string           `json:"op"`
+	Path  string           `json:"path"`
+	From  string           `json:"from,omitempty"`
+	Value *json.RawMessage `json:"value,omitempty"`
+}
+
+func Patch[K ~string | ~uint64, V any](provider KeyValueProvider[K, V], key K, jsonPatch []byte) error {
+	trimmed := bytes.TrimSpace(jsonPatch)
+	if len(trimmed) == 0 {
+		return errors.New("patch is empty")
+	}
+
+	var apply func(doc any) (any, error)
+	if trimmed[0] == '[' {
+		var operations []patchOperation
+		if err := json.Unmarshal(trimmed, &operations); err != nil {
+			return err
+		}
+
+		apply = func(doc any) (any, error) {
+			return applyJSONPatch(doc, operations)
+		}
+	} else {
+		var patch any
+		if err := json.Unmarshal(trimmed, &patch); err != nil {
+			return err
+		}
+
+		apply = func(doc any) (any, error) {
+			return mergePatch(doc, patch), nil
+		}
+	}
+
+	return updateJSON(provider, key, apply)
+}
+
+func UpdateField[K ~string | ~uint64, V any](provider KeyValueProvider[K, V], key K, path string, value any) error {
+	if path == "" {
+		return errors.New("field path is empty")
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return err
+	}
+
+	return updateJSON(provider, key, func(doc any) (any, error) {
+		return setPath(doc, strings.Split(path, "."), v, false)
+	})
+}
+
+func updateJSON[K ~string | ~uint64, V any](provider KeyValueProvider[K, V], key K, apply func(doc any) (any, error)) error {
+	return provider.Update(key, func(value V, exists bool) (V, error) {
+		if !exists {
+			return value, storageErrors.NotFound
+		}
+
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return value, err
+		}
+
+		// The patched value is decoded into a zero V, so fields without a
+		// JSON form, such as json:"-" and unexported fields, would be reset
+		// by a patch that does not mention them.
+		var unchanged V
+		if err := json.Unmarshal(raw, &unchanged); err != nil {
+			return value, err
+		}
+		if !reflect.DeepEqual(unchanged, value) {
+			return value, storageErrors.NewUnsupported(fmt.Errorf("%T does not round-trip through JSON, so it cannot be patched", value))
+		}
+
+		var doc any
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			return value, err
+		}
+
+		doc, err = apply(doc)
+		if err != nil {
+			return value, err
+		}
+
+		raw, err = json.Marshal(doc)
+		if err != nil {
+			return value, err
+		}
+
+		var patched V
+		if err := json.Unmarshal(raw, &patched); err != nil {
+			return value, err
+		}
+
+		return patched, nil
+	})
+}
+
+func mergePatch(doc any, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	docObject, ok := doc.(map[string]any)
+	if !ok {
+		docObject = map[string]any{}
+	}
+
+	for k, v := range patchObject {
+		if v == nil {
+			delete(docObject, k)
+			continue
+		}
+		docObject[k] = mergePatch(docObject[k], v)
+	}
+
+	return docObject
+}
+
+func applyJSONPatch(doc any, operations []patchOperation) (any, error) {
+	for _, operation := range operations {
+		path, err := parsePointer(operation.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		var value any
+		if operation.Value != nil {
+			if err := json.Unmarshal(*operation.Value, &value); err != nil {
+				return nil, err
+			}
+		}
+
+		switch operation.Op {
+		case "add":
+			doc, err = setPath(doc, path, value, true)
+		case "replace":
+			if _, err = getPath(doc, path); err == nil {
+				doc, err = setPath(doc, path, value, false)
+			}
+		case "remove":
+			doc, err = removePath(doc, path)
+		case "test":
+			var current any
+			current, err = getPath(doc, path)
+			if err == nil && !reflect.DeepEqual(current, value) {
+				err = fmt.Errorf("test operation failed at %q", operation.Path)
+			}
+		case "move", "copy":
+			var from []string
+			from, err = parsePointer(operation.From)
+			if err != nil {
+				return nil, err
+			}
+
+			var current any
+			current, err = getPath(doc, from)
+			if err == nil && operation.Op == "move" {
+				doc, err = removePath(doc, from)
+			}
+			if err == nil {
+				doc, err = setPath(doc, path, current, true)
+			}
+		default:
+			err = fmt.Errorf("unsupported patch operation %q", operation.Op)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return doc, nil
+}
+
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+
+	parts := strings.Split(pointer[1:], "/")
+	for i, part := range parts {
+		parts[i] = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+	}
+
+	return parts, nil
+}
+
+func getPath(doc any, path []string) (any, error) {
+	current := doc
+	for _, part := range path {
+		switch node := current.(type) {
+		case map[string]any:
+			value, ok := node[part]
+			if !ok {
+				return nil, fmt.Errorf("path element %q not found", part)
+			}
+			current = value
+		case []any:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, fmt.Errorf("invalid array index %q", part)
+			}
+			current = node[i]
+		default:
+			return nil, fmt.Errorf("path element %q not found", part)
+		}
+	}
+
+	return current, nil
+}
+
+func setPath(doc any, path []string, value any, insert bool) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	part := path[0]
+	switch node := doc.(type) {
+	case map[string]any:
+		child, ok := node[part]
+		if !ok && len(path) > 1 {
+			return nil, fmt.Errorf("path element %q not found", part)
+		}
+
+		updated, err := setPath(child, path[1:], value, insert)
+		if err != nil {
+			return nil, err
+		}
+		node[part] = updated
+		return node, nil
+	case []any:
+		if part == "-" && insert && len(path) == 1 {
+			return append(node, value), nil
+		}
+
+		i, err := strconv.Atoi(part)
+		if err != nil || i < 0 || i > len(node) || (i == len(node) && !(insert && len(path) == 1)) {
+			return nil, fmt.Errorf("invalid array index %q", part)
+		}
+
+		if insert && len(path) == 1 {
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = value
+			return node, nil
+		}
+
+		updated, err := setPath(node[i], path[1:], value, insert)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = updated
+		return node, nil
+	default:
+		return nil, fmt.Errorf("path element %q not found", part)
+	}
+}
+
+func removePath(doc any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, errors.New("cannot remove the whole document")
+	}
+
+	part := path[0]
+	switch node := doc.(type) {
+	case map[string]any:
+		child, ok := node[part]
+		if !ok {
+			return nil, fmt.Errorf("path element %q not found", part)
+		}
+
+		if len(path) == 1 {
+			delete(node, part)
+			return node, nil
+		}
+
+		updated, err := removePath(child, path[1:])
+		if err != nil {
+			return nil, err
+		}
+		node[part] = updated
+		return node, nil
+	case []any:
+		i, err := strconv.Atoi(part)
+		if err != nil || i < 0 || i >= len(node) {
+			return nil, fmt.Errorf("invalid array index %q", part)
+		}
+
+		if len(path) == 1 {
+			return append(node[:i], node[i+1:]...), nil
+		}
+
+		updated, err := removePath(node[i], path[1:])
+		if err != nil {
+			return nil, err
+		}
+		node[i] = updated
+		return node, nil
+	default:
+		return nil, fmt.Errorf("path element %q not found", part)
+	}
+}
@@ -0,0 +1,1007 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build !nobadger
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	badgerDB "github.com/dgraph-io/badger/v4"
+	"github.com/google/uuid"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/rlshukhov/storage/codec"
+	"github.com/rlshukhov/storage/errors"
+	"github.com/rlshukhov/storage/file"
+	"github.com/rlshukhov/storage/kv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBadgerProvider_TypeMismatchIsCorrupted(t *testing.T) {
+	dir := t.TempDir()
+	cfg := KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{
+			DirectoryPath: nullable.FromValue(dir),
+		}),
+	}
+
+	strings, err := GetKeyValueProviderFromConfig[string, string](cfg)
+	require.NoError(t, err)
+	require.NoError(t, strings.Setup())
+	require.NoError(t, strings.Store("key", "value"))
+	require.NoError(t, strings.Shutdown())
+
+	users, err := GetKeyValueProviderFromConfig[string, User](cfg)
+	require.NoError(t, err)
+	require.NoError(t, users.Setup())
+	defer func() {
+		require.NoError(t, users.Shutdown())
+	}()
+
+	_, err = users.Get("key")
+	assert.True(t, errors.Is(err, errors.Corrupted))
+	assert.True(t, errors.Is(users.Verify(), errors.Corrupted))
+}
+
+func TestBadgerProvider_LegacyReferences(t *testing.T) {
+	// References used to be plain entries holding the target key.
+	dir := t.TempDir()
+	db, err := badgerDB.Open(badgerDB.DefaultOptions(dir).WithLogger(nil))
+	require.NoError(t, err)
+	var value bytes.Buffer
+	require.NoError(t, gob.NewEncoder(&value).Encode("value"))
+	require.NoError(t, db.Update(func(txn *badgerDB.Txn) error {
+		if err := txn.Set([]byte("key"), value.Bytes()); err != nil {
+			return err
+		}
+		return txn.Set([]byte("ref"), []byte("key"))
+	}))
+	require.NoError(t, db.Close())
+
+	p, err := GetKeyValueProviderFromConfig[string, string](KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{DirectoryPath: nullable.FromValue(dir)}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	defer p.Shutdown()
+
+	val, err := p.GetByReference("ref")
+	require.NoError(t, err)
+	assert.Equal(t, "value", val)
+	var keys []string
+	require.NoError(t, p.ForEach(func(key string, value string) bool {
+		keys = append(keys, key)
+		return true
+	}))
+	assert.Equal(t, []string{"key"}, keys)
+	require.NoError(t, p.Verify())
+}
+
+func TestBadgerProvider_BinaryKeys(t *testing.T) {
+	dir := t.TempDir()
+	open := func(encoding string) KeyValueProvider[uint64, string] {
+		p, err := GetKeyValueProviderFromConfig[uint64, string](KeyValueConfig{
+			Badger: nullable.FromValue(badger.Config{DirectoryPath: nullable.FromValue(dir), KeyEncoding: encoding}),
+		})
+		require.NoError(t, err)
+		require.NoError(t, p.Setup())
+		return p
+	}
+	keys := func(p KeyValueProvider[uint64, string]) []uint64 {
+		var keys []uint64
+		require.NoError(t, p.ForEachKey(func(key uint64) bool {
+			keys = append(keys, key)
+			return true
+		}))
+		return keys
+	}
+
+	legacy := open("")
+	for _, key := range []uint64{2, 10, 1} {
+		require.NoError(t, legacy.Store(key, fmt.Sprint("value-", key)))
+	}
+	require.NoError(t, legacy.StoreReference(100, 10))
+	assert.Equal(t, []uint64{1, 10, 2}, keys(legacy))
+	require.NoError(t, legacy.Shutdown())
+
+	p := open(badger.BinaryKeys)
+	defer p.Shutdown()
+	require.NoError(t, p.Store(2, "rewritten"))
+	migrated, err := MigrateKeys(p)
+	require.NoError(t, err)
+	assert.Equal(t, 4, migrated)
+	migrated, err = MigrateKeys(p)
+	require.NoError(t, err)
+	assert.Equal(t, 0, migrated)
+
+	assert.Equal(t, []uint64{1, 2, 10}, keys(p))
+	val, err := p.Get(2)
+	require.NoError(t, err)
+	assert.Equal(t, "rewritten", val)
+	val, err = p.GetByReference(100)
+	require.NoError(t, err)
+	assert.Equal(t, "value-10", val)
+
+	_, err = GetKeyValueProviderFromConfig[string, string](KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true, KeyEncoding: badger.BinaryKeys}),
+	})
+	assert.Error(t, err)
+}
+
+func TestBadgerProvider_UUIDKeys(t *testing.T) {
+	dir := t.TempDir()
+	open := func(encoding string) KeyValueProvider[string, string] {
+		p, err := GetKeyValueProviderFromConfig[string, string](KeyValueConfig{
+			Badger: nullable.FromValue(badger.Config{DirectoryPath: nullable.FromValue(dir), KeyEncoding: encoding}),
+		})
+		require.NoError(t, err)
+		require.NoError(t, p.Setup())
+		return p
+	}
+	id := uuid.MustParse("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+
+	legacy := open("")
+	require.NoError(t, legacy.Store(id.String(), "ann"))
+	require.NoError(t, legacy.Store("name:ann", "not a uuid"))
+	require.NoError(t, legacy.StoreReference("email:ann", id.String()))
+	require.NoError(t, legacy.Shutdown())
+
+	p := open(badger.UUIDKeys)
+	migrated, err := MigrateKeys(p)
+	require.NoError(t, err)
+	assert.Equal(t, 2, migrated)
+	migrated, err = MigrateKeys(p)
+	require.NoError(t, err)
+	assert.Equal(t, 0, migrated)
+
+	var keys []string
+	require.NoError(t, p.ForEachKey(func(key string) bool {
+		keys = append(keys, key)
+		return true
+	}))
+	assert.ElementsMatch(t, []string{id.String(), "name:ann"}, keys)
+	val, err := p.GetByReference("email:ann")
+	require.NoError(t, err)
+	assert.Equal(t, "ann", val)
+
+	// Only the canonical form is stored as 16 bytes.
+	upper := strings.ToUpper(id.String())
+	require.NoError(t, p.Store(upper, "upper"))
+	val, err = p.Get(upper)
+	require.NoError(t, err)
+	assert.Equal(t, "upper", val)
+	require.NoError(t, p.Shutdown())
+
+	raw := open("")
+	defer raw.Shutdown()
+	_, err = raw.Get(string(append([]byte{0}, id[:]...)))
+	assert.NoError(t, err)
+
+	_, err = GetKeyValueProviderFromConfig[uint64, string](KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true, KeyEncoding: badger.UUIDKeys}),
+	})
+	assert.Error(t, err)
+}
+
+func TestBadgerProvider_Chunks(t *testing.T) {
+	dir := t.TempDir()
+	open := func(cfg badger.Config) KeyValueProvider[string, string] {
+		cfg.DirectoryPath = nullable.FromValue(dir)
+		p, err := GetKeyValueProviderFromConfig[string, string](KeyValueConfig{Badger: nullable.FromValue(cfg)})
+		require.NoError(t, err)
+		require.NoError(t, p.Setup())
+		return p
+	}
+	collect := func(p KeyValueProvider[string, string]) int {
+		n, err := p.(interface{ CollectChunks() (int, error) }).CollectChunks()
+		require.NoError(t, err)
+		return n
+	}
+
+	p := open(badger.Config{ChunkSize: nullable.FromValue(1024)})
+	big := strings.Repeat("0123456789", 1000)
+	require.NoError(t, p.Store("big", big))
+	require.NoError(t, p.Store("small", "value"))
+	require.NoError(t, p.StoreReference("ref", "big"))
+
+	val, err := p.Get("big")
+	require.NoError(t, err)
+	assert.Equal(t, big, val)
+	val, err = p.GetByReference("ref")
+	require.NoError(t, err)
+	assert.Equal(t, big, val)
+	values := map[string]int{}
+	require.NoError(t, p.ForEach(func(key string, value string) bool {
+		values[key] = len(value)
+		return true
+	}))
+	assert.Equal(t, map[string]int{"big": len(big), "small": 5}, values)
+	entries, _, err := List(p, 10, "")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, big, entries[0].Value)
+
+	require.NoError(t, p.Update("big", func(value string, exists bool) (string, error) {
+		return value + "!", nil
+	}))
+	require.NoError(t, p.Update("small", func(value string, exists bool) (string, error) {
+		return big, nil
+	}))
+	assert.Equal(t, 0, collect(p))
+	require.NoError(t, p.Shutdown())
+
+	p = open(badger.Config{})
+	val, err = p.Get("big")
+	require.NoError(t, err)
+	assert.Equal(t, big+"!", val)
+	require.NoError(t, p.Store("big", "no longer chunked"))
+	require.NoError(t, p.Remove("small"))
+	assert.Equal(t, 0, collect(p))
+
+	require.NoError(t, p.Shutdown())
+
+	// Values above the value log file size need chunks.
+	p = open(badger.Config{ValueLogFileSize: 1 << 20})
+	huge := strings.Repeat("x", 3<<20)
+	require.NoError(t, p.Store("huge", huge))
+	val, err = p.Get("huge")
+	require.NoError(t, err)
+	assert.Equal(t, len(huge), len(val))
+	_, err = p.RemovePrefix("hu")
+	require.NoError(t, err)
+	assert.Equal(t, 0, collect(p))
+	require.NoError(t, p.Shutdown())
+
+	p = open(badger.Config{ValueLogFileSize: 1 << 20, ChunkSize: nullable.FromValue(0)})
+	assert.Error(t, p.Store("huge", huge))
+	require.NoError(t, p.Shutdown())
+
+	p = open(badger.Config{ChunkSize: nullable.FromValue(1024)})
+	defer p.Shutdown()
+	var mu sync.Mutex
+	expired := map[string]string{}
+	require.NoError(t, OnExpire(p, func(key string, value string) {
+		mu.Lock()
+		defer mu.Unlock()
+		expired[key] = value
+	}))
+	require.NoError(t, p.(Expirer[string, string]).StoreWithTTL("session", big, time.Second))
+	assert.Eventually(t, func() bool {
+		_, err := p.Get("session")
+		return errors.Is(err, errors.NotFound)
+	}, 5*time.Second, 50*time.Millisecond)
+	removed, err := SweepExpired(p)
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	mu.Lock()
+	assert.Equal(t, big, expired["session"])
+	mu.Unlock()
+
+	cfg := badger.Config{InMemory: true, ChunkSize: nullable.FromValue(-1)}
+	assert.Error(t, cfg.Validate())
+	cfg = badger.Config{InMemory: true, ChunkSize: nullable.FromValue(2 << 20), ValueLogFileSize: 1 << 20}
+	assert.Error(t, cfg.Validate())
+}
+
+func TestBadgerProvider_StrictSchema(t *testing.T) {
+	cfg := badger.Config{DirectoryPath: nullable.FromValue(t.TempDir()), StrictSchema: true}
+
+	func() {
+		type user struct {
+			Name string
+			Age  int
+		}
+		p, err := badger.New[string, user](cfg)
+		require.NoError(t, err)
+		require.NoError(t, p.Setup())
+		defer p.Shutdown()
+		require.NoError(t, p.Store("a", user{Name: "Ann", Age: 30}))
+	}()
+
+	func() {
+		type user struct {
+			Name  string
+			Age   int
+			Email string
+		}
+		p, err := badger.New[string, user](cfg)
+		require.NoError(t, err)
+		require.NoError(t, p.Setup())
+		defer p.Shutdown()
+
+		val, err := p.Get("a")
+		require.NoError(t, err)
+		assert.Equal(t, user{Name: "Ann", Age: 30}, val)
+	}()
+
+	func() {
+		type user struct {
+			FullName string
+			Age      int
+		}
+		p, err := badger.New[string, user](cfg)
+		require.NoError(t, err)
+		require.NoError(t, p.Setup())
+		defer p.Shutdown()
+
+		_, err = p.Get("a")
+		assert.True(t, errors.Is(err, errors.SchemaMismatch))
+		assert.ErrorContains(t, err, "Name string")
+		assert.True(t, errors.Is(p.Verify(), errors.SchemaMismatch))
+
+		lenient, err := badger.New[string, user](badger.Config{DirectoryPath: cfg.DirectoryPath, ReadOnly: true})
+		require.NoError(t, err)
+		require.NoError(t, p.Shutdown())
+		require.NoError(t, lenient.Setup())
+		defer lenient.Shutdown()
+		_, err = lenient.Get("a")
+		assert.True(t, errors.Is(err, errors.Corrupted), "without strict schema, the changed shape fails the fingerprint")
+	}()
+}
+
+func TestBadgerProvider_Fingerprint(t *testing.T) {
+	dir := t.TempDir()
+	open := func() badger.Config {
+		return badger.Config{DirectoryPath: nullable.FromValue(dir)}
+	}
+
+	func() {
+		type user struct {
+			Name string
+			Age  int
+		}
+		p, err := badger.New[string, user](open())
+		require.NoError(t, err)
+		require.NoError(t, p.Setup())
+		defer p.Shutdown()
+		require.NoError(t, p.Store("a", user{Name: "Ann", Age: 30}))
+	}()
+
+	func() {
+		type person struct {
+			Name string
+			Age  int
+		}
+		p, err := badger.New[string, person](open())
+		require.NoError(t, err)
+		require.NoError(t, p.Setup())
+		defer p.Shutdown()
+
+		val, err := p.Get("a")
+		require.NoError(t, err, "renamed types with the same fields read their values")
+		assert.Equal(t, person{Name: "Ann", Age: 30}, val)
+	}()
+
+	changed := map[string]func() error{
+		"added": func() error {
+			type user struct {
+				Name  string
+				Age   int
+				Email string
+			}
+			return getFrom[user](t, open())
+		},
+		"removed": func() error {
+			type user struct {
+				Name string
+			}
+			return getFrom[user](t, open())
+		},
+		"retyped": func() error {
+			type user struct {
+				Name string
+				Age  string
+			}
+			return getFrom[user](t, open())
+		},
+	}
+	for name, get := range changed {
+		assert.True(t, errors.Is(get(), errors.Corrupted), name)
+	}
+}
+
+func getFrom[V any](t *testing.T, cfg badger.Config) error {
+	p, err := badger.New[string, V](cfg)
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	defer p.Shutdown()
+
+	_, err = p.Get("a")
+	return err
+}
+
+func TestBadgerProvider_ProtoValues(t *testing.T) {
+	cfg := badger.Config{DirectoryPath: nullable.FromValue(t.TempDir())}
+
+	p, err := badger.New[string, *timestamppb.Timestamp](cfg)
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	stored := timestamppb.New(time.Date(2024, 5, 1, 12, 0, 0, 42, time.UTC))
+	require.NoError(t, p.Store("a", stored))
+	require.NoError(t, p.Shutdown())
+
+	p, err = badger.New[string, *timestamppb.Timestamp](cfg)
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	defer p.Shutdown()
+
+	val, err := p.Get("a")
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(stored, val))
+	require.NoError(t, p.Verify())
+
+	values, err := p.GetMultiple([]string{"a"})
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(stored, values[0]))
+}
+
+func TestBadgerProvider_CodecMigration(t *testing.T) {
+	type user struct {
+		Name string
+		Age  int
+	}
+	cfg := badger.Config{DirectoryPath: nullable.FromValue(t.TempDir())}
+	open := func(name string) KeyValueProvider[string, user] {
+		c := cfg
+		c.Codec = name
+		p, err := GetKeyValueProviderFromConfig[string, user](KeyValueConfig{Badger: nullable.FromValue(c)})
+		require.NoError(t, err)
+		require.NoError(t, p.Setup())
+		return p
+	}
+
+	p := open("")
+	require.NoError(t, p.Store("a", user{Name: "Ann", Age: 30}))
+	require.NoError(t, p.Store("b", user{Name: "Bob", Age: 40}))
+	require.NoError(t, p.StoreReference("ref", "a"))
+	require.NoError(t, p.Shutdown())
+
+	p = open("msgpack")
+	val, err := p.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, user{Name: "Ann", Age: 30}, val)
+	require.NoError(t, p.Store("c", user{Name: "Cid", Age: 50}))
+
+	n, err := ReencodeAll(p)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = ReencodeAll(p)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	require.NoError(t, p.Verify())
+	require.NoError(t, p.Shutdown())
+
+	p = open("gob")
+	defer p.Shutdown()
+	values, err := p.GetMultiple([]string{"a", "b", "c"})
+	require.NoError(t, err)
+	assert.Equal(t, []user{{Name: "Ann", Age: 30}, {Name: "Bob", Age: 40}, {Name: "Cid", Age: 50}}, values)
+	val, err = p.GetByReference("ref")
+	require.NoError(t, err)
+	assert.Equal(t, "Ann", val.Name)
+
+	_, err = GetKeyValueProviderFromConfig[string, user](KeyValueConfig{Badger: nullable.FromValue(badger.Config{InMemory: true, Codec: "xml"})})
+	assert.ErrorContains(t, err, "unknown codec")
+
+	f, err := GetKeyValueProviderFromConfig[string, user](KeyValueConfig{File: nullable.FromValue(file.Config{Path: filepath.Join(t.TempDir(), "data.json")})})
+	require.NoError(t, err)
+	_, err = ReencodeAll(f)
+	assert.True(t, errors.Is(err, errors.Unsupported))
+}
+
+type descriptorMap map[uint64][]byte
+
+func (d descriptorMap) LoadDescriptor(id uint64) ([]byte, error) {
+	return d[id], nil
+}
+
+func (d descriptorMap) StoreDescriptor(id uint64, descriptor []byte) error {
+	d[id] = descriptor
+	return nil
+}
+
+func TestBadgerProvider_SharedGob(t *testing.T) {
+	type user struct {
+		Name string
+		Tags []string
+		Age  int
+	}
+	ann := user{Name: "Ann", Tags: []string{"admin"}, Age: 30}
+
+	shared := codec.NewSharedGob(descriptorMap{})
+	encoded, err := shared.Marshal(ann)
+	require.NoError(t, err)
+	plain, err := codec.Gob.Marshal(ann)
+	require.NoError(t, err)
+	assert.Less(t, len(encoded)*2, len(plain))
+	var decoded user
+	require.NoError(t, shared.Unmarshal(encoded, &decoded))
+	assert.Equal(t, ann, decoded)
+
+	cfg := badger.Config{DirectoryPath: nullable.FromValue(t.TempDir())}
+	open := func(name string) KeyValueProvider[string, user] {
+		c := cfg
+		c.Codec = name
+		p, err := GetKeyValueProviderFromConfig[string, user](KeyValueConfig{Badger: nullable.FromValue(c)})
+		require.NoError(t, err)
+		require.NoError(t, p.Setup())
+		return p
+	}
+
+	p := open("gob")
+	require.NoError(t, p.Store("a", ann))
+	require.NoError(t, p.Shutdown())
+
+	p = open("gob-shared")
+	require.NoError(t, p.Store("b", user{Name: "Bob"}))
+	n, err := ReencodeAll(p)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	stats, err := p.Stats()
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, stats.Entries)
+	keys := 0
+	require.NoError(t, p.ForEachKey(func(string) bool {
+		keys++
+		return true
+	}))
+	assert.Equal(t, 2, keys)
+	require.NoError(t, p.Verify())
+	require.NoError(t, p.Shutdown())
+
+	// A new process loads the descriptors from the database.
+	p = open("gob")
+	values, err := p.GetMultiple([]string{"a", "b"})
+	require.NoError(t, err)
+	assert.Equal(t, []user{ann, {Name: "Bob"}}, values)
+	require.NoError(t, p.Shutdown())
+
+	// Clear keeps the descriptors encoders already sent.
+	p = open("gob-shared")
+	require.NoError(t, p.Store("a", ann))
+	require.NoError(t, p.Clear())
+	require.NoError(t, p.Store("c", ann))
+	n, err = MigrateKeys(p)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	require.NoError(t, p.Shutdown())
+
+	p = open("gob")
+	defer p.Shutdown()
+	val, err := p.Get("c")
+	require.NoError(t, err)
+	assert.Equal(t, ann, val)
+
+	type event struct {
+		Payload any
+	}
+	e, err := GetKeyValueProviderFromConfig[string, event](KeyValueConfig{Badger: nullable.FromValue(badger.Config{InMemory: true, Codec: "gob-shared"})})
+	require.NoError(t, err)
+	require.NoError(t, e.Setup())
+	defer e.Shutdown()
+	assert.ErrorContains(t, e.Store("a", event{Payload: 1}), "interface fields")
+}
+
+func TestBadgerProvider_BackupRestore(t *testing.T) {
+	open := func() KeyValueProvider[string, string] {
+		p, err := GetKeyValueProviderFromConfig[string, string](KeyValueConfig{Badger: nullable.FromValue(badger.Config{InMemory: true})})
+		require.NoError(t, err)
+		require.NoError(t, p.Setup())
+		return p
+	}
+
+	source := open()
+	defer source.Shutdown()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, source.Store(fmt.Sprintf("key-%03d", i), strings.Repeat("v", i)))
+	}
+	require.NoError(t, source.StoreReference("ref", "key-042"))
+
+	var backup bytes.Buffer
+	require.NoError(t, source.Backup(&backup))
+	require.NoError(t, badger.VerifyBackup(bytes.NewReader(backup.Bytes())))
+
+	restored := open()
+	defer restored.Shutdown()
+	require.NoError(t, Restore(restored, bytes.NewReader(backup.Bytes())))
+	val, err := restored.GetByReference("ref")
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("v", 42), val)
+	require.NoError(t, restored.Verify())
+
+	damaged := bytes.Clone(backup.Bytes())
+	damaged[len(damaged)/2] ^= 0xFF
+	err = badger.VerifyBackup(bytes.NewReader(damaged))
+	assert.True(t, errors.Is(err, errors.Corrupted))
+	assert.ErrorContains(t, err, "checksum mismatch")
+
+	err = badger.VerifyBackup(bytes.NewReader(backup.Bytes()[:backup.Len()-1]))
+	assert.True(t, errors.Is(err, errors.Corrupted))
+	assert.ErrorContains(t, err, "truncated")
+
+	target := open()
+	defer target.Shutdown()
+	assert.True(t, errors.Is(Restore(target, bytes.NewReader(damaged)), errors.Corrupted))
+}
+
+func TestBadgerProvider_Preload(t *testing.T) {
+	p, err := GetKeyValueProviderFromConfig[string, string](KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{DirectoryPath: nullable.FromValue(t.TempDir())}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	defer p.Shutdown()
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, p.Store(fmt.Sprintf("hot:%02d", i), "value"))
+		require.NoError(t, p.Store(fmt.Sprintf("cold:%02d", i), "value"))
+	}
+	require.NoError(t, p.StoreReference("hot:ref", "hot:00"))
+
+	n, err := PreloadPrefix(p, "hot:")
+	require.NoError(t, err)
+	assert.Equal(t, 20, n)
+	n, err = Preload(p, []string{"cold:01", "cold:02", "missing"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	f, err := GetKeyValueProviderFromConfig[string, string](KeyValueConfig{
+		File: nullable.FromValue(file.Config{Path: filepath.Join(t.TempDir(), "data.json")}),
+	})
+	require.NoError(t, err)
+	_, err = Preload(f, []string{"a"})
+	assert.ErrorIs(t, err, errors.Unsupported)
+}
+
+func TestBadgerProvider_OpenSnapshot(t *testing.T) {
+	p, err := GetKeyValueProviderFromConfig[string, string](KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{DirectoryPath: nullable.FromValue(t.TempDir())}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	defer p.Shutdown()
+
+	require.NoError(t, p.Store("user:2", "bob"))
+	require.NoError(t, p.Store("user:1", "alice"))
+	require.NoError(t, p.Store("order:1", "book"))
+
+	snap, err := Snapshot(p)
+	require.NoError(t, err)
+	require.NoError(t, p.Store("user:3", "carol"))
+	require.NoError(t, p.Remove("user:1"))
+
+	v, err := snap.Get("user:1")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", v)
+	_, err = snap.Get("user:3")
+	assert.ErrorIs(t, err, errors.NotFound)
+
+	var keys []string
+	require.NoError(t, snap.ForEachPrefix("user:", func(key string, _ string) bool {
+		keys = append(keys, key)
+		return true
+	}))
+	assert.Equal(t, []string{"user:1", "user:2"}, keys)
+	require.NoError(t, snap.Close())
+
+	entries, err := Entries(p)
+	require.NoError(t, err)
+	assert.Equal(t, []kv.Entry[string, string]{
+		{Key: "order:1", Value: "book"},
+		{Key: "user:2", Value: "bob"},
+		{Key: "user:3", Value: "carol"},
+	}, entries)
+
+	f, err := file.New[uint64, string](file.Config{Path: filepath.Join(t.TempDir(), "data.json")})
+	require.NoError(t, err)
+	require.NoError(t, f.Setup())
+	defer f.Shutdown()
+	for _, id := range []uint64{10, 2, 1} {
+		require.NoError(t, f.Store(id, fmt.Sprint("v", id)))
+	}
+
+	fsnap, err := Snapshot[uint64, string](f)
+	require.NoError(t, err)
+	require.NoError(t, f.Store(11, "v11"))
+	keys = nil
+	require.NoError(t, fsnap.ForEachPrefix(1, func(key uint64, _ string) bool {
+		keys = append(keys, fmt.Sprint(key))
+		return true
+	}))
+	assert.Equal(t, []string{"1", "10"}, keys)
+	v, err = fsnap.Get(2)
+	require.NoError(t, err)
+	assert.Equal(t, "v2", v)
+	require.NoError(t, fsnap.Close())
+	_, err = fsnap.Get(2)
+	assert.ErrorIs(t, err, errors.Closed)
+}
+
+func TestBadgerProvider_WriteQueue(t *testing.T) {
+	dir := t.TempDir()
+	var mu sync.Mutex
+	var failures []error
+	open := func(interval time.Duration, consistency kv.Consistency) KeyValueProvider[string, string] {
+		p, err := GetKeyValueProviderFromConfig[string, string](KeyValueConfig{
+			Badger: nullable.FromValue(badger.Config{
+				DirectoryPath:    nullable.FromValue(dir),
+				ChunkSize:        nullable.FromValue(0),
+				ValueLogFileSize: 1 << 20,
+				Consistency:      consistency,
+				WriteQueue: nullable.FromValue(badger.WriteQueueConfig{
+					Interval: interval,
+					MaxBatch: 100,
+					OnError: func(err error) {
+						mu.Lock()
+						defer mu.Unlock()
+						failures = append(failures, err)
+					},
+				}),
+			}),
+		})
+		require.NoError(t, err)
+		require.NoError(t, p.Setup())
+		return p
+	}
+
+	// Eventual reads see queued values once they are committed.
+	p := open(time.Hour, kv.Eventual)
+	require.NoError(t, p.Store("a", "queued"))
+	_, err := p.Get("a")
+	assert.True(t, errors.Is(err, errors.NotFound))
+	require.NoError(t, Flush(p))
+	val, err := p.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, "queued", val)
+
+	// Other writes apply after the queued ones.
+	require.NoError(t, p.Store("b", "queued"))
+	require.NoError(t, p.Remove("b"))
+	require.NoError(t, p.Store("c", "queued"))
+	require.NoError(t, StoreIfPresent(p, "c", "updated"))
+	require.NoError(t, Flush(p))
+	_, err = p.Get("b")
+	assert.True(t, errors.Is(err, errors.NotFound))
+	val, err = p.Get("c")
+	require.NoError(t, err)
+	assert.Equal(t, "updated", val)
+
+	// A full batch is committed without waiting for the interval.
+	for i := 0; i < 250; i++ {
+		require.NoError(t, p.Store(fmt.Sprintf("key-%03d", i), "value"))
+	}
+	assert.Eventually(t, func() bool {
+		_, err := p.Get("key-199")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Failed commits are reported and returned by Flush.
+	require.NoError(t, p.Store("huge", strings.Repeat("x", 2<<20)))
+	require.NoError(t, p.Store("small", "value"))
+	err = Flush(p)
+	assert.Error(t, err)
+	mu.Lock()
+	assert.Len(t, failures, 1)
+	mu.Unlock()
+	assert.NoError(t, Flush(p))
+	val, err = p.Get("small")
+	require.NoError(t, err)
+	assert.Equal(t, "value", val)
+
+	// Shutdown commits the queue.
+	require.NoError(t, p.Store("last", "value"))
+	require.NoError(t, p.Shutdown())
+	assert.True(t, errors.Is(p.Store("closed", "value"), errors.Closed))
+
+	p = open(time.Millisecond, kv.Eventual)
+	val, err = p.Get("last")
+	require.NoError(t, err)
+	assert.Equal(t, "value", val)
+	require.NoError(t, p.Store("d", "value"))
+	assert.Eventually(t, func() bool {
+		_, err := p.Get("d")
+		return err == nil
+	}, 5*time.Second, time.Millisecond)
+	require.NoError(t, p.Shutdown())
+
+	// Strict reads see every write that returned before them.
+	p = open(time.Hour, "")
+	defer p.Shutdown()
+	require.NoError(t, p.Store("e", "value"))
+	val, err = p.Get("e")
+	require.NoError(t, err)
+	assert.Equal(t, "value", val)
+	require.NoError(t, p.Store("f", "value"))
+	var keys []string
+	require.NoError(t, p.ForEachKey(func(key string) bool {
+		keys = append(keys, key)
+		return true
+	}))
+	assert.Contains(t, keys, "f")
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				key := fmt.Sprintf("g-%d-%d", i, j)
+				assert.NoError(t, p.Store(key, key))
+				val, err := p.Get(key)
+				assert.NoError(t, err)
+				assert.Equal(t, key, val)
+			}
+		}()
+	}
+	wg.Wait()
+
+	_, err = GetKeyValueProviderFromConfig[string, string](KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true, Consistency: "linearizable"}),
+	})
+	assert.ErrorContains(t, err, "consistency must be")
+
+	f, err := GetKeyValueProviderFromConfig[string, string](KeyValueConfig{File: nullable.FromValue(file.Config{Path: filepath.Join(t.TempDir(), "data.json")})})
+	require.NoError(t, err)
+	assert.NoError(t, Flush(f))
+}
+
+func TestBadgerProvider_Snapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.bak")
+	open := func() KeyValueProvider[string, string] {
+		p, err := GetKeyValueProviderFromConfig[string, string](KeyValueConfig{
+			Badger: nullable.FromValue(badger.Config{
+				InMemory: true,
+				Snapshot: nullable.FromValue(badger.SnapshotConfig{Path: path, Interval: 10 * time.Millisecond}),
+			}),
+		})
+		require.NoError(t, err)
+		return p
+	}
+
+	p := open()
+	require.NoError(t, p.Setup())
+	require.NoError(t, p.Store("a", "1"))
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, p.Store("b", "2"))
+	require.NoError(t, p.Shutdown())
+
+	p = open()
+	require.NoError(t, p.Setup())
+	for key, want := range map[string]string{"a": "1", "b": "2"} {
+		val, err := p.Get(key)
+		require.NoError(t, err)
+		assert.Equal(t, want, val)
+	}
+	require.NoError(t, p.Shutdown())
+
+	require.NoError(t, os.WriteFile(path, []byte("not a snapshot"), 0o600))
+	assert.True(t, errors.Is(open().Setup(), errors.Corrupted))
+
+	cfg := badger.Config{DirectoryPath: nullable.FromValue(t.TempDir()), Snapshot: nullable.FromValue(badger.SnapshotConfig{Path: path})}
+	assert.Error(t, cfg.Validate())
+}
+
+func TestBadgerProvider_TransactionTimeout(t *testing.T) {
+	p, err := GetKeyValueProviderFromConfig[string, string](KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true, TransactionTimeout: 10 * time.Millisecond}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	defer p.Shutdown()
+
+	require.NoError(t, p.Store("key", "value"))
+
+	err = p.Update("key", func(value string, exists bool) (string, error) {
+		time.Sleep(20 * time.Millisecond)
+		return "updated", nil
+	})
+	assert.True(t, errors.Is(err, errors.Timeout))
+
+	val, err := p.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", val, "a timed out transaction is not committed")
+}
+
+func TestBadgerProvider_ConcurrentUpdates(t *testing.T) {
+	p, err := GetKeyValueProviderFromConfig[string, int](KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true, ConflictRetries: nullable.FromValue(100)}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	defer p.Shutdown()
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, p.Update("counter", func(value int, exists bool) (int, error) {
+				return value + 1, nil
+			}))
+		}()
+	}
+	wg.Wait()
+
+	val, err := p.Get("counter")
+	require.NoError(t, err)
+	assert.Equal(t, 20, val)
+}
+
+func TestWrappers_ForwardCapabilities(t *testing.T) {
+	lazy := nullable.FromValue(LazyConfig{})
+	backup := nullable.FromValue(BackupSchedule{Interval: time.Hour, Directory: t.TempDir()})
+	wrappers := []KeyValueConfig{{Lazy: lazy}, {Backup: backup}, {Lazy: lazy, Backup: backup}}
+
+	for _, cfg := range wrappers {
+		cfg.Badger = nullable.FromValue(badger.Config{InMemory: true})
+		p, err := GetKeyValueProviderFromConfig[string, string](cfg)
+		require.NoError(t, err)
+		require.NoError(t, p.Setup())
+
+		watcher, ok := p.(Watcher[string, string])
+		require.True(t, ok)
+		ctx, cancel := context.WithCancel(context.Background())
+		watched := make(chan string, 1)
+		go func() {
+			_ = watcher.Watch(ctx, "", func(key string, value string, removed bool) {
+				select {
+				case watched <- key:
+				default:
+				}
+			})
+		}()
+
+		expirer, ok := p.(Expirer[string, string])
+		require.True(t, ok)
+		assert.Eventually(t, func() bool {
+			require.NoError(t, expirer.StoreWithTTL("session", "value", time.Minute))
+			select {
+			case key := <-watched:
+				return key == "session"
+			default:
+				return false
+			}
+		}, time.Second, 10*time.Millisecond)
+		cancel()
+
+		_, ok = p.(Lister[string, string])
+		assert.True(t, ok)
+		_, ok = p.(ParallelIterator[string, string])
+		assert.True(t, ok)
+		reencoded, err := ReencodeAll(p)
+		require.NoError(t, err)
+		assert.Equal(t, 0, reencoded)
+		_, err = MigrateKeys(p)
+		require.NoError(t, err)
+		require.NoError(t, p.Shutdown())
+
+		cfg.Badger = nullable.Nullable[badger.Config]{}
+		cfg.File = nullable.FromValue(file.Config{Path: filepath.Join(t.TempDir(), "data.json")})
+		f, err := GetKeyValueProviderFromConfig[string, string](cfg)
+		require.NoError(t, err)
+		_, ok = f.(Watcher[string, string])
+		assert.False(t, ok)
+		_, ok = f.(Expirer[string, string])
+		assert.False(t, ok)
+	}
+}
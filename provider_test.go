@@ -9,15 +9,31 @@
 package storage
 
 import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/rlshukhov/nullable"
 	"github.com/rlshukhov/storage/badger"
 	"github.com/rlshukhov/storage/errors"
 	"github.com/rlshukhov/storage/file"
+	"github.com/rlshukhov/storage/integrity"
+	"github.com/rlshukhov/storage/kv"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	gokeyring "github.com/zalando/go-keyring"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 func performTestsForProviders[K ~string | ~uint64, V any](t *testing.T, test func(t *testing.T, p KeyValueProvider[K, V])) {
@@ -174,6 +190,26 @@ func TestProviderWithComplexStructure(t *testing.T) {
 	})
 }
 
+func TestProvider_GetInto(t *testing.T) {
+	performTestsForProviders[uint64, User](t, func(t *testing.T, p KeyValueProvider[uint64, User]) {
+		ann := User{ID: 1, Name: "Ann", Address: Address{City: "Oslo"}, Age: 40}
+		// Gob leaves out zero fields, they must not keep the old value of dst.
+		bob := User{ID: 2, Name: "Bob"}
+		require.NoError(t, p.Store(ann.ID, ann))
+		require.NoError(t, p.Store(bob.ID, bob))
+
+		var user User
+		require.NoError(t, GetInto(p, ann.ID, &user))
+		assert.Equal(t, ann, user)
+		require.NoError(t, GetInto(p, bob.ID, &user))
+		assert.Equal(t, bob, user)
+
+		err := GetInto(p, 3, &user)
+		assert.True(t, errors.Is(err, errors.NotFound))
+		assert.Equal(t, bob, user)
+	})
+}
+
 func TestProvider_StoreReferenceAndGetByReference(t *testing.T) {
 	performTestsForProviders[string, string](t, func(t *testing.T, p KeyValueProvider[string, string]) {
 		err := p.Store("key", "value")
@@ -205,6 +241,167 @@ func TestProvider_RemoveReference(t *testing.T) {
 	})
 }
 
+func TestProvider_StoreWithReferences(t *testing.T) {
+	performTestsForProviders[string, string](t, func(t *testing.T, p KeyValueProvider[string, string]) {
+		require.NoError(t, p.Store("other", "other value"))
+		require.NoError(t, p.StoreReference("by-email", "other"))
+
+		require.NoError(t, StoreWithReferences(p, "user:1", "ann", "by-email", "by-name"))
+
+		val, err := p.Get("user:1")
+		require.NoError(t, err)
+		assert.Equal(t, "ann", val)
+		for _, reference := range []string{"by-email", "by-name"} {
+			val, err = p.GetByReference(reference)
+			require.NoError(t, err)
+			assert.Equal(t, "ann", val)
+		}
+	})
+}
+
+func TestProvider_ConditionalStore(t *testing.T) {
+	performTestsForProviders[string, string](t, func(t *testing.T, p KeyValueProvider[string, string]) {
+		err := StoreIfPresent(p, "user:ann", "ann")
+		assert.True(t, errors.Is(err, errors.NotFound))
+		_, err = p.Get("user:ann")
+		assert.True(t, errors.Is(err, errors.NotFound))
+
+		require.NoError(t, StoreIfAbsent(p, "user:ann", "ann"))
+		err = StoreIfAbsent(p, "user:ann", "impostor")
+		assert.True(t, errors.Is(err, errors.AlreadyExists))
+
+		require.NoError(t, StoreIfPresent(p, "user:ann", "ann smith"))
+		val, err := p.Get("user:ann")
+		require.NoError(t, err)
+		assert.Equal(t, "ann smith", val)
+	})
+}
+
+func TestProvider_CompositeKeys(t *testing.T) {
+	performTestsForProviders[string, int](t, func(t *testing.T, p KeyValueProvider[string, int]) {
+		for _, id := range []uint64{10, 2, 1 << 40} {
+			require.NoError(t, p.Store(NewKey("user", "acme").Uint64(id).Key(), int(id%100)))
+		}
+		require.NoError(t, p.Store(NewKey("user", "acme-corp").Uint64(1).Key(), 0))
+		require.NoError(t, p.Store(NewKey("user", "ac").Uint64(1).Key(), 0))
+
+		var ids []uint64
+		require.NoError(t, p.ForEachPrefix(NewKey("user", "acme").Prefix(), func(key string, value int) bool {
+			parts, err := SplitKey(key)
+			require.NoError(t, err)
+			require.Len(t, parts, 3)
+			id, err := ParseUint64Part(parts[2])
+			require.NoError(t, err)
+			ids = append(ids, id)
+			return true
+		}))
+		assert.Equal(t, []uint64{2, 10, 1 << 40}, ids)
+	})
+
+	tricky := NewKey("a\x00b", "\x01").String("c").Key()
+	parts, err := SplitKey(tricky)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a\x00b", "\x01", "c"}, parts)
+	assert.Less(t, NewKey("a").Key(), NewKey("a", "b").Key())
+	assert.Less(t, NewKey("a", "z").Key(), NewKey("a\x00").Key())
+	assert.Less(t, NewKey("a\x00").Key(), NewKey("a\x01").Key())
+	assert.Less(t, NewKey("a\x01").Key(), NewKey("a\x02").Key())
+}
+
+func TestProvider_List(t *testing.T) {
+	performTestsForProviders[string, int](t, func(t *testing.T, p KeyValueProvider[string, int]) {
+		for i := 0; i < 25; i++ {
+			require.NoError(t, p.Store(fmt.Sprintf("key-%02d", i), i))
+		}
+		require.NoError(t, p.StoreReference("ref", "key-00"))
+
+		var values []int
+		var cursor Cursor
+		pages := 0
+		for {
+			entries, next, err := List(p, 10, cursor)
+			require.NoError(t, err)
+			for _, entry := range entries {
+				values = append(values, entry.Value)
+			}
+			pages++
+			if pages == 1 {
+				require.NoError(t, p.Remove("key-10"))
+				require.NoError(t, p.Store("key-05a", 100))
+			}
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+
+		assert.Equal(t, 3, pages)
+		assert.Len(t, values, 24)
+		assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 11, 12}, values[:12])
+
+		_, _, err := List(p, 10, "not a cursor!")
+		assert.Error(t, err)
+		_, _, err = List(p, 0, "")
+		assert.Error(t, err)
+	})
+}
+
+func TestProvider_ForEachParallel(t *testing.T) {
+	performTestsForProviders[string, int](t, func(t *testing.T, p KeyValueProvider[string, int]) {
+		for i := 1; i <= 500; i++ {
+			require.NoError(t, p.Store(fmt.Sprintf("key-%03d", i), i))
+		}
+		require.NoError(t, p.StoreReference("ref", "key-001"))
+
+		var mu sync.Mutex
+		sum := 0
+		require.NoError(t, ForEachParallel(p, 4, func(key string, value int) error {
+			mu.Lock()
+			defer mu.Unlock()
+			sum += value
+			return nil
+		}))
+		assert.Equal(t, 500*501/2, sum)
+
+		failure := fmt.Errorf("cannot process key-250")
+		err := ForEachParallel(p, 4, func(key string, value int) error {
+			if key == "key-250" {
+				return failure
+			}
+			return nil
+		})
+		assert.ErrorIs(t, err, failure)
+	})
+}
+
+func TestProvider_Aggregates(t *testing.T) {
+	performTestsForProviders[uint64, User](t, func(t *testing.T, p KeyValueProvider[uint64, User]) {
+		for i := uint64(0); i < 300; i++ {
+			require.NoError(t, p.Store(i, User{ID: i, Name: []string{"ann", "bob", "cid"}[i%3]}))
+		}
+
+		n, err := Count(p, func(key uint64, user User) bool {
+			return key%2 == 0
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 150, n)
+
+		groups, err := GroupBy(p, func(key uint64, user User) string {
+			return user.Name
+		})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int{"ann": 100, "bob": 100, "cid": 100}, groups)
+
+		highest, err := Reduce(p, 3,
+			func() int { return 0 },
+			func(acc int, key uint64, user User) int { return max(acc, int(key)) },
+			func(a, b int) int { return max(a, b) },
+		)
+		require.NoError(t, err)
+		assert.Equal(t, 299, highest)
+	})
+}
+
 func TestProvider_StoreReference_WithNonExistentKey(t *testing.T) {
 	performTestsForProviders[string, string](t, func(t *testing.T, p KeyValueProvider[string, string]) {
 		err := p.StoreReference("ref", "nonexistent_key")
@@ -234,3 +431,1585 @@ func TestProvider_StoreReference_Overwrite(t *testing.T) {
 		assert.Equal(t, "value2", val)
 	})
 }
+
+func TestProvider_Update(t *testing.T) {
+	performTestsForProviders[string, int](t, func(t *testing.T, p KeyValueProvider[string, int]) {
+		err := p.Update("counter", func(value int, exists bool) (int, error) {
+			assert.False(t, exists)
+			return value + 1, nil
+		})
+		require.NoError(t, err)
+
+		err = p.Update("counter", func(value int, exists bool) (int, error) {
+			assert.True(t, exists)
+			return value + 1, nil
+		})
+		require.NoError(t, err)
+
+		val, err := p.Get("counter")
+		require.NoError(t, err)
+		assert.Equal(t, 2, val)
+
+		err = p.Update("counter", func(value int, exists bool) (int, error) {
+			return 0, errors.NotLeader
+		})
+		assert.True(t, errors.Is(err, errors.NotLeader))
+
+		val, err = p.Get("counter")
+		require.NoError(t, err)
+		assert.Equal(t, 2, val)
+	})
+}
+
+func TestProvider_ForEachPrefix(t *testing.T) {
+	performTestsForProviders[string, string](t, func(t *testing.T, p KeyValueProvider[string, string]) {
+		for _, k := range []string{"b/2", "a/1", "b/1", "c/1", "b/3"} {
+			require.NoError(t, p.Store(k, k))
+		}
+		require.NoError(t, p.StoreReference("b/ref", "a/1"))
+
+		var visited []string
+		err := p.ForEachPrefix("b/", func(key, value string) bool {
+			visited = append(visited, key)
+			return len(visited) < 2
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"b/1", "b/2"}, visited)
+	})
+}
+
+func TestMap(t *testing.T) {
+	performTestsForProviders[string, int](t, func(t *testing.T, p KeyValueProvider[string, int]) {
+		m := NewMap(p)
+
+		_, ok, err := m.Load("a")
+		require.NoError(t, err)
+		assert.False(t, ok)
+
+		actual, loaded, err := m.LoadOrStore("a", 1)
+		require.NoError(t, err)
+		assert.Equal(t, 1, actual)
+		assert.False(t, loaded)
+		actual, loaded, err = m.LoadOrStore("a", 2)
+		require.NoError(t, err)
+		assert.Equal(t, 1, actual)
+		assert.True(t, loaded)
+
+		previous, loaded, err := m.Swap("a", 3)
+		require.NoError(t, err)
+		assert.Equal(t, 1, previous)
+		assert.True(t, loaded)
+		require.NoError(t, m.Store("b", 4))
+
+		sum := 0
+		require.NoError(t, m.Range(func(key string, value int) bool {
+			sum += value
+			return true
+		}))
+		assert.Equal(t, 7, sum)
+
+		value, loaded, err := m.LoadAndDelete("a")
+		require.NoError(t, err)
+		assert.Equal(t, 3, value)
+		assert.True(t, loaded)
+		_, loaded, err = m.LoadAndDelete("a")
+		require.NoError(t, err)
+		assert.False(t, loaded)
+		require.NoError(t, m.Delete("a"))
+
+		require.NoError(t, m.Clear())
+		_, ok, err = m.Load("b")
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+}
+
+func TestDefault(t *testing.T) {
+	_, err := Get[string, int]("a")
+	assert.ErrorContains(t, err, "no default storage provider")
+
+	performTestsForProviders[string, int](t, func(t *testing.T, p KeyValueProvider[string, int]) {
+		SetDefault(p)
+		defer SetDefault[string, int](nil)
+
+		require.NoError(t, Store("a", 1))
+		val, err := Get[string, int]("a")
+		require.NoError(t, err)
+		assert.Equal(t, 1, val)
+		require.NoError(t, Remove[string, int]("a"))
+		_, err = p.Get("a")
+		assert.True(t, errors.Is(err, errors.NotFound))
+
+		_, err = Get[string, string]("a")
+		assert.ErrorContains(t, err, "is not a KeyValueProvider[string, string]")
+	})
+}
+
+type countingProvider[K ~string | ~uint64, V any] struct {
+	KeyValueProvider[K, V]
+	gets int
+}
+
+func (p *countingProvider[K, V]) Get(key K) (V, error) {
+	p.gets++
+	return p.KeyValueProvider.Get(key)
+}
+
+func TestRequestCached(t *testing.T) {
+	performTestsForProviders[string, int](t, func(t *testing.T, inner KeyValueProvider[string, int]) {
+		p := &countingProvider[string, int]{KeyValueProvider: inner}
+		require.NoError(t, p.Store("a", 1))
+
+		assert.Same(t, p, RequestCached[string, int](context.Background(), p))
+
+		ctx := WithRequestCache(context.Background())
+		for i := 0; i < 3; i++ {
+			val, err := RequestCached[string, int](ctx, p).Get("a")
+			require.NoError(t, err)
+			assert.Equal(t, 1, val)
+			_, err = RequestCached[string, int](ctx, p).Get("missing")
+			assert.True(t, errors.Is(err, errors.NotFound))
+		}
+		assert.Equal(t, 2, p.gets)
+
+		cached := RequestCached[string, int](ctx, p)
+		require.NoError(t, cached.Store("a", 2))
+		values, err := cached.GetMultiple([]string{"a", "a"})
+		require.NoError(t, err)
+		assert.Equal(t, []int{2, 2}, values)
+		assert.Equal(t, 3, p.gets)
+
+		require.NoError(t, cached.Clear())
+		_, err = cached.Get("a")
+		assert.True(t, errors.Is(err, errors.NotFound))
+		assert.Equal(t, 4, p.gets)
+
+		val, err := RequestCached[string, int](WithRequestCache(context.Background()), p).Get("missing")
+		assert.Zero(t, val)
+		assert.True(t, errors.Is(err, errors.NotFound))
+		assert.Equal(t, 5, p.gets)
+	})
+}
+
+func TestHashStore(t *testing.T) {
+	performTestsForProviders[string, int](t, func(t *testing.T, p KeyValueProvider[string, int]) {
+		h := NewHashStore[string, string, int](p)
+
+		require.NoError(t, h.HSet("user:1", "age", 30))
+		require.NoError(t, h.HSet("user:1", "visits", 7))
+		require.NoError(t, h.HSet("user:10", "age", 40))
+
+		age, err := h.HGet("user:1", "age")
+		require.NoError(t, err)
+		assert.Equal(t, 30, age)
+
+		all, err := h.HGetAll("user:1")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int{"age": 30, "visits": 7}, all)
+
+		require.NoError(t, h.HDel("user:1", "visits", "missing"))
+
+		_, err = h.HGet("user:1", "visits")
+		assert.True(t, errors.Is(err, errors.NotFound))
+	})
+}
+
+func TestPatch(t *testing.T) {
+	performTestsForProviders[uint64, User](t, func(t *testing.T, p KeyValueProvider[uint64, User]) {
+		user := User{ID: 1, Name: "John Doe", Address: Address{City: "New York", Country: "USA"}, Age: 30}
+		require.NoError(t, p.Store(user.ID, user))
+
+		require.NoError(t, Patch(p, user.ID, []byte(`{"Age": 31, "Address": {"City": "Boston"}}`)))
+		require.NoError(t, Patch(p, user.ID, []byte(`[{"op": "test", "path": "/Age", "value": 31}, {"op": "replace", "path": "/Name", "value": "Jane Doe"}]`)))
+		require.NoError(t, UpdateField(p, user.ID, "Address.Country", "US"))
+
+		patched, err := p.Get(user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, User{ID: 1, Name: "Jane Doe", Address: Address{City: "Boston", Country: "US"}, Age: 31}, patched)
+
+		err = Patch(p, user.ID, []byte(`[{"op": "test", "path": "/Age", "value": 40}]`))
+		assert.Error(t, err)
+
+		err = Patch(p, 2, []byte(`{"Age": 1}`))
+		assert.True(t, errors.Is(err, errors.NotFound))
+	})
+}
+
+func TestPatch_LossyValue(t *testing.T) {
+	type account struct {
+		Name   string
+		Secret string `json:"-"`
+	}
+
+	p, err := GetKeyValueProviderFromConfig[string, account](KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	defer p.Shutdown()
+
+	require.NoError(t, p.Store("a", account{Name: "John", Secret: "s3cret"}))
+	assert.True(t, errors.Is(Patch(p, "a", []byte(`{"Name": "Jane"}`)), errors.Unsupported))
+	assert.True(t, errors.Is(UpdateField(p, "a", "Name", "Jane"), errors.Unsupported))
+
+	val, err := p.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, account{Name: "John", Secret: "s3cret"}, val)
+
+	require.NoError(t, p.Store("b", account{Name: "John"}))
+	require.NoError(t, Patch(p, "b", []byte(`{"Name": "Jane"}`)), "values without lossy fields set are patched")
+	val, err = p.Get("b")
+	require.NoError(t, err)
+	assert.Equal(t, account{Name: "Jane"}, val)
+}
+
+func TestProvider_Verify(t *testing.T) {
+	performTestsForProviders[string, string](t, func(t *testing.T, p KeyValueProvider[string, string]) {
+		require.NoError(t, p.Store("key", "value"))
+		require.NoError(t, p.StoreReference("ref", "key"))
+
+		assert.NoError(t, p.Verify())
+	})
+}
+
+func TestProvider_PingAndStats(t *testing.T) {
+	performTestsForProviders[string, string](t, func(t *testing.T, p KeyValueProvider[string, string]) {
+		require.NoError(t, p.Ping(context.Background()))
+
+		require.NoError(t, p.Store("key1", "value1"))
+		require.NoError(t, p.Store("key2", "value2"))
+		require.NoError(t, p.StoreReference("ref", "key1"))
+
+		stats, err := p.Stats()
+		require.NoError(t, err)
+		assert.NotEmpty(t, stats.Provider)
+		assert.Equal(t, uint64(2), stats.Entries)
+		assert.Equal(t, uint64(1), stats.References)
+		assert.False(t, stats.LastFlush.IsZero())
+
+		_, err = p.ApproximateSize()
+		require.NoError(t, err)
+	})
+}
+
+func TestFileProvider_ApproximateSize(t *testing.T) {
+	p, err := file.New[string, string](file.Config{Path: filepath.Join(t.TempDir(), "data.json")})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	defer p.Shutdown()
+
+	empty, err := p.ApproximateSize()
+	require.NoError(t, err)
+
+	require.NoError(t, p.Store("key", "a fairly long value to grow the file"))
+	size, err := p.ApproximateSize()
+	require.NoError(t, err)
+	assert.Greater(t, size, empty)
+}
+
+func TestProvider_ShutdownIsIdempotent(t *testing.T) {
+	configs := []KeyValueConfig{
+		{Badger: nullable.FromValue(badger.Config{InMemory: true})},
+		{File: nullable.FromValue(file.Config{Path: t.TempDir() + "/data.json"})},
+	}
+
+	for _, cfg := range configs {
+		p, err := GetKeyValueProviderFromConfig[string, string](cfg)
+		require.NoError(t, err)
+		require.NoError(t, p.Setup())
+		require.NoError(t, p.Store("key", "value"))
+
+		require.NoError(t, p.Shutdown())
+		require.NoError(t, p.Shutdown())
+		require.NoError(t, p.Close())
+	}
+
+	p, err := GetKeyValueProviderFromConfig[string, string](configs[0])
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	require.NoError(t, p.Close())
+
+	_, err = p.Get("key")
+	assert.True(t, errors.Is(err, errors.Closed))
+}
+
+func TestLazyProvider_RetriesSetup(t *testing.T) {
+	path := t.TempDir() + "/missing/data.yaml"
+	p, err := GetKeyValueProviderFromConfig[string, string](KeyValueConfig{
+		File: nullable.FromValue(file.Config{Path: path}),
+		Lazy: nullable.FromValue(LazyConfig{InitialBackoff: time.Millisecond}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+
+	err = p.Store("key", "value")
+	assert.True(t, errors.Is(err, errors.Unavailable))
+	assert.Error(t, p.Ping(context.Background()))
+
+	stats, err := p.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, "disconnected", stats.Backend["connection_state"])
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	time.Sleep(5 * time.Millisecond)
+
+	require.NoError(t, p.Store("key", "value"))
+	require.NoError(t, p.Ping(context.Background()))
+
+	stats, err = p.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, "connected", stats.Backend["connection_state"])
+	assert.Equal(t, uint64(1), stats.Entries)
+
+	require.NoError(t, p.Shutdown())
+}
+
+func TestProvider_ReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	configs := []func(readOnly bool) KeyValueConfig{
+		func(readOnly bool) KeyValueConfig {
+			return KeyValueConfig{Badger: nullable.FromValue(badger.Config{
+				DirectoryPath: nullable.FromValue(dir + "/badger"),
+				ReadOnly:      readOnly,
+			})}
+		},
+		func(readOnly bool) KeyValueConfig {
+			return KeyValueConfig{File: nullable.FromValue(file.Config{
+				Path:     dir + "/data.yaml",
+				ReadOnly: readOnly,
+			})}
+		},
+	}
+
+	for _, cfg := range configs {
+		writable, err := GetKeyValueProviderFromConfig[string, string](cfg(false))
+		require.NoError(t, err)
+		require.NoError(t, writable.Setup())
+		require.NoError(t, writable.Store("key", "value"))
+		require.NoError(t, writable.Shutdown())
+
+		readOnly, err := GetKeyValueProviderFromConfig[string, string](cfg(true))
+		require.NoError(t, err)
+		require.NoError(t, readOnly.Setup())
+
+		val, err := readOnly.Get("key")
+		require.NoError(t, err)
+		assert.Equal(t, "value", val)
+
+		assert.True(t, errors.Is(readOnly.Store("key", "other"), errors.ReadOnly))
+		assert.True(t, errors.Is(readOnly.Remove("key"), errors.ReadOnly))
+		assert.True(t, errors.Is(readOnly.StoreReference("ref", "key"), errors.ReadOnly))
+
+		require.NoError(t, readOnly.Shutdown())
+	}
+}
+
+func TestFileProvider_JournalRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	cfg := file.Config{
+		Path:    path,
+		Journal: nullable.FromValue(file.JournalConfig{CheckpointEvery: 100, Retain: 2}),
+	}
+
+	p, err := file.New[string, string](cfg)
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	require.NoError(t, p.Store("a", "1"))
+	require.NoError(t, p.StoreReference("ref", "a"))
+
+	time.Sleep(5 * time.Millisecond)
+	restorePoint := time.Now()
+	time.Sleep(5 * time.Millisecond)
+
+	require.NoError(t, p.Store("a", "2"))
+	require.NoError(t, p.Store("b", "3"))
+	require.NoError(t, p.RemoveReference("ref"))
+
+	replayed, err := file.New[string, string](cfg)
+	require.NoError(t, err)
+	require.NoError(t, replayed.Setup())
+	val, err := replayed.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, "2", val)
+	require.NoError(t, replayed.Shutdown())
+
+	require.NoError(t, p.RestoreToTime(restorePoint))
+
+	val, err = p.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, "1", val)
+	_, err = p.Get("b")
+	assert.True(t, errors.Is(err, errors.NotFound))
+	val, err = p.GetByReference("ref")
+	require.NoError(t, err)
+	assert.Equal(t, "1", val)
+
+	require.NoError(t, p.Shutdown())
+}
+
+func TestFileProvider_CSV(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.csv")
+
+	p, err := file.New[string, map[string]int](file.Config{Path: path})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	require.NoError(t, p.Store("a", map[string]int{"x": 1}))
+	require.NoError(t, p.Store("b,c", map[string]int{"y": 2}))
+	require.NoError(t, p.AddReference("ref", "a"))
+	require.NoError(t, p.AddReference("ref", "b,c"))
+	require.NoError(t, p.Shutdown())
+
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "key,value\na,\"{\"\"x\"\":1}\"\n\"b,c\",\"{\"\"y\"\":2}\"\n", string(raw))
+
+	raw, err = os.ReadFile(filepath.Join(dir, "data.references.csv"))
+	require.NoError(t, err)
+	assert.Equal(t, "reference,key\nref,a\nref,\"b,c\"\n", string(raw))
+
+	reopened, err := file.New[string, map[string]int](file.Config{Path: path})
+	require.NoError(t, err)
+	require.NoError(t, reopened.Setup())
+	defer reopened.Shutdown()
+	require.NoError(t, reopened.Verify())
+
+	values, err := reopened.GetAllByReference("ref")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []map[string]int{{"x": 1}, {"y": 2}}, values)
+
+	var backup bytes.Buffer
+	require.NoError(t, reopened.Backup(&backup))
+	restored, err := file.New[string, map[string]int](file.Config{Path: filepath.Join(dir, "restored.csv")})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "restored.csv"), backup.Bytes(), 0644))
+	require.NoError(t, restored.Setup())
+	defer restored.Shutdown()
+	values, err = restored.GetAllByReference("ref")
+	require.NoError(t, err)
+	assert.Len(t, values, 2)
+}
+
+func TestFileProvider_Directory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	open := func(cfg file.DirectoryConfig) KeyValueProvider[string, map[string]int] {
+		p, err := file.New[string, map[string]int](file.Config{Path: dir, Directory: nullable.FromValue(cfg)})
+		require.NoError(t, err)
+		require.NoError(t, p.Setup())
+		return p
+	}
+
+	p := open(file.DirectoryConfig{})
+	require.NoError(t, p.Store("a", map[string]int{"x": 1}))
+	require.NoError(t, p.Store("user/.b c", map[string]int{"y": 2}))
+	require.NoError(t, p.AddReference("ref", "a"))
+	require.NoError(t, p.AddReference("ref", "user/.b c"))
+
+	raw, err := os.ReadFile(filepath.Join(dir, "a.json"))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"x": 1}`, string(raw))
+	_, err = os.Stat(filepath.Join(dir, "user%2F.b%20c.json"))
+	require.NoError(t, err)
+
+	info, err := os.Stat(filepath.Join(dir, "a.json"))
+	require.NoError(t, err)
+	require.NoError(t, p.Store("c", map[string]int{"z": 3}))
+	unchanged, err := os.Stat(filepath.Join(dir, "a.json"))
+	require.NoError(t, err)
+	assert.Equal(t, info.ModTime(), unchanged.ModTime())
+
+	require.NoError(t, p.Remove("c"))
+	_, err = os.Stat(filepath.Join(dir, "c.json"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	require.NoError(t, p.Shutdown())
+
+	p = open(file.DirectoryConfig{Shards: 4})
+	values, err := p.GetAllByReference("ref")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []map[string]int{{"x": 1}, {"y": 2}}, values)
+	require.NoError(t, p.Verify())
+	require.NoError(t, p.Shutdown())
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	require.NoError(t, err)
+	for _, f := range files {
+		assert.True(t, strings.HasPrefix(filepath.Base(f), "@"), f)
+	}
+
+	p = open(file.DirectoryConfig{Shards: 4})
+	defer p.Shutdown()
+	value, err := p.Get("user/.b c")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"y": 2}, value)
+
+	assert.Error(t, file.Config{Path: dir, Directory: nullable.FromValue(file.DirectoryConfig{Format: "csv"})}.Validate())
+	assert.Error(t, file.Config{Path: dir, Directory: nullable.FromValue(file.DirectoryConfig{}), Journal: nullable.FromValue(file.JournalConfig{})}.Validate())
+}
+
+func TestFileProvider_PreserveComments(t *testing.T) {
+	original := `# Feature flags, reviewed in git.
+data:
+    # Shared defaults.
+    base: &base
+        enabled: true
+        limit: 10
+    beta: *base # same as base
+    legacy:
+        enabled: false
+        limit: 1
+`
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(original), 0644))
+
+	p, err := file.New[string, map[string]any](file.Config{Path: path, PreserveComments: true})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	require.NoError(t, p.Remove("legacy"))
+	require.NoError(t, p.Shutdown())
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	header := "header:\n    format_version: 1\n    key_type: string\n    value_type: map[string]interface {}\n    codec: yaml\n"
+	assert.Equal(t, header+strings.TrimSuffix(original, "    legacy:\n        enabled: false\n        limit: 1\n"), string(raw))
+
+	require.NoError(t, os.WriteFile(path, []byte(original), 0644))
+	p, err = file.New[string, map[string]any](file.Config{Path: path, PreserveComments: true})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	require.NoError(t, p.Store("alpha", map[string]any{"enabled": true, "limit": 5}))
+	require.NoError(t, p.Update("base", func(value map[string]any, exists bool) (map[string]any, error) {
+		value["limit"] = 20
+		return value, nil
+	}))
+	require.NoError(t, p.Shutdown())
+
+	raw, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, header+`# Feature flags, reviewed in git.
+data:
+    alpha:
+        enabled: true
+        limit: 5
+    # Shared defaults.
+    base:
+        enabled: true
+        limit: 20
+    beta: # same as base
+        enabled: true
+        limit: 10
+    legacy:
+        enabled: false
+        limit: 1
+`, string(raw))
+
+	p, err = file.New[string, map[string]any](file.Config{Path: path, PreserveComments: true})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	defer p.Shutdown()
+	value, err := p.Get("beta")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"enabled": true, "limit": 10}, value)
+
+	assert.Error(t, file.Config{Path: filepath.Join(t.TempDir(), "config.json"), PreserveComments: true}.Validate())
+}
+
+func TestFileProvider_Header(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	p, err := file.New[string, map[string]int](file.Config{Path: path})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	require.NoError(t, p.Store("a", map[string]int{"x": 1}))
+	require.NoError(t, p.Shutdown())
+
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), `"value_type": "map[string]int"`)
+
+	reopened, err := file.New[string, map[string]int](file.Config{Path: path})
+	require.NoError(t, err)
+	require.NoError(t, reopened.Setup())
+	require.NoError(t, reopened.Verify())
+	require.NoError(t, reopened.Shutdown())
+
+	wrongValue, err := file.New[string, []string](file.Config{Path: path})
+	require.NoError(t, err)
+	err = wrongValue.Setup()
+	assert.ErrorIs(t, err, errors.SchemaMismatch)
+	assert.ErrorContains(t, err, "values are map[string]int, but the provider uses []string values")
+
+	wrongKey, err := file.New[uint64, map[string]int](file.Config{Path: path})
+	require.NoError(t, err)
+	assert.ErrorIs(t, wrongKey.Setup(), errors.SchemaMismatch)
+
+	renamed := filepath.Join(filepath.Dir(path), "data.yaml")
+	require.NoError(t, os.WriteFile(renamed, raw, 0644))
+	wrongCodec, err := file.New[string, map[string]int](file.Config{Path: renamed})
+	require.NoError(t, err)
+	assert.ErrorContains(t, wrongCodec.Setup(), "the file was written as json, but is read as yaml")
+
+	require.NoError(t, os.WriteFile(path, []byte(`[1, 2, 3]`), 0644))
+	notStorage, err := file.New[string, map[string]int](file.Config{Path: path})
+	require.NoError(t, err)
+	assert.ErrorIs(t, notStorage.Setup(), errors.Corrupted)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"data": {"a": {"x": 1}}}`), 0644))
+	legacy, err := file.New[string, map[string]int](file.Config{Path: path})
+	require.NoError(t, err)
+	require.NoError(t, legacy.Setup())
+	defer legacy.Shutdown()
+	value, err := legacy.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"x": 1}, value)
+}
+
+func TestFileProvider_Overlay(t *testing.T) {
+	content := `{"data": {"a": 1, "b": 2}, "references": {"first": "a"}}`
+
+	inline, err := file.New[string, int](file.Config{Content: content})
+	require.NoError(t, err)
+	require.NoError(t, inline.Setup())
+	err = inline.Store("c", 3)
+	assert.ErrorIs(t, err, errors.ReadOnly)
+	assert.ErrorContains(t, err, "overlay_path")
+	require.NoError(t, inline.Shutdown())
+
+	overlayPath := filepath.Join(t.TempDir(), "overlay.yaml")
+	open := func() KeyValueProvider[string, int] {
+		p, err := file.New[string, int](file.Config{Content: content, OverlayPath: overlayPath})
+		require.NoError(t, err)
+		require.NoError(t, p.Setup())
+		return p
+	}
+
+	p := open()
+	require.NoError(t, p.Store("a", 10))
+	require.NoError(t, p.Store("c", 3))
+	require.NoError(t, p.Remove("b"))
+	require.NoError(t, p.RemoveReference("first"))
+	require.NoError(t, p.AddReference("all", "a"))
+	require.NoError(t, p.AddReference("all", "c"))
+	require.NoError(t, p.Shutdown())
+
+	raw, err := os.ReadFile(overlayPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), "removed:\n    - b\n")
+	assert.Contains(t, string(raw), "removed_references:\n    - first\n")
+	assert.NotContains(t, string(raw), "b: 2")
+
+	p = open()
+	defer p.Shutdown()
+	require.NoError(t, p.Verify())
+	value, err := p.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, 10, value)
+	_, err = p.Get("b")
+	assert.ErrorIs(t, err, errors.NotFound)
+	values, err := p.GetAllByReference("all")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int{10, 3}, values)
+	_, err = p.GetByReference("first")
+	assert.ErrorIs(t, err, errors.NotFound)
+
+	assert.Error(t, file.Config{Path: filepath.Join(t.TempDir(), "data.json"), OverlayPath: overlayPath}.Validate())
+	assert.Error(t, file.Config{Content: content, OverlayPath: "overlay.csv"}.Validate())
+}
+
+func TestFileProvider_Closed(t *testing.T) {
+	for _, name := range []string{"data.yaml", "data.sqlite", "data.db"} {
+		t.Run(name, func(t *testing.T) {
+			p, err := file.New[string, string](file.Config{Path: filepath.Join(t.TempDir(), name)})
+			require.NoError(t, err)
+			require.NoError(t, p.Setup())
+			require.NoError(t, p.Store("key", "value"))
+			require.NoError(t, p.StoreReference("ref", "key"))
+			require.NoError(t, p.Shutdown())
+
+			assert.ErrorIs(t, p.Store("key", "updated"), errors.Closed)
+			assert.ErrorIs(t, p.Update("key", func(value string, exists bool) (string, error) { return "updated", nil }), errors.Closed)
+			assert.ErrorIs(t, p.Remove("key"), errors.Closed)
+			assert.ErrorIs(t, p.StoreReference("other", "key"), errors.Closed)
+			_, err = p.Get("key")
+			assert.ErrorIs(t, err, errors.Closed)
+			_, err = p.GetByReference("ref")
+			assert.ErrorIs(t, err, errors.Closed)
+			assert.ErrorIs(t, p.ForEachKey(func(key string) bool { return true }), errors.Closed)
+			require.NoError(t, p.Shutdown())
+
+			require.NoError(t, p.Setup())
+			defer p.Shutdown()
+			val, err := p.Get("key")
+			require.NoError(t, err)
+			assert.Equal(t, "value", val, "writes after Shutdown are not applied")
+		})
+	}
+}
+
+func TestFileProvider_SQLite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.db")
+
+	p, err := file.New[uint64, string](file.Config{Path: path})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	require.NoError(t, p.Store(1, "one"))
+	require.NoError(t, p.Store(2, "two"))
+	require.NoError(t, p.Remove(2))
+	require.NoError(t, p.AddReference(10, 1))
+	require.NoError(t, p.Update(3, func(string, bool) (string, error) { return "three", nil }))
+	require.NoError(t, p.AddReference(10, 3))
+
+	var backup bytes.Buffer
+	require.NoError(t, p.Backup(&backup))
+	assert.True(t, bytes.HasPrefix(backup.Bytes(), []byte("SQLite format 3")))
+	require.NoError(t, p.Shutdown())
+
+	reopened, err := file.New[uint64, string](file.Config{Path: path, ReadOnly: true})
+	require.NoError(t, err)
+	require.NoError(t, reopened.Setup())
+	defer reopened.Shutdown()
+	require.NoError(t, reopened.Verify())
+
+	_, err = reopened.Get(2)
+	assert.True(t, errors.Is(err, errors.NotFound))
+	values, err := reopened.GetAllByReference(10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"one", "three"}, values)
+
+	_, err = file.New[uint64, string](file.Config{
+		Path:    path,
+		Journal: nullable.FromValue(file.JournalConfig{}),
+	})
+	assert.Error(t, err)
+}
+
+func TestFileProvider_BeginRead(t *testing.T) {
+	p, err := file.New[string, int](file.Config{Path: filepath.Join(t.TempDir(), "accounts.json")})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	defer p.Shutdown()
+	require.NoError(t, p.Store("alice", 100))
+	require.NoError(t, p.Store("bob", 0))
+
+	view, err := p.BeginRead()
+	require.NoError(t, err)
+	require.NoError(t, p.Store("alice", 50))
+	require.NoError(t, p.Store("bob", 50))
+	require.NoError(t, p.Store("carol", 1))
+
+	balances, err := view.GetMultiple([]string{"alice", "bob"})
+	require.NoError(t, err)
+	assert.Equal(t, []int{100, 0}, balances)
+	_, err = view.Get("carol")
+	assert.ErrorIs(t, err, errors.NotFound)
+
+	latest, err := p.BeginRead()
+	require.NoError(t, err)
+	defer latest.Close()
+	balances, err = latest.GetMultiple([]string{"alice", "bob"})
+	require.NoError(t, err)
+	assert.Equal(t, []int{50, 50}, balances)
+
+	require.NoError(t, view.Close())
+	_, err = view.Get("alice")
+	assert.ErrorIs(t, err, errors.Closed)
+}
+
+func TestProvider_Integrity(t *testing.T) {
+	type user struct {
+		Name string `json:"name" yaml:"name"`
+	}
+	t.Setenv("STORAGE_TEST_INTEGRITY_SECRET", "s3cret")
+	signed := nullable.FromValue(integrity.Config{SecretEnv: "STORAGE_TEST_INTEGRITY_SECRET"})
+
+	path := filepath.Join(t.TempDir(), "data.json")
+	cfg := KeyValueConfig{File: nullable.FromValue(file.Config{Path: path, Integrity: signed})}
+	p, err := GetKeyValueProviderFromConfig[string, user](cfg)
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	require.NoError(t, p.Store("a", user{Name: "Ann"}))
+	require.NoError(t, p.Store("b", user{Name: "Bob"}))
+	require.NoError(t, p.Shutdown())
+
+	p, err = GetKeyValueProviderFromConfig[string, user](cfg)
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	val, err := p.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, user{Name: "Ann"}, val)
+	require.NoError(t, p.Verify())
+	require.NoError(t, p.Shutdown())
+
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, bytes.Replace(raw, []byte(`"Ann"`), []byte(`"Eve"`), 1), 0644))
+	p, err = GetKeyValueProviderFromConfig[string, user](cfg)
+	require.NoError(t, err)
+	err = p.Setup()
+	assert.True(t, errors.Is(err, errors.Tampered))
+	assert.ErrorContains(t, err, "key a: integrity check failed")
+
+	_, err = GetKeyValueProviderFromConfig[string, user](KeyValueConfig{File: nullable.FromValue(file.Config{
+		Path:      filepath.Join(t.TempDir(), "data.csv"),
+		Integrity: signed,
+	})})
+	assert.ErrorContains(t, err, "integrity is only supported")
+
+	dir := t.TempDir()
+	badgerConfig := badger.Config{DirectoryPath: nullable.FromValue(dir), Integrity: signed}
+	b, err := GetKeyValueProviderFromConfig[string, user](KeyValueConfig{Badger: nullable.FromValue(badgerConfig)})
+	require.NoError(t, err)
+	require.NoError(t, b.Setup())
+	require.NoError(t, b.Store("a", user{Name: "Ann"}))
+	val, err = b.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, user{Name: "Ann"}, val)
+	require.NoError(t, b.Shutdown())
+
+	unsigned, err := GetKeyValueProviderFromConfig[string, user](KeyValueConfig{Badger: nullable.FromValue(badger.Config{DirectoryPath: nullable.FromValue(dir)})})
+	require.NoError(t, err)
+	require.NoError(t, unsigned.Setup())
+	require.NoError(t, unsigned.Store("a", user{Name: "Eve"}))
+	require.NoError(t, unsigned.Shutdown())
+
+	b, err = GetKeyValueProviderFromConfig[string, user](KeyValueConfig{Badger: nullable.FromValue(badgerConfig)})
+	require.NoError(t, err)
+	require.NoError(t, b.Setup())
+	defer b.Shutdown()
+	_, err = b.Get("a")
+	assert.True(t, errors.Is(err, errors.Tampered))
+	assert.True(t, errors.Is(b.Verify(), errors.Tampered))
+}
+
+func TestKeyringProvider(t *testing.T) {
+	gokeyring.MockInit()
+
+	cfg, err := ParseKeyValueConfig([]byte("keyring:\n  service: storage-test\n"))
+	require.NoError(t, err)
+	p, err := GetKeyValueProviderFromConfig[string, string](cfg)
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+
+	require.NoError(t, p.Store("github", "gh-token"))
+	require.NoError(t, p.Store("gitlab", "gl-token"))
+	require.NoError(t, p.Store("npm", "npm-token"))
+	require.NoError(t, p.StoreReference("default", "github"))
+
+	value, err := p.Get("gitlab")
+	require.NoError(t, err)
+	assert.Equal(t, "gl-token", value)
+	value, err = p.GetByReference("default")
+	require.NoError(t, err)
+	assert.Equal(t, "gh-token", value)
+	_, err = p.Get("missing")
+	assert.ErrorIs(t, err, errors.NotFound)
+
+	var keys []string
+	require.NoError(t, p.ForEachPrefix("git", func(key string, _ string) bool {
+		keys = append(keys, key)
+		return true
+	}))
+	assert.Equal(t, []string{"github", "gitlab"}, keys)
+
+	require.NoError(t, p.Remove("npm"))
+	stats, err := p.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), stats.Entries)
+	assert.Equal(t, uint64(1), stats.References)
+
+	// A new provider for the same service sees the stored entries.
+	other, err := GetKeyValueProviderFromConfig[string, string](cfg)
+	require.NoError(t, err)
+	require.NoError(t, other.Setup())
+	value, err = other.Get("github")
+	require.NoError(t, err)
+	assert.Equal(t, "gh-token", value)
+
+	require.NoError(t, p.Clear())
+	require.NoError(t, other.ForEachKey(func(string) bool {
+		t.Fatal("keyring is not empty after Clear")
+		return false
+	}))
+
+	require.NoError(t, p.Shutdown())
+	_, err = p.Get("github")
+	assert.ErrorIs(t, err, errors.Closed)
+}
+
+func TestLocalStorageProvider_NotWasm(t *testing.T) {
+	cfg, err := ParseKeyValueConfig([]byte("local_storage:\n  prefix: \"app:\"\n  session: true\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "app:", cfg.LocalStorage.GetValue().Prefix)
+
+	_, err = GetKeyValueProviderFromConfig[string, string](cfg)
+	assert.ErrorIs(t, err, errors.Unsupported)
+}
+
+func TestProvider_ScheduledBackup(t *testing.T) {
+	dir := t.TempDir()
+	destination, err := GetKeyValueProviderFromConfig[string, []byte](KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, destination.Setup())
+	defer destination.Shutdown()
+
+	schedules := []BackupSchedule{
+		{Interval: 5 * time.Millisecond, Directory: filepath.Join(dir, "backups"), Retain: 2},
+		{Interval: 5 * time.Millisecond, Destination: destination, Retain: 2},
+	}
+
+	for _, schedule := range schedules {
+		succeeded := make(chan string, 100)
+		schedule.OnSuccess = func(name string, size int) {
+			succeeded <- name
+		}
+		schedule.OnFailure = func(err error) {
+			t.Error(err)
+		}
+
+		p, err := GetKeyValueProviderFromConfig[string, string](KeyValueConfig{
+			File:   nullable.FromValue(file.Config{Path: filepath.Join(dir, "data.json")}),
+			Backup: nullable.FromValue(schedule),
+		})
+		require.NoError(t, err)
+		require.NoError(t, p.Setup())
+		require.NoError(t, p.Store("key", "value"))
+
+		for range 3 {
+			select {
+			case <-succeeded:
+			case <-time.After(time.Second):
+				t.Fatal("backup did not run")
+			}
+		}
+		require.NoError(t, p.Shutdown())
+
+		var backups int
+		if schedule.Directory != "" {
+			entries, err := os.ReadDir(schedule.Directory)
+			require.NoError(t, err)
+			backups = len(entries)
+		} else {
+			require.NoError(t, destination.ForEach(func(key string, value []byte) bool {
+				backups++
+				assert.Contains(t, string(value), "value")
+				return true
+			}))
+		}
+		assert.Equal(t, 2, backups)
+	}
+
+	_, err = GetKeyValueProviderFromConfig[string, string](KeyValueConfig{
+		File:   nullable.FromValue(file.Config{Path: filepath.Join(dir, "data.json")}),
+		Backup: nullable.FromValue(BackupSchedule{Interval: time.Second}),
+	})
+	assert.Error(t, err)
+}
+
+func TestExportImport(t *testing.T) {
+	performTestsForProviders(t, func(t *testing.T, p KeyValueProvider[uint64, User]) {
+		users := []User{
+			{ID: 1, Name: "Alice", Address: Address{City: "Berlin", Country: "DE"}, Age: 30},
+			{ID: 2, Name: "Bob, \"the builder\"", Address: Address{City: "Paris", Country: "FR"}, Age: 40},
+		}
+		for _, user := range users {
+			require.NoError(t, p.Store(user.ID, user))
+		}
+
+		for _, format := range []ExportFormat{JSONL, CSV} {
+			var buf bytes.Buffer
+			require.NoError(t, Export(p, &buf, format))
+
+			target, err := GetKeyValueProviderFromConfig[uint64, User](KeyValueConfig{
+				Badger: nullable.FromValue(badger.Config{InMemory: true}),
+			})
+			require.NoError(t, err)
+			require.NoError(t, target.Setup())
+			require.NoError(t, Import(target, &buf, format))
+
+			values, err := target.GetMultiple([]uint64{1, 2})
+			require.NoError(t, err)
+			assert.Equal(t, users, values)
+			require.NoError(t, target.Shutdown())
+		}
+
+		assert.Error(t, Export(p, &bytes.Buffer{}, "xml"))
+	})
+}
+
+type Customer struct {
+	Name     string
+	Email    string `storage:"redact"`
+	Card     *Card
+	Contacts []Contact
+}
+
+type Card struct {
+	Number string `storage:"redact"`
+	Expiry int    `storage:"redact"`
+}
+
+type Contact struct {
+	Phone string `storage:"redact"`
+}
+
+type Note string
+
+func (n Note) Redact() Note {
+	return Note(strings.Repeat("*", len(n)))
+}
+
+func TestRedact(t *testing.T) {
+	customer := Customer{
+		Name:     "Ann",
+		Email:    "ann@example.com",
+		Card:     &Card{Number: "4111", Expiry: 2030},
+		Contacts: []Contact{{Phone: "+1555"}},
+	}
+
+	redacted := Redact(customer)
+	assert.Equal(t, Customer{
+		Name:     "Ann",
+		Email:    RedactedText,
+		Card:     &Card{Number: RedactedText},
+		Contacts: []Contact{{Phone: RedactedText}},
+	}, redacted)
+	assert.Equal(t, "ann@example.com", customer.Email)
+	assert.Equal(t, "4111", customer.Card.Number)
+	assert.Equal(t, "+1555", customer.Contacts[0].Phone)
+
+	assert.Equal(t, Note("****"), Redact(Note("memo")))
+	assert.Equal(t, 42, Redact(42))
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	logger.Info("customer", slog.Any("customer", Redacted(customer)))
+	assert.NotContains(t, buf.String(), "ann@example.com")
+	assert.Contains(t, buf.String(), RedactedText)
+
+	performTestsForProviders(t, func(t *testing.T, p KeyValueProvider[string, Customer]) {
+		require.NoError(t, p.Store("customer:1", customer))
+
+		var buf bytes.Buffer
+		require.NoError(t, ExportRedacted(p, &buf, JSONL))
+		assert.NotContains(t, buf.String(), "ann@example.com")
+		assert.Contains(t, buf.String(), `"Name":"Ann"`)
+
+		buf.Reset()
+		require.NoError(t, Export(p, &buf, JSONL))
+		assert.Contains(t, buf.String(), "ann@example.com")
+	})
+}
+
+type Order struct {
+	Owner  string
+	Buyers []string
+}
+
+func TestEraseByIndex(t *testing.T) {
+	performTestsForProviders(t, func(t *testing.T, p KeyValueProvider[string, Order]) {
+		require.NoError(t, StoreWithReferences(p, "order:1", Order{Owner: "42"}, "owner:42"))
+		require.NoError(t, p.Store("order:2", Order{Owner: "7", Buyers: []string{"42"}}))
+		require.NoError(t, p.Store("order:3", Order{Owner: "7"}))
+		require.NoError(t, p.AddReference("status:open", "order:1"))
+		require.NoError(t, p.AddReference("status:open", "order:3"))
+
+		indexes := NewIndexes(p)
+		require.NoError(t, indexes.Register("buyer", func(key string, order Order) []string {
+			return order.Buyers
+		}))
+		assert.ErrorIs(t, indexes.Register("buyer", func(string, Order) []string { return nil }), errors.AlreadyExists)
+
+		keys, err := indexes.Lookup("buyer", "42")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"order:2"}, keys)
+
+		report, err := indexes.EraseByIndex("owner", "42")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"order:1"}, report.Keys)
+		assert.Equal(t, []string{"owner:42", "status:open"}, report.References)
+
+		_, err = p.Get("order:1")
+		assert.ErrorIs(t, err, errors.NotFound)
+		_, err = p.GetByReference("owner:42")
+		assert.ErrorIs(t, err, errors.NotFound)
+		open, err := p.GetAllByReference("status:open")
+		require.NoError(t, err)
+		assert.Equal(t, []Order{{Owner: "7"}}, open)
+
+		report, err = indexes.EraseByIndex("buyer", "42")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"order:2"}, report.Keys)
+		assert.Empty(t, report.References)
+
+		report, err = indexes.EraseByIndex("owner", "42")
+		require.NoError(t, err)
+		assert.Empty(t, report.Keys)
+	})
+
+	_, err := NewIndexes[uint64, Order](nil).Lookup("owner", "42")
+	assert.ErrorIs(t, err, errors.NotFound)
+}
+
+type orderKey struct {
+	Tenant string
+	ID     uuid.UUID
+	Seq    int
+}
+
+func TestKeyed(t *testing.T) {
+	first, second := uuid.MustParse("00000000-0000-0000-0000-000000000001"), uuid.MustParse("ffffffff-0000-0000-0000-000000000000")
+	keys := []orderKey{
+		{Tenant: "acme", ID: first, Seq: -5},
+		{Tenant: "acme", ID: first, Seq: 3},
+		{Tenant: "acme", ID: second, Seq: 0},
+		{Tenant: "acme-corp", ID: first, Seq: 0},
+	}
+
+	performTestsForProviders(t, func(t *testing.T, p KeyValueProvider[string, int]) {
+		keyed, err := NewKeyed[orderKey](p, nil)
+		require.NoError(t, err)
+		for i, key := range slices.Backward(keys) {
+			require.NoError(t, keyed.Store(key, i))
+		}
+
+		value, err := keyed.Get(keys[1])
+		require.NoError(t, err)
+		assert.Equal(t, 1, value)
+
+		var found []orderKey
+		require.NoError(t, keyed.ForEachPrefix(NewKey("acme").Prefix(), func(key orderKey, _ int) bool {
+			found = append(found, key)
+			return true
+		}))
+		slices.SortFunc(found, func(a, b orderKey) int {
+			ka, _ := keyed.codec.EncodeKey(a)
+			kb, _ := keyed.codec.EncodeKey(b)
+			return strings.Compare(ka, kb)
+		})
+		assert.Equal(t, keys[:3], found)
+
+		require.NoError(t, keyed.Remove(keys[0]))
+		_, err = keyed.Get(keys[0])
+		assert.ErrorIs(t, err, errors.NotFound)
+
+		require.NoError(t, p.Store("plain", 1))
+		assert.Error(t, keyed.ForEach(func(orderKey, int) bool { return true }))
+	})
+
+	codec, err := NewKeyCodec[orderKey]()
+	require.NoError(t, err)
+	var encoded []string
+	for _, key := range keys {
+		s, err := codec.EncodeKey(key)
+		require.NoError(t, err)
+		encoded = append(encoded, s)
+
+		decoded, err := codec.DecodeKey(s)
+		require.NoError(t, err)
+		assert.Equal(t, key, decoded)
+	}
+	assert.True(t, slices.IsSorted(encoded))
+
+	ints, err := NewKeyCodec[int8]()
+	require.NoError(t, err)
+	_, err = ints.DecodeKey(NewKey().Uint64(1000).Key())
+	assert.Error(t, err)
+
+	_, err = NewKeyCodec[struct{ F float64 }]()
+	assert.Error(t, err)
+}
+
+func TestKeyed_UUID(t *testing.T) {
+	ann, bob := uuid.New(), uuid.New()
+
+	performTestsForProviders(t, func(t *testing.T, p KeyValueProvider[string, string]) {
+		users, err := NewKeyed[uuid.UUID](p, nil)
+		require.NoError(t, err)
+
+		require.NoError(t, users.StoreWithReferences(ann, "ann", "email:ann@example.com"))
+		require.NoError(t, users.Store(bob, "bob"))
+		require.NoError(t, users.AddReference("team:ops", ann))
+		require.NoError(t, users.AddReference("team:ops", bob))
+
+		// Other tools see the canonical form.
+		value, err := p.Get(ann.String())
+		require.NoError(t, err)
+		assert.Equal(t, "ann", value)
+
+		value, err = users.GetByReference("email:ann@example.com")
+		require.NoError(t, err)
+		assert.Equal(t, "ann", value)
+
+		require.NoError(t, users.RemoveReferenceTarget("team:ops", ann))
+		targets := map[string][]uuid.UUID{}
+		require.NoError(t, users.ForEachReference(func(reference string, key uuid.UUID) bool {
+			targets[reference] = append(targets[reference], key)
+			return true
+		}))
+		assert.Equal(t, map[string][]uuid.UUID{"email:ann@example.com": {ann}, "team:ops": {bob}}, targets)
+	})
+}
+
+func TestSeed(t *testing.T) {
+	fsys := fstest.MapFS{
+		"seed/1-users.json":  {Data: []byte(`{"user:1": "ann", "user:2": "bob"}`)},
+		"seed/2-groups.yaml": {Data: []byte("group:admins: ann\n")},
+		"seed/3-more.jsonl":  {Data: []byte(`{"key": "user:2", "value": "bobby"}` + "\n")},
+		"seed/readme.txt":    {Data: []byte("not data")},
+	}
+
+	performTestsForProviders[string, string](t, func(t *testing.T, p KeyValueProvider[string, string]) {
+		seeded, err := Seed(p, fsys, "seed/*.[jy]*")
+		require.NoError(t, err)
+		assert.True(t, seeded)
+
+		value, err := p.Get("user:2")
+		require.NoError(t, err)
+		assert.Equal(t, "bobby", value)
+		value, err = p.Get("group:admins")
+		require.NoError(t, err)
+		assert.Equal(t, "ann", value)
+
+		require.NoError(t, p.Store("user:1", "ann smith"))
+		seeded, err = Seed(p, fsys, "seed/*.[jy]*")
+		require.NoError(t, err)
+		assert.False(t, seeded)
+		value, err = p.Get("user:1")
+		require.NoError(t, err)
+		assert.Equal(t, "ann smith", value)
+
+		_, err = Seed(p, fsys, "seed/*.txt")
+		assert.Error(t, err)
+		_, err = Seed(p, fsys, "missing/*")
+		assert.Error(t, err)
+	})
+}
+
+func TestKeyValueConfig_Validate(t *testing.T) {
+	cfg, err := ParseKeyValueConfig([]byte("badger:\n  in_memory: true\n"))
+	require.NoError(t, err)
+	assert.True(t, cfg.Badger.GetValue().InMemory)
+
+	_, err = ParseKeyValueConfig([]byte("badger:\n  in_memroy: true\n"))
+	assert.ErrorContains(t, err, "in_memroy")
+
+	err = KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true, ReadOnly: true}),
+		File:   nullable.FromValue(file.Config{Path: "data.txt"}),
+	}.Validate()
+	assert.ErrorContains(t, err, "badger: in-memory database cannot be read-only")
+	assert.ErrorContains(t, err, "file: unsupported file extension")
+	assert.ErrorContains(t, err, "multiple storage providers are configured")
+
+	_, err = GetKeyValueProviderFromConfig[string, string](KeyValueConfig{})
+	assert.ErrorContains(t, err, "storage provider is not configured")
+}
+
+func TestKeyValueConfig_Pool(t *testing.T) {
+	cfg, err := ParseKeyValueConfig([]byte("mysql:\n  dsn: \"user:pass@tcp(127.0.0.1:3306)/app\"\n  pool:\n    max_open: 20\n    max_idle: 5\n    wait_timeout: 2s\n"))
+	require.NoError(t, err)
+	assert.Equal(t, kv.PoolConfig{MaxOpen: 20, MaxIdle: 5, WaitTimeout: 2 * time.Second}, cfg.MySQL.GetValue().Pool)
+
+	_, err = ParseKeyValueConfig([]byte("mysql:\n  dsn: \"user:pass@tcp(127.0.0.1:3306)/app\"\n  pool:\n    max_open: 2\n    max_idle: 5\n"))
+	assert.ErrorContains(t, err, "pool.max_idle must not exceed pool.max_open")
+	_, err = ParseKeyValueConfig([]byte("memcached:\n  servers: [\"127.0.0.1:11211\"]\n  pool:\n    wait_timeout: 1s\n"))
+	assert.ErrorContains(t, err, "memcached only supports pool.max_idle")
+	_, err = ParseKeyValueConfig([]byte("memcached:\n  servers: [\"127.0.0.1:11211\"]\n  pool:\n    max_idle: 8\n"))
+	assert.NoError(t, err)
+}
+
+func TestKeyValueConfig_TLS(t *testing.T) {
+	cfg, err := ParseKeyValueConfig([]byte("mysql:\n  dsn: \"user:pass@tcp(db.internal:3306)/app\"\n  tls:\n    ca_file: /etc/ssl/db-ca.pem\n    server_name: db.internal\n"))
+	require.NoError(t, err)
+	require.True(t, cfg.MySQL.GetValue().TLS.HasValue())
+	assert.Equal(t, kv.TLSConfig{CAFile: "/etc/ssl/db-ca.pem", ServerName: "db.internal"}, cfg.MySQL.GetValue().TLS.GetValue())
+
+	_, err = ParseKeyValueConfig([]byte("memcached:\n  servers: [\"127.0.0.1:11211\"]\n  tls:\n    cert_file: client.pem\n"))
+	assert.ErrorContains(t, err, "tls.cert_file and tls.key_file must be set together")
+	_, err = ParseKeyValueConfig([]byte("mysql:\n  dsn: \"user:pass@tcp(db.internal:3306)/app?tls=true\"\n  tls:\n    server_name: db.internal\n"))
+	assert.ErrorContains(t, err, "mutually exclusive")
+
+	_, err = kv.TLSConfig{CAFile: filepath.Join(t.TempDir(), "missing.pem")}.Client()
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = kv.TLSConfig{}.Server()
+	assert.Error(t, err)
+	config, err := kv.TLSConfig{ServerName: "db.internal"}.Client()
+	require.NoError(t, err)
+	assert.Equal(t, "db.internal", config.ServerName)
+	assert.Nil(t, config.RootCAs)
+}
+
+func TestResolveSecrets(t *testing.T) {
+	t.Setenv("STORAGE_TEST_PASSWORD", "s3cret")
+	secret := filepath.Join(t.TempDir(), "password")
+	require.NoError(t, os.WriteFile(secret, []byte("from-file\n"), 0o600))
+
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" || r.URL.Path != "/v1/secret/data/app" {
+			http.Error(w, "denied", http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"data":{"data":{"password":"from-vault"},"metadata":{"version":3}}}`)
+	}))
+	defer vault.Close()
+	t.Setenv("VAULT_ADDR", vault.URL)
+	t.Setenv("VAULT_TOKEN", "token")
+
+	ctx := context.Background()
+	for ref, expected := range map[string]string{
+		"user:${env:STORAGE_TEST_PASSWORD}@tcp(db:3306)/app": "user:s3cret@tcp(db:3306)/app",
+		"file:" + secret:                        "from-file",
+		"a ${file:" + secret + "} b":            "a from-file b",
+		"vault:secret/data/app#password":        "from-vault",
+		"$${env:STORAGE_TEST_PASSWORD}":         "${env:STORAGE_TEST_PASSWORD}",
+		"user:password@tcp(127.0.0.1:3306)/app": "user:password@tcp(127.0.0.1:3306)/app",
+	} {
+		resolved, err := kv.ResolveSecrets(ctx, ref)
+		require.NoError(t, err, ref)
+		assert.Equal(t, expected, resolved, ref)
+	}
+
+	for _, ref := range []string{"${env:STORAGE_TEST_MISSING}", "${env:STORAGE_TEST_PASSWORD", "${unknown:x}", "vault:secret/data/app#missing", "vault:secret/data/other#password"} {
+		_, err := kv.ResolveSecrets(ctx, ref)
+		assert.Error(t, err, ref)
+	}
+
+	_, err := ParseKeyValueConfig([]byte("mysql:\n  dsn: \"user:${env:STORAGE_TEST_PASSWORD}@tcp(db:3306)/app\"\n"))
+	assert.NoError(t, err)
+}
+
+func TestKeyValueConfig_EnvAndFlags(t *testing.T) {
+	env := map[string]string{
+		"STORAGE_PROVIDER":         "badger",
+		"STORAGE_BADGER_IN_MEMORY": "true",
+		"STORAGE_LAZY_MAX_BACKOFF": "5s",
+	}
+	cfg, err := loadKeyValueConfigFromEnv(func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	})
+	require.NoError(t, err)
+	assert.True(t, cfg.Badger.GetValue().InMemory)
+	assert.Equal(t, 5*time.Second, cfg.Lazy.GetValue().MaxBackoff)
+
+	env["STORAGE_BADGER_READ_ONLY"] = "maybe"
+	_, err = loadKeyValueConfigFromEnv(func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	})
+	assert.ErrorContains(t, err, "STORAGE_BADGER_READ_ONLY")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	load := KeyValueConfigFlags(fs)
+	require.NoError(t, fs.Parse([]string{"-storage.provider=file", "-storage.file.path=data.yaml"}))
+	cfg, err = load()
+	require.NoError(t, err)
+	assert.Equal(t, "data.yaml", cfg.File.GetValue().Path)
+	assert.False(t, cfg.Lazy.HasValue())
+}
+
+func TestVerifyReferences(t *testing.T) {
+	performTestsForProviders(t, func(t *testing.T, p KeyValueProvider[string, string]) {
+		require.NoError(t, p.Store("a", "1"))
+		require.NoError(t, p.Store("b", "2"))
+		require.NoError(t, p.StoreReference("ref-a", "a"))
+		require.NoError(t, p.StoreReference("ref-missing", "missing"))
+
+		report, err := VerifyReferences(p, RepairNone)
+		require.NoError(t, err)
+		assert.False(t, report.Consistent())
+		assert.Equal(t, 2, report.Keys)
+		assert.Equal(t, 2, report.References)
+		assert.Equal(t, map[string][]string{"ref-missing": {"missing"}}, report.Dangling)
+		assert.Equal(t, []string{"b"}, report.Unreferenced)
+
+		_, err = VerifyReferences(p, RepairDangling)
+		require.NoError(t, err)
+
+		report, err = VerifyReferences(p, RepairNone)
+		require.NoError(t, err)
+		assert.True(t, report.Consistent())
+		assert.Equal(t, []string{"b"}, report.Unreferenced)
+		val, err := p.Get("b")
+		require.NoError(t, err)
+		assert.Equal(t, "2", val)
+	})
+}
+
+func TestProvider_ManyToManyReferences(t *testing.T) {
+	performTestsForProviders(t, func(t *testing.T, p KeyValueProvider[string, string]) {
+		require.NoError(t, p.Store("a", "1"))
+		require.NoError(t, p.Store("b", "2"))
+		require.NoError(t, p.StoreReference("tag", "a"))
+		require.NoError(t, p.AddReference("tag", "b"))
+		require.NoError(t, p.AddReference("tag", "b"))
+		require.NoError(t, p.AddReference("tag", "missing"))
+
+		values, err := p.GetAllByReference("tag")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"1", "2"}, values)
+
+		val, err := p.GetByReference("tag")
+		require.NoError(t, err)
+		assert.Equal(t, "1", val)
+
+		var pairs int
+		require.NoError(t, p.ForEachReference(func(reference string, key string) bool {
+			pairs++
+			return true
+		}))
+		assert.Equal(t, 3, pairs)
+
+		require.NoError(t, p.RemoveReferenceTarget("tag", "a"))
+		assert.True(t, errors.Is(p.RemoveReferenceTarget("tag", "a"), errors.NotFound))
+		values, err = p.GetAllByReference("tag")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"2"}, values)
+
+		require.NoError(t, p.RemoveReference("tag"))
+		_, err = p.GetAllByReference("tag")
+		assert.True(t, errors.Is(err, errors.NotFound))
+	})
+}
+
+func TestProvider_ReferenceChains(t *testing.T) {
+	performTestsForProviders(t, func(t *testing.T, p KeyValueProvider[string, string]) {
+		require.NoError(t, p.Store("key", "value"))
+		require.NoError(t, p.StoreReference("alias", "key"))
+		require.NoError(t, p.StoreReference("alias-of-alias", "alias"))
+
+		val, err := p.GetByReference("alias-of-alias")
+		require.NoError(t, err)
+		assert.Equal(t, "value", val)
+
+		require.NoError(t, p.StoreReference("loop-a", "loop-b"))
+		require.NoError(t, p.StoreReference("loop-b", "loop-a"))
+		_, err = p.GetByReference("loop-a")
+		assert.True(t, errors.Is(err, errors.ReferenceCycle))
+
+		previous := "key"
+		for i := range 10 {
+			reference := fmt.Sprintf("chain-%d", i)
+			require.NoError(t, p.StoreReference(reference, previous))
+			previous = reference
+		}
+		_, err = p.GetByReference(previous)
+		assert.True(t, errors.Is(err, errors.ReferenceTooDeep))
+	})
+}
+
+func TestResolveReference(t *testing.T) {
+	performTestsForProviders(t, func(t *testing.T, p KeyValueProvider[string, string]) {
+		require.NoError(t, p.Store("a", "1"))
+		require.NoError(t, p.Store("b", "2"))
+		require.NoError(t, p.StoreReference("tag", "a"))
+		require.NoError(t, p.AddReference("tag", "alias"))
+		require.NoError(t, p.StoreReference("alias", "b"))
+
+		// The fallback scans the references, so check it against the
+		// provider's own resolution.
+		fallback := struct {
+			KeyValueProvider[string, string]
+		}{p}
+		for _, provider := range []KeyValueProvider[string, string]{p, fallback} {
+			keys, err := ResolveReference(provider, "tag")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"a", "b"}, keys)
+
+			_, err = ResolveReference(provider, "missing")
+			assert.True(t, errors.Is(err, errors.NotFound))
+		}
+
+		require.NoError(t, p.StoreReference("loop-a", "loop-b"))
+		require.NoError(t, p.StoreReference("loop-b", "loop-a"))
+		_, err := ResolveReference(fallback, "loop-a")
+		assert.True(t, errors.Is(err, errors.ReferenceCycle))
+	})
+}
+
+func TestProvider_RemovePrefixAndWhere(t *testing.T) {
+	performTestsForProviders(t, func(t *testing.T, p KeyValueProvider[string, int]) {
+		for i := range 5 {
+			require.NoError(t, p.Store(fmt.Sprintf("user/%d", i), i))
+			require.NoError(t, p.Store(fmt.Sprintf("order/%d", i), i))
+		}
+		require.NoError(t, p.StoreReference("user/latest", "user/4"))
+
+		removed, err := p.RemovePrefix("user/")
+		require.NoError(t, err)
+		assert.Equal(t, 5, removed)
+		_, err = p.Get("user/1")
+		assert.True(t, errors.Is(err, errors.NotFound))
+
+		removed, err = p.RemoveWhere(func(key string, value int) bool {
+			return value%2 == 0
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 3, removed)
+
+		var remaining []string
+		require.NoError(t, p.ForEachKey(func(key string) bool {
+			remaining = append(remaining, key)
+			return true
+		}))
+		assert.ElementsMatch(t, []string{"order/1", "order/3"}, remaining)
+
+		removed, err = p.RemovePrefix("order/1")
+		require.NoError(t, err)
+		assert.Equal(t, 1, removed)
+
+		removed, err = p.RemovePrefix("missing/")
+		require.NoError(t, err)
+		assert.Equal(t, 0, removed)
+	})
+}
+
+func TestProvider_Clear(t *testing.T) {
+	performTestsForProviders(t, func(t *testing.T, p KeyValueProvider[string, string]) {
+		require.NoError(t, p.Store("a", "1"))
+		require.NoError(t, p.Store("b", "2"))
+		require.NoError(t, p.StoreReference("ref", "a"))
+		require.NoError(t, p.AddReference("tag", "a"))
+		require.NoError(t, p.AddReference("tag", "b"))
+
+		require.NoError(t, p.Clear())
+
+		stats, err := p.Stats()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(0), stats.Entries)
+		assert.Equal(t, uint64(0), stats.References)
+
+		require.NoError(t, p.Store("a", "3"))
+		_, err = p.GetByReference("ref")
+		assert.True(t, errors.Is(err, errors.NotFound))
+	})
+}
+
+func TestFileProvider_WriteTimeout(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.json")
+	p, err := GetKeyValueProviderFromConfig[string, string](KeyValueConfig{
+		File: nullable.FromValue(file.Config{Path: path}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	require.NoError(t, p.Store("key", "value"))
+	require.NoError(t, p.Shutdown())
+	saved, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	p, err = GetKeyValueProviderFromConfig[string, string](KeyValueConfig{
+		File: nullable.FromValue(file.Config{Path: path, WriteTimeout: time.Nanosecond}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	defer p.Shutdown()
+
+	assert.True(t, errors.Is(p.Store("key", "updated"), errors.Timeout))
+	current, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, saved, current, "a timed out write does not replace the file")
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "the temporary file is removed")
+}
@@ -9,41 +9,444 @@
 package storage
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage/azureblob"
 	"github.com/rlshukhov/storage/badger"
+	storageErrors "github.com/rlshukhov/storage/errors"
 	"github.com/rlshukhov/storage/file"
+	"github.com/rlshukhov/storage/firestore"
+	"github.com/rlshukhov/storage/keyring"
+	"github.com/rlshukhov/storage/kv"
+	"github.com/rlshukhov/storage/localstorage"
+	"github.com/rlshukhov/storage/memcached"
+	"github.com/rlshukhov/storage/mysql"
+	"github.com/rlshukhov/storage/rocksdb"
+	"io"
+	"time"
 )
 
 type KeyValueConfig struct {
-	Badger nullable.Nullable[badger.Config] `yaml:"badger"`
-	File   nullable.Nullable[file.Config]   `yaml:"file"`
+	AzureBlob    nullable.Nullable[azureblob.Config]    `yaml:"azure_blob"`
+	Badger       nullable.Nullable[badger.Config]       `yaml:"badger"`
+	File         nullable.Nullable[file.Config]         `yaml:"file"`
+	Firestore    nullable.Nullable[firestore.Config]    `yaml:"firestore"`
+	Keyring      nullable.Nullable[keyring.Config]      `yaml:"keyring"`
+	LocalStorage nullable.Nullable[localstorage.Config] `yaml:"local_storage"`
+	Memcached    nullable.Nullable[memcached.Config]    `yaml:"memcached"`
+	MySQL        nullable.Nullable[mysql.Config]        `yaml:"mysql"`
+	RocksDB      nullable.Nullable[rocksdb.Config]      `yaml:"rocksdb"`
+
+	Lazy   nullable.Nullable[LazyConfig]     `yaml:"lazy"`
+	Backup nullable.Nullable[BackupSchedule] `yaml:"backup"`
 }
 
+type ProviderStats = kv.ProviderStats
+
 type KeyValueProvider[K ~string | ~uint64, V any] interface {
 	Setup() error
 	Shutdown() error
+	Close() error
+	Ping(ctx context.Context) error
+	Stats() (ProviderStats, error)
+	ApproximateSize() (uint64, error)
 
 	Store(key K, value V) error
+	Update(key K, fn func(value V, exists bool) (V, error)) error
 	Get(key K) (V, error)
 	Remove(key K) error
+	RemovePrefix(prefix K) (int, error)
+	RemoveWhere(pred func(key K, value V) bool) (int, error)
+	Clear() error
 	ForEach(fn func(key K, value V) bool) error
+	ForEachPrefix(prefix K, fn func(key K, value V) bool) error
+	ForEachKey(fn func(key K) bool) error
 	GetMultiple(keys []K) ([]V, error)
 
 	StoreReference(reference K, key K) error
+	AddReference(reference K, key K) error
 	RemoveReference(reference K) error
+	RemoveReferenceTarget(reference K, key K) error
 	GetByReference(reference K) (V, error)
+	GetAllByReference(reference K) ([]V, error)
+	ForEachReference(fn func(reference K, key K) bool) error
+
+	Verify() error
+	Backup(w io.Writer) error
+}
+
+type Watcher[K ~string | ~uint64, V any] interface {
+	Watch(ctx context.Context, prefix K, fn func(key K, value V, removed bool)) error
+}
+
+// Expirer is implemented by providers that can expire entries on their own.
+type Expirer[K ~string | ~uint64, V any] interface {
+	StoreWithTTL(key K, value V, ttl time.Duration) error
+}
+
+type Reencoder interface {
+	ReencodeAll() (int, error)
+}
+
+type KeyMigrator interface {
+	MigrateKeys() (int, error)
+}
+
+type Restorer interface {
+	Restore(r io.Reader) error
+}
+
+// IntoGetter is implemented by providers that can decode values into memory
+// of the caller.
+type IntoGetter[K ~string | ~uint64, V any] interface {
+	GetInto(key K, dst *V) error
+}
+
+type ReferenceStorer[K ~string | ~uint64, V any] interface {
+	StoreWithReferences(key K, value V, references ...K) error
+}
+
+type ReferenceRemover[K ~string | ~uint64] interface {
+	RemoveWithReferences(keys []K, references []K) error
+}
+
+// ReferenceResolver is implemented by providers that can look up the keys a
+// reference points to without scanning every reference.
+type ReferenceResolver[K ~string | ~uint64] interface {
+	ResolveReference(reference K) ([]K, error)
+}
+
+type ConditionalStorer[K ~string | ~uint64, V any] interface {
+	StoreIfAbsent(key K, value V) error
+	StoreIfPresent(key K, value V) error
+}
+
+// ExpirySweeper is implemented by providers that remove expired entries in
+// the background and can report them.
+type ExpirySweeper[K ~string | ~uint64, V any] interface {
+	OnExpire(fn func(key K, value V)) error
+	SweepExpired() (int, error)
+}
+
+// Preloader is implemented by providers with caches that can be warmed up
+// before traffic arrives, e.g. right after a deploy.
+type Preloader[K ~string | ~uint64] interface {
+	Preload(keys []K) (int, error)
+	PreloadPrefix(prefix K) (int, error)
+}
+
+// Flusher is implemented by providers that hold writes back, e.g. to
+// commit them in groups.
+type Flusher interface {
+	Flush() error
+}
+
+type Maintainer interface {
+	Maintain(ctx context.Context) error
+}
+
+// ReencodeAll rewrites the values the provider stored with another codec than
+// the configured one and returns how many values were rewritten.
+func ReencodeAll[K ~string | ~uint64, V any](provider KeyValueProvider[K, V]) (int, error) {
+	r, ok := provider.(Reencoder)
+	if !ok {
+		return 0, storageErrors.NewUnsupported(fmt.Errorf("%T does not support re-encoding", provider))
+	}
+
+	return r.ReencodeAll()
+}
+
+// MigrateKeys rewrites the keys the provider stored with another key encoding
+// than the configured one and returns how many entries were rewritten.
+func MigrateKeys[K ~string | ~uint64, V any](provider KeyValueProvider[K, V]) (int, error) {
+	m, ok := provider.(KeyMigrator)
+	if !ok {
+		return 0, storageErrors.NewUnsupported(fmt.Errorf("%T does not support key migration", provider))
+	}
+
+	return m.MigrateKeys()
+}
+
+// Restore loads a backup that was written by the provider's Backup.
+func Restore[K ~string | ~uint64, V any](provider KeyValueProvider[K, V], r io.Reader) error {
+	restorer, ok := provider.(Restorer)
+	if !ok {
+		return storageErrors.NewUnsupported(fmt.Errorf("%T does not support restoring backups", provider))
+	}
+
+	return restorer.Restore(r)
 }
 
 func GetKeyValueProviderFromConfig[K ~string | ~uint64, V any](keyValueConfig KeyValueConfig) (KeyValueProvider[K, V], error) {
+	if err := keyValueConfig.Validate(); err != nil {
+		return nil, err
+	}
+
+	provider, err := newKeyValueProvider[K, V](keyValueConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if keyValueConfig.Lazy.HasValue() {
+		provider = newLazyProvider(provider, keyValueConfig.Lazy.GetValue())
+	}
+
+	if keyValueConfig.Backup.HasValue() {
+		provider, err = newScheduledProvider(provider, keyValueConfig.Backup.GetValue())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return provider, nil
+}
+
+func newKeyValueProvider[K ~string | ~uint64, V any](keyValueConfig KeyValueConfig) (KeyValueProvider[K, V], error) {
 	switch true {
+	case keyValueConfig.AzureBlob.HasValue():
+		return newAzureBlobProvider[K, V](keyValueConfig.AzureBlob.GetValue())
+
 	case keyValueConfig.Badger.HasValue():
-		return badger.New[K, V](keyValueConfig.Badger.GetValue())
+		return newBadgerProvider[K, V](keyValueConfig.Badger.GetValue())
 
 	case keyValueConfig.File.HasValue():
 		return file.New[K, V](keyValueConfig.File.GetValue())
 
+	case keyValueConfig.Firestore.HasValue():
+		return newFirestoreProvider[K, V](keyValueConfig.Firestore.GetValue())
+
+	case keyValueConfig.Keyring.HasValue():
+		return newKeyringProvider[K, V](keyValueConfig.Keyring.GetValue())
+
+	case keyValueConfig.LocalStorage.HasValue():
+		return newLocalStorageProvider[K, V](keyValueConfig.LocalStorage.GetValue())
+
+	case keyValueConfig.Memcached.HasValue():
+		return newMemcachedProvider[K, V](keyValueConfig.Memcached.GetValue())
+
+	case keyValueConfig.MySQL.HasValue():
+		return newMySQLProvider[K, V](keyValueConfig.MySQL.GetValue())
+
+	case keyValueConfig.RocksDB.HasValue():
+		return newRocksDBProvider[K, V](keyValueConfig.RocksDB.GetValue())
+
 	default:
 		return nil, errors.New("storage provider is not configured")
 	}
 }
+
+// StoreWithReferences stores the value and points every reference to key.
+// Badger, RocksDB, MySQL and file providers apply all of it atomically; other
+// providers fall back to Store followed by StoreReference for each reference.
+func StoreWithReferences[K ~string | ~uint64, V any](provider KeyValueProvider[K, V], key K, value V, references ...K) error {
+	if s, ok := provider.(ReferenceStorer[K, V]); ok {
+		return s.StoreWithReferences(key, value, references...)
+	}
+
+	if err := provider.Store(key, value); err != nil {
+		return err
+	}
+	for _, reference := range references {
+		if err := provider.StoreReference(reference, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RemoveWithReferences removes keys and drops them from the targets of
+// references; references left without targets are removed. Missing keys and
+// targets are ignored. Badger applies all of it atomically; other providers
+// fall back to Remove and RemoveReferenceTarget.
+func RemoveWithReferences[K ~string | ~uint64, V any](provider KeyValueProvider[K, V], keys []K, references []K) error {
+	if r, ok := provider.(ReferenceRemover[K]); ok {
+		return r.RemoveWithReferences(keys, references)
+	}
+
+	for _, key := range keys {
+		if err := provider.Remove(key); err != nil && !storageErrors.Is(err, storageErrors.NotFound) {
+			return err
+		}
+	}
+	for _, reference := range references {
+		for _, key := range keys {
+			err := provider.RemoveReferenceTarget(reference, key)
+			if storageErrors.Is(err, storageErrors.Unsupported) {
+				err = provider.RemoveReference(reference)
+			}
+			if err != nil && !storageErrors.Is(err, storageErrors.NotFound) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ResolveReference returns the keys reference points to, following
+// references to references, in the order GetAllByReference reads them.
+// Providers without ResolveReference fall back to ForEachReference.
+func ResolveReference[K ~string | ~uint64, V any](provider KeyValueProvider[K, V], reference K) ([]K, error) {
+	if r, ok := provider.(ReferenceResolver[K]); ok {
+		return r.ResolveReference(reference)
+	}
+
+	references := map[K][]K{}
+	err := provider.ForEachReference(func(reference K, key K) bool {
+		references[reference] = append(references[reference], key)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := references[reference]; !ok {
+		return nil, storageErrors.NotFound
+	}
+
+	return resolveReference(references, reference, 0, map[K]bool{})
+}
+
+// maxReferenceDepth is the depth the providers allow by default.
+const maxReferenceDepth = 8
+
+func resolveReference[K ~string | ~uint64](references map[K][]K, reference K, depth int, path map[K]bool) ([]K, error) {
+	if path[reference] {
+		return nil, storageErrors.NewReferenceCycle(fmt.Errorf("reference %v", reference))
+	}
+	if depth >= maxReferenceDepth {
+		return nil, storageErrors.NewReferenceTooDeep(fmt.Errorf("reference %v exceeds depth %d", reference, maxReferenceDepth))
+	}
+	path[reference] = true
+	defer delete(path, reference)
+
+	var keys []K
+	for _, key := range references[reference] {
+		if _, chained := references[key]; !chained {
+			keys = append(keys, key)
+			continue
+		}
+
+		resolved, err := resolveReference(references, key, depth+1, path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, resolved...)
+	}
+
+	return keys, nil
+}
+
+// GetInto decodes the value of key into dst, saving the copy Get makes.
+// Providers without GetInto fall back to Get; dst is only set on success.
+func GetInto[K ~string | ~uint64, V any](provider KeyValueProvider[K, V], key K, dst *V) error {
+	if g, ok := provider.(IntoGetter[K, V]); ok {
+		return g.GetInto(key, dst)
+	}
+
+	value, err := provider.Get(key)
+	if err != nil {
+		return err
+	}
+	*dst = value
+
+	return nil
+}
+
+// StoreIfAbsent stores the value only if key does not exist yet, otherwise it
+// returns errors.AlreadyExists.
+func StoreIfAbsent[K ~string | ~uint64, V any](provider KeyValueProvider[K, V], key K, value V) error {
+	if s, ok := provider.(ConditionalStorer[K, V]); ok {
+		return s.StoreIfAbsent(key, value)
+	}
+
+	return provider.Update(key, func(current V, exists bool) (V, error) {
+		if exists {
+			return current, storageErrors.NewAlreadyExists(fmt.Errorf("key %v", key))
+		}
+		return value, nil
+	})
+}
+
+// StoreIfPresent replaces the value only if key exists, otherwise it returns
+// errors.NotFound.
+func StoreIfPresent[K ~string | ~uint64, V any](provider KeyValueProvider[K, V], key K, value V) error {
+	if s, ok := provider.(ConditionalStorer[K, V]); ok {
+		return s.StoreIfPresent(key, value)
+	}
+
+	return provider.Update(key, func(current V, exists bool) (V, error) {
+		if !exists {
+			return current, storageErrors.NewNotFound(fmt.Errorf("key %v", key))
+		}
+		return value, nil
+	})
+}
+
+// Maintain compacts the provider's storage and reclaims the space of deleted
+// and overwritten values.
+func Maintain[K ~string | ~uint64, V any](ctx context.Context, provider KeyValueProvider[K, V]) error {
+	m, ok := provider.(Maintainer)
+	if !ok {
+		return storageErrors.NewUnsupported(fmt.Errorf("%T does not support maintenance", provider))
+	}
+
+	return m.Maintain(ctx)
+}
+
+// Flush commits the writes the provider holds back and syncs them to disk,
+// returning the errors of held back writes. Providers without Flush write
+// through, so there is nothing to flush.
+func Flush[K ~string | ~uint64, V any](provider KeyValueProvider[K, V]) error {
+	if f, ok := provider.(Flusher); ok {
+		return f.Flush()
+	}
+
+	return nil
+}
+
+// OnExpire registers fn to be called for every expired entry the provider
+// removes.
+func OnExpire[K ~string | ~uint64, V any](provider KeyValueProvider[K, V], fn func(key K, value V)) error {
+	s, ok := provider.(ExpirySweeper[K, V])
+	if !ok {
+		return storageErrors.NewUnsupported(fmt.Errorf("%T does not support expiration callbacks", provider))
+	}
+
+	return s.OnExpire(fn)
+}
+
+// SweepExpired removes the expired entries now and returns how many were
+// removed.
+func SweepExpired[K ~string | ~uint64, V any](provider KeyValueProvider[K, V]) (int, error) {
+	s, ok := provider.(ExpirySweeper[K, V])
+	if !ok {
+		return 0, storageErrors.NewUnsupported(fmt.Errorf("%T does not support expiration sweeps", provider))
+	}
+
+	return s.SweepExpired()
+}
+
+// Preload loads the entries of keys into the caches of the provider and
+// returns how many were loaded. Missing keys are skipped.
+func Preload[K ~string | ~uint64, V any](provider KeyValueProvider[K, V], keys []K) (int, error) {
+	p, ok := provider.(Preloader[K])
+	if !ok {
+		return 0, storageErrors.NewUnsupported(fmt.Errorf("%T does not support preloading", provider))
+	}
+
+	return p.Preload(keys)
+}
+
+// PreloadPrefix loads the entries whose keys start with prefix into the
+// caches of the provider and returns how many were loaded.
+func PreloadPrefix[K ~string | ~uint64, V any](provider KeyValueProvider[K, V], prefix K) (int, error) {
+	p, ok := provider.(Preloader[K])
+	if !ok {
+		return 0, storageErrors.NewUnsupported(fmt.Errorf("%T does not support preloading", provider))
+	}
+
+	return p.PreloadPrefix(prefix)
+}
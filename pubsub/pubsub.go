@@ -0,0 +1,182 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/kv"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Config struct {
+	Buffer int `yaml:"buffer,omitempty"`
+}
+
+type Bus[T any] struct {
+	provider storage.KeyValueProvider[string, T]
+	watcher  storage.Watcher[string, T]
+	cfg      Config
+
+	sequence atomic.Uint64
+	dropped  atomic.Uint64
+
+	mu          sync.RWMutex
+	subscribers map[string]map[chan T]struct{}
+}
+
+const defaultBuffer = 64
+
+func New[T any](provider storage.KeyValueProvider[string, T], cfg Config) *Bus[T] {
+	if cfg.Buffer <= 0 {
+		cfg.Buffer = defaultBuffer
+	}
+
+	b := &Bus[T]{
+		provider:    provider,
+		cfg:         cfg,
+		subscribers: map[string]map[chan T]struct{}{},
+	}
+	if watcher, ok := provider.(storage.Watcher[string, T]); ok {
+		b.watcher = watcher
+	}
+
+	return b
+}
+
+func (b *Bus[T]) Publish(topic string, message T) error {
+	if err := validateTopic(topic); err != nil {
+		return err
+	}
+
+	if b.watcher == nil {
+		b.broadcast(topic, message)
+		return nil
+	}
+
+	key := topicPrefix(topic) + strconv.FormatInt(time.Now().UnixNano(), 10) + "." + strconv.FormatUint(b.sequence.Add(1), 10)
+	if err := b.provider.Store(key, message); err != nil {
+		return err
+	}
+
+	return b.provider.Remove(key)
+}
+
+func (b *Bus[T]) Subscribe(ctx context.Context, topic string) (<-chan T, error) {
+	if err := validateTopic(topic); err != nil {
+		return nil, err
+	}
+
+	ch := make(chan T, b.cfg.Buffer)
+
+	if b.watcher == nil {
+		b.mu.Lock()
+		if b.subscribers[topic] == nil {
+			b.subscribers[topic] = map[chan T]struct{}{}
+		}
+		b.subscribers[topic][ch] = struct{}{}
+		b.mu.Unlock()
+
+		go func() {
+			<-ctx.Done()
+
+			b.mu.Lock()
+			delete(b.subscribers[topic], ch)
+			if len(b.subscribers[topic]) == 0 {
+				delete(b.subscribers, topic)
+			}
+			b.mu.Unlock()
+
+			close(ch)
+		}()
+
+		return ch, nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	prefix := topicPrefix(topic)
+	ready := make(chan struct{})
+	var once sync.Once
+
+	// Messages published before the watch is registered are missed, so
+	// Subscribe returns once the watcher reports it is ready.
+	ctx, cancel := context.WithCancel(ctx)
+	watchCtx := kv.WithWatchReady(ctx, func() {
+		once.Do(func() { close(ready) })
+	})
+	watched := make(chan error, 1)
+	go func() {
+		defer close(ch)
+		defer cancel()
+
+		watched <- b.watcher.Watch(watchCtx, prefix, func(key string, message T, removed bool) {
+			if removed {
+				return
+			}
+			b.deliver(ch, message)
+		})
+	}()
+
+	select {
+	case <-ready:
+		return ch, nil
+	case err := <-watched:
+		if err == nil {
+			err = errors.New("watch ended before it was ready")
+		}
+		return nil, err
+	case <-ctx.Done():
+		cancel()
+		return nil, ctx.Err()
+	}
+}
+
+func (b *Bus[T]) Dropped() uint64 {
+	return b.dropped.Load()
+}
+
+func (b *Bus[T]) broadcast(topic string, message T) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for ch := range b.subscribers[topic] {
+		b.deliver(ch, message)
+	}
+}
+
+func (b *Bus[T]) deliver(ch chan T, message T) {
+	select {
+	case ch <- message:
+	default:
+		b.dropped.Add(1)
+	}
+}
+
+func validateTopic(topic string) error {
+	if topic == "" {
+		return errors.New("topic is empty")
+	}
+	if strings.ContainsRune(topic, 0) {
+		return errors.New("topic must not contain NUL characters")
+	}
+
+	return nil
+}
+
+func topicPrefix(topic string) string {
+	return "pubsub/" + topic + "\x00"
+}
@@ -0,0 +1,169 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package pubsub
+
+import (
+	"context"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/rlshukhov/storage/file"
+	"github.com/rlshukhov/storage/kv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func providers(t *testing.T) map[string]storage.KeyValueProvider[string, int] {
+	result := map[string]storage.KeyValueProvider[string, int]{}
+	configs := map[string]storage.KeyValueConfig{
+		"badger": {Badger: nullable.FromValue(badger.Config{InMemory: true})},
+		"file":   {File: nullable.FromValue(file.Config{Path: filepath.Join(t.TempDir(), "pubsub.json")})},
+	}
+	for name, cfg := range configs {
+		p, err := storage.GetKeyValueProviderFromConfig[string, int](cfg)
+		require.NoError(t, err)
+		require.NoError(t, p.Setup())
+		t.Cleanup(func() {
+			require.NoError(t, p.Shutdown())
+		})
+		result[name] = p
+	}
+
+	return result
+}
+
+func receive(t *testing.T, ch <-chan int) int {
+	t.Helper()
+
+	select {
+	case message, ok := <-ch:
+		require.True(t, ok, "channel is closed")
+		return message
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+		return 0
+	}
+}
+
+func TestBus_Order(t *testing.T) {
+	for name, p := range providers(t) {
+		t.Run(name, func(t *testing.T) {
+			bus := New(p, Config{Buffer: 128})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			ch, err := bus.Subscribe(ctx, "orders")
+			require.NoError(t, err)
+			other, err := bus.Subscribe(ctx, "other")
+			require.NoError(t, err)
+
+			// Publish right after Subscribe: nothing may be missed.
+			for i := 0; i < 100; i++ {
+				require.NoError(t, bus.Publish("orders", i))
+			}
+			for i := 0; i < 100; i++ {
+				assert.Equal(t, i, receive(t, ch))
+			}
+
+			select {
+			case message := <-other:
+				t.Fatalf("message %d delivered to another topic", message)
+			case <-time.After(50 * time.Millisecond):
+			}
+			assert.Zero(t, bus.Dropped())
+		})
+	}
+}
+
+func TestBus_Unsubscribe(t *testing.T) {
+	for name, p := range providers(t) {
+		t.Run(name, func(t *testing.T) {
+			bus := New(p, Config{})
+			ctx, cancel := context.WithCancel(context.Background())
+
+			ch, err := bus.Subscribe(ctx, "orders")
+			require.NoError(t, err)
+			kept, err := bus.Subscribe(context.Background(), "orders")
+			require.NoError(t, err)
+
+			require.NoError(t, bus.Publish("orders", 1))
+			assert.Equal(t, 1, receive(t, ch))
+			assert.Equal(t, 1, receive(t, kept))
+
+			cancel()
+			assert.Eventually(t, func() bool {
+				select {
+				case _, ok := <-ch:
+					return !ok
+				default:
+					return false
+				}
+			}, 5*time.Second, time.Millisecond)
+
+			require.NoError(t, bus.Publish("orders", 2))
+			assert.Equal(t, 2, receive(t, kept))
+		})
+	}
+}
+
+func TestBus_Subscribe(t *testing.T) {
+	p := providers(t)["badger"]
+	bus := New(p, Config{})
+
+	_, err := bus.Subscribe(context.Background(), "")
+	assert.Error(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = bus.Subscribe(ctx, "orders")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// Subscribing writes nothing other watchers of the topics can see.
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	var mu sync.Mutex
+	var watched []string
+	ready := make(chan struct{})
+	go func() {
+		_ = p.(storage.Watcher[string, int]).Watch(kv.WithWatchReady(watchCtx, func() { close(ready) }), "pubsub/", func(key string, value int, removed bool) {
+			mu.Lock()
+			defer mu.Unlock()
+			watched = append(watched, key)
+		})
+	}()
+	<-ready
+
+	ch, err := bus.Subscribe(context.Background(), "orders")
+	require.NoError(t, err)
+	require.NoError(t, bus.Publish("orders", 1))
+	assert.Equal(t, 1, receive(t, ch))
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(watched) >= 2
+	}, time.Second, time.Millisecond)
+	mu.Lock()
+	require.Len(t, watched, 2, "only the message is stored and removed")
+	assert.Equal(t, watched[0], watched[1])
+	assert.True(t, strings.HasPrefix(watched[0], topicPrefix("orders")))
+	mu.Unlock()
+
+	var keys []string
+	require.NoError(t, p.ForEachKey(func(key string) bool {
+		keys = append(keys, key)
+		return true
+	}))
+	assert.Empty(t, keys, "published messages are removed")
+}
@@ -0,0 +1,209 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package queue
+
+import (
+	baseErrors "errors"
+	"fmt"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/errors"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type Config struct {
+	VisibilityTimeout time.Duration `yaml:"visibility_timeout"`
+	MaxAttempts       int           `yaml:"max_attempts,omitempty"`
+}
+
+type Message[T any] struct {
+	ID         string    `yaml:"id" json:"id"`
+	Payload    T         `yaml:"payload" json:"payload"`
+	Attempts   int       `yaml:"attempts" json:"attempts"`
+	EnqueuedAt time.Time `yaml:"enqueued_at" json:"enqueued_at"`
+	VisibleAt  time.Time `yaml:"visible_at" json:"visible_at"`
+}
+
+type Queue[T any] struct {
+	provider storage.KeyValueProvider[string, Message[T]]
+	name     string
+	cfg      Config
+	sequence atomic.Uint64
+}
+
+const scanBatch = 16
+
+var errClaimed = baseErrors.New("message already claimed")
+
+func New[T any](provider storage.KeyValueProvider[string, Message[T]], name string, cfg Config) (*Queue[T], error) {
+	if name == "" {
+		return nil, baseErrors.New("queue name is empty")
+	}
+	if strings.Contains(name, "/") {
+		return nil, baseErrors.New("queue name must not contain '/'")
+	}
+	if cfg.VisibilityTimeout <= 0 {
+		return nil, baseErrors.New("queue visibility timeout must be positive")
+	}
+
+	return &Queue[T]{
+		provider: provider,
+		name:     name,
+		cfg:      cfg,
+	}, nil
+}
+
+func (q *Queue[T]) Enqueue(payload T) (string, error) {
+	now := time.Now()
+	id := fmt.Sprintf("%020d%010d", now.UnixNano(), q.sequence.Add(1)%10000000000)
+
+	err := q.provider.Store(q.messageKey(id), Message[T]{
+		ID:         id,
+		Payload:    payload,
+		EnqueuedAt: now,
+		VisibleAt:  now,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (q *Queue[T]) Dequeue() (Message[T], error) {
+	for {
+		now := time.Now()
+
+		var candidates []string
+		err := q.provider.ForEachPrefix(q.messagePrefix(), func(key string, message Message[T]) bool {
+			if !message.VisibleAt.After(now) {
+				candidates = append(candidates, message.ID)
+			}
+			return len(candidates) < scanBatch
+		})
+		if err != nil {
+			return Message[T]{}, err
+		}
+
+		if len(candidates) == 0 {
+			return Message[T]{}, errors.NotFound
+		}
+
+		for _, id := range candidates {
+			message, err := q.claim(id, now)
+			if err == nil {
+				return message, nil
+			}
+			if !baseErrors.Is(err, errClaimed) && !errors.Is(err, errors.NotFound) {
+				return Message[T]{}, err
+			}
+		}
+	}
+}
+
+func (q *Queue[T]) Ack(id string) error {
+	return q.provider.Remove(q.messageKey(id))
+}
+
+func (q *Queue[T]) Nack(id string) error {
+	return q.provider.Update(q.messageKey(id), func(message Message[T], exists bool) (Message[T], error) {
+		if !exists {
+			return message, errors.NotFound
+		}
+
+		message.VisibleAt = time.Now()
+		return message, nil
+	})
+}
+
+func (q *Queue[T]) DeadLetters() ([]Message[T], error) {
+	var messages []Message[T]
+	err := q.provider.ForEachPrefix(q.deadPrefix(), func(key string, message Message[T]) bool {
+		messages = append(messages, message)
+		return true
+	})
+
+	return messages, err
+}
+
+func (q *Queue[T]) Redrive(id string) error {
+	message, err := q.provider.Get(q.deadKey(id))
+	if err != nil {
+		return err
+	}
+
+	message.Attempts = 0
+	message.VisibleAt = time.Now()
+	if err := q.provider.Store(q.messageKey(id), message); err != nil {
+		return err
+	}
+
+	return q.provider.Remove(q.deadKey(id))
+}
+
+func (q *Queue[T]) claim(id string, now time.Time) (Message[T], error) {
+	var claimed Message[T]
+	var dead bool
+
+	err := q.provider.Update(q.messageKey(id), func(message Message[T], exists bool) (Message[T], error) {
+		if !exists {
+			return message, errors.NotFound
+		}
+		if message.VisibleAt.After(now) {
+			return message, errClaimed
+		}
+
+		if q.cfg.MaxAttempts > 0 && message.Attempts >= q.cfg.MaxAttempts {
+			dead = true
+			claimed = message
+			return message, errClaimed
+		}
+
+		message.Attempts++
+		message.VisibleAt = now.Add(q.cfg.VisibilityTimeout)
+		claimed = message
+		return message, nil
+	})
+
+	if dead {
+		return Message[T]{}, q.bury(claimed)
+	}
+
+	return claimed, err
+}
+
+func (q *Queue[T]) bury(message Message[T]) error {
+	if err := q.provider.Store(q.deadKey(message.ID), message); err != nil {
+		return err
+	}
+
+	err := q.provider.Remove(q.messageKey(message.ID))
+	if err != nil && !errors.Is(err, errors.NotFound) {
+		return err
+	}
+
+	return errClaimed
+}
+
+func (q *Queue[T]) messagePrefix() string {
+	return "queue/" + q.name + "/messages/"
+}
+
+func (q *Queue[T]) messageKey(id string) string {
+	return q.messagePrefix() + id
+}
+
+func (q *Queue[T]) deadPrefix() string {
+	return "queue/" + q.name + "/dead/"
+}
+
+func (q *Queue[T]) deadKey(id string) string {
+	return q.deadPrefix() + id
+}
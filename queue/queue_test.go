@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package queue
+
+import (
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/rlshukhov/storage/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func newQueue(t *testing.T, cfg Config) *Queue[string] {
+	p, err := storage.GetKeyValueProviderFromConfig[string, Message[string]](storage.KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{
+			InMemory: true,
+		}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	t.Cleanup(func() {
+		require.NoError(t, p.Shutdown())
+	})
+
+	q, err := New[string](p, "jobs", cfg)
+	require.NoError(t, err)
+
+	return q
+}
+
+func TestQueue_EnqueueDequeueAck(t *testing.T) {
+	q := newQueue(t, Config{VisibilityTimeout: time.Minute})
+
+	for _, payload := range []string{"first", "second"} {
+		_, err := q.Enqueue(payload)
+		require.NoError(t, err)
+	}
+
+	message, err := q.Dequeue()
+	require.NoError(t, err)
+	assert.Equal(t, "first", message.Payload)
+	assert.Equal(t, 1, message.Attempts)
+
+	next, err := q.Dequeue()
+	require.NoError(t, err)
+	assert.Equal(t, "second", next.Payload)
+
+	_, err = q.Dequeue()
+	assert.True(t, errors.Is(err, errors.NotFound))
+
+	require.NoError(t, q.Ack(message.ID))
+	require.NoError(t, q.Nack(next.ID))
+
+	again, err := q.Dequeue()
+	require.NoError(t, err)
+	assert.Equal(t, next.ID, again.ID)
+	assert.Equal(t, 2, again.Attempts)
+}
+
+func TestQueue_DeadLetter(t *testing.T) {
+	q := newQueue(t, Config{VisibilityTimeout: 10 * time.Millisecond, MaxAttempts: 2})
+
+	id, err := q.Enqueue("poison")
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		message, err := q.Dequeue()
+		require.NoError(t, err)
+		assert.Equal(t, id, message.ID)
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	_, err = q.Dequeue()
+	assert.True(t, errors.Is(err, errors.NotFound))
+
+	dead, err := q.DeadLetters()
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	assert.Equal(t, id, dead[0].ID)
+
+	require.NoError(t, q.Redrive(id))
+	message, err := q.Dequeue()
+	require.NoError(t, err)
+	assert.Equal(t, "poison", message.Payload)
+	assert.Equal(t, 1, message.Attempts)
+}
@@ -0,0 +1,457 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package quota
+
+import (
+	"container/list"
+	baseErrors "errors"
+	"fmt"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/codec"
+	"github.com/rlshukhov/storage/errors"
+	"slices"
+	"strings"
+	"sync"
+)
+
+type Policy string
+
+const (
+	// Reject fails writes that would exceed the limits with
+	// errors.QuotaExceeded.
+	Reject Policy = "reject"
+	// EvictLRU removes the least recently read or written entries of the
+	// prefix until the write fits.
+	EvictLRU Policy = "lru"
+)
+
+// Rule limits the keys starting with Prefix. Zero limits are unlimited. A key
+// counts towards the rule with the longest matching prefix only.
+type Rule struct {
+	Prefix     string `yaml:"prefix"`
+	MaxEntries int    `yaml:"max_entries,omitempty"`
+	MaxBytes   int64  `yaml:"max_bytes,omitempty"`
+	Policy     Policy `yaml:"policy,omitempty"`
+}
+
+type Config struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+type Usage struct {
+	Entries int
+	Bytes   int64
+}
+
+type QuotaExceededError struct {
+	Prefix   string
+	Resource string
+	Limit    int64
+	Usage    int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("prefix %q would use %d %s, the limit is %d", e.Prefix, e.Usage, e.Resource, e.Limit)
+}
+
+func (e *QuotaExceededError) Is(target error) bool {
+	return target == errors.QuotaExceeded
+}
+
+type provider[V any] struct {
+	storage.KeyValueProvider[string, V]
+	codec codec.Codec
+	// buckets are sorted by descending prefix length, so the first match is
+	// the longest.
+	buckets []*bucket
+}
+
+type bucket struct {
+	rule Rule
+
+	mu     sync.Mutex
+	loaded bool
+	usage  Usage
+	// recent orders the keys of EvictLRU buckets, most recently used first.
+	recent  *list.List
+	entries map[string]*list.Element
+}
+
+// maxEvictionRounds bounds how often a write is retried after evicting, in
+// case other writers keep filling the freed space.
+const maxEvictionRounds = 3
+
+var errEvict = baseErrors.New("eviction required")
+
+// New enforces cfg.Rules on the writes made through the returned provider.
+// Usage is counted from inner on the first write under a prefix. Recency for
+// EvictLRU starts out in iteration order and is tracked in memory, so it
+// does not survive a restart.
+func New[V any](inner storage.KeyValueProvider[string, V], cfg Config) (*provider[V], error) {
+	if inner == nil {
+		return nil, baseErrors.New("inner provider is nil")
+	}
+
+	var errs []error
+	seen := map[string]bool{}
+	p := &provider[V]{
+		KeyValueProvider: inner,
+		codec:            codec.For[V](),
+	}
+	for _, rule := range cfg.Rules {
+		if seen[rule.Prefix] {
+			errs = append(errs, fmt.Errorf("prefix %q: duplicate rule", rule.Prefix))
+		}
+		seen[rule.Prefix] = true
+		if rule.MaxEntries < 0 || rule.MaxBytes < 0 {
+			errs = append(errs, fmt.Errorf("prefix %q: limits must not be negative", rule.Prefix))
+		}
+		if rule.Policy == "" {
+			rule.Policy = Reject
+		}
+		if rule.Policy != Reject && rule.Policy != EvictLRU {
+			errs = append(errs, fmt.Errorf("prefix %q: unknown policy %q", rule.Prefix, rule.Policy))
+		}
+
+		b := &bucket{rule: rule}
+		if rule.Policy == EvictLRU {
+			b.recent = list.New()
+			b.entries = map[string]*list.Element{}
+		}
+		p.buckets = append(p.buckets, b)
+	}
+	if err := baseErrors.Join(errs...); err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(p.buckets, func(a, b *bucket) int {
+		return len(b.rule.Prefix) - len(a.rule.Prefix)
+	})
+
+	return p, nil
+}
+
+// Usage returns the usage of the rule with prefix.
+func (p *provider[V]) Usage(prefix string) (Usage, error) {
+	for _, b := range p.buckets {
+		if b.rule.Prefix != prefix {
+			continue
+		}
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if err := p.load(b); err != nil {
+			return Usage{}, err
+		}
+		return b.usage, nil
+	}
+
+	return Usage{}, errors.NewNotFound(fmt.Errorf("no rule for prefix %q", prefix))
+}
+
+func (p *provider[V]) Get(key string) (V, error) {
+	value, err := p.KeyValueProvider.Get(key)
+	if err == nil {
+		p.touch(key)
+	}
+
+	return value, err
+}
+
+func (p *provider[V]) GetMultiple(keys []string) ([]V, error) {
+	values, err := p.KeyValueProvider.GetMultiple(keys)
+	if err == nil {
+		p.touch(keys...)
+	}
+
+	return values, err
+}
+
+func (p *provider[V]) Flush() error {
+	return storage.Flush(p.KeyValueProvider)
+}
+
+func (p *provider[V]) Store(key string, value V) error {
+	return p.Update(key, func(V, bool) (V, error) {
+		return value, nil
+	})
+}
+
+func (p *provider[V]) Update(key string, fn func(value V, exists bool) (V, error)) error {
+	b := p.bucket(key)
+	if b == nil {
+		return p.KeyValueProvider.Update(key, fn)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := p.load(b); err != nil {
+		return err
+	}
+
+	for round := 0; ; round++ {
+		var delta Usage
+		err := p.KeyValueProvider.Update(key, func(old V, exists bool) (V, error) {
+			value, err := fn(old, exists)
+			if err != nil {
+				return old, err
+			}
+
+			size, err := p.size(key, value)
+			if err != nil {
+				return old, err
+			}
+			delta = Usage{Entries: 1, Bytes: size}
+			if exists {
+				oldSize, err := p.size(key, old)
+				if err != nil {
+					return old, err
+				}
+				delta = Usage{Bytes: size - oldSize}
+			}
+
+			if err := b.check(delta); err != nil {
+				if b.rule.Policy == EvictLRU && round < maxEvictionRounds {
+					return old, errEvict
+				}
+				return old, err
+			}
+			return value, nil
+		})
+		if baseErrors.Is(err, errEvict) {
+			if err := p.evict(b, key, delta); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		b.usage.Entries += delta.Entries
+		b.usage.Bytes += delta.Bytes
+		if b.recent != nil {
+			b.use(key)
+		}
+		return nil
+	}
+}
+
+func (p *provider[V]) Remove(key string) error {
+	b := p.bucket(key)
+	if b == nil {
+		return p.KeyValueProvider.Remove(key)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := p.load(b); err != nil {
+		return err
+	}
+
+	return p.remove(b, key)
+}
+
+func (p *provider[V]) RemovePrefix(prefix string) (int, error) {
+	var removed int
+	err := p.recount(func() error {
+		var err error
+		removed, err = p.KeyValueProvider.RemovePrefix(prefix)
+		return err
+	})
+
+	return removed, err
+}
+
+func (p *provider[V]) RemoveWhere(pred func(key string, value V) bool) (int, error) {
+	var removed int
+	err := p.recount(func() error {
+		var err error
+		removed, err = p.KeyValueProvider.RemoveWhere(pred)
+		return err
+	})
+
+	return removed, err
+}
+
+func (p *provider[V]) Clear() error {
+	return p.recount(p.KeyValueProvider.Clear)
+}
+
+// bucket returns the bucket of the rule with the longest prefix of key.
+func (p *provider[V]) bucket(key string) *bucket {
+	for _, b := range p.buckets {
+		if strings.HasPrefix(key, b.rule.Prefix) {
+			return b
+		}
+	}
+
+	return nil
+}
+
+// load counts the entries of b unless they are counted already. b.mu must be
+// held.
+func (p *provider[V]) load(b *bucket) error {
+	if b.loaded {
+		return nil
+	}
+
+	var usage Usage
+	var keys []string
+	var sizeErr error
+	err := p.KeyValueProvider.ForEachPrefix(b.rule.Prefix, func(key string, value V) bool {
+		if p.bucket(key) != b {
+			return true
+		}
+
+		size, err := p.size(key, value)
+		if err != nil {
+			sizeErr = err
+			return false
+		}
+		usage.Entries++
+		usage.Bytes += size
+		keys = append(keys, key)
+		return true
+	})
+	if err = baseErrors.Join(err, sizeErr); err != nil {
+		return fmt.Errorf("count usage of prefix %q: %w", b.rule.Prefix, err)
+	}
+
+	b.usage = usage
+	if b.recent != nil {
+		b.recent.Init()
+		clear(b.entries)
+		for _, key := range keys {
+			b.entries[key] = b.recent.PushBack(key)
+		}
+	}
+	b.loaded = true
+	return nil
+}
+
+// evict removes least recently used entries of b other than key until delta
+// fits. b.mu must be held.
+func (p *provider[V]) evict(b *bucket, key string, delta Usage) error {
+	if err := (&bucket{rule: b.rule}).check(delta); err != nil {
+		return err
+	}
+
+	for b.check(delta) != nil {
+		oldest := b.recent.Back()
+		if oldest != nil && oldest.Value.(string) == key {
+			oldest = oldest.Prev()
+		}
+		if oldest == nil {
+			return b.check(delta)
+		}
+
+		if err := p.remove(b, oldest.Value.(string)); err != nil && !errors.Is(err, errors.NotFound) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// remove removes key and subtracts it from the usage of b. b.mu must be
+// held.
+func (p *provider[V]) remove(b *bucket, key string) error {
+	old, err := p.KeyValueProvider.Get(key)
+	if errors.Is(err, errors.NotFound) {
+		b.forget(key)
+		return p.KeyValueProvider.Remove(key)
+	} else if err != nil {
+		return err
+	}
+
+	size, err := p.size(key, old)
+	if err != nil {
+		return err
+	}
+	if err := p.KeyValueProvider.Remove(key); err != nil {
+		return err
+	}
+
+	b.usage.Entries--
+	b.usage.Bytes -= size
+	b.forget(key)
+	return nil
+}
+
+// recount runs fn with every bucket locked and counts them again on their
+// next use.
+func (p *provider[V]) recount(fn func() error) error {
+	for _, b := range p.buckets {
+		b.mu.Lock()
+	}
+	defer func() {
+		for _, b := range p.buckets {
+			b.loaded = false
+			b.mu.Unlock()
+		}
+	}()
+
+	return fn()
+}
+
+func (p *provider[V]) touch(keys ...string) {
+	for _, key := range keys {
+		b := p.bucket(key)
+		if b == nil || b.recent == nil {
+			continue
+		}
+
+		b.mu.Lock()
+		if e, ok := b.entries[key]; ok {
+			b.recent.MoveToFront(e)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// size is the length of the key plus the length of the value in the codec
+// the value type would be stored with.
+func (p *provider[V]) size(key string, value V) (int64, error) {
+	encoded, err := p.codec.Marshal(value)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(key) + len(encoded)), nil
+}
+
+func (b *bucket) check(delta Usage) error {
+	if delta.Entries > 0 && b.rule.MaxEntries > 0 && b.usage.Entries+delta.Entries > b.rule.MaxEntries {
+		return &QuotaExceededError{Prefix: b.rule.Prefix, Resource: "entries", Limit: int64(b.rule.MaxEntries), Usage: int64(b.usage.Entries + delta.Entries)}
+	}
+	if delta.Bytes > 0 && b.rule.MaxBytes > 0 && b.usage.Bytes+delta.Bytes > b.rule.MaxBytes {
+		return &QuotaExceededError{Prefix: b.rule.Prefix, Resource: "bytes", Limit: b.rule.MaxBytes, Usage: b.usage.Bytes + delta.Bytes}
+	}
+
+	return nil
+}
+
+func (b *bucket) use(key string) {
+	if e, ok := b.entries[key]; ok {
+		b.recent.MoveToFront(e)
+		return
+	}
+
+	b.entries[key] = b.recent.PushFront(key)
+}
+
+func (b *bucket) forget(key string) {
+	if b.recent == nil {
+		return
+	}
+	if e, ok := b.entries[key]; ok {
+		b.recent.Remove(e)
+		delete(b.entries, key)
+	}
+}
@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package quota
+
+import (
+	baseErrors "errors"
+	"fmt"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/rlshukhov/storage/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestProvider(t *testing.T) {
+	inner, err := storage.GetKeyValueProviderFromConfig[string, string](storage.KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, inner.Setup())
+	defer inner.Shutdown()
+	require.NoError(t, inner.Store("cache:old", "x"))
+
+	_, err = New(inner, Config{Rules: []Rule{{Prefix: "a", Policy: "fifo"}, {Prefix: "a", MaxEntries: -1}}})
+	assert.Error(t, err)
+
+	p, err := New(inner, Config{Rules: []Rule{
+		{Prefix: "cache:", MaxEntries: 3, Policy: EvictLRU},
+		{Prefix: "cache:big:", MaxBytes: 64, Policy: EvictLRU},
+		{Prefix: "session:", MaxEntries: 2},
+		{Prefix: "config:"},
+	}})
+	require.NoError(t, err)
+
+	require.NoError(t, p.Store("cache:a", "1"))
+	require.NoError(t, p.Store("cache:b", "2"))
+	_, err = p.Get("cache:old")
+	require.NoError(t, err)
+	require.NoError(t, p.Store("cache:c", "3"))
+	_, err = p.Get("cache:a")
+	assert.True(t, errors.Is(err, errors.NotFound))
+	require.NoError(t, p.Store("cache:b", "22"))
+	require.NoError(t, p.Store("cache:d", "4"))
+	_, err = p.Get("cache:old")
+	assert.True(t, errors.Is(err, errors.NotFound))
+	for _, key := range []string{"cache:b", "cache:c", "cache:d"} {
+		_, err := p.Get(key)
+		assert.NoError(t, err, key)
+	}
+	usage, err := p.Usage("cache:")
+	require.NoError(t, err)
+	assert.Equal(t, 3, usage.Entries)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, p.Store(fmt.Sprintf("cache:big:%d", i), strings.Repeat("v", 10)))
+	}
+	usage, err = p.Usage("cache:big:")
+	require.NoError(t, err)
+	assert.LessOrEqual(t, usage.Bytes, int64(64))
+	assert.Greater(t, usage.Entries, 1)
+	err = p.Store("cache:big:huge", strings.Repeat("v", 100))
+	assert.True(t, errors.Is(err, errors.QuotaExceeded))
+	after, err := p.Usage("cache:big:")
+	require.NoError(t, err)
+	assert.Equal(t, usage, after)
+
+	require.NoError(t, p.Store("session:1", "a"))
+	require.NoError(t, p.Store("session:2", "b"))
+	require.NoError(t, p.Store("session:2", "c"))
+	err = p.Store("session:3", "c")
+	var quotaErr *QuotaExceededError
+	require.True(t, baseErrors.As(err, &quotaErr))
+	assert.Equal(t, QuotaExceededError{Prefix: "session:", Resource: "entries", Limit: 2, Usage: 3}, *quotaErr)
+	require.NoError(t, p.Remove("session:1"))
+	require.NoError(t, p.Store("session:3", "c"))
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, p.Store(fmt.Sprintf("config:%d", i), "on"))
+		require.NoError(t, p.Store(fmt.Sprintf("other:%d", i), "on"))
+	}
+
+	removed, err := p.RemovePrefix("session:")
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	usage, err = p.Usage("session:")
+	require.NoError(t, err)
+	assert.Equal(t, Usage{}, usage)
+	_, err = p.Usage("missing:")
+	assert.True(t, errors.Is(err, errors.NotFound))
+}
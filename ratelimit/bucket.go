@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package ratelimit
+
+import (
+	"time"
+)
+
+type Limit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst,omitempty"`
+}
+
+func (l Limit) enabled() bool {
+	return l.Rate > 0
+}
+
+type bucket struct {
+	limit  Limit
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(limit Limit, now time.Time) *bucket {
+	if limit.Burst <= 0 {
+		limit.Burst = max(1, int(limit.Rate))
+	}
+
+	return &bucket{
+		limit:  limit,
+		tokens: float64(limit.Burst),
+		last:   now,
+	}
+}
+
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = min(float64(b.limit.Burst), b.tokens+elapsed*b.limit.Rate)
+		b.last = now
+	}
+}
+
+func (b *bucket) delay(now time.Time, n float64) time.Duration {
+	b.refill(now)
+	if b.tokens >= n {
+		return 0
+	}
+
+	return time.Duration((n - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+func (b *bucket) take(n float64) {
+	b.tokens -= n
+}
+
+func (b *bucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= float64(b.limit.Burst)
+}
@@ -0,0 +1,313 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package ratelimit
+
+import (
+	baseErrors "errors"
+	"fmt"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Config struct {
+	Read   Limit `yaml:"read"`
+	Write  Limit `yaml:"write"`
+	Scan   Limit `yaml:"scan"`
+	PerKey Limit `yaml:"per_key"`
+
+	MaxWait time.Duration `yaml:"max_wait,omitempty"`
+}
+
+type class string
+
+const (
+	read  class = "read"
+	write class = "write"
+	scan  class = "scan"
+)
+
+const pruneThreshold = 10000
+
+type provider[K ~string | ~uint64, V any] struct {
+	storage.KeyValueProvider[K, V]
+	cfg Config
+
+	mu      sync.Mutex
+	classes map[class]*bucket
+	keys    map[K]*bucket
+	limited atomic.Uint64
+}
+
+func New[K ~string | ~uint64, V any](inner storage.KeyValueProvider[K, V], cfg Config) (*provider[K, V], error) {
+	if inner == nil {
+		return nil, baseErrors.New("inner provider is nil")
+	}
+	for name, limit := range map[string]Limit{"read": cfg.Read, "write": cfg.Write, "scan": cfg.Scan, "per_key": cfg.PerKey} {
+		if limit.Rate < 0 || limit.Burst < 0 {
+			return nil, fmt.Errorf("%s limit must not be negative", name)
+		}
+	}
+	if cfg.MaxWait < 0 {
+		return nil, baseErrors.New("max_wait must not be negative")
+	}
+
+	now := time.Now()
+	p := &provider[K, V]{
+		KeyValueProvider: inner,
+		cfg:              cfg,
+		classes:          map[class]*bucket{},
+		keys:             map[K]*bucket{},
+	}
+	for c, limit := range map[class]Limit{read: cfg.Read, write: cfg.Write, scan: cfg.Scan} {
+		if limit.enabled() {
+			p.classes[c] = newBucket(limit, now)
+		}
+	}
+
+	return p, nil
+}
+
+func (p *provider[K, V]) Limited() uint64 {
+	return p.limited.Load()
+}
+
+func (p *provider[K, V]) Flush() error {
+	return storage.Flush(p.KeyValueProvider)
+}
+
+func (p *provider[K, V]) Store(key K, value V) error {
+	if err := p.wait(write, 1, key); err != nil {
+		return err
+	}
+
+	return p.KeyValueProvider.Store(key, value)
+}
+
+func (p *provider[K, V]) Update(key K, fn func(value V, exists bool) (V, error)) error {
+	if err := p.wait(write, 1, key); err != nil {
+		return err
+	}
+
+	return p.KeyValueProvider.Update(key, fn)
+}
+
+func (p *provider[K, V]) Get(key K) (V, error) {
+	if err := p.wait(read, 1, key); err != nil {
+		var v V
+		return v, err
+	}
+
+	return p.KeyValueProvider.Get(key)
+}
+
+func (p *provider[K, V]) GetMultiple(keys []K) ([]V, error) {
+	if err := p.wait(read, float64(max(1, len(keys))), keys...); err != nil {
+		return nil, err
+	}
+
+	return p.KeyValueProvider.GetMultiple(keys)
+}
+
+func (p *provider[K, V]) Remove(key K) error {
+	if err := p.wait(write, 1, key); err != nil {
+		return err
+	}
+
+	return p.KeyValueProvider.Remove(key)
+}
+
+func (p *provider[K, V]) RemovePrefix(prefix K) (int, error) {
+	if err := p.wait(write, 1); err != nil {
+		return 0, err
+	}
+
+	return p.KeyValueProvider.RemovePrefix(prefix)
+}
+
+func (p *provider[K, V]) RemoveWhere(pred func(key K, value V) bool) (int, error) {
+	if err := p.wait(scan, 1); err != nil {
+		return 0, err
+	}
+
+	return p.KeyValueProvider.RemoveWhere(pred)
+}
+
+func (p *provider[K, V]) Clear() error {
+	if err := p.wait(write, 1); err != nil {
+		return err
+	}
+
+	return p.KeyValueProvider.Clear()
+}
+
+func (p *provider[K, V]) ForEach(fn func(key K, value V) bool) error {
+	if err := p.wait(scan, 1); err != nil {
+		return err
+	}
+
+	return p.KeyValueProvider.ForEach(fn)
+}
+
+func (p *provider[K, V]) ForEachPrefix(prefix K, fn func(key K, value V) bool) error {
+	if err := p.wait(scan, 1); err != nil {
+		return err
+	}
+
+	return p.KeyValueProvider.ForEachPrefix(prefix, fn)
+}
+
+func (p *provider[K, V]) ForEachKey(fn func(key K) bool) error {
+	if err := p.wait(scan, 1); err != nil {
+		return err
+	}
+
+	return p.KeyValueProvider.ForEachKey(fn)
+}
+
+func (p *provider[K, V]) StoreReference(reference K, key K) error {
+	if err := p.wait(write, 1, reference); err != nil {
+		return err
+	}
+
+	return p.KeyValueProvider.StoreReference(reference, key)
+}
+
+func (p *provider[K, V]) AddReference(reference K, key K) error {
+	if err := p.wait(write, 1, reference); err != nil {
+		return err
+	}
+
+	return p.KeyValueProvider.AddReference(reference, key)
+}
+
+func (p *provider[K, V]) RemoveReference(reference K) error {
+	if err := p.wait(write, 1, reference); err != nil {
+		return err
+	}
+
+	return p.KeyValueProvider.RemoveReference(reference)
+}
+
+func (p *provider[K, V]) RemoveReferenceTarget(reference K, key K) error {
+	if err := p.wait(write, 1, reference); err != nil {
+		return err
+	}
+
+	return p.KeyValueProvider.RemoveReferenceTarget(reference, key)
+}
+
+func (p *provider[K, V]) GetByReference(reference K) (V, error) {
+	if err := p.wait(read, 1, reference); err != nil {
+		var v V
+		return v, err
+	}
+
+	return p.KeyValueProvider.GetByReference(reference)
+}
+
+func (p *provider[K, V]) GetAllByReference(reference K) ([]V, error) {
+	if err := p.wait(read, 1, reference); err != nil {
+		return nil, err
+	}
+
+	return p.KeyValueProvider.GetAllByReference(reference)
+}
+
+func (p *provider[K, V]) ForEachReference(fn func(reference K, key K) bool) error {
+	if err := p.wait(scan, 1); err != nil {
+		return err
+	}
+
+	return p.KeyValueProvider.ForEachReference(fn)
+}
+
+func (p *provider[K, V]) Verify() error {
+	if err := p.wait(scan, 1); err != nil {
+		return err
+	}
+
+	return p.KeyValueProvider.Verify()
+}
+
+func (p *provider[K, V]) Backup(w io.Writer) error {
+	if err := p.wait(scan, 1); err != nil {
+		return err
+	}
+
+	return p.KeyValueProvider.Backup(w)
+}
+
+func (p *provider[K, V]) wait(c class, n float64, keys ...K) error {
+	delay, err := p.reserve(c, n, keys)
+	if err != nil {
+		return err
+	}
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+
+	return nil
+}
+
+func (p *provider[K, V]) reserve(c class, n float64, keys []K) (time.Duration, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+
+	var delay time.Duration
+	classBucket := p.classes[c]
+	if classBucket != nil {
+		delay = classBucket.delay(now, n)
+	}
+
+	var keyBuckets []*bucket
+	if p.cfg.PerKey.enabled() {
+		p.prune(now)
+		for _, key := range keys {
+			b, ok := p.keys[key]
+			if !ok {
+				b = newBucket(p.cfg.PerKey, now)
+				p.keys[key] = b
+			}
+			keyBuckets = append(keyBuckets, b)
+			delay = max(delay, b.delay(now, 1))
+		}
+	}
+
+	if delay > p.cfg.MaxWait {
+		p.limited.Add(1)
+		return 0, errors.NewRateLimited(fmt.Errorf("%s operation would wait %s", c, delay))
+	}
+
+	if classBucket != nil {
+		classBucket.take(n)
+	}
+	for _, b := range keyBuckets {
+		b.take(1)
+	}
+
+	return delay, nil
+}
+
+func (p *provider[K, V]) prune(now time.Time) {
+	if len(p.keys) < pruneThreshold {
+		return
+	}
+
+	for key, b := range p.keys {
+		if b.full(now) {
+			delete(p.keys, key)
+		}
+	}
+}
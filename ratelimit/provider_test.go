@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package ratelimit
+
+import (
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/rlshukhov/storage/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func newProvider(t *testing.T, cfg Config) *provider[string, string] {
+	inner, err := storage.GetKeyValueProviderFromConfig[string, string](storage.KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true}),
+	})
+	require.NoError(t, err)
+	p, err := New(inner, cfg)
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	t.Cleanup(func() {
+		require.NoError(t, p.Shutdown())
+	})
+
+	return p
+}
+
+func TestBucket_Refill(t *testing.T) {
+	now := time.Now()
+	b := newBucket(Limit{Rate: 10, Burst: 2}, now)
+	assert.True(t, b.full(now))
+
+	assert.Zero(t, b.delay(now, 2))
+	b.take(2)
+	assert.Equal(t, 100*time.Millisecond, b.delay(now, 1))
+	assert.Equal(t, 200*time.Millisecond, b.delay(now, 2))
+
+	now = now.Add(50 * time.Millisecond)
+	assert.Equal(t, 50*time.Millisecond, b.delay(now, 1))
+	now = now.Add(50 * time.Millisecond)
+	assert.Zero(t, b.delay(now, 1))
+	assert.False(t, b.full(now))
+
+	now = now.Add(time.Hour)
+	assert.True(t, b.full(now))
+	assert.Zero(t, b.delay(now, 2))
+	assert.NotZero(t, b.delay(now, 3), "tokens never exceed the burst")
+
+	assert.Equal(t, 5, newBucket(Limit{Rate: 5}, now).limit.Burst)
+	assert.Equal(t, 1, newBucket(Limit{Rate: 0.5}, now).limit.Burst)
+}
+
+func TestProvider_Limit(t *testing.T) {
+	p := newProvider(t, Config{
+		Write: Limit{Rate: 1, Burst: 2},
+		Read:  Limit{Rate: 1, Burst: 1},
+	})
+
+	require.NoError(t, p.Store("a", "1"))
+	require.NoError(t, p.Store("b", "2"))
+	err := p.Store("c", "3")
+	assert.True(t, errors.Is(err, errors.RateLimited))
+	assert.Equal(t, uint64(1), p.Limited())
+
+	val, err := p.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, "1", val)
+	_, err = p.Get("b")
+	assert.True(t, errors.Is(err, errors.RateLimited))
+	assert.Equal(t, uint64(2), p.Limited())
+
+	require.NoError(t, p.ForEachKey(func(key string) bool { return true }), "scans are not limited")
+	_, err = p.Get("c")
+	assert.True(t, errors.Is(err, errors.RateLimited), "rejected writes do not reach the provider")
+}
+
+func TestProvider_PerKey(t *testing.T) {
+	p := newProvider(t, Config{PerKey: Limit{Rate: 1, Burst: 1}})
+
+	require.NoError(t, p.Store("a", "1"))
+	assert.True(t, errors.Is(p.Store("a", "2"), errors.RateLimited))
+	require.NoError(t, p.Store("b", "1"))
+	_, err := p.GetMultiple([]string{"a", "c"})
+	assert.True(t, errors.Is(err, errors.RateLimited))
+	_, err = p.Get("c")
+	require.True(t, errors.Is(err, errors.NotFound), "rejected batches take no tokens")
+}
+
+func TestProvider_Refill(t *testing.T) {
+	p := newProvider(t, Config{
+		Write:   Limit{Rate: 20, Burst: 1},
+		MaxWait: time.Second,
+	})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, p.Store("key", "value"))
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond, "writes wait for refilled tokens")
+	assert.Zero(t, p.Limited())
+
+	p = newProvider(t, Config{Write: Limit{Rate: 20, Burst: 1}})
+	require.NoError(t, p.Store("key", "value"))
+	assert.True(t, errors.Is(p.Store("key", "value"), errors.RateLimited))
+	time.Sleep(60 * time.Millisecond)
+	require.NoError(t, p.Store("key", "value"))
+}
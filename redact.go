@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"log/slog"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Redactable is implemented by values that hold personal data. Redact
+// returns a copy that tools may show: exports made with ExportRedacted, the
+// admin UI and logs written through Redacted. It must not modify the value
+// it is called on.
+type Redactable[V any] interface {
+	Redact() V
+}
+
+// RedactedText replaces string fields tagged `storage:"redact"`.
+const RedactedText = "[REDACTED]"
+
+const redactTag = "redact"
+
+// Redact returns value as tools may show it. When V implements Redactable,
+// its Redact is applied; then every field tagged `storage:"redact"`,
+// including fields of nested structs, struct pointers and slices of them,
+// is replaced with RedactedText if it is a string and with its zero value
+// otherwise. The stored value is not changed.
+func Redact[V any](value V) V {
+	if r, ok := any(value).(Redactable[V]); ok {
+		value = r.Redact()
+	}
+
+	v := reflect.ValueOf(&value).Elem()
+	if hasRedactedFields(v.Type(), nil) {
+		redactValue(v)
+	}
+
+	return value
+}
+
+// Redacted returns a slog.LogValuer that logs value after Redact, e.g.
+// slog.Any("user", storage.Redacted(user)).
+func Redacted[V any](value V) slog.LogValuer {
+	return redacted[V]{value: value}
+}
+
+type redacted[V any] struct {
+	value V
+}
+
+func (r redacted[V]) LogValue() slog.Value {
+	return slog.AnyValue(Redact(r.value))
+}
+
+// redactValue masks the tagged fields in v, which must be settable. Pointers
+// and slices on the way are replaced with copies.
+func redactValue(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() || !hasRedactedFields(v.Type(), nil) {
+			return
+		}
+		copied := reflect.New(v.Type().Elem())
+		copied.Elem().Set(v.Elem())
+		v.Set(copied)
+		redactValue(copied.Elem())
+
+	case reflect.Slice:
+		if v.IsNil() || !hasRedactedFields(v.Type(), nil) {
+			return
+		}
+		copied := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(copied, v)
+		v.Set(copied)
+		for i := range copied.Len() {
+			redactValue(copied.Index(i))
+		}
+
+	case reflect.Array:
+		for i := range v.Len() {
+			redactValue(v.Index(i))
+		}
+
+	case reflect.Struct:
+		for i := range v.NumField() {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			if !redactTagged(field) {
+				redactValue(v.Field(i))
+				continue
+			}
+
+			if field.Type.Kind() == reflect.String {
+				v.Field(i).SetString(RedactedText)
+			} else {
+				v.Field(i).SetZero()
+			}
+		}
+	}
+}
+
+// hasRedactedFields reports whether t holds a tagged field.
+func hasRedactedFields(t reflect.Type, visiting []reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array:
+		return hasRedactedFields(t.Elem(), visiting)
+	case reflect.Struct:
+	default:
+		return false
+	}
+	if slices.Contains(visiting, t) {
+		return false
+	}
+	visiting = append(visiting, t)
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if field.IsExported() && (redactTagged(field) || hasRedactedFields(field.Type, visiting)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func redactTagged(field reflect.StructField) bool {
+	return slices.Contains(strings.Split(field.Tag.Get("storage"), ","), redactTag)
+}
@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"errors"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"slices"
+)
+
+type RepairMode int
+
+const (
+	RepairNone RepairMode = iota
+	// RepairDangling removes the targets of references that point to missing
+	// keys. Values are never removed.
+	RepairDangling
+)
+
+type ReferenceReport[K ~string | ~uint64] struct {
+	References int
+	Keys       int
+	Dangling   map[K][]K
+	// Unreferenced are the keys no reference points to. Most stores have
+	// plenty of them, so they are reported but not an inconsistency.
+	Unreferenced []K
+}
+
+func (r ReferenceReport[K]) Consistent() bool {
+	return len(r.Dangling) == 0
+}
+
+// VerifyReferences reports references whose targets are missing and, with
+// RepairDangling, removes those targets.
+func VerifyReferences[K ~string | ~uint64, V any](provider KeyValueProvider[K, V], mode RepairMode) (ReferenceReport[K], error) {
+	report := ReferenceReport[K]{Dangling: map[K][]K{}}
+
+	keys := map[K]bool{}
+	err := provider.ForEachKey(func(key K) bool {
+		keys[key] = false
+		return true
+	})
+	if err != nil {
+		return report, err
+	}
+	report.Keys = len(keys)
+
+	references := map[K][]K{}
+	err = provider.ForEachReference(func(reference K, key K) bool {
+		report.References++
+		references[reference] = append(references[reference], key)
+		return true
+	})
+	if err != nil {
+		return report, err
+	}
+
+	for reference, targets := range references {
+		for _, key := range targets {
+			if _, ok := keys[key]; ok {
+				keys[key] = true
+			} else if _, chained := references[key]; !chained {
+				report.Dangling[reference] = append(report.Dangling[reference], key)
+			}
+		}
+	}
+
+	for key, referenced := range keys {
+		if !referenced {
+			report.Unreferenced = append(report.Unreferenced, key)
+		}
+	}
+	slices.Sort(report.Unreferenced)
+
+	var errs []error
+	if mode >= RepairDangling {
+		for reference, keys := range report.Dangling {
+			for _, key := range keys {
+				errs = append(errs, ignoreNotFound(provider.RemoveReferenceTarget(reference, key)))
+			}
+		}
+	}
+
+	return report, errors.Join(errs...)
+}
+
+func ignoreNotFound(err error) error {
+	if storageErrors.Is(err, storageErrors.NotFound) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,472 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package replication
+
+import (
+	"context"
+	baseErrors "errors"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Config struct {
+	QueueSize     int           `yaml:"queue_size,omitempty"`
+	RetryInterval time.Duration `yaml:"retry_interval,omitempty"`
+	MaxRetries    int           `yaml:"max_retries,omitempty"`
+	ReadFallback  bool          `yaml:"read_fallback,omitempty"`
+
+	OnError func(mirror int, err error) `yaml:"-"`
+}
+
+const (
+	defaultQueueSize     = 1024
+	defaultRetryInterval = time.Second
+)
+
+// ErrQueueFull is reported to OnError for every write dropped because the
+// queue of a mirror was full.
+var ErrQueueFull = errors.NewUnavailable(baseErrors.New("replication queue is full"))
+
+type mirror[K ~string | ~uint64, V any] struct {
+	provider storage.KeyValueProvider[K, V]
+	queue    chan func(storage.KeyValueProvider[K, V]) error
+	ready    atomic.Bool
+	dropped  atomic.Uint64
+}
+
+type provider[K ~string | ~uint64, V any] struct {
+	storage.KeyValueProvider[K, V]
+	mirrors []*mirror[K, V]
+	cfg     Config
+
+	// writes orders primary writes with their enqueue, so every mirror
+	// applies writes to the same key in the order the primary committed them.
+	writes  sync.Mutex
+	workers sync.WaitGroup
+	mu      sync.RWMutex
+	running bool
+	stop    chan struct{}
+
+	pendingMu sync.Mutex
+	pending   int
+	idle      chan struct{}
+}
+
+func New[K ~string | ~uint64, V any](primary storage.KeyValueProvider[K, V], mirrors []storage.KeyValueProvider[K, V], cfg Config) (*provider[K, V], error) {
+	if primary == nil {
+		return nil, baseErrors.New("primary provider is nil")
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultQueueSize
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = defaultRetryInterval
+	}
+
+	p := &provider[K, V]{
+		KeyValueProvider: primary,
+		cfg:              cfg,
+		idle:             make(chan struct{}),
+	}
+	close(p.idle)
+	for _, m := range mirrors {
+		if m == nil {
+			return nil, baseErrors.New("mirror provider is nil")
+		}
+		p.mirrors = append(p.mirrors, &mirror[K, V]{provider: m})
+	}
+
+	return p, nil
+}
+
+// Setup sets up the primary and the mirrors. A mirror that fails to set up
+// does not fail Setup: it is reported to OnError, marked degraded and set up
+// again every RetryInterval in the background.
+func (p *provider[K, V]) Setup() error {
+	if err := p.KeyValueProvider.Setup(); err != nil {
+		return err
+	}
+
+	for i, m := range p.mirrors {
+		if err := m.provider.Setup(); err != nil {
+			p.report(i, err)
+			continue
+		}
+		m.ready.Store(true)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.running {
+		return nil
+	}
+
+	p.running = true
+	p.stop = make(chan struct{})
+	for i, m := range p.mirrors {
+		m.queue = make(chan func(storage.KeyValueProvider[K, V]) error, p.cfg.QueueSize)
+		p.workers.Add(1)
+		go p.replicate(i, m)
+	}
+
+	return nil
+}
+
+func (p *provider[K, V]) Shutdown() error {
+	p.mu.Lock()
+	if p.running {
+		p.running = false
+		close(p.stop)
+		for _, m := range p.mirrors {
+			close(m.queue)
+		}
+	}
+	p.mu.Unlock()
+
+	p.workers.Wait()
+
+	var errs []error
+	errs = append(errs, p.KeyValueProvider.Shutdown())
+	for _, m := range p.mirrors {
+		if m.ready.Swap(false) {
+			errs = append(errs, m.provider.Shutdown())
+		}
+	}
+
+	return baseErrors.Join(errs...)
+}
+
+func (p *provider[K, V]) Close() error {
+	return p.Shutdown()
+}
+
+// Dropped returns how many writes were dropped for the mirror with the given
+// index because its queue was full.
+func (p *provider[K, V]) Dropped(mirror int) uint64 {
+	return p.mirrors[mirror].dropped.Load()
+}
+
+// Degraded returns the indexes of the mirrors that are not set up or missed
+// writes because their queue was full, and need to be resynced.
+func (p *provider[K, V]) Degraded() []int {
+	var degraded []int
+	for i, m := range p.mirrors {
+		if !m.ready.Load() || m.dropped.Load() > 0 {
+			degraded = append(degraded, i)
+		}
+	}
+
+	return degraded
+}
+
+func (p *provider[K, V]) Sync(ctx context.Context) error {
+	p.pendingMu.Lock()
+	idle := p.idle
+	p.pendingMu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *provider[K, V]) addPending() {
+	p.pendingMu.Lock()
+	defer p.pendingMu.Unlock()
+
+	if p.pending == 0 {
+		p.idle = make(chan struct{})
+	}
+	p.pending++
+}
+
+func (p *provider[K, V]) donePending() {
+	p.pendingMu.Lock()
+	defer p.pendingMu.Unlock()
+
+	p.pending--
+	if p.pending == 0 {
+		close(p.idle)
+	}
+}
+
+// write runs the primary write and, if it succeeds, enqueues op for the
+// mirrors while holding writes.
+func (p *provider[K, V]) write(primary func() error, op func(storage.KeyValueProvider[K, V]) error) error {
+	p.writes.Lock()
+	defer p.writes.Unlock()
+
+	if err := primary(); err != nil {
+		return err
+	}
+
+	p.enqueue(op)
+	return nil
+}
+
+// Flush flushes the primary. Sync waits for the mirrors.
+func (p *provider[K, V]) Flush() error {
+	return storage.Flush(p.KeyValueProvider)
+}
+
+func (p *provider[K, V]) Store(key K, value V) error {
+	return p.write(func() error {
+		return p.KeyValueProvider.Store(key, value)
+	}, func(m storage.KeyValueProvider[K, V]) error {
+		return m.Store(key, value)
+	})
+}
+
+func (p *provider[K, V]) Update(key K, fn func(value V, exists bool) (V, error)) error {
+	var updated V
+	return p.write(func() error {
+		return p.KeyValueProvider.Update(key, func(value V, exists bool) (V, error) {
+			v, err := fn(value, exists)
+			updated = v
+			return v, err
+		})
+	}, func(m storage.KeyValueProvider[K, V]) error {
+		return m.Store(key, updated)
+	})
+}
+
+func (p *provider[K, V]) Remove(key K) error {
+	return p.write(func() error {
+		return p.KeyValueProvider.Remove(key)
+	}, func(m storage.KeyValueProvider[K, V]) error {
+		return ignoreNotFound(m.Remove(key))
+	})
+}
+
+func (p *provider[K, V]) RemovePrefix(prefix K) (int, error) {
+	var removed int
+	err := p.write(func() (err error) {
+		removed, err = p.KeyValueProvider.RemovePrefix(prefix)
+		return err
+	}, func(m storage.KeyValueProvider[K, V]) error {
+		_, err := m.RemovePrefix(prefix)
+		return err
+	})
+	return removed, err
+}
+
+func (p *provider[K, V]) RemoveWhere(pred func(key K, value V) bool) (int, error) {
+	var removed int
+	err := p.write(func() (err error) {
+		removed, err = p.KeyValueProvider.RemoveWhere(pred)
+		return err
+	}, func(m storage.KeyValueProvider[K, V]) error {
+		_, err := m.RemoveWhere(pred)
+		return err
+	})
+	return removed, err
+}
+
+func (p *provider[K, V]) Clear() error {
+	return p.write(p.KeyValueProvider.Clear, func(m storage.KeyValueProvider[K, V]) error {
+		return m.Clear()
+	})
+}
+
+func (p *provider[K, V]) StoreReference(reference K, key K) error {
+	return p.write(func() error {
+		return p.KeyValueProvider.StoreReference(reference, key)
+	}, func(m storage.KeyValueProvider[K, V]) error {
+		return m.StoreReference(reference, key)
+	})
+}
+
+func (p *provider[K, V]) AddReference(reference K, key K) error {
+	return p.write(func() error {
+		return p.KeyValueProvider.AddReference(reference, key)
+	}, func(m storage.KeyValueProvider[K, V]) error {
+		return m.AddReference(reference, key)
+	})
+}
+
+func (p *provider[K, V]) RemoveReference(reference K) error {
+	return p.write(func() error {
+		return p.KeyValueProvider.RemoveReference(reference)
+	}, func(m storage.KeyValueProvider[K, V]) error {
+		return ignoreNotFound(m.RemoveReference(reference))
+	})
+}
+
+func (p *provider[K, V]) RemoveReferenceTarget(reference K, key K) error {
+	return p.write(func() error {
+		return p.KeyValueProvider.RemoveReferenceTarget(reference, key)
+	}, func(m storage.KeyValueProvider[K, V]) error {
+		return ignoreNotFound(m.RemoveReferenceTarget(reference, key))
+	})
+}
+
+func (p *provider[K, V]) Get(key K) (V, error) {
+	value, err := p.KeyValueProvider.Get(key)
+	if !p.shouldFallback(err) {
+		return value, err
+	}
+
+	for _, m := range p.mirrors {
+		if !m.ready.Load() {
+			continue
+		}
+		if v, mErr := m.provider.Get(key); mErr == nil {
+			return v, nil
+		}
+	}
+
+	return value, err
+}
+
+func (p *provider[K, V]) GetMultiple(keys []K) ([]V, error) {
+	values, err := p.KeyValueProvider.GetMultiple(keys)
+	if !p.shouldFallback(err) {
+		return values, err
+	}
+
+	for _, m := range p.mirrors {
+		if !m.ready.Load() {
+			continue
+		}
+		if v, mErr := m.provider.GetMultiple(keys); mErr == nil {
+			return v, nil
+		}
+	}
+
+	return values, err
+}
+
+func (p *provider[K, V]) GetByReference(reference K) (V, error) {
+	value, err := p.KeyValueProvider.GetByReference(reference)
+	if !p.shouldFallback(err) {
+		return value, err
+	}
+
+	for _, m := range p.mirrors {
+		if !m.ready.Load() {
+			continue
+		}
+		if v, mErr := m.provider.GetByReference(reference); mErr == nil {
+			return v, nil
+		}
+	}
+
+	return value, err
+}
+
+func (p *provider[K, V]) GetAllByReference(reference K) ([]V, error) {
+	values, err := p.KeyValueProvider.GetAllByReference(reference)
+	if !p.shouldFallback(err) {
+		return values, err
+	}
+
+	for _, m := range p.mirrors {
+		if !m.ready.Load() {
+			continue
+		}
+		if v, mErr := m.provider.GetAllByReference(reference); mErr == nil {
+			return v, nil
+		}
+	}
+
+	return values, err
+}
+
+func (p *provider[K, V]) shouldFallback(err error) bool {
+	return err != nil && p.cfg.ReadFallback && !errors.Is(err, errors.NotFound)
+}
+
+func (p *provider[K, V]) enqueue(op func(storage.KeyValueProvider[K, V]) error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if !p.running {
+		return
+	}
+
+	for i, m := range p.mirrors {
+		p.addPending()
+		select {
+		case m.queue <- op:
+		default:
+			p.donePending()
+			m.dropped.Add(1)
+			p.report(i, ErrQueueFull)
+		}
+	}
+}
+
+func (p *provider[K, V]) replicate(index int, m *mirror[K, V]) {
+	defer p.workers.Done()
+
+	ready := m.ready.Load() || p.setupMirror(index, m)
+	for op := range m.queue {
+		if ready {
+			p.apply(index, m, op)
+		}
+		p.donePending()
+	}
+}
+
+func (p *provider[K, V]) setupMirror(index int, m *mirror[K, V]) bool {
+	for {
+		select {
+		case <-p.stop:
+			return false
+		case <-time.After(p.cfg.RetryInterval):
+		}
+
+		err := m.provider.Setup()
+		if err == nil {
+			m.ready.Store(true)
+			return true
+		}
+		p.report(index, err)
+	}
+}
+
+func (p *provider[K, V]) apply(index int, m *mirror[K, V], op func(storage.KeyValueProvider[K, V]) error) {
+	for attempt := 0; ; attempt++ {
+		err := op(m.provider)
+		if err == nil {
+			return
+		}
+
+		p.report(index, err)
+		if p.cfg.MaxRetries > 0 && attempt >= p.cfg.MaxRetries {
+			return
+		}
+
+		select {
+		case <-p.stop:
+			return
+		case <-time.After(p.cfg.RetryInterval):
+		}
+	}
+}
+
+func (p *provider[K, V]) report(index int, err error) {
+	if p.cfg.OnError != nil {
+		p.cfg.OnError(index, err)
+	}
+}
+
+func ignoreNotFound(err error) error {
+	if errors.Is(err, errors.NotFound) {
+		return nil
+	}
+
+	return err
+}
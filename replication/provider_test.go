@@ -0,0 +1,246 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package replication
+
+import (
+	"context"
+	baseErrors "errors"
+	"fmt"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/rlshukhov/storage/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newMemory(t *testing.T) storage.KeyValueProvider[string, string] {
+	p, err := storage.GetKeyValueProviderFromConfig[string, string](storage.KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true}),
+	})
+	require.NoError(t, err)
+
+	return p
+}
+
+// flaky fails Setup and Store while down is set.
+type flaky struct {
+	storage.KeyValueProvider[string, string]
+	down   atomic.Bool
+	stores atomic.Int64
+}
+
+var errDown = baseErrors.New("mirror is down")
+
+func (f *flaky) Setup() error {
+	if f.down.Load() {
+		return errDown
+	}
+
+	return f.KeyValueProvider.Setup()
+}
+
+func (f *flaky) Store(key string, value string) error {
+	f.stores.Add(1)
+	if f.down.Load() {
+		return errDown
+	}
+
+	return f.KeyValueProvider.Store(key, value)
+}
+
+func TestProvider_FanOut(t *testing.T) {
+	primary, first, second := newMemory(t), newMemory(t), newMemory(t)
+	p, err := New(primary, []storage.KeyValueProvider[string, string]{first, second}, Config{})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	defer p.Shutdown()
+
+	require.NoError(t, p.Store("a", "1"))
+	require.NoError(t, p.Store("b", "2"))
+	require.NoError(t, p.Update("a", func(value string, exists bool) (string, error) {
+		return value + "1", nil
+	}))
+	require.NoError(t, p.StoreReference("ref", "a"))
+	require.NoError(t, p.Remove("b"))
+	require.NoError(t, p.Sync(context.Background()))
+
+	for _, m := range []storage.KeyValueProvider[string, string]{first, second} {
+		val, err := m.Get("a")
+		require.NoError(t, err)
+		assert.Equal(t, "11", val)
+		val, err = m.GetByReference("ref")
+		require.NoError(t, err)
+		assert.Equal(t, "11", val)
+		_, err = m.Get("b")
+		assert.True(t, errors.Is(err, errors.NotFound))
+	}
+	assert.Empty(t, p.Degraded())
+}
+
+func TestProvider_Retry(t *testing.T) {
+	mirror := &flaky{KeyValueProvider: newMemory(t)}
+	var mu sync.Mutex
+	var reported []error
+	p, err := New(newMemory(t), []storage.KeyValueProvider[string, string]{mirror}, Config{
+		RetryInterval: time.Millisecond,
+		OnError: func(mirror int, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			reported = append(reported, err)
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	defer p.Shutdown()
+
+	mirror.down.Store(true)
+	require.NoError(t, p.Store("key", "value"))
+	assert.Eventually(t, func() bool { return mirror.stores.Load() >= 3 }, time.Second, time.Millisecond)
+	mirror.down.Store(false)
+	require.NoError(t, p.Sync(context.Background()))
+
+	val, err := mirror.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", val)
+	mu.Lock()
+	assert.NotEmpty(t, reported)
+	assert.ErrorIs(t, reported[0], errDown)
+	mu.Unlock()
+
+	limited := &flaky{KeyValueProvider: newMemory(t)}
+	p, err = New(newMemory(t), []storage.KeyValueProvider[string, string]{limited}, Config{
+		RetryInterval: time.Millisecond,
+		MaxRetries:    2,
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	defer p.Shutdown()
+
+	limited.down.Store(true)
+	require.NoError(t, p.Store("key", "value"))
+	require.NoError(t, p.Sync(context.Background()))
+	assert.Equal(t, int64(3), limited.stores.Load())
+}
+
+func TestProvider_QueueFull(t *testing.T) {
+	mirror := &flaky{KeyValueProvider: newMemory(t)}
+	var full atomic.Int64
+	p, err := New(newMemory(t), []storage.KeyValueProvider[string, string]{mirror}, Config{
+		QueueSize:     2,
+		RetryInterval: time.Millisecond,
+		OnError: func(mirror int, err error) {
+			if errors.Is(err, ErrQueueFull) {
+				full.Add(1)
+			}
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+
+	mirror.down.Store(true)
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		for i := 0; i < 100; i++ {
+			assert.NoError(t, p.Store(fmt.Sprintf("key-%d", i), "value"))
+		}
+	}()
+	select {
+	case <-written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("writes to the primary block on a full queue")
+	}
+
+	assert.Greater(t, p.Dropped(0), uint64(0))
+	assert.Equal(t, int64(p.Dropped(0)), full.Load())
+	assert.Equal(t, []int{0}, p.Degraded())
+	val, err := p.Get("key-99")
+	require.NoError(t, err)
+	assert.Equal(t, "value", val)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, p.Sync(ctx), context.DeadlineExceeded)
+
+	stopped := make(chan error)
+	go func() { stopped <- p.Shutdown() }()
+	select {
+	case err := <-stopped:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown blocks while the mirror is down")
+	}
+}
+
+func TestProvider_MirrorSetupFails(t *testing.T) {
+	mirror := &flaky{KeyValueProvider: newMemory(t)}
+	mirror.down.Store(true)
+	p, err := New(newMemory(t), []storage.KeyValueProvider[string, string]{mirror}, Config{
+		RetryInterval: time.Millisecond,
+		ReadFallback:  true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+
+	assert.Equal(t, []int{0}, p.Degraded())
+	require.NoError(t, p.Store("key", "value"))
+	val, err := p.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", val)
+
+	mirror.down.Store(false)
+	require.NoError(t, p.Sync(context.Background()))
+	assert.Empty(t, p.Degraded())
+	val, err = mirror.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", val)
+
+	require.NoError(t, p.Shutdown())
+	require.NoError(t, p.Shutdown())
+}
+
+func TestProvider_SyncConcurrent(t *testing.T) {
+	mirror := newMemory(t)
+	p, err := New(newMemory(t), []storage.KeyValueProvider[string, string]{mirror}, Config{})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	defer p.Shutdown()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				assert.NoError(t, p.Store("key", fmt.Sprintf("%d-%d", w, i)))
+			}
+		}(w)
+	}
+	for i := 0; i < 200; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+		err := p.Sync(ctx)
+		cancel()
+		if err != nil {
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+		}
+	}
+	wg.Wait()
+	require.NoError(t, p.Sync(context.Background()))
+
+	expected, err := p.Get("key")
+	require.NoError(t, err)
+	val, err := mirror.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, expected, val, "the mirror applies writes in the order of the primary")
+}
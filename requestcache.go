@@ -0,0 +1,148 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"context"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"reflect"
+	"sync"
+)
+
+type requestCacheKey struct{}
+
+// requestCache holds the results of Get for one request, per provider.
+type requestCache struct {
+	mu      sync.Mutex
+	entries map[any]map[any]cachedResult
+}
+
+type cachedResult struct {
+	value any
+	err   error
+}
+
+// WithRequestCache returns a copy of ctx that carries an empty read cache.
+// Providers returned by RequestCached for that ctx answer repeated Gets of
+// a key from the cache, e.g. for the duration of one HTTP request.
+func WithRequestCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requestCacheKey{}, &requestCache{entries: map[any]map[any]cachedResult{}})
+}
+
+// RequestCached returns provider reading through the cache of ctx. Get and
+// GetMultiple results, including errors.NotFound, are cached; writes made
+// through the returned provider drop what they change from the cache. Writes
+// made elsewhere are not seen until the cache is gone, so keep ctx short
+// lived. Cached values are shared by all readers and must not be modified.
+// Without a cache in ctx, provider is returned as is.
+func RequestCached[K ~string | ~uint64, V any](ctx context.Context, provider KeyValueProvider[K, V]) KeyValueProvider[K, V] {
+	cache, ok := ctx.Value(requestCacheKey{}).(*requestCache)
+	if !ok || provider == nil || !reflect.TypeOf(provider).Comparable() {
+		return provider
+	}
+
+	return &requestCachedProvider[K, V]{KeyValueProvider: provider, cache: cache}
+}
+
+type requestCachedProvider[K ~string | ~uint64, V any] struct {
+	KeyValueProvider[K, V]
+	cache *requestCache
+}
+
+func (p *requestCachedProvider[K, V]) Get(key K) (V, error) {
+	if result, ok := p.lookup(key); ok {
+		value, _ := result.value.(V)
+		return value, result.err
+	}
+
+	value, err := p.KeyValueProvider.Get(key)
+	if err == nil || storageErrors.Is(err, storageErrors.NotFound) {
+		p.remember(key, cachedResult{value: value, err: err})
+	}
+
+	return value, err
+}
+
+func (p *requestCachedProvider[K, V]) GetMultiple(keys []K) ([]V, error) {
+	var values []V
+	for _, key := range keys {
+		v, err := p.Get(key)
+		if err != nil {
+			return []V{}, err
+		}
+
+		values = append(values, v)
+	}
+
+	return values, nil
+}
+
+func (p *requestCachedProvider[K, V]) Store(key K, value V) error {
+	defer p.forget(key)
+	return p.KeyValueProvider.Store(key, value)
+}
+
+func (p *requestCachedProvider[K, V]) Update(key K, fn func(value V, exists bool) (V, error)) error {
+	defer p.forget(key)
+	return p.KeyValueProvider.Update(key, fn)
+}
+
+func (p *requestCachedProvider[K, V]) Remove(key K) error {
+	defer p.forget(key)
+	return p.KeyValueProvider.Remove(key)
+}
+
+func (p *requestCachedProvider[K, V]) RemovePrefix(prefix K) (int, error) {
+	defer p.forgetAll()
+	return p.KeyValueProvider.RemovePrefix(prefix)
+}
+
+func (p *requestCachedProvider[K, V]) RemoveWhere(pred func(key K, value V) bool) (int, error) {
+	defer p.forgetAll()
+	return p.KeyValueProvider.RemoveWhere(pred)
+}
+
+func (p *requestCachedProvider[K, V]) Clear() error {
+	defer p.forgetAll()
+	return p.KeyValueProvider.Clear()
+}
+
+func (p *requestCachedProvider[K, V]) lookup(key K) (cachedResult, bool) {
+	p.cache.mu.Lock()
+	defer p.cache.mu.Unlock()
+
+	result, ok := p.cache.entries[p.KeyValueProvider][key]
+	return result, ok
+}
+
+func (p *requestCachedProvider[K, V]) remember(key K, result cachedResult) {
+	p.cache.mu.Lock()
+	defer p.cache.mu.Unlock()
+
+	entries, ok := p.cache.entries[p.KeyValueProvider]
+	if !ok {
+		entries = map[any]cachedResult{}
+		p.cache.entries[p.KeyValueProvider] = entries
+	}
+	entries[key] = result
+}
+
+func (p *requestCachedProvider[K, V]) forget(key K) {
+	p.cache.mu.Lock()
+	defer p.cache.mu.Unlock()
+
+	delete(p.cache.entries[p.KeyValueProvider], key)
+}
+
+func (p *requestCachedProvider[K, V]) forgetAll() {
+	p.cache.mu.Lock()
+	defer p.cache.mu.Unlock()
+
+	delete(p.cache.entries, p.KeyValueProvider)
+}
@@ -0,0 +1,375 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+// Package retention removes entries that are kept longer, or in larger
+// numbers, than a policy per key prefix allows.
+package retention
+
+import (
+	"cmp"
+	"context"
+	baseErrors "errors"
+	"fmt"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rule limits the keys starting with Prefix. Zero limits are unlimited. A key
+// counts towards the rule with the longest matching prefix only.
+type Rule struct {
+	Prefix string `yaml:"prefix"`
+	// MaxAge removes entries that were last written longer ago.
+	MaxAge time.Duration `yaml:"max_age,omitempty"`
+	// MaxCount removes the least recently written entries beyond it.
+	MaxCount int `yaml:"max_count,omitempty"`
+}
+
+type Config struct {
+	Rules    []Rule        `yaml:"rules"`
+	Interval time.Duration `yaml:"interval,omitempty"`
+
+	// Times records when each key under a rule was last written. It must
+	// outlive restarts, or entries are kept for longer than MaxAge.
+	Times storage.KeyValueProvider[string, time.Time] `yaml:"-"`
+
+	// OnEnforce receives the report of every run that removed entries.
+	OnEnforce func(report Report) `yaml:"-"`
+	OnError   func(err error)     `yaml:"-"`
+}
+
+const defaultInterval = time.Hour
+
+type Reason string
+
+const (
+	ReasonMaxAge   Reason = "max_age"
+	ReasonMaxCount Reason = "max_count"
+)
+
+// Removal is an entry removed by the enforcer.
+type Removal struct {
+	Key       string    `yaml:"key" json:"key"`
+	Prefix    string    `yaml:"prefix" json:"prefix"`
+	Reason    Reason    `yaml:"reason" json:"reason"`
+	WrittenAt time.Time `yaml:"written_at" json:"written_at"`
+}
+
+type Report struct {
+	Started  time.Time     `yaml:"started" json:"started"`
+	Duration time.Duration `yaml:"duration" json:"duration"`
+	Removed  []Removal     `yaml:"removed" json:"removed"`
+}
+
+type provider[V any] struct {
+	storage.KeyValueProvider[string, V]
+	cfg Config
+	// rules are sorted by descending prefix length, so the first match is the
+	// longest.
+	rules []Rule
+
+	// mu keeps the enforcer from removing an entry while it is written.
+	mu sync.RWMutex
+}
+
+type written struct {
+	key string
+	at  time.Time
+}
+
+// New records when keys under cfg.Rules are written through the returned
+// provider and removes them once the rules no longer allow them, with
+// Enforce or Run. Entries found without a write time, e.g. because they were
+// written before retention was enabled or directly to inner, age from the
+// first time the enforcer sees them.
+func New[V any](inner storage.KeyValueProvider[string, V], cfg Config) (*provider[V], error) {
+	if inner == nil {
+		return nil, baseErrors.New("inner provider is nil")
+	}
+	if cfg.Times == nil {
+		return nil, baseErrors.New("times provider is nil")
+	}
+	if cfg.Interval < 0 {
+		return nil, baseErrors.New("interval must not be negative")
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = defaultInterval
+	}
+
+	var errs []error
+	seen := map[string]bool{}
+	for _, rule := range cfg.Rules {
+		if seen[rule.Prefix] {
+			errs = append(errs, fmt.Errorf("prefix %q: duplicate rule", rule.Prefix))
+		}
+		seen[rule.Prefix] = true
+		if rule.MaxAge < 0 || rule.MaxCount < 0 {
+			errs = append(errs, fmt.Errorf("prefix %q: limits must not be negative", rule.Prefix))
+		}
+	}
+	if err := baseErrors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	rules := slices.Clone(cfg.Rules)
+	slices.SortStableFunc(rules, func(a, b Rule) int {
+		return len(b.Prefix) - len(a.Prefix)
+	})
+
+	return &provider[V]{KeyValueProvider: inner, cfg: cfg, rules: rules}, nil
+}
+
+func (p *provider[V]) Flush() error {
+	return storage.Flush(p.KeyValueProvider)
+}
+
+func (p *provider[V]) Store(key string, value V) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if err := p.KeyValueProvider.Store(key, value); err != nil {
+		return err
+	}
+	return p.stamp(key)
+}
+
+func (p *provider[V]) Update(key string, fn func(value V, exists bool) (V, error)) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if err := p.KeyValueProvider.Update(key, fn); err != nil {
+		return err
+	}
+	return p.stamp(key)
+}
+
+// Remove removes key and its write time. Write times of entries removed
+// otherwise are dropped by the next enforcement.
+func (p *provider[V]) Remove(key string) error {
+	if err := p.KeyValueProvider.Remove(key); err != nil {
+		return err
+	}
+	if _, ok := p.rule(key); !ok {
+		return nil
+	}
+
+	if err := p.cfg.Times.Remove(key); err != nil && !errors.Is(err, errors.NotFound) {
+		return err
+	}
+	return nil
+}
+
+// Run enforces the rules every Interval until ctx is done.
+func (p *provider[V]) Run(ctx context.Context) error {
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := p.Enforce(ctx)
+		if err != nil && ctx.Err() == nil && p.cfg.OnError != nil {
+			p.cfg.OnError(err)
+		}
+		if len(report.Removed) > 0 && p.cfg.OnEnforce != nil {
+			p.cfg.OnEnforce(report)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Enforce removes the entries the rules no longer allow once and reports
+// them. Entries over MaxAge are removed first, then the least recently
+// written ones beyond MaxCount.
+func (p *provider[V]) Enforce(ctx context.Context) (Report, error) {
+	report := Report{Started: time.Now()}
+
+	var errs []error
+	for _, rule := range p.rules {
+		if ctx.Err() != nil {
+			errs = append(errs, ctx.Err())
+			break
+		}
+		if rule.MaxAge == 0 && rule.MaxCount == 0 {
+			continue
+		}
+
+		removed, err := p.enforce(ctx, rule, report.Started)
+		report.Removed = append(report.Removed, removed...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("prefix %q: %w", rule.Prefix, err))
+		}
+	}
+	report.Duration = time.Since(report.Started)
+
+	return report, baseErrors.Join(errs...)
+}
+
+func (p *provider[V]) enforce(ctx context.Context, rule Rule, now time.Time) ([]Removal, error) {
+	entries, err := p.written(rule, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// Newest first, so the entries beyond MaxCount are at the end.
+	slices.SortFunc(entries, func(a, b written) int {
+		return cmp.Or(b.at.Compare(a.at), strings.Compare(a.key, b.key))
+	})
+
+	var removals []Removal
+	kept := 0
+	for _, entry := range entries {
+		reason := Reason("")
+		switch {
+		case rule.MaxAge > 0 && now.Sub(entry.at) > rule.MaxAge:
+			reason = ReasonMaxAge
+		case rule.MaxCount > 0 && kept >= rule.MaxCount:
+			reason = ReasonMaxCount
+		default:
+			kept++
+			continue
+		}
+		if ctx.Err() != nil {
+			return removals, ctx.Err()
+		}
+
+		removed, err := p.remove(entry)
+		if err != nil {
+			return removals, err
+		}
+		if removed {
+			removals = append(removals, Removal{Key: entry.key, Prefix: rule.Prefix, Reason: reason, WrittenAt: entry.at})
+		}
+	}
+
+	return removals, nil
+}
+
+// written returns the entries rule applies to with their write times. Keys
+// without a write time are stamped with now; write times of keys that no
+// longer exist are dropped.
+func (p *provider[V]) written(rule Rule, now time.Time) ([]written, error) {
+	times := map[string]time.Time{}
+	err := p.cfg.Times.ForEachPrefix(rule.Prefix, func(key string, at time.Time) bool {
+		times[key] = at
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []written
+	var missing []string
+	err = p.KeyValueProvider.ForEachPrefix(rule.Prefix, func(key string, _ V) bool {
+		if owner, _ := p.rule(key); owner.Prefix != rule.Prefix {
+			return true
+		}
+
+		at, ok := times[key]
+		if !ok {
+			missing = append(missing, key)
+			at = now
+		}
+		delete(times, key)
+		entries = append(entries, written{key: key, at: at})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, key := range missing {
+		if err := p.cfg.Times.Update(key, func(at time.Time, exists bool) (time.Time, error) {
+			if exists {
+				return at, nil
+			}
+			return now, nil
+		}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for key := range times {
+		if owner, _ := p.rule(key); owner.Prefix != rule.Prefix {
+			continue
+		}
+		if err := p.removeStale(key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return entries, baseErrors.Join(errs...)
+}
+
+// remove removes entry unless it was written since its write time was read.
+func (p *provider[V]) remove(entry written) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	at, err := p.cfg.Times.Get(entry.key)
+	if err != nil && !errors.Is(err, errors.NotFound) {
+		return false, err
+	}
+	if err == nil && at.After(entry.at) {
+		return false, nil
+	}
+
+	err = p.KeyValueProvider.Remove(entry.key)
+	if err != nil && !errors.Is(err, errors.NotFound) {
+		return false, err
+	}
+	removed := err == nil
+	if err := p.cfg.Times.Remove(entry.key); err != nil && !errors.Is(err, errors.NotFound) {
+		return removed, err
+	}
+
+	return removed, nil
+}
+
+// removeStale drops the write time of key if key was not written meanwhile.
+func (p *provider[V]) removeStale(key string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, err := p.KeyValueProvider.Get(key)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, errors.NotFound) {
+		return err
+	}
+
+	if err := p.cfg.Times.Remove(key); err != nil && !errors.Is(err, errors.NotFound) {
+		return err
+	}
+	return nil
+}
+
+func (p *provider[V]) stamp(key string) error {
+	if _, ok := p.rule(key); !ok {
+		return nil
+	}
+
+	return p.cfg.Times.Store(key, time.Now())
+}
+
+func (p *provider[V]) rule(key string) (Rule, bool) {
+	for _, rule := range p.rules {
+		if strings.HasPrefix(key, rule.Prefix) {
+			return rule, true
+		}
+	}
+
+	return Rule{}, false
+}
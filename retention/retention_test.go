@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package retention
+
+import (
+	"context"
+	"fmt"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/rlshukhov/storage/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func newProvider[V any](t *testing.T) storage.KeyValueProvider[string, V] {
+	p, err := storage.GetKeyValueProviderFromConfig[string, V](storage.KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	t.Cleanup(func() {
+		require.NoError(t, p.Shutdown())
+	})
+
+	return p
+}
+
+func TestEnforce(t *testing.T) {
+	inner := newProvider[string](t)
+	times := newProvider[time.Time](t)
+	require.NoError(t, inner.Store("audit:legacy", "written before retention"))
+
+	p, err := New(inner, Config{
+		Times: times,
+		Rules: []Rule{
+			{Prefix: "session:", MaxAge: time.Hour},
+			{Prefix: "audit:", MaxCount: 2},
+			{Prefix: "audit:keep:"},
+		},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, p.Store("session:old", "a"))
+	require.NoError(t, p.Store("session:new", "b"))
+	require.NoError(t, times.Store("session:old", time.Now().Add(-2*time.Hour)))
+	for i := range 3 {
+		require.NoError(t, p.Store(fmt.Sprintf("audit:%d", i), "entry"))
+		require.NoError(t, times.Store(fmt.Sprintf("audit:%d", i), time.Now().Add(time.Duration(i-10)*time.Minute)))
+	}
+	require.NoError(t, p.Store("audit:keep:1", "kept"))
+	require.NoError(t, p.Store("other", "untracked"))
+	_, err = times.Get("other")
+	assert.ErrorIs(t, err, errors.NotFound)
+
+	report, err := p.Enforce(context.Background())
+	require.NoError(t, err)
+	removed := map[string]Reason{}
+	for _, r := range report.Removed {
+		removed[r.Key] = r.Reason
+	}
+	assert.Equal(t, map[string]Reason{
+		"session:old": ReasonMaxAge,
+		"audit:0":     ReasonMaxCount,
+		"audit:1":     ReasonMaxCount,
+	}, removed)
+
+	var keys []string
+	require.NoError(t, inner.ForEachKey(func(key string) bool {
+		keys = append(keys, key)
+		return true
+	}))
+	// audit:legacy had no write time and ages from this enforcement.
+	assert.ElementsMatch(t, []string{"session:new", "audit:2", "audit:legacy", "audit:keep:1", "other"}, keys)
+	_, err = times.Get("session:old")
+	assert.ErrorIs(t, err, errors.NotFound)
+
+	require.NoError(t, inner.Remove("audit:2"))
+	report, err = p.Enforce(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, report.Removed)
+	_, err = times.Get("audit:2")
+	assert.ErrorIs(t, err, errors.NotFound)
+
+	_, err = New(inner, Config{Times: times, Rules: []Rule{{Prefix: "a:"}, {Prefix: "a:", MaxAge: -1}}})
+	assert.Error(t, err)
+	_, err = New(inner, Config{})
+	assert.Error(t, err)
+}
+
+func TestRun(t *testing.T) {
+	inner := newProvider[string](t)
+	times := newProvider[time.Time](t)
+
+	reports := make(chan Report, 1)
+	p, err := New(inner, Config{
+		Times:     times,
+		Rules:     []Rule{{Prefix: "tmp:", MaxAge: time.Minute}},
+		Interval:  10 * time.Millisecond,
+		OnEnforce: func(report Report) { reports <- report },
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Run(ctx)
+
+	require.NoError(t, p.Store("tmp:1", "a"))
+	require.NoError(t, times.Store("tmp:1", time.Now().Add(-time.Hour)))
+
+	select {
+	case report := <-reports:
+		require.Len(t, report.Removed, 1)
+		assert.Equal(t, "tmp:1", report.Removed[0].Key)
+	case <-time.After(5 * time.Second):
+		t.Fatal("entry was not removed")
+	}
+	_, err = inner.Get("tmp:1")
+	assert.ErrorIs(t, err, errors.NotFound)
+}
@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package rocksdb
+
+import (
+	"errors"
+	"fmt"
+	"github.com/rlshukhov/storage/codec"
+	"slices"
+	"time"
+)
+
+type Config struct {
+	Path     string `yaml:"db_path"`
+	ReadOnly bool   `yaml:"read_only,omitempty"`
+	Sync     bool   `yaml:"sync,omitempty"`
+
+	BlockCacheSize    uint64  `yaml:"block_cache_size,omitempty"`
+	WriteBufferSize   uint64  `yaml:"write_buffer_size,omitempty"`
+	MaxOpenFiles      int     `yaml:"max_open_files,omitempty"`
+	MaxBackgroundJobs int     `yaml:"max_background_jobs,omitempty"`
+	BloomFilterBits   float64 `yaml:"bloom_filter_bits,omitempty"`
+	Compression       string  `yaml:"compression,omitempty"`
+	CompactionStyle   string  `yaml:"compaction_style,omitempty"`
+	Options           string  `yaml:"options,omitempty"`
+	StrictSchema      bool    `yaml:"strict_schema,omitempty"`
+	Codec             string  `yaml:"codec,omitempty"`
+
+	MaxReferenceDepth  int           `yaml:"max_reference_depth,omitempty"`
+	TransactionTimeout time.Duration `yaml:"transaction_timeout,omitempty"`
+}
+
+var (
+	compressions     = []string{"", "none", "snappy", "zlib", "bz2", "lz4", "lz4hc", "zstd"}
+	compactionStyles = []string{"", "level", "universal", "fifo"}
+)
+
+func (c Config) Validate() error {
+	var errs []error
+	if c.Path == "" {
+		errs = append(errs, errors.New("db_path is required"))
+	}
+	if c.MaxOpenFiles < -1 {
+		errs = append(errs, errors.New("max_open_files must be -1 or greater"))
+	}
+	if c.MaxBackgroundJobs < 0 {
+		errs = append(errs, errors.New("max_background_jobs must not be negative"))
+	}
+	if c.BloomFilterBits < 0 {
+		errs = append(errs, errors.New("bloom_filter_bits must not be negative"))
+	}
+	if !slices.Contains(compressions, c.Compression) {
+		errs = append(errs, fmt.Errorf("unknown compression %q", c.Compression))
+	}
+	if !slices.Contains(compactionStyles, c.CompactionStyle) {
+		errs = append(errs, fmt.Errorf("unknown compaction_style %q", c.CompactionStyle))
+	}
+	if c.MaxReferenceDepth < 0 {
+		errs = append(errs, errors.New("max_reference_depth must not be negative"))
+	}
+	if c.TransactionTimeout < 0 {
+		errs = append(errs, errors.New("transaction_timeout must not be negative"))
+	}
+	if c.Codec != "" {
+		if cd, err := codec.ByName(c.Codec); err != nil {
+			errs = append(errs, err)
+		} else if cd == codec.SharedGob {
+			errs = append(errs, errors.New("gob-shared codec is only supported by badger"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
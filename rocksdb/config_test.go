@@ -0,0 +1,53 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package rocksdb
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	path := "/var/lib/app/rocksdb"
+	valid := map[string]Config{
+		"path": {Path: path},
+		"full": {
+			Path:               path,
+			BlockCacheSize:     64 << 20,
+			MaxOpenFiles:       -1,
+			MaxBackgroundJobs:  4,
+			BloomFilterBits:    10,
+			Compression:        "zstd",
+			CompactionStyle:    "universal",
+			Codec:              "msgpack",
+			MaxReferenceDepth:  4,
+			TransactionTimeout: time.Second,
+		},
+	}
+	for name, cfg := range valid {
+		assert.NoError(t, cfg.Validate(), name)
+	}
+
+	invalid := map[string]Config{
+		"no path":              {},
+		"open files":           {Path: path, MaxOpenFiles: -2},
+		"background jobs":      {Path: path, MaxBackgroundJobs: -1},
+		"bloom filter":         {Path: path, BloomFilterBits: -1},
+		"compression":          {Path: path, Compression: "brotli"},
+		"compaction style":     {Path: path, CompactionStyle: "tiered"},
+		"negative depth":       {Path: path, MaxReferenceDepth: -1},
+		"negative transaction": {Path: path, TransactionTimeout: -time.Second},
+		"codec":                {Path: path, Codec: "xml"},
+		"shared gob":           {Path: path, Codec: "gob-shared"},
+	}
+	for name, cfg := range invalid {
+		assert.Error(t, cfg.Validate(), name)
+	}
+}
@@ -0,0 +1,1120 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build rocksdb
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package rocksdb
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/linxGnu/grocksdb"
+	"github.com/rlshukhov/storage/codec"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"github.com/rlshukhov/storage/internal/bufpool"
+	"github.com/rlshukhov/storage/internal/schema"
+	"github.com/rlshukhov/storage/internal/timeout"
+	"github.com/rlshukhov/storage/kv"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	valueMagic      byte = 0xB5
+	schemaMagic     byte = 0xB6
+	protoMagic      byte = 0xB7
+	taggedMagic     byte = 0xB8
+	valueHeaderSize      = 1 + 8 + 4
+
+	defaultMaxReferenceDepth = 8
+)
+
+var columnFamilies = []string{"default", "references"}
+
+type provider[K ~string | ~uint64, V any] struct {
+	cfg         Config
+	fingerprint uint64
+	schema      []byte
+	codec       codec.Codec
+	lastWrite   atomic.Int64
+
+	mu         sync.RWMutex
+	writeMu    sync.Mutex
+	db         *grocksdb.DB
+	data       *grocksdb.ColumnFamilyHandle
+	references *grocksdb.ColumnFamilyHandle
+	opts       *grocksdb.Options
+	table      *grocksdb.BlockBasedTableOptions
+	cache      *grocksdb.Cache
+	ro         *grocksdb.ReadOptions
+	wo         *grocksdb.WriteOptions
+}
+
+func New[K ~string | ~uint64, V any](cfg Config) (*provider[K, V], error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	c := codec.For[V]()
+	if cfg.Codec != "" {
+		c, _ = codec.ByName(cfg.Codec)
+		if c == codec.Proto && !codec.IsProto[V]() {
+			return nil, fmt.Errorf("proto codec requires a proto.Message value type, got %v", reflect.TypeFor[V]())
+		}
+	}
+
+	p := &provider[K, V]{cfg: cfg, fingerprint: typeFingerprint[V](), codec: c}
+	if cfg.StrictSchema && p.codec != codec.Proto {
+		p.schema = schema.Encode(schema.Describe(reflect.TypeFor[V]()))
+	}
+
+	return p, nil
+}
+
+func (p *provider[K, V]) Setup() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.db != nil {
+		return nil
+	}
+
+	opts := grocksdb.NewDefaultOptions()
+	if p.cfg.Options != "" {
+		parsed, err := grocksdb.GetOptionsFromString(opts, p.cfg.Options)
+		opts.Destroy()
+		if err != nil {
+			return fmt.Errorf("invalid rocksdb options: %w", err)
+		}
+		opts = parsed
+	}
+	opts.SetCreateIfMissing(!p.cfg.ReadOnly)
+	opts.SetCreateIfMissingColumnFamilies(!p.cfg.ReadOnly)
+	if p.cfg.WriteBufferSize > 0 {
+		opts.SetWriteBufferSize(p.cfg.WriteBufferSize)
+	}
+	if p.cfg.MaxOpenFiles != 0 {
+		opts.SetMaxOpenFiles(p.cfg.MaxOpenFiles)
+	}
+	if p.cfg.MaxBackgroundJobs > 0 {
+		opts.SetMaxBackgroundJobs(p.cfg.MaxBackgroundJobs)
+	}
+	if p.cfg.Compression != "" {
+		opts.SetCompression(compression(p.cfg.Compression))
+	}
+	if p.cfg.CompactionStyle != "" {
+		opts.SetCompactionStyle(compactionStyle(p.cfg.CompactionStyle))
+	}
+
+	table := grocksdb.NewDefaultBlockBasedTableOptions()
+	var cache *grocksdb.Cache
+	if p.cfg.BlockCacheSize > 0 {
+		cache = grocksdb.NewLRUCache(p.cfg.BlockCacheSize)
+		table.SetBlockCache(cache)
+	}
+	if p.cfg.BloomFilterBits > 0 {
+		table.SetFilterPolicy(grocksdb.NewBloomFilter(p.cfg.BloomFilterBits))
+	}
+	opts.SetBlockBasedTableFactory(table)
+
+	var db *grocksdb.DB
+	var handles []*grocksdb.ColumnFamilyHandle
+	var err error
+	cfOpts := []*grocksdb.Options{opts, opts}
+	if p.cfg.ReadOnly {
+		db, handles, err = grocksdb.OpenDbForReadOnlyColumnFamilies(opts, p.cfg.Path, columnFamilies, cfOpts, false)
+	} else {
+		db, handles, err = grocksdb.OpenDbColumnFamilies(opts, p.cfg.Path, columnFamilies, cfOpts)
+	}
+	if err != nil {
+		opts.Destroy()
+		table.Destroy()
+		if cache != nil {
+			cache.Destroy()
+		}
+		return storageErrors.NewUnavailable(err)
+	}
+
+	wo := grocksdb.NewDefaultWriteOptions()
+	wo.SetSync(p.cfg.Sync)
+
+	p.db = db
+	p.data = handles[0]
+	p.references = handles[1]
+	p.opts = opts
+	p.table = table
+	p.cache = cache
+	p.ro = grocksdb.NewDefaultReadOptions()
+	p.wo = wo
+	return nil
+}
+
+func (p *provider[K, V]) Shutdown() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.db == nil {
+		return nil
+	}
+
+	var err error
+	if !p.cfg.ReadOnly {
+		flush := grocksdb.NewDefaultFlushOptions()
+		err = p.db.FlushCFs([]*grocksdb.ColumnFamilyHandle{p.data, p.references}, flush)
+		flush.Destroy()
+	}
+
+	p.data.Destroy()
+	p.references.Destroy()
+	p.db.Close()
+	p.ro.Destroy()
+	p.wo.Destroy()
+	p.opts.Destroy()
+	p.table.Destroy()
+	if p.cache != nil {
+		p.cache.Destroy()
+	}
+
+	p.db, p.data, p.references = nil, nil, nil
+	p.opts, p.table, p.cache, p.ro, p.wo = nil, nil, nil, nil, nil
+	return err
+}
+
+func (p *provider[K, V]) Close() error {
+	return p.Shutdown()
+}
+
+func (p *provider[K, V]) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return p.view(func() error {
+		return nil
+	})
+}
+
+func (p *provider[K, V]) Stats() (kv.ProviderStats, error) {
+	stats := kv.ProviderStats{Provider: "rocksdb"}
+
+	err := p.view(func() error {
+		err := p.scan(p.data, nil, func(_, _ []byte) (bool, error) {
+			stats.Entries++
+			return true, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		err = p.scan(p.references, nil, func(_, _ []byte) (bool, error) {
+			stats.References++
+			return true, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		stats.DiskUsage = int64(p.property("rocksdb.total-sst-files-size"))
+		stats.Backend = map[string]any{
+			"path":               p.cfg.Path,
+			"read_only":          p.cfg.ReadOnly,
+			"estimate_num_keys":  p.property("rocksdb.estimate-num-keys"),
+			"memtable_size":      p.property("rocksdb.cur-size-all-mem-tables"),
+			"live_data_size":     p.property("rocksdb.estimate-live-data-size"),
+			"pending_compaction": p.property("rocksdb.estimate-pending-compaction-bytes"),
+			"block_cache_usage":  p.property("rocksdb.block-cache-usage"),
+			"latest_sequence":    p.db.GetLatestSequenceNumber(),
+		}
+		return nil
+	})
+	if lastWrite := p.lastWrite.Load(); lastWrite > 0 {
+		stats.LastFlush = time.Unix(0, lastWrite)
+	}
+
+	return stats, err
+}
+
+func (p *provider[K, V]) ApproximateSize() (uint64, error) {
+	var size uint64
+	err := p.view(func() error {
+		for _, cf := range []*grocksdb.ColumnFamilyHandle{p.data, p.references} {
+			for _, name := range []string{"rocksdb.total-sst-files-size", "rocksdb.cur-size-all-mem-tables"} {
+				value, _ := p.db.GetIntPropertyCF(name, cf)
+				size += value
+			}
+		}
+		return nil
+	})
+
+	return size, err
+}
+
+func (p *provider[K, V]) Compact() error {
+	return p.update(func() error {
+		p.db.CompactRangeCF(p.data, grocksdb.Range{})
+		p.db.CompactRangeCF(p.references, grocksdb.Range{})
+		return nil
+	})
+}
+
+func (p *provider[K, V]) Store(key K, value V) error {
+	v, err := p.encodeToBytes(value)
+	if err != nil {
+		return err
+	}
+
+	return p.update(func() error {
+		return p.db.PutCF(p.wo, p.data, keyToBytes(key), v)
+	})
+}
+
+func (p *provider[K, V]) Update(key K, fn func(value V, exists bool) (V, error)) error {
+	k := keyToBytes(key)
+
+	return p.updateWithin(func(deadline timeout.Deadline) error {
+		p.writeMu.Lock()
+		defer p.writeMu.Unlock()
+
+		raw, err := p.get(p.data, k)
+		if err != nil {
+			return err
+		}
+
+		var value V
+		exists := raw != nil
+		if exists {
+			if value, err = p.decodeFromBytes(raw); err != nil {
+				return err
+			}
+		}
+
+		value, err = fn(value, exists)
+		if err != nil {
+			return err
+		}
+
+		v, err := p.encodeToBytes(value)
+		if err != nil {
+			return err
+		}
+		if err := deadline.Err(); err != nil {
+			return err
+		}
+
+		return p.db.PutCF(p.wo, p.data, k, v)
+	})
+}
+
+func (p *provider[K, V]) Get(key K) (V, error) {
+	var value V
+	err := p.GetInto(key, &value)
+
+	return value, err
+}
+
+// GetInto decodes the value of key into dst, which is reset first. dst is
+// left unchanged when key does not exist.
+func (p *provider[K, V]) GetInto(key K, dst *V) error {
+	return p.view(func() error {
+		raw, err := p.get(p.data, keyToBytes(key))
+		if err != nil {
+			return err
+		}
+		if raw == nil {
+			return storageErrors.NewNotFound(fmt.Errorf("key %v", key))
+		}
+
+		return p.decodeInto(raw, dst)
+	})
+}
+
+func (p *provider[K, V]) GetMultiple(keys []K) ([]V, error) {
+	var values []V
+	for _, key := range keys {
+		v, err := p.Get(key)
+		if err != nil {
+			return []V{}, err
+		}
+
+		values = append(values, v)
+	}
+
+	return values, nil
+}
+
+func (p *provider[K, V]) Remove(key K) error {
+	return p.update(func() error {
+		return p.db.DeleteCF(p.wo, p.data, keyToBytes(key))
+	})
+}
+
+func (p *provider[K, V]) RemovePrefix(prefix K) (int, error) {
+	pr := keyToBytes(prefix)
+
+	removed := 0
+	err := p.update(func() error {
+		err := p.scan(p.data, pr, func(_, _ []byte) (bool, error) {
+			removed++
+			return true, nil
+		})
+		if err != nil || removed == 0 {
+			return err
+		}
+
+		if limit := prefixLimit(pr); limit != nil {
+			return p.db.DeleteRangeCF(p.wo, p.data, pr, limit)
+		}
+
+		return p.deleteWhere(p.data, pr, func(_, _ []byte) (bool, error) {
+			return true, nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+func (p *provider[K, V]) RemoveWhere(pred func(key K, value V) bool) (int, error) {
+	removed := 0
+	err := p.update(func() error {
+		return p.deleteWhere(p.data, nil, func(k, v []byte) (bool, error) {
+			key, err := bytesToKey[K](k)
+			if err != nil {
+				return false, err
+			}
+			value, err := p.decodeFromBytes(v)
+			if err != nil {
+				return false, err
+			}
+
+			if !pred(key, value) {
+				return false, nil
+			}
+			removed++
+			return true, nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+func (p *provider[K, V]) Clear() error {
+	return p.update(func() error {
+		all := func(_, _ []byte) (bool, error) {
+			return true, nil
+		}
+
+		return errors.Join(p.deleteWhere(p.data, nil, all), p.deleteWhere(p.references, nil, all))
+	})
+}
+
+func (p *provider[K, V]) ForEach(fn func(key K, value V) bool) error {
+	return p.iterate(nil, fn)
+}
+
+func (p *provider[K, V]) ForEachPrefix(prefix K, fn func(key K, value V) bool) error {
+	return p.iterate(keyToBytes(prefix), fn)
+}
+
+func (p *provider[K, V]) iterate(prefix []byte, fn func(key K, value V) bool) error {
+	return p.view(func() error {
+		return p.scan(p.data, prefix, func(k, v []byte) (bool, error) {
+			key, err := bytesToKey[K](k)
+			if err != nil {
+				return false, err
+			}
+			value, err := p.decodeFromBytes(v)
+			if err != nil {
+				return false, err
+			}
+
+			return fn(key, value), nil
+		})
+	})
+}
+
+func (p *provider[K, V]) ForEachKey(fn func(key K) bool) error {
+	return p.view(func() error {
+		return p.scan(p.data, nil, func(k, _ []byte) (bool, error) {
+			key, err := bytesToKey[K](k)
+			if err != nil {
+				return false, err
+			}
+
+			return fn(key), nil
+		})
+	})
+}
+
+func (p *provider[K, V]) ForEachReference(fn func(reference K, key K) bool) error {
+	return p.view(func() error {
+		return p.scan(p.references, nil, func(r, v []byte) (bool, error) {
+			reference, err := bytesToKey[K](r)
+			if err != nil {
+				return false, err
+			}
+			targets, err := decodeReference(v)
+			if err != nil {
+				return false, err
+			}
+
+			for _, t := range targets {
+				key, err := bytesToKey[K](t)
+				if err != nil {
+					return false, err
+				}
+				if !fn(reference, key) {
+					return false, nil
+				}
+			}
+			return true, nil
+		})
+	})
+}
+
+func (p *provider[K, V]) StoreReference(reference K, key K) error {
+	v, err := encodeReference([][]byte{keyToBytes(key)})
+	if err != nil {
+		return err
+	}
+
+	return p.update(func() error {
+		return p.db.PutCF(p.wo, p.references, keyToBytes(reference), v)
+	})
+}
+
+// StoreWithReferences stores the value and points every reference to key in
+// one write batch.
+func (p *provider[K, V]) StoreWithReferences(key K, value V, references ...K) error {
+	v, err := p.encodeToBytes(value)
+	if err != nil {
+		return err
+	}
+	k := keyToBytes(key)
+	target, err := encodeReference([][]byte{k})
+	if err != nil {
+		return err
+	}
+
+	return p.update(func() error {
+		wb := grocksdb.NewWriteBatch()
+		defer wb.Destroy()
+
+		wb.PutCF(p.data, k, v)
+		for _, reference := range references {
+			wb.PutCF(p.references, keyToBytes(reference), target)
+		}
+		return p.db.Write(p.wo, wb)
+	})
+}
+
+func (p *provider[K, V]) AddReference(reference K, key K) error {
+	r, k := keyToBytes(reference), keyToBytes(key)
+
+	return p.update(func() error {
+		p.writeMu.Lock()
+		defer p.writeMu.Unlock()
+
+		targets, err := p.loadReference(r)
+		if err != nil && !storageErrors.Is(err, storageErrors.NotFound) {
+			return err
+		}
+		if slices.ContainsFunc(targets, func(t []byte) bool { return bytes.Equal(t, k) }) {
+			return nil
+		}
+
+		return p.saveReference(r, append(targets, k))
+	})
+}
+
+func (p *provider[K, V]) RemoveReference(reference K) error {
+	return p.update(func() error {
+		return p.db.DeleteCF(p.wo, p.references, keyToBytes(reference))
+	})
+}
+
+func (p *provider[K, V]) RemoveReferenceTarget(reference K, key K) error {
+	r, k := keyToBytes(reference), keyToBytes(key)
+
+	return p.update(func() error {
+		p.writeMu.Lock()
+		defer p.writeMu.Unlock()
+
+		targets, err := p.loadReference(r)
+		if err != nil {
+			return err
+		}
+
+		remaining := slices.DeleteFunc(slices.Clone(targets), func(t []byte) bool { return bytes.Equal(t, k) })
+		if len(remaining) == len(targets) {
+			return storageErrors.NewNotFound(fmt.Errorf("reference %v does not point to %v", reference, key))
+		}
+
+		return p.saveReference(r, remaining)
+	})
+}
+
+func (p *provider[K, V]) GetByReference(reference K) (V, error) {
+	var key K
+	err := p.view(func() error {
+		targets, err := p.resolveReference(keyToBytes(reference), 0, map[string]bool{})
+		if err != nil {
+			return err
+		}
+
+		key, err = bytesToKey[K](targets[0])
+		return err
+	})
+	if err != nil {
+		var v V
+		return v, err
+	}
+
+	return p.Get(key)
+}
+
+func (p *provider[K, V]) GetAllByReference(reference K) ([]V, error) {
+	var values []V
+	err := p.view(func() error {
+		targets, err := p.resolveReference(keyToBytes(reference), 0, map[string]bool{})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range targets {
+			raw, err := p.get(p.data, k)
+			if err != nil {
+				return err
+			}
+			if raw == nil {
+				continue
+			}
+
+			v, err := p.decodeFromBytes(raw)
+			if err != nil {
+				return err
+			}
+			values = append(values, v)
+		}
+		return nil
+	})
+
+	return values, err
+}
+
+func (p *provider[K, V]) Verify() error {
+	var errs []error
+	err := p.view(func() error {
+		err := p.scan(p.data, nil, func(k, v []byte) (bool, error) {
+			if _, err := p.decodeFromBytes(v); err != nil {
+				errs = append(errs, fmt.Errorf("key %q: %w", k, err))
+			}
+			return true, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return p.scan(p.references, nil, func(r, v []byte) (bool, error) {
+			if _, err := decodeReference(v); err != nil {
+				errs = append(errs, fmt.Errorf("reference %q: %w", r, err))
+			}
+			return true, nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	return errors.Join(errs...)
+}
+
+// ReencodeAll rewrites every value that was not written with the configured
+// codec and returns how many values were rewritten.
+func (p *provider[K, V]) ReencodeAll() (int, error) {
+	reencoded := 0
+	err := p.update(func() error {
+		p.writeMu.Lock()
+		defer p.writeMu.Unlock()
+
+		wb := grocksdb.NewWriteBatch()
+		defer wb.Destroy()
+
+		err := p.scan(p.data, nil, func(k, raw []byte) (bool, error) {
+			if !p.stale(raw) {
+				return true, nil
+			}
+
+			value, err := p.decodeFromBytes(raw)
+			if err != nil {
+				return false, fmt.Errorf("key %q: %w", k, err)
+			}
+			v, err := p.encodeToBytes(value)
+			if err != nil {
+				return false, err
+			}
+			wb.PutCF(p.data, k, v)
+			return true, nil
+		})
+		if err != nil || wb.Count() == 0 {
+			return err
+		}
+		if err := p.db.Write(p.wo, wb); err != nil {
+			return err
+		}
+
+		reencoded = wb.Count()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return reencoded, nil
+}
+
+func (p *provider[K, V]) stale(raw []byte) bool {
+	return len(raw) <= valueHeaderSize || raw[0] != taggedMagic || raw[valueHeaderSize] != p.codec.Tag()
+}
+
+func (p *provider[K, V]) Backup(w io.Writer) error {
+	dir, err := os.MkdirTemp("", "rocksdb-backup-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	checkpointDir := filepath.Join(dir, "checkpoint")
+	err = p.view(func() error {
+		checkpoint, err := p.db.NewCheckpoint()
+		if err != nil {
+			return err
+		}
+		defer checkpoint.Destroy()
+
+		return checkpoint.CreateCheckpoint(checkpointDir, 0)
+	})
+	if err != nil {
+		return err
+	}
+
+	return writeTar(w, checkpointDir)
+}
+
+func writeTar(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		if header.Name, err = filepath.Rel(dir, path); err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+func (p *provider[K, V]) view(fn func() error) error {
+	deadline := timeout.Start(p.cfg.TransactionTimeout, "rocksdb read")
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.db == nil {
+		return storageErrors.NewClosed(errors.New("database is not open"))
+	}
+
+	if err := fn(); err != nil {
+		return err
+	}
+	return deadline.Err()
+}
+
+func (p *provider[K, V]) update(fn func() error) error {
+	return p.updateWithin(func(timeout.Deadline) error {
+		return fn()
+	})
+}
+
+// updateWithin runs fn with the deadline of the write. Writes that run
+// callbacks check it before they write, so a Timeout leaves the database
+// unchanged.
+func (p *provider[K, V]) updateWithin(fn func(deadline timeout.Deadline) error) error {
+	if p.cfg.ReadOnly {
+		return storageErrors.ReadOnly
+	}
+
+	deadline := timeout.Start(p.cfg.TransactionTimeout, "rocksdb write")
+	err := func() error {
+		p.mu.RLock()
+		defer p.mu.RUnlock()
+
+		if p.db == nil {
+			return storageErrors.NewClosed(errors.New("database is not open"))
+		}
+		if err := deadline.Err(); err != nil {
+			return err
+		}
+
+		return fn(deadline)
+	}()
+	if err == nil {
+		p.lastWrite.Store(time.Now().UnixNano())
+	}
+
+	return err
+}
+
+func (p *provider[K, V]) get(cf *grocksdb.ColumnFamilyHandle, key []byte) ([]byte, error) {
+	slice, err := p.db.GetCF(p.ro, cf, key)
+	if err != nil {
+		return nil, err
+	}
+	defer slice.Free()
+
+	if !slice.Exists() {
+		return nil, nil
+	}
+
+	return bytes.Clone(slice.Data()), nil
+}
+
+func (p *provider[K, V]) scan(cf *grocksdb.ColumnFamilyHandle, prefix []byte, fn func(key, value []byte) (bool, error)) error {
+	it := p.db.NewIteratorCF(p.ro, cf)
+	defer it.Close()
+
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		next, err := fn(bytes.Clone(it.Key().Data()), bytes.Clone(it.Value().Data()))
+		if err != nil {
+			return err
+		}
+		if !next {
+			return nil
+		}
+	}
+
+	return it.Err()
+}
+
+func (p *provider[K, V]) deleteWhere(cf *grocksdb.ColumnFamilyHandle, prefix []byte, fn func(key, value []byte) (bool, error)) error {
+	wb := grocksdb.NewWriteBatch()
+	defer wb.Destroy()
+
+	err := p.scan(cf, prefix, func(k, v []byte) (bool, error) {
+		remove, err := fn(k, v)
+		if remove {
+			wb.DeleteCF(cf, k)
+		}
+		return err == nil, err
+	})
+	if err != nil || wb.Count() == 0 {
+		return err
+	}
+
+	return p.db.Write(p.wo, wb)
+}
+
+func (p *provider[K, V]) property(name string) uint64 {
+	var total uint64
+	for _, cf := range []*grocksdb.ColumnFamilyHandle{p.data, p.references} {
+		value, _ := p.db.GetIntPropertyCF(name, cf)
+		total += value
+	}
+
+	return total
+}
+
+func (p *provider[K, V]) loadReference(r []byte) ([][]byte, error) {
+	raw, err := p.get(p.references, r)
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, storageErrors.NewNotFound(fmt.Errorf("reference %q", r))
+	}
+
+	return decodeReference(raw)
+}
+
+func (p *provider[K, V]) saveReference(r []byte, targets [][]byte) error {
+	if len(targets) == 0 {
+		return p.db.DeleteCF(p.wo, p.references, r)
+	}
+
+	v, err := encodeReference(targets)
+	if err != nil {
+		return err
+	}
+
+	return p.db.PutCF(p.wo, p.references, r, v)
+}
+
+func (p *provider[K, V]) resolveReference(r []byte, depth int, path map[string]bool) ([][]byte, error) {
+	maxDepth := p.cfg.MaxReferenceDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultMaxReferenceDepth
+	}
+	if path[string(r)] {
+		return nil, storageErrors.NewReferenceCycle(fmt.Errorf("reference %q", r))
+	}
+	if depth >= maxDepth {
+		return nil, storageErrors.NewReferenceTooDeep(fmt.Errorf("reference %q exceeds depth %d", r, maxDepth))
+	}
+
+	targets, err := p.loadReference(r)
+	if err != nil {
+		return nil, err
+	}
+
+	path[string(r)] = true
+	defer delete(path, string(r))
+
+	var keys [][]byte
+	for _, t := range targets {
+		raw, err := p.get(p.references, t)
+		if err != nil {
+			return nil, err
+		}
+		if raw == nil {
+			keys = append(keys, t)
+			continue
+		}
+
+		resolved, err := p.resolveReference(t, depth+1, path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, resolved...)
+	}
+
+	return keys, nil
+}
+
+func encodeReference(targets [][]byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(targets); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decodeReference(data []byte) ([][]byte, error) {
+	var targets [][]byte
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&targets); err != nil {
+		return nil, storageErrors.NewCorrupted(err)
+	}
+	if len(targets) == 0 {
+		return nil, storageErrors.NewCorrupted(errors.New("empty reference set"))
+	}
+
+	return targets, nil
+}
+
+func (p *provider[K, V]) encodeToBytes(data V) ([]byte, error) {
+	buf := bufpool.Get()
+	defer bufpool.Put(buf)
+
+	var header [valueHeaderSize + 1 + 4]byte
+	header[valueHeaderSize] = p.codec.Tag()
+	binary.BigEndian.PutUint32(header[valueHeaderSize+1:], uint32(len(p.schema)))
+	buf.Write(header[:])
+	buf.Write(p.schema)
+	if err := codec.MarshalTo(p.codec, buf, data); err != nil {
+		return nil, err
+	}
+
+	b := buf.Bytes()
+	b[0] = taggedMagic
+	binary.BigEndian.PutUint64(b[1:9], p.fingerprint)
+	binary.BigEndian.PutUint32(b[9:valueHeaderSize], crc32.ChecksumIEEE(b[valueHeaderSize:]))
+
+	// The pooled buffer is reused, so the caller gets a copy.
+	return bytes.Clone(b), nil
+}
+
+func (p *provider[K, V]) decodeFromBytes(data []byte) (V, error) {
+	var value V
+	err := p.decodeInto(data, &value)
+
+	return value, err
+}
+
+// decodeInto decodes data into value, which is reset first.
+func (p *provider[K, V]) decodeInto(data []byte, value *V) error {
+	var zero V
+	*value = zero
+	if len(data) < valueHeaderSize || (data[0] != valueMagic && data[0] != schemaMagic && data[0] != protoMagic && data[0] != taggedMagic) {
+		return storageErrors.NewCorrupted(errors.New("value header is missing"))
+	}
+	// With StrictSchema, values that carry a schema are checked field by
+	// field below, which allows compatible changes such as added fields.
+	fingerprinted := binary.BigEndian.Uint64(data[1:9]) == p.fingerprint
+	if binary.BigEndian.Uint32(data[9:valueHeaderSize]) != crc32.ChecksumIEEE(data[valueHeaderSize:]) {
+		return storageErrors.NewCorrupted(errors.New("value checksum mismatch"))
+	}
+
+	dec := codec.Gob
+	if data[0] == protoMagic {
+		dec = codec.Proto
+	}
+
+	payload := data[valueHeaderSize:]
+	if data[0] == taggedMagic {
+		if len(payload) == 0 {
+			return storageErrors.NewCorrupted(errors.New("value codec tag is missing"))
+		}
+		c, err := codec.ByTag(payload[0])
+		if err != nil {
+			return storageErrors.NewCorrupted(err)
+		}
+		dec = c
+		payload = payload[1:]
+	}
+	if data[0] == schemaMagic || data[0] == taggedMagic {
+		if len(payload) < 4 || uint64(len(payload)-4) < uint64(binary.BigEndian.Uint32(payload)) {
+			return storageErrors.NewCorrupted(errors.New("value schema is truncated"))
+		}
+		n := binary.BigEndian.Uint32(payload)
+		if p.schema != nil && n > 0 {
+			if err := schema.Check(payload[4:4+n], p.schema); err != nil {
+				return err
+			}
+			fingerprinted = true
+		}
+		payload = payload[4+n:]
+	}
+	if !fingerprinted {
+		return storageErrors.NewCorrupted(errors.New("value type fingerprint mismatch"))
+	}
+
+	if err := dec.Unmarshal(payload, value); err != nil {
+		return storageErrors.NewCorrupted(err)
+	}
+
+	return nil
+}
+
+// typeFingerprint identifies the shape of V, so values stored with an
+// incompatible type fail to decode while renamed types still read them.
+func typeFingerprint[V any]() uint64 {
+	return schema.Fingerprint(reflect.TypeFor[V]())
+}
+
+func keyToBytes[K ~string | ~uint64](key K) []byte {
+	v := reflect.ValueOf(key)
+	if v.Kind() == reflect.Uint64 {
+		return strconv.AppendUint(nil, v.Uint(), 10)
+	}
+
+	return []byte(v.String())
+}
+
+func bytesToKey[K ~string | ~uint64](b []byte) (K, error) {
+	var key K
+	v := reflect.ValueOf(&key).Elem()
+	if v.Kind() != reflect.Uint64 {
+		v.SetString(string(b))
+		return key, nil
+	}
+
+	n, err := strconv.ParseUint(string(b), 10, 64)
+	if err != nil {
+		return key, storageErrors.NewCorrupted(fmt.Errorf("key %q is not a uint64", b))
+	}
+	v.SetUint(n)
+
+	return key, nil
+}
+
+func prefixLimit(prefix []byte) []byte {
+	limit := bytes.Clone(prefix)
+	for i := len(limit) - 1; i >= 0; i-- {
+		if limit[i] < 0xff {
+			limit[i]++
+			return limit[:i+1]
+		}
+	}
+
+	return nil
+}
+
+func compression(name string) grocksdb.CompressionType {
+	switch name {
+	case "snappy":
+		return grocksdb.SnappyCompression
+	case "zlib":
+		return grocksdb.ZLibCompression
+	case "bz2":
+		return grocksdb.Bz2Compression
+	case "lz4":
+		return grocksdb.LZ4Compression
+	case "lz4hc":
+		return grocksdb.LZ4HCCompression
+	case "zstd":
+		return grocksdb.ZSTDCompression
+	default:
+		return grocksdb.NoCompression
+	}
+}
+
+func compactionStyle(name string) grocksdb.CompactionStyle {
+	switch name {
+	case "universal":
+		return grocksdb.UniversalCompactionStyle
+	case "fifo":
+		return grocksdb.FIFOCompactionStyle
+	default:
+		return grocksdb.LevelCompactionStyle
+	}
+}
@@ -0,0 +1,255 @@
+// SPDX-License-Identifier: MPL-2.0
+
+//go:build rocksdb
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package rocksdb
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type user struct {
+	Name string
+	Age  int
+}
+
+func newProvider[V any](t *testing.T, cfg Config) *provider[string, V] {
+	p, err := New[string, V](cfg)
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	t.Cleanup(func() {
+		require.NoError(t, p.Shutdown())
+	})
+
+	return p
+}
+
+func TestProvider_StoreAndGet(t *testing.T) {
+	p := newProvider[user](t, Config{Path: t.TempDir()})
+
+	require.NoError(t, p.Store("user:1", user{Name: "Ann", Age: 30}))
+	require.NoError(t, p.Store("user:2", user{Name: "Bob", Age: 40}))
+	require.NoError(t, p.Store("team:1", user{Name: "Ops"}))
+
+	val, err := p.Get("user:1")
+	require.NoError(t, err)
+	assert.Equal(t, user{Name: "Ann", Age: 30}, val)
+	values, err := p.GetMultiple([]string{"user:1", "user:2"})
+	require.NoError(t, err)
+	assert.Equal(t, []user{{Name: "Ann", Age: 30}, {Name: "Bob", Age: 40}}, values)
+	_, err = p.Get("missing")
+	assert.True(t, storageErrors.Is(err, storageErrors.NotFound))
+
+	require.NoError(t, p.Update("user:1", func(value user, exists bool) (user, error) {
+		assert.True(t, exists)
+		value.Age++
+		return value, nil
+	}))
+	require.NoError(t, p.Update("user:3", func(value user, exists bool) (user, error) {
+		assert.False(t, exists)
+		return user{Name: "Cid"}, nil
+	}))
+	failed := errors.New("rejected")
+	assert.ErrorIs(t, p.Update("user:3", func(value user, exists bool) (user, error) {
+		return user{Name: "Dan"}, failed
+	}), failed)
+	val, err = p.Get("user:3")
+	require.NoError(t, err)
+	assert.Equal(t, user{Name: "Cid"}, val)
+
+	var keys []string
+	require.NoError(t, p.ForEachPrefix("user:", func(key string, value user) bool {
+		keys = append(keys, key)
+		return true
+	}))
+	assert.Equal(t, []string{"user:1", "user:2", "user:3"}, keys)
+
+	removed, err := p.RemoveWhere(func(key string, value user) bool {
+		return value.Age > 35
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	removed, err = p.RemovePrefix("user:")
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	require.NoError(t, p.Remove("team:1"))
+
+	var count int
+	require.NoError(t, p.ForEachKey(func(key string) bool {
+		count++
+		return true
+	}))
+	assert.Zero(t, count)
+	require.NoError(t, p.Verify())
+}
+
+func TestProvider_References(t *testing.T) {
+	p := newProvider[string](t, Config{Path: t.TempDir(), MaxReferenceDepth: 4})
+
+	require.NoError(t, p.Store("a", "1"))
+	require.NoError(t, p.StoreWithReferences("b", "2", "latest", "current"))
+	require.NoError(t, p.StoreReference("tag", "a"))
+	require.NoError(t, p.AddReference("tag", "b"))
+	require.NoError(t, p.AddReference("tag", "b"))
+	require.NoError(t, p.AddReference("tag", "missing"))
+
+	val, err := p.GetByReference("latest")
+	require.NoError(t, err)
+	assert.Equal(t, "2", val)
+	values, err := p.GetAllByReference("tag")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "2"}, values)
+
+	var pairs int
+	require.NoError(t, p.ForEachReference(func(reference string, key string) bool {
+		pairs++
+		return true
+	}))
+	assert.Equal(t, 5, pairs)
+
+	require.NoError(t, p.RemoveReferenceTarget("tag", "a"))
+	assert.True(t, storageErrors.Is(p.RemoveReferenceTarget("tag", "a"), storageErrors.NotFound))
+	require.NoError(t, p.RemoveReference("current"))
+	_, err = p.GetByReference("current")
+	assert.True(t, storageErrors.Is(err, storageErrors.NotFound))
+
+	require.NoError(t, p.StoreReference("alias", "latest"))
+	val, err = p.GetByReference("alias")
+	require.NoError(t, err)
+	assert.Equal(t, "2", val)
+
+	require.NoError(t, p.StoreReference("loop-a", "loop-b"))
+	require.NoError(t, p.StoreReference("loop-b", "loop-a"))
+	_, err = p.GetByReference("loop-a")
+	assert.True(t, storageErrors.Is(err, storageErrors.ReferenceCycle))
+
+	previous := "a"
+	for i := range 5 {
+		reference := fmt.Sprintf("chain-%d", i)
+		require.NoError(t, p.StoreReference(reference, previous))
+		previous = reference
+	}
+	_, err = p.GetByReference(previous)
+	assert.True(t, storageErrors.Is(err, storageErrors.ReferenceTooDeep))
+
+	require.NoError(t, p.Clear())
+	_, err = p.GetByReference("latest")
+	assert.True(t, storageErrors.Is(err, storageErrors.NotFound))
+	require.NoError(t, p.Verify())
+}
+
+func TestProvider_Reopen(t *testing.T) {
+	path := t.TempDir()
+	p, err := New[string, user](Config{Path: path})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	require.NoError(t, p.Store("user", user{Name: "Ann", Age: 30}))
+	require.NoError(t, p.Shutdown())
+	require.NoError(t, p.Shutdown())
+	_, err = p.Get("user")
+	assert.True(t, storageErrors.Is(err, storageErrors.Closed))
+
+	readOnly := newProvider[user](t, Config{Path: path, ReadOnly: true})
+	val, err := readOnly.Get("user")
+	require.NoError(t, err)
+	assert.Equal(t, user{Name: "Ann", Age: 30}, val)
+	assert.True(t, storageErrors.Is(readOnly.Store("user", user{}), storageErrors.ReadOnly))
+	require.NoError(t, readOnly.Shutdown())
+
+	// A renamed type with the same fields reads the values, a type with
+	// other fields does not.
+	type person struct {
+		Name string
+		Age  int
+	}
+	renamed := newProvider[person](t, Config{Path: path})
+	same, err := renamed.Get("user")
+	require.NoError(t, err)
+	assert.Equal(t, person{Name: "Ann", Age: 30}, same)
+	require.NoError(t, renamed.Shutdown())
+
+	type account struct {
+		Name  string
+		Email string
+	}
+	other := newProvider[account](t, Config{Path: path})
+	_, err = other.Get("user")
+	assert.True(t, storageErrors.Is(err, storageErrors.Corrupted))
+	assert.True(t, storageErrors.Is(other.Verify(), storageErrors.Corrupted))
+}
+
+func TestProvider_Codec(t *testing.T) {
+	path := t.TempDir()
+	gob := newProvider[user](t, Config{Path: path})
+	require.NoError(t, gob.Store("user", user{Name: "Ann", Age: 30}))
+	require.NoError(t, gob.Shutdown())
+
+	msgpack := newProvider[user](t, Config{Path: path, Codec: "msgpack"})
+	reencoded, err := msgpack.ReencodeAll()
+	require.NoError(t, err)
+	assert.Equal(t, 1, reencoded)
+	reencoded, err = msgpack.ReencodeAll()
+	require.NoError(t, err)
+	assert.Zero(t, reencoded)
+
+	val, err := msgpack.Get("user")
+	require.NoError(t, err)
+	assert.Equal(t, user{Name: "Ann", Age: 30}, val)
+}
+
+func TestProvider_TransactionTimeout(t *testing.T) {
+	p := newProvider[int](t, Config{Path: t.TempDir(), TransactionTimeout: 10 * time.Millisecond})
+	require.NoError(t, p.Store("counter", 1))
+
+	err := p.Update("counter", func(value int, exists bool) (int, error) {
+		time.Sleep(20 * time.Millisecond)
+		return value + 1, nil
+	})
+	assert.True(t, storageErrors.Is(err, storageErrors.Timeout))
+
+	val, err := p.Get("counter")
+	require.NoError(t, err)
+	assert.Equal(t, 1, val, "a timed out update is not written")
+}
+
+func TestProvider_Backup(t *testing.T) {
+	p := newProvider[string](t, Config{Path: t.TempDir()})
+	require.NoError(t, p.Store("key", "value"))
+	require.NoError(t, p.Compact())
+
+	var buf bytes.Buffer
+	require.NoError(t, p.Backup(&buf))
+
+	var files []string
+	tr := tar.NewReader(&buf)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		files = append(files, filepath.Base(header.Name))
+	}
+	assert.Contains(t, files, "CURRENT")
+
+	stats, err := p.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, "rocksdb", stats.Provider)
+	assert.Equal(t, uint64(1), stats.Entries)
+}
@@ -0,0 +1,422 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package search
+
+import (
+	baseErrors "errors"
+	"fmt"
+	"github.com/blevesearch/bleve/v2"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/errors"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Config struct {
+	// Path is the directory of the bleve index. An empty path keeps the index
+	// in memory and rebuilds it from the provider on Setup. Delete the
+	// directory after changing Fields.
+	Path string `yaml:"path,omitempty"`
+	// Fields are the names of the string fields of V to index. Queries refer
+	// to them in lower case, e.g. title:storage for Title. They must be empty
+	// if V is a string, which is then indexed as the field value.
+	Fields []string `yaml:"fields,omitempty"`
+}
+
+type provider[K ~string | ~uint64, V any] struct {
+	storage.KeyValueProvider[K, V]
+	path   string
+	fields []field
+
+	mu    sync.RWMutex
+	index bleve.Index
+}
+
+type field struct {
+	name  string
+	index []int
+}
+
+const (
+	valueField = "value"
+	batchSize  = 1000
+)
+
+// New indexes the selected fields of every value stored through the returned
+// provider in a bleve index. The index is opened on Setup. A value is indexed
+// after inner stored it, so a crash in between leaves the index behind; call
+// Reindex to catch up.
+func New[K ~string | ~uint64, V any](inner storage.KeyValueProvider[K, V], cfg Config) (*provider[K, V], error) {
+	if inner == nil {
+		return nil, baseErrors.New("inner provider is nil")
+	}
+
+	fields, err := resolveFields[V](cfg.Fields)
+	if err != nil {
+		return nil, err
+	}
+
+	return &provider[K, V]{
+		KeyValueProvider: inner,
+		path:             cfg.Path,
+		fields:           fields,
+	}, nil
+}
+
+func (p *provider[K, V]) Setup() error {
+	if err := p.KeyValueProvider.Setup(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.path != "" {
+		index, err := bleve.Open(p.path)
+		if err == nil {
+			p.index = index
+			return nil
+		} else if !baseErrors.Is(err, bleve.ErrorIndexPathDoesNotExist) {
+			return fmt.Errorf("failed to open search index: %w", err)
+		}
+	}
+
+	if err := p.create(); err != nil {
+		return err
+	}
+	_, err := p.reindex()
+	return err
+}
+
+func (p *provider[K, V]) Shutdown() error {
+	return baseErrors.Join(p.closeIndex(), p.KeyValueProvider.Shutdown())
+}
+
+func (p *provider[K, V]) Close() error {
+	return baseErrors.Join(p.closeIndex(), p.KeyValueProvider.Close())
+}
+
+// Search returns the keys of the values matching query, best match first.
+// query uses the bleve query string syntax, e.g. `+title:storage -draft`.
+func (p *provider[K, V]) Search(query string) ([]K, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.index == nil {
+		return nil, errors.NewClosed(baseErrors.New("search index is not open"))
+	}
+	count, err := p.index.DocCount()
+	if err != nil {
+		return nil, err
+	}
+
+	request := bleve.NewSearchRequestOptions(bleve.NewQueryStringQuery(query), int(count), 0, false)
+	result, err := p.index.Search(request)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]K, 0, len(result.Hits))
+	for _, hit := range result.Hits {
+		key, err := parseKey[K](hit.ID)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// Reindex rebuilds the index from the values in the provider and returns how
+// many values were indexed.
+func (p *provider[K, V]) Reindex() (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.index == nil {
+		return 0, errors.NewClosed(baseErrors.New("search index is not open"))
+	}
+	if err := p.recreate(); err != nil {
+		return 0, err
+	}
+
+	return p.reindex()
+}
+
+func (p *provider[K, V]) Flush() error {
+	return storage.Flush(p.KeyValueProvider)
+}
+
+func (p *provider[K, V]) Store(key K, value V) error {
+	if err := p.KeyValueProvider.Store(key, value); err != nil {
+		return err
+	}
+
+	return p.indexValue(key, value)
+}
+
+func (p *provider[K, V]) Update(key K, fn func(value V, exists bool) (V, error)) error {
+	var stored V
+	err := p.KeyValueProvider.Update(key, func(value V, exists bool) (V, error) {
+		var err error
+		stored, err = fn(value, exists)
+		return stored, err
+	})
+	if err != nil {
+		return err
+	}
+
+	return p.indexValue(key, stored)
+}
+
+func (p *provider[K, V]) Remove(key K) error {
+	if err := p.KeyValueProvider.Remove(key); err != nil {
+		return err
+	}
+
+	return p.unindex(key)
+}
+
+func (p *provider[K, V]) RemovePrefix(prefix K) (int, error) {
+	var keys []K
+	err := p.KeyValueProvider.ForEachPrefix(prefix, func(key K, value V) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	removed, err := p.KeyValueProvider.RemovePrefix(prefix)
+	if err != nil {
+		return removed, err
+	}
+
+	return removed, p.unindex(keys...)
+}
+
+func (p *provider[K, V]) RemoveWhere(pred func(key K, value V) bool) (int, error) {
+	var keys []K
+	removed, err := p.KeyValueProvider.RemoveWhere(func(key K, value V) bool {
+		if pred(key, value) {
+			keys = append(keys, key)
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		return removed, err
+	}
+
+	return removed, p.unindex(keys...)
+}
+
+func (p *provider[K, V]) Clear() error {
+	if err := p.KeyValueProvider.Clear(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.index == nil {
+		return nil
+	}
+	return p.recreate()
+}
+
+func (p *provider[K, V]) indexValue(key K, value V) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.index == nil {
+		return nil
+	}
+	return p.index.Index(keyString(key), p.document(value))
+}
+
+func (p *provider[K, V]) unindex(keys ...K) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.index == nil || len(keys) == 0 {
+		return nil
+	}
+	batch := p.index.NewBatch()
+	for _, key := range keys {
+		batch.Delete(keyString(key))
+	}
+	return p.index.Batch(batch)
+}
+
+// reindex indexes every value of the provider. The caller holds p.mu.
+func (p *provider[K, V]) reindex() (int, error) {
+	indexed := 0
+	batch := p.index.NewBatch()
+	var err error
+	forErr := p.KeyValueProvider.ForEach(func(key K, value V) bool {
+		if err = batch.Index(keyString(key), p.document(value)); err != nil {
+			return false
+		}
+		indexed++
+		if batch.Size() >= batchSize {
+			if err = p.index.Batch(batch); err != nil {
+				return false
+			}
+			batch.Reset()
+		}
+		return true
+	})
+	if forErr != nil {
+		return 0, forErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := p.index.Batch(batch); err != nil {
+		return 0, err
+	}
+
+	return indexed, nil
+}
+
+// recreate replaces the index with an empty one. The caller holds p.mu.
+func (p *provider[K, V]) recreate() error {
+	if err := p.index.Close(); err != nil {
+		return err
+	}
+	p.index = nil
+	if p.path != "" {
+		if err := os.RemoveAll(p.path); err != nil {
+			return err
+		}
+	}
+
+	return p.create()
+}
+
+func (p *provider[K, V]) create() error {
+	mapping := bleve.NewIndexMapping()
+	document := bleve.NewDocumentStaticMapping()
+	if len(p.fields) == 0 {
+		document.AddFieldMappingsAt(valueField, bleve.NewTextFieldMapping())
+	}
+	for _, f := range p.fields {
+		document.AddFieldMappingsAt(f.name, bleve.NewTextFieldMapping())
+	}
+	mapping.DefaultMapping = document
+
+	var index bleve.Index
+	var err error
+	if p.path == "" {
+		index, err = bleve.NewMemOnly(mapping)
+	} else {
+		index, err = bleve.New(p.path, mapping)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create search index: %w", err)
+	}
+
+	p.index = index
+	return nil
+}
+
+func (p *provider[K, V]) closeIndex() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.index == nil {
+		return nil
+	}
+	err := p.index.Close()
+	p.index = nil
+	return err
+}
+
+func (p *provider[K, V]) document(value V) map[string]string {
+	v := reflect.ValueOf(value)
+	if len(p.fields) == 0 {
+		return map[string]string{valueField: v.String()}
+	}
+
+	document := make(map[string]string, len(p.fields))
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return document
+		}
+		v = v.Elem()
+	}
+	for _, f := range p.fields {
+		document[f.name] = v.FieldByIndex(f.index).String()
+	}
+	return document
+}
+
+func resolveFields[V any](names []string) ([]field, error) {
+	t := reflect.TypeFor[V]()
+	if t.Kind() == reflect.String {
+		if len(names) > 0 {
+			return nil, baseErrors.New("fields must be empty for string values")
+		}
+		return nil, nil
+	}
+
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%s is neither a string nor a struct", reflect.TypeFor[V]())
+	}
+	if len(names) == 0 {
+		return nil, baseErrors.New("at least one field to index is required")
+	}
+
+	fields := make([]field, 0, len(names))
+	for _, name := range names {
+		sf, ok := t.FieldByName(name)
+		if !ok || !sf.IsExported() {
+			return nil, fmt.Errorf("%s has no exported field %s", t, name)
+		}
+		if sf.Type.Kind() != reflect.String {
+			return nil, fmt.Errorf("field %s of %s is not a string", name, t)
+		}
+		fields = append(fields, field{name: strings.ToLower(name), index: sf.Index})
+	}
+
+	return fields, nil
+}
+
+func keyString[K ~string | ~uint64](key K) string {
+	v := reflect.ValueOf(key)
+	if v.Kind() == reflect.Uint64 {
+		return strconv.FormatUint(v.Uint(), 10)
+	}
+
+	return v.String()
+}
+
+func parseKey[K ~string | ~uint64](id string) (K, error) {
+	var key K
+	v := reflect.ValueOf(&key).Elem()
+	if v.Kind() != reflect.Uint64 {
+		v.SetString(id)
+		return key, nil
+	}
+
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return key, errors.NewCorrupted(fmt.Errorf("invalid key %q in search index", id))
+	}
+	v.SetUint(n)
+	return key, nil
+}
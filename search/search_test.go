@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package search
+
+import (
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/rlshukhov/storage/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+)
+
+type article struct {
+	Title string
+	Body  string
+	Views int
+}
+
+func TestProvider(t *testing.T) {
+	dir := t.TempDir()
+	open := func() *provider[string, article] {
+		inner, err := storage.GetKeyValueProviderFromConfig[string, article](storage.KeyValueConfig{
+			File: nullable.FromValue(file.Config{Path: filepath.Join(dir, "articles.json")}),
+		})
+		require.NoError(t, err)
+		p, err := New(inner, Config{Path: filepath.Join(dir, "articles.bleve"), Fields: []string{"Title", "Body"}})
+		require.NoError(t, err)
+		require.NoError(t, p.Setup())
+		return p
+	}
+
+	_, err := New[string, article](nil, Config{})
+	assert.Error(t, err)
+	p := open()
+	_, err = New[string, article](p, Config{Fields: []string{"Views"}})
+	assert.Error(t, err)
+	_, err = New[string, article](p, Config{Fields: []string{"Author"}})
+	assert.Error(t, err)
+
+	require.NoError(t, p.Store("badger", article{Title: "Badger internals", Body: "LSM trees and value logs"}))
+	require.NoError(t, p.Store("rocks", article{Title: "RocksDB tuning", Body: "Compaction and bloom filters for LSM trees"}))
+	require.NoError(t, p.Store("geo", article{Title: "Geohash", Body: "Nearby lookups with prefix scans"}))
+	require.NoError(t, p.Store("draft", article{Title: "Draft", Body: "Nothing here yet"}))
+
+	keys, err := p.Search("lsm")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"badger", "rocks"}, keys)
+	keys, err = p.Search("title:badger")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"badger"}, keys)
+
+	require.NoError(t, p.Update("geo", func(value article, exists bool) (article, error) {
+		value.Body = "Radius queries over LSM storage"
+		return value, nil
+	}))
+	require.NoError(t, p.Remove("rocks"))
+	keys, err = p.Search("lsm")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"badger", "geo"}, keys)
+
+	removed, err := p.RemoveWhere(func(key string, value article) bool {
+		return value.Title == "Draft"
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	require.NoError(t, p.Shutdown())
+
+	p = open()
+	keys, err = p.Search("nothing")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+	keys, err = p.Search("+lsm +radius")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"geo"}, keys)
+
+	require.NoError(t, p.Clear())
+	keys, err = p.Search("lsm")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+	require.NoError(t, p.Shutdown())
+}
+
+func TestProvider_Strings(t *testing.T) {
+	inner, err := storage.GetKeyValueProviderFromConfig[uint64, string](storage.KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true}),
+	})
+	require.NoError(t, err)
+	_, err = New(inner, Config{Fields: []string{"Title"}})
+	assert.Error(t, err)
+
+	p, err := New(inner, Config{})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	defer p.Shutdown()
+
+	require.NoError(t, p.Store(1, "the quick brown fox"))
+	require.NoError(t, p.Store(2, "the lazy dog"))
+	require.NoError(t, p.Store(300, "a quick dog"))
+
+	keys, err := p.Search("quick")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []uint64{1, 300}, keys)
+
+	n, err := p.Reindex()
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	keys, err = p.Search("dog")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []uint64{2, 300}, keys)
+}
@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// Seed loads the files of fsys matching glob into provider, unless provider
+// already holds an entry, so a dataset embedded with embed.FS is only loaded
+// on the first run. Files are loaded in lexical order and decoded by their
+// extension: .jsonl and .csv as written by Export, .json and .yaml as objects
+// mapping keys to values. It reports whether the provider was seeded. A seed
+// that fails halfway keeps the entries stored so far, so Clear the provider
+// before retrying.
+func Seed[K ~string | ~uint64, V any](provider KeyValueProvider[K, V], fsys fs.FS, glob string) (bool, error) {
+	names, err := fs.Glob(fsys, glob)
+	if err != nil {
+		return false, err
+	}
+	if len(names) == 0 {
+		return false, fmt.Errorf("no files match %q", glob)
+	}
+	for _, name := range names {
+		switch strings.ToLower(path.Ext(name)) {
+		case ".jsonl", ".csv", ".json", ".yaml", ".yml":
+		default:
+			return false, fmt.Errorf("seed %s: unsupported extension %q: only .jsonl, .csv, .json, .yaml and .yml are supported", name, path.Ext(name))
+		}
+	}
+
+	empty := true
+	err = provider.ForEachKey(func(K) bool {
+		empty = false
+		return false
+	})
+	if err != nil || !empty {
+		return false, err
+	}
+
+	for _, name := range names {
+		if err := seedFile(provider, fsys, name); err != nil {
+			return false, fmt.Errorf("seed %s: %w", name, err)
+		}
+	}
+
+	return true, nil
+}
+
+func seedFile[K ~string | ~uint64, V any](provider KeyValueProvider[K, V], fsys fs.FS, name string) error {
+	raw, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return err
+	}
+
+	var values map[K]V
+	switch strings.ToLower(path.Ext(name)) {
+	case ".jsonl":
+		return Import(provider, bytes.NewReader(raw), JSONL)
+	case ".csv":
+		return Import(provider, bytes.NewReader(raw), CSV)
+	case ".json":
+		err = json.Unmarshal(raw, &values)
+	default:
+		err = yaml.Unmarshal(raw, &values)
+	}
+	if err != nil {
+		return err
+	}
+
+	for key, value := range values {
+		if err := provider.Store(key, value); err != nil {
+			return fmt.Errorf("key %v: %w", key, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package sessions
+
+import (
+	"encoding/base32"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Record is a stored session. Data holds the gob-encoded session values.
+type Record struct {
+	Data      []byte    `yaml:"data" json:"data"`
+	ExpiresAt time.Time `yaml:"expires_at" json:"expires_at"`
+}
+
+const defaultKeyPrefix = "session:"
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Store is a gorilla/sessions store that keeps session values in a
+// KeyValueProvider. The cookie only carries the signed session ID.
+type Store struct {
+	Codecs    []securecookie.Codec
+	Options   *sessions.Options
+	KeyPrefix string
+
+	provider storage.KeyValueProvider[string, Record]
+	now      func() time.Time
+}
+
+// NewStore works like sessions.NewFilesystemStore: keyPairs sign and
+// optionally encrypt the session ID cookie.
+func NewStore(provider storage.KeyValueProvider[string, Record], keyPairs ...[]byte) *Store {
+	s := &Store{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:   "/",
+			MaxAge: 86400 * 30,
+		},
+		KeyPrefix: defaultKeyPrefix,
+		provider:  provider,
+		now:       time.Now,
+	}
+
+	s.MaxAge(s.Options.MaxAge)
+	return s
+}
+
+func (s *Store) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+func (s *Store) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	if err := securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...); err != nil {
+		return session, err
+	}
+
+	found, err := s.load(session)
+	if err != nil {
+		return session, err
+	}
+	if !found {
+		session.ID = ""
+	}
+	session.IsNew = !found
+
+	return session, nil
+}
+
+// Save stores the session and sets its cookie. A session with MaxAge <= 0 is
+// removed from the provider and its cookie is cleared.
+func (s *Store) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge <= 0 {
+		if session.ID != "" {
+			if err := s.provider.Remove(s.key(session.ID)); err != nil && !errors.Is(err, errors.NotFound) {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = encoding.EncodeToString(securecookie.GenerateRandomKey(32))
+	}
+	if err := s.save(session); err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+
+	return nil
+}
+
+// MaxAge sets the default lifetime of sessions and of the signed cookies.
+func (s *Store) MaxAge(age int) {
+	s.Options.MaxAge = age
+	for _, codec := range s.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(age)
+		}
+	}
+}
+
+// Cleanup removes expired sessions. Providers that expire entries on their
+// own never return expired sessions, for the others Cleanup should run
+// periodically.
+func (s *Store) Cleanup() (int, error) {
+	now := s.now()
+	return s.provider.RemoveWhere(func(key string, record Record) bool {
+		return strings.HasPrefix(key, s.KeyPrefix) && !record.ExpiresAt.After(now)
+	})
+}
+
+func (s *Store) save(session *sessions.Session) error {
+	data, err := securecookie.GobEncoder{}.Serialize(session.Values)
+	if err != nil {
+		return err
+	}
+
+	ttl := time.Duration(session.Options.MaxAge) * time.Second
+	record := Record{Data: data, ExpiresAt: s.now().Add(ttl)}
+	if expirer, ok := s.provider.(storage.Expirer[string, Record]); ok {
+		return expirer.StoreWithTTL(s.key(session.ID), record, ttl)
+	}
+
+	return s.provider.Store(s.key(session.ID), record)
+}
+
+func (s *Store) load(session *sessions.Session) (bool, error) {
+	record, err := s.provider.Get(s.key(session.ID))
+	if errors.Is(err, errors.NotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if !record.ExpiresAt.After(s.now()) {
+		if err := s.provider.Remove(s.key(session.ID)); err != nil && !errors.Is(err, errors.NotFound) {
+			return false, err
+		}
+		return false, nil
+	}
+
+	if err := (securecookie.GobEncoder{}).Deserialize(record.Data, &session.Values); err != nil {
+		return false, errors.NewCorrupted(err)
+	}
+
+	return true, nil
+}
+
+func (s *Store) key(id string) string {
+	return s.KeyPrefix + id
+}
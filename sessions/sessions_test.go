@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package sessions
+
+import (
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/rlshukhov/storage/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	configs := map[string]storage.KeyValueConfig{
+		"badger": {Badger: nullable.FromValue(badger.Config{InMemory: true})},
+		"file":   {File: nullable.FromValue(file.Config{Path: filepath.Join(t.TempDir(), "sessions.json")})},
+	}
+
+	for name, cfg := range configs {
+		t.Run(name, func(t *testing.T) {
+			provider, err := storage.GetKeyValueProviderFromConfig[string, Record](cfg)
+			require.NoError(t, err)
+			require.NoError(t, provider.Setup())
+			defer func() {
+				require.NoError(t, provider.Shutdown())
+			}()
+
+			store := NewStore(provider, []byte("0123456789abcdef0123456789abcdef"))
+			now := time.Now()
+			store.now = func() time.Time {
+				return now
+			}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			session, err := store.Get(r, "app")
+			require.NoError(t, err)
+			assert.True(t, session.IsNew)
+			session.Values["user"] = "ann"
+			require.NoError(t, session.Save(r, w))
+			cookie := w.Result().Cookies()[0]
+
+			r = httptest.NewRequest(http.MethodGet, "/", nil)
+			r.AddCookie(cookie)
+			session, err = store.Get(r, "app")
+			require.NoError(t, err)
+			assert.False(t, session.IsNew)
+			assert.Equal(t, "ann", session.Values["user"])
+
+			n, err := store.Cleanup()
+			require.NoError(t, err)
+			assert.Equal(t, 0, n)
+
+			now = now.Add(31 * 24 * time.Hour)
+			r = httptest.NewRequest(http.MethodGet, "/", nil)
+			r.AddCookie(cookie)
+			session, err = store.New(r, "app")
+			require.NoError(t, err)
+			assert.True(t, session.IsNew)
+			assert.Empty(t, session.Values)
+
+			w = httptest.NewRecorder()
+			session.Values["user"] = "bob"
+			require.NoError(t, session.Save(r, w))
+			now = now.Add(31 * 24 * time.Hour)
+			n, err = store.Cleanup()
+			require.NoError(t, err)
+			assert.Equal(t, 1, n)
+
+			require.NoError(t, session.Save(r, w))
+			session.Options.MaxAge = -1
+			require.NoError(t, session.Save(r, w))
+			_, err = provider.Get(store.key(session.ID))
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,392 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package shadow
+
+import (
+	baseErrors "errors"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Config struct {
+	QueueSize int `yaml:"queue_size,omitempty"`
+
+	OnMismatch func(mismatch Mismatch)    `yaml:"-"`
+	OnError    func(op string, err error) `yaml:"-"`
+}
+
+type Mismatch struct {
+	Op       string
+	Key      any
+	Expected any
+	Actual   any
+
+	ExpectedErr error
+	ActualErr   error
+}
+
+const (
+	defaultQueueSize = 1024
+	recheckAttempts  = 3
+	recheckDelay     = time.Millisecond
+)
+
+type provider[K ~string | ~uint64, V any] struct {
+	storage.KeyValueProvider[K, V]
+	shadow storage.KeyValueProvider[K, V]
+	cfg    Config
+
+	mu         sync.RWMutex
+	running    bool
+	queue      chan func()
+	worker     sync.WaitGroup
+	mismatches atomic.Uint64
+	dropped    atomic.Uint64
+	// disabled is set when the shadow fails to set up, the primary is then
+	// served alone.
+	disabled atomic.Bool
+}
+
+func New[K ~string | ~uint64, V any](primary storage.KeyValueProvider[K, V], shadow storage.KeyValueProvider[K, V], cfg Config) (*provider[K, V], error) {
+	if primary == nil {
+		return nil, baseErrors.New("primary provider is nil")
+	}
+	if shadow == nil {
+		return nil, baseErrors.New("shadow provider is nil")
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultQueueSize
+	}
+
+	return &provider[K, V]{
+		KeyValueProvider: primary,
+		shadow:           shadow,
+		cfg:              cfg,
+	}, nil
+}
+
+func (p *provider[K, V]) Setup() error {
+	if err := p.KeyValueProvider.Setup(); err != nil {
+		return err
+	}
+	if err := p.shadow.Setup(); err != nil {
+		p.disabled.Store(true)
+		p.report("setup", err)
+		return nil
+	}
+	p.disabled.Store(false)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.running {
+		return nil
+	}
+
+	p.running = true
+	p.queue = make(chan func(), p.cfg.QueueSize)
+	p.worker.Add(1)
+	go p.compare(p.queue)
+
+	return nil
+}
+
+func (p *provider[K, V]) Shutdown() error {
+	p.mu.Lock()
+	if p.running {
+		p.running = false
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	p.worker.Wait()
+
+	if p.disabled.Load() {
+		return p.KeyValueProvider.Shutdown()
+	}
+
+	return baseErrors.Join(p.KeyValueProvider.Shutdown(), p.shadow.Shutdown())
+}
+
+func (p *provider[K, V]) Close() error {
+	return p.Shutdown()
+}
+
+func (p *provider[K, V]) Mismatches() uint64 {
+	return p.mismatches.Load()
+}
+
+func (p *provider[K, V]) Dropped() uint64 {
+	return p.dropped.Load()
+}
+
+// Flush flushes the primary. Writes to the shadow are best effort.
+func (p *provider[K, V]) Flush() error {
+	return storage.Flush(p.KeyValueProvider)
+}
+
+func (p *provider[K, V]) Store(key K, value V) error {
+	if err := p.KeyValueProvider.Store(key, value); err != nil {
+		return err
+	}
+
+	p.write("store", func() error { return p.shadow.Store(key, value) })
+	return nil
+}
+
+func (p *provider[K, V]) Update(key K, fn func(value V, exists bool) (V, error)) error {
+	var updated V
+	err := p.KeyValueProvider.Update(key, func(value V, exists bool) (V, error) {
+		v, err := fn(value, exists)
+		updated = v
+		return v, err
+	})
+	if err != nil {
+		return err
+	}
+
+	p.write("update", func() error { return p.shadow.Store(key, updated) })
+	return nil
+}
+
+func (p *provider[K, V]) Remove(key K) error {
+	if err := p.KeyValueProvider.Remove(key); err != nil {
+		return err
+	}
+
+	p.write("remove", func() error { return ignoreNotFound(p.shadow.Remove(key)) })
+	return nil
+}
+
+func (p *provider[K, V]) RemovePrefix(prefix K) (int, error) {
+	removed, err := p.KeyValueProvider.RemovePrefix(prefix)
+	if err != nil {
+		return removed, err
+	}
+
+	p.write("remove_prefix", func() error {
+		_, err := p.shadow.RemovePrefix(prefix)
+		return err
+	})
+	return removed, nil
+}
+
+func (p *provider[K, V]) RemoveWhere(pred func(key K, value V) bool) (int, error) {
+	removed, err := p.KeyValueProvider.RemoveWhere(pred)
+	if err != nil {
+		return removed, err
+	}
+
+	p.write("remove_where", func() error {
+		_, err := p.shadow.RemoveWhere(pred)
+		return err
+	})
+	return removed, nil
+}
+
+func (p *provider[K, V]) Clear() error {
+	if err := p.KeyValueProvider.Clear(); err != nil {
+		return err
+	}
+
+	p.write("clear", func() error { return p.shadow.Clear() })
+	return nil
+}
+
+func (p *provider[K, V]) StoreReference(reference K, key K) error {
+	if err := p.KeyValueProvider.StoreReference(reference, key); err != nil {
+		return err
+	}
+
+	p.write("store_reference", func() error { return p.shadow.StoreReference(reference, key) })
+	return nil
+}
+
+func (p *provider[K, V]) AddReference(reference K, key K) error {
+	if err := p.KeyValueProvider.AddReference(reference, key); err != nil {
+		return err
+	}
+
+	p.write("add_reference", func() error { return p.shadow.AddReference(reference, key) })
+	return nil
+}
+
+func (p *provider[K, V]) RemoveReference(reference K) error {
+	if err := p.KeyValueProvider.RemoveReference(reference); err != nil {
+		return err
+	}
+
+	p.write("remove_reference", func() error { return ignoreNotFound(p.shadow.RemoveReference(reference)) })
+	return nil
+}
+
+func (p *provider[K, V]) RemoveReferenceTarget(reference K, key K) error {
+	if err := p.KeyValueProvider.RemoveReferenceTarget(reference, key); err != nil {
+		return err
+	}
+
+	p.write("remove_reference_target", func() error { return ignoreNotFound(p.shadow.RemoveReferenceTarget(reference, key)) })
+	return nil
+}
+
+func (p *provider[K, V]) Get(key K) (V, error) {
+	value, err := p.KeyValueProvider.Get(key)
+	p.enqueue(func() {
+		p.check("get", key, value, err, func() (any, error) {
+			return p.KeyValueProvider.Get(key)
+		}, func() (any, error) {
+			return p.shadow.Get(key)
+		})
+	})
+
+	return value, err
+}
+
+func (p *provider[K, V]) GetMultiple(keys []K) ([]V, error) {
+	values, err := p.KeyValueProvider.GetMultiple(keys)
+	p.enqueue(func() {
+		p.check("get_multiple", keys, values, err, func() (any, error) {
+			return p.KeyValueProvider.GetMultiple(keys)
+		}, func() (any, error) {
+			return p.shadow.GetMultiple(keys)
+		})
+	})
+
+	return values, err
+}
+
+func (p *provider[K, V]) GetByReference(reference K) (V, error) {
+	value, err := p.KeyValueProvider.GetByReference(reference)
+	p.enqueue(func() {
+		p.check("get_by_reference", reference, value, err, func() (any, error) {
+			return p.KeyValueProvider.GetByReference(reference)
+		}, func() (any, error) {
+			return p.shadow.GetByReference(reference)
+		})
+	})
+
+	return value, err
+}
+
+func (p *provider[K, V]) GetAllByReference(reference K) ([]V, error) {
+	values, err := p.KeyValueProvider.GetAllByReference(reference)
+	p.enqueue(func() {
+		p.check("get_all_by_reference", reference, values, err, func() (any, error) {
+			return p.KeyValueProvider.GetAllByReference(reference)
+		}, func() (any, error) {
+			return p.shadow.GetAllByReference(reference)
+		})
+	})
+
+	return values, err
+}
+
+// check compares a primary read with the shadow. A write that lands between
+// the primary and the shadow read looks like a mismatch, so a difference is
+// only counted when it repeats while the primary stays the same around the
+// shadow read.
+func (p *provider[K, V]) check(op string, key any, expected any, expectedErr error, primary, shadow func() (any, error)) {
+	actual, actualErr := shadow()
+	if equal(expected, expectedErr, actual, actualErr) {
+		return
+	}
+
+	var mismatch *Mismatch
+	for attempt := 0; attempt < recheckAttempts; attempt++ {
+		time.Sleep(recheckDelay)
+
+		expected, expectedErr = primary()
+		actual, actualErr = shadow()
+		current, currentErr := primary()
+		if !equal(expected, expectedErr, current, currentErr) {
+			continue
+		}
+		if equal(expected, expectedErr, actual, actualErr) {
+			return
+		}
+
+		mismatch = &Mismatch{
+			Op:          op,
+			Key:         key,
+			Expected:    expected,
+			Actual:      actual,
+			ExpectedErr: expectedErr,
+			ActualErr:   actualErr,
+		}
+	}
+	if mismatch == nil {
+		return
+	}
+
+	p.mismatches.Add(1)
+	if p.cfg.OnMismatch != nil {
+		p.cfg.OnMismatch(*mismatch)
+	}
+}
+
+func (p *provider[K, V]) write(op string, fn func() error) {
+	if p.disabled.Load() {
+		return
+	}
+
+	p.report(op, fn())
+}
+
+func (p *provider[K, V]) report(op string, err error) {
+	if err != nil && p.cfg.OnError != nil {
+		p.cfg.OnError(op, err)
+	}
+}
+
+func (p *provider[K, V]) enqueue(fn func()) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if !p.running || p.disabled.Load() {
+		return
+	}
+
+	select {
+	case p.queue <- fn:
+	default:
+		p.dropped.Add(1)
+	}
+}
+
+func (p *provider[K, V]) compare(queue <-chan func()) {
+	defer p.worker.Done()
+
+	for fn := range queue {
+		fn()
+	}
+}
+
+func equal(expected any, expectedErr error, actual any, actualErr error) bool {
+	if expectedErr != nil || actualErr != nil {
+		if errors.Is(expectedErr, errors.NotFound) && errors.Is(actualErr, errors.NotFound) {
+			return true
+		}
+
+		return expectedErr != nil && actualErr != nil && expectedErr.Error() == actualErr.Error()
+	}
+
+	return reflect.DeepEqual(expected, actual)
+}
+
+func ignoreNotFound(err error) error {
+	if errors.Is(err, errors.NotFound) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,171 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package shadow
+
+import (
+	baseErrors "errors"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func newMemory(t *testing.T) storage.KeyValueProvider[string, string] {
+	p, err := storage.GetKeyValueProviderFromConfig[string, string](storage.KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true}),
+	})
+	require.NoError(t, err)
+
+	return p
+}
+
+var errBroken = baseErrors.New("shadow is broken")
+
+// broken fails every call while down is set.
+type broken struct {
+	storage.KeyValueProvider[string, string]
+	down atomic.Bool
+}
+
+func (b *broken) Setup() error {
+	if b.down.Load() {
+		return errBroken
+	}
+
+	return b.KeyValueProvider.Setup()
+}
+
+func (b *broken) Store(key string, value string) error {
+	if b.down.Load() {
+		return errBroken
+	}
+
+	return b.KeyValueProvider.Store(key, value)
+}
+
+func (b *broken) Get(key string) (string, error) {
+	if b.down.Load() {
+		return "", errBroken
+	}
+
+	return b.KeyValueProvider.Get(key)
+}
+
+// racing runs write before its first Get, like a write that lands between
+// the primary and the shadow read.
+type racing struct {
+	storage.KeyValueProvider[string, string]
+	once  sync.Once
+	write func()
+}
+
+func (r *racing) Get(key string) (string, error) {
+	r.once.Do(r.write)
+
+	return r.KeyValueProvider.Get(key)
+}
+
+func TestProvider_Mismatch(t *testing.T) {
+	primary, secondary := newMemory(t), newMemory(t)
+	var mismatches []Mismatch
+	p, err := New(primary, secondary, Config{
+		OnMismatch: func(mismatch Mismatch) {
+			mismatches = append(mismatches, mismatch)
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+
+	require.NoError(t, p.Store("same", "1"))
+	require.NoError(t, p.Store("changed", "1"))
+	require.NoError(t, secondary.Store("changed", "2"))
+
+	val, err := p.Get("same")
+	require.NoError(t, err)
+	assert.Equal(t, "1", val)
+	val, err = p.Get("changed")
+	require.NoError(t, err)
+	assert.Equal(t, "1", val)
+	_, err = p.Get("missing")
+	assert.Error(t, err)
+	require.NoError(t, p.Shutdown())
+
+	assert.Equal(t, uint64(1), p.Mismatches())
+	require.Len(t, mismatches, 1)
+	assert.Equal(t, Mismatch{Op: "get", Key: "changed", Expected: "1", Actual: "2"}, mismatches[0])
+	assert.Zero(t, p.Dropped())
+}
+
+func TestProvider_ConcurrentWrite(t *testing.T) {
+	secondary := &racing{KeyValueProvider: newMemory(t)}
+	p, err := New(newMemory(t), secondary, Config{})
+	require.NoError(t, err)
+	secondary.write = func() {
+		assert.NoError(t, p.Store("key", "2"))
+	}
+	require.NoError(t, p.Setup())
+
+	require.NoError(t, p.Store("key", "1"))
+	val, err := p.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, "1", val)
+	require.NoError(t, p.Shutdown())
+
+	assert.Zero(t, p.Mismatches())
+}
+
+func TestProvider_ShadowFails(t *testing.T) {
+	secondary := &broken{KeyValueProvider: newMemory(t)}
+	var mu sync.Mutex
+	var ops []string
+	p, err := New(newMemory(t), secondary, Config{
+		OnError: func(op string, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			assert.ErrorIs(t, err, errBroken)
+			ops = append(ops, op)
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+
+	secondary.down.Store(true)
+	require.NoError(t, p.Store("key", "value"))
+	val, err := p.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", val)
+	require.NoError(t, p.Shutdown())
+
+	assert.Equal(t, []string{"store"}, ops)
+	assert.Equal(t, uint64(1), p.Mismatches())
+
+	secondary = &broken{KeyValueProvider: newMemory(t)}
+	secondary.down.Store(true)
+	ops = nil
+	p, err = New(newMemory(t), secondary, Config{
+		OnError: func(op string, err error) {
+			ops = append(ops, op)
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+
+	require.NoError(t, p.Store("key", "value"))
+	val, err = p.Get("key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", val)
+	require.NoError(t, p.Shutdown())
+
+	assert.Equal(t, []string{"setup"}, ops)
+	assert.Zero(t, p.Mismatches())
+}
@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+func ShutdownOnSignal(closer io.Closer, signals ...os.Signal) (stop func()) {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, signals...)
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-ch:
+			_ = closer.Close()
+			signal.Stop(ch)
+
+			process, err := os.FindProcess(os.Getpid())
+			if err == nil {
+				err = process.Signal(sig)
+			}
+			if err != nil {
+				os.Exit(1)
+			}
+		case <-done:
+			signal.Stop(ch)
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+		})
+	}
+}
@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"cmp"
+	storageErrors "github.com/rlshukhov/storage/errors"
+	"github.com/rlshukhov/storage/kv"
+	"slices"
+	"sync"
+)
+
+// Snapshotter is implemented by providers that can read a point-in-time view
+// of their data without copying it.
+type Snapshotter[K ~string | ~uint64, V any] interface {
+	OpenSnapshot() (kv.Snapshot[K, V], error)
+}
+
+// Entries returns every entry of provider in key order.
+func Entries[K ~string | ~uint64, V any](provider KeyValueProvider[K, V]) ([]kv.Entry[K, V], error) {
+	var entries []kv.Entry[K, V]
+	err := provider.ForEach(func(key K, value V) bool {
+		entries = append(entries, kv.Entry[K, V]{Key: key, Value: value})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(entries, func(a, b kv.Entry[K, V]) int {
+		return cmp.Compare(a.Key, b.Key)
+	})
+
+	return entries, nil
+}
+
+// Snapshot returns a read-only view of provider at one point in time, so a
+// report can read a stable dataset while writes continue. Badger reads from
+// a read-only transaction. Other providers copy their entries into memory,
+// which is as consistent as their ForEach. Close the snapshot when done.
+func Snapshot[K ~string | ~uint64, V any](provider KeyValueProvider[K, V]) (kv.Snapshot[K, V], error) {
+	if s, ok := provider.(Snapshotter[K, V]); ok {
+		return s.OpenSnapshot()
+	}
+
+	entries, err := Entries(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	return &memorySnapshot[K, V]{entries: entries}, nil
+}
+
+// memorySnapshot holds a copy of the entries, sorted by key.
+type memorySnapshot[K ~string | ~uint64, V any] struct {
+	mu      sync.RWMutex
+	entries []kv.Entry[K, V]
+	closed  bool
+}
+
+func (s *memorySnapshot[K, V]) Get(key K) (V, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var value V
+	if s.closed {
+		return value, storageErrors.Closed
+	}
+	i, found := slices.BinarySearchFunc(s.entries, key, func(e kv.Entry[K, V], key K) int {
+		return cmp.Compare(e.Key, key)
+	})
+	if !found {
+		return value, storageErrors.NotFound
+	}
+
+	return s.entries[i].Value, nil
+}
+
+func (s *memorySnapshot[K, V]) ForEach(fn func(key K, value V) bool) error {
+	return s.forEach(func(K) bool { return true }, fn)
+}
+
+func (s *memorySnapshot[K, V]) ForEachPrefix(prefix K, fn func(key K, value V) bool) error {
+	pr := cursorKey(prefix)
+	return s.forEach(func(key K) bool { return bytes.HasPrefix(cursorKey(key), pr) }, fn)
+}
+
+func (s *memorySnapshot[K, V]) forEach(match func(key K) bool, fn func(key K, value V) bool) error {
+	s.mu.RLock()
+	entries, closed := s.entries, s.closed
+	s.mu.RUnlock()
+	if closed {
+		return storageErrors.Closed
+	}
+
+	for _, entry := range entries {
+		if match(entry.Key) && !fn(entry.Key, entry.Value) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (s *memorySnapshot[K, V]) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries, s.closed = nil, true
+	return nil
+}
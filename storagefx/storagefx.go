@@ -0,0 +1,46 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package storagefx
+
+import (
+	"context"
+	"github.com/rlshukhov/storage"
+	"go.uber.org/fx"
+)
+
+// Module provides a KeyValueProvider[K, V] built from the KeyValueConfig in
+// the graph, for example one added with fx.Supply or ProvideConfigFromEnv.
+func Module[K ~string | ~uint64, V any]() fx.Option {
+	return fx.Module("storage", fx.Provide(ProvideKeyValueProvider[K, V]))
+}
+
+// ProvideKeyValueProvider builds the provider and registers Setup as the start
+// hook and Shutdown as the stop hook.
+func ProvideKeyValueProvider[K ~string | ~uint64, V any](lc fx.Lifecycle, cfg storage.KeyValueConfig) (storage.KeyValueProvider[K, V], error) {
+	provider, err := storage.GetKeyValueProviderFromConfig[K, V](cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			return provider.Setup()
+		},
+		OnStop: func(context.Context) error {
+			return provider.Shutdown()
+		},
+	})
+
+	return provider, nil
+}
+
+// ProvideConfigFromEnv reads the config from STORAGE_* environment variables.
+func ProvideConfigFromEnv() fx.Option {
+	return fx.Provide(storage.LoadKeyValueConfigFromEnv)
+}
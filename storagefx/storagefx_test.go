@@ -0,0 +1,44 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package storagefx
+
+import (
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/fx"
+	"go.uber.org/fx/fxtest"
+	"path/filepath"
+	"testing"
+)
+
+func TestModule(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	cfg := storage.KeyValueConfig{File: nullable.FromValue(file.Config{Path: path})}
+
+	var provider storage.KeyValueProvider[string, int]
+	app := fxtest.New(t,
+		fx.Supply(cfg),
+		Module[string, int](),
+		fx.Populate(&provider),
+	)
+	app.RequireStart()
+	require.NoError(t, provider.Store("a", 1))
+	app.RequireStop()
+
+	reopened, err := storage.GetKeyValueProviderFromConfig[string, int](cfg)
+	require.NoError(t, err)
+	require.NoError(t, reopened.Setup())
+	defer reopened.Shutdown()
+	val, err := reopened.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, 1, val)
+}
@@ -0,0 +1,35 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+// Package storagewire has provider functions in the shape google/wire
+// expects, with a cleanup function instead of lifecycle hooks.
+package storagewire
+
+import (
+	"github.com/rlshukhov/storage"
+)
+
+// ProvideKeyValueProvider builds and sets up the provider. The returned
+// cleanup function shuts it down.
+func ProvideKeyValueProvider[K ~string | ~uint64, V any](cfg storage.KeyValueConfig) (storage.KeyValueProvider[K, V], func(), error) {
+	provider, err := storage.GetKeyValueProviderFromConfig[K, V](cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := provider.Setup(); err != nil {
+		return nil, nil, err
+	}
+
+	return provider, func() {
+		_ = provider.Shutdown()
+	}, nil
+}
+
+func ProvideConfigFromEnv() (storage.KeyValueConfig, error) {
+	return storage.LoadKeyValueConfigFromEnv()
+}
@@ -0,0 +1,296 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package tenancy
+
+import (
+	"context"
+	baseErrors "errors"
+	"fmt"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/errors"
+	"io"
+	"strings"
+)
+
+// provider is the view of one tenant. The inner provider is shared by all
+// tenants, so Setup and Shutdown leave it alone.
+type provider[V any] struct {
+	tenancy *Tenancy[V]
+	tenant  string
+	prefix  string
+	account *account
+}
+
+func (p *provider[V]) Setup() error {
+	return nil
+}
+
+func (p *provider[V]) Shutdown() error {
+	return nil
+}
+
+func (p *provider[V]) Close() error {
+	return nil
+}
+
+func (p *provider[V]) Ping(ctx context.Context) error {
+	return p.tenancy.inner.Ping(ctx)
+}
+
+func (p *provider[V]) Stats() (storage.ProviderStats, error) {
+	usage, err := p.tenancy.Usage(p.tenant)
+	if err != nil {
+		return storage.ProviderStats{}, err
+	}
+
+	limits := p.tenancy.Limits(p.tenant)
+	return storage.ProviderStats{
+		Provider: "tenancy",
+		Entries:  uint64(usage.Keys),
+		Backend: map[string]any{
+			"tenant":    p.tenant,
+			"bytes":     usage.Bytes,
+			"max_keys":  limits.MaxKeys,
+			"max_bytes": limits.MaxBytes,
+		},
+	}, nil
+}
+
+func (p *provider[V]) ApproximateSize() (uint64, error) {
+	usage, err := p.tenancy.Usage(p.tenant)
+	return uint64(usage.Bytes), err
+}
+
+func (p *provider[V]) Flush() error {
+	return storage.Flush(p.tenancy.inner)
+}
+
+func (p *provider[V]) Store(key string, value V) error {
+	return p.Update(key, func(V, bool) (V, error) {
+		return value, nil
+	})
+}
+
+func (p *provider[V]) Update(key string, fn func(value V, exists bool) (V, error)) error {
+	return p.write(func(usage Usage) (Usage, error) {
+		var delta Usage
+		err := p.tenancy.inner.Update(p.key(key), func(old V, exists bool) (V, error) {
+			value, err := fn(old, exists)
+			if err != nil {
+				return old, err
+			}
+
+			size, err := p.tenancy.size(p.key(key), value)
+			if err != nil {
+				return old, err
+			}
+			delta = Usage{Keys: 1, Bytes: size}
+			if exists {
+				oldSize, err := p.tenancy.size(p.key(key), old)
+				if err != nil {
+					return old, err
+				}
+				delta = Usage{Bytes: size - oldSize}
+			}
+
+			if err := p.tenancy.check(p.tenant, usage, delta); err != nil {
+				return old, err
+			}
+			return value, nil
+		})
+
+		return delta, err
+	})
+}
+
+func (p *provider[V]) Get(key string) (V, error) {
+	return p.tenancy.inner.Get(p.key(key))
+}
+
+func (p *provider[V]) GetMultiple(keys []string) ([]V, error) {
+	return p.tenancy.inner.GetMultiple(p.keys(keys))
+}
+
+func (p *provider[V]) Remove(key string) error {
+	return p.write(func(Usage) (Usage, error) {
+		old, err := p.tenancy.inner.Get(p.key(key))
+		if errors.Is(err, errors.NotFound) {
+			return Usage{}, p.tenancy.inner.Remove(p.key(key))
+		} else if err != nil {
+			return Usage{}, err
+		}
+
+		size, err := p.tenancy.size(p.key(key), old)
+		if err != nil {
+			return Usage{}, err
+		}
+		if err := p.tenancy.inner.Remove(p.key(key)); err != nil {
+			return Usage{}, err
+		}
+
+		return Usage{Keys: -1, Bytes: -size}, nil
+	})
+}
+
+func (p *provider[V]) RemovePrefix(prefix string) (int, error) {
+	var removed int
+	err := p.recount(func() error {
+		var err error
+		removed, err = p.tenancy.inner.RemovePrefix(p.key(prefix))
+		return err
+	})
+
+	return removed, err
+}
+
+func (p *provider[V]) RemoveWhere(pred func(key string, value V) bool) (int, error) {
+	var removed int
+	err := p.recount(func() error {
+		var err error
+		removed, err = p.tenancy.inner.RemoveWhere(func(key string, value V) bool {
+			k, ok := strings.CutPrefix(key, p.prefix)
+			return ok && pred(k, value)
+		})
+		return err
+	})
+
+	return removed, err
+}
+
+func (p *provider[V]) Clear() error {
+	return p.recount(func() error {
+		var references []string
+		err := p.tenancy.inner.ForEachReference(func(reference string, _ string) bool {
+			if strings.HasPrefix(reference, p.prefix) {
+				references = append(references, reference)
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, reference := range references {
+			if err := p.tenancy.inner.RemoveReference(reference); err != nil && !errors.Is(err, errors.NotFound) {
+				return err
+			}
+		}
+
+		_, err = p.tenancy.inner.RemovePrefix(p.prefix)
+		return err
+	})
+}
+
+func (p *provider[V]) ForEach(fn func(key string, value V) bool) error {
+	return p.ForEachPrefix("", fn)
+}
+
+func (p *provider[V]) ForEachPrefix(prefix string, fn func(key string, value V) bool) error {
+	return p.tenancy.inner.ForEachPrefix(p.key(prefix), func(key string, value V) bool {
+		return fn(strings.TrimPrefix(key, p.prefix), value)
+	})
+}
+
+func (p *provider[V]) ForEachKey(fn func(key string) bool) error {
+	return p.tenancy.inner.ForEachKey(func(key string) bool {
+		k, ok := strings.CutPrefix(key, p.prefix)
+		return !ok || fn(k)
+	})
+}
+
+func (p *provider[V]) StoreReference(reference string, key string) error {
+	return p.tenancy.inner.StoreReference(p.key(reference), p.key(key))
+}
+
+func (p *provider[V]) AddReference(reference string, key string) error {
+	return p.tenancy.inner.AddReference(p.key(reference), p.key(key))
+}
+
+func (p *provider[V]) RemoveReference(reference string) error {
+	return p.tenancy.inner.RemoveReference(p.key(reference))
+}
+
+func (p *provider[V]) RemoveReferenceTarget(reference string, key string) error {
+	return p.tenancy.inner.RemoveReferenceTarget(p.key(reference), p.key(key))
+}
+
+func (p *provider[V]) GetByReference(reference string) (V, error) {
+	return p.tenancy.inner.GetByReference(p.key(reference))
+}
+
+func (p *provider[V]) GetAllByReference(reference string) ([]V, error) {
+	return p.tenancy.inner.GetAllByReference(p.key(reference))
+}
+
+func (p *provider[V]) ForEachReference(fn func(reference string, key string) bool) error {
+	return p.tenancy.inner.ForEachReference(func(reference string, key string) bool {
+		r, ok := strings.CutPrefix(reference, p.prefix)
+		if !ok {
+			return true
+		}
+
+		return fn(r, strings.TrimPrefix(key, p.prefix))
+	})
+}
+
+func (p *provider[V]) Verify() error {
+	return p.tenancy.inner.Verify()
+}
+
+func (p *provider[V]) Backup(io.Writer) error {
+	return errors.NewUnsupported(baseErrors.New("a single tenant cannot be backed up, back up the inner provider"))
+}
+
+func (p *provider[V]) key(key string) string {
+	return p.prefix + key
+}
+
+func (p *provider[V]) keys(keys []string) []string {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = p.key(key)
+	}
+
+	return prefixed
+}
+
+// write runs fn with the current usage of the tenant and adds the usage it
+// returns. Writes of one tenant are serialized, so that the quota check and
+// the write cannot interleave with another write.
+func (p *provider[V]) write(fn func(usage Usage) (Usage, error)) error {
+	p.account.mu.Lock()
+	defer p.account.mu.Unlock()
+
+	if err := p.tenancy.load(p.tenant, p.account); err != nil {
+		return err
+	}
+
+	delta, err := fn(p.account.usage)
+	if err != nil {
+		return err
+	}
+
+	p.account.usage.Keys += delta.Keys
+	p.account.usage.Bytes += delta.Bytes
+	return nil
+}
+
+// recount runs fn and counts the entries of the tenant again afterwards.
+func (p *provider[V]) recount(fn func() error) error {
+	p.account.mu.Lock()
+	defer p.account.mu.Unlock()
+
+	err := fn()
+	p.account.loaded = false
+	if loadErr := p.tenancy.load(p.tenant, p.account); loadErr != nil {
+		return baseErrors.Join(err, fmt.Errorf("recount usage of tenant %q: %w", p.tenant, loadErr))
+	}
+
+	return err
+}
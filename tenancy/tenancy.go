@@ -0,0 +1,260 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package tenancy
+
+import (
+	"context"
+	baseErrors "errors"
+	"fmt"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/codec"
+	"github.com/rlshukhov/storage/errors"
+	"strings"
+	"sync"
+)
+
+type Limits struct {
+	MaxKeys  int   `yaml:"max_keys,omitempty"`
+	MaxBytes int64 `yaml:"max_bytes,omitempty"`
+}
+
+// Config sets the key separator and the limits. Tenants without an entry in
+// Tenants get Default; zero limits are unlimited.
+type Config struct {
+	Separator string            `yaml:"separator,omitempty"`
+	Default   Limits            `yaml:"default,omitempty"`
+	Tenants   map[string]Limits `yaml:"tenants,omitempty"`
+}
+
+type Usage struct {
+	Keys  int
+	Bytes int64
+}
+
+type QuotaExceededError struct {
+	Tenant   string
+	Resource string
+	Limit    int64
+	Usage    int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("tenant %q would use %d %s, the limit is %d", e.Tenant, e.Usage, e.Resource, e.Limit)
+}
+
+func (e *QuotaExceededError) Is(target error) bool {
+	return target == errors.QuotaExceeded
+}
+
+const defaultSeparator = "/"
+
+type contextKey struct{}
+
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, contextKey{}, tenant)
+}
+
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(contextKey{}).(string)
+	return tenant, ok && tenant != ""
+}
+
+type Tenancy[V any] struct {
+	inner storage.KeyValueProvider[string, V]
+	cfg   Config
+	codec codec.Codec
+
+	mu       sync.Mutex
+	accounts map[string]*account
+}
+
+type account struct {
+	mu     sync.Mutex
+	loaded bool
+	usage  Usage
+}
+
+// New stores the keys of every tenant in inner, prefixed with the tenant ID
+// and the separator.
+func New[V any](inner storage.KeyValueProvider[string, V], cfg Config) (*Tenancy[V], error) {
+	if inner == nil {
+		return nil, baseErrors.New("inner provider is nil")
+	}
+	if cfg.Separator == "" {
+		cfg.Separator = defaultSeparator
+	}
+
+	var errs []error
+	for tenant, limits := range cfg.Tenants {
+		if limits.MaxKeys < 0 || limits.MaxBytes < 0 {
+			errs = append(errs, fmt.Errorf("tenant %q: limits must not be negative", tenant))
+		}
+	}
+	if cfg.Default.MaxKeys < 0 || cfg.Default.MaxBytes < 0 {
+		errs = append(errs, baseErrors.New("default limits must not be negative"))
+	}
+	if err := baseErrors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return &Tenancy[V]{
+		inner:    inner,
+		cfg:      cfg,
+		codec:    codec.For[V](),
+		accounts: map[string]*account{},
+	}, nil
+}
+
+func (t *Tenancy[V]) For(tenant string) (storage.KeyValueProvider[string, V], error) {
+	if tenant == "" {
+		return nil, baseErrors.New("tenant id is empty")
+	}
+	if strings.Contains(tenant, t.cfg.Separator) {
+		return nil, fmt.Errorf("tenant id %q contains the separator %q", tenant, t.cfg.Separator)
+	}
+
+	return &provider[V]{
+		tenancy: t,
+		tenant:  tenant,
+		prefix:  tenant + t.cfg.Separator,
+		account: t.account(tenant),
+	}, nil
+}
+
+func (t *Tenancy[V]) FromContext(ctx context.Context) (storage.KeyValueProvider[string, V], error) {
+	tenant, ok := TenantFromContext(ctx)
+	if !ok {
+		return nil, baseErrors.New("context has no tenant")
+	}
+
+	return t.For(tenant)
+}
+
+func (t *Tenancy[V]) Limits(tenant string) Limits {
+	if limits, ok := t.cfg.Tenants[tenant]; ok {
+		return limits
+	}
+
+	return t.cfg.Default
+}
+
+func (t *Tenancy[V]) Usage(tenant string) (Usage, error) {
+	a := t.account(tenant)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := t.load(tenant, a); err != nil {
+		return Usage{}, err
+	}
+
+	return a.usage, nil
+}
+
+// Report scans the inner provider and returns the usage of every tenant.
+func (t *Tenancy[V]) Report() (map[string]Usage, error) {
+	report := map[string]Usage{}
+	var sizeErr error
+	err := t.inner.ForEach(func(key string, value V) bool {
+		tenant, _, ok := strings.Cut(key, t.cfg.Separator)
+		if !ok {
+			return true
+		}
+
+		size, err := t.size(key, value)
+		if err != nil {
+			sizeErr = err
+			return false
+		}
+
+		u := report[tenant]
+		u.Keys++
+		u.Bytes += size
+		report[tenant] = u
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return report, sizeErr
+}
+
+func (t *Tenancy[V]) account(tenant string) *account {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	a, ok := t.accounts[tenant]
+	if !ok {
+		a = &account{}
+		t.accounts[tenant] = a
+	}
+
+	return a
+}
+
+// load counts the entries of tenant unless they are counted already. a.mu
+// must be held.
+func (t *Tenancy[V]) load(tenant string, a *account) error {
+	if a.loaded {
+		return nil
+	}
+
+	usage, err := t.count(tenant + t.cfg.Separator)
+	if err != nil {
+		return err
+	}
+
+	a.usage = usage
+	a.loaded = true
+	return nil
+}
+
+func (t *Tenancy[V]) count(prefix string) (Usage, error) {
+	var usage Usage
+	var sizeErr error
+	err := t.inner.ForEachPrefix(prefix, func(key string, value V) bool {
+		size, err := t.size(key, value)
+		if err != nil {
+			sizeErr = err
+			return false
+		}
+
+		usage.Keys++
+		usage.Bytes += size
+		return true
+	})
+	if err != nil {
+		return Usage{}, err
+	}
+
+	return usage, sizeErr
+}
+
+// size is the length of the key plus the length of the value in the codec
+// the value type would be stored with.
+func (t *Tenancy[V]) size(key string, value V) (int64, error) {
+	encoded, err := t.codec.Marshal(value)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(key) + len(encoded)), nil
+}
+
+func (t *Tenancy[V]) check(tenant string, usage Usage, delta Usage) error {
+	limits := t.Limits(tenant)
+	if delta.Keys > 0 && limits.MaxKeys > 0 && usage.Keys+delta.Keys > limits.MaxKeys {
+		return &QuotaExceededError{Tenant: tenant, Resource: "keys", Limit: int64(limits.MaxKeys), Usage: int64(usage.Keys + delta.Keys)}
+	}
+	if delta.Bytes > 0 && limits.MaxBytes > 0 && usage.Bytes+delta.Bytes > limits.MaxBytes {
+		return &QuotaExceededError{Tenant: tenant, Resource: "bytes", Limit: limits.MaxBytes, Usage: usage.Bytes + delta.Bytes}
+	}
+
+	return nil
+}
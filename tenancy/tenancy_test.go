@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package tenancy
+
+import (
+	"context"
+	baseErrors "errors"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/rlshukhov/storage/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestTenancy(t *testing.T) {
+	inner, err := storage.GetKeyValueProviderFromConfig[string, string](storage.KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{
+			InMemory: true,
+		}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, inner.Setup())
+	defer func() {
+		require.NoError(t, inner.Shutdown())
+	}()
+	require.NoError(t, inner.Store("acme/existing", "value"))
+
+	tenants, err := New(inner, Config{
+		Default: Limits{MaxKeys: 3},
+		Tenants: map[string]Limits{"small": {MaxBytes: 64}},
+	})
+	require.NoError(t, err)
+
+	acme, err := tenants.FromContext(WithTenant(context.Background(), "acme"))
+	require.NoError(t, err)
+	globex, err := tenants.For("globex")
+	require.NoError(t, err)
+
+	require.NoError(t, acme.Store("a", "acme-a"))
+	require.NoError(t, globex.Store("a", "globex-a"))
+	val, err := acme.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, "acme-a", val)
+	val, err = inner.Get("globex/a")
+	require.NoError(t, err)
+	assert.Equal(t, "globex-a", val)
+
+	require.NoError(t, acme.Store("b", "acme-b"))
+	require.NoError(t, acme.Store("b", "overwrite does not add a key"))
+	err = acme.Store("c", "acme-c")
+	assert.True(t, errors.Is(err, errors.QuotaExceeded))
+	var quotaErr *QuotaExceededError
+	require.True(t, baseErrors.As(err, &quotaErr))
+	assert.Equal(t, QuotaExceededError{Tenant: "acme", Resource: "keys", Limit: 3, Usage: 4}, *quotaErr)
+	_, err = acme.Get("c")
+	assert.True(t, errors.Is(err, errors.NotFound))
+
+	require.NoError(t, acme.Remove("existing"))
+	require.NoError(t, acme.Store("c", "acme-c"))
+
+	var keys []string
+	require.NoError(t, acme.ForEachKey(func(key string) bool {
+		keys = append(keys, key)
+		return true
+	}))
+	assert.Equal(t, []string{"a", "b", "c"}, keys)
+
+	small, err := tenants.For("small")
+	require.NoError(t, err)
+	require.NoError(t, small.Store("a", "x"))
+	err = small.Store("b", strings.Repeat("x", 64))
+	require.True(t, baseErrors.As(err, &quotaErr))
+	assert.Equal(t, "bytes", quotaErr.Resource)
+
+	usage, err := tenants.Usage("acme")
+	require.NoError(t, err)
+	assert.Equal(t, 3, usage.Keys)
+	report, err := tenants.Report()
+	require.NoError(t, err)
+	assert.Equal(t, usage, report["acme"])
+	assert.Equal(t, 1, report["globex"].Keys)
+	assert.Equal(t, 1, report["small"].Keys)
+
+	require.NoError(t, acme.StoreReference("ref", "a"))
+	require.NoError(t, acme.Clear())
+	usage, err = tenants.Usage("acme")
+	require.NoError(t, err)
+	assert.Equal(t, Usage{}, usage)
+	_, err = inner.GetByReference("acme/ref")
+	assert.True(t, errors.Is(err, errors.NotFound))
+	val, err = globex.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, "globex-a", val)
+
+	_, err = tenants.For("a/b")
+	assert.Error(t, err)
+	_, err = tenants.FromContext(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,196 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+// Package timeenc makes the time.Time values held by stored values come back
+// the same way from every provider and codec, and the same before and after
+// a restart.
+package timeenc
+
+import (
+	"fmt"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/internal/transform"
+	"reflect"
+	"slices"
+	"time"
+)
+
+type Encoding string
+
+const (
+	// RFC3339 keeps the instant and the zone offset of times, as RFC 3339
+	// with nanoseconds does. Times come back in a fixed zone of their
+	// offset, or in UTC for a zero offset; zone names are not kept.
+	RFC3339 Encoding = "rfc3339"
+	// UnixNano keeps only the instant. Times come back in UTC.
+	UnixNano Encoding = "unix_nano"
+)
+
+type Config struct {
+	Encoding Encoding `yaml:"encoding"`
+}
+
+func (c Config) Validate() error {
+	if c.Encoding != RFC3339 && c.Encoding != UnixNano {
+		return fmt.Errorf("time encoding must be %q or %q, got %q", RFC3339, UnixNano, c.Encoding)
+	}
+
+	return nil
+}
+
+// New normalizes the times in values with Normalize before they are stored
+// in inner and after they are read from it. Monotonic clock readings are
+// always dropped. Gob, JSON and YAML keep zone offsets; msgpack keeps only
+// the instant, so use UnixNano with it.
+func New[K ~string | ~uint64, V any](inner storage.KeyValueProvider[K, V], cfg Config) (*provider[K, V], error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	p := &provider[K, V]{encoding: cfg.Encoding}
+	p.Provider = &transform.Provider[K, V, V]{
+		Inner:  inner,
+		Encode: p.normalize,
+		Decode: p.normalize,
+	}
+
+	return p, nil
+}
+
+type provider[K ~string | ~uint64, V any] struct {
+	*transform.Provider[K, V, V]
+	encoding Encoding
+}
+
+func (p *provider[K, V]) normalize(_ K, value V) (V, error) {
+	return Normalize(value, p.encoding), nil
+}
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// Normalize returns value with every time.Time in it, including times in
+// exported fields of nested structs, pointers, slices, maps and interfaces,
+// converted as enc describes. Pointers, slices and maps holding times are
+// copied, so value itself is not changed.
+func Normalize[V any](value V, enc Encoding) V {
+	v := reflect.ValueOf(&value).Elem()
+	if holdsTimes(v.Type(), nil) {
+		normalize(v, enc)
+	}
+
+	return value
+}
+
+func convert(t time.Time, enc Encoding) time.Time {
+	t = t.Round(0)
+	if enc == UnixNano {
+		return t.UTC()
+	}
+
+	_, offset := t.Zone()
+	if offset == 0 {
+		return t.UTC()
+	}
+	return t.In(time.FixedZone("", offset))
+}
+
+// normalize converts the times in v, which must be settable.
+func normalize(v reflect.Value, enc Encoding) {
+	if v.Type() == timeType {
+		v.Set(reflect.ValueOf(convert(v.Interface().(time.Time), enc)))
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() || !holdsTimes(v.Type(), nil) {
+			return
+		}
+		copied := reflect.New(v.Type().Elem())
+		copied.Elem().Set(v.Elem())
+		v.Set(copied)
+		normalize(copied.Elem(), enc)
+
+	case reflect.Interface:
+		if v.IsNil() || !holdsTimes(v.Elem().Type(), nil) {
+			return
+		}
+		copied := reflect.New(v.Elem().Type()).Elem()
+		copied.Set(v.Elem())
+		normalize(copied, enc)
+		v.Set(copied)
+
+	case reflect.Slice:
+		if v.IsNil() || !holdsTimes(v.Type(), nil) {
+			return
+		}
+		copied := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(copied, v)
+		v.Set(copied)
+		for i := range copied.Len() {
+			normalize(copied.Index(i), enc)
+		}
+
+	case reflect.Array:
+		for i := range v.Len() {
+			normalize(v.Index(i), enc)
+		}
+
+	case reflect.Map:
+		if v.IsNil() || !holdsTimes(v.Type(), nil) {
+			return
+		}
+		copied := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(iter.Value())
+			normalize(elem, enc)
+			copied.SetMapIndex(iter.Key(), elem)
+		}
+		v.Set(copied)
+
+	case reflect.Struct:
+		for i := range v.NumField() {
+			if v.Type().Field(i).IsExported() {
+				normalize(v.Field(i), enc)
+			}
+		}
+	}
+}
+
+// holdsTimes reports whether values of t can hold a time.Time. Map keys are
+// not converted.
+func holdsTimes(t reflect.Type, visiting []reflect.Type) bool {
+	if t == timeType {
+		return true
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+		return holdsTimes(t.Elem(), visiting)
+	case reflect.Struct:
+	default:
+		return false
+	}
+	if slices.Contains(visiting, t) {
+		return false
+	}
+	visiting = append(visiting, t)
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if field.IsExported() && holdsTimes(field.Type, visiting) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package timeenc
+
+import (
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/rlshukhov/storage/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type Event struct {
+	At      time.Time            `json:"at" yaml:"at"`
+	Due     *time.Time           `json:"due" yaml:"due"`
+	History []time.Time          `json:"history" yaml:"history"`
+	ByStage map[string]time.Time `json:"by_stage" yaml:"by_stage"`
+	Name    string               `json:"name" yaml:"name"`
+}
+
+func newEvent() Event {
+	now := time.Now().In(time.FixedZone("CEST", 2*60*60))
+	due := now.Add(time.Hour).In(time.UTC)
+	return Event{
+		At:      now,
+		Due:     &due,
+		History: []time.Time{now.Add(-time.Minute), now.In(time.FixedZone("", -5*60*60))},
+		ByStage: map[string]time.Time{"created": now.Add(-time.Hour)},
+		Name:    "deploy",
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	event := newEvent()
+	utc := Normalize(event, UnixNano)
+	assert.Equal(t, time.UTC, utc.At.Location())
+	assert.True(t, utc.At.Equal(event.At))
+	assert.Equal(t, time.UTC, utc.History[1].Location())
+	assert.Equal(t, time.UTC, utc.ByStage["created"].Location())
+	// The value passed in is not changed.
+	assert.Equal(t, "CEST", event.History[0].Location().String())
+	assert.NotSame(t, event.Due, utc.Due)
+
+	zoned := Normalize(event, RFC3339)
+	_, offset := zoned.At.Zone()
+	assert.Equal(t, 2*60*60, offset)
+	assert.Equal(t, time.UTC, zoned.Due.Location())
+	assert.Equal(t, event.At.Format(time.RFC3339Nano), zoned.At.Format(time.RFC3339Nano))
+	assert.Equal(t, zoned, Normalize(zoned, RFC3339))
+
+	assert.Equal(t, "plain", Normalize("plain", RFC3339))
+	var boxed any = event
+	assert.Equal(t, Normalize(event, UnixNano), Normalize(boxed, UnixNano))
+
+	_, err := New[string, Event](nil, Config{Encoding: "iso"})
+	assert.Error(t, err)
+}
+
+func TestProviders(t *testing.T) {
+	dir := t.TempDir()
+	configs := map[string]storage.KeyValueConfig{
+		"badger gob": {Badger: nullable.FromValue(badger.Config{InMemory: true, Codec: "gob"})},
+		"badger msgpack": {
+			Badger: nullable.FromValue(badger.Config{InMemory: true, Codec: "msgpack"}),
+		},
+		"badger on disk": {Badger: nullable.FromValue(badger.Config{DirectoryPath: nullable.FromValue(filepath.Join(dir, "badger"))})},
+		"json file":      {File: nullable.FromValue(file.Config{Path: filepath.Join(dir, "events.json")})},
+		"yaml file":      {File: nullable.FromValue(file.Config{Path: filepath.Join(dir, "events.yaml")})},
+	}
+
+	for name, cfg := range configs {
+		for _, enc := range []Encoding{RFC3339, UnixNano} {
+			// Msgpack keeps only the instant of times.
+			if name == "badger msgpack" && enc == RFC3339 {
+				continue
+			}
+
+			t.Run(name+"/"+string(enc), func(t *testing.T) {
+				open := func() (storage.KeyValueProvider[string, Event], func()) {
+					inner, err := storage.GetKeyValueProviderFromConfig[string, Event](cfg)
+					require.NoError(t, err)
+					require.NoError(t, inner.Setup())
+					p, err := New(inner, Config{Encoding: enc})
+					require.NoError(t, err)
+					return p, func() { require.NoError(t, inner.Shutdown()) }
+				}
+
+				event := newEvent()
+				expected := Normalize(event, enc)
+
+				p, shutdown := open()
+				require.NoError(t, p.Store(string(enc), event))
+				got, err := p.Get(string(enc))
+				require.NoError(t, err)
+				assert.Equal(t, expected, got)
+				shutdown()
+
+				if cfg.Badger.HasValue() && cfg.Badger.GetValue().InMemory {
+					return
+				}
+				p, shutdown = open()
+				defer shutdown()
+				got, err = p.Get(string(enc))
+				require.NoError(t, err)
+				assert.Equal(t, expected, got)
+			})
+		}
+	}
+}
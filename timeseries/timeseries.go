@@ -0,0 +1,207 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package timeseries
+
+import (
+	baseErrors "errors"
+	"fmt"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/errors"
+	"github.com/rlshukhov/storage/kv"
+	"time"
+)
+
+type Point[V any] struct {
+	Time  time.Time `yaml:"time" json:"time"`
+	Value V         `yaml:"value" json:"value"`
+}
+
+// Series stores points under the composite key ("timeseries", name, bucket
+// start, time), both in Unix nanoseconds, so a time window is a contiguous
+// key range and a bucket shares one key prefix.
+type Series[V any] struct {
+	provider storage.KeyValueProvider[string, Point[V]]
+	name     string
+	bucket   time.Duration
+}
+
+const rangeBatch = 256
+
+func New[V any](provider storage.KeyValueProvider[string, Point[V]], name string, bucket time.Duration) (*Series[V], error) {
+	if provider == nil {
+		return nil, baseErrors.New("provider is nil")
+	}
+	if name == "" {
+		return nil, baseErrors.New("series name is empty")
+	}
+	if bucket <= 0 {
+		return nil, baseErrors.New("series bucket must be positive")
+	}
+
+	return &Series[V]{
+		provider: provider,
+		name:     name,
+		bucket:   bucket,
+	}, nil
+}
+
+// Write stores value at t. A point written at the same nanosecond replaces
+// the previous one. Times before the Unix epoch are not supported.
+func (s *Series[V]) Write(t time.Time, value V) error {
+	if t.Before(time.Unix(0, 0)) {
+		return fmt.Errorf("time %s is before the Unix epoch", t)
+	}
+
+	return s.provider.Store(s.key(t), Point[V]{Time: t, Value: value})
+}
+
+// Range calls fn for the points in [from, to) in time order until fn returns
+// false. It pages through storage.List, so Badger seeks straight to from.
+func (s *Series[V]) Range(from, to time.Time, fn func(point Point[V]) bool) error {
+	if epoch := time.Unix(0, 0); from.Before(epoch) {
+		from = epoch
+	}
+	if !from.Before(to) {
+		return nil
+	}
+
+	start, end := s.key(from), s.key(to)
+	cursor := kv.NewCursor([]byte(s.bucketKey(from).Key()))
+	for {
+		entries, next, err := storage.List(s.provider, rangeBatch, cursor)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.Key >= end {
+				return nil
+			}
+			if entry.Key < start {
+				continue
+			}
+			if !fn(entry.Value) {
+				return nil
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// Points returns the points in [from, to) in time order.
+func (s *Series[V]) Points(from, to time.Time) ([]Point[V], error) {
+	var points []Point[V]
+	err := s.Range(from, to, func(point Point[V]) bool {
+		points = append(points, point)
+		return true
+	})
+
+	return points, err
+}
+
+// Downsample compacts the buckets that end before before: the points of every
+// width-long window are replaced with one point at the window start whose
+// value is reduce of the window's points. width must divide the bucket size.
+// Windows already holding a single point at their start are left alone, so
+// running Downsample again is cheap. It returns how many points were removed.
+// A bucket is not compacted atomically: reduced points are written before
+// the originals are removed.
+func (s *Series[V]) Downsample(before time.Time, width time.Duration, reduce func(points []Point[V]) V) (int, error) {
+	if width <= 0 || s.bucket%width != 0 {
+		return 0, fmt.Errorf("width %s must be positive and divide the bucket size %s", width, s.bucket)
+	}
+
+	end := s.bucketStart(before)
+	var bucket []Point[V]
+	var current time.Time
+	removed := 0
+	flush := func() error {
+		n, err := s.compact(bucket, width, reduce)
+		removed += n
+		bucket = bucket[:0]
+		return err
+	}
+
+	var err error
+	rangeErr := s.Range(time.Unix(0, 0), end, func(point Point[V]) bool {
+		if start := s.bucketStart(point.Time); !start.Equal(current) && len(bucket) > 0 {
+			if err = flush(); err != nil {
+				return false
+			}
+		}
+		current = s.bucketStart(point.Time)
+		bucket = append(bucket, point)
+		return true
+	})
+	if rangeErr != nil {
+		return removed, rangeErr
+	}
+	if err != nil {
+		return removed, err
+	}
+	if len(bucket) > 0 {
+		err = flush()
+	}
+
+	return removed, err
+}
+
+func (s *Series[V]) compact(points []Point[V], width time.Duration, reduce func(points []Point[V]) V) (int, error) {
+	removed := 0
+	for i := 0; i < len(points); {
+		window := truncate(points[i].Time, width)
+		j := i + 1
+		for j < len(points) && truncate(points[j].Time, width).Equal(window) {
+			j++
+		}
+		group := points[i:j]
+		i = j
+
+		if len(group) == 1 && group[0].Time.Equal(window) {
+			continue
+		}
+
+		key := s.key(window)
+		if err := s.provider.Store(key, Point[V]{Time: window, Value: reduce(group)}); err != nil {
+			return removed, err
+		}
+		for _, point := range group {
+			if s.key(point.Time) == key {
+				continue
+			}
+			if err := s.provider.Remove(s.key(point.Time)); err != nil && !errors.Is(err, errors.NotFound) {
+				return removed, err
+			}
+			removed++
+		}
+	}
+
+	return removed, nil
+}
+
+func (s *Series[V]) key(t time.Time) string {
+	return s.bucketKey(t).Uint64(uint64(t.UnixNano())).Key()
+}
+
+func (s *Series[V]) bucketKey(t time.Time) storage.KeyBuilder {
+	return storage.NewKey("timeseries", s.name).Uint64(uint64(s.bucketStart(t).UnixNano()))
+}
+
+func (s *Series[V]) bucketStart(t time.Time) time.Time {
+	return truncate(t, s.bucket)
+}
+
+// truncate rounds t down to a multiple of d since the Unix epoch, unlike
+// time.Time.Truncate which counts from the zero time.
+func truncate(t time.Time, d time.Duration) time.Time {
+	n := t.UnixNano()
+	return time.Unix(0, n-n%int64(d)).In(t.Location())
+}
@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package timeseries
+
+import (
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/rlshukhov/storage/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSeries(t *testing.T) {
+	configs := map[string]storage.KeyValueConfig{
+		"badger": {Badger: nullable.FromValue(badger.Config{InMemory: true})},
+		"file":   {File: nullable.FromValue(file.Config{Path: filepath.Join(t.TempDir(), "series.json")})},
+	}
+
+	for name, cfg := range configs {
+		t.Run(name, func(t *testing.T) {
+			provider, err := storage.GetKeyValueProviderFromConfig[string, Point[int]](cfg)
+			require.NoError(t, err)
+			require.NoError(t, provider.Setup())
+			defer func() {
+				require.NoError(t, provider.Shutdown())
+			}()
+
+			series, err := New(provider, "requests", time.Hour)
+			require.NoError(t, err)
+			other, err := New(provider, "errors", time.Hour)
+			require.NoError(t, err)
+
+			start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+			for i := 0; i < 180; i++ {
+				require.NoError(t, series.Write(start.Add(time.Duration(i)*time.Minute), 1))
+				require.NoError(t, other.Write(start.Add(time.Duration(i)*time.Minute), 100))
+			}
+			assert.Error(t, series.Write(time.Unix(-1, 0), 1))
+
+			points, err := series.Points(start.Add(30*time.Minute), start.Add(90*time.Minute))
+			require.NoError(t, err)
+			require.Len(t, points, 60)
+			for i, point := range points {
+				assert.True(t, point.Time.Equal(start.Add(time.Duration(30+i)*time.Minute)))
+			}
+
+			sum := func(points []Point[int]) int {
+				total := 0
+				for _, point := range points {
+					total += point.Value
+				}
+				return total
+			}
+			_, err = series.Downsample(start.Add(2*time.Hour), 7*time.Minute, sum)
+			assert.Error(t, err)
+
+			removed, err := series.Downsample(start.Add(2*time.Hour+30*time.Minute), 10*time.Minute, sum)
+			require.NoError(t, err)
+			assert.Equal(t, 108, removed)
+			removed, err = series.Downsample(start.Add(2*time.Hour), 10*time.Minute, sum)
+			require.NoError(t, err)
+			assert.Equal(t, 0, removed)
+
+			points, err = series.Points(start, start.Add(3*time.Hour))
+			require.NoError(t, err)
+			require.Len(t, points, 72)
+			for i, point := range points[:12] {
+				assert.True(t, point.Time.Equal(start.Add(time.Duration(i)*10*time.Minute)))
+				assert.Equal(t, 10, point.Value)
+			}
+			assert.Equal(t, 180, sum(points))
+
+			points, err = other.Points(start, start.Add(3*time.Hour))
+			require.NoError(t, err)
+			assert.Len(t, points, 180)
+		})
+	}
+}
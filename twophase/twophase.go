@@ -0,0 +1,345 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+// Package twophase writes related data to several providers so that either
+// every write is applied or none is. It is best effort: a write made by
+// someone else to one of the same keys while a transaction is rolled back
+// is overwritten.
+package twophase
+
+import (
+	"encoding/json"
+	baseErrors "errors"
+	"fmt"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Record is a prepared transaction. It is stored in the log before any
+// participant is written and removed once the transaction is committed or
+// rolled back, so every record found in the log is an incomplete
+// transaction. Keys and values are encoded with encoding/json.
+type Record struct {
+	ID         string    `yaml:"id" json:"id"`
+	Ops        []Op      `yaml:"ops" json:"ops"`
+	PreparedAt time.Time `yaml:"prepared_at" json:"prepared_at"`
+}
+
+type Op struct {
+	Participant string `yaml:"participant" json:"participant"`
+	Key         string `yaml:"key" json:"key"`
+	Value       string `yaml:"value,omitempty" json:"value,omitempty"`
+	Remove      bool   `yaml:"remove,omitempty" json:"remove,omitempty"`
+	Previous    string `yaml:"previous,omitempty" json:"previous,omitempty"`
+	Existed     bool   `yaml:"existed,omitempty" json:"existed,omitempty"`
+}
+
+type Coordinator struct {
+	log storage.KeyValueProvider[string, Record]
+
+	mu           sync.RWMutex
+	participants map[string]participant
+	sequence     atomic.Uint64
+}
+
+type participant interface {
+	encode(key any, value any, remove bool) (string, string, error)
+	read(key string) (string, bool, error)
+	store(key string, value string) error
+	remove(key string) error
+}
+
+const recordPrefix = "tx/"
+
+// New returns a coordinator that keeps its prepared records in log. The log
+// must be durable and should not be one of the participants.
+func New(log storage.KeyValueProvider[string, Record]) *Coordinator {
+	return &Coordinator{
+		log:          log,
+		participants: map[string]participant{},
+	}
+}
+
+// Register adds a provider that transactions can write to under name. Every
+// participant of a prepared record must be registered before Recover.
+func Register[K ~string | ~uint64, V any](c *Coordinator, name string, provider storage.KeyValueProvider[K, V]) error {
+	if name == "" {
+		return baseErrors.New("participant name is empty")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.participants[name]; exists {
+		return fmt.Errorf("participant %q is already registered", name)
+	}
+	c.participants[name] = typedParticipant[K, V]{provider: provider}
+
+	return nil
+}
+
+func (c *Coordinator) participant(name string) (participant, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	p, exists := c.participants[name]
+	if !exists {
+		return nil, fmt.Errorf("participant %q is not registered", name)
+	}
+
+	return p, nil
+}
+
+type Tx struct {
+	c   *Coordinator
+	ops []Op
+	err error
+}
+
+// Begin starts a transaction. Nothing is written before Commit.
+func (c *Coordinator) Begin() *Tx {
+	return &Tx{c: c}
+}
+
+// Store adds a write of value to key of the named participant. A key or
+// value of the wrong type fails here and fails Commit.
+func (tx *Tx) Store(participant string, key any, value any) error {
+	return tx.add(participant, key, value, false)
+}
+
+// Remove adds a removal of key from the named participant. A missing key is
+// not an error.
+func (tx *Tx) Remove(participant string, key any) error {
+	return tx.add(participant, key, nil, true)
+}
+
+func (tx *Tx) add(name string, key any, value any, remove bool) error {
+	if tx.err != nil {
+		return tx.err
+	}
+
+	p, err := tx.c.participant(name)
+	if err != nil {
+		tx.err = err
+		return err
+	}
+	k, v, err := p.encode(key, value, remove)
+	if err != nil {
+		tx.err = fmt.Errorf("participant %q: %w", name, err)
+		return tx.err
+	}
+
+	tx.ops = append(tx.ops, Op{Participant: name, Key: k, Value: v, Remove: remove})
+	return nil
+}
+
+// Commit prepares the transaction in the log and applies its writes in the
+// order they were added. When a write fails, the writes already applied are
+// rolled back. When the rollback fails too, the record stays in the log for
+// Recover.
+func (tx *Tx) Commit() error {
+	if tx.err != nil {
+		return tx.err
+	}
+	if len(tx.ops) == 0 {
+		return nil
+	}
+
+	c := tx.c
+	record := Record{
+		ID:         fmt.Sprintf("%020d%010d", time.Now().UnixNano(), c.sequence.Add(1)%10000000000),
+		Ops:        tx.ops,
+		PreparedAt: time.Now(),
+	}
+	for i, op := range record.Ops {
+		p, err := c.participant(op.Participant)
+		if err != nil {
+			return err
+		}
+		record.Ops[i].Previous, record.Ops[i].Existed, err = p.read(op.Key)
+		if err != nil {
+			return fmt.Errorf("prepare %s: %w", op.Participant, err)
+		}
+	}
+
+	if err := c.log.Store(recordPrefix+record.ID, record); err != nil {
+		return fmt.Errorf("prepare: %w", err)
+	}
+
+	for i, op := range record.Ops {
+		if err := c.apply(op); err != nil {
+			err = fmt.Errorf("commit %s: %w", op.Participant, err)
+			if rollbackErr := c.rollback(record, i+1); rollbackErr != nil {
+				return baseErrors.Join(err, rollbackErr)
+			}
+			return err
+		}
+	}
+
+	return c.log.Remove(recordPrefix + record.ID)
+}
+
+// Recover rolls back the transactions that were prepared but not completed,
+// e.g. because the process stopped during Commit. Call it on startup, after
+// the providers are set up and the participants are registered. It returns
+// the number of transactions rolled back.
+func (c *Coordinator) Recover() (int, error) {
+	records, err := c.Pending()
+	if err != nil {
+		return 0, err
+	}
+
+	recovered := 0
+	var errs []error
+	for _, record := range records {
+		if err := c.rollback(record, len(record.Ops)); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		recovered++
+	}
+
+	return recovered, baseErrors.Join(errs...)
+}
+
+// Pending returns the prepared records in the log.
+func (c *Coordinator) Pending() ([]Record, error) {
+	var records []Record
+	err := c.log.ForEachPrefix(recordPrefix, func(key string, record Record) bool {
+		records = append(records, record)
+		return true
+	})
+
+	return records, err
+}
+
+// rollback restores the previous values of the first n ops of record, last
+// op first, and removes the record once every op is restored.
+func (c *Coordinator) rollback(record Record, n int) error {
+	var errs []error
+	for i := n - 1; i >= 0; i-- {
+		op := record.Ops[i]
+		p, err := c.participant(op.Participant)
+		if err == nil {
+			if op.Existed {
+				err = p.store(op.Key, op.Previous)
+			} else {
+				err = p.remove(op.Key)
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rollback %s %s: %w", op.Participant, op.Key, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("transaction %s: %w", record.ID, baseErrors.Join(errs...))
+	}
+
+	if err := c.log.Remove(recordPrefix + record.ID); err != nil && !errors.Is(err, errors.NotFound) {
+		return fmt.Errorf("transaction %s: %w", record.ID, err)
+	}
+
+	return nil
+}
+
+func (c *Coordinator) apply(op Op) error {
+	p, err := c.participant(op.Participant)
+	if err != nil {
+		return err
+	}
+	if op.Remove {
+		return p.remove(op.Key)
+	}
+
+	return p.store(op.Key, op.Value)
+}
+
+type typedParticipant[K ~string | ~uint64, V any] struct {
+	provider storage.KeyValueProvider[K, V]
+}
+
+func (p typedParticipant[K, V]) encode(key any, value any, remove bool) (string, string, error) {
+	k, ok := key.(K)
+	if !ok {
+		return "", "", fmt.Errorf("key is %T, expected %T", key, k)
+	}
+	rawKey, err := json.Marshal(k)
+	if err != nil {
+		return "", "", err
+	}
+	if remove {
+		return string(rawKey), "", nil
+	}
+
+	v, ok := value.(V)
+	if !ok {
+		return "", "", fmt.Errorf("value is %T, expected %T", value, v)
+	}
+	rawValue, err := json.Marshal(v)
+	if err != nil {
+		return "", "", err
+	}
+
+	return string(rawKey), string(rawValue), nil
+}
+
+func (p typedParticipant[K, V]) read(key string) (string, bool, error) {
+	k, err := decode[K](key)
+	if err != nil {
+		return "", false, err
+	}
+
+	value, err := p.provider.Get(k)
+	if errors.Is(err, errors.NotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return "", false, err
+	}
+
+	return string(raw), true, nil
+}
+
+func (p typedParticipant[K, V]) store(key string, value string) error {
+	k, err := decode[K](key)
+	if err != nil {
+		return err
+	}
+	v, err := decode[V](value)
+	if err != nil {
+		return err
+	}
+
+	return p.provider.Store(k, v)
+}
+
+func (p typedParticipant[K, V]) remove(key string) error {
+	k, err := decode[K](key)
+	if err != nil {
+		return err
+	}
+
+	if err := p.provider.Remove(k); err != nil && !errors.Is(err, errors.NotFound) {
+		return err
+	}
+
+	return nil
+}
+
+func decode[T any](raw string) (T, error) {
+	var value T
+	err := json.Unmarshal([]byte(raw), &value)
+	return value, err
+}
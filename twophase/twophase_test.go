@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package twophase
+
+import (
+	baseErrors "errors"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/rlshukhov/storage/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type user struct {
+	Name   string `json:"name"`
+	Avatar string `json:"avatar"`
+}
+
+type failingProvider struct {
+	storage.KeyValueProvider[string, []byte]
+	fail bool
+}
+
+func (p *failingProvider) Store(key string, value []byte) error {
+	if p.fail {
+		return errors.NewUnavailable(baseErrors.New("blob store is down"))
+	}
+	return p.KeyValueProvider.Store(key, value)
+}
+
+func newProvider[K ~string | ~uint64, V any](t *testing.T) storage.KeyValueProvider[K, V] {
+	p, err := storage.GetKeyValueProviderFromConfig[K, V](storage.KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	t.Cleanup(func() {
+		require.NoError(t, p.Shutdown())
+	})
+
+	return p
+}
+
+func newCoordinator(t *testing.T) (*Coordinator, storage.KeyValueProvider[uint64, user], *failingProvider) {
+	users := newProvider[uint64, user](t)
+	blobs := &failingProvider{KeyValueProvider: newProvider[string, []byte](t)}
+
+	c := New(newProvider[string, Record](t))
+	require.NoError(t, Register(c, "users", users))
+	require.NoError(t, Register[string, []byte](c, "blobs", blobs))
+	assert.Error(t, Register(c, "users", users))
+
+	return c, users, blobs
+}
+
+func TestCoordinator_Commit(t *testing.T) {
+	c, users, blobs := newCoordinator(t)
+
+	tx := c.Begin()
+	require.NoError(t, tx.Store("blobs", "avatar/1", []byte("png")))
+	require.NoError(t, tx.Store("users", uint64(1), user{Name: "alice", Avatar: "avatar/1"}))
+	require.NoError(t, tx.Commit())
+
+	u, err := users.Get(1)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", u.Name)
+	blob, err := blobs.Get("avatar/1")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("png"), blob)
+
+	pending, err := c.Pending()
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	tx = c.Begin()
+	assert.Error(t, tx.Store("users", "1", user{}))
+	assert.Error(t, tx.Commit())
+	assert.Error(t, c.Begin().Remove("unknown", "key"))
+}
+
+func TestCoordinator_RollbackOnFailure(t *testing.T) {
+	c, users, blobs := newCoordinator(t)
+	require.NoError(t, users.Store(1, user{Name: "alice", Avatar: "avatar/old"}))
+
+	blobs.fail = true
+	tx := c.Begin()
+	require.NoError(t, tx.Store("users", uint64(1), user{Name: "alice", Avatar: "avatar/new"}))
+	require.NoError(t, tx.Store("users", uint64(2), user{Name: "bob"}))
+	require.NoError(t, tx.Store("blobs", "avatar/new", []byte("png")))
+	assert.True(t, errors.Is(tx.Commit(), errors.Unavailable))
+
+	u, err := users.Get(1)
+	require.NoError(t, err)
+	assert.Equal(t, "avatar/old", u.Avatar)
+	_, err = users.Get(2)
+	assert.True(t, errors.Is(err, errors.NotFound))
+
+	pending, err := c.Pending()
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestCoordinator_Recover(t *testing.T) {
+	c, users, blobs := newCoordinator(t)
+	require.NoError(t, users.Store(1, user{Name: "alice", Avatar: "avatar/old"}))
+
+	// A process stopped after the first write of a prepared transaction.
+	require.NoError(t, c.log.Store(recordPrefix+"1", Record{
+		ID: "1",
+		Ops: []Op{
+			{Participant: "users", Key: "1", Value: `{"name":"alice","avatar":"avatar/new"}`, Previous: `{"name":"alice","avatar":"avatar/old"}`, Existed: true},
+			{Participant: "blobs", Key: `"avatar/new"`, Value: `"cG5n"`},
+		},
+		PreparedAt: time.Now(),
+	}))
+	require.NoError(t, users.Store(1, user{Name: "alice", Avatar: "avatar/new"}))
+
+	n, err := c.Recover()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	u, err := users.Get(1)
+	require.NoError(t, err)
+	assert.Equal(t, "avatar/old", u.Avatar)
+	_, err = blobs.Get("avatar/new")
+	assert.True(t, errors.Is(err, errors.NotFound))
+
+	pending, err := c.Pending()
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
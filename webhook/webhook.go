@@ -0,0 +1,345 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+// Package webhook posts the changes a provider reports through Watch to an
+// HTTP endpoint, so systems that are not written in Go can react to them.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	baseErrors "errors"
+	"fmt"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/errors"
+	"github.com/rlshukhov/storage/kv"
+	"io"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+type Config struct {
+	URL string `yaml:"url"`
+	// Secret signs every request with HMAC-SHA256. It may be a secret
+	// reference such as ${env:WEBHOOK_SECRET}.
+	Secret  string                          `yaml:"secret,omitempty"`
+	Headers map[string]string               `yaml:"headers,omitempty"`
+	TLS     nullable.Nullable[kv.TLSConfig] `yaml:"tls"`
+	Timeout time.Duration                   `yaml:"timeout,omitempty"`
+
+	// MaxAttempts is how often a change is posted before it is given up on.
+	MaxAttempts int           `yaml:"max_attempts,omitempty"`
+	Backoff     time.Duration `yaml:"backoff,omitempty"`
+	MaxBackoff  time.Duration `yaml:"max_backoff,omitempty"`
+	Buffer      int           `yaml:"buffer,omitempty"`
+
+	// DeadLetters keeps the changes that could not be delivered, so they can
+	// be sent again with Redrive.
+	DeadLetters storage.KeyValueProvider[string, DeadLetter] `yaml:"-"`
+	OnError     func(err error)                              `yaml:"-"`
+}
+
+const (
+	defaultTimeout     = 10 * time.Second
+	defaultMaxAttempts = 5
+	defaultBackoff     = time.Second
+	defaultMaxBackoff  = time.Minute
+	defaultBuffer      = 256
+
+	SignatureHeader = "X-Storage-Signature"
+	DeliveryHeader  = "X-Storage-Delivery"
+)
+
+func (c Config) Validate() error {
+	var errs []error
+	if c.URL == "" {
+		errs = append(errs, baseErrors.New("url is required"))
+	} else if u, err := url.Parse(c.URL); err != nil {
+		errs = append(errs, fmt.Errorf("url: %w", err))
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		errs = append(errs, fmt.Errorf("unsupported url scheme %q: expected http or https", u.Scheme))
+	}
+	if c.Timeout < 0 || c.Backoff < 0 || c.MaxBackoff < 0 {
+		errs = append(errs, baseErrors.New("timeout, backoff and max_backoff must not be negative"))
+	}
+	if c.MaxAttempts < 0 {
+		errs = append(errs, baseErrors.New("max_attempts must not be negative"))
+	}
+	if c.Buffer < 0 {
+		errs = append(errs, baseErrors.New("buffer must not be negative"))
+	}
+	if c.TLS.HasValue() {
+		if err := c.TLS.GetValue().Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return baseErrors.Join(errs...)
+}
+
+// Change is the JSON body of a request. Value is omitted for removals.
+type Change[K ~string | ~uint64, V any] struct {
+	ID      string    `json:"id"`
+	Key     K         `json:"key"`
+	Value   *V        `json:"value,omitempty"`
+	Removed bool      `json:"removed,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// DeadLetter is a request that was not delivered.
+type DeadLetter struct {
+	ID        string    `yaml:"id" json:"id"`
+	Body      []byte    `yaml:"body" json:"body"`
+	Attempts  int       `yaml:"attempts" json:"attempts"`
+	LastError string    `yaml:"last_error" json:"last_error"`
+	FailedAt  time.Time `yaml:"failed_at" json:"failed_at"`
+}
+
+type Bridge[K ~string | ~uint64, V any] struct {
+	watcher storage.Watcher[K, V]
+	cfg     Config
+	secret  []byte
+	client  *http.Client
+
+	sequence atomic.Uint64
+}
+
+// New returns a bridge for provider, which must implement storage.Watcher.
+func New[K ~string | ~uint64, V any](provider storage.KeyValueProvider[K, V], cfg Config) (*Bridge[K, V], error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	watcher, ok := provider.(storage.Watcher[K, V])
+	if !ok {
+		return nil, errors.NewUnsupported(fmt.Errorf("%T does not support watching", provider))
+	}
+
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.MaxAttempts == 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+	if cfg.Backoff == 0 {
+		cfg.Backoff = defaultBackoff
+	}
+	if cfg.MaxBackoff == 0 {
+		cfg.MaxBackoff = defaultMaxBackoff
+	}
+	if cfg.Buffer == 0 {
+		cfg.Buffer = defaultBuffer
+	}
+
+	b := &Bridge[K, V]{
+		watcher: watcher,
+		cfg:     cfg,
+		client:  &http.Client{Timeout: cfg.Timeout},
+	}
+	if cfg.Secret != "" {
+		secret, err := kv.ResolveSecrets(context.Background(), cfg.Secret)
+		if err != nil {
+			return nil, err
+		}
+		b.secret = []byte(secret)
+	}
+	if cfg.TLS.HasValue() {
+		tlsConfig, err := cfg.TLS.GetValue().Client()
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		b.client.Transport = transport
+	}
+
+	return b, nil
+}
+
+// Run posts every change under prefix until ctx is done. Changes are posted
+// one at a time in the order they were made; when the buffer is full, the
+// watch waits for the endpoint. Changes still buffered when ctx is done are
+// dropped.
+func (b *Bridge[K, V]) Run(ctx context.Context, prefix K) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	changes := make(chan Change[K, V], b.cfg.Buffer)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for change := range changes {
+			if err := b.send(ctx, change); err != nil && ctx.Err() == nil && b.cfg.OnError != nil {
+				b.cfg.OnError(err)
+			}
+		}
+	}()
+
+	err := b.watcher.Watch(ctx, prefix, func(key K, value V, removed bool) {
+		change := Change[K, V]{
+			ID:      b.nextID(),
+			Key:     key,
+			Removed: removed,
+			Time:    time.Now(),
+		}
+		if !removed {
+			change.Value = &value
+		}
+
+		select {
+		case changes <- change:
+		case <-ctx.Done():
+		}
+	})
+	close(changes)
+	<-done
+
+	return err
+}
+
+// Redrive posts the dead letters again, once each, and removes the ones that
+// were delivered. It returns how many were delivered.
+func (b *Bridge[K, V]) Redrive(ctx context.Context) (int, error) {
+	if b.cfg.DeadLetters == nil {
+		return 0, baseErrors.New("dead letters are not configured")
+	}
+
+	var letters []DeadLetter
+	err := b.cfg.DeadLetters.ForEach(func(id string, letter DeadLetter) bool {
+		letters = append(letters, letter)
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	var errs []error
+	for _, letter := range letters {
+		if err := b.post(ctx, letter.ID, letter.Body); err != nil {
+			errs = append(errs, fmt.Errorf("delivery %s: %w", letter.ID, err))
+			continue
+		}
+		if err := b.cfg.DeadLetters.Remove(letter.ID); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		delivered++
+	}
+
+	return delivered, baseErrors.Join(errs...)
+}
+
+func (b *Bridge[K, V]) send(ctx context.Context, change Change[K, V]) error {
+	body, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+
+	attempts := 0
+	for {
+		attempts++
+		err = b.post(ctx, change.ID, body)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		if attempts >= b.cfg.MaxAttempts || !errors.Is(err, errors.Unavailable) {
+			break
+		}
+
+		select {
+		case <-time.After(b.backoff(attempts)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	err = fmt.Errorf("delivery %s failed after %d attempts: %w", change.ID, attempts, err)
+	if b.cfg.DeadLetters == nil {
+		return err
+	}
+
+	deadErr := b.cfg.DeadLetters.Store(change.ID, DeadLetter{
+		ID:        change.ID,
+		Body:      body,
+		Attempts:  attempts,
+		LastError: err.Error(),
+		FailedAt:  time.Now(),
+	})
+	if deadErr != nil {
+		return baseErrors.Join(err, deadErr)
+	}
+
+	return nil
+}
+
+// post sends body once. Network errors, 429 and 5xx responses are returned
+// as errors.Unavailable and are retried; other responses are not.
+func (b *Bridge[K, V]) post(ctx context.Context, id string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(DeliveryHeader, id)
+	for name, value := range b.cfg.Headers {
+		req.Header.Set(name, value)
+	}
+	if b.secret != nil {
+		req.Header.Set(SignatureHeader, Sign(b.secret, body))
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return errors.NewUnavailable(err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError:
+		return errors.NewUnavailable(fmt.Errorf("webhook returned %s", resp.Status))
+	default:
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+}
+
+func (b *Bridge[K, V]) backoff(attempts int) time.Duration {
+	delay := b.cfg.Backoff
+	for i := 1; i < attempts && delay < b.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	return min(delay, b.cfg.MaxBackoff)
+}
+
+func (b *Bridge[K, V]) nextID() string {
+	return fmt.Sprintf("%020d%010d", time.Now().UnixNano(), b.sequence.Add(1)%10000000000)
+}
+
+// Sign returns the value of the signature header for body: sha256= and the
+// hex HMAC-SHA256 of body with secret.
+func Sign(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the signature of body, for receivers
+// written in Go.
+func Verify(secret []byte, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
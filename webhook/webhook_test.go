@@ -0,0 +1,168 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/rlshukhov/storage/errors"
+	"github.com/rlshukhov/storage/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type receiver struct {
+	mu      sync.Mutex
+	status  atomic.Int32
+	fail    atomic.Int32
+	changes []Change[string, int]
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	if !Verify([]byte("s3cret"), body, req.Header.Get(SignatureHeader)) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.fail.Add(-1) >= 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if status := r.status.Load(); status != 0 {
+		w.WriteHeader(int(status))
+		return
+	}
+
+	var change Change[string, int]
+	if err := json.Unmarshal(body, &change); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.mu.Lock()
+	r.changes = append(r.changes, change)
+	r.mu.Unlock()
+}
+
+func (r *receiver) received() []Change[string, int] {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Change[string, int](nil), r.changes...)
+}
+
+func newProvider(t *testing.T) storage.KeyValueProvider[string, int] {
+	p, err := storage.GetKeyValueProviderFromConfig[string, int](storage.KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	t.Cleanup(func() {
+		require.NoError(t, p.Shutdown())
+	})
+
+	return p
+}
+
+func run(t *testing.T, b *Bridge[string, int], prefix string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- b.Run(ctx, prefix)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	// Subscriptions start asynchronously.
+	time.Sleep(50 * time.Millisecond)
+}
+
+func TestBridge_Run(t *testing.T) {
+	r := &receiver{}
+	r.fail.Store(1)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	t.Setenv("WEBHOOK_SECRET", "s3cret")
+	p := newProvider(t)
+	b, err := New(p, Config{URL: server.URL, Secret: "${env:WEBHOOK_SECRET}", Backoff: 10 * time.Millisecond})
+	require.NoError(t, err)
+	run(t, b, "user:")
+
+	require.NoError(t, p.Store("user:1", 42))
+	require.NoError(t, p.Store("order:1", 7))
+	require.NoError(t, p.Remove("user:1"))
+
+	assert.Eventually(t, func() bool {
+		return len(r.received()) == 2
+	}, 2*time.Second, 10*time.Millisecond)
+	changes := r.received()
+	assert.Equal(t, "user:1", changes[0].Key)
+	require.NotNil(t, changes[0].Value)
+	assert.Equal(t, 42, *changes[0].Value)
+	assert.True(t, changes[1].Removed)
+	assert.Nil(t, changes[1].Value)
+}
+
+func TestBridge_DeadLetters(t *testing.T) {
+	r := &receiver{}
+	r.status.Store(http.StatusBadRequest)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	dead, err := file.New[string, DeadLetter](file.Config{Path: filepath.Join(t.TempDir(), "dead.json")})
+	require.NoError(t, err)
+	require.NoError(t, dead.Setup())
+	defer dead.Shutdown()
+
+	p := newProvider(t)
+	b, err := New(p, Config{URL: server.URL, Secret: "s3cret", DeadLetters: dead})
+	require.NoError(t, err)
+	run(t, b, "")
+
+	require.NoError(t, p.Store("a", 1))
+	var letter DeadLetter
+	assert.Eventually(t, func() bool {
+		return dead.ForEach(func(id string, l DeadLetter) bool {
+			letter = l
+			return false
+		}) == nil && letter.ID != ""
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, letter.Attempts)
+	assert.Contains(t, letter.LastError, "400")
+
+	r.status.Store(0)
+	n, err := b.Redrive(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Len(t, r.received(), 1)
+	_, err = dead.Get(letter.ID)
+	assert.True(t, errors.Is(err, errors.NotFound))
+}
+
+func TestBridge_Unsupported(t *testing.T) {
+	f, err := file.New[string, int](file.Config{Path: filepath.Join(t.TempDir(), "data.json")})
+	require.NoError(t, err)
+	_, err = New[string, int](f, Config{URL: "http://localhost"})
+	assert.True(t, errors.Is(err, errors.Unsupported))
+
+	assert.Error(t, Config{URL: "ftp://localhost"}.Validate())
+	assert.Error(t, Config{}.Validate())
+}
@@ -0,0 +1,315 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+// Package worm makes entries write-once: once stored, they can not be
+// changed or removed until their retention period has passed and no legal
+// hold is placed on them.
+package worm
+
+import (
+	baseErrors "errors"
+	"fmt"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	// Retention is how long an entry is locked after it was stored. Zero
+	// locks entries forever.
+	Retention time.Duration `yaml:"retention,omitempty"`
+	// Prefixes limits the lock to keys starting with one of them. Empty
+	// locks every key.
+	Prefixes []string `yaml:"prefixes,omitempty"`
+
+	// Locks records when each entry was stored and its legal hold. It must be
+	// kept as long as the entries.
+	Locks storage.KeyValueProvider[string, Lock] `yaml:"-"`
+}
+
+type Lock struct {
+	StoredAt time.Time `yaml:"stored_at" json:"stored_at"`
+	Hold     bool      `yaml:"hold,omitempty" json:"hold,omitempty"`
+}
+
+// ImmutableError is returned for writes to locked entries.
+type ImmutableError struct {
+	Key string
+	// Until is when the retention period ends; it is zero when entries are
+	// locked forever.
+	Until time.Time
+	Hold  bool
+}
+
+func (e *ImmutableError) Error() string {
+	switch {
+	case e.Hold:
+		return fmt.Sprintf("key %q is under legal hold", e.Key)
+	case e.Until.IsZero():
+		return fmt.Sprintf("key %q is immutable", e.Key)
+	default:
+		return fmt.Sprintf("key %q is immutable until %s", e.Key, e.Until.Format(time.RFC3339))
+	}
+}
+
+func (e *ImmutableError) Is(target error) bool {
+	return target == errors.Immutable
+}
+
+type provider[V any] struct {
+	storage.KeyValueProvider[string, V]
+	cfg Config
+
+	// mu serializes writes, so an entry is not locked between the check and
+	// the write.
+	mu sync.Mutex
+}
+
+// New returns inner with write-once entries. Entries that exist in inner
+// without a lock, e.g. because they were stored before, are locked from the
+// first time a write to them is attempted. References are not locked.
+func New[V any](inner storage.KeyValueProvider[string, V], cfg Config) (*provider[V], error) {
+	if inner == nil {
+		return nil, baseErrors.New("inner provider is nil")
+	}
+	if cfg.Locks == nil {
+		return nil, baseErrors.New("locks provider is nil")
+	}
+	if cfg.Retention < 0 {
+		return nil, baseErrors.New("retention must not be negative")
+	}
+
+	return &provider[V]{KeyValueProvider: inner, cfg: cfg}, nil
+}
+
+func (p *provider[V]) Flush() error {
+	return storage.Flush(p.KeyValueProvider)
+}
+
+func (p *provider[V]) Store(key string, value V) error {
+	return p.Update(key, func(V, bool) (V, error) {
+		return value, nil
+	})
+}
+
+// Update fails with an ImmutableError when key exists and is locked.
+func (p *provider[V]) Update(key string, fn func(value V, exists bool) (V, error)) error {
+	if !p.applies(key) {
+		return p.KeyValueProvider.Update(key, fn)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	err := p.KeyValueProvider.Update(key, func(value V, exists bool) (V, error) {
+		if exists {
+			if err := p.check(key); err != nil {
+				return value, err
+			}
+		}
+		return fn(value, exists)
+	})
+	if err != nil {
+		return err
+	}
+
+	return p.cfg.Locks.Store(key, Lock{StoredAt: time.Now()})
+}
+
+func (p *provider[V]) Remove(key string) error {
+	if !p.applies(key) {
+		return p.KeyValueProvider.Remove(key)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.checkExisting(key); err != nil {
+		return err
+	}
+
+	return p.remove(key)
+}
+
+// RemovePrefix removes nothing when an entry under prefix is locked.
+func (p *provider[V]) RemovePrefix(prefix string) (int, error) {
+	return p.RemoveWhere(func(key string, _ V) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+}
+
+// RemoveWhere removes nothing when an entry pred matches is locked.
+func (p *provider[V]) RemoveWhere(pred func(key string, value V) bool) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	keys, err := p.unlocked(pred)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, key := range keys {
+		err := p.remove(key)
+		if errors.Is(err, errors.NotFound) {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// Clear fails when any entry is locked.
+func (p *provider[V]) Clear() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	keys, err := p.unlocked(func(string, V) bool { return true })
+	if err != nil {
+		return err
+	}
+	if err := p.KeyValueProvider.Clear(); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if !p.applies(key) {
+			continue
+		}
+		if err := p.cfg.Locks.Remove(key); err != nil && !errors.Is(err, errors.NotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// unlocked returns the keys pred matches, or an error for every locked one.
+func (p *provider[V]) unlocked(pred func(key string, value V) bool) ([]string, error) {
+	var keys []string
+	err := p.KeyValueProvider.ForEach(func(key string, value V) bool {
+		if pred(key, value) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, key := range keys {
+		if p.applies(key) {
+			errs = append(errs, p.check(key))
+		}
+	}
+
+	return keys, baseErrors.Join(errs...)
+}
+
+// Hold places a legal hold on key: it stays locked after its retention
+// period until Release.
+func (p *provider[V]) Hold(key string) error {
+	return p.setHold(key, true)
+}
+
+func (p *provider[V]) Release(key string) error {
+	return p.setHold(key, false)
+}
+
+// Lock returns the lock of key.
+func (p *provider[V]) Lock(key string) (Lock, error) {
+	return p.cfg.Locks.Get(key)
+}
+
+func (p *provider[V]) setHold(key string, hold bool) error {
+	if !p.applies(key) {
+		return fmt.Errorf("key %q is not covered by worm prefixes", key)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := p.KeyValueProvider.Get(key); err != nil {
+		return err
+	}
+
+	return p.cfg.Locks.Update(key, func(lock Lock, exists bool) (Lock, error) {
+		if !exists {
+			lock.StoredAt = time.Now()
+		}
+		lock.Hold = hold
+		return lock, nil
+	})
+}
+
+// checkExisting checks the lock of key if key exists.
+func (p *provider[V]) checkExisting(key string) error {
+	if _, err := p.KeyValueProvider.Get(key); err != nil {
+		return err
+	}
+
+	return p.check(key)
+}
+
+// check returns an ImmutableError when the existing entry key is locked. An
+// entry without a lock is locked from now on.
+func (p *provider[V]) check(key string) error {
+	lock, err := p.cfg.Locks.Get(key)
+	if errors.Is(err, errors.NotFound) {
+		lock = Lock{StoredAt: time.Now()}
+		err = p.cfg.Locks.Store(key, lock)
+	}
+	if err != nil {
+		return err
+	}
+
+	if lock.Hold {
+		return &ImmutableError{Key: key, Hold: true}
+	}
+	if p.cfg.Retention == 0 {
+		return &ImmutableError{Key: key}
+	}
+	if until := lock.StoredAt.Add(p.cfg.Retention); time.Now().Before(until) {
+		return &ImmutableError{Key: key, Until: until}
+	}
+
+	return nil
+}
+
+func (p *provider[V]) remove(key string) error {
+	if err := p.KeyValueProvider.Remove(key); err != nil {
+		return err
+	}
+	if !p.applies(key) {
+		return nil
+	}
+
+	if err := p.cfg.Locks.Remove(key); err != nil && !errors.Is(err, errors.NotFound) {
+		return err
+	}
+	return nil
+}
+
+func (p *provider[V]) applies(key string) bool {
+	if len(p.cfg.Prefixes) == 0 {
+		return true
+	}
+
+	for _, prefix := range p.cfg.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
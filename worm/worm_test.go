@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: MPL-2.0
+
+/*
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at https://mozilla.org/MPL/2.0/.
+ */
+
+package worm
+
+import (
+	baseErrors "errors"
+	"github.com/rlshukhov/nullable"
+	"github.com/rlshukhov/storage"
+	"github.com/rlshukhov/storage/badger"
+	"github.com/rlshukhov/storage/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func newProvider[V any](t *testing.T) storage.KeyValueProvider[string, V] {
+	p, err := storage.GetKeyValueProviderFromConfig[string, V](storage.KeyValueConfig{
+		Badger: nullable.FromValue(badger.Config{InMemory: true}),
+	})
+	require.NoError(t, err)
+	require.NoError(t, p.Setup())
+	t.Cleanup(func() {
+		require.NoError(t, p.Shutdown())
+	})
+
+	return p
+}
+
+func TestProvider(t *testing.T) {
+	inner := newProvider[string](t)
+	locks := newProvider[Lock](t)
+	require.NoError(t, inner.Store("audit:legacy", "stored before worm"))
+
+	p, err := New(inner, Config{Retention: time.Hour, Prefixes: []string{"audit:"}, Locks: locks})
+	require.NoError(t, err)
+
+	require.NoError(t, p.Store("audit:1", "login"))
+	err = p.Store("audit:1", "changed")
+	assert.ErrorIs(t, err, errors.Immutable)
+	var immutable *ImmutableError
+	require.True(t, baseErrors.As(err, &immutable))
+	assert.Equal(t, "audit:1", immutable.Key)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), immutable.Until, time.Minute)
+
+	assert.ErrorIs(t, p.Remove("audit:1"), errors.Immutable)
+	assert.ErrorIs(t, p.Remove("audit:legacy"), errors.Immutable)
+	_, err = p.RemovePrefix("audit:")
+	assert.ErrorIs(t, err, errors.Immutable)
+	assert.ErrorIs(t, p.Clear(), errors.Immutable)
+	value, err := p.Get("audit:1")
+	require.NoError(t, err)
+	assert.Equal(t, "login", value)
+
+	require.NoError(t, p.Store("cache:1", "a"))
+	require.NoError(t, p.Store("cache:1", "b"))
+	require.NoError(t, p.Remove("cache:1"))
+
+	// The retention period of audit:1 has passed, but the hold keeps it.
+	require.NoError(t, locks.Store("audit:1", Lock{StoredAt: time.Now().Add(-2 * time.Hour)}))
+	require.NoError(t, p.Hold("audit:1"))
+	err = p.Remove("audit:1")
+	require.True(t, baseErrors.As(err, &immutable))
+	assert.True(t, immutable.Hold)
+
+	require.NoError(t, p.Release("audit:1"))
+	require.NoError(t, p.Remove("audit:1"))
+	_, err = locks.Get("audit:1")
+	assert.ErrorIs(t, err, errors.NotFound)
+	assert.ErrorIs(t, p.Hold("audit:1"), errors.NotFound)
+
+	forever, err := New(inner, Config{Locks: locks})
+	require.NoError(t, err)
+	require.NoError(t, forever.Store("audit:2", "a"))
+	require.NoError(t, locks.Store("audit:2", Lock{StoredAt: time.Now().Add(-24 * time.Hour)}))
+	assert.ErrorIs(t, forever.Remove("audit:2"), errors.Immutable)
+
+	_, err = New(inner, Config{})
+	assert.Error(t, err)
+}